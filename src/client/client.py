@@ -14,6 +14,7 @@ from matlib.vec import Vec
 from network import Message
 from network import MessageField as MF
 from network import MessageType as MT
+from network import PROTOCOL_VERSION
 from network import get_message_handlers
 from network import message_handler
 from renderlib.camera import PerspectiveCamera
@@ -260,7 +261,9 @@ class Client:
             MT.join,
             {
                 MF.name: name,
-                MF.entity_type: actor_type
+                MF.entity_type: actor_type,
+                MF.version: PROTOCOL_VERSION,
+                MF.token: self.context.session_token or '',
             })
         self.proxy.enqueue(msg)
 
@@ -315,6 +318,18 @@ class Client:
             now - msg.data[MF.timestamp] + (now - sent_at) / 2)
         LOG.info('Synced time with server: delta={}'.format(self.delta))
 
+    @message_handler(MT.ping)
+    def handle_ping(self, msg):
+        """Answers the server pings, used to measure the client latency.
+
+        :param msg: The ping message
+        :type msg: :class:`network.message.Message`
+        """
+        self.proxy.enqueue(Message(MT.pong, {
+            MF.id: msg.data[MF.id],
+            MF.timestamp: msg.data[MF.timestamp],
+        }))
+
     @message_handler(MT.stay)
     def handle_stay(self, msg):
         """Handles stay response from server.
@@ -327,6 +342,8 @@ class Client:
         """
         srv_id = msg.data[MF.id]
         self.context.player_id = srv_id
+        # keep the session token, to resume playing after a disconnection
+        self.context.session_token = msg.data.get(MF.token)
         self.context.players_name_map[srv_id] = msg.data[MF.id]
         LOG.info('Joined the party with name "{}" and  ID {}'.format(
             self.context.character_name, self.context.player_id))
@@ -365,13 +382,72 @@ class Client:
         srv_id = msg.data[MF.id]
         reason = msg.data[MF.reason]
         if not self.context.player_id or srv_id == self.context.player_id:
-            LOG.info('Local player disconnected')
+            LOG.info('Local player disconnected: {}'.format(reason))
             self.exit = True
         else:
             LOG.info('Player "{}" disconnected'.format(srv_id))
             name = self.context.players_name_map.pop(srv_id)
             send_event(CharacterLeave(srv_id, name, reason))
 
+    @message_handler(MT.chat)
+    def handle_chat(self, msg):
+        """Handles the chat message.
+
+        :param msg: the message to be processed
+        :type msg: :class:`message.Message`
+        """
+        srv_id = msg.data[MF.id]
+        name = self.context.players_name_map.get(srv_id, srv_id)
+        LOG.info('<{}> {}'.format(name, msg.data[MF.text]))
+
+    @message_handler(MT.build_rejected)
+    def handle_build_rejected(self, msg):
+        """Handles the build rejected message.
+
+        :param msg: the message to be processed
+        :type msg: :class:`message.Message`
+        """
+        LOG.warning('Can\'t build at ({}, {}): {}'.format(
+            msg.data[MF.x_pos], msg.data[MF.y_pos], msg.data[MF.reason]))
+
+    @message_handler(MT.move_rejected)
+    def handle_move_rejected(self, msg):
+        """Handles the move rejected message.
+
+        :param msg: the message to be processed
+        :type msg: :class:`message.Message`
+        """
+        reasons = {
+            1: 'unreachable',
+            2: 'out of bounds',
+            3: 'destination occupied',
+            4: 'too far',
+        }
+        LOG.warning('Can\'t go to ({}, {}): {}'.format(
+            msg.data[MF.x_pos], msg.data[MF.y_pos],
+            reasons.get(msg.data[MF.reason], msg.data[MF.reason])))
+
+    @message_handler(MT.entity_spawned)
+    def handle_entity_spawned(self, msg):
+        """Handles the entity spawned message.
+
+        :param msg: the message to be processed
+        :type msg: :class:`message.Message`
+        """
+        LOG.debug('Entity {} of kind {} spawned at ({}, {})'.format(
+            msg.data[MF.id], msg.data[MF.kind],
+            msg.data[MF.x_pos], msg.data[MF.y_pos]))
+
+    @message_handler(MT.entity_despawned)
+    def handle_entity_despawned(self, msg):
+        """Handles the entity despawned message.
+
+        :param msg: the message to be processed
+        :type msg: :class:`message.Message`
+        """
+        LOG.debug('Entity {} of kind {} despawned'.format(
+            msg.data[MF.id], msg.data[MF.kind]))
+
     @message_handler(MT.gamestate)
     def gamestate_handler(self, msg):
         """Handle gamestate messages
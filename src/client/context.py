@@ -50,6 +50,7 @@ class Context:
         # Local player entity information
         self.player_name = None
         self.player_id = None
+        self.session_token = None
         self.character_name = None
         self.character_type = None
         self.character_avatar = None
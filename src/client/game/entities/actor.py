@@ -42,10 +42,11 @@ class ActionType(IntEnum):
     repair = 3
     attack = 4
     drinking = 5
+    dying = 6
 
 
 def action_anim_index(action_type):
-    if action_type in {ActionType.idle, ActionType.drinking}:
+    if action_type in {ActionType.idle, ActionType.drinking, ActionType.dying}:
         return 0
     elif action_type == ActionType.move:
         return 1
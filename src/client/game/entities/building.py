@@ -25,6 +25,7 @@ class BuildingType(IntEnum):
     """Enumeration of the possible buildings"""
     barricade = 0
     mg_turret = 1
+    wall = 2
 
 
 class Building(Entity):
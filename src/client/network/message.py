@@ -6,6 +6,9 @@ import msgpack
 
 LOG = logging.getLogger(__name__)
 
+# Protocol version implemented by the client, sent to the server on join
+PROTOCOL_VERSION = 1
+
 
 @unique
 class MessageType(IntEnum):
@@ -22,6 +25,14 @@ class MessageType(IntEnum):
     repair = 9
     attack = 10
     use = 11
+    shoot = 12
+    chat = 13
+    explored = 14
+    build_rejected = 15
+    move_rejected = 16
+    entity_spawned = 17
+    entity_despawned = 18
+    throw = 19
 
 
 class MessageField(bytes, Enum):
@@ -30,24 +41,39 @@ class MessageField(bytes, Enum):
     action_type = b'ActionType'
     building_type = b'Type'
     buildings = b'Buildings'
+    channel = b'Channel'
     completed = b'Completed'
     cur_hp = b'CurHitPoints'
     entities = b'Entities'
     entity_type = b'Type'
+    heading = b'Heading'
     id = b'Id'
+    items = b'Items'
+    kind = b'Kind'
     name = b'Name'
     object_type = b'Type'
     objects = b'Objects'
     operated_by = b'OperatedBy'
     path = b'Path'
+    phase = b'Phase'
     players = b'Players'
+    projectiles = b'Projectiles'
+    protected = b'Protected'
     reason = b'Reason'
+    regenerating = b'Regenerating'
     speed = b'Speed'
+    staggered = b'Staggered'
+    text = b'Text'
+    tiles = b'Tiles'
     time = b'Time'
     timestamp = b'Tstamp'
+    token = b'Token'
     tot_hp = b'TotHitPoints'
+    version = b'Version'
     x_pos = b'Xpos'
+    x_vel = b'Xvel'
     y_pos = b'Ypos'
+    y_vel = b'Yvel'
 
 
 class Message:
@@ -14,6 +14,7 @@ const (
 	RepairId
 	AttackId
 	DrinkCoffeeId
+	DieId
 )
 
 /*
@@ -36,18 +37,54 @@ type Repair struct{}
  */
 type Attack struct {
 	TargetID uint32
+	Phase    AttackPhase
 }
 
+/*
+ * AttackPhase is the phase of an attack, reported so that the clients can
+ * animate the blows
+ */
+type AttackPhase uint8
+
+// attack phases
+const (
+	AttackWindUp   AttackPhase = 0 + iota // the blow is being prepared
+	AttackRecovery                        // the blow has landed
+)
+
 /*
  * Drink coffee action payload
  */
 type DrinkCoffee struct{}
 
+/*
+ * Die action payload, the entity has been killed and is about to be removed
+ */
+type Die struct{}
+
 /*
  * Movement action payload
  */
 type Move struct {
 	Speed float32
+	Path  []Waypoint // next waypoints, from the closest to the farthest
+}
+
+/*
+ * Queued move action payload, a destination the player goes to once done with
+ * the actions above it. The path is searched when the player sets off.
+ */
+type QueuedMove struct {
+	Xpos float32
+	Ypos float32
+}
+
+/*
+ * Waypoint is a point of a movement path
+ */
+type Waypoint struct {
+	Xpos float32
+	Ypos float32
 }
 
 /*
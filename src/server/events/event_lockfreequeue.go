@@ -2,6 +2,9 @@
 // command: "go-gencon -type Event -cont LockFreeQueue -name Queue"
 // Go Generic Containers
 // For more information see http://github.com/aurelien-rainone/go-gencon
+//
+// Edited after generation: the node pointers are loaded atomically, the
+// generated code reading them without synchronization
 
 package events
 
@@ -21,6 +24,12 @@ type Queue struct {
 	tail  *node
 }
 
+// loadNode atomically loads the node pointer at p, so that the node fields
+// written before it was published are visible
+func loadNode(p **node) *node {
+	return (*node)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(p))))
+}
+
 // NewQueue creates a new lock free queue of Event
 func NewQueue() *Queue {
 
@@ -43,10 +52,10 @@ func (q *Queue) Enqueue(v *Event) {
 	newNodeAdded := false
 
 	for !newNodeAdded {
-		oldTail = q.tail
-		oldTailNext = oldTail.next
+		oldTail = loadNode(&q.tail)
+		oldTailNext = loadNode(&oldTail.next)
 
-		if q.tail != oldTail {
+		if loadNode(&q.tail) != oldTail {
 			continue
 		}
 
@@ -73,11 +82,11 @@ func (q *Queue) Dequeue() (*Event, bool) {
 	removed := false
 
 	for !removed {
-		oldDummy = q.dummy
-		oldHead = oldDummy.next
-		oldTail := q.tail
+		oldDummy = loadNode(&q.dummy)
+		oldHead = loadNode(&oldDummy.next)
+		oldTail := loadNode(&q.tail)
 
-		if q.dummy != oldDummy {
+		if loadNode(&q.dummy) != oldDummy {
 			continue
 		}
 
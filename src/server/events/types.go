@@ -17,6 +17,10 @@ const (
 	PlayerDeathId
 	ZombieDeathId
 	BuildingDestroyId
+	PlayerShootId
+	PlayerChatId
+	ItemPickupId
+	PlayerThrowId
 )
 
 type PlayerJoin struct {
@@ -29,9 +33,10 @@ type PlayerLeave struct {
 }
 
 type PlayerMove struct {
-	Id   uint32
-	Xpos float32
-	Ypos float32
+	Id     uint32
+	Xpos   float32
+	Ypos   float32
+	Queued bool
 }
 
 type PlayerBuild struct {
@@ -49,6 +54,7 @@ type PlayerRepair struct {
 type PlayerAttack struct {
 	Id       uint32
 	EntityId uint32
+	Latency  uint32 // player round-trip time, in milliseconds
 }
 
 type PlayerOperate struct {
@@ -56,6 +62,23 @@ type PlayerOperate struct {
 	EntityId uint32
 }
 
+type PlayerShoot struct {
+	Id   uint32
+	Xpos float32
+	Ypos float32
+}
+
+type PlayerThrow struct {
+	Id   uint32
+	Xpos float32
+	Ypos float32
+}
+
+type PlayerChat struct {
+	Id   uint32
+	Text string
+}
+
 type PlayerDeath struct {
 	Id uint32
 }
@@ -67,3 +90,8 @@ type ZombieDeath struct {
 type BuildingDestroy struct {
 	Id uint32
 }
+
+type ItemPickup struct {
+	Id       uint32
+	PlayerId uint32
+}
@@ -0,0 +1,254 @@
+/*
+ * Surviveler logging package
+ * module-scoped loggers
+ */
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/*
+ * Config contains the logging settings
+ */
+type Config struct {
+	File       string // if set, logs are also written to this file
+	MaxSize    int    // maximum size of the log file in MB, before rotation
+	MaxBackups int    // number of rotated log files to keep
+	Modules    string // per-module log levels, ex: "pathfinder=debug,network=warn"
+	Format     string // format of the log lines, FormatText or FormatJSON
+}
+
+/*
+ * Formats of the log lines
+ */
+const (
+	FormatText = "text" // human readable
+	FormatJSON = "json" // one JSON object per line, for log aggregators
+)
+
+/*
+ * Standard field keys, so that the log lines of every subsystem can be
+ * correlated
+ */
+const (
+	ModuleKey = "module" // name of the logging module
+	TickKey   = "tick"   // logic tick of the game
+	EntityKey = "entity" // id of the entity concerned
+	ClientKey = "client" // id of the client concerned
+)
+
+var (
+	mutex        sync.Mutex
+	defaultLevel = log.InfoLevel            // level of modules without specific level
+	out          io.Writer                  // output of every logger
+	file         *RotatingFile              // if enabled, the log file
+	loggers      = map[string]*log.Logger{} // module loggers
+	levels       = map[string]log.Level{}   // module specific levels
+)
+
+/*
+ * Module returns the logger of a module.
+ *
+ * The logger level is the module specific level if any, or the default level.
+ * Every entry logged through it has a 'module' field.
+ */
+func Module(name string) *log.Entry {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	logger, ok := loggers[name]
+	if !ok {
+		std := log.StandardLogger()
+		logger = &log.Logger{
+			Out:       std.Out,
+			Formatter: std.Formatter,
+			Hooks:     std.Hooks,
+			Level:     moduleLevel(name),
+		}
+		if out != nil {
+			logger.Out = out
+		}
+		loggers[name] = logger
+	}
+	return logger.WithField(ModuleKey, name)
+}
+
+/*
+ * WithTick adds the logic tick field to an entry
+ */
+func WithTick(e *log.Entry, tick uint64) *log.Entry {
+	return e.WithField(TickKey, tick)
+}
+
+/*
+ * WithEntity adds the entity id field to an entry
+ */
+func WithEntity(e *log.Entry, id uint32) *log.Entry {
+	return e.WithField(EntityKey, id)
+}
+
+/*
+ * WithClient adds the client id field to an entry
+ */
+func WithClient(e *log.Entry, id uint32) *log.Entry {
+	return e.WithField(ClientKey, id)
+}
+
+func moduleLevel(name string) log.Level {
+	if lvl, ok := levels[name]; ok {
+		return lvl
+	}
+	return defaultLevel
+}
+
+/*
+ * SetLevel sets the default level, used by the standard logger and by the
+ * modules that don't have a specific level
+ */
+func SetLevel(lvl log.Level) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	defaultLevel = lvl
+	log.StandardLogger().Level = lvl
+	for name, logger := range loggers {
+		logger.Level = moduleLevel(name)
+	}
+}
+
+/*
+ * SetModuleLevel sets the level of a specific module
+ */
+func SetModuleLevel(name string, lvl log.Level) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	levels[name] = lvl
+	if logger, ok := loggers[name]; ok {
+		logger.Level = lvl
+	}
+}
+
+/*
+ * SetOutput sets the output of the standard logger and of every module logger
+ */
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	out = w
+	log.StandardLogger().Out = w
+	for _, logger := range loggers {
+		logger.Out = w
+	}
+}
+
+/*
+ * SetFormatter sets the formatter of the standard logger and of every module
+ * logger
+ */
+func SetFormatter(f log.Formatter) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	log.StandardLogger().Formatter = f
+	for _, logger := range loggers {
+		logger.Formatter = f
+	}
+}
+
+/*
+ * ParseFormat returns the formatter of a log format, the text format being
+ * the default
+ */
+func ParseFormat(format string) (log.Formatter, error) {
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return &log.TextFormatter{}, nil
+	case FormatJSON:
+		return &log.JSONFormatter{}, nil
+	}
+	return nil, fmt.Errorf("invalid log format '%s', expected '%s' or '%s'", format, FormatText, FormatJSON)
+}
+
+/*
+ * ParseLevels parses a list of module levels, in the form
+ * "module1=level1,module2=level2"
+ */
+func ParseLevels(s string) (map[string]log.Level, error) {
+	lvls := make(map[string]log.Level)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+			return nil, fmt.Errorf("invalid module level '%s', expected module=level", item)
+		}
+		lvl, err := log.ParseLevel(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid level for module '%s': %v", kv[0], err)
+		}
+		lvls[strings.TrimSpace(kv[0])] = lvl
+	}
+	return lvls, nil
+}
+
+/*
+ * Setup applies the logging configuration.
+ *
+ * Logs are written to the standard error output and, if a file is
+ * configured, to this file as well.
+ */
+func Setup(cfg Config) error {
+	lvls, err := ParseLevels(cfg.Modules)
+	if err != nil {
+		return err
+	}
+	formatter, err := ParseFormat(cfg.Format)
+	if err != nil {
+		return err
+	}
+	for name, lvl := range lvls {
+		SetModuleLevel(name, lvl)
+	}
+	SetFormatter(formatter)
+
+	if len(cfg.File) > 0 {
+		rf, err := OpenRotatingFile(cfg.File, int64(cfg.MaxSize)*1024*1024, cfg.MaxBackups)
+		if err != nil {
+			return fmt.Errorf("can't open log file: %v", err)
+		}
+		Close()
+		mutex.Lock()
+		file = rf
+		mutex.Unlock()
+		SetOutput(io.MultiWriter(os.Stderr, rf))
+	}
+	return nil
+}
+
+/*
+ * Close closes the log file, if any, logs are then only written to the
+ * standard error output.
+ */
+func Close() error {
+	mutex.Lock()
+	rf := file
+	file = nil
+	mutex.Unlock()
+
+	if rf == nil {
+		return nil
+	}
+	SetOutput(os.Stderr)
+	return rf.Close()
+}
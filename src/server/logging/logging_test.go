@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestModule_Levels(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(log.InfoLevel)
+
+	quiet, verbose := Module("test-quiet"), Module("test-verbose")
+	SetModuleLevel("test-quiet", log.WarnLevel)
+	SetModuleLevel("test-verbose", log.DebugLevel)
+
+	quiet.Info("quiet info")
+	quiet.Warn("quiet warning")
+	verbose.Debug("verbose debug")
+	Module("test-default").Debug("default debug")
+	Module("test-default").Info("default info")
+
+	out := buf.String()
+	for _, msg := range []string{"quiet warning", "verbose debug", "default info"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("log output should contain %q:\n%s", msg, out)
+		}
+	}
+	for _, msg := range []string{"quiet info", "default debug"} {
+		if strings.Contains(out, msg) {
+			t.Errorf("log output shouldn't contain %q:\n%s", msg, out)
+		}
+	}
+	if !strings.Contains(out, "module=test-verbose") {
+		t.Errorf("log entries should have a module field:\n%s", out)
+	}
+
+	// changing the default level doesn't affect modules with a specific level
+	buf.Reset()
+	SetLevel(log.DebugLevel)
+	defer SetLevel(log.InfoLevel)
+	quiet.Info("quiet info")
+	Module("test-default").Debug("default debug")
+	if out := buf.String(); strings.Contains(out, "quiet info") || !strings.Contains(out, "default debug") {
+		t.Errorf("unexpected log output after changing the default level:\n%s", out)
+	}
+}
+
+func TestSetFormatter_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	formatter, err := ParseFormat("JSON")
+	if err != nil {
+		t.Fatalf("ParseFormat() error = %v", err)
+	}
+	SetFormatter(formatter)
+	defer SetFormatter(&log.TextFormatter{})
+
+	// both the module loggers and the standard logger write JSON lines
+	WithClient(WithEntity(WithTick(Module("test-json"), 42), 7), 3).Info("module entry")
+	WithTick(log.NewEntry(log.StandardLogger()), 43).Info("standard entry")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d log lines, want 2:\n%s", len(lines), buf.String())
+	}
+	var entries [2]map[string]interface{}
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &entries[i]); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", line, err)
+		}
+	}
+	want := map[string]interface{}{
+		"msg": "module entry", "level": "info",
+		ModuleKey: "test-json", TickKey: 42.0, EntityKey: 7.0, ClientKey: 3.0,
+	}
+	for k, v := range want {
+		if entries[0][k] != v {
+			t.Errorf("field %q = %v, want %v", k, entries[0][k], v)
+		}
+	}
+	if entries[1]["msg"] != "standard entry" || entries[1][TickKey] != 43.0 {
+		t.Errorf("standard logger entry = %v", entries[1])
+	}
+
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("ParseFormat(\"xml\") should fail")
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	lvls, err := ParseLevels(" pathfinder=debug, network=WARN,")
+	if err != nil {
+		t.Fatalf("ParseLevels() error = %v", err)
+	}
+	if len(lvls) != 2 || lvls["pathfinder"] != log.DebugLevel || lvls["network"] != log.WarnLevel {
+		t.Errorf("ParseLevels() = %v", lvls)
+	}
+	for _, s := range []string{"pathfinder", "=debug", "network=loud"} {
+		if _, err := ParseLevels(s); err == nil {
+			t.Errorf("ParseLevels(%q) should fail", s)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surviveler-logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "server.log")
+
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	rf.Close()
+
+	// each line exceeds the remaining size, only 2 backups are kept
+	for file, want := range map[string]string{
+		path:        "line 4\n",
+		path + ".1": "line 3\n",
+		path + ".2": "line 2\n",
+	} {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("ReadFile(%v) error = %v", file, err)
+		} else if string(buf) != want {
+			t.Errorf("%v content = %q, want %q", file, buf, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%v.3 shouldn't exist", path)
+	}
+}
+
+func TestRotatingFile_RotationFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surviveler-logging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "server.log")
+
+	// a directory stands in the way of the backup
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer rf.Close()
+
+	// the rotation fails, the lines are written to the file all the same
+	lines := []string{"line 1\n", "line 2\n", "line 3\n"}
+	for i, line := range lines {
+		n, err := rf.Write([]byte(line))
+		if n != len(line) {
+			t.Fatalf("Write(%q) = %d, want %d", line, n, len(line))
+		}
+		if rotated := i > 0; rotated != (err != nil) {
+			t.Errorf("Write(%q) error = %v, want a rotation error = %v", line, err, rotated)
+		}
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%v) error = %v", path, err)
+	}
+	if want := strings.Join(lines, ""); string(buf) != want {
+		t.Errorf("%v content = %q, want %q", path, buf, want)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Surviveler logging package
+ * rotating log file
+ */
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+/*
+ * RotatingFile is a file writer that rotates the file when it reaches a
+ * maximum size.
+ *
+ * On rotation, the current file is renamed with a '.1' suffix, the previous
+ * backups being shifted ('.1' becomes '.2' and so on), and the oldest backup
+ * removed.
+ */
+type RotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64 // maximum size in bytes, 0 disables rotation
+	maxBackups int   // number of backups to keep
+	f          *os.File
+	size       int64 // current file size
+}
+
+/*
+ * OpenRotatingFile opens, or creates, a rotating file at path
+ */
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+/*
+ * Write writes p into the file, rotating it first if needed.
+ *
+ * If the rotation fails, p is still written, the file keeping growing until
+ * a later rotation succeeds, and the rotation error is returned.
+ */
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if rotateErr = rf.rotate(); rf.f == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+/*
+ * backup returns the path of the backup file with given index
+ */
+func (rf *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", rf.path, i)
+}
+
+/*
+ * rotate closes the file, shifts the backups and opens a new file. If the
+ * file can't be moved away, it's reopened to keep on writing to it, only a
+ * failure to reopen it leaves rf.f nil.
+ */
+func (rf *RotatingFile) rotate() error {
+	err := rf.f.Close()
+	rf.f = nil
+	if err == nil {
+		err = rf.shift()
+	}
+	if oerr := rf.open(); oerr != nil {
+		return oerr
+	}
+	return err
+}
+
+/*
+ * shift moves the closed file to the first backup, shifting the previous
+ * backups, or removes it if no backup is kept
+ */
+func (rf *RotatingFile) shift() error {
+	if rf.maxBackups <= 0 {
+		return os.Remove(rf.path)
+	}
+	os.Remove(rf.backup(rf.maxBackups))
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		os.Rename(rf.backup(i), rf.backup(i+1))
+	}
+	return os.Rename(rf.path, rf.backup(1))
+}
+
+/*
+ * Close closes the file
+ */
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
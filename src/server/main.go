@@ -5,14 +5,18 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"server/surviveler"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/go-ini/ini"
 	"github.com/urfave/cli"
 )
 
+// prefix of the environment variables overriding the configuration
+const envVarPrefix = "SURVIVELER_"
+
 /*
  * runCliApp reads configuration and run the server as a command line app
 
@@ -21,61 +25,35 @@ import (
  *
  * By order of precedence, this is how the configuration fields are merged:
  * - command line flags
- * - ini file values (if any)
+ * - environment variables, named after the flags (ex: SURVIVELER_LOG_LEVEL)
+ * - config file values (if any), from an ini, YAML or TOML file
  * - default values
  *
  * `-h` or `--help` flags is a special case, directly handled by the cli library,
  * it shows the app usage and exits
  */
 func runCliApp() error {
-	// get configuration, pre-filled with default values
-	cfg := surviveler.NewConfig()
-
 	// command line interface setup
 	app := cli.NewApp()
 	app.Name = "server"
 	app.Usage = "Surviveler server"
-	app.Flags = configFlags()
+	app.Flags = withEnvVars(configFlags())
 	app.Action = func(c *cli.Context) error {
-		// read config file
-		if inifile := c.String("inifile"); inifile != "" {
-			if err := ini.MapToWithMapper(&cfg, ini.AllCapsUnderscore, inifile); err != nil {
-				log.WithField("inifile", inifile).WithError(err).Error("Couldn't read config file")
-				os.Exit(1)
-			}
+		cfg, err := parseConfig(c)
+		if err != nil {
+			log.WithError(err).Error("Couldn't read the configuration")
+			os.Exit(1)
 		}
 
-		// override current configuration with the values of
-		// the flags provided on the command line
-		if c.IsSet("port") {
-			cfg.Port = c.String("port")
-		}
-		if c.IsSet("send-tick-period") {
-			cfg.SendTickPeriod = c.Int("send-tick-period")
-		}
-		if c.IsSet("logic-tick-period") {
-			cfg.LogicTickPeriod = c.Int("logic-tick-period")
-		}
-		if c.IsSet("time-factor") {
-			cfg.TimeFactor = c.Int("time-factor")
-		}
-		if c.IsSet("night-starting-time") {
-			cfg.NightStartingTime = c.Int("night-starting-time")
-		}
-		if c.IsSet("night-ending-time") {
-			cfg.NightEndingTime = c.Int("night-ending-time")
-		}
-		if c.IsSet("game-starting-time") {
-			cfg.GameStartingTime = c.Int("game-starting-time")
-		}
-		if c.IsSet("telnet-port") {
-			cfg.TelnetPort = c.String("telnet-port")
-		}
-		if c.IsSet("assets") {
-			cfg.AssetsPath = c.String("assets")
-		}
-		if c.IsSet("log-level") {
-			cfg.LogLevel = c.String("log-level")
+		// a lobby is needed to host several rooms
+		if cfg.Rooms > 1 {
+			lobby := surviveler.NewLobby(cfg)
+			if lobby == nil {
+				log.Fatal("Game startup failed")
+			}
+			log.WithField("rooms", cfg.Rooms).Info("Starting rooms...")
+			lobby.Start()
+			return nil
 		}
 
 		// game setup
@@ -92,6 +70,204 @@ func runCliApp() error {
 	return app.Run(os.Args)
 }
 
+/*
+ * parseConfig merges the configuration fields read from the various sources,
+ * by order of precedence
+ */
+func parseConfig(c *cli.Context) (surviveler.Config, error) {
+	// get configuration, pre-filled with default values
+	cfg := surviveler.NewConfig()
+
+	// read config file
+	path := c.String("config")
+	if path == "" {
+		path = c.String("inifile")
+	}
+	if path != "" {
+		if err := surviveler.LoadConfigFile(&cfg, path); err != nil {
+			return cfg, fmt.Errorf("couldn't read config file '%s': %v", path, err)
+		}
+	}
+
+	// environment variables are the default values of the flags, so a flag
+	// counts as set if it is present on the command line or in environment
+	isSet := func(name string) bool {
+		return c.IsSet(name) || os.Getenv(envVar(name)) != ""
+	}
+
+	// override current configuration with the values of
+	// the flags provided on the command line
+	if isSet("port") {
+		cfg.Port = c.String("port")
+	}
+	if isSet("send-tick-period") {
+		cfg.SendTickPeriod = c.Int("send-tick-period")
+	}
+	if isSet("logic-tick-period") {
+		cfg.LogicTickPeriod = c.Int("logic-tick-period")
+	}
+	if isSet("align-send-ticks") {
+		cfg.AlignSendTicks = c.Bool("align-send-ticks")
+	}
+	if isSet("send-velocities") {
+		cfg.SendVelocities = c.Bool("send-velocities")
+	}
+	if isSet("view-radius") {
+		cfg.ViewRadius = float32(c.Float64("view-radius"))
+	}
+	if isSet("slow-client-drops") {
+		cfg.SlowClientDrops = c.Int("slow-client-drops")
+	}
+	if isSet("time-factor") {
+		cfg.TimeFactor = c.Int("time-factor")
+	}
+	if isSet("night-starting-time") {
+		cfg.NightStartingTime = c.Int("night-starting-time")
+	}
+	if isSet("night-ending-time") {
+		cfg.NightEndingTime = c.Int("night-ending-time")
+	}
+	if isSet("game-starting-time") {
+		cfg.GameStartingTime = c.Int("game-starting-time")
+	}
+	if isSet("telnet-port") {
+		cfg.TelnetPort = c.String("telnet-port")
+	}
+	if isSet("telnet-password") {
+		cfg.TelnetPassword = c.String("telnet-password")
+	}
+	if isSet("assets") {
+		cfg.AssetsPath = c.String("assets")
+	}
+	if isSet("metrics-port") {
+		cfg.MetricsPort = c.String("metrics-port")
+	}
+	if isSet("record") {
+		cfg.RecordPath = c.String("record")
+	}
+	if isSet("replay") {
+		cfg.ReplayPath = c.String("replay")
+	}
+	if isSet("player-waypoints") {
+		cfg.PlayerWaypoints = c.Int("player-waypoints")
+	}
+	if isSet("zombie-waypoints") {
+		cfg.ZombieWaypoints = c.Int("zombie-waypoints")
+	}
+	if isSet("arrival-tolerance") {
+		cfg.ArrivalTolerance = float32(c.Float64("arrival-tolerance"))
+	}
+	if isSet("slowdown-radius") {
+		cfg.SlowdownRadius = float32(c.Float64("slowdown-radius"))
+	}
+	if isSet("reconnect-grace") {
+		cfg.ReconnectGrace = c.Int("reconnect-grace")
+	}
+	if isSet("friendly-fire") {
+		cfg.FriendlyFire = c.Bool("friendly-fire")
+	}
+	if isSet("player-factions") {
+		cfg.PlayerFactions = c.Int("player-factions")
+	}
+	if isSet("grid-scale") {
+		cfg.GridScale = float32(c.Float64("grid-scale"))
+	}
+	if isSet("grid-cache") {
+		cfg.GridCache = c.String("grid-cache")
+	}
+	if isSet("map-border") {
+		cfg.MapBorder = float32(c.Float64("map-border"))
+	}
+	if isSet("path-budget") {
+		cfg.PathBudget = c.Int("path-budget")
+	}
+	if isSet("seed") {
+		cfg.Seed = c.Int64("seed")
+	}
+	if isSet("player-regen-delay") {
+		cfg.PlayerRegenDelay = c.Int("player-regen-delay")
+	}
+	if isSet("player-regen-rate") {
+		cfg.PlayerRegenRate = float32(c.Float64("player-regen-rate"))
+	}
+	if isSet("spawn-protection") {
+		cfg.SpawnProtection = c.Int("spawn-protection")
+	}
+	if isSet("zombie-chase-time") {
+		cfg.ZombieChaseTime = c.Int("zombie-chase-time")
+	}
+	if isSet("zombie-leash") {
+		cfg.ZombieLeash = float32(c.Float64("zombie-leash"))
+	}
+	if isSet("zombie-dying-time") {
+		cfg.ZombieDyingTime = c.Int("zombie-dying-time")
+	}
+	if isSet("repath-interval") {
+		cfg.RepathInterval = c.Int("repath-interval")
+	}
+	if isSet("repath-distance") {
+		cfg.RepathDistance = float32(c.Float64("repath-distance"))
+	}
+	if isSet("attack-cooldown") {
+		cfg.AttackCooldown = c.Int("attack-cooldown")
+	}
+	if isSet("damage-variance") {
+		cfg.DamageVariance = float32(c.Float64("damage-variance"))
+	}
+	if isSet("modifier-stacking") {
+		cfg.ModifierStacking = c.String("modifier-stacking")
+	}
+	if isSet("melee-arc") {
+		cfg.MeleeArc = float32(c.Float64("melee-arc"))
+	}
+	if isSet("melee-range") {
+		cfg.MeleeRange = float32(c.Float64("melee-range"))
+	}
+	if isSet("zombie-targets") {
+		cfg.ZombieTargets = c.String("zombie-targets")
+	}
+	if isSet("spawn-jitter") {
+		cfg.SpawnJitter = float32(c.Float64("spawn-jitter"))
+	}
+	if isSet("spawn-pattern") {
+		cfg.SpawnPattern = c.String("spawn-pattern")
+	}
+	if isSet("wave-scaling") {
+		cfg.WaveScaling = c.String("wave-scaling")
+	}
+	if isSet("wave-hp-growth") {
+		cfg.WaveHPGrowth = float32(c.Float64("wave-hp-growth"))
+	}
+	if isSet("wave-damage-growth") {
+		cfg.WaveDamageGrowth = float32(c.Float64("wave-damage-growth"))
+	}
+	if isSet("rooms") {
+		cfg.Rooms = c.Int("rooms")
+	}
+	if isSet("pause-events") {
+		cfg.PauseEvents = c.String("pause-events")
+	}
+	if isSet("max-entities") {
+		cfg.MaxEntities = c.Int("max-entities")
+	}
+	if isSet("spawns-at-cap") {
+		cfg.SpawnsAtCap = c.String("spawns-at-cap")
+	}
+	if isSet("log-level") {
+		cfg.LogLevel = c.String("log-level")
+	}
+	if isSet("log-modules") {
+		cfg.Logging.Modules = c.String("log-modules")
+	}
+	if isSet("log-file") {
+		cfg.Logging.File = c.String("log-file")
+	}
+	if isSet("log-format") {
+		cfg.Logging.Format = c.String("log-format")
+	}
+	return cfg, nil
+}
+
 /*
  * configFlags populates and returns a slice with the command line flags
  */
@@ -105,6 +281,18 @@ func configFlags() []cli.Flag {
 			Name:  "log-level",
 			Usage: "Server logging level (Debug, Info, Warning, Error)",
 		},
+		cli.StringFlag{
+			Name:  "log-modules",
+			Usage: "Per-module logging levels, ex: pathfinder=debug,network=warn",
+		},
+		cli.StringFlag{
+			Name:  "log-file",
+			Usage: "Path to a file in which logs are also written, with rotation",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "Format of the log lines, 'text' or 'json' (default: text)",
+		},
 		cli.IntFlag{
 			Name:  "logic-tick-period",
 			Usage: "Period in millisecond of the ticker that updates game logic",
@@ -113,6 +301,22 @@ func configFlags() []cli.Flag {
 			Name:  "send-tick-period",
 			Usage: "Period in millisecond of the ticker that sends the gamestate to clients",
 		},
+		cli.BoolFlag{
+			Name:  "align-send-ticks",
+			Usage: "Send the gamestate right after the logic ticks, every send/logic tick periods ratio",
+		},
+		cli.BoolFlag{
+			Name:  "send-velocities",
+			Usage: "Send the velocities of the moving entities in the gamestate, for client extrapolation",
+		},
+		cli.Float64Flag{
+			Name:  "view-radius",
+			Usage: "Max radius around their player within which entities are sent to the clients, that may ask for less, 0 for no limit (default: 0)",
+		},
+		cli.IntFlag{
+			Name:  "slow-client-drops",
+			Usage: "Consecutive messages a client too slow to read them can miss before being disconnected, 0 to never disconnect (default: 50)",
+		},
 		cli.IntFlag{
 			Name:  "time-factor",
 			Usage: "Game time speed multiplier",
@@ -133,17 +337,210 @@ func configFlags() []cli.Flag {
 			Name:  "telnet-port",
 			Usage: "Any port different than 0 enables the telnet server (disabled by defaut)",
 		},
+		cli.StringFlag{
+			Name:  "telnet-password",
+			Usage: "Password the telnet clients must give before any command, empty for none (default: none)",
+		},
 		cli.StringFlag{
 			Name:  "assets",
 			Usage: "Path to the game assets package",
 		},
+		cli.StringFlag{
+			Name:  "metrics-port",
+			Usage: "Any port different than 0 enables the metrics http server (disabled by defaut)",
+		},
+		cli.IntFlag{
+			Name:  "player-waypoints",
+			Usage: "Number of waypoints sent in player moves, -1 for the whole path (default: 2)",
+		},
+		cli.IntFlag{
+			Name:  "zombie-waypoints",
+			Usage: "Number of waypoints sent in zombie moves, -1 for the whole path (default: 2)",
+		},
+		cli.Float64Flag{
+			Name:  "arrival-tolerance",
+			Usage: "Distance under which the players and zombies have reached a waypoint, in world units (default: 0.05)",
+		},
+		cli.Float64Flag{
+			Name:  "slowdown-radius",
+			Usage: "Distance to their destination under which the players slow down, 0 to disable (default: 0.5)",
+		},
+		cli.IntFlag{
+			Name:  "reconnect-grace",
+			Usage: "Seconds a disconnected player has to reconnect and resume, 0 to disable (default: 30)",
+		},
+		cli.BoolFlag{
+			Name:  "friendly-fire",
+			Usage: "Let players hurt the players of their own faction",
+		},
+		cli.IntFlag{
+			Name:  "player-factions",
+			Usage: "Number of player factions the joining players are spread among, 1 for co-op (default: 1)",
+		},
+		cli.Float64Flag{
+			Name:  "grid-scale",
+			Usage: "Pathfinding grid tiles per world unit, between 0.25 and 8, 0 for the map scale (default: 0)",
+		},
+		cli.StringFlag{
+			Name:  "grid-cache",
+			Usage: "Directory in which the world grid is cached, to skip building it from the map at startup",
+		},
+		cli.Float64Flag{
+			Name:  "map-border",
+			Usage: "Width of the impassable border along the map edges, in world units (default: 0)",
+		},
+		cli.IntFlag{
+			Name:  "path-budget",
+			Usage: "Max number of tiles explored by a path search, 0 for no limit (default: 0)",
+		},
+		cli.Int64Flag{
+			Name:  "seed",
+			Usage: "Seed of the random number generators, 0 for a random seed (default: 0)",
+		},
+		cli.IntFlag{
+			Name:  "player-regen-delay",
+			Usage: "Milliseconds a player must go unhurt before regenerating hit points (default: 5000)",
+		},
+		cli.Float64Flag{
+			Name:  "player-regen-rate",
+			Usage: "Hit points regenerated per second by unhurt players, 0 to disable (default: 0)",
+		},
+		cli.IntFlag{
+			Name:  "spawn-protection",
+			Usage: "Milliseconds newly joined players can't be targeted nor hurt, 0 to disable (default: 3000)",
+		},
+		cli.IntFlag{
+			Name:  "zombie-chase-time",
+			Usage: "Seconds a zombie chases a target before giving up, 0 to disable (default: 0)",
+		},
+		cli.Float64Flag{
+			Name:  "zombie-leash",
+			Usage: "Max distance from its spawn point at which a zombie chases, 0 to disable (default: 0)",
+		},
+		cli.IntFlag{
+			Name:  "zombie-dying-time",
+			Usage: "Milliseconds a killed zombie lies dying before being removed, 0 to remove it at once (default: 1500)",
+		},
+		cli.IntFlag{
+			Name:  "repath-interval",
+			Usage: "Min milliseconds between 2 path searches of a zombie chasing a target (default: 200)",
+		},
+		cli.Float64Flag{
+			Name:  "repath-distance",
+			Usage: "Distance its target must move for a chasing zombie to search a new path, 0 to search at each interval (default: 1)",
+		},
+		cli.IntFlag{
+			Name:  "attack-cooldown",
+			Usage: "Minimum milliseconds between 2 hits of a player or a zombie, on top of its attack rate (default: 0)",
+		},
+		cli.Float64Flag{
+			Name:  "damage-variance",
+			Usage: "Fraction of the combat power by which the damage of each hit randomly varies, in [0, 1] (default: 0)",
+		},
+		cli.StringFlag{
+			Name:  "modifier-stacking",
+			Usage: "The buffs and debuffs of a same stat 'add' up, 'multiply', or only the 'strongest' bonus and malus apply (default: multiply)",
+		},
+		cli.Float64Flag{
+			Name:  "melee-arc",
+			Usage: "Degrees swept in front of a player by its melee hits, hurting every enemy in sight within, 0 to only hurt the target (default: 0)",
+		},
+		cli.Float64Flag{
+			Name:  "melee-range",
+			Usage: "Reach of the player melee hits, in world units (default: 1)",
+		},
+		cli.StringFlag{
+			Name:  "zombie-targets",
+			Usage: "Zombies in reach of players and buildings attack the 'players', the 'buildings', the 'nearest' or the 'weakest' first (default: players)",
+		},
+		cli.Float64Flag{
+			Name:  "spawn-jitter",
+			Usage: "Max distance of a spawned zombie from its spawn point, 0 to spawn right on it (default: 2)",
+		},
+		cli.StringFlag{
+			Name:  "spawn-pattern",
+			Usage: "Spawned zombies are scattered 'uniform'ly, in a 'cluster' or 'spread' around their spawn point (default: uniform)",
+		},
+		cli.StringFlag{
+			Name:  "wave-scaling",
+			Usage: "The zombie stats grow with the waves, the nights of the game, in a 'linear' or 'exponential' way (default: linear)",
+		},
+		cli.Float64Flag{
+			Name:  "wave-hp-growth",
+			Usage: "Fraction of their hit points the zombies gain per wave, 0 for none (default: 0.1)",
+		},
+		cli.Float64Flag{
+			Name:  "wave-damage-growth",
+			Usage: "Fraction of their damage the zombies gain per wave, 0 for none (default: 0.05)",
+		},
+		cli.IntFlag{
+			Name:  "rooms",
+			Usage: "Number of isolated game rooms, joining clients are sent to the emptiest one (default: 1)",
+		},
+		cli.StringFlag{
+			Name:  "pause-events",
+			Usage: "Client events received while the game is paused are 'queue'd or 'drop'ped (default: queue)",
+		},
+		cli.IntFlag{
+			Name:  "max-entities",
+			Usage: "Max number of entities in game, beyond which zombie spawns are held, 0 for no limit (default: 0)",
+		},
+		cli.StringFlag{
+			Name:  "spawns-at-cap",
+			Usage: "Zombie spawns beyond the entity cap are 'queue'd until deaths make room, or 'refuse'd (default: queue)",
+		},
+		cli.StringFlag{
+			Name:  "record",
+			Usage: "Path to a file in which the session client events are recorded",
+		},
+		cli.StringFlag{
+			Name:  "replay",
+			Usage: "Path to a recorded session to replay (clients can't play during a replay)",
+		},
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "Path to the server configuration file, in ini, YAML (.yaml, .yml) or TOML (.toml) format",
+		},
 		cli.StringFlag{
 			Name:  "inifile",
-			Usage: "Path to the server configuration file",
+			Usage: "Deprecated alias of --config",
 		},
 	}
 }
 
+/*
+ * withEnvVars binds each flag to its environment variable, see envVar
+ */
+func withEnvVars(flags []cli.Flag) []cli.Flag {
+	for i, f := range flags {
+		switch f := f.(type) {
+		case cli.StringFlag:
+			f.EnvVar = envVar(f.Name)
+			flags[i] = f
+		case cli.IntFlag:
+			f.EnvVar = envVar(f.Name)
+			flags[i] = f
+		case cli.Int64Flag:
+			f.EnvVar = envVar(f.Name)
+			flags[i] = f
+		case cli.Float64Flag:
+			f.EnvVar = envVar(f.Name)
+			flags[i] = f
+		case cli.BoolFlag:
+			f.EnvVar = envVar(f.Name)
+			flags[i] = f
+		}
+	}
+	return flags
+}
+
+/*
+ * envVar returns the name of the environment variable overriding a flag
+ */
+func envVar(flag string) string {
+	return envVarPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
 func main() {
 	runCliApp()
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"server/surviveler"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+/*
+ * runParseConfig parses the configuration as the server would, from the given
+ * command line arguments and environment variables
+ */
+func runParseConfig(t *testing.T, args []string, env map[string]string) surviveler.Config {
+	for name, val := range env {
+		os.Setenv(name, val)
+		defer os.Unsetenv(name)
+	}
+
+	var (
+		cfg surviveler.Config
+		err error
+	)
+	app := cli.NewApp()
+	app.Flags = withEnvVars(configFlags())
+	app.Action = func(c *cli.Context) error {
+		cfg, err = parseConfig(c)
+		return nil
+	}
+	if err := app.Run(append([]string{"server"}, args...)); err != nil {
+		t.Fatalf("app.Run(%v) = %v", args, err)
+	}
+	if err != nil {
+		t.Fatalf("parseConfig(%v) = %v", args, err)
+	}
+	return cfg
+}
+
+func TestParseConfig_Precedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surviveler-main")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "server.yaml")
+	content := "port: 2000\ntelnet_port: 2001\nmetrics_port: 2002\nfriendly_fire: true\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("couldn't write config file: %v", err)
+	}
+
+	// the file overrides the defaults
+	cfg := runParseConfig(t, []string{"--config", path}, nil)
+	if cfg.Port != "2000" || cfg.TelnetPort != "2001" || cfg.MetricsPort != "2002" || !cfg.FriendlyFire {
+		t.Errorf("file values not loaded: %+v", cfg)
+	}
+	if def := surviveler.NewConfig(); cfg.SendTickPeriod != def.SendTickPeriod {
+		t.Errorf("SendTickPeriod = %d, want the default %d", cfg.SendTickPeriod, def.SendTickPeriod)
+	}
+
+	// environment variables override the file, flags override both
+	cfg = runParseConfig(t,
+		[]string{"--inifile", path, "--port", "3000"},
+		map[string]string{
+			"SURVIVELER_PORT":          "4000",
+			"SURVIVELER_TELNET_PORT":   "4001",
+			"SURVIVELER_FRIENDLY_FIRE": "false",
+		})
+	if cfg.Port != "3000" {
+		t.Errorf("Port = %q, want the flag value 3000", cfg.Port)
+	}
+	if cfg.TelnetPort != "4001" {
+		t.Errorf("TelnetPort = %q, want the environment value 4001", cfg.TelnetPort)
+	}
+	if cfg.FriendlyFire {
+		t.Errorf("FriendlyFire = true, want the environment value false")
+	}
+	if cfg.MetricsPort != "2002" {
+		t.Errorf("MetricsPort = %q, want the file value 2002", cfg.MetricsPort)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("merged configuration should be valid, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Surviveler messages package
+ * actions of the mobile entities, as sent in the game state
+ */
+package messages
+
+import (
+	"server/actions"
+
+	"github.com/ugorji/go/codec"
+)
+
+/*
+ * Action is the current action of a mobile entity.
+ *
+ * It's a tagged union: Type tells which of the payload fields is set, the
+ * action types having no payload leave them all nil. On the wire, the type
+ * and the payload are sent under the ActionType and Action keys of the
+ * entity state, the payload-less actions having an empty payload.
+ */
+type Action struct {
+	Type   actions.Type
+	Move   *actions.Move   // MoveId payload
+	Attack *actions.Attack // AttackId payload
+}
+
+/*
+ * NewAction returns an action without payload, like idling or building
+ */
+func NewAction(t actions.Type) Action {
+	return Action{Type: t}
+}
+
+/*
+ * NewMoveAction returns a movement action
+ */
+func NewMoveAction(move actions.Move) Action {
+	return Action{Type: actions.MoveId, Move: &move}
+}
+
+/*
+ * NewAttackAction returns an attack action
+ */
+func NewAttackAction(attack actions.Attack) Action {
+	return Action{Type: actions.AttackId, Attack: &attack}
+}
+
+/*
+ * payload returns the payload to encode for the action type
+ */
+func (a Action) payload() interface{} {
+	switch a.Type {
+	case actions.MoveId:
+		if a.Move != nil {
+			return a.Move
+		}
+		return actions.Move{}
+	case actions.AttackId:
+		if a.Attack != nil {
+			return a.Attack
+		}
+		return actions.Attack{}
+	}
+	return struct{}{}
+}
+
+/*
+ * actionPayload receives any action payload: the fields of the embedded
+ * payloads are decoded as if they were its own
+ */
+type actionPayload struct {
+	actions.Move
+	actions.Attack
+}
+
+/*
+ * action returns the action of type t, out of the decoded payload
+ */
+func (p *actionPayload) action(t actions.Type) Action {
+	switch t {
+	case actions.MoveId:
+		return NewMoveAction(p.Move)
+	case actions.AttackId:
+		return NewAttackAction(p.Attack)
+	}
+	return NewAction(t)
+}
+
+/*
+ * mobileEntityFields has the fields of MobileEntityState, but not its
+ * encoding methods
+ */
+type mobileEntityFields MobileEntityState
+
+/*
+ * mobileEntityWire is the wire schema of MobileEntityState, the action being
+ * split into its type and its payload
+ */
+type mobileEntityWire struct {
+	*mobileEntityFields
+	ActionType actions.Type `codec:"ActionType"`
+	Action     interface{}  `codec:"Action"` // action payload, depending on ActionType
+}
+
+/*
+ * CodecEncodeSelf encodes the entity state, the payload being chosen after
+ * the action type
+ */
+func (s *MobileEntityState) CodecEncodeSelf(e *codec.Encoder) {
+	e.MustEncode(mobileEntityWire{
+		mobileEntityFields: (*mobileEntityFields)(s),
+		ActionType:         s.Action.Type,
+		Action:             s.Action.payload(),
+	})
+}
+
+/*
+ * CodecDecodeSelf decodes the entity state, the payload into the concrete
+ * type of the action type
+ */
+func (s *MobileEntityState) CodecDecodeSelf(d *codec.Decoder) {
+	payload := new(actionPayload)
+	w := mobileEntityWire{
+		mobileEntityFields: (*mobileEntityFields)(s),
+		Action:             payload,
+	}
+	d.MustDecode(&w)
+	s.Action = payload.action(w.ActionType)
+}
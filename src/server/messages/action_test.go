@@ -0,0 +1,51 @@
+package messages
+
+import (
+	"reflect"
+	"server/actions"
+	"testing"
+)
+
+func TestAction_RoundTrip(t *testing.T) {
+	tests := []Action{
+		NewAction(actions.IdleId),
+		NewMoveAction(actions.Move{Speed: 2, Path: []actions.Waypoint{{Xpos: 1.5, Ypos: 2.5}, {Xpos: 3, Ypos: 4}}}),
+		NewMoveAction(actions.Move{Speed: 1}),
+		NewAction(actions.BuildId),
+		NewAction(actions.RepairId),
+		NewAttackAction(actions.Attack{TargetID: 12, Phase: actions.AttackRecovery}),
+		NewAction(actions.DrinkCoffeeId),
+		NewAction(actions.DieId),
+	}
+	for _, want := range tests {
+		state := MobileEntityState{Type: 3, Xpos: 1.5, Ypos: 2.5, CurHitPoints: 80, Action: want}
+		var got MobileEntityState
+		if err := Decode(New(GameStateId, state), &got); err != nil {
+			t.Errorf("action %v: Decode() error = %v", want.Type, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, state) {
+			t.Errorf("action %v: decoded %+v, want %+v", want.Type, got, state)
+		}
+
+		// only the payload of the action type is set
+		if (got.Action.Move != nil) != (want.Type == actions.MoveId) {
+			t.Errorf("action %v: decoded move payload %+v", want.Type, got.Action.Move)
+		}
+		if (got.Action.Attack != nil) != (want.Type == actions.AttackId) {
+			t.Errorf("action %v: decoded attack payload %+v", want.Type, got.Action.Attack)
+		}
+	}
+}
+
+func TestAction_MissingPayload(t *testing.T) {
+	// a move without payload is sent as an empty move
+	state := MobileEntityState{Action: Action{Type: actions.MoveId}}
+	var got MobileEntityState
+	if err := Decode(New(GameStateId, state), &got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if want := NewMoveAction(actions.Move{}); !reflect.DeepEqual(got.Action, want) {
+		t.Errorf("decoded action %+v, want %+v", got.Action, want)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Surviveler messages package
+ * payload compression
+ */
+package messages
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+ * Compression is a payload compression mode, negotiated by each client at
+ * JOIN
+ */
+type Compression uint8
+
+const (
+	CompressionNone Compression = 0 + iota
+	CompressionGzip
+	CompressionZstd
+)
+
+/*
+ * CompressedFlag is set in the type of a message whose payload is compressed,
+ * with the compression mode negotiated by the receiving client
+ */
+const CompressedFlag Type = 0x8000
+
+/*
+ * CompressionThreshold is the payload length from which messages get
+ * compressed, shorter payloads wouldn't shrink enough to be worth it
+ */
+const CompressionThreshold = 512
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Compression(%d)", c)
+}
+
+/*
+ * Valid indicates if c is a known compression mode
+ */
+func (c Compression) Valid() bool {
+	return c <= CompressionZstd
+}
+
+/*
+ * gzipPool holds the gzip writers, as allocating one is expensive
+ */
+var gzipPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+/*
+ * zstd encoder and decoder, both safe for concurrent use of EncodeAll and
+ * DecodeAll
+ */
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+/*
+ * Compressed indicates if the payload of msg is compressed
+ */
+func (msg *Message) Compressed() bool {
+	return msg.Type&CompressedFlag != 0
+}
+
+/*
+ * Compress returns a copy of msg with its payload compressed with c, and the
+ * CompressedFlag set in its type.
+ *
+ * msg itself is returned if c is CompressionNone, if it's already compressed
+ * or if its payload is shorter than CompressionThreshold.
+ */
+func (msg *Message) Compress(c Compression) *Message {
+	if c == CompressionNone || msg.Compressed() || len(msg.Payload) < CompressionThreshold {
+		return msg
+	}
+
+	var payload []byte
+	switch c {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzipPool.Get().(*gzip.Writer)
+		defer gzipPool.Put(zw)
+		zw.Reset(&buf)
+		zw.Write(msg.Payload)
+		if err := zw.Close(); err != nil {
+			log.WithError(err).Error("Couldn't gzip payload")
+			return msg
+		}
+		payload = buf.Bytes()
+	case CompressionZstd:
+		payload = zstdEncoder.EncodeAll(msg.Payload, nil)
+	default:
+		log.WithField("compression", c).Error("Unknown compression")
+		return msg
+	}
+	return &Message{
+		Type:    msg.Type | CompressedFlag,
+		Length:  uint32(len(payload)),
+		Payload: payload,
+	}
+}
+
+/*
+ * Decompress returns a copy of msg with its payload decompressed with c, and
+ * the CompressedFlag cleared from its type. msg itself is returned if it's
+ * not compressed.
+ */
+func (msg *Message) Decompress(c Compression) (*Message, error) {
+	if !msg.Compressed() {
+		return msg, nil
+	}
+
+	var (
+		payload []byte
+		err     error
+	)
+	switch c {
+	case CompressionGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(msg.Payload)); err == nil {
+			payload, err = ioutil.ReadAll(zr)
+		}
+	case CompressionZstd:
+		payload, err = zstdDecoder.DecodeAll(msg.Payload, nil)
+	default:
+		err = fmt.Errorf("can't decompress with %v", c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while decompressing payload: %v", err)
+	}
+	return &Message{
+		Type:    msg.Type &^ CompressedFlag,
+		Length:  uint32(len(payload)),
+		Payload: payload,
+	}, nil
+}
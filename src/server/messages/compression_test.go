@@ -0,0 +1,83 @@
+package messages
+
+import (
+	"bytes"
+	"reflect"
+	"server/actions"
+	"testing"
+)
+
+// largeGameState returns a game state whose payload is well above the
+// compression threshold
+func largeGameState() GameState {
+	gs := GameState{
+		Tstamp:    1234567890,
+		Time:      120,
+		Version:   StateSchemaVersion,
+		Entities:  make(map[uint32]MobileEntityState),
+		Buildings: make(map[uint32]BuildingState),
+	}
+	for i := uint32(0); i < 100; i++ {
+		gs.Entities[i] = MobileEntityState{
+			Type:         3,
+			Xpos:         float32(i) + 0.5,
+			Ypos:         float32(i%10) + 0.5,
+			CurHitPoints: 100,
+			Heading:      0.5,
+			Action:       NewAction(actions.BuildId),
+		}
+	}
+	for i := uint32(100); i < 110; i++ {
+		gs.Buildings[i] = BuildingState{Type: 1, Xpos: 4.5, Ypos: float32(i), CurHitPoints: 50, Completed: true}
+	}
+	return gs
+}
+
+func TestMessage_CompressionRoundTrip(t *testing.T) {
+	want := largeGameState()
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		orig := New(GameStateId, want)
+		msg := orig.Compress(c)
+		if compressed := c != CompressionNone; msg.Compressed() != compressed {
+			t.Errorf("%v: Compressed() = %v, want %v", c, msg.Compressed(), compressed)
+		}
+		if c != CompressionNone && len(msg.Payload) >= len(orig.Payload) {
+			t.Errorf("%v: compressed payload is %d bytes long, want less than %d", c, len(msg.Payload), len(orig.Payload))
+		}
+
+		// through the wire
+		read, err := ReadMessage(bytes.NewReader(msg.Serialize()))
+		if err != nil {
+			t.Fatalf("%v: ReadMessage() error = %v", c, err)
+		}
+		if read, err = read.Decompress(c); err != nil {
+			t.Fatalf("%v: Decompress() error = %v", c, err)
+		}
+		if read.Type != GameStateId {
+			t.Errorf("%v: decompressed type = %v, want %v", c, read.Type, GameStateId)
+		}
+		var got GameState
+		if err := Decode(read, &got); err != nil {
+			t.Fatalf("%v: Decode() error = %v", c, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: decoded game state differs from the original", c)
+		}
+	}
+}
+
+func TestMessage_SmallStaysUncompressed(t *testing.T) {
+	msg := New(PingId, Ping{Id: 1, Tstamp: 1234567890})
+	for _, c := range []Compression{CompressionGzip, CompressionZstd} {
+		if got := msg.Compress(c); got != msg || got.Compressed() {
+			t.Errorf("%v: %d bytes payload got compressed", c, len(msg.Payload))
+		}
+	}
+}
+
+func TestMessage_DecompressCorrupted(t *testing.T) {
+	msg := New(GameStateId, largeGameState()).Compress(CompressionGzip)
+	if _, err := msg.Decompress(CompressionZstd); err == nil {
+		t.Errorf("Decompress() with the wrong compression succeeded")
+	}
+}
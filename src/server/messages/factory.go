@@ -8,7 +8,6 @@ import (
 	"reflect"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/ugorji/go/codec"
 )
 
 var factory *Factory
@@ -52,6 +51,14 @@ func (mf Factory) registerMsgTypes() {
 	mf.registerMsgType(RepairId, Repair{})
 	mf.registerMsgType(AttackId, Attack{})
 	mf.registerMsgType(OperateId, Operate{})
+	mf.registerMsgType(ShootId, Shoot{})
+	mf.registerMsgType(ChatId, Chat{})
+	mf.registerMsgType(ExploredId, Explored{})
+	mf.registerMsgType(BuildRejectedId, BuildRejected{})
+	mf.registerMsgType(MoveRejectedId, MoveRejected{})
+	mf.registerMsgType(EntitySpawnedId, EntitySpawned{})
+	mf.registerMsgType(EntityDespawnedId, EntityDespawned{})
+	mf.registerMsgType(ThrowId, Throw{})
 }
 
 /*
@@ -79,13 +86,11 @@ func (mf Factory) newMsg(t Type) interface{} {
  * Decode returns a new specialized message, decoded from a raw message
  */
 func (mf Factory) Decode(raw *Message) interface{} {
-	var mh codec.MsgpackHandle
 	// create a struct having the corresponding underlying type
 	msg := mf.newMsg(raw.Type)
 
 	// decode msgpack payload into interface
-	decoder := codec.NewDecoderBytes(raw.Payload, &mh)
-	err := decoder.Decode(&msg)
+	err := Decode(raw, &msg)
 	if err != nil {
 		log.WithError(err).Error("Couldn't decode raw message payload")
 	}
@@ -7,6 +7,10 @@ package messages
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/ugorji/go/codec"
@@ -39,25 +43,106 @@ func (msg Message) Serialize() []byte {
 	return bbuf.Bytes()
 }
 
+/*
+ * ReadMessage reads a message from r: its type and its length, in network
+ * byte order, followed by its payload
+ */
+func ReadMessage(r io.Reader) (*Message, error) {
+	return ReadLimitedMessage(r, math.MaxUint32)
+}
+
+/*
+ * ReadLimitedMessage reads a message from r, like ReadMessage does, but fails
+ * before reading the payload if it's longer than maxLength
+ */
+func ReadLimitedMessage(r io.Reader, maxLength uint32) (*Message, error) {
+	msg := new(Message)
+	var err error
+
+	// Read MsgType
+	err = binary.Read(r, binary.BigEndian, &msg.Type)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading Message.Type: %v", err)
+	}
+
+	// Read message length
+	err = binary.Read(r, binary.BigEndian, &msg.Length)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading Message.Length: %v", err)
+	}
+
+	if msg.Length == 0 {
+		return nil, fmt.Errorf("invalid Message.Length: 0")
+	}
+	if msg.Length > maxLength {
+		return nil, fmt.Errorf("invalid (too big) Message.Length: %v", msg.Length)
+	}
+
+	// Read payload
+	msg.Payload = make([]byte, msg.Length)
+	_, err = io.ReadFull(r, msg.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading payload: %v", err)
+	}
+	return msg, nil
+}
+
+/*
+ * Decode decodes the msgpack payload of a message into out, that must be a
+ * pointer to the message struct
+ */
+func Decode(msg *Message, out interface{}) error {
+	return codec.NewDecoderBytes(msg.Payload, &mh).Decode(out)
+}
+
+/*
+ * msgpack handle shared by the encoders and decoders. A handle is safe for
+ * concurrent use, as long as it isn't modified.
+ */
+var mh codec.MsgpackHandle
+
+/*
+ * encoder is a reusable msgpack encoder, writing into its own buffer
+ */
+type encoder struct {
+	buf bytes.Buffer
+	enc *codec.Encoder
+}
+
+/*
+ * encoderPool holds the encoders, so that creating a message doesn't allocate
+ * a new encoder each time
+ */
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		e := new(encoder)
+		e.enc = codec.NewEncoder(&e.buf, &mh)
+		return e
+	},
+}
+
 /*
  * New creates a new message from a message type and a generic payload
  */
 func New(t Type, p interface{}) *Message {
-	var mh codec.MsgpackHandle
-	msg := new(Message)
-	msg.Type = t
+	e := encoderPool.Get().(*encoder)
+	defer encoderPool.Put(e)
+	e.buf.Reset()
+	e.enc.Reset(&e.buf)
 
 	// Encode payload to msgpack
-	bb := new(bytes.Buffer)
-	var enc *codec.Encoder = codec.NewEncoder(bb, &mh)
-	err := enc.Encode(p)
+	err := e.enc.Encode(p)
 	if err != nil {
 		log.WithError(err).Error("Error encoding payload")
 		return nil
 	}
 
-	// Copy the payload buffer
-	msg.Payload = bb.Bytes()
+	msg := new(Message)
+	msg.Type = t
+
+	// Copy the payload buffer, as the encoder buffer is reused
+	msg.Payload = make([]byte, e.buf.Len())
+	copy(msg.Payload, e.buf.Bytes())
 
 	// Length is the buffer length
 	msg.Length = uint32(len(msg.Payload))
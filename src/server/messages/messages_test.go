@@ -0,0 +1,183 @@
+package messages
+
+import (
+	"bytes"
+	"reflect"
+	"server/actions"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+/*
+ * testPayloads are messages payloads covering the different shapes of
+ * messages. Maps have at most one entry, so that the encoding is
+ * deterministic.
+ */
+var testPayloads = []struct {
+	t Type
+	p interface{}
+}{
+	{PingId, Ping{Id: 1, Tstamp: 1480000000000}},
+	{JoinId, Join{Name: "John Doe", Type: 1}},
+	{LeaveId, Leave{Reason: "server shutdown"}},
+	{ShootId, Shoot{Xpos: 3.5, Ypos: -2.25}},
+	{GameStateId, GameState{
+		Tstamp:  1480000000000,
+		Time:    720,
+		Version: StateSchemaVersion,
+		Entities: map[uint32]MobileEntityState{
+			12: {Type: 3, Xpos: 1.5, Ypos: 2.5,
+				Action: NewMoveAction(actions.Move{Speed: 2})},
+		},
+		Buildings:   map[uint32]BuildingState{},
+		Objects:     map[uint32]ObjectState{},
+		Projectiles: map[uint32]ProjectileState{},
+		Items:       map[uint32]ItemState{},
+	}},
+}
+
+/*
+ * encodeReference encodes a payload with a fresh handle and encoder
+ */
+func encodeReference(t testing.TB, p interface{}) []byte {
+	var (
+		mh codec.MsgpackHandle
+		bb bytes.Buffer
+	)
+	if err := codec.NewEncoder(&bb, &mh).Encode(p); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func TestNew_PayloadEncoding(t *testing.T) {
+	var wg sync.WaitGroup
+	for _, tt := range testPayloads {
+		want := encodeReference(t, tt.p)
+		// encode concurrently, like broadcasts do
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(tt Type, p interface{}) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					msg := New(tt, p)
+					if msg.Type != tt || msg.Length != uint32(len(want)) || !bytes.Equal(msg.Payload, want) {
+						t.Errorf("New(%v) payload = %v, want %v", tt, msg.Payload, want)
+						return
+					}
+				}
+			}(tt.t, tt.p)
+		}
+	}
+	wg.Wait()
+}
+
+func TestNew_PayloadNotShared(t *testing.T) {
+	m1 := New(PingId, Ping{Id: 1})
+	want := append([]byte(nil), m1.Payload...)
+	New(PingId, Ping{Id: 2, Tstamp: 1480000000000})
+	if !bytes.Equal(m1.Payload, want) {
+		t.Errorf("payload = %v after another message creation, want %v", m1.Payload, want)
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	for _, tt := range testPayloads {
+		b.Run(tt.t.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				New(tt.t, tt.p)
+			}
+		})
+	}
+}
+
+func TestReadMessage_RoundTrip(t *testing.T) {
+	payloads := []interface{}{
+		Ping{Id: 1, Tstamp: 1480000000000},
+		Pong{Id: 1, Tstamp: 1480000000042},
+		Join{Name: "John Doe", Type: 1},
+		Joined{Id: 3, Name: "John Doe", Type: 1},
+		Stay{Id: 3, Players: map[uint32]string{1: "Jane Doe", 3: "John Doe"}},
+		Leave{Id: 3, Reason: "server shutdown"},
+		GameState{
+			Tstamp:      1480000000000,
+			Time:        720,
+			Version:     StateSchemaVersion,
+			Entities:    map[uint32]MobileEntityState{},
+			Buildings:   map[uint32]BuildingState{},
+			Objects:     map[uint32]ObjectState{},
+			Projectiles: map[uint32]ProjectileState{},
+			Items:       map[uint32]ItemState{},
+		},
+		Move{Xpos: 1.5, Ypos: 2.5},
+		Build{Type: 2, Xpos: 1.5, Ypos: 2.5},
+		Repair{Id: 7},
+		Attack{Id: 8},
+		Operate{Id: 9},
+		Shoot{Xpos: 3.5, Ypos: -2.25},
+		Throw{Xpos: 4.5, Ypos: 6.25},
+		Chat{Id: 3, Text: "hello", Channel: ChatArea},
+		Explored{Tiles: []uint32{12, 13, 31}},
+		BuildRejected{Type: 1, Xpos: 4.5, Ypos: 2.5, Reason: "on a wall"},
+		MoveRejected{Xpos: 4.5, Ypos: 2.5, Reason: 1},
+		EntitySpawned{Id: 9, Kind: MobileEntityKind, Type: 3, Xpos: 1.5, Ypos: 2.5},
+		EntityDespawned{Id: 9, Kind: MobileEntityKind},
+	}
+
+	// the whole stream is read back, message after message
+	var stream bytes.Buffer
+	types := make([]Type, len(payloads))
+	for i, p := range payloads {
+		for t, reft := range GetFactory().registry {
+			if reft == reflect.TypeOf(p) {
+				types[i] = t
+			}
+		}
+		stream.Write(New(types[i], p).Serialize())
+	}
+	if len(GetFactory().registry) != len(payloads) {
+		t.Errorf("round-trip tested for %d message types, want %d", len(payloads), len(GetFactory().registry))
+	}
+
+	for i, want := range payloads {
+		msg, err := ReadMessage(&stream)
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		if msg.Type != types[i] {
+			t.Errorf("ReadMessage() type = %v, want %v", msg.Type, types[i])
+		}
+		got := reflect.New(reflect.TypeOf(want))
+		if err := Decode(msg, got.Interface()); err != nil {
+			t.Fatalf("Decode(%v) error = %v", msg.Type, err)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), want) {
+			t.Errorf("Decode(%v) = %#v, want %#v", msg.Type, got.Elem().Interface(), want)
+		}
+	}
+	if _, err := ReadMessage(&stream); err == nil {
+		t.Errorf("ReadMessage() on an empty stream should fail")
+	}
+}
+
+func TestReadLimitedMessage_InvalidLength(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		err  string
+	}{
+		{"empty", []byte{0, 0, 0, 0, 0, 0}, "invalid Message.Length: 0"},
+		{"too big", New(JoinId, Join{Name: strings.Repeat("x", 100)}).Serialize(), "too big"},
+		{"truncated", New(JoinId, Join{Name: "John"}).Serialize()[:8], "error while reading payload"},
+	}
+	for _, tt := range tests {
+		_, err := ReadLimitedMessage(bytes.NewReader(tt.raw), 64)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: ReadLimitedMessage() error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ * Surviveler messages package
+ * entity states, as sent in the game state
+ */
+package messages
+
+/*
+ * StateSchemaVersion is the version of the entity state schemas, sent in each
+ * game state.
+ *
+ * Fields are encoded under the names of their codec tags, the struct field
+ * names can thus be changed freely. Adding a field is compatible, the clients
+ * ignoring the fields they don't know; renaming, removing or changing the
+ * type of a field isn't, and requires to increment the version.
+ */
+const StateSchemaVersion = 1
+
+/*
+ * MobileEntityState is the state of a player or of a zombie.
+ *
+ * Its action is encoded by CodecEncodeSelf, as ActionType and Action.
+ */
+type MobileEntityState struct {
+	Type         uint8   `codec:"Type"`
+	Xpos         float32 `codec:"Xpos"`
+	Ypos         float32 `codec:"Ypos"`
+	CurHitPoints uint16  `codec:"CurHitPoints"`
+	Heading      float32 `codec:"Heading"`        // angle in radians from the x axis
+	Xvel         float32 `codec:"Xvel,omitempty"` // velocity, only sent if enabled and moving
+	Yvel         float32 `codec:"Yvel,omitempty"`
+	Staggered    bool    `codec:"Staggered"`
+	Regenerating bool    `codec:"Regenerating"` // out of combat hit points regeneration, players only
+	Protected    bool    `codec:"Protected"`    // spawn protection, players only
+	Action       Action  `codec:"-"`
+	Resources    uint16  `codec:"Resources"` // 0 for non-player entities
+}
+
+/*
+ * BuildingState is the state of a building
+ */
+type BuildingState struct {
+	Type         uint8   `codec:"Type"`
+	Xpos         float32 `codec:"Xpos"`
+	Ypos         float32 `codec:"Ypos"`
+	CurHitPoints uint16  `codec:"CurHitPoints"`
+	Completed    bool    `codec:"Completed"`
+}
+
+/*
+ * ObjectState is the state of an usable object
+ */
+type ObjectState struct {
+	Type       uint8   `codec:"Type"`
+	Xpos       float32 `codec:"Xpos"`
+	Ypos       float32 `codec:"Ypos"`
+	OperatedBy uint32  `codec:"OperatedBy"` // id of the operating player, or max uint32
+}
+
+/*
+ * ProjectileState is the state of a projectile
+ */
+type ProjectileState struct {
+	Type uint8   `codec:"Type"`
+	Xpos float32 `codec:"Xpos"`
+	Ypos float32 `codec:"Ypos"`
+	Xdir float32 `codec:"Xdir"`
+	Ydir float32 `codec:"Ydir"`
+}
+
+/*
+ * ItemState is the state of an item lying on the ground
+ */
+type ItemState struct {
+	Type     uint8   `codec:"Type"`
+	Xpos     float32 `codec:"Xpos"`
+	Ypos     float32 `codec:"Ypos"`
+	Quantity uint16  `codec:"Quantity"`
+}
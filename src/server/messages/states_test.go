@@ -0,0 +1,101 @@
+package messages
+
+import (
+	"encoding/hex"
+	"server/actions"
+	"testing"
+)
+
+/*
+ * goldenStates are the msgpack encodings of each entity state schema, as
+ * expected by the clients for StateSchemaVersion. A change here breaks the
+ * wire format: unless a field has only been added, StateSchemaVersion has to
+ * be incremented.
+ */
+var goldenStates = []struct {
+	state  interface{}
+	golden string
+}{
+	{
+		MobileEntityState{Type: 3, Xpos: 1.5, Ypos: 2.5, CurHitPoints: 100,
+			Heading: 0.5, Staggered: true, Regenerating: true, Protected: true,
+			Action: NewAction(actions.BuildId), Resources: 20},
+		"8b" +
+			"a6416374696f6e" + "80" + // Action: {}
+			"aa416374696f6e54797065" + "02" + // ActionType: 2
+			"ac437572486974506f696e7473" + "64" + // CurHitPoints: 100
+			"a748656164696e67" + "ca3f000000" + // Heading: 0.5
+			"a950726f746563746564" + "c3" + // Protected: true
+			"ac526567656e65726174696e67" + "c3" + // Regenerating: true
+			"a95265736f7572636573" + "14" + // Resources: 20
+			"a9537461676765726564" + "c3" + // Staggered: true
+			"a454797065" + "03" + // Type: 3
+			"a458706f73" + "ca3fc00000" + // Xpos: 1.5
+			"a459706f73" + "ca40200000", // Ypos: 2.5
+	},
+	{
+		// null velocity components are omitted
+		MobileEntityState{Type: 3, Xpos: 1.5, Ypos: 2.5, CurHitPoints: 100, Xvel: 2,
+			Action: NewMoveAction(actions.Move{Speed: 1.5, Path: []actions.Waypoint{{Xpos: 2, Ypos: 2.5}}})},
+		"8c" +
+			"a6416374696f6e" + "82" + // Action:
+			"a450617468" + "91" + "82" + // Path: [
+			"a458706f73" + "ca40000000" + // Xpos: 2
+			"a459706f73" + "ca40200000" + // Ypos: 2.5 ]
+			"a55370656564" + "ca3fc00000" + // Speed: 1.5
+			"aa416374696f6e54797065" + "01" + // ActionType: 1
+			"ac437572486974506f696e7473" + "64" + // CurHitPoints: 100
+			"a748656164696e67" + "ca00000000" + // Heading: 0
+			"a950726f746563746564" + "c2" + // Protected: false
+			"ac526567656e65726174696e67" + "c2" + // Regenerating: false
+			"a95265736f7572636573" + "00" + // Resources: 0
+			"a9537461676765726564" + "c2" + // Staggered: false
+			"a454797065" + "03" + // Type: 3
+			"a458706f73" + "ca3fc00000" + // Xpos: 1.5
+			"a45876656c" + "ca40000000" + // Xvel: 2
+			"a459706f73" + "ca40200000", // Ypos: 2.5
+	},
+	{
+		BuildingState{Type: 1, Xpos: 4.5, Ypos: 2.5, CurHitPoints: 50, Completed: true},
+		"85" +
+			"a9436f6d706c65746564" + "c3" + // Completed: true
+			"ac437572486974506f696e7473" + "32" + // CurHitPoints: 50
+			"a454797065" + "01" + // Type: 1
+			"a458706f73" + "ca40900000" + // Xpos: 4.5
+			"a459706f73" + "ca40200000", // Ypos: 2.5
+	},
+	{
+		ObjectState{Type: 0, Xpos: 1.5, Ypos: 3.5, OperatedBy: 7},
+		"84" +
+			"aa4f706572617465644279" + "07" + // OperatedBy: 7
+			"a454797065" + "00" + // Type: 0
+			"a458706f73" + "ca3fc00000" + // Xpos: 1.5
+			"a459706f73" + "ca40600000", // Ypos: 3.5
+	},
+	{
+		ProjectileState{Type: 0, Xpos: 1.5, Ypos: 2.5, Xdir: 1, Ydir: 0},
+		"85" +
+			"a454797065" + "00" + // Type: 0
+			"a458646972" + "ca3f800000" + // Xdir: 1
+			"a458706f73" + "ca3fc00000" + // Xpos: 1.5
+			"a459646972" + "ca00000000" + // Ydir: 0
+			"a459706f73" + "ca40200000", // Ypos: 2.5
+	},
+	{
+		ItemState{Type: 2, Xpos: 6.5, Ypos: 1.5, Quantity: 5},
+		"84" +
+			"a85175616e74697479" + "05" + // Quantity: 5
+			"a454797065" + "02" + // Type: 2
+			"a458706f73" + "ca40d00000" + // Xpos: 6.5
+			"a459706f73" + "ca3fc00000", // Ypos: 1.5
+	},
+}
+
+func TestStates_GoldenEncoding(t *testing.T) {
+	for _, tt := range goldenStates {
+		msg := New(GameStateId, tt.state)
+		if got := hex.EncodeToString(msg.Payload); got != tt.golden {
+			t.Errorf("%T encoding =\n%s\nwant\n%s", tt.state, got, tt.golden)
+		}
+	}
+}
@@ -4,9 +4,9 @@ package messages
 
 import "fmt"
 
-const _Type_name = "PingIdPongIdJoinIdJoinedIdStayIdLeaveIdGameStateIdMoveIdBuildIdRepairIdAttackIdOperateId"
+const _Type_name = "PingIdPongIdJoinIdJoinedIdStayIdLeaveIdGameStateIdMoveIdBuildIdRepairIdAttackIdOperateIdShootIdChatIdExploredIdBuildRejectedIdMoveRejectedIdEntitySpawnedIdEntityDespawnedIdThrowId"
 
-var _Type_index = [...]uint8{0, 6, 12, 18, 26, 32, 39, 50, 56, 63, 71, 79, 88}
+var _Type_index = [...]uint8{0, 6, 12, 18, 26, 32, 39, 50, 56, 63, 71, 79, 88, 95, 101, 111, 126, 140, 155, 172, 179}
 
 func (i Type) String() string {
 	if i >= Type(len(_Type_index)-1) {
@@ -23,6 +23,14 @@ const (
 	RepairId
 	AttackId
 	OperateId
+	ShootId
+	ChatId
+	ExploredId
+	BuildRejectedId
+	MoveRejectedId
+	EntitySpawnedId
+	EntityDespawnedId
+	ThrowId
 )
 
 /*
@@ -42,19 +50,31 @@ type Pong Ping
  * Server->client game state
  */
 type GameState struct {
-	Tstamp    int64
-	Time      int16
-	Entities  map[uint32]interface{}
-	Buildings map[uint32]interface{}
-	Objects   map[uint32]interface{}
+	Tstamp      int64
+	Time        int16
+	Version     uint16 // version of the entity state schemas
+	Entities    map[uint32]MobileEntityState
+	Buildings   map[uint32]BuildingState
+	Objects     map[uint32]ObjectState
+	Projectiles map[uint32]ProjectileState
+	Items       map[uint32]ItemState
+}
+
+/*
+ * Server->client tiles newly revealed to a player, sent along with the game
+ * state
+ */
+type Explored struct {
+	Tiles []uint32 // tile indices in the world grid (x + y * grid width)
 }
 
 /*
  * player initiated character movement. Client -> server message
  */
 type Move struct {
-	Xpos float32
-	Ypos float32
+	Xpos   float32
+	Ypos   float32
+	Queued bool // go there once done with the current orders, rather than right away
 }
 
 /*
@@ -66,6 +86,59 @@ type Build struct {
 	Ypos float32
 }
 
+/*
+ * the building can't be placed where the player asked. Server -> client
+ * message
+ */
+type BuildRejected struct {
+	Type   uint8
+	Xpos   float32
+	Ypos   float32
+	Reason string
+}
+
+/*
+ * the player can't go where it asked. Server -> client message
+ */
+type MoveRejected struct {
+	Xpos   float32
+	Ypos   float32
+	Reason uint8 // 1: unreachable, 2: out of bounds, 3: destination occupied, 4: search budget exceeded
+}
+
+/*
+ * Kinds of entities, telling in which map of the game state an entity is
+ */
+const (
+	MobileEntityKind uint8 = iota // Entities
+	BuildingKind                  // Buildings
+	ObjectKind                    // Objects
+	ProjectileKind                // Projectiles
+	ItemKind                      // Items
+)
+
+/*
+ * an entity entered the world. Server -> client message, sent before the
+ * game state in which the entity first appears
+ */
+type EntitySpawned struct {
+	Id   uint32
+	Kind uint8 // kind of the entity, MobileEntityKind, BuildingKind, etc.
+	Type uint8 // type of the entity, within its kind
+	Xpos float32
+	Ypos float32
+}
+
+/*
+ * an entity left the world: it has been killed, destroyed, picked up, etc.
+ * Server -> client message, sent before the first game state in which the
+ * entity doesn't appear anymore
+ */
+type EntityDespawned struct {
+	Id   uint32
+	Kind uint8
+}
+
 /*
  * player initiated a repair action. Client -> server message
  */
@@ -87,13 +160,51 @@ type Operate struct {
 	Id uint32 // id of the entity to operate
 }
 
+/*
+ * player initiated a shoot action. Client -> server message
+ */
+type Shoot struct {
+	Xpos float32 // aimed point
+	Ypos float32
+}
+
+/*
+ * player initiated a throw action. Client -> server message
+ */
+type Throw struct {
+	Xpos float32 // aimed point
+	Ypos float32
+}
+
+/*
+ * Chat channels, defining who receives a chat message
+ */
+const (
+	ChatAll  uint8 = iota // every player
+	ChatArea              // players around the sender
+)
+
+/*
+ * player sent a chat message. Client -> server message, then broadcasted by
+ * the server to the players of the channel
+ */
+type Chat struct {
+	Id      uint32 // id of the sender, set by the server
+	Text    string
+	Channel uint8
+}
+
 /*
  * This message is sent only by clients right after a connection is
  * established.
  */
 type Join struct {
-	Name string
-	Type uint8
+	Name        string
+	Type        uint8
+	Version     uint16  // protocol version implemented by the client
+	Token       string  // session token, to resume a previous session
+	Compression uint8   // payload compression requested by the client
+	ViewRadius  float32 // radius around its player within which the client wants the entities, 0 for the server max
 }
 
 /*
@@ -111,8 +222,11 @@ type Joined struct {
  * requested to join.
  */
 type Stay struct {
-	Id      uint32
-	Players map[uint32]string
+	Id          uint32
+	Players     map[uint32]string
+	Token       string  // session token, allowing to resume after a disconnection
+	Compression uint8   // payload compression of the messages sent to the client
+	ViewRadius  float32 // radius within which the entities are sent to the client, 0 for no limit
 }
 
 /*
@@ -7,13 +7,15 @@ package network
 import (
 	"errors"
 	"net"
+	"server/logging"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	log "github.com/Sirupsen/logrus"
 )
 
+// logger of the network module
+var netLog = logging.Module("network")
+
 /*
  * Error types
  */
@@ -31,6 +33,7 @@ type Conn struct {
 	srv          *Server
 	conn         *net.TCPConn  // underlying tcp connection
 	userData     interface{}   // associated user data
+	userDataMu   sync.RWMutex  // protect user data from concurrent accesses
 	closeOnce    sync.Once     // close the connection, once, per instance
 	closeFlag    int32         // close flag
 	closeChan    chan struct{} // close chanel
@@ -42,6 +45,8 @@ type Conn struct {
  * GetUserData retrieves the associated user data
  */
 func (c *Conn) GetUserData() interface{} {
+	c.userDataMu.RLock()
+	defer c.userDataMu.RUnlock()
 	return c.userData
 }
 
@@ -49,7 +54,8 @@ func (c *Conn) GetUserData() interface{} {
  * SetUserData associates user data with the connection
  */
 func (c *Conn) SetUserData(data interface{}) {
-	// TODO: protect again more than one call, using sync.Once
+	c.userDataMu.Lock()
+	defer c.userDataMu.Unlock()
 	c.userData = data
 }
 
@@ -97,10 +103,9 @@ func (c *Conn) Close() {
 		// atomically set to 1, so concurrent accesses always have the latest value
 		atomic.StoreInt32(&c.closeFlag, 1)
 
-		// close all the channels
+		// signal the closing, the packet channels are left open since
+		// concurrent senders could still be writing to them
 		close(c.closeChan)
-		close(c.outgoingChan)
-		close(c.incomingChan)
 
 		// close the underlying TCP connection
 		c.conn.Close()
@@ -126,12 +131,6 @@ func (c *Conn) AsyncSendPacket(msg Packet, timeout time.Duration) (err error) {
 		return ErrClosedConnection
 	}
 
-	defer func() {
-		if e := recover(); e != nil {
-			err = ErrClosedConnection
-		}
-	}()
-
 	if timeout == 0 {
 		select {
 		case c.outgoingChan <- msg:
@@ -214,12 +213,16 @@ func (c *Conn) readLoop() {
 		// Read from the connection byte stream and unserialize into a packet
 		msg, err := c.srv.msgReader.ReadPacket(c.conn)
 		if err != nil {
-			log.WithError(err).Warning("Error while reading packet")
+			netLog.WithError(err).Warning("Error while reading packet")
 			return
 		}
 
 		// send the received packet for further processing
-		c.incomingChan <- msg
+		select {
+		case c.incomingChan <- msg:
+		case <-c.closeChan:
+			return
+		}
 	}
 }
 
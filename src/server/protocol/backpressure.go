@@ -0,0 +1,89 @@
+/*
+ * Surviveler protocol package
+ * slow clients, that can't keep up with the game messages
+ */
+package protocol
+
+import (
+	"server/messages"
+	"server/network"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/*
+ * DefaultMaxDroppedSends is the number of consecutive messages a client can
+ * miss, its send queue being full, before it gets disconnected
+ */
+const DefaultMaxDroppedSends = 50
+
+/*
+ * SetMaxDroppedSends sets the number of consecutive messages a client can
+ * miss, because it doesn't read them fast enough, before it gets
+ * disconnected. 0 never disconnects the slow clients.
+ */
+func (reg *ClientRegistry) SetMaxDroppedSends(n int) {
+	reg.dropMutex.Lock()
+	defer reg.dropMutex.Unlock()
+	reg.maxDropped = n
+}
+
+/*
+ * trySend queues msg on the send queue of client, without ever waiting: if
+ * the queue is full, the message is dropped for this client. tooSlow reports
+ * if the client has dropped too many messages in a row and must be
+ * disconnected.
+ */
+func (reg *ClientRegistry) trySend(id uint32, client *network.Conn, msg *messages.Message) (dropped, tooSlow bool) {
+	err := client.AsyncSendPacket(msg, 0)
+	if client.IsClosed() {
+		return false, false
+	}
+
+	reg.dropMutex.Lock()
+	defer reg.dropMutex.Unlock()
+	if err != network.ErrBlockingWrite {
+		delete(reg.dropped, id)
+		return false, false
+	}
+	reg.dropped[id]++
+	reg.droppedTotal++
+	count := reg.dropped[id]
+	if count == 1 {
+		protoLog.WithField("clientID", id).Warning("Client send queue is full, dropping messages")
+	}
+	return true, reg.maxDropped > 0 && count == reg.maxDropped
+}
+
+/*
+ * DroppedSends returns the number of consecutive messages a client has
+ * missed so far
+ */
+func (reg *ClientRegistry) DroppedSends(id uint32) int {
+	reg.dropMutex.Lock()
+	defer reg.dropMutex.Unlock()
+	return reg.dropped[id]
+}
+
+/*
+ * TotalDroppedSends returns the number of messages missed by all the clients
+ * so far, a message missed by n clients counting n times
+ */
+func (reg *ClientRegistry) TotalDroppedSends() uint64 {
+	reg.dropMutex.Lock()
+	defer reg.dropMutex.Unlock()
+	return reg.droppedTotal
+}
+
+/*
+ * dropSlowClients disconnects the clients that can't keep up with the game
+ * messages
+ */
+func (reg *ClientRegistry) dropSlowClients(slow []*network.Conn) {
+	for _, c := range slow {
+		id := c.GetUserData().(ClientData).Id
+		protoLog.WithFields(log.Fields{"clientID": id, "dropped": reg.DroppedSends(id)}).
+			Warning("Disconnecting a client too slow to keep up")
+		reg.Leave("Too slow to keep up with the game", c)
+	}
+}
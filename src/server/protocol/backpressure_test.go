@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"server/messages"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientRegistry_SlowClient(t *testing.T) {
+	srv, clients, wg, _, left := testSessionServer(0)
+	defer func() {
+		srv.Stop()
+		wg.Wait()
+	}()
+	clients.SetMaxDroppedSends(10)
+	fast, fastStay := testJoin(t, srv, "fast", "")
+	defer fast.Close()
+	// the slow client never reads what it's sent
+	slow, slowStay := testJoin(t, srv, "slow", "")
+	defer slow.Close()
+
+	// the fast client counts the broadcast chats, until the last one
+	received := make(chan int)
+	go func() {
+		var n int
+		fast.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for {
+			msg, err := messages.ReadMessage(fast)
+			if err != nil {
+				received <- -1
+				return
+			}
+			if msg.Type != messages.ChatId {
+				continue
+			}
+			n++
+			if chat := messages.GetFactory().Decode(msg).(messages.Chat); chat.Text == "last" {
+				received <- n
+				return
+			}
+		}
+	}()
+
+	// big messages fill the socket buffers of the slow client quickly
+	big := messages.New(messages.ChatId, messages.Chat{Text: strings.Repeat("zombie! ", 8192)})
+	var sent int
+	for ; sent < 2000 && clients.Len() == 2; sent++ {
+		clients.Broadcast(big)
+		time.Sleep(time.Millisecond)
+	}
+	if clients.Len() != 1 {
+		t.Fatalf("slow client still connected after %d broadcasts", sent)
+	}
+	expectId(t, "slow client left", left, slowStay.Id)
+
+	// the fast client received everything, the broadcasts never blocked
+	clients.Broadcast(messages.New(messages.ChatId, messages.Chat{Text: "last"}))
+	if n := <-received; n != sent+1 {
+		t.Errorf("fast client received %d broadcasts, want %d", n, sent+1)
+	}
+	if n := clients.DroppedSends(fastStay.Id); n != 0 {
+		t.Errorf("fast client missed %d messages", n)
+	}
+	if n := clients.TotalDroppedSends(); n < 10 {
+		t.Errorf("%d missed messages in total, want at least the 10 of the slow client", n)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Surviveler protocol package
+ * chat messages
+ */
+package protocol
+
+import (
+	"server/messages"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxChatLength = 200         // max number of characters of a chat message
+	chatBurst     = 5           // number of chat messages a client can send in a row
+	chatPeriod    = time.Second // time needed by a client to earn back one chat message
+)
+
+// words masked with asterisks in chat messages
+var chatBannedWords = map[string]bool{
+	"asshole": true,
+	"bastard": true,
+	"bitch":   true,
+	"cunt":    true,
+	"fuck":    true,
+	"shit":    true,
+}
+
+/*
+ * chatLimiter prevents a client from flooding the chat.
+ *
+ * It's a token bucket: a client can send chatBurst messages in a row, then
+ * one message per chatPeriod.
+ */
+type chatLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+func newChatLimiter(now time.Time) *chatLimiter {
+	return &chatLimiter{tokens: chatBurst, last: now}
+}
+
+/*
+ * allow reports whether a message sent at t is allowed
+ */
+func (cl *chatLimiter) allow(t time.Time) bool {
+	cl.tokens += float64(t.Sub(cl.last)) / float64(chatPeriod)
+	if cl.tokens > chatBurst {
+		cl.tokens = chatBurst
+	}
+	cl.last = t
+	if cl.tokens < 1 {
+		return false
+	}
+	cl.tokens--
+	return true
+}
+
+/*
+ * sanitizeChat removes the control characters and the surrounding spaces of
+ * a chat text, truncates it to MaxChatLength characters and masks the banned
+ * words
+ */
+func sanitizeChat(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		text = strings.TrimSpace(string([]rune(text)[:MaxChatLength]))
+	}
+
+	// mask the banned words, letter by letter
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && unicode.IsLetter(runes[j]) {
+			j++
+		}
+		if chatBannedWords[strings.ToLower(string(runes[i:j]))] {
+			for k := i; k < j; k++ {
+				runes[k] = '*'
+			}
+		}
+		i = j
+	}
+	return string(runes)
+}
+
+/*
+ * allowChat reports whether the client is allowed to send a chat message at t
+ */
+func (reg *ClientRegistry) allowChat(id uint32, t time.Time) bool {
+	reg.chatMutex.Lock()
+	defer reg.chatMutex.Unlock()
+
+	cl, ok := reg.chats[id]
+	if !ok {
+		cl = newChatLimiter(t)
+		reg.chats[id] = cl
+	}
+	return cl.allow(t)
+}
+
+/*
+ * OnAreaChat sets the function called to deliver the chat messages sent on
+ * the area channel. If not set, they are delivered to every client.
+ */
+func (srv *Server) OnAreaChat(fn func(chat messages.Chat)) {
+	srv.areaChatCb = fn
+}
+
+/*
+ * onChat filters a chat message sent by a client, then delivers it
+ */
+func (srv *Server) onChat(clientData ClientData, chat messages.Chat) {
+	ctxLog := protoLog.WithField("clientID", clientData.Id)
+	if !clientData.Joined {
+		ctxLog.Warning("Chat message from a client that hasn't joined")
+		return
+	}
+	if !srv.clients.allowChat(clientData.Id, time.Now()) {
+		ctxLog.Warning("Chat flood, message dropped")
+		return
+	}
+	if chat.Text = sanitizeChat(chat.Text); len(chat.Text) == 0 {
+		return
+	}
+	chat.Id = clientData.Id
+
+	if chat.Channel == messages.ChatArea && srv.areaChatCb != nil {
+		srv.areaChatCb(chat)
+		return
+	}
+	chat.Channel = messages.ChatAll
+	srv.Broadcast(messages.New(messages.ChatId, chat))
+}
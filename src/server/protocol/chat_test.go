@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"net"
+	"server/messages"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeChat(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"untouched", "hello, world!", "hello, world!"},
+		{"spaces", "  hello \t", "hello"},
+		{"control characters", "hel\x00lo\nworld\x1b", "helloworld"},
+		{"banned word", "oh Shit, zombies!", "oh ****, zombies!"},
+		{"banned word inside another", "shitake", "shitake"},
+		{"too long", strings.Repeat("é", MaxChatLength+10), strings.Repeat("é", MaxChatLength)},
+		{"blank", " \n ", ""},
+	}
+	for _, tt := range tests {
+		if got := sanitizeChat(tt.text); got != tt.want {
+			t.Errorf("%s: sanitizeChat(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestChatLimiter(t *testing.T) {
+	now := time.Now()
+	cl := newChatLimiter(now)
+	for i := 0; i < chatBurst; i++ {
+		if !cl.allow(now) {
+			t.Fatalf("message %d of the burst not allowed", i)
+		}
+	}
+	if cl.allow(now) {
+		t.Errorf("message allowed after a full burst")
+	}
+	if !cl.allow(now.Add(chatPeriod)) {
+		t.Errorf("message not allowed after a chat period")
+	}
+	if cl.allow(now.Add(chatPeriod)) {
+		t.Errorf("2 messages allowed after a chat period")
+	}
+}
+
+/*
+ * readChats reads the CHAT messages received on conn, until nothing is
+ * received for a while
+ */
+func readChats(conn net.Conn) []messages.Chat {
+	var chats []messages.Chat
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			return chats
+		}
+		if msg.Type == messages.ChatId {
+			var chat messages.Chat
+			messages.Decode(msg, &chat)
+			chats = append(chats, chat)
+		}
+	}
+}
+
+func TestServer_Chat(t *testing.T) {
+	srv, clients, wg, _, _ := testSessionServer(0)
+	connA, stayA := testJoin(t, srv, "John Doe", "")
+	connB, _ := testJoin(t, srv, "Jane Doe", "")
+
+	send := func(text string) {
+		chat := messages.New(messages.ChatId, messages.Chat{Id: 42, Text: text})
+		if _, err := connA.Write(chat.Serialize()); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	// the chat is broadcast, with the sender id set by the server
+	send("what the fuck is that?")
+	chats := readChats(connB)
+	want := messages.Chat{Id: stayA.Id, Text: "what the **** is that?", Channel: messages.ChatAll}
+	if len(chats) != 1 || chats[0] != want {
+		t.Errorf("received chats = %+v, want %+v", chats, want)
+	}
+
+	// spam is dropped, once the burst has been consumed
+	for i := 0; i < 2*chatBurst; i++ {
+		send("spam")
+	}
+	if chats := readChats(connB); len(chats) != chatBurst-1 {
+		t.Errorf("received %d spam messages, want %d", len(chats), chatBurst-1)
+	}
+
+	// disconnect one client at a time, as the other is notified
+	connA.Close()
+	waitClients(clients, 1)
+	connB.Close()
+	waitClients(clients, 0)
+	srv.Stop()
+	wg.Wait()
+}
@@ -0,0 +1,35 @@
+/*
+ * Surviveler protocol package
+ * suspected cheats
+ */
+package protocol
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+/*
+ * FlagCheat records and logs a suspected cheat of a client, reason describes
+ * what gave it away
+ */
+func (reg *ClientRegistry) FlagCheat(id uint32, reason string) {
+	reg.cheatMutex.Lock()
+	reg.cheats[id]++
+	count := reg.cheats[id]
+	reg.cheatMutex.Unlock()
+
+	protoLog.WithFields(log.Fields{
+		"clientID": id,
+		"reason":   reason,
+		"count":    count,
+	}).Warn("Suspected cheat")
+}
+
+/*
+ * Cheats returns the number of suspected cheats of a client
+ */
+func (reg *ClientRegistry) Cheats(id uint32) int {
+	reg.cheatMutex.Lock()
+	defer reg.cheatMutex.Unlock()
+	return reg.cheats[id]
+}
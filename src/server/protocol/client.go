@@ -5,6 +5,7 @@
 package protocol
 
 import (
+	"fmt"
 	"server/messages"
 	"server/network"
 	"sync"
@@ -16,12 +17,33 @@ import (
 /*
  * ClientRegistry manages a list of connections to remote clients.
  *
- * It implements the Handshaker interface.
+ * It implements the Handshaker interface. It's safe for concurrent use, by
+ * the connection goroutines of the server and by the game loop.
  */
 type ClientRegistry struct {
-	clients map[uint32]*network.Conn // one for each client connection
-	mutex   sync.RWMutex             // protect map from concurrent accesses
-	allocId func() uint32
+	clients  map[uint32]*network.Conn // one for each client connection
+	mutex    sync.RWMutex             // protect map from concurrent accesses
+	allocId  func() uint32
+	rtts     map[uint32]*rttTracker // round-trip time of each client
+	rttMutex sync.Mutex             // protect rtts from concurrent accesses
+
+	chats     map[uint32]*chatLimiter // chat flood control of each client
+	chatMutex sync.Mutex              // protect chats from concurrent accesses
+
+	cheats     map[uint32]int // number of suspected cheats of each client
+	cheatMutex sync.Mutex     // protect cheats from concurrent accesses
+
+	maxViewRadius float32 // max view radius granted to the clients, 0 for no limit
+
+	dropped      map[uint32]int // consecutive messages missed by each slow client
+	droppedTotal uint64         // messages missed by all the clients so far
+	maxDropped   int            // missed messages before a slow client is disconnected, 0 for never
+	dropMutex    sync.Mutex     // protect dropped from concurrent accesses
+
+	sessions       map[string]*session // sessions of the joined clients, by token
+	sessionMutex   sync.Mutex          // protect sessions from concurrent accesses
+	gracePeriod    time.Duration       // time left to disconnected clients to resume
+	sessionsClosed bool                // no more sessions can be suspended
 }
 
 /*
@@ -31,6 +53,10 @@ type ClientData struct {
 	Id     uint32
 	Name   string
 	Joined bool
+	Token  string // session token, once joined
+
+	Compression messages.Compression // compression of the payloads sent to the client
+	ViewRadius  float32              // radius around its player within which entities are sent, 0 for no limit
 }
 
 /*
@@ -38,8 +64,15 @@ type ClientData struct {
  */
 func NewClientRegistry(idAllocator func() uint32) *ClientRegistry {
 	return &ClientRegistry{
-		clients: make(map[uint32]*network.Conn, 0),
-		allocId: idAllocator,
+		clients:     make(map[uint32]*network.Conn, 0),
+		allocId:     idAllocator,
+		rtts:        make(map[uint32]*rttTracker),
+		chats:       make(map[uint32]*chatLimiter),
+		cheats:      make(map[uint32]int),
+		dropped:     make(map[uint32]int),
+		maxDropped:  DefaultMaxDroppedSends,
+		sessions:    make(map[string]*session),
+		gracePeriod: DefaultReconnectGracePeriod,
 	}
 }
 
@@ -71,7 +104,11 @@ func (reg *ClientRegistry) register(client *network.Conn) uint32 {
 	//        We will have other problems to solve before this overflows...
 	reg.mutex.Unlock()
 
-	log.WithFields(log.Fields{
+	reg.rttMutex.Lock()
+	reg.rtts[clientId] = newRttTracker()
+	reg.rttMutex.Unlock()
+
+	protoLog.WithFields(log.Fields{
 		"client": clientData,
 		"addr":   client.GetRawConn().RemoteAddr(),
 	}).Info("Accepted a new client")
@@ -83,62 +120,199 @@ func (reg *ClientRegistry) register(client *network.Conn) uint32 {
  * unregister removes client from the registry
  */
 func (reg *ClientRegistry) unregister(clientId uint32) {
-	log.WithField("id", clientId).Debug("Unregister a client")
+	protoLog.WithField("id", clientId).Debug("Unregister a client")
 	// protect client map write
 	reg.mutex.Lock()
 	delete(reg.clients, clientId)
 	reg.mutex.Unlock()
+
+	reg.rttMutex.Lock()
+	delete(reg.rtts, clientId)
+	reg.rttMutex.Unlock()
+
+	reg.chatMutex.Lock()
+	delete(reg.chats, clientId)
+	reg.chatMutex.Unlock()
+
+	reg.cheatMutex.Lock()
+	delete(reg.cheats, clientId)
+	reg.cheatMutex.Unlock()
+
+	reg.dropMutex.Lock()
+	delete(reg.dropped, clientId)
+	reg.dropMutex.Unlock()
 }
 
 /*
- * Broadcast sends a message to all clients
+ * Broadcast sends a message to all clients.
+ *
+ * The message is queued on the send queue of each client, without waiting:
+ * the clients whose queue is full miss it, rather than making the rest of
+ * the world wait, and are disconnected once they have missed too many
+ * messages in a row (see SetMaxDroppedSends). It returns
+ * network.ErrBlockingWrite if any client missed the message.
  */
 func (reg *ClientRegistry) Broadcast(msg *messages.Message) error {
-
+	var (
+		ret  error
+		slow []*network.Conn
+	)
 	// protect client map access (read)
 	reg.mutex.RLock()
-	defer reg.mutex.RUnlock()
+	// the message is compressed at most once per compression mode
+	var variants [messages.CompressionZstd + 1]*messages.Message
+	for id, client := range reg.clients {
+		dropped, tooSlow := reg.trySend(id, client, compressFor(client, msg, &variants))
+		if dropped {
+			ret = network.ErrBlockingWrite
+		}
+		if tooSlow {
+			slow = append(slow, client)
+		}
+	}
+	reg.mutex.RUnlock()
 
-	for _, client := range reg.clients {
-		// we tolerate only a very short delay
-		err := client.AsyncSendPacket(msg, 10*time.Millisecond)
-		if !client.IsClosed() {
-			switch err {
-			case network.ErrClosedConnection:
-				// the connection could still have been closed in the meantime
-				log.WithField("msg", msg).Warning("Client connection already closed")
-			case network.ErrBlockingWrite:
-				// this should not be tolerated, as we can't make the rest of the world wait
-				log.WithError(err).WithField("msg", msg).Error("Blocking broadcast")
-				return err
-			}
+	reg.dropSlowClients(slow)
+	return ret
+}
+
+/*
+ * compressFor returns msg compressed with the compression negotiated by the
+ * client, variants caches the compressed messages by compression mode
+ */
+func compressFor(client *network.Conn, msg *messages.Message, variants *[messages.CompressionZstd + 1]*messages.Message) *messages.Message {
+	c := client.GetUserData().(ClientData).Compression
+	if c == messages.CompressionNone {
+		return msg
+	}
+	if variants[c] == nil {
+		variants[c] = msg.Compress(c)
+	}
+	return variants[c]
+}
+
+/*
+ * negotiateCompression returns the compression requested in a JOIN message,
+ * or no compression if the requested mode is unknown
+ */
+func negotiateCompression(join messages.Join) messages.Compression {
+	c := messages.Compression(join.Compression)
+	if !c.Valid() {
+		protoLog.WithField("compression", join.Compression).Warning("Unknown compression requested, not compressing")
+		return messages.CompressionNone
+	}
+	return c
+}
+
+/*
+ * SetMaxViewRadius sets the max view radius granted to the clients, 0 to
+ * send them every entity. It must be called before the clients join.
+ */
+func (reg *ClientRegistry) SetMaxViewRadius(r float32) {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.maxViewRadius = r
+}
+
+/*
+ * negotiateViewRadius returns the view radius granted to a client for the
+ * radius requested in its JOIN message: the requested radius clamped to the
+ * max view radius, or the max if the client doesn't request any
+ */
+func (reg *ClientRegistry) negotiateViewRadius(join messages.Join) float32 {
+	reg.mutex.RLock()
+	max := reg.maxViewRadius
+	reg.mutex.RUnlock()
+	if max <= 0 || join.ViewRadius <= 0 || join.ViewRadius > max {
+		return max
+	}
+	return join.ViewRadius
+}
+
+/*
+ * Multicast sends a message to the clients having the given ids, the slow
+ * clients missing it, as in Broadcast
+ */
+func (reg *ClientRegistry) Multicast(ids []uint32, msg *messages.Message) {
+	var slow []*network.Conn
+	// protect client map access (read)
+	reg.mutex.RLock()
+	var variants [messages.CompressionZstd + 1]*messages.Message
+	for _, id := range ids {
+		client, ok := reg.clients[id]
+		if !ok {
+			continue
+		}
+		if _, tooSlow := reg.trySend(id, client, compressFor(client, msg, &variants)); tooSlow {
+			slow = append(slow, client)
 		}
 	}
-	return nil
+	reg.mutex.RUnlock()
+
+	reg.dropSlowClients(slow)
 }
 
-func (reg *ClientRegistry) Disconnect(id uint32, reason string) {
+/*
+ * conn returns the connection of the client having the given id
+ */
+func (reg *ClientRegistry) conn(id uint32) (*network.Conn, bool) {
 	// protect client map access (read)
 	reg.mutex.RLock()
 	defer reg.mutex.RUnlock()
-
 	conn, ok := reg.clients[id]
+	return conn, ok
+}
+
+func (reg *ClientRegistry) Disconnect(id uint32, reason string) {
+	conn, ok := reg.conn(id)
 	if !ok {
-		log.WithField("client", id).Error("Uknown client id, can't disconnect him/her")
+		// the client may be disconnected, with a session waiting to be resumed
+		if !reg.expireSession(id) {
+			protoLog.WithField("client", id).Error("Uknown client id, can't disconnect him/her")
+		}
+		return
 	}
 	reg.Leave(reason, conn)
 }
 
 func (reg *ClientRegistry) Kick(id uint32, reason string) {
+	conn, ok := reg.conn(id)
+	if !ok {
+		if !reg.expireSession(id) {
+			protoLog.WithField("client", id).Error("Unknown client id, can't kick him/her")
+		}
+		return
+	}
+	reg.Leave(reason, conn)
+}
+
+/*
+ * Len returns the number of registered clients
+ */
+func (reg *ClientRegistry) Len() int {
 	// protect client map access (read)
 	reg.mutex.RLock()
 	defer reg.mutex.RUnlock()
+	return len(reg.clients)
+}
 
-	conn, ok := reg.clients[id]
-	if !ok {
-		log.WithField("client", id).Error("Unknown client id, can't kick him/her")
+/*
+ * LeaveAll sends a LEAVE message to every client.
+ *
+ * Contrary to Leave, the connections are not closed, in order to let the
+ * clients close them after having received the message.
+ */
+func (reg *ClientRegistry) LeaveAll(reason string) {
+	// protect client map access (read)
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+
+	for id, client := range reg.clients {
+		leave := messages.New(messages.LeaveId, messages.Leave{Id: id, Reason: reason})
+		if err := client.AsyncSendPacket(leave, 5*time.Millisecond); err != nil {
+			protoLog.WithError(err).WithField("clientID", id).Error("LEAVE message couldn't be sent")
+		}
 	}
-	reg.Leave(reason, conn)
 }
 
 /*
@@ -150,9 +324,9 @@ type ClientDataFunc func(ClientData) bool
 /*
  * ForEach runs a provided function, once per each connection, and gives it a
  * copy of the ClientData struct associated to the current connection. The
- * callback method should not call any ClientRegistry. it is guaranteed that the
- * list of connection won't be modified during the ForEach closure. ForEach exits
- * prematurely if the callback returns false.
+ * callback method should not call any ClientRegistry, see Range for that. it
+ * is guaranteed that the list of connection won't be modified during the
+ * ForEach closure. ForEach exits prematurely if the callback returns false.
  */
 func (reg *ClientRegistry) ForEach(cb ClientDataFunc) {
 
@@ -166,12 +340,61 @@ func (reg *ClientRegistry) ForEach(cb ClientDataFunc) {
 	}
 }
 
+/*
+ * Range runs a provided function, once per each connection, on a snapshot of
+ * the ClientData structs taken under lock. Contrary to ForEach, the callback
+ * can call the ClientRegistry, the clients joining or leaving meanwhile not
+ * being visited. Range exits prematurely if the callback returns false.
+ */
+func (reg *ClientRegistry) Range(cb ClientDataFunc) {
+	reg.mutex.RLock()
+	snapshot := make([]ClientData, 0, len(reg.clients))
+	for _, client := range reg.clients {
+		snapshot = append(snapshot, client.GetUserData().(ClientData))
+	}
+	reg.mutex.RUnlock()
+
+	for _, cd := range snapshot {
+		if !cb(cd) {
+			break
+		}
+	}
+}
+
+/*
+ * claimName gives name to the client of connection c, unless another client
+ * has it already. The check and the claim are atomic, so that 2 clients
+ * joining at once can't get the same name. It also returns the names of the
+ * registered clients, by id, as they were before the claim.
+ */
+func (reg *ClientRegistry) claimName(c *network.Conn, name string) (names map[uint32]string, ok bool) {
+	// protect client map and client data accesses (write)
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	names = make(map[uint32]string)
+	for _, client := range reg.clients {
+		cd := client.GetUserData().(ClientData)
+		if cd.Name == name {
+			return nil, false
+		}
+		names[cd.Id] = cd.Name
+	}
+	cd := c.GetUserData().(ClientData)
+	cd.Name = name
+	c.SetUserData(cd)
+	return names, true
+}
+
 /*
  * Leave sends a LEAVE message to the client associated to given connection
  */
 func (reg *ClientRegistry) Leave(reason string, c *network.Conn) {
 	clientData := c.GetUserData().(ClientData)
 
+	// a client that has been asked to leave can't come back
+	reg.closeSession(clientData.Token)
+
 	// send LEAVE to client
 	leave := messages.New(messages.LeaveId, messages.Leave{
 		Id:     uint32(clientData.Id),
@@ -181,9 +404,9 @@ func (reg *ClientRegistry) Leave(reason string, c *network.Conn) {
 		// either the client received the LEAVE or not, we will close the
 		// connection afterwards, so there's nothing more to do in order to
 		// gracefully handle this error
-		log.WithError(err).WithField("clientID", clientData.Id).Error("LEAVE message couldn't be sent")
+		protoLog.WithError(err).WithField("clientID", clientData.Id).Error("LEAVE message couldn't be sent")
 	} else {
-		log.WithField("clientID", clientData.Id).Info("LEAVE message has been sent")
+		protoLog.WithField("clientID", clientData.Id).Info("LEAVE message has been sent")
 	}
 
 	// TODO: Remove this: the client should remain alive even when the connection
@@ -198,49 +421,82 @@ func (reg *ClientRegistry) Leave(reason string, c *network.Conn) {
 	}()
 }
 
-func (reg *ClientRegistry) Join(join messages.Join, c *network.Conn) bool {
+func (reg *ClientRegistry) Join(join messages.Join, c *network.Conn) JoinResult {
 	clientData := c.GetUserData().(ClientData)
 
-	log.WithFields(log.Fields{"name": join.Name, "clientData": clientData}).Info("Received JOIN from client")
+	protoLog.WithFields(log.Fields{"name": join.Name, "clientData": clientData}).Info("Received JOIN from client")
 
 	// client already JOINED?
 	if clientData.Joined {
 		reg.Leave("Joined already received", c)
-		return false
+		return JoinRejected
+	}
+
+	// protocol version compatibility
+	if join.Version < MinProtocolVersion {
+		reg.Leave(fmt.Sprintf("Protocol version %d is too old, the server requires at least version %d",
+			join.Version, MinProtocolVersion), c)
+		return JoinRejected
+	}
+	if join.Version > MaxProtocolVersion {
+		reg.Leave(fmt.Sprintf("Protocol version %d is too new, the server supports up to version %d",
+			join.Version, MaxProtocolVersion), c)
+		return JoinRejected
+	}
+
+	// resume a previous session?
+	if len(join.Token) > 0 {
+		if s, ok := reg.resumeSession(join.Token); ok {
+			if !reg.resume(s, join, c) {
+				return JoinRejected
+			}
+			return JoinResumed
+		}
+		protoLog.WithField("name", join.Name).Info("Unknown or expired session, joining as a new player")
 	}
 
 	// name length condition
 	if len(join.Name) < 3 {
 		reg.Leave("Name is too short", c)
-		return false
+		return JoinRejected
 	}
 
-	// name already taken?
-	nameTaken := false
-	playerNames := make(map[uint32]string)
-
-	// compute the list of joined players, populating the STAY message and
-	// checking if name is taken as well
-	reg.ForEach(func(cd ClientData) bool {
-		nameTaken = cd.Name == join.Name
-		playerNames[cd.Id] = cd.Name
-		// stop iteration if name is taken
-		return !nameTaken
-	})
-
-	if nameTaken {
+	// name already taken? if not, compute the list of the other players,
+	// populating the STAY message
+	playerNames, ok := reg.claimName(c, join.Name)
+	if !ok {
 		reg.Leave("Name is already taken", c)
-		return false
+		return JoinRejected
 	}
 
+	// open the session, allowing the client to resume after a disconnection
+	clientData.Name = join.Name
+	token, err := reg.openSession(clientData)
+	if err != nil {
+		// a guessable token would let anyone take the player over
+		protoLog.WithError(err).Error("Couldn't generate session token")
+		reg.Leave("Couldn't open a session", c)
+		return JoinRejected
+	}
+	clientData.Token = token
+	clientData.Compression = negotiateCompression(join)
+	clientData.ViewRadius = reg.negotiateViewRadius(join)
+
 	// create and send STAY to the new client
-	stay := messages.Stay{Id: clientData.Id, Players: playerNames}
-	err := c.AsyncSendPacket(messages.New(messages.StayId, stay), time.Second)
+	stay := messages.Stay{
+		Id:          clientData.Id,
+		Players:     playerNames,
+		Token:       clientData.Token,
+		Compression: uint8(clientData.Compression),
+		ViewRadius:  clientData.ViewRadius,
+	}
+	err = c.AsyncSendPacket(messages.New(messages.StayId, stay), time.Second)
 	if err != nil {
 		// handle error in case we couldn't send the STAY message
-		log.WithError(err).Error("Couldn't send STAY message to the new client")
+		protoLog.WithError(err).Error("Couldn't send STAY message to the new client")
+		reg.closeSession(clientData.Token)
 		reg.Leave("Couldn't finish handshaking", c)
-		return false
+		return JoinRejected
 	}
 
 	// fill a JOINED message
@@ -250,12 +506,11 @@ func (reg *ClientRegistry) Join(join messages.Join, c *network.Conn) bool {
 		Type: join.Type,
 	}
 
-	log.WithField("joined", joined).Info("Tell to the world this client has joined")
+	protoLog.WithField("joined", joined).Info("Tell to the world this client has joined")
 	reg.Broadcast(messages.New(messages.JoinedId, joined))
 
 	// at this point we consider the client as accepted
 	clientData.Joined = true
-	clientData.Name = join.Name
 	c.SetUserData(clientData)
-	return true
+	return JoinAccepted
 }
@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"server/messages"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+ * joinAndLeave connects to srv and joins with name, then increments answered
+ * once the server answered, and closes the connection once leave is closed.
+ * It returns true if the client has been accepted.
+ */
+func joinAndLeave(srv *Server, name string, answered *int32, leave chan struct{}) (bool, error) {
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	join := messages.New(messages.JoinId, messages.Join{Name: name, Version: MaxProtocolVersion})
+	if _, err := conn.Write(join.Serialize()); err != nil {
+		return false, err
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			return false, err
+		}
+		switch msg.Type {
+		case messages.StayId:
+			atomic.AddInt32(answered, 1)
+			<-leave
+			return true, nil
+		case messages.LeaveId:
+			atomic.AddInt32(answered, 1)
+			return false, nil
+		}
+	}
+}
+
+func TestClientRegistry_Concurrency(t *testing.T) {
+	const (
+		players = 30
+		twins   = 5 // clients joining at once with the same name
+	)
+	var (
+		wg     sync.WaitGroup
+		nextId uint32
+	)
+	clients := NewClientRegistry(func() uint32 {
+		nextId++
+		return nextId
+	})
+	srv := NewServer("0", clients, nil, &wg, clients)
+	srv.Start()
+	defer func() {
+		srv.Stop()
+		wg.Wait()
+	}()
+
+	// hammer the registry as the game loop would, while the clients come
+	// and go
+	done := make(chan struct{})
+	var hammering sync.WaitGroup
+	hammering.Add(1)
+	go func() {
+		defer hammering.Done()
+		ping := messages.New(messages.PingId, messages.Ping{Id: 1})
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			clients.Broadcast(ping)
+			var ids []uint32
+			clients.Range(func(cd ClientData) bool {
+				ids = append(ids, cd.Id)
+				clients.RTT(cd.Id)
+				clients.Len()
+				return true
+			})
+			clients.Multicast(ids, ping)
+			clients.ForEach(func(cd ClientData) bool { return cd.Joined || true })
+			clients.PingAll()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var (
+		joining  sync.WaitGroup
+		accepted int32 // twins accepted
+		answered int32 // clients accepted or rejected
+		leave    = make(chan struct{})
+	)
+	for i := 0; i < players+twins; i++ {
+		joining.Add(1)
+		go func(i int) {
+			defer joining.Done()
+			name := fmt.Sprintf("player %d", i)
+			if i >= players {
+				name = "twin"
+			}
+			ok, err := joinAndLeave(srv, name, &answered, leave)
+			switch {
+			case err != nil:
+				t.Errorf("%s: %v", name, err)
+			case ok && i >= players:
+				atomic.AddInt32(&accepted, 1)
+			case !ok && i < players:
+				t.Errorf("%s has been rejected", name)
+			}
+		}(i)
+	}
+	// the clients leave once they have all joined, or been rejected
+	for i := 0; i < 100 && atomic.LoadInt32(&answered) < players+twins; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(leave)
+	joining.Wait()
+	close(done)
+	hammering.Wait()
+
+	if accepted != 1 {
+		t.Errorf("%d clients joined as 'twin', want 1", accepted)
+	}
+	waitClients(clients, 0)
+	if n := clients.Len(); n != 0 {
+		t.Errorf("%d clients left in the registry, want 0", n)
+	}
+}
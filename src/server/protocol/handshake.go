@@ -13,15 +13,35 @@ import (
 	"github.com/urfave/cli"
 )
 
+/*
+ * Range of protocol versions supported by the server. The protocol version is
+ * sent by the clients in the JOIN message.
+ */
+const (
+	MinProtocolVersion = 1
+	MaxProtocolVersion = 1
+)
+
+/*
+ * JoinResult is the outcome of a JOIN
+ */
+type JoinResult int
+
+const (
+	JoinRejected JoinResult = iota // the client has been asked to leave
+	JoinAccepted                   // the client joins the game as a new player
+	JoinResumed                    // the client is back to its player
+)
+
 // an Handshaker impements the server-side part of the handshaking protocol.
 type Handshaker interface {
 
 	// Join should process the JOIN message of the Handshaking protocol, checks
 	// that the conditions are met in order to accept the emitter for the JOIN
-	// message. If that is the case, true is returned. In both cases, it should
-	// take care of the required steps in order to follow the defined
-	// handshaking protocol (i.e send/broadcast messages, etc.).
-	Join(join messages.Join, c *network.Conn) bool
+	// message, either as a new player or as a player resuming its session. In
+	// all cases, it should take care of the required steps in order to follow
+	// the defined handshaking protocol (i.e send/broadcast messages, etc.).
+	Join(join messages.Join, c *network.Conn) JoinResult
 
 	// Leave executes the LEAVE step of the Handshaking protocol on the client
 	// associtated to the connection c.
@@ -41,7 +61,7 @@ func registerTelnetCommands(tns *TelnetServer, registry *ClientRegistry) {
 		},
 		Action: func(c *cli.Context) error {
 			clientId := uint32(c.Int("id"))
-			if connection, ok := registry.clients[clientId]; ok {
+			if connection, ok := registry.conn(clientId); ok {
 				registry.Leave("telnet just kicked your ass out", connection)
 				io.WriteString(c.App.Writer,
 					fmt.Sprintf("client %v has been kicked out\n", clientId))
@@ -59,8 +79,12 @@ func registerTelnetCommands(tns *TelnetServer, registry *ClientRegistry) {
 		Usage: "shows the list of connected clients",
 		Action: func(c *cli.Context) error {
 			io.WriteString(c.App.Writer, fmt.Sprintf("connected clients:\n"))
-			registry.ForEach(func(client ClientData) bool {
-				io.WriteString(c.App.Writer, fmt.Sprintf(" * %v - %v\n", client.Name, client.Id))
+			registry.Range(func(client ClientData) bool {
+				rtt := "unknown"
+				if d, ok := registry.RTT(client.Id); ok {
+					rtt = d.String()
+				}
+				io.WriteString(c.App.Writer, fmt.Sprintf(" * %v - %v (rtt: %v)\n", client.Name, client.Id, rtt))
 				return true
 			})
 			return nil
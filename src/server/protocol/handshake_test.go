@@ -0,0 +1,205 @@
+package protocol
+
+import (
+	"net"
+	"server/messages"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientRegistry_JoinProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint16
+		reason  string // expected LEAVE reason, empty if the client is accepted
+	}{
+		{"matching", MaxProtocolVersion, ""},
+		{"too old", MinProtocolVersion - 1, "too old"},
+		{"too new", MaxProtocolVersion + 1, "too new"},
+	}
+
+	var (
+		wg     sync.WaitGroup
+		nextId uint32
+	)
+	clients := NewClientRegistry(func() uint32 {
+		nextId++
+		return nextId
+	})
+	srv := NewServer("0", clients, nil, &wg, clients)
+	joined := make(chan uint32, len(tests))
+	srv.OnPlayerJoined(func(id uint32, playerType uint8) {
+		joined <- id
+	})
+	srv.Start()
+
+	var conns []net.Conn
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		join := messages.New(messages.JoinId, messages.Join{
+			Name:    "player " + tt.name,
+			Version: tt.version,
+		})
+		if _, err := conn.Write(join.Serialize()); err != nil {
+			t.Fatalf("%s: Write() error = %v", tt.name, err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("%s: ReadMessage() error = %v", tt.name, err)
+		}
+		switch {
+		case tt.reason == "" && msg.Type != messages.StayId:
+			t.Errorf("%s: got %v, want the client to be accepted with a STAY", tt.name, msg.Type)
+		case tt.reason != "" && msg.Type != messages.LeaveId:
+			t.Errorf("%s: got %v, want the client to be rejected with a LEAVE", tt.name, msg.Type)
+		case tt.reason != "":
+			var leave messages.Leave
+			messages.Decode(msg, &leave)
+			if !strings.Contains(leave.Reason, tt.reason) {
+				t.Errorf("%s: LEAVE reason = %q, want it to contain %q", tt.name, leave.Reason, tt.reason)
+			}
+		}
+		conns = append(conns, conn)
+
+		if tt.reason == "" {
+			select {
+			case <-joined:
+			case <-time.After(time.Second):
+				t.Errorf("%s: the player joined callback hasn't been called", tt.name)
+			}
+		}
+	}
+
+	if len(joined) != 0 {
+		t.Errorf("%d rejected clients have joined the game", len(joined))
+	}
+
+	// wait for the rejected clients to be kicked, before disconnecting
+	waitClients := func(n int) {
+		for i := 0; i < 100 && clients.Len() != n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitClients(1)
+	for _, conn := range conns {
+		conn.Close()
+	}
+	waitClients(0)
+	srv.Stop()
+	wg.Wait()
+}
+
+func TestClientRegistry_JoinCompression(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested uint8
+		want      messages.Compression
+	}{
+		{"none", uint8(messages.CompressionNone), messages.CompressionNone},
+		{"zstd", uint8(messages.CompressionZstd), messages.CompressionZstd},
+		{"unknown", 7, messages.CompressionNone},
+	}
+
+	var (
+		wg     sync.WaitGroup
+		nextId uint32
+	)
+	clients := NewClientRegistry(func() uint32 {
+		nextId++
+		return nextId
+	})
+	srv := NewServer("0", clients, nil, &wg, clients)
+	srv.Start()
+
+	var conns []net.Conn
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		conns = append(conns, conn)
+		join := messages.New(messages.JoinId, messages.Join{
+			Name:        "player " + tt.name,
+			Version:     MaxProtocolVersion,
+			Compression: tt.requested,
+		})
+		if _, err := conn.Write(join.Serialize()); err != nil {
+			t.Fatalf("%s: Write() error = %v", tt.name, err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		msg, err := messages.ReadMessage(conn)
+		if err != nil || msg.Type != messages.StayId {
+			t.Fatalf("%s: ReadMessage() = %v, %v, want a STAY", tt.name, msg, err)
+		}
+		var stay messages.Stay
+		messages.Decode(msg, &stay)
+		if got := messages.Compression(stay.Compression); got != tt.want {
+			t.Errorf("%s: STAY compression = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// broadcast a message big enough to be compressed
+	chat := messages.Chat{Text: strings.Repeat("brains ", 200)}
+	srv.Broadcast(messages.New(messages.ChatId, chat))
+	for i, tt := range tests {
+		var msg *messages.Message
+		for {
+			conns[i].SetReadDeadline(time.Now().Add(time.Second))
+			var err error
+			if msg, err = messages.ReadMessage(conns[i]); err != nil {
+				t.Fatalf("%s: ReadMessage() error = %v", tt.name, err)
+			}
+			if msg.Type&^messages.CompressedFlag == messages.ChatId {
+				break
+			}
+		}
+		if compressed := tt.want != messages.CompressionNone; msg.Compressed() != compressed {
+			t.Errorf("%s: broadcast compressed = %v, want %v", tt.name, msg.Compressed(), compressed)
+		}
+		msg, err := msg.Decompress(tt.want)
+		if err != nil {
+			t.Fatalf("%s: Decompress() error = %v", tt.name, err)
+		}
+		var got messages.Chat
+		if messages.Decode(msg, &got); got.Text != chat.Text {
+			t.Errorf("%s: broadcast chat text differs from the original", tt.name)
+		}
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	for i := 0; i < 100 && clients.Len() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.Stop()
+	wg.Wait()
+}
+
+func TestClientRegistry_negotiateViewRadius(t *testing.T) {
+	tests := []struct {
+		max, requested, want float32
+	}{
+		{0, 0, 0},
+		{0, 30, 0},
+		{20, 0, 20},
+		{20, 10, 10},
+		{20, 30, 20},
+		{20, -1, 20},
+	}
+	for _, tt := range tests {
+		clients := NewClientRegistry(func() uint32 { return 1 })
+		clients.SetMaxViewRadius(tt.max)
+		if got := clients.negotiateViewRadius(messages.Join{ViewRadius: tt.requested}); got != tt.want {
+			t.Errorf("max %v: negotiateViewRadius(%v) = %v, want %v", tt.max, tt.requested, got, tt.want)
+		}
+	}
+}
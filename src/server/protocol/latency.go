@@ -0,0 +1,155 @@
+/*
+ * Surviveler protocol package
+ * clients latency measurement
+ */
+package protocol
+
+import (
+	"server/messages"
+	"time"
+)
+
+const (
+	PingPeriod      = 2 * time.Second // period at which the clients are pinged
+	maxPendingPings = 5               // pings awaiting a pong, older ones are considered lost
+	rttAlpha        = 0.125           // weight of a new sample in the smoothed RTT
+)
+
+/*
+ * rttTracker measures the round-trip time of a client.
+ *
+ * The round-trip time is smoothed with an exponentially weighted moving
+ * average, as TCP does.
+ */
+type rttTracker struct {
+	nextId   uint32
+	pending  map[uint32]time.Time // sending time of the pings awaiting a pong
+	srtt     time.Duration        // smoothed round-trip time
+	measured bool                 // at least one round-trip has been measured
+}
+
+func newRttTracker() *rttTracker {
+	return &rttTracker{pending: make(map[uint32]time.Time)}
+}
+
+/*
+ * ping records a ping sent at t and returns its id
+ */
+func (rt *rttTracker) ping(t time.Time) uint32 {
+	rt.nextId++
+	rt.pending[rt.nextId] = t
+	// forget the ping that has most likely been lost
+	delete(rt.pending, rt.nextId-maxPendingPings)
+	return rt.nextId
+}
+
+/*
+ * pong records the reception at t of the pong answering the ping id, and
+ * returns the measured round-trip time. It returns false if the pong doesn't
+ * answer a pending ping.
+ */
+func (rt *rttTracker) pong(id uint32, t time.Time) (time.Duration, bool) {
+	sent, ok := rt.pending[id]
+	if !ok {
+		return 0, false
+	}
+	delete(rt.pending, id)
+
+	rtt := t.Sub(sent)
+	if rt.measured {
+		rt.srtt += time.Duration(rttAlpha * float64(rtt-rt.srtt))
+	} else {
+		rt.srtt = rtt
+		rt.measured = true
+	}
+	return rtt, true
+}
+
+/*
+ * PingAll sends a PING to every client, in order to measure their round-trip
+ * time
+ */
+func (reg *ClientRegistry) PingAll() {
+	// protect client map access (read)
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+	reg.rttMutex.Lock()
+	defer reg.rttMutex.Unlock()
+
+	now := time.Now()
+	for id, client := range reg.clients {
+		rt, ok := reg.rtts[id]
+		if !ok {
+			continue
+		}
+		ping := messages.New(messages.PingId, messages.Ping{
+			Id:     rt.ping(now),
+			Tstamp: now.UnixNano() / int64(time.Millisecond),
+		})
+		if err := client.AsyncSendPacket(ping, 5*time.Millisecond); err != nil {
+			protoLog.WithError(err).WithField("clientID", id).Warning("PING message couldn't be sent")
+		}
+	}
+}
+
+/*
+ * onPong records the reception of a PONG sent by a client, in response to
+ * a PING sent by PingAll
+ */
+func (reg *ClientRegistry) onPong(id uint32, pong messages.Pong, t time.Time) {
+	reg.rttMutex.Lock()
+	defer reg.rttMutex.Unlock()
+
+	rt, ok := reg.rtts[id]
+	if !ok {
+		return
+	}
+	if rtt, ok := rt.pong(pong.Id, t); ok {
+		protoLog.WithField("clientID", id).WithField("rtt", rtt).Debug("Measured client round-trip time")
+	} else {
+		protoLog.WithField("clientID", id).WithField("pong", pong).Warning("Unexpected PONG")
+	}
+}
+
+/*
+ * RTT returns the smoothed round-trip time of a client. It returns false if
+ * it hasn't been measured yet.
+ */
+func (reg *ClientRegistry) RTT(id uint32) (time.Duration, bool) {
+	reg.rttMutex.Lock()
+	defer reg.rttMutex.Unlock()
+
+	rt, ok := reg.rtts[id]
+	if !ok || !rt.measured {
+		return 0, false
+	}
+	return rt.srtt, true
+}
+
+/*
+ * pingClients periodically pings the clients, until the server is stopped
+ */
+func (srv *Server) pingClients() {
+	defer close(srv.pingDone)
+	ticker := time.NewTicker(srv.pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-srv.stopPing:
+			return
+		case <-ticker.C:
+			srv.clients.PingAll()
+		}
+	}
+}
+
+/*
+ * stopPinging stops pinging the clients, and waits for the last PING to have
+ * been sent
+ */
+func (srv *Server) stopPinging() {
+	srv.stopPingOnce.Do(func() {
+		close(srv.stopPing)
+		<-srv.pingDone
+	})
+}
@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"net"
+	"server/messages"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRttTracker_SmoothedRTT(t *testing.T) {
+	rt := newRttTracker()
+	t0 := time.Unix(0, 0)
+
+	delays := []time.Duration{
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+	}
+	srtts := []time.Duration{
+		50 * time.Millisecond,
+		56250 * time.Microsecond,
+		61718750 * time.Nanosecond,
+	}
+	for i, delay := range delays {
+		id := rt.ping(t0)
+		rtt, ok := rt.pong(id, t0.Add(delay))
+		if !ok || rtt != delay {
+			t.Fatalf("pong(%d) = %v, %v, want %v, true", id, rtt, ok, delay)
+		}
+		if rt.srtt != srtts[i] {
+			t.Errorf("after %d samples, smoothed RTT = %v, want %v", i+1, rt.srtt, srtts[i])
+		}
+	}
+}
+
+func TestRttTracker_UnexpectedPongs(t *testing.T) {
+	rt := newRttTracker()
+	t0 := time.Unix(0, 0)
+
+	id := rt.ping(t0)
+	if _, ok := rt.pong(id+1, t0); ok {
+		t.Errorf("pong() = true for an unknown ping")
+	}
+	rt.pong(id, t0.Add(time.Millisecond))
+	if _, ok := rt.pong(id, t0.Add(time.Millisecond)); ok {
+		t.Errorf("pong() = true for an already answered ping")
+	}
+
+	// lost pings are eventually forgotten
+	lost := rt.ping(t0)
+	for i := 0; i < maxPendingPings; i++ {
+		rt.ping(t0)
+	}
+	if _, ok := rt.pong(lost, t0.Add(time.Second)); ok {
+		t.Errorf("pong() = true for a ping considered lost")
+	}
+	if len(rt.pending) != maxPendingPings {
+		t.Errorf("got %d pending pings, want %d", len(rt.pending), maxPendingPings)
+	}
+}
+
+/*
+ * pongClient answers the server pings after the given delay, until the
+ * connection is closed
+ */
+func pongClient(conn net.Conn, delay time.Duration) {
+	defer conn.Close()
+	for {
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if msg.Type != messages.PingId {
+			continue
+		}
+		var ping messages.Ping
+		if err := messages.Decode(msg, &ping); err != nil {
+			return
+		}
+		time.Sleep(delay)
+		pong := messages.New(messages.PongId, messages.Pong{Id: ping.Id, Tstamp: ping.Tstamp})
+		if _, err := conn.Write(pong.Serialize()); err != nil {
+			return
+		}
+	}
+}
+
+func TestServer_MeasuresClientRTT(t *testing.T) {
+	var (
+		wg     sync.WaitGroup
+		nextId uint32
+	)
+	clients := NewClientRegistry(func() uint32 {
+		nextId++
+		return nextId
+	})
+	srv := NewServer("0", clients, nil, &wg, clients)
+	srv.pingPeriod = 20 * time.Millisecond
+	srv.Start()
+
+	const delay = 30 * time.Millisecond
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	go pongClient(conn, delay)
+
+	// wait for a few round-trips to be measured
+	var (
+		rtt time.Duration
+		ok  bool
+	)
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if rtt, ok = clients.RTT(1); ok && i > 10 {
+			break
+		}
+	}
+	if !ok {
+		t.Fatalf("client RTT hasn't been measured")
+	}
+	if rtt < delay || rtt > delay+200*time.Millisecond {
+		t.Errorf("client RTT = %v, want about %v", rtt, delay)
+	}
+
+	// disconnect the client
+	conn.Close()
+	for i := 0; i < 100 && clients.Len() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := clients.RTT(1); ok {
+		t.Errorf("RTT() = true for a disconnected client")
+	}
+	srv.Stop()
+	wg.Wait()
+}
@@ -0,0 +1,175 @@
+/*
+ * Surviveler protocol package
+ * lobby, routing the clients to the game rooms
+ */
+package protocol
+
+import (
+	"net"
+	"server/messages"
+	"server/network"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/*
+ * Lobby is the TCP server of a process hosting several isolated game rooms,
+ * each room having its own Server and client registry. It implements the
+ * network.ConnEvtHandler interface.
+ *
+ * A connection belongs to no room until its client sends a JOIN message. The
+ * lobby then assigns it a room, and hands it over to the room server, for
+ * which the client has just connected. A client resuming its session goes
+ * back to the room holding it, others go to the room having the fewest
+ * clients.
+ */
+type Lobby struct {
+	port   string
+	server *network.Server
+	rooms  []*Server
+	conns  map[*network.Conn]*Server // room of each assigned connection
+	mutex  sync.Mutex                // protect conns from concurrent accesses
+	wg     *sync.WaitGroup
+	addr   net.Addr // listening address
+}
+
+/*
+ * NewLobby returns a lobby routing the clients to the given room servers,
+ * which must have been started with Run
+ */
+func NewLobby(port string, wg *sync.WaitGroup, rooms ...*Server) *Lobby {
+	return &Lobby{
+		port:  port,
+		rooms: rooms,
+		conns: make(map[*network.Conn]*Server),
+		wg:    wg,
+	}
+}
+
+/*
+ * Start creates the TCP server and starts the listening goroutine
+ */
+func (l *Lobby) Start() {
+	config := &network.ServerCfg{
+		MaxOutgoingChannels: MAX_OUT_CHANNELS,
+		MaxIncomingChannels: MAX_IN_CHANNELS,
+	}
+	l.server = network.NewServer(config, l, &packetReader{})
+
+	listener, err := listenTo(":" + l.port)
+	if err != nil {
+		protoLog.Fatal("can't start lobby")
+	}
+
+	l.addr = listener.Addr()
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.server.Start(listener, time.Second)
+	}()
+	protoLog.WithFields(log.Fields{"addr": l.addr, "rooms": len(l.rooms)}).Info("Lobby ready, listening for incoming connections")
+}
+
+/*
+ * Stop stops the tcp server and closes the connections of all the rooms
+ */
+func (l *Lobby) Stop() {
+	protoLog.Info("Stopping lobby")
+	l.server.Stop()
+}
+
+/*
+ * Addr returns the address the lobby is listening on
+ */
+func (l *Lobby) Addr() net.Addr {
+	return l.addr
+}
+
+/*
+ * OnConnect gets called by the server at connection initialization. The
+ * connection is registered in a room only once its client has joined.
+ */
+func (l *Lobby) OnConnect(c *network.Conn) bool {
+	return true
+}
+
+/*
+ * OnIncomingPacket forwards the packet to the room of the connection, after
+ * having assigned it one if the packet is a JOIN. Packets received from a
+ * client not having joined yet are dropped.
+ */
+func (l *Lobby) OnIncomingPacket(c *network.Conn, packet network.Packet) bool {
+	l.mutex.Lock()
+	room, ok := l.conns[c]
+	l.mutex.Unlock()
+	if ok {
+		return room.OnIncomingPacket(c, packet)
+	}
+
+	raw := packet.(*messages.Message)
+	if raw.Type != messages.JoinId {
+		protoLog.WithField("type", raw.Type.String()).Debug("Dropping message from a client that hasn't joined")
+		return true
+	}
+	var join messages.Join
+	if err := messages.Decode(raw, &join); err != nil {
+		protoLog.WithError(err).Warning("Couldn't decode JOIN message")
+		return false
+	}
+	if room = l.assign(c, join); room == nil {
+		return false
+	}
+	return room.OnIncomingPacket(c, packet)
+}
+
+/*
+ * OnClose gets called by the server at connection closing, the room of the
+ * connection, if any, performs the client cleanup
+ */
+func (l *Lobby) OnClose(c *network.Conn) {
+	l.mutex.Lock()
+	room, ok := l.conns[c]
+	delete(l.conns, c)
+	l.mutex.Unlock()
+	if ok {
+		room.OnClose(c)
+	}
+}
+
+/*
+ * assign chooses the room of a client, and registers the connection in it.
+ * It returns nil if the connection has been closed in the meantime.
+ */
+func (l *Lobby) assign(c *network.Conn, join messages.Join) *Server {
+	idx := -1
+	if len(join.Token) > 0 {
+		for i, r := range l.rooms {
+			if r.clients.hasSuspendedSession(join.Token) {
+				idx = i
+				break
+			}
+		}
+	}
+	if idx < 0 {
+		idx = 0
+		for i, r := range l.rooms {
+			if r.clients.Len() < l.rooms[idx].clients.Len() {
+				idx = i
+			}
+		}
+	}
+	room := l.rooms[idx]
+
+	// registered under lock, so that OnClose can't miss the connection
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if c.IsClosed() {
+		return nil
+	}
+	l.conns[c] = room
+	room.OnConnect(c)
+	protoLog.WithFields(log.Fields{"name": join.Name, "room": idx}).Debug("Client assigned to a room")
+	return room
+}
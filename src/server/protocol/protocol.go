@@ -5,18 +5,14 @@
 package protocol
 
 import (
-	"encoding/binary"
-	"fmt"
 	"net"
+	"server/logging"
 	"server/messages"
 	"server/network"
 )
 
-/*
- * value used to check the length of a client message before allocating
- * a buffer, in case the received size was wrong
- */
-const MaxIncomingMsgLength uint32 = 1279
+// logger of the protocol module
+var protoLog = logging.Module("protocol")
 
 type packetReader struct{}
 
@@ -25,33 +21,5 @@ type packetReader struct{}
  * from network to local byte order.
  */
 func (this *packetReader) ReadPacket(conn *net.TCPConn) (network.Packet, error) {
-	msg := new(messages.Message)
-	var err error
-
-	// Read MsgType
-	err = binary.Read(conn, binary.BigEndian, &msg.Type)
-	if err != nil {
-		return nil, fmt.Errorf("error while reading Message.Type: %v", err)
-	}
-
-	// Read message length
-	err = binary.Read(conn, binary.BigEndian, &msg.Length)
-	if err != nil {
-		return nil, fmt.Errorf("error while reading Message.Length: %v", err)
-	}
-
-	if msg.Length == 0 {
-		return nil, fmt.Errorf("invalid Message.Length: 0")
-	}
-	if msg.Length > MaxIncomingMsgLength {
-		return nil, fmt.Errorf("invalid (too big) Message.Length: %v", msg.Length)
-	}
-
-	// Read payload
-	msg.Payload = make([]byte, msg.Length, msg.Length)
-	err = binary.Read(conn, binary.BigEndian, &msg.Payload)
-	if err != nil {
-		return nil, fmt.Errorf("error while reading payload: %v", err)
-	}
-	return msg, nil
+	return messages.ReadLimitedMessage(conn, messages.MaxIncomingMsgLength)
 }
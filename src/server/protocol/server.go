@@ -29,7 +29,7 @@ type (
  */
 type Server struct {
 	port           string
-	server         network.Server                   // tcp server instance
+	server         *network.Server                  // tcp server instance, nil for a room
 	clients        *ClientRegistry                  // manage the connected clients
 	telnet         *TelnetServer                    // embedded telnet server
 	factory        *messages.Factory                // the unique message factory
@@ -38,6 +38,12 @@ type Server struct {
 	msgHandlers    map[messages.Type]messageHandler // message handlers
 	playerJoinedCb func(uint32, uint8)              // raised after a successfull JOIN
 	playerLeftCb   func(uint32)                     // raised after an effective LEAVE
+	areaChatCb     func(messages.Chat)              // delivers the area chat messages
+	addr           net.Addr                         // listening address
+	pingPeriod     time.Duration                    // period at which clients are pinged
+	stopPing       chan struct{}                    // stops pinging the clients
+	pingDone       chan struct{}                    // closed once pinging has stopped
+	stopPingOnce   sync.Once
 }
 
 /*
@@ -54,6 +60,9 @@ func NewServer(port string, clients *ClientRegistry,
 		wg:          wg,
 		msgHandlers: make(map[messages.Type]messageHandler),
 		handshaker:  handshaker,
+		pingPeriod:  PingPeriod,
+		stopPing:    make(chan struct{}),
+		pingDone:    make(chan struct{}),
 	}
 }
 
@@ -70,12 +79,12 @@ func (srv *Server) RegisterMsgHandler(t messages.Type, fn messageHandler) {
 func listenTo(addr string) (*net.TCPListener, error) {
 	tcpAddr, err := net.ResolveTCPAddr("tcp4", addr)
 	if err != nil {
-		log.WithError(err).Warn("couldn't resolve address")
+		protoLog.WithError(err).Warn("couldn't resolve address")
 		return nil, err
 	}
 	listener, err := net.ListenTCP("tcp", tcpAddr)
 	if err != nil {
-		log.WithError(err).Warn("couldn't initiate TCP listening")
+		protoLog.WithError(err).Warn("couldn't initiate TCP listening")
 		return nil, err
 	}
 	return listener, nil
@@ -90,23 +99,38 @@ func (srv *Server) Start() {
 		MaxOutgoingChannels: MAX_OUT_CHANNELS,
 		MaxIncomingChannels: MAX_IN_CHANNELS,
 	}
-	srv.server = *network.NewServer(config, srv, &packetReader{})
+	srv.server = network.NewServer(config, srv, &packetReader{})
 
 	listener, err := listenTo(":" + srv.port)
 	if err != nil {
-		log.Fatal("can't start server")
+		protoLog.Fatal("can't start server")
 	}
 
 	// starts the server in a listening goroutine
+	srv.addr = listener.Addr()
 	srv.wg.Add(1)
-	go srv.server.Start(listener, time.Second)
-	log.WithField("addr", listener.Addr()).Info("Server ready, listening for incoming connections")
+	go func() {
+		defer srv.wg.Done()
+		srv.server.Start(listener, time.Second)
+	}()
+	protoLog.WithField("addr", srv.addr).Info("Server ready, listening for incoming connections")
+	srv.Run()
+}
+
+/*
+ * Run starts the server without listening for incoming connections, which
+ * is the case of a room server, whose connections are handed over by a
+ * Lobby. Start calls it, after having started listening.
+ */
+func (srv *Server) Run() {
+	// periodically measure the clients latency
+	go srv.pingClients()
 
 	if srv.telnet != nil {
 		// start telnet server if present
 		listener, err := listenTo(":" + srv.telnet.port)
 		if err != nil {
-			log.Fatal("can't start telnet server")
+			protoLog.Fatal("can't start telnet server")
 		}
 		srv.telnet.Start(listener, srv.wg)
 		registerTelnetCommands(srv.telnet, srv.clients)
@@ -133,7 +157,7 @@ func (srv *Server) OnIncomingPacket(c *network.Conn, packet network.Packet) bool
 	clientData := c.GetUserData().(ClientData)
 	raw := packet.(*messages.Message)
 
-	log.WithFields(
+	protoLog.WithFields(
 		log.Fields{
 			"clientData": clientData,
 			"addr":       c.GetRawConn().RemoteAddr(),
@@ -147,7 +171,7 @@ func (srv *Server) OnIncomingPacket(c *network.Conn, packet network.Packet) bool
 	handler, ok := srv.msgHandlers[raw.Type]
 	if ok {
 		if err := handler(c, msg); err != nil {
-			log.WithError(err).Error("Error handling message")
+			protoLog.WithError(err).Error("Error handling message")
 			return false
 		}
 	} else {
@@ -164,20 +188,33 @@ func (srv *Server) OnIncomingPacket(c *network.Conn, packet network.Packet) bool
 					Tstamp: time.Now().UnixNano() / int64(time.Millisecond),
 				})
 			if err := c.AsyncSendPacket(pong, time.Second); err != nil {
-				log.WithError(err).Error("Error handling message")
+				protoLog.WithError(err).Error("Error handling message")
 				return false
 			}
 
+		case messages.PongId:
+
+			// answer to a PING we sent
+			srv.clients.onPong(clientData.Id, msg.(messages.Pong), time.Now())
+
+		case messages.ChatId:
+
+			srv.onChat(clientData, msg.(messages.Chat))
+
 		case messages.JoinId:
 
 			join := msg.(messages.Join)
 			// JOIN is handled by the handshaker
-			if srv.handshaker.Join(join, c) {
+			switch srv.handshaker.Join(join, c) {
+			case JoinAccepted:
 				// new client has been accepted
 				if srv.playerJoinedCb != nil {
 					// raise 'player joined' external callback
 					srv.playerJoinedCb(clientData.Id, join.Type)
 				}
+			case JoinResumed:
+				// the player is still in game, nothing to do
+				protoLog.WithField("clientData", c.GetUserData()).Info("Client resumed its session")
 			}
 		}
 	}
@@ -191,12 +228,29 @@ func (srv *Server) OnIncomingPacket(c *network.Conn, packet network.Packet) bool
  * client cleanup
  */
 func (srv *Server) OnClose(c *network.Conn) {
-	log.WithField("addr", c.GetRawConn().RemoteAddr()).Debug("Connection closed")
+	protoLog.WithField("addr", c.GetRawConn().RemoteAddr()).Debug("Connection closed")
 
 	// unregister the client before anything
 	clientData := c.GetUserData().(ClientData)
 	srv.clients.unregister(clientData.Id)
 
+	if clientData.Joined {
+		// keep the player in game for a while, the client may reconnect
+		resumable := srv.clients.suspendSession(clientData.Token, func() {
+			srv.playerLeft(clientData)
+		})
+		if resumable {
+			protoLog.WithField("clientID", clientData.Id).Info("Client disconnected, waiting for it to resume")
+			return
+		}
+	}
+	srv.playerLeft(clientData)
+}
+
+/*
+ * playerLeft notifies the other clients and the game that a player has left
+ */
+func (srv *Server) playerLeft(clientData ClientData) {
 	if clientData.Joined {
 		// client is still JOINED so that's a disconnection initiated externally
 		// send a LEAVE to the rest of the world
@@ -233,16 +287,47 @@ func (srv *Server) OnPlayerLeft(fn func(ID uint32)) {
 func (srv *Server) Broadcast(msg *messages.Message) error {
 	err := srv.clients.Broadcast(msg)
 	if err != nil {
-		log.WithError(err).Error("Couldn't broadcast")
+		protoLog.WithError(err).Error("Couldn't broadcast")
 	}
 	return err
 }
 
 /*
- * Stop stops the tcp server and the clients connections
+ * Addr returns the address the server is listening on
+ */
+func (srv *Server) Addr() net.Addr {
+	return srv.addr
+}
+
+/*
+ * Shutdown gracefully stops the server.
+ *
+ * It notifies every client with a LEAVE message, waits for them to close
+ * their connection during at most the grace period, then stops the server.
+ */
+func (srv *Server) Shutdown(reason string, grace time.Duration) {
+	protoLog.WithField("clients", srv.clients.Len()).Info("Notifying clients of the server shutdown")
+	srv.stopPinging()
+	// the clients won't be able to resume once the server is stopped
+	srv.clients.closeSessions()
+	srv.clients.LeaveAll(reason)
+
+	deadline := time.Now().Add(grace)
+	for srv.clients.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.Stop()
+}
+
+/*
+ * Stop stops the tcp server and the clients connections. The connections of
+ * a room server are left to the Lobby, which owns them.
  */
 func (srv *Server) Stop() {
-	log.Info("Stopping server")
-	srv.server.Stop()
-	srv.wg.Done()
+	protoLog.Info("Stopping server")
+	srv.stopPinging()
+	if srv.server != nil {
+		srv.server.Stop()
+	}
+	srv.clients.closeSessions()
 }
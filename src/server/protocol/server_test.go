@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"net"
+	"server/messages"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ * fakeClient reads messages until it receives a LEAVE, then, if ack is true,
+ * closes the connection, otherwise waits for the server to close it. It
+ * reports the received LEAVE reason and if the connection was closed by the
+ * server after the LEAVE.
+ */
+func fakeClient(conn net.Conn, ack bool) (reason string, closed bool) {
+	defer conn.Close()
+	for {
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			return reason, reason != ""
+		}
+		if msg.Type == messages.LeaveId {
+			reason = messages.GetFactory().Decode(msg).(messages.Leave).Reason
+			if ack {
+				return reason, false
+			}
+		}
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	var (
+		wg     sync.WaitGroup
+		nextId uint32
+	)
+	clients := NewClientRegistry(func() uint32 {
+		nextId++
+		return nextId
+	})
+	srv := NewServer("0", clients, nil, &wg, clients)
+	srv.Start()
+
+	acks := []bool{true, true, false}
+	type result struct {
+		ack    bool
+		reason string
+		closed bool
+	}
+	results := make(chan result, len(acks))
+	for _, ack := range acks {
+		conn, err := net.Dial("tcp", srv.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		go func(conn net.Conn, ack bool) {
+			reason, closed := fakeClient(conn, ack)
+			results <- result{ack, reason, closed}
+		}(conn, ack)
+	}
+
+	// wait for the clients to be registered
+	for i := 0; i < 100 && clients.Len() != len(acks); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if clients.Len() != len(acks) {
+		t.Fatalf("got %d registered clients, want %d", clients.Len(), len(acks))
+	}
+
+	srv.Shutdown("server shutdown", 200*time.Millisecond)
+	wg.Wait()
+
+	for range acks {
+		res := <-results
+		if res.reason != "server shutdown" {
+			t.Errorf("client (ack=%v) received LEAVE reason %q, want %q", res.ack, res.reason, "server shutdown")
+		}
+		if !res.ack && !res.closed {
+			t.Errorf("client (ack=%v) connection should have been closed by the server", res.ack)
+		}
+	}
+	if clients.Len() != 0 {
+		t.Errorf("got %d registered clients after shutdown, want 0", clients.Len())
+	}
+}
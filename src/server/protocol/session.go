@@ -0,0 +1,284 @@
+/*
+ * Surviveler protocol package
+ * player sessions, allowing disconnected players to resume
+ */
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"server/messages"
+	"server/network"
+	"time"
+)
+
+// DefaultReconnectGracePeriod is the time a disconnected player has to resume
+const DefaultReconnectGracePeriod = 30 * time.Second
+
+// source of the session tokens randomness, replaced in tests
+var randRead = rand.Read
+
+/*
+ * session represents a joined player, it outlives the client connection, so
+ * that the client can reconnect and resume playing
+ */
+type session struct {
+	token    string
+	id       uint32      // client id, it is also the player entity id
+	name     string      // player name
+	expiry   *time.Timer // non-nil while the client is disconnected
+	onExpire func()      // called if the client doesn't reconnect in time
+}
+
+/*
+ * newSessionToken returns a random, hard to guess, session token, or an
+ * error if there's no randomness to make one
+ */
+func newSessionToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := randRead(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+/*
+ * SetReconnectGracePeriod sets the time a disconnected player has to
+ * reconnect and resume playing. A null grace period disables resumption.
+ */
+func (reg *ClientRegistry) SetReconnectGracePeriod(d time.Duration) {
+	reg.sessionMutex.Lock()
+	defer reg.sessionMutex.Unlock()
+	reg.gracePeriod = d
+}
+
+/*
+ * openSession creates the session of a client that just joined, and returns
+ * its token. No session is opened if no token could be generated.
+ */
+func (reg *ClientRegistry) openSession(clientData ClientData) (string, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	reg.sessionMutex.Lock()
+	defer reg.sessionMutex.Unlock()
+
+	s := &session{token: token, id: clientData.Id, name: clientData.Name}
+	reg.sessions[s.token] = s
+	return s.token, nil
+}
+
+/*
+ * closeSession forgets a session, the client won't be able to resume it
+ */
+func (reg *ClientRegistry) closeSession(token string) {
+	reg.sessionMutex.Lock()
+	defer reg.sessionMutex.Unlock()
+
+	if s, ok := reg.sessions[token]; ok {
+		if s.expiry != nil {
+			s.expiry.Stop()
+		}
+		delete(reg.sessions, token)
+	}
+}
+
+/*
+ * suspendSession is called when the client of an open session disconnects,
+ * onExpire will be called if it doesn't resume the session before the end of
+ * the grace period.
+ *
+ * It returns false if the session can't be resumed, in which case onExpire
+ * won't be called.
+ */
+func (reg *ClientRegistry) suspendSession(token string, onExpire func()) bool {
+	reg.sessionMutex.Lock()
+	defer reg.sessionMutex.Unlock()
+
+	s, ok := reg.sessions[token]
+	if !ok || reg.gracePeriod <= 0 || reg.sessionsClosed {
+		delete(reg.sessions, token)
+		return false
+	}
+	s.onExpire = onExpire
+	s.expiry = time.AfterFunc(reg.gracePeriod, func() {
+		reg.sessionMutex.Lock()
+		if reg.sessions[s.token] != s || s.expiry == nil {
+			// resumed or closed in the meantime
+			reg.sessionMutex.Unlock()
+			return
+		}
+		delete(reg.sessions, s.token)
+		reg.sessionMutex.Unlock()
+
+		protoLog.WithField("clientID", s.id).Info("Session expired")
+		s.onExpire()
+	})
+	return true
+}
+
+/*
+ * resumeSession resumes the suspended session having the given token. It
+ * returns false if there's no such session, or if it has expired.
+ */
+func (reg *ClientRegistry) resumeSession(token string) (*session, bool) {
+	reg.sessionMutex.Lock()
+	defer reg.sessionMutex.Unlock()
+
+	s, ok := reg.sessions[token]
+	if !ok || s.expiry == nil {
+		return nil, false
+	}
+	s.expiry.Stop()
+	s.expiry = nil
+	s.onExpire = nil
+	return s, true
+}
+
+/*
+ * hasSuspendedSession indicates if there's a suspended session having the
+ * given token, that a client could resume
+ */
+func (reg *ClientRegistry) hasSuspendedSession(token string) bool {
+	reg.sessionMutex.Lock()
+	defer reg.sessionMutex.Unlock()
+
+	s, ok := reg.sessions[token]
+	return ok && s.expiry != nil
+}
+
+/*
+ * expireSession immediately ends the suspended session of the client having
+ * the given id, as if it had expired. It returns false if there's no such
+ * session.
+ */
+func (reg *ClientRegistry) expireSession(id uint32) bool {
+	reg.sessionMutex.Lock()
+	var expired *session
+	for token, s := range reg.sessions {
+		if s.id == id && s.expiry != nil {
+			s.expiry.Stop()
+			delete(reg.sessions, token)
+			expired = s
+			break
+		}
+	}
+	reg.sessionMutex.Unlock()
+
+	if expired == nil {
+		return false
+	}
+	expired.onExpire()
+	return true
+}
+
+/*
+ * EndSession ends the suspended session of the client having the given id,
+ * if any, as if it had expired, so that the client can't resume a player
+ * that's no longer in game. It returns false if there's no such session.
+ */
+func (reg *ClientRegistry) EndSession(id uint32) bool {
+	return reg.expireSession(id)
+}
+
+/*
+ * closeSessions forgets all the sessions, without expiring them, and prevents
+ * new sessions from being suspended
+ */
+func (reg *ClientRegistry) closeSessions() {
+	reg.sessionMutex.Lock()
+	defer reg.sessionMutex.Unlock()
+
+	for token, s := range reg.sessions {
+		if s.expiry != nil {
+			s.expiry.Stop()
+		}
+		delete(reg.sessions, token)
+	}
+	reg.sessionsClosed = true
+}
+
+/*
+ * moveClientState moves the chat limiter, the suspected cheats and the
+ * dropped messages count of the client having the id from to the id to
+ */
+func (reg *ClientRegistry) moveClientState(from, to uint32) {
+	reg.chatMutex.Lock()
+	if cl, ok := reg.chats[from]; ok {
+		delete(reg.chats, from)
+		reg.chats[to] = cl
+	}
+	reg.chatMutex.Unlock()
+
+	reg.cheatMutex.Lock()
+	if cheats, ok := reg.cheats[from]; ok {
+		delete(reg.cheats, from)
+		reg.cheats[to] = cheats
+	}
+	reg.cheatMutex.Unlock()
+
+	reg.dropMutex.Lock()
+	if dropped, ok := reg.dropped[from]; ok {
+		delete(reg.dropped, from)
+		reg.dropped[to] = dropped
+	}
+	reg.dropMutex.Unlock()
+}
+
+/*
+ * resume attaches the connection c to a resumed session, and sends it the
+ * STAY message
+ */
+func (reg *ClientRegistry) resume(s *session, join messages.Join, c *network.Conn) bool {
+	clientData := c.GetUserData().(ClientData)
+	protoLog.WithField("clientID", s.id).WithField("connID", clientData.Id).Info("Resuming session")
+
+	// the connection takes the id of the session
+	reg.mutex.Lock()
+	delete(reg.clients, clientData.Id)
+	reg.clients[s.id] = c
+	reg.mutex.Unlock()
+
+	reg.rttMutex.Lock()
+	delete(reg.rtts, clientData.Id)
+	reg.rtts[s.id] = newRttTracker()
+	reg.rttMutex.Unlock()
+	reg.moveClientState(clientData.Id, s.id)
+
+	clientData = ClientData{
+		Id:          s.id,
+		Name:        s.name,
+		Joined:      true,
+		Token:       s.token,
+		Compression: negotiateCompression(join),
+		ViewRadius:  reg.negotiateViewRadius(join),
+	}
+	c.SetUserData(clientData)
+
+	stay := messages.Stay{
+		Id:          s.id,
+		Players:     reg.playerNames(),
+		Token:       s.token,
+		Compression: uint8(clientData.Compression),
+		ViewRadius:  clientData.ViewRadius,
+	}
+	if err := c.AsyncSendPacket(messages.New(messages.StayId, stay), time.Second); err != nil {
+		protoLog.WithError(err).Error("Couldn't send STAY message to the resuming client")
+		reg.Leave("Couldn't finish handshaking", c)
+		return false
+	}
+	return true
+}
+
+/*
+ * playerNames returns the names of the registered clients, by id
+ */
+func (reg *ClientRegistry) playerNames() map[uint32]string {
+	names := make(map[uint32]string)
+	reg.Range(func(cd ClientData) bool {
+		names[cd.Id] = cd.Name
+		return true
+	})
+	return names
+}
@@ -0,0 +1,225 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"server/messages"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ * testJoin connects to the server and joins it with the given session token,
+ * it returns the connection and the received STAY message
+ */
+func testJoin(t *testing.T, srv *Server, name, token string) (net.Conn, messages.Stay) {
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	join := messages.New(messages.JoinId, messages.Join{
+		Name:    name,
+		Version: MaxProtocolVersion,
+		Token:   token,
+	})
+	if _, err := conn.Write(join.Serialize()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var stay messages.Stay
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("%s: no STAY received, error = %v", name, err)
+		}
+		if msg.Type == messages.StayId {
+			messages.Decode(msg, &stay)
+			return conn, stay
+		}
+	}
+}
+
+/*
+ * testSessionServer starts a server reporting the joined and left players
+ */
+func testSessionServer(grace time.Duration) (srv *Server, clients *ClientRegistry, wg *sync.WaitGroup, joined, left chan uint32) {
+	var nextId uint32
+	wg = new(sync.WaitGroup)
+	clients = NewClientRegistry(func() uint32 {
+		nextId++
+		return nextId
+	})
+	clients.SetReconnectGracePeriod(grace)
+	srv = NewServer("0", clients, nil, wg, clients)
+	joined = make(chan uint32, 10)
+	left = make(chan uint32, 10)
+	srv.OnPlayerJoined(func(id uint32, playerType uint8) { joined <- id })
+	srv.OnPlayerLeft(func(id uint32) { left <- id })
+	srv.Start()
+	return
+}
+
+/*
+ * waitClients waits for the registry to have n clients
+ */
+func waitClients(clients *ClientRegistry, n int) {
+	for i := 0; i < 100 && clients.Len() != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+/*
+ * expectId checks that an id is received on ch, or that nothing is received
+ * if want is 0
+ */
+func expectId(t *testing.T, what string, ch chan uint32, want uint32) {
+	timeout := time.Second
+	if want == 0 {
+		timeout = 50 * time.Millisecond
+	}
+	select {
+	case id := <-ch:
+		if id != want {
+			t.Errorf("%s: got id %d, want %d", what, id, want)
+		}
+	case <-time.After(timeout):
+		if want != 0 {
+			t.Errorf("%s: got nothing, want id %d", what, want)
+		}
+	}
+}
+
+func TestClientRegistry_ResumeSession(t *testing.T) {
+	const grace = 200 * time.Millisecond
+	srv, clients, wg, joined, left := testSessionServer(grace)
+
+	conn, stay := testJoin(t, srv, "John Doe", "")
+	if stay.Token == "" {
+		t.Fatalf("STAY message has no session token")
+	}
+	expectId(t, "first join", joined, stay.Id)
+	id, token := stay.Id, stay.Token
+
+	// reconnect within the grace period
+	conn.Close()
+	waitClients(clients, 0)
+	expectId(t, "disconnection", left, 0)
+	conn, stay = testJoin(t, srv, "John Doe", token)
+	if stay.Id != id || stay.Token != token {
+		t.Errorf("resumed STAY = %+v, want id %d and the same token", stay, id)
+	}
+	expectId(t, "resume", joined, 0)
+	expectId(t, "resume", left, 0)
+
+	// reconnect after the grace period
+	conn.Close()
+	waitClients(clients, 0)
+	time.Sleep(grace)
+	expectId(t, "session expiry", left, id)
+	conn, stay = testJoin(t, srv, "John Doe", token)
+	if stay.Id == id || stay.Token == token {
+		t.Errorf("STAY after expiry = %+v, want a new id and a new token", stay)
+	}
+	expectId(t, "join after expiry", joined, stay.Id)
+
+	conn.Close()
+	waitClients(clients, 0)
+	srv.Stop()
+	wg.Wait()
+}
+
+func TestClientRegistry_EndedSessions(t *testing.T) {
+	srv, clients, wg, joined, left := testSessionServer(time.Minute)
+
+	// a kicked client can't resume
+	conn, stay := testJoin(t, srv, "John Doe", "")
+	expectId(t, "first join", joined, stay.Id)
+	clients.Kick(stay.Id, "kicked")
+	expectId(t, "kick", left, stay.Id)
+	conn.Close()
+	conn, resumed := testJoin(t, srv, "John Doe", stay.Token)
+	if resumed.Id == stay.Id {
+		t.Errorf("kicked client resumed its session")
+	}
+	expectId(t, "join after kick", joined, resumed.Id)
+
+	// a disconnected client can be disconnected for good, when its player
+	// dies for example
+	conn.Close()
+	waitClients(clients, 0)
+	expectId(t, "disconnection", left, 0)
+	clients.Disconnect(resumed.Id, "player got killed")
+	expectId(t, "disconnected while suspended", left, resumed.Id)
+
+	srv.Stop()
+	wg.Wait()
+}
+
+func TestClientRegistry_NoSessionToken(t *testing.T) {
+	randRead = func([]byte) (int, error) { return 0, errors.New("no entropy") }
+	defer func() { randRead = rand.Read }()
+	srv, _, wg, joined, _ := testSessionServer(time.Minute)
+
+	// without randomness, the join is refused rather than given a
+	// guessable token
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	join := messages.New(messages.JoinId, messages.Join{Name: "John Doe", Version: MaxProtocolVersion})
+	if _, err := conn.Write(join.Serialize()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("no LEAVE received, error = %v", err)
+		}
+		if msg.Type == messages.StayId {
+			t.Fatalf("STAY received without a session token")
+		}
+		if msg.Type == messages.LeaveId {
+			break
+		}
+	}
+	expectId(t, "join without token", joined, 0)
+
+	srv.Stop()
+	wg.Wait()
+}
+
+func TestClientRegistry_moveClientState(t *testing.T) {
+	reg := NewClientRegistry(func() uint32 { return 0 })
+	const tempId, id = 7, 3
+
+	now := time.Now()
+	reg.allowChat(tempId, now)
+	reg.FlagCheat(tempId, "test")
+	reg.dropped[tempId] = 2
+
+	reg.moveClientState(tempId, id)
+
+	if got := reg.Cheats(id); got != 1 {
+		t.Errorf("Cheats(session id) = %d, want 1", got)
+	}
+	if got := reg.DroppedSends(id); got != 2 {
+		t.Errorf("DroppedSends(session id) = %d, want 2", got)
+	}
+	if _, ok := reg.chats[id]; !ok {
+		t.Errorf("the chat limiter has not been moved to the session id")
+	}
+	if _, ok := reg.chats[tempId]; ok {
+		t.Errorf("a chat limiter is left under the connection id")
+	}
+	if _, ok := reg.cheats[tempId]; ok {
+		t.Errorf("the cheats are left under the connection id")
+	}
+	if _, ok := reg.dropped[tempId]; ok {
+		t.Errorf("the dropped messages are left under the connection id")
+	}
+}
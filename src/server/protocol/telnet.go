@@ -5,21 +5,42 @@
 package protocol
 
 import (
+	"crypto/subtle"
 	"net"
+	"server/logging"
+	"strings"
 	"sync"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/aurelien-rainone/telgo"
 	"github.com/urfave/cli"
 )
 
+// logger of the telnet module
+var telnetLog = logging.Module("telnet")
+
+const (
+	maxTelnetAuthFailures = 3              // wrong passwords a telnet client can give before being disconnected
+	telnetPrompt          = "surviveler> " // prompt of the console
+	telnetPasswordPrompt  = "password: "   // prompt of the clients that aren't authenticated yet
+)
+
 type TelnetServer struct {
 	port     string          // port on which listening
 	registry *ClientRegistry // the unique client registry
+	password string          // password the clients must give first, empty if none
 	server   *telgo.Server
 	CliApp   *cli.App
 }
 
+/*
+ * telnetSession is the authentication state of a telnet client, kept in the
+ * client user data
+ */
+type telnetSession struct {
+	authenticated bool
+	failures      int // wrong passwords given
+}
+
 /*
  * NewTelnetServer initializes a TelnetServer struct
  */
@@ -47,6 +68,9 @@ func NewTelnetServer(port string, registry *ClientRegistry) *TelnetServer {
  */
 func (tns *TelnetServer) Start(listener *net.TCPListener, wg *sync.WaitGroup) {
 	globalHandler := func(c *telgo.Client, args []string) bool {
+		if quit, ok := tns.authenticate(c, args); !ok {
+			return quit
+		}
 		tw := telnetWriter{c}
 		tns.CliApp.Writer = &tw
 		tns.CliApp.ErrWriter = &tw
@@ -56,19 +80,76 @@ func (tns *TelnetServer) Start(listener *net.TCPListener, wg *sync.WaitGroup) {
 
 	wg.Add(1)
 	// start the server in a goroutine
-	tns.server = telgo.NewServer("surviveler> ", globalHandler, "anonymous")
+	tns.server = telgo.NewServer(telnetPrompt, globalHandler, "anonymous")
+	tns.server.OnConnect(func(c *telgo.Client) {
+		if len(tns.password) != 0 {
+			// ask the password first
+			c.UserData = &telnetSession{}
+			c.SetPrompt(telnetPasswordPrompt)
+		}
+	})
 	go func() {
 		defer func() {
-			log.Info("Stopping admin telnet server")
+			telnetLog.Info("Stopping admin telnet server")
 			wg.Done()
 		}()
-		log.Info("Starting admin telnet server")
+		telnetLog.Info("Starting admin telnet server")
 		if err := tns.server.Run(listener); err != nil {
-			log.WithError(err).Error("Telnet server error")
+			telnetLog.WithError(err).Error("Telnet server error")
 		}
 	}()
 }
 
+/*
+ * SetPassword sets the password the telnet clients are prompted for when they
+ * connect, before running any command. An empty password disables the
+ * authentication.
+ */
+func (tns *TelnetServer) SetPassword(password string) {
+	tns.password = password
+}
+
+/*
+ * authenticate checks that client c is authenticated before it runs the
+ * command line args. If it isn't, the line is the password it was prompted
+ * for: ok is then false, and quit is true if the client must be disconnected,
+ * having given too many wrong passwords.
+ */
+func (tns *TelnetServer) authenticate(c *telgo.Client, args []string) (quit, ok bool) {
+	if len(tns.password) == 0 {
+		return false, true
+	}
+	sess, isSession := c.UserData.(*telnetSession)
+	if !isSession {
+		// the password was set after the client connected, ask it now
+		c.UserData = &telnetSession{}
+		c.Sayln("authentication required")
+		c.SetPrompt(telnetPasswordPrompt)
+		return false, false
+	}
+	if sess.authenticated {
+		return false, true
+	}
+
+	ctxLog := telnetLog.WithField("addr", c.Conn.RemoteAddr())
+	password := strings.Join(args, " ")
+	if subtle.ConstantTimeCompare([]byte(password), []byte(tns.password)) == 1 {
+		sess.authenticated = true
+		ctxLog.Info("Telnet client authenticated")
+		c.Sayln("authenticated")
+		c.SetPrompt(telnetPrompt)
+		return false, false
+	}
+	sess.failures++
+	ctxLog.WithField("failures", sess.failures).Warn("Wrong telnet password")
+	if sess.failures >= maxTelnetAuthFailures {
+		c.Sayln("too many wrong passwords, bye")
+		return true, false
+	}
+	c.Sayln("wrong password")
+	return false, false
+}
+
 /*
  * RegisterCommand registers a telnet command
  */
@@ -97,6 +178,6 @@ type telnetWriter struct {
  */
 func (w telnetWriter) Write(p []byte) (n int, err error) {
 	s := string(p)
-	w.c.Say(s)
+	w.c.Say("%s", s)
 	return len(s), nil
 }
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+/*
+ * telnetConsole is a telnet client of the admin console
+ */
+type telnetConsole struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+/*
+ * readUntilPrompt returns what the server wrote until the next prompt, the
+ * prompt, and false if the connection was closed before
+ */
+func (tc *telnetConsole) readUntilPrompt() (string, string, bool) {
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var out []byte
+	for {
+		for _, prompt := range []string{telnetPrompt, telnetPasswordPrompt} {
+			if strings.HasSuffix(string(out), prompt) {
+				return strings.TrimSuffix(string(out), prompt), prompt, true
+			}
+		}
+		b, err := tc.r.ReadByte()
+		if err != nil {
+			return string(out), "", false
+		}
+		out = append(out, b)
+	}
+}
+
+/*
+ * run sends a command line, and returns the output of the server and the
+ * prompt that followed
+ */
+func (tc *telnetConsole) run(line string) (string, string, bool) {
+	if _, err := tc.conn.Write([]byte(line + "\r\n")); err != nil {
+		tc.t.Fatalf("couldn't send %q: %v", line, err)
+	}
+	return tc.readUntilPrompt()
+}
+
+/*
+ * startTelnetServer starts a telnet server, protected by password if any, having a
+ * 'ping' command. It returns a function connecting a client to it, and a
+ * function stopping it.
+ */
+func startTelnetServer(t *testing.T, password string) (connect func() *telnetConsole, stop func()) {
+	tns := NewTelnetServer("0", nil)
+	tns.SetPassword(password)
+	tns.RegisterCommand(&cli.Command{
+		Name: "ping",
+		Action: func(c *cli.Context) error {
+			c.App.Writer.Write([]byte("pong\r\n"))
+			return nil
+		},
+	})
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenTCP() error = %v", err)
+	}
+	var wg sync.WaitGroup
+	tns.Start(listener, &wg)
+
+	connect = func() *telnetConsole {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		tc := &telnetConsole{t: t, conn: conn, r: bufio.NewReader(conn)}
+		want := telnetPrompt
+		if password != "" {
+			want = telnetPasswordPrompt
+		}
+		if _, prompt, ok := tc.readUntilPrompt(); prompt != want || !ok {
+			t.Fatalf("prompt on connection = %q, want %q", prompt, want)
+		}
+		return tc
+	}
+	return connect, func() {
+		tns.Stop()
+		wg.Wait()
+	}
+}
+
+func TestTelnetServer_Authentication(t *testing.T) {
+	connect, stop := startTelnetServer(t, "hunter2")
+	defer stop()
+
+	// the lines are taken as the password until the right one is given
+	tc := connect()
+	defer tc.conn.Close()
+	steps := []struct {
+		line   string
+		want   string
+		prompt string
+	}{
+		{"letmein", "wrong password", telnetPasswordPrompt},
+		{"ping", "wrong password", telnetPasswordPrompt},
+		{"hunter2", "authenticated", telnetPrompt},
+		{"ping", "pong", telnetPrompt},
+	}
+	for _, step := range steps {
+		out, prompt, ok := tc.run(step.line)
+		if !ok {
+			t.Fatalf("%q: connection closed, output %q", step.line, out)
+		}
+		if !strings.Contains(out, step.want) {
+			t.Errorf("%q: output = %q, want it to contain %q", step.line, out, step.want)
+		}
+		if prompt != step.prompt {
+			t.Errorf("%q: prompt = %q, want %q", step.line, prompt, step.prompt)
+		}
+		if step.want != "pong" && strings.Contains(out, "pong") {
+			t.Errorf("%q: command run before authentication", step.line)
+		}
+	}
+
+	// the clients giving too many wrong passwords are disconnected
+	tc2 := connect()
+	defer tc2.conn.Close()
+	for i := 1; i <= maxTelnetAuthFailures; i++ {
+		out, _, ok := tc2.run("letmein")
+		if last := i == maxTelnetAuthFailures; ok == last {
+			t.Fatalf("wrong password %d: connection open = %v, output %q", i, ok, out)
+		}
+	}
+}
+
+func TestTelnetServer_NoPassword(t *testing.T) {
+	connect, stop := startTelnetServer(t, "")
+	defer stop()
+
+	// without password, anyone can run commands
+	tc := connect()
+	defer tc.conn.Close()
+	if out, _, _ := tc.run("ping"); !strings.Contains(out, "pong") {
+		t.Errorf("ping output = %q, want pong", out)
+	}
+}
@@ -5,14 +5,19 @@
 package surviveler
 
 import (
-	"math/rand"
 	"server/events"
+	"server/logging"
+	"server/messages"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
 )
 
+// logger of the ai module
+var aiLog = logging.Module("ai")
+
 // This number represents the ration between the number of logic ticks for one
 // AI director tick
 const (
@@ -20,6 +25,8 @@ const (
 	FrequencyAddZombie   time.Duration = 30 * time.Second
 	MaxZombieCount       int           = 10
 	MobZombieCount       int           = 3
+	MaxSpawnCount        int           = 10000 // max number of zombies spawned at once
+	spawnScatterAttempts int           = 5     // random points tried to find a walkable spawn position
 )
 
 /*
@@ -38,21 +45,31 @@ type AIDirector struct {
 	curTick      int
 	nightStart   int16
 	nightEnd     int16
-	lastTime     time.Time
+	sinceSpawn   time.Duration // logic time elapsed since the last periodic spawn
 	zombieCount  int
 	intensity    int
 	keypoints    AIKeypoints
 	entitiesData EntityDataDict
+	rng          *RNG
+	pending      []d2.Vec2 // positions of the zombie spawns queued by the entity cap
+	atCap        bool      // the entity cap has been reached, spawns are held
+	wave         int       // current wave, the number of nights begun, 0 before the first one
+	night        bool      // it was night at the last update
 }
 
 func NewAIDirector(game *Game, nightStart, nightEnd int16) *AIDirector {
-	log.Info("Initializing AI Director")
+	aiLog.Info("Initializing AI Director")
 	ai := new(AIDirector)
 	ai.game = game
 	ai.curTick = 0
-	ai.lastTime = time.Now()
 	ai.nightStart = nightStart
 	ai.nightEnd = nightEnd
+	ai.rng = game.rng.Derive("ai")
+
+	// count the zombies in game, the dying ones included
+	gs := game.State()
+	gs.OnSpawn(messages.MobileEntityKind, ZombieEntity, func(Entity) { ai.zombieCount++ })
+	gs.OnRemove(messages.MobileEntityKind, ZombieEntity, func(Entity) { ai.zombieCount-- })
 
 	// preload needed assets
 	gameData := game.gameData
@@ -65,7 +82,6 @@ func NewAIDirector(game *Game, nightStart, nightEnd int16) *AIDirector {
  * event handler for EnemyDeath events
  */
 func (ai *AIDirector) OnZombieDeath(event *events.Event) {
-	ai.zombieCount--
 	ai.intensity++
 }
 
@@ -77,27 +93,209 @@ func (ai *AIDirector) OnZombieDeath(event *events.Event) {
 // entities following a scripted testable scenario.
 func (ai *AIDirector) SummonZombie() {
 	// pick a random spawn point
-	org := ai.keypoints.Spawn.Enemies[rand.Intn(len(ai.keypoints.Spawn.Enemies))]
+	org := ai.keypoints.Spawn.Enemies[ai.rng.Intn(len(ai.keypoints.Spawn.Enemies))]
 
-	log.WithFields(log.Fields{
+	aiLog.WithFields(log.Fields{
 		"spawn": org,
 	}).Info("summoning zombie")
 
 	ai.addZombie(org)
-	ai.zombieCount++
 }
 
-func (ai *AIDirector) addZombie(org d2.Vec2) {
+/*
+ * addZombie spawns a zombie around the spawn point org (see spawnPosition).
+ * If the entity cap is reached, the spawn is queued or refused instead,
+ * depending on the configuration. It returns true if the zombie has been
+ * spawned.
+ */
+func (ai *AIDirector) addZombie(org d2.Vec2) bool {
+	org = ai.spawnPosition(org)
+	if !ai.game.State().atEntityCap() {
+		ai.spawnZombie(org)
+		return true
+	}
+
+	queue := ai.game.cfg.SpawnsAtCap == SpawnsAtCapQueue && len(ai.pending) < MaxSpawnCount
+	if !ai.atCap {
+		ai.atCap = true
+		aiLog.WithFields(log.Fields{
+			"cap":    ai.game.cfg.MaxEntities,
+			"policy": ai.game.cfg.SpawnsAtCap,
+		}).Warn("Entity cap reached, holding the zombie spawns")
+	}
+	if queue {
+		ai.pending = append(ai.pending, org)
+	}
+	return false
+}
+
+/*
+ * spawnPosition returns the position of a zombie spawning at the spawn point
+ * org, scattered around it according to the spawn jitter and pattern of the
+ * configuration. The position is on a walkable tile, within the map bounds
+ * and reachable from the spawn point. If no such position is found, it's the
+ * spawn point itself.
+ */
+func (ai *AIDirector) spawnPosition(org d2.Vec2) d2.Vec2 {
+	if ai.game.cfg.SpawnJitter <= 0 {
+		return org
+	}
+	world := ai.game.State().World()
+	spawn, _ := world.TileAtWorldVec(org)
+	for i := 0; i < spawnScatterAttempts && spawn != nil; i++ {
+		pos := ai.jitter(org, ai.game.cfg.SpawnJitter)
+		if tile, ok := world.TileAtWorldVec(pos); ok && world.Reachable(spawn, tile) {
+			return pos
+		}
+	}
+	return org
+}
+
+/*
+ * jitter returns a random point within radius of org, distributed according
+ * to the spawn pattern of the configuration
+ */
+func (ai *AIDirector) jitter(org d2.Vec2, radius float32) d2.Vec2 {
+	var dist float32
+	switch ai.game.cfg.SpawnPattern {
+	case SpawnPatternCluster:
+		dist = math32.Min(math32.Abs(ai.rng.Normal(0, radius/3)), radius)
+	case SpawnPatternSpread:
+		// uniformly over the outer half of the disc
+		dist = radius * math32.Sqrt(0.25+0.75*ai.rng.Float32())
+	default:
+		dist = radius * math32.Sqrt(ai.rng.Float32())
+	}
+	angle := ai.rng.Range(0, 2*math32.Pi)
+	return org.Add(d2.Vec2{dist * math32.Cos(angle), dist * math32.Sin(angle)})
+}
+
+/*
+ * spawnPending spawns the queued zombies, as long as the entity cap allows it
+ */
+func (ai *AIDirector) spawnPending() {
+	if !ai.atCap {
+		return
+	}
+	var n int
+	for ; n < len(ai.pending) && !ai.game.State().atEntityCap(); n++ {
+		ai.spawnZombie(ai.pending[n])
+	}
+	ai.pending = ai.pending[n:]
+	if len(ai.pending) == 0 {
+		ai.pending = nil
+	}
+	if !ai.game.State().atEntityCap() {
+		ai.atCap = false
+		aiLog.WithField("spawned", n).Info("Below the entity cap, zombie spawns resumed")
+	}
+}
+
+func (ai *AIDirector) spawnZombie(org d2.Vec2) {
 	entityData, ok := ai.entitiesData[ZombieEntity]
 	if !ok {
-		log.Error("Can't create zombie, unsupported entity data type")
+		aiLog.Error("Can't create zombie, unsupported entity data type")
 		return
 	}
+	entityData = ai.zombieVariant(entityData)
 	speed := entityData.Speed
 	combatPower := entityData.CombatPower
-	totHP := float32(entityData.TotalHP)
-	ai.game.State().AddEntity(
-		NewZombie(ai.game, org, speed, combatPower, totHP))
+	cfg := ai.game.cfg
+	totHP := float32(entityData.TotalHP) * waveFactor(cfg.WaveScaling, cfg.WaveHPGrowth, ai.wave)
+	z := NewZombie(ai.game, org, speed, combatPower, totHP)
+	applyEntityData(z, entityData)
+	if f := waveFactor(cfg.WaveScaling, cfg.WaveDamageGrowth, ai.wave); f != 1 {
+		z.modifiers.Add(Modifier{Source: "wave", Stat: DamageStat, Factor: f})
+	}
+	ai.game.State().AddEntity(z)
+}
+
+/*
+ * waveFactor returns the factor by which the zombie stats growing by growth
+ * per wave, according to the scaling curve, are multiplied on wave. The
+ * zombies of the first wave, and the ones spawned before it, are unscaled.
+ */
+func waveFactor(curve string, growth float32, wave int) float32 {
+	if wave <= 1 {
+		return 1
+	}
+	n := float32(wave - 1)
+	if curve == WaveScalingExponential {
+		return math32.Pow(1+growth, n)
+	}
+	return 1 + growth*n
+}
+
+/*
+ * Wave returns the current wave, the number of nights begun, 0 before the
+ * first one
+ */
+func (ai *AIDirector) Wave() int {
+	return ai.wave
+}
+
+/*
+ * updateWave begins a new wave at nightfall
+ */
+func (ai *AIDirector) updateWave() {
+	night := ai.IsNight()
+	if night && !ai.night {
+		ai.wave++
+		aiLog.WithField("wave", ai.wave).Info("Night has fallen, new wave")
+	}
+	ai.night = night
+}
+
+/*
+ * zombieVariant draws the variant of a zombie to spawn, according to the
+ * spawn chances of the variants of data, the common zombie data
+ */
+func (ai *AIDirector) zombieVariant(data *EntityData) *EntityData {
+	if len(data.Variants) == 0 {
+		return data
+	}
+	r := ai.rng.Float32()
+	for _, v := range data.Variants {
+		if r -= v.SpawnChance; r < 0 {
+			return v
+		}
+	}
+	return data
+}
+
+/*
+ * SpawnZombies spawns count zombies at once, for load testing. It returns
+ * the number of zombies actually spawned, and the number of spawns queued
+ * because of the entity cap.
+ *
+ * The zombies are distributed in turn across the spawn points, and scattered
+ * around them (see spawnPosition). No more than MaxSpawnCount zombies are
+ * spawned at once.
+ */
+func (ai *AIDirector) SpawnZombies(count int) (spawned, queued int) {
+	spawns := ai.keypoints.Spawn.Enemies
+	if count > MaxSpawnCount {
+		count = MaxSpawnCount
+	}
+	if count <= 0 || len(spawns) == 0 {
+		return 0, 0
+	}
+
+	idx := ai.rng.Intn(len(spawns))
+	pending := len(ai.pending)
+	for i := 0; i < count; i++ {
+		if ai.addZombie(spawns[(i+idx)%len(spawns)]) {
+			spawned++
+		}
+	}
+	queued = len(ai.pending) - pending
+	aiLog.WithFields(log.Fields{
+		"count":   count,
+		"spawned": spawned,
+		"queued":  queued,
+		"spawns":  len(spawns),
+	}).Info("spawned zombies")
+	return spawned, queued
 }
 
 /*
@@ -105,15 +303,23 @@ func (ai *AIDirector) addZombie(org d2.Vec2) {
  */
 func (ai *AIDirector) summonZombieMob(qty int) {
 	// pick a random spawn point
-	idx := rand.Intn(len(ai.keypoints.Spawn.Enemies))
+	idx := ai.rng.Intn(len(ai.keypoints.Spawn.Enemies))
 	for i := 0; i < qty; i++ {
 		org := ai.keypoints.Spawn.Enemies[(i+idx)%len(ai.keypoints.Spawn.Enemies)]
 		ai.addZombie(org)
-		ai.zombieCount++
 	}
 }
 
-func (ai *AIDirector) Update(curTime time.Time) {
+/*
+ * Update updates the AI director, dt being the logic time elapsed since the
+ * last update
+ */
+func (ai *AIDirector) Update(dt time.Duration) {
+	ai.sinceSpawn += dt
+	ai.updateWave()
+	// spawn the queued zombies as soon as there's room for them
+	ai.spawnPending()
+
 	// limit the update frequency
 	ai.curTick++
 	if ai.curTick%AIDirectorTickUpdate != 0 {
@@ -121,7 +327,7 @@ func (ai *AIDirector) Update(curTime time.Time) {
 	}
 
 	freq := FrequencyAddZombie
-	if time.Since(ai.lastTime) > freq && ai.IsNight() && ai.zombieCount < MaxZombieCount {
+	if ai.sinceSpawn > freq && ai.IsNight() && ai.zombieCount+len(ai.pending) < MaxZombieCount {
 		if ai.intensity >= 5 {
 			n := MaxZombieCount - ai.zombieCount
 			if n > MobZombieCount {
@@ -132,7 +338,7 @@ func (ai *AIDirector) Update(curTime time.Time) {
 		} else {
 			ai.SummonZombie()
 		}
-		ai.lastTime = time.Now()
+		ai.sinceSpawn = 0
 	}
 }
 
@@ -0,0 +1,251 @@
+package surviveler
+
+import (
+	"server/messages"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+func TestAIDirector_SpawnZombies(t *testing.T) {
+	g := newTestGame(t, sealedRooms...)
+	world := g.state.World()
+	spawns := VecList{{1.5, 1.5}, {9.5, 3.5}}
+	g.ai.keypoints.Spawn.Enemies = spawns
+
+	if n, queued := g.ai.SpawnZombies(0); n != 0 || queued != 0 {
+		t.Errorf("SpawnZombies(0) = %d, %d, want 0, 0", n, queued)
+	}
+	const count = 500
+	if n, queued := g.ai.SpawnZombies(count); n != count || queued != 0 {
+		t.Fatalf("SpawnZombies(%d) = %d, %d, want %d, 0", count, n, queued, count)
+	}
+	if n := len(g.state.entities); n != count {
+		t.Fatalf("%d entities in game, want %d", n, count)
+	}
+	if g.ai.zombieCount != count {
+		t.Errorf("AI director counts %d zombies, want %d", g.ai.zombieCount, count)
+	}
+
+	// the zombies land on walkable tiles, in the room of their spawn point
+	regions := world.Regions()
+	perSpawn := make(map[int]int)
+	for _, ent := range g.state.entities {
+		z := ent.(*Zombie)
+		tile, ok := world.TileAtWorldVec(z.Pos)
+		if !ok || !tile.IsWalkable() {
+			t.Fatalf("zombie spawned at %v, not on a walkable tile", z.Pos)
+		}
+		if d0, d1 := z.Pos.Sub(spawns[0]).Len(), z.Pos.Sub(spawns[1]).Len(); d0 > g.cfg.SpawnJitter && d1 > g.cfg.SpawnJitter {
+			t.Errorf("zombie spawned at %v, too far from the spawn points", z.Pos)
+		}
+		perSpawn[regions.Region(tile.X, tile.Y)]++
+	}
+	for _, org := range spawns {
+		tile := world.TileFromWorldVec(org)
+		if n := perSpawn[regions.Region(tile.X, tile.Y)]; n != count/len(spawns) {
+			t.Errorf("%d zombies spawned around %v, want %d", n, org, count/len(spawns))
+		}
+	}
+
+	// they're spread around, not stacked on the spawn points
+	var onSpawn int
+	for _, ent := range g.state.entities {
+		for _, org := range spawns {
+			if ent.Position().Approx(org) {
+				onSpawn++
+			}
+		}
+	}
+	if onSpawn > count/2 {
+		t.Errorf("%d zombies out of %d spawned right on a spawn point", onSpawn, count)
+	}
+}
+
+func TestAIDirector_EntityCap(t *testing.T) {
+	tests := []struct {
+		policy string
+		queued int
+	}{
+		{SpawnsAtCapQueue, 30},
+		{SpawnsAtCapRefuse, 0},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, sealedRooms...)
+		g.cfg.MaxEntities = 20
+		g.cfg.SpawnsAtCap = tt.policy
+		g.ai.keypoints.Spawn.Enemies = VecList{{1.5, 1.5}, {9.5, 3.5}}
+
+		n, queued := g.ai.SpawnZombies(50)
+		if n != 20 || queued != tt.queued {
+			t.Fatalf("%s: SpawnZombies(50) = %d, %d, want 20, %d", tt.policy, n, queued, tt.queued)
+		}
+		if n := len(g.state.entities); n != 20 {
+			t.Fatalf("%s: %d entities in game, want 20", tt.policy, n)
+		}
+		// the director summons no zombie either
+		g.ai.SummonZombie()
+		if n := len(g.state.entities); n != 20 {
+			t.Errorf("%s: %d entities in game once summoned, want 20", tt.policy, n)
+		}
+
+		// killing zombies makes room for the queued spawns, if any
+		var killed int
+		for id := range g.state.entities {
+			g.state.RemoveEntity(id)
+			g.ai.OnZombieDeath(nil)
+			if killed++; killed == 15 {
+				break
+			}
+		}
+		g.ai.Update(10 * time.Millisecond)
+		want := 5
+		if tt.policy == SpawnsAtCapQueue {
+			want = 20
+		}
+		if n := len(g.state.entities); n != want {
+			t.Errorf("%s: %d entities in game after %d deaths, want %d", tt.policy, n, killed, want)
+		}
+		if g.ai.zombieCount != want {
+			t.Errorf("%s: AI director counts %d zombies, want %d", tt.policy, g.ai.zombieCount, want)
+		}
+		if tt.policy == SpawnsAtCapQueue && len(g.ai.pending) != 31-15 {
+			t.Errorf("%s: %d spawns still queued, want %d", tt.policy, len(g.ai.pending), 31-15)
+		}
+	}
+}
+
+func TestAIDirector_SpawnsOnLogicTime(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.ai.keypoints.Spawn.Enemies = VecList{{7.5, 2.5}}
+	g.state.gameTime = 1100
+
+	// at night, a zombie is summoned once FrequencyAddZombie of logic time
+	// has elapsed, however fast the updates run
+	const step = 100 * time.Millisecond
+	for elapsed := step; elapsed <= FrequencyAddZombie; elapsed += step {
+		g.ai.Update(step)
+	}
+	if g.ai.zombieCount != 0 {
+		t.Fatalf("%d zombies summoned before %v of logic time", g.ai.zombieCount, FrequencyAddZombie)
+	}
+	for i := 0; i < AIDirectorTickUpdate; i++ {
+		g.ai.Update(step)
+	}
+	if g.ai.zombieCount != 1 {
+		t.Errorf("%d zombies summoned after %v of logic time, want 1", g.ai.zombieCount, FrequencyAddZombie)
+	}
+}
+
+func TestAIDirector_SpawnJitter(t *testing.T) {
+	const count = 200
+	org := d2.Vec2{10.5, 10.5}
+	spawn := func(pattern string, rows ...string) (*Game, []d2.Vec2) {
+		g := newTestGame(t, rows...)
+		g.cfg.SpawnJitter = 3
+		g.cfg.SpawnPattern = pattern
+		g.ai.keypoints.Spawn.Enemies = VecList{org}
+		g.ai.SpawnZombies(count)
+		var pos []d2.Vec2
+		for _, ent := range g.state.entities {
+			pos = append(pos, ent.Position())
+		}
+		return g, pos
+	}
+
+	// each pattern spreads the batch within the jitter radius, more or less
+	// densely around the spawn point
+	var means []float32
+	for _, pattern := range []string{SpawnPatternCluster, SpawnPatternUniform, SpawnPatternSpread} {
+		g, pos := spawn(pattern, newTestRoom(21)...)
+		var total float32
+		for _, p := range pos {
+			if d := p.Sub(org).Len(); d > g.cfg.SpawnJitter {
+				t.Errorf("%s: zombie spawned at %v, %v away from its spawn point", pattern, p, d)
+			}
+			total += p.Sub(org).Len()
+		}
+		mean := total / count
+		if mean < 0.1 {
+			t.Errorf("%s: zombies stacked on the spawn point", pattern)
+		}
+		means = append(means, mean)
+	}
+	if means[0] >= means[1] || means[1] >= means[2] {
+		t.Errorf("mean distances to the spawn point = %v, want cluster < uniform < spread", means)
+	}
+
+	// right next to a wall, the zombies stay on the walkable tiles of the
+	// spawn point room
+	org = d2.Vec2{3.5, 2.5}
+	g, pos := spawn(SpawnPatternSpread, sealedRooms...)
+	world := g.state.World()
+	spawnTile := world.TileFromWorldVec(org)
+	for _, p := range pos {
+		tile, ok := world.TileAtWorldVec(p)
+		if !ok || !world.Reachable(spawnTile, tile) {
+			t.Fatalf("zombie spawned at %v, not on a walkable tile of the spawn point room", p)
+		}
+	}
+
+	// the spawn positions are reproducible
+	_, again := spawn(SpawnPatternSpread, sealedRooms...)
+	sort.Slice(pos, func(i, j int) bool { return pos[i][0] < pos[j][0] })
+	sort.Slice(again, func(i, j int) bool { return again[i][0] < again[j][0] })
+	for i := range pos {
+		if !pos[i].Approx(again[i]) {
+			t.Fatalf("zombie spawned at %v, then at %v with the same seed", pos[i], again[i])
+		}
+	}
+}
+
+func TestAIDirector_WaveScaling(t *testing.T) {
+	tests := []struct {
+		curve      string
+		hp, damage float32 // factors on wave 5
+	}{
+		{WaveScalingLinear, 1.4, 1.8},
+		{WaveScalingExponential, 1.4641, 2.0736},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, openRoom...)
+		g.cfg.WaveScaling = tt.curve
+		g.cfg.WaveHPGrowth = 0.1
+		g.cfg.WaveDamageGrowth = 0.2
+		var z *Zombie
+		g.state.OnSpawn(messages.MobileEntityKind, ZombieEntity, func(e Entity) { z = e.(*Zombie) })
+		data := g.state.EntityData(ZombieEntity)
+		hp, power := float32(data.TotalHP), float32(data.CombatPower)
+
+		for _, wave := range []int{0, 1, 5} {
+			g.ai.wave = wave
+			g.ai.spawnZombie(d2.Vec2{4.5, 2.5})
+			wantHP, wantDamage := hp, power
+			if wave == 5 {
+				wantHP, wantDamage = hp*tt.hp, power*tt.damage
+			}
+			if got := z.health.Total; math32.Abs(got-wantHP) > 1e-3 || z.health.Cur != got {
+				t.Errorf("%s: wave %d zombie has %v/%v HP, want %v", tt.curve, wave, z.health.Cur, got, wantHP)
+			}
+			if got := dealtDamage(z, power); math32.Abs(got-wantDamage) > 1e-3 {
+				t.Errorf("%s: wave %d zombie deals %v damage, want %v", tt.curve, wave, got, wantDamage)
+			}
+		}
+	}
+
+	// a wave begins at each nightfall
+	g := newTestGame(t, openRoom...)
+	for i, tt := range []struct {
+		time int16
+		wave int
+	}{{600, 0}, {1100, 1}, {1300, 1}, {300, 1}, {600, 1}, {1080, 2}} {
+		g.state.gameTime = tt.time
+		g.ai.updateWave()
+		if got := g.ai.Wave(); got != tt.wave {
+			t.Errorf("step %d: wave at %d = %d, want %d", i, tt.time, got, tt.wave)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Surviveler package
+ * movement sanity checks
+ */
+package surviveler
+
+import "github.com/aurelien-rainone/gogeo/f32/d2"
+
+const (
+	moveTolerance = 1.1  // factor applied to the distance an entity can cover
+	moveSlack     = 0.01 // distance always allowed, to absorb rounding errors
+)
+
+/*
+ * MoveGuard is the component checking that an entity never moves faster
+ * than its speed allows.
+ *
+ * Movements are computed by the server, a position advancing faster than
+ * that betrays either a bug, or a client managing to influence the
+ * movements. Displacements that aren't movements, like knockbacks, must be
+ * declared with Allow.
+ */
+type MoveGuard struct {
+	last    d2.Vec2 // last valid position
+	allowed float32 // extra distance allowed until the next check
+}
+
+/*
+ * NewMoveGuard creates a move guard for an entity standing at pos
+ */
+func NewMoveGuard(pos d2.Vec2) *MoveGuard {
+	return &MoveGuard{last: pos}
+}
+
+/*
+ * Allow allows the entity to cover an extra distance until the next check
+ */
+func (mg *MoveGuard) Allow(dist float32) {
+	mg.allowed += dist
+}
+
+/*
+ * Check checks that pos lies within maxDist, the distance the entity could
+ * cover since the last check, of the last valid position.
+ *
+ * If so, pos becomes the last valid position. Otherwise Check returns false,
+ * with the distance actually covered, and the last valid position is kept.
+ */
+func (mg *MoveGuard) Check(pos d2.Vec2, maxDist float32) (dist float32, ok bool) {
+	dist = pos.Sub(mg.last).Len()
+	ok = dist <= maxDist*moveTolerance+mg.allowed+moveSlack
+	mg.allowed = 0
+	if ok {
+		mg.last = pos
+	}
+	return
+}
+
+/*
+ * Reset makes pos the last valid position, for the displacements that aren't
+ * movements, like teleports
+ */
+func (mg *MoveGuard) Reset(pos d2.Vec2) {
+	mg.last, mg.allowed = pos, 0
+}
+
+/*
+ * Last returns the last valid position
+ */
+func (mg *MoveGuard) Last() d2.Vec2 {
+	return mg.last
+}
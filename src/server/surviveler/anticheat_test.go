@@ -0,0 +1,70 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestMoveGuard_Check(t *testing.T) {
+	mg := NewMoveGuard(d2.Vec2{1, 1})
+	if _, ok := mg.Check(d2.Vec2{2, 1}, 1); !ok {
+		t.Errorf("Check() = false for a move within the max distance")
+	}
+	if dist, ok := mg.Check(d2.Vec2{4, 1}, 1); ok || dist != 2 {
+		t.Errorf("Check() = %v, %v, want a rejected move of 2", dist, ok)
+	}
+	if last := mg.Last(); !last.Approx(d2.Vec2{2, 1}) {
+		t.Errorf("Last() = %v, want the last valid position (2,1)", last)
+	}
+
+	// allowed displacements are only allowed once
+	mg.Allow(2)
+	if _, ok := mg.Check(d2.Vec2{4, 2}, 1); !ok {
+		t.Errorf("Check() = false for a move within the allowed distance")
+	}
+	if _, ok := mg.Check(d2.Vec2{6, 2}, 1); ok {
+		t.Errorf("Check() = true, the allowance should have been consumed")
+	}
+}
+
+func TestPlayer_TeleportRejected(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	org := d2.Vec2{1.5, 2.5}
+	p := addTestPlayer(g, TankEntity, org)
+
+	// walking is fine
+	p.Move(Path{d2.Vec2{3.5, 2.5}})
+	for i := 0; i < 10; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if n := g.clients.Cheats(p.Id()); n != 0 || p.Pos.Approx(org) {
+		t.Fatalf("walking player at %v flagged %d times", p.Pos, n)
+	}
+
+	// but not jumping across the room
+	valid := p.Pos
+	p.Pos = d2.Vec2{7.5, 2.5}
+	tick(g, 10*time.Millisecond)
+	if !p.Pos.Approx(valid) {
+		t.Errorf("player at %v after a jump, want it back at %v", p.Pos, valid)
+	}
+	if n := g.clients.Cheats(p.Id()); n != 1 {
+		t.Errorf("Cheats() = %v after a jump, want 1", n)
+	}
+}
+
+func TestPlayer_KnockbackNotFlagged(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{2.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	z.combat.Knockback = 1
+
+	org := p.Pos
+	dealHit(g.state.World(), z, p, z.combat, MeleeDamage)
+	tick(g, 10*time.Millisecond)
+	if p.Pos.Approx(org) || g.clients.Cheats(p.Id()) != 0 {
+		t.Errorf("knocked back player at %v, flagged %d times", p.Pos, g.clients.Cheats(p.Id()))
+	}
+}
@@ -5,16 +5,21 @@
 package surviveler
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"image"
-	"io"
+	"io/ioutil"
+	"os"
 	"path"
 	"server/resource"
+	"sort"
+	"strings"
 
 	"golang.org/x/image/bmp"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/aurelien-rainone/math32"
 )
 
 // URI of some static elements contained in a package
@@ -25,13 +30,41 @@ const (
 
 // TODO: this map is hard-coded for now, but will be read from resources
 // in the future
-var _entityTypes = map[string]EntityType{}
+var _entityTypes = map[string]EntityType{
+	"grunt":      TankEntity,
+	"programmer": ProgrammerEntity,
+	"engineer":   EngineerEntity,
+	"zombie":     ZombieEntity,
+	"barricade":  BarricadeBuilding,
+	"mg_turret":  MgTurretBuilding,
+	"wall":       WallBuilding,
+}
 
-func newGameData(pkg resource.Package) (*gameData, error) {
+/*
+ * newGameData loads the game data from an assets package.
+ *
+ * The world grid has gridScale tiles per world unit, the map bitmaps are
+ * resampled if it differs from the map scale factor. If gridScale is 0, the
+ * map scale factor is used.
+ *
+ * If cacheDir isn't empty, the world grid is cached there, and loaded from
+ * there as long as the map bitmaps and the scales are unchanged.
+ *
+ * If the map data has a 'world' resource, the world grid, the special tiles
+ * and the spawn points are rather loaded from this world file (see
+ * WorldFile), edited maps being used as they are.
+ *
+ * The package is checked beforehand (see checkAssets), so that all the missing
+ * and malformed asset entries are reported at once.
+ */
+func newGameData(pkg resource.Package, gridScale float32, cacheDir string) (*gameData, error) {
 	var (
 		gd  *gameData
 		err error
 	)
+	if err = checkAssets(pkg); err != nil {
+		return nil, err
+	}
 	gd = new(gameData)
 	gd.entitiesData = make(EntityDataDict)
 	gd.buildingsData = make(BuildingDataDict)
@@ -41,47 +74,31 @@ func newGameData(pkg resource.Package) (*gameData, error) {
 	if err = resource.LoadJSON(pkg, mapURI, &gd.mapData); err != nil {
 		return nil, err
 	}
-	if gd.mapData.ScaleFactor == 0 {
-		return nil, errors.New("'scale_factor' can't be 0")
+	if gd.mapData.ScaleFactor <= 0 {
+		return nil, errors.New("'scale_factor' must be positive")
 	}
-	// package must contain the path to world matrix bitmap
-	fname, ok := gd.mapData.Resources["matrix"]
-	if !ok {
-		return nil, errors.New("'matrix' field not found in the map asset")
+	if gridScale == 0 {
+		gridScale = gd.mapData.ScaleFactor
+	}
+	gridScale = clampGridScale(gridScale)
+	// an edited world file replaces the map bitmaps
+	if uri, ok := gd.mapData.Resources["world"]; ok {
+		err = gd.loadWorldFile(pkg, uri)
+	} else {
+		err = gd.loadMapBitmaps(pkg, gridScale, cacheDir)
 	}
-
-	// read and decode the bitmap from the package
-	var (
-		worldBmp image.Image
-		item     resource.Item
-		f        io.ReadCloser
-	)
-	item, err = pkg.Open(fname)
 	if err != nil {
 		return nil, err
 	}
-	f, err = item.Open()
-	defer f.Close()
-	if worldBmp, err = bmp.Decode(f); err == nil {
-		if gd.world, err =
-			NewWorld(worldBmp, gd.mapData.ScaleFactor); err != nil {
-			return nil, err
-		}
+	if err = gd.mapData.AIKeypoints.loadSpawnPoints(); err != nil {
+		return nil, err
 	}
 
-	// TODO: this map is hard-coded for now, but will be read from resources
-	// in the future
-	_entityTypes["grunt"] = TankEntity
-	_entityTypes["programmer"] = ProgrammerEntity
-	_entityTypes["engineer"] = EngineerEntity
-	_entityTypes["zombie"] = ZombieEntity
-	_entityTypes["barricade"] = BarricadeBuilding
-	_entityTypes["mg_turret"] = MgTurretBuilding
-
 	// load entities URI map
 	var (
 		em *EntitiesData
 		t  EntityType
+		ok bool
 	)
 	em = new(EntitiesData)
 	if err = resource.LoadJSON(pkg, entitiesURI, &em); err != nil {
@@ -97,6 +114,9 @@ func newGameData(pkg resource.Package) (*gameData, error) {
 		if t, ok = _entityTypes[name]; !ok {
 			return nil, fmt.Errorf("couldn't find type of '%s' entity", name)
 		}
+		if err = entityData.validate(t); err != nil {
+			return nil, fmt.Errorf("invalid EntityData in %v: %v", uri, err)
+		}
 		log.WithFields(
 			log.Fields{"name": name, "type": t, "data": entityData}).
 			Debug("Loaded EntityData")
@@ -112,6 +132,9 @@ func newGameData(pkg resource.Package) (*gameData, error) {
 		if t, ok = _entityTypes[name]; !ok {
 			return nil, fmt.Errorf("couldn't find type of '%s' building", name)
 		}
+		if err = buildingData.validate(t); err != nil {
+			return nil, fmt.Errorf("invalid BuildingData in %v: %v", uri, err)
+		}
 		log.WithFields(log.Fields{"name": name, "type": t, "data": buildingData}).
 			Debug("Loaded BuildingData")
 		gd.buildingsData[t] = &buildingData
@@ -124,15 +147,311 @@ func newGameData(pkg resource.Package) (*gameData, error) {
 	return gd, nil
 }
 
+/*
+ * assetErrors lists the problems found in an assets package, each one
+ * prefixed with the URI of the asset entry having it
+ */
+type assetErrors []string
+
+func (ae assetErrors) Error() string {
+	return fmt.Sprintf("invalid assets package, %d problem(s):\n\t%s", len(ae), strings.Join(ae, "\n\t"))
+}
+
+/*
+ * add records a problem of the asset entry at uri
+ */
+func (ae *assetErrors) add(uri, format string, args ...interface{}) {
+	*ae = append(*ae, uri+": "+fmt.Sprintf(format, args...))
+}
+
+/*
+ * exists returns true if the package has an entry at uri, or records it as
+ * missing
+ */
+func (ae *assetErrors) exists(pkg resource.Package, uri string) bool {
+	if _, err := pkg.Open(uri); err != nil {
+		if os.IsNotExist(err) {
+			ae.add(uri, "missing")
+		} else {
+			ae.add(uri, "%v", err)
+		}
+		return false
+	}
+	return true
+}
+
+/*
+ * loadJSON decodes the JSON entry at uri into v, it returns false and records
+ * the problem if it's missing or malformed
+ */
+func (ae *assetErrors) loadJSON(pkg resource.Package, uri string, v interface{}) bool {
+	if !ae.exists(pkg, uri) {
+		return false
+	}
+	if err := resource.LoadJSON(pkg, uri, v); err != nil {
+		ae.add(uri, "malformed JSON: %v", err)
+		return false
+	}
+	return true
+}
+
+/*
+ * checkAssets checks that an assets package has all the entries the game
+ * needs: the map data and the files it refers to, at least a player and an
+ * enemy spawn point, and the data of every entity and building type. It
+ * returns an assetErrors listing every missing or malformed entry, or nil.
+ *
+ * Only the presence and the format of the entries are checked, their content
+ * is validated while loading them.
+ */
+func checkAssets(pkg resource.Package) error {
+	var ae assetErrors
+
+	var md MapData
+	if ae.loadJSON(pkg, mapURI, &md) {
+		ae.checkMap(pkg, &md)
+	}
+
+	var em EntitiesData
+	if ae.loadJSON(pkg, entitiesURI, &em) {
+		listed := make(map[string]bool)
+		check := func(name, uri string, data interface{}) {
+			listed[name] = true
+			if _, ok := _entityTypes[name]; !ok {
+				ae.add(entitiesURI, "unknown entity '%s'", name)
+				return
+			}
+			ae.loadJSON(pkg, path.Join(uri, "data.json"), data)
+		}
+		for name, uri := range em.Entities {
+			check(name, uri, new(EntityData))
+		}
+		for name, uri := range em.Buildings {
+			check(name, uri, new(BuildingData))
+		}
+		for name := range _entityTypes {
+			if !listed[name] {
+				ae.add(entitiesURI, "no data for '%s'", name)
+			}
+		}
+	}
+
+	if len(ae) > 0 {
+		sort.Strings(ae)
+		return ae
+	}
+	return nil
+}
+
+/*
+ * checkMap checks the files the map data refers to, and its spawn points
+ */
+func (ae *assetErrors) checkMap(pkg resource.Package, md *MapData) {
+	kp := md.AIKeypoints
+	spawnsURI := mapURI
+	if uri, ok := md.Resources["world"]; ok {
+		// the spawn points are read from the world file
+		if !ae.exists(pkg, uri) {
+			return
+		}
+		data, err := readResource(pkg, uri)
+		if err != nil {
+			ae.add(uri, "%v", err)
+			return
+		}
+		_, spawns, err := ReadWorld(bytes.NewReader(data))
+		if err != nil {
+			ae.add(uri, "%v", err)
+			return
+		}
+		kp, spawnsURI = AIKeypoints{SpawnPoints: spawns}, uri
+	} else {
+		if uri, ok := md.Resources["matrix"]; ok {
+			ae.exists(pkg, uri)
+		} else {
+			ae.add(mapURI, "'matrix' field not found")
+		}
+		if uri, ok := md.Resources["costs"]; ok {
+			ae.exists(pkg, uri)
+		}
+	}
+
+	if err := kp.loadSpawnPoints(); err != nil {
+		ae.add(spawnsURI, "%v", err)
+		return
+	}
+	if len(kp.Spawn.Players) == 0 {
+		ae.add(spawnsURI, "no player spawn point")
+	}
+	if len(kp.Spawn.Enemies) == 0 {
+		ae.add(spawnsURI, "no enemy spawn point")
+	}
+}
+
+/*
+ * loadMapBitmaps builds the world grid from the map bitmaps, or loads it from
+ * the grid cache, then applies the map zones to it (see newGameData)
+ */
+func (gd *gameData) loadMapBitmaps(pkg resource.Package, gridScale float32, cacheDir string) error {
+	// package must contain the path to world matrix bitmap
+	fname, ok := gd.mapData.Resources["matrix"]
+	if !ok {
+		return errors.New("'matrix' field not found in the map asset")
+	}
+	var (
+		matrix, costs []byte
+		err           error
+	)
+	if matrix, err = readResource(pkg, fname); err != nil {
+		return err
+	}
+	// the terrain cost layer is optional
+	if uri, ok := gd.mapData.Resources["costs"]; ok {
+		if costs, err = readResource(pkg, uri); err != nil {
+			return err
+		}
+	}
+
+	// build the world grid from the bitmaps, unless it's been cached
+	var cacheFile string
+	key := newGridCacheKey(gd.mapData.ScaleFactor, gridScale, matrix, costs)
+	if len(cacheDir) > 0 {
+		cacheFile = gridCachePath(cacheDir, fname)
+		gd.world = loadGridCache(cacheFile, key)
+	}
+	if gd.world == nil {
+		if gd.world, err = buildWorld(matrix, costs, gridScale/gd.mapData.ScaleFactor, gridScale); err != nil {
+			return err
+		}
+		if len(cacheFile) > 0 {
+			if err = saveGridCache(cacheFile, gd.world, key); err != nil {
+				log.WithError(err).Warn("Couldn't cache the world grid")
+			}
+		}
+	}
+
+	// the special tiles aren't cached, as they're not read from the bitmaps
+	return gd.world.LoadZones(gd.mapData.Zones)
+}
+
+/*
+ * loadWorldFile loads the world grid from the world file at uri, saved by the
+ * map editor. Its special tiles and spawn points replace the zones and the
+ * spawn points of the map data, and its grid scale replaces gridScale.
+ */
+func (gd *gameData) loadWorldFile(pkg resource.Package, uri string) error {
+	data, err := readResource(pkg, uri)
+	if err != nil {
+		return err
+	}
+	w, spawns, err := ReadWorld(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid world file %v: %v", uri, err)
+	}
+	log.WithFields(log.Fields{
+		"file":  uri,
+		"grid":  fmt.Sprintf("%dx%d", w.GridWidth, w.GridHeight),
+		"scale": w.GridScale,
+	}).Info("World loaded from world file")
+	gd.world = w
+	gd.mapData.Zones = nil
+	gd.mapData.AIKeypoints = AIKeypoints{SpawnPoints: spawns}
+	return nil
+}
+
+/*
+ * resampleBitmap resizes a map bitmap by factor, each pixel of the returned
+ * bitmap taking the value of the source pixel under its center
+ */
+func resampleBitmap(img image.Image, factor float32) image.Image {
+	if factor == 1 {
+		return img
+	}
+	src := img.Bounds()
+	width := int(math32.Floor(float32(src.Dx())*factor + 0.5))
+	height := int(math32.Floor(float32(src.Dy())*factor + 0.5))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			sx := int((float32(x) + 0.5) / factor)
+			sy := int((float32(y) + 0.5) / factor)
+			dst.Set(x, y, img.At(src.Min.X+sx, src.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+/*
+ * buildWorld decodes the map bitmaps, resamples them by factor, and builds
+ * the world grid from them. The terrain cost layer is optional.
+ */
+func buildWorld(matrix, costs []byte, factor, gridScale float32) (*World, error) {
+	img, err := bmp.Decode(bytes.NewReader(matrix))
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWorld(resampleBitmap(img, factor), gridScale)
+	if err != nil {
+		return nil, err
+	}
+	if costs != nil {
+		if img, err = bmp.Decode(bytes.NewReader(costs)); err != nil {
+			return nil, err
+		}
+		if err = w.LoadCosts(resampleBitmap(img, factor)); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+/*
+ * readResource reads a whole file from a package
+ */
+func readResource(pkg resource.Package, uri string) ([]byte, error) {
+	item, err := pkg.Open(uri)
+	if err != nil {
+		return nil, err
+	}
+	f, err := item.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+/*
+ * loadSpawnPoints adds the named spawn points to the lists of player and
+ * enemy spawn points, depending on their type
+ */
+func (kp *AIKeypoints) loadSpawnPoints() error {
+	for _, sp := range kp.SpawnPoints {
+		switch sp.Type {
+		case PlayerSpawnPoint:
+			kp.Spawn.Players = append(kp.Spawn.Players, sp.Pos)
+		case ZombieSpawnPoint:
+			kp.Spawn.Enemies = append(kp.Spawn.Enemies, sp.Pos)
+		default:
+			return fmt.Errorf("spawn point '%s' has an unknown type: '%s'", sp.Name, sp.Type)
+		}
+		log.WithField("spawn", sp).Debug("Loaded spawn point")
+	}
+	return nil
+}
+
 /*
  * validateWorld performs some consistency and logical checks on the world
  */
 func (gd *gameData) validateWorld(world *World) error {
 	// validate player spawn point
 	spawnPoints := gd.mapData.AIKeypoints.Spawn
+	if len(spawnPoints.Players) == 0 {
+		return errors.New("at least one player spawn point must be defined")
+	}
 	for i := range spawnPoints.Players {
-		pt := world.TileFromWorldVec(spawnPoints.Players[i])
-		if pt == nil {
+		pt, ok := world.TileAtWorldVec(spawnPoints.Players[i])
+		if !ok {
 			return fmt.Errorf(
 				"player spawn point is out of bounds (%#v)",
 				spawnPoints.Players[i])
@@ -149,8 +468,8 @@ func (gd *gameData) validateWorld(world *World) error {
 		return errors.New("at least one enemy spawn point must be defined")
 	}
 	for i := range spawnPoints.Enemies {
-		zt := world.TileFromWorldVec(spawnPoints.Enemies[i])
-		if zt == nil {
+		zt, ok := world.TileAtWorldVec(spawnPoints.Enemies[i])
+		if !ok {
 			return fmt.Errorf(
 				"a Zombie spawn point is out of bounds: (%#v)",
 				spawnPoints.Enemies[i])
@@ -163,3 +482,13 @@ func (gd *gameData) validateWorld(world *World) error {
 	}
 	return nil
 }
+
+/*
+ * forRoom returns the game data of another room: the map and entity data are
+ * shared, as they are read-only, but the room gets its own world
+ */
+func (gd *gameData) forRoom() *gameData {
+	c := *gd
+	c.world = gd.world.emptyCopy()
+	return &c
+}
@@ -0,0 +1,248 @@
+package surviveler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"server/resource"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+func TestNewGameData_SpawnPoints(t *testing.T) {
+	pkg, err := resource.OpenFSPackage(testAssets)
+	if err != nil {
+		t.Fatalf("OpenFSPackage(%v) error = %v", testAssets, err)
+	}
+	gd, err := newGameData(pkg, 0, "")
+	if err != nil {
+		t.Fatalf("newGameData() error = %v", err)
+	}
+
+	spawn := gd.mapData.AIKeypoints.Spawn
+	wantPlayers := VecList{{1.5, 1.5}, {8.5, 1.5}, {1.5, 6.5}}
+	wantEnemies := VecList{{8.5, 6.5}}
+	if len(spawn.Players) != len(wantPlayers) {
+		t.Fatalf("got %v player spawn points, want %v", spawn.Players, wantPlayers)
+	}
+	for i := range wantPlayers {
+		if !spawn.Players[i].Approx(wantPlayers[i]) {
+			t.Errorf("player spawn point %d = %v, want %v", i, spawn.Players[i], wantPlayers[i])
+		}
+	}
+	if len(spawn.Enemies) != 1 || !spawn.Enemies[0].Approx(wantEnemies[0]) {
+		t.Errorf("got %v enemy spawn points, want %v", spawn.Enemies, wantEnemies)
+	}
+}
+
+func TestNewGameData_GridScale(t *testing.T) {
+	pkg, err := resource.OpenFSPackage(testAssets)
+	if err != nil {
+		t.Fatalf("OpenFSPackage(%v) error = %v", testAssets, err)
+	}
+	load := func(scale float32) *World {
+		gd, err := newGameData(pkg, scale, "")
+		if err != nil {
+			t.Fatalf("newGameData(%v) error = %v", scale, err)
+		}
+		return gd.world
+	}
+	coarse, fine := load(0), load(2*load(0).GridScale)
+
+	// the world keeps its dimensions, each tile covering the same ground
+	if fine.Width != coarse.Width || fine.Height != coarse.Height {
+		t.Errorf("world is %vx%v with a finer grid, want %vx%v", fine.Width, fine.Height, coarse.Width, coarse.Height)
+	}
+	if fine.GridWidth != 2*coarse.GridWidth || fine.GridHeight != 2*coarse.GridHeight {
+		t.Fatalf("grid is %dx%d, want %dx%d", fine.GridWidth, fine.GridHeight, 2*coarse.GridWidth, 2*coarse.GridHeight)
+	}
+	for x := 0; x < fine.GridWidth; x++ {
+		for y := 0; y < fine.GridHeight; y++ {
+			if got, want := fine.Tile(x, y).Kind, coarse.Tile(x/2, y/2).Kind; got != want {
+				t.Fatalf("tile (%d, %d) kind = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// absurd scales are clamped
+	if w := load(1000); w.GridScale != MaxGridScale {
+		t.Errorf("grid scale = %v, want it clamped to %v", w.GridScale, MaxGridScale)
+	}
+}
+
+func TestPathfinder_FindPathOnScaledGrid(t *testing.T) {
+	pkg, err := resource.OpenFSPackage(testAssets)
+	if err != nil {
+		t.Fatalf("OpenFSPackage(%v) error = %v", testAssets, err)
+	}
+	gd, err := newGameData(pkg, 2, "")
+	if err != nil {
+		t.Fatalf("newGameData() error = %v", err)
+	}
+	g := newTestGameFromData(t, gd)
+	spawn := gd.mapData.AIKeypoints.Spawn
+	org, dst := spawn.Players[0], spawn.Enemies[0]
+
+	path, _, found := g.Pathfinder().FindPath(org, dst)
+	if !found {
+		t.Fatalf("FindPath(%v, %v) found = false, want true", org, dst)
+	}
+	if !path[0].Approx(dst) || !path[len(path)-1].Approx(org) {
+		t.Errorf("path %v doesn't go from %v to %v", path, org, dst)
+	}
+	// intermediate waypoints are the centers of the half-unit tiles
+	for _, wp := range path[1 : len(path)-1] {
+		for _, c := range wp {
+			if frac := c*2 - math32.Floor(c*2); math32.Abs(frac-0.5) > 1e-4 {
+				t.Errorf("waypoint %v isn't the center of a tile of the scaled grid", wp)
+			}
+		}
+	}
+}
+
+func TestAIKeypoints_loadSpawnPoints(t *testing.T) {
+	kp := AIKeypoints{
+		SpawnPoints: []SpawnPoint{{Name: "nowhere", Type: "ghost", Pos: d2.Vec2{1, 1}}},
+	}
+	if err := kp.loadSpawnPoints(); err == nil {
+		t.Errorf("loadSpawnPoints() with an unknown spawn type should fail")
+	}
+}
+
+func TestNewGameData_Buildings(t *testing.T) {
+	pkg, err := resource.OpenFSPackage(testAssets)
+	if err != nil {
+		t.Fatalf("OpenFSPackage(%v) error = %v", testAssets, err)
+	}
+	gd, err := newGameData(pkg, 0, "")
+	if err != nil {
+		t.Fatalf("newGameData() error = %v", err)
+	}
+
+	want := map[EntityType]string{
+		BarricadeBuilding: BarricadeBehavior,
+		MgTurretBuilding:  TurretBehavior,
+		WallBuilding:      WallBehavior,
+	}
+	for bt, behavior := range want {
+		data, ok := gd.buildingsData[bt]
+		if !ok {
+			t.Errorf("building type %v not loaded", bt)
+			continue
+		}
+		if got := data.behavior(bt); got != behavior {
+			t.Errorf("building type %v behavior = %q, want %q", bt, got, behavior)
+		}
+	}
+	power, maxRange, cooldown := gd.buildingsData[MgTurretBuilding].turretSettings()
+	if power != 10 || maxRange != 8 || cooldown != 500*time.Millisecond {
+		t.Errorf("turret settings = %v, %v, %v, want 10, 8, 500ms", power, maxRange, cooldown)
+	}
+}
+
+func TestEntityData_validate(t *testing.T) {
+	screamer := &EntityData{Behavior: ZombieScreamer, AlertRadius: 8, SpawnChance: 0.2}
+	tank := &EntityData{Behavior: ZombieTank, SpawnChance: 0.1}
+	tests := []struct {
+		t    EntityType
+		data EntityData
+		want string // expected error, empty if valid
+	}{
+		{ZombieEntity, EntityData{Variants: []*EntityData{screamer, tank}}, ""},
+		{TankEntity, EntityData{Variants: []*EntityData{tank}}, "only the zombies have variants"},
+		{ZombieEntity, EntityData{Behavior: ZombieTank}, "can only be set on a variant"},
+		{ZombieEntity, EntityData{Variants: []*EntityData{{Behavior: "runner"}}}, "unknown zombie behavior 'runner'"},
+		{ZombieEntity, EntityData{Variants: []*EntityData{{Behavior: ZombieScreamer}}}, "alert radius must be positive"},
+		{ZombieEntity, EntityData{Variants: []*EntityData{tank, tank}}, "more than one 'tank' variant"},
+		{ZombieEntity, EntityData{Variants: []*EntityData{{Behavior: ZombieTank, SpawnChance: -1}}}, "can't be negative"},
+		{ZombieEntity, EntityData{Variants: []*EntityData{screamer, {Behavior: ZombieTank, SpawnChance: 0.9}}}, "add up to"},
+		{TankEntity, EntityData{Resistances: map[string]float32{"fire": 1, "melee": -0.5}}, ""},
+		{TankEntity, EntityData{Resistances: map[string]float32{"acid": 0.5}}, "unknown damage type 'acid'"},
+		{TankEntity, EntityData{Resistances: map[string]float32{"ranged": 1.5}}, "can't be above 1"},
+		{ZombieEntity, EntityData{Variants: []*EntityData{{Behavior: ZombieTank, Resistances: map[string]float32{"cold": 1}}}},
+			"'tank' variant: unknown damage type 'cold'"},
+	}
+	for i, tt := range tests {
+		err := tt.data.validate(tt.t)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("test %d: validate() error = %v", i, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("test %d: validate() error = %v, want it to contain %q", i, err, tt.want)
+		}
+	}
+}
+
+func TestNewGameData_MissingMap(t *testing.T) {
+	assets, cleanup := copyTestAssets(t)
+	defer cleanup()
+	if err := os.RemoveAll(filepath.Join(assets, "map")); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := resource.OpenFSPackage(assets)
+	if err != nil {
+		t.Fatalf("OpenFSPackage(%v) error = %v", assets, err)
+	}
+	_, err = newGameData(pkg, 0, "")
+	ae, ok := err.(assetErrors)
+	if !ok {
+		t.Fatalf("newGameData() error = %v, want the assets problems", err)
+	}
+	if want := (assetErrors{"map/data.json: missing"}); !reflect.DeepEqual(ae, want) {
+		t.Errorf("assets problems = %q, want %q", ae, want)
+	}
+}
+
+func TestNewGameData_MalformedAssets(t *testing.T) {
+	assets, cleanup := copyTestAssets(t)
+	defer cleanup()
+	write := func(uri, content string) {
+		if err := ioutil.WriteFile(filepath.Join(assets, uri), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("entities/zombie/data.json", `{"combat_power": 5, "tot_hp": 50,`)
+	write("buildings/wall/data.json", `{"tot_hp": "lots"}`)
+	if err := os.Remove(filepath.Join(assets, "entities", "grunt", "data.json")); err != nil {
+		t.Fatal(err)
+	}
+	write("map/data.json", `{
+    "resources": {"matrix": "map/matrix.bmp", "costs": "map/costs.bmp"},
+    "scale_factor": 1,
+    "ai_keypoints": {"spawn_points": [{"name": "door", "type": "player", "pos": [1.5, 1.5]}]}
+}`)
+
+	pkg, err := resource.OpenFSPackage(assets)
+	if err != nil {
+		t.Fatalf("OpenFSPackage(%v) error = %v", assets, err)
+	}
+	_, err = newGameData(pkg, 0, "")
+	ae, ok := err.(assetErrors)
+	if !ok {
+		t.Fatalf("newGameData() error = %v, want the assets problems", err)
+	}
+	want := []string{
+		"buildings/wall/data.json: malformed JSON",
+		"entities/grunt/data.json: missing",
+		"entities/zombie/data.json: malformed JSON",
+		"map/costs.bmp: missing",
+		"map/data.json: no enemy spawn point",
+	}
+	if len(ae) != len(want) {
+		t.Fatalf("assets problems = %q, want %q", ae, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(ae[i], want[i]) {
+			t.Errorf("assets problem %d = %q, want %q", i, ae[i], want[i])
+		}
+	}
+	if msg := err.Error(); !strings.Contains(msg, "5 problem(s)") {
+		t.Errorf("error message = %q, want it to count the problems", msg)
+	}
+}
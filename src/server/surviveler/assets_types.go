@@ -4,7 +4,12 @@
  */
 package surviveler
 
-import "github.com/aurelien-rainone/gogeo/f32/d2"
+import (
+	"fmt"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
 
 type Rect2D [2]d2.Vec2
 type VecList []d2.Vec2
@@ -33,6 +38,27 @@ type MapUsableObject struct {
 	Type uint8 `json:"type"`
 }
 
+/*
+ * MapItem is an item lying on the map at the game start
+ */
+type MapItem struct {
+	Type     uint8   `json:"type"`     // item type
+	Pos      d2.Vec2 `json:"pos"`      // position of the item on the map
+	Quantity uint16  `json:"quantity"` // rounds of ammo, hit points of a medkit, etc.
+}
+
+/*
+ * MapZone is an area of the map whose tiles have a special effect on the
+ * entities standing on them
+ */
+type MapZone struct {
+	Name   string  `json:"name"`   // zone name
+	Effect string  `json:"effect"` // hazard, slow or spawn_only
+	Rect   Rect2D  `json:"rect"`   // area covered, top-left and bottom-right corners
+	Damage float32 `json:"damage"` // hazard: hit points lost per second
+	Speed  float32 `json:"speed"`  // slow: speed factor, in ]0, 1[
+}
+
 type ResourceList map[string]string
 
 /*
@@ -43,11 +69,29 @@ type Spawn struct {
 	Enemies VecList `json:"enemies"` // list of spawn points for enemies
 }
 
+/*
+ * Spawn point types
+ */
+const (
+	PlayerSpawnPoint string = "player"
+	ZombieSpawnPoint string = "zombie"
+)
+
+/*
+ * SpawnPoint is a named spawn point for a given type of entities
+ */
+type SpawnPoint struct {
+	Name string  `json:"name"` // spawn point name
+	Type string  `json:"type"` // type of spawning entities (player/zombie)
+	Pos  d2.Vec2 `json:"pos"`  // position on the map
+}
+
 /*
  * AIKeypoints regroups the various AI-related key points on the map
  */
 type AIKeypoints struct {
-	Spawn Spawn `json:"spawn"` // entity spawn points
+	Spawn       Spawn        `json:"spawn"`        // entity spawn points
+	SpawnPoints []SpawnPoint `json:"spawn_points"` // named spawn points
 }
 
 /*
@@ -58,6 +102,8 @@ type MapData struct {
 	ScaleFactor   float32           `json:"scale_factor"`
 	UsableObjects []MapUsableObject `json:"usable_objects"`
 	Objects       []MapObject       `json:"objects"`
+	Items         []MapItem         `json:"items"`
+	Zones         []MapZone         `json:"zones"`
 	AIKeypoints   AIKeypoints       `json:"ai_keypoints"`
 }
 
@@ -78,6 +124,78 @@ type EntityData struct {
 	CombatPower   uint8   `json:"combat_power"`
 	TotalHP       uint16  `json:"tot_hp"`
 	Speed         float32 `json:"speed"`
+	Knockback     float32 `json:"knockback"`    // distance its hits push back
+	StaggerTime   float32 `json:"stagger_time"` // seconds its hits stagger
+	Radius        float32 `json:"radius"`       // collision radius, 0 for DefaultEntityRadius
+
+	// resistances by damage type name, the fraction of the damage spared,
+	// negative for a vulnerability
+	Resistances map[string]float32 `json:"resistances"`
+
+	// zombie variants
+	Behavior    string        `json:"behavior"`     // variant: ZombieScreamer or ZombieTank
+	AlertRadius float32       `json:"alert_radius"` // screamer: distance up to which it alerts the zombies
+	SpawnChance float32       `json:"spawn_chance"` // variant: chance that a spawned zombie is of this variant
+	Variants    []*EntityData `json:"variants"`     // zombie: special variants, with their own stats
+}
+
+/*
+ * variant returns the entity data of the zombie variant having the given
+ * behavior, or ed if there's none
+ */
+func (ed *EntityData) variant(behavior string) *EntityData {
+	for _, v := range ed.Variants {
+		if v.Behavior == behavior {
+			return v
+		}
+	}
+	return ed
+}
+
+/*
+ * validate checks the entity data of the entities of type t
+ */
+func (ed *EntityData) validate(t EntityType) error {
+	if t != ZombieEntity && len(ed.Variants) > 0 {
+		return fmt.Errorf("only the zombies have variants")
+	}
+	if len(ed.Behavior) > 0 {
+		return fmt.Errorf("behavior '%s' can only be set on a variant", ed.Behavior)
+	}
+	if err := validateResistances(ed.Resistances); err != nil {
+		return err
+	}
+	var chance float32
+	seen := make(map[string]bool)
+	for i, v := range ed.Variants {
+		switch v.Behavior {
+		case ZombieScreamer:
+			if v.AlertRadius <= 0 {
+				return fmt.Errorf("screamer alert radius must be positive, got %v", v.AlertRadius)
+			}
+		case ZombieTank:
+		default:
+			return fmt.Errorf("unknown zombie behavior '%s'", v.Behavior)
+		}
+		if seen[v.Behavior] {
+			return fmt.Errorf("more than one '%s' variant", v.Behavior)
+		}
+		seen[v.Behavior] = true
+		if len(v.Variants) > 0 {
+			return fmt.Errorf("variant %d has variants", i)
+		}
+		if err := validateResistances(v.Resistances); err != nil {
+			return fmt.Errorf("'%s' variant: %v", v.Behavior, err)
+		}
+		if v.SpawnChance < 0 {
+			return fmt.Errorf("'%s' spawn chance can't be negative, got %v", v.Behavior, v.SpawnChance)
+		}
+		chance += v.SpawnChance
+	}
+	if chance > 1 {
+		return fmt.Errorf("variant spawn chances add up to %v, more than 1", chance)
+	}
+	return nil
 }
 
 /*
@@ -85,6 +203,70 @@ type EntityData struct {
  * entity type
  */
 type BuildingData struct {
-	TotHp            uint16 `json:"tot_hp"`
-	BuildingPowerRec uint16 `json:"building_power_req"`
+	TotHp            uint16  `json:"tot_hp"`
+	BuildingPowerRec uint16  `json:"building_power_req"`
+	Cost             uint16  `json:"cost"`      // resources spent to build it
+	Behavior         string  `json:"behavior"`  // wall, barricade or turret, empty for the default of the type
+	Power            uint16  `json:"power"`     // turret: damage of a shot, 0 for DefaultTurretPower
+	Range            float32 `json:"range"`     // turret: distance up to which zombies are shot, 0 for DefaultTurretRange
+	Cooldown         float32 `json:"cooldown"`  // turret: seconds between 2 shots, 0 for DefaultTurretCooldown
+	Targeting        string  `json:"targeting"` // turret: zombie shot first, nearest or weakest, empty for nearest
+}
+
+/*
+ * behavior returns the behavior of the buildings of type t
+ */
+func (bd *BuildingData) behavior(t EntityType) string {
+	if len(bd.Behavior) > 0 {
+		return bd.Behavior
+	}
+	return defaultBehaviors[t]
+}
+
+/*
+ * turretSettings returns the power, range and cooldown of a turret, the
+ * defaults replacing the unspecified ones
+ */
+func (bd *BuildingData) turretSettings() (power uint16, maxRange float32, cooldown time.Duration) {
+	power, maxRange = DefaultTurretPower, DefaultTurretRange
+	cooldown = DefaultTurretCooldown
+	if bd.Power > 0 {
+		power = bd.Power
+	}
+	if bd.Range > 0 {
+		maxRange = bd.Range
+	}
+	if bd.Cooldown > 0 {
+		cooldown = time.Duration(bd.Cooldown * float32(time.Second))
+	}
+	return
+}
+
+/*
+ * targeting returns the targeting policy of a turret
+ */
+func (bd *BuildingData) targeting() string {
+	if len(bd.Targeting) > 0 {
+		return bd.Targeting
+	}
+	return TurretTargetsNearest
+}
+
+/*
+ * validate checks the building data of the buildings of type t
+ */
+func (bd *BuildingData) validate(t EntityType) error {
+	switch bd.behavior(t) {
+	case WallBehavior, BarricadeBehavior, TurretBehavior:
+	default:
+		return fmt.Errorf("unknown building behavior '%s'", bd.Behavior)
+	}
+	if bd.Range < 0 || bd.Cooldown < 0 {
+		return fmt.Errorf("turret range and cooldown can't be negative, got %v and %v", bd.Range, bd.Cooldown)
+	}
+	if p := bd.targeting(); p != TurretTargetsNearest && p != TurretTargetsWeakest {
+		return fmt.Errorf("turret targeting must be '%s' or '%s', got '%s'",
+			TurretTargetsNearest, TurretTargetsWeakest, p)
+	}
+	return nil
 }
@@ -5,6 +5,7 @@
 package surviveler
 
 import (
+	"errors"
 	"server/events"
 	"time"
 
@@ -12,6 +13,9 @@ import (
 	"github.com/aurelien-rainone/gogeo/f32/d2"
 )
 
+// half the side of the square bounding box of the buildings
+const buildingRadius = 0.25
+
 /*
  * BuildingBase is a base containing the required fields for every building
  *
@@ -34,6 +38,14 @@ func (bb *BuildingBase) Type() EntityType {
 	return bb.buildingType
 }
 
+func (bb *BuildingBase) Faction() Faction {
+	return NeutralFaction
+}
+
+func (bb *BuildingBase) hitPoints() float32 {
+	return bb.curHP
+}
+
 func (bb *BuildingBase) Id() uint32 {
 	return bb.id
 }
@@ -47,8 +59,7 @@ func (bb *BuildingBase) Position() d2.Vec2 {
 }
 
 func (bb *BuildingBase) Rectangle() d2.Rectangle {
-	x, y := bb.pos.X(), bb.pos.Y()
-	return d2.Rect(x-0.25, y-0.25, x+0.25, y+0.25)
+	return buildingRectangle(bb.pos)
 }
 
 func (bb *BuildingBase) State() EntityState {
@@ -61,7 +72,7 @@ func (bb *BuildingBase) State() EntityState {
 	}
 }
 
-func (bb *BuildingBase) DealDamage(damage float32) (dead bool) {
+func (bb *BuildingBase) DealDamage(damage float32, typ DamageType) (dead bool) {
 	if damage >= bb.curHP {
 		bb.curHP = 0
 		bb.g.PostEvent(events.NewEvent(
@@ -100,33 +111,57 @@ type BuildingUpdater interface {
 }
 
 /*
- * Barricade is a simple barricade building
- *
- * It implements the Building interface
+ * Behaviors of the buildings, set by their building data
  */
-type Barricade struct {
-	BuildingBase
+const (
+	WallBehavior      = "wall"      // just blocks, zombies go around
+	BarricadeBehavior = "barricade" // blocks, zombies attack it to get through
+	TurretBehavior    = "turret"    // shoots the zombies in range, once built
+)
+
+/*
+ * How the turrets choose the zombie they shoot among the ones in sight
+ */
+const (
+	TurretTargetsNearest = "nearest" // the closest one
+	TurretTargetsWeakest = "weakest" // the one with the fewest hit points left
+)
+
+/*
+ * defaultBehaviors are the behaviors of the building types whose data don't
+ * specify one
+ */
+var defaultBehaviors = map[EntityType]string{
+	WallBuilding:      WallBehavior,
+	BarricadeBuilding: BarricadeBehavior,
+	MgTurretBuilding:  TurretBehavior,
 }
 
+// TODO: those values should be taken from the resources
+const (
+	DefaultTurretPower    = 10                     // damage of a shot
+	DefaultTurretRange    = 8                      // distance up to which zombies are shot
+	DefaultTurretCooldown = 500 * time.Millisecond // time between 2 shots
+)
+
 /*
- * NewBarricade creates a new barricade
+ * newBuildingBase returns the base of a building of type t at pos, created
+ * from its building data
  */
-func NewBarricade(g *Game, pos d2.Vec2, totHP, reqBP uint16) *MgTurret {
-	return &MgTurret{
-		BuildingBase{
-			id:           InvalidID,
-			g:            g,
-			pos:          pos,
-			totalHP:      float32(totHP),
-			curHP:        1,
-			requiredBP:   reqBP,
-			curBP:        0,
-			buildingType: BarricadeBuilding,
-		},
+func newBuildingBase(g *Game, t EntityType, pos d2.Vec2, data *BuildingData) BuildingBase {
+	return BuildingBase{
+		id:           InvalidID,
+		g:            g,
+		pos:          pos,
+		totalHP:      float32(data.TotHp),
+		curHP:        1,
+		requiredBP:   data.BuildingPowerRec,
+		curBP:        0,
+		buildingType: t,
 	}
 }
 
-func (mg *Barricade) Update(dt time.Duration) {
+func (bb *BuildingBase) Update(dt time.Duration) {
 }
 
 /*
@@ -134,8 +169,8 @@ func (mg *Barricade) Update(dt time.Duration) {
  *
  * Build Power is induced by construction or reparation.
  */
-func (mg *Barricade) AddBuildPower(bp uint16) {
-	mg.addBuildPower(bp)
+func (bb *BuildingBase) AddBuildPower(bp uint16) {
+	bb.addBuildPower(bp)
 }
 
 /*
@@ -144,55 +179,254 @@ func (mg *Barricade) AddBuildPower(bp uint16) {
  * For the case of a building with shooting ability (eg a turret), this
  * implies the building is active and can shoot
  */
-func (mg *Barricade) IsBuilt() bool {
-	return mg.isBuilt
+func (bb *BuildingBase) IsBuilt() bool {
+	return bb.isBuilt
 }
 
 /*
- * MgTurret is a machine-gun turret building
+ * Wall is a building that just blocks the way. Unlike the other buildings,
+ * zombies don't attack it, they go around.
+ *
+ * It implements the Building interface
+ */
+type Wall struct {
+	BuildingBase
+}
+
+/*
+ * NewWall creates a new wall
+ */
+func NewWall(g *Game, t EntityType, pos d2.Vec2, data *BuildingData) *Wall {
+	return &Wall{newBuildingBase(g, t, pos, data)}
+}
+
+/*
+ * Barricade is a simple barricade building
+ *
+ * It implements the Building interface
+ */
+type Barricade struct {
+	BuildingBase
+}
+
+/*
+ * NewBarricade creates a new barricade
+ */
+func NewBarricade(g *Game, t EntityType, pos d2.Vec2, data *BuildingData) *Barricade {
+	return &Barricade{newBuildingBase(g, t, pos, data)}
+}
+
+/*
+ * MgTurret is a machine-gun turret building. Once built, it shoots a zombie
+ * in range and in sight, chosen after its targeting policy, each time its
+ * combat cooldown is over.
  *
  * It implements the Building interface
  */
 type MgTurret struct {
 	BuildingBase
+	Components
+	combat   *Combat
+	maxRange float32 // distance up to which zombies are shot
+	policy   string  // how the target is chosen, TurretTargetsNearest or TurretTargetsWeakest
+	target   uint32  // id of the zombie currently shot at, InvalidID if none
 }
 
 /*
  * NewMgTurret creates a new machine-gun turret
  */
-func NewMgTurret(g *Game, pos d2.Vec2, totHP, reqBP uint16) *MgTurret {
-	return &MgTurret{
-		BuildingBase{
-			id:           InvalidID,
-			g:            g,
-			pos:          pos,
-			totalHP:      float32(totHP),
-			curHP:        1,
-			requiredBP:   reqBP,
-			curBP:        0,
-			buildingType: MgTurretBuilding,
-		},
+func NewMgTurret(g *Game, t EntityType, pos d2.Vec2, data *BuildingData) *MgTurret {
+	power, maxRange, cooldown := data.turretSettings()
+	mg := &MgTurret{
+		BuildingBase: newBuildingBase(g, t, pos, data),
+		combat:       newCombat(g.cfg, power, cooldown),
+		maxRange:     maxRange,
+		policy:       data.targeting(),
+		target:       InvalidID,
 	}
+	mg.AddComponent(mg.combat)
+	return mg
 }
 
+func (mg *MgTurret) SetId(id uint32) {
+	mg.id = id
+	seedCombat(mg.g, mg.combat, "turret", id)
+}
+
+/*
+ * Update shoots its target, if the turret is built and its cooldown is over
+ */
 func (mg *MgTurret) Update(dt time.Duration) {
+	mg.combat.Tick(dt)
+	if !mg.isBuilt {
+		return
+	}
+	target := mg.acquireTarget()
+	if target == nil {
+		mg.target = InvalidID
+		return
+	}
+	mg.target = target.Id()
+	if mg.combat.Ready() {
+		dealHit(mg.g.State().World(), mg, target, mg.combat, RangedDamage)
+	}
 }
 
 /*
- * AddBuildPower adds a given quantity of build power into the building.
+ * Target returns the id of the zombie the turret is shooting at, and false if
+ * there's none
  *
- * Build Power is induced by construction or reparation.
+ * It implements the Targeter interface
  */
-func (mg *MgTurret) AddBuildPower(bp uint16) {
-	mg.addBuildPower(bp)
+func (mg *MgTurret) Target() (uint32, bool) {
+	return mg.target, mg.target != InvalidID
 }
 
 /*
- * IsBuilt indicates if the building is totally constructed.
+ * acquireTarget returns the zombie the turret shoots at, or nil if none is
+ * in range and in sight.
  *
- * For the case of a building with shooting ability (eg a turret), this
- * implies the building is active and can shoot
+ * Like the other shooters, turrets don't see through walls nor buildings.
+ * Ties are broken by increasing id, so that the choice doesn't depend on the
+ * spatial index.
  */
-func (mg *MgTurret) IsBuilt() bool {
-	return mg.isBuilt
+func (mg *MgTurret) acquireTarget() Entity {
+	gs := mg.g.State()
+	var (
+		best      Entity
+		bestScore float32
+	)
+	for _, ent := range gs.entitiesInRadius(mg.pos, mg.maxRange, func(e Entity) bool {
+		return isTurretTarget(e) && gs.world.lineOfSightFrom(mg.pos, e.Position())
+	}) {
+		score := ent.d
+		if mg.policy == TurretTargetsWeakest {
+			score = ent.e.(damageable).hitPoints()
+		}
+		if best == nil || score < bestScore || score == bestScore && ent.e.Id() < best.Id() {
+			best, bestScore = ent.e, score
+		}
+	}
+	return best
+}
+
+/*
+ * isTurretTarget indicates if an entity is shot by the turrets
+ */
+func isTurretTarget(e Entity) bool {
+	z, ok := e.(*Zombie)
+	return ok && z.curState != dyingState
+}
+
+// reasons for which a building can't be placed
+var (
+	errBuildOutOfBounds  = errors.New("out of the world bounds")
+	errBuildNotWalkable  = errors.New("not on walkable ground")
+	errBuildOccupied     = errors.New("there's already a building there")
+	errBuildInTheWay     = errors.New("someone is standing there")
+	errBuildTrapsPlayer  = errors.New("it would trap a player")
+	errBuildSpawnOnly    = errors.New("the area is reserved to spawning")
+	errBuildTooExpensive = errors.New("not enough resources")
+	errBuildUnsupported  = errors.New("this building can't be built")
+)
+
+/*
+ * buildingRectangle returns the bounding box of a building at pos
+ */
+func buildingRectangle(pos d2.Vec2) d2.Rectangle {
+	return d2.RectFromCircle(pos, buildingRadius)
+}
+
+/*
+ * checkPlacement checks if builder can place a building on the tile at pos,
+ * in world coordinates, and returns that tile.
+ *
+ * The building must lie in the world, its tile be walkable, free of buildings
+ * and not reserved to spawning, and no one but the builder must stand in the way. Last, the
+ * building must not cut off a player from all the player spawn points.
+ */
+func (gs *GameState) checkPlacement(pos d2.Vec2, builder Entity) (*Tile, error) {
+	tile, ok := gs.world.TileAtWorldVec(pos)
+	switch {
+	case !ok || !gs.world.Contains(buildingRectangle(tile.Rectangle().Center())):
+		return nil, errBuildOutOfBounds
+	case tile.Kind != KindWalkable:
+		return nil, errBuildNotWalkable
+	case tile.HasBuilding():
+		return nil, errBuildOccupied
+	case tile.Effects.Has(TileSpawnOnly):
+		return nil, errBuildSpawnOnly
+	}
+
+	var inTheWay bool
+	gs.world.AABBSpatialQuery(buildingRectangle(tile.Rectangle().Center())).Each(func(e Entity) bool {
+		switch e.(type) {
+		case *Player, *Zombie:
+			inTheWay = e != builder
+		}
+		return !inTheWay
+	})
+	if inTheWay {
+		return nil, errBuildInTheWay
+	}
+	if gs.trapsPlayer(tile) {
+		return nil, errBuildTrapsPlayer
+	}
+	return tile, nil
+}
+
+/*
+ * trapsPlayer indicates if blocking a tile would cut off a player from all
+ * the player spawn points, while it can currently reach one.
+ */
+func (gs *GameState) trapsPlayer(blocked *Tile) bool {
+	var before, after []bool
+	for _, ent := range gs.entities {
+		p, ok := ent.(*Player)
+		if !ok {
+			continue
+		}
+		t, ok := gs.world.TileAtWorldVec(p.Pos)
+		if !ok || t == blocked {
+			continue
+		}
+		if before == nil {
+			before, after = gs.reachableFromSpawns(nil), gs.reachableFromSpawns(blocked)
+		}
+		if i := t.X + t.Y*gs.world.GridWidth; before[i] && !after[i] {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * reachableFromSpawns returns, for each tile of the grid, if it can be
+ * reached from a player spawn point without going through blocked
+ */
+func (gs *GameState) reachableFromSpawns(blocked *Tile) []bool {
+	w := gs.world
+	reached := make([]bool, w.GridWidth*w.GridHeight)
+	var queue []*Tile
+	visit := func(t *Tile, ok bool) {
+		if !ok || t == blocked || !t.IsWalkable() || reached[t.X+t.Y*w.GridWidth] {
+			return
+		}
+		reached[t.X+t.Y*w.GridWidth] = true
+		queue = append(queue, t)
+	}
+	for _, spawn := range gs.MapData().AIKeypoints.Spawn.Players {
+		visit(w.TileAtWorldVec(spawn))
+	}
+	// diagonal moves require both adjacent tiles to be walkable, so
+	// considering the 4 direct neighbours is enough
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		visit(w.TileAt(t.X-1, t.Y))
+		visit(w.TileAt(t.X+1, t.Y))
+		visit(w.TileAt(t.X, t.Y-1))
+		visit(w.TileAt(t.X, t.Y+1))
+	}
+	return reached
 }
@@ -0,0 +1,530 @@
+package surviveler
+
+import (
+	"server/events"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+var openRoom = []string{
+	"#########",
+	"#.......#",
+	"#.......#",
+	"#.......#",
+	"#########",
+}
+
+/*
+ * pathCrossesTile indicates if any segment of path goes through tile
+ */
+func pathCrossesTile(w *World, path Path, tile *Tile) bool {
+	for i := 0; i < len(path)-1; i++ {
+		seg := path[i+1].Sub(path[i])
+		for s := float32(0); s <= 1; s += 0.05 {
+			if w.TileFromWorldVec(path[i].Add(seg.Scale(s))) == tile {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestBuilding_BlocksTile(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	world := g.state.World()
+	tile := world.Tile(4, 2)
+
+	if !tile.IsWalkable() {
+		t.Fatalf("tile %#v should be walkable before building", *tile)
+	}
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+	if b == nil {
+		t.Fatalf("createBuilding() = nil, want a building")
+	}
+	if tile.IsWalkable() {
+		t.Errorf("tile %#v should not be walkable after building", *tile)
+	}
+	// neighbours should be left untouched
+	for _, n := range []*Tile{world.Tile(3, 2), world.Tile(5, 2), world.Tile(4, 1), world.Tile(4, 3)} {
+		if !n.IsWalkable() {
+			t.Errorf("tile %#v should be walkable", *n)
+		}
+	}
+	if _, ok := g.state.pack().Buildings[b.Id()]; !ok {
+		t.Errorf("building %v not found in packed game state", b.Id())
+	}
+
+	g.state.RemoveEntity(b.Id())
+	if !tile.IsWalkable() {
+		t.Errorf("tile %#v should be walkable once the building is removed", *tile)
+	}
+}
+
+func TestPathfinder_FindPathAroundBuilding(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	world := g.state.World()
+	org, dst := d2.Vec2{1.5, 2.5}, d2.Vec2{7.5, 2.5}
+
+	path, _, found := g.Pathfinder().FindPath(org, dst)
+	if !found {
+		t.Fatalf("FindPath(%v, %v) found = false, want true", org, dst)
+	}
+	if !pathCrossesTile(world, path, world.Tile(4, 2)) {
+		t.Fatalf("path %v should go straight through the room", path)
+	}
+
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+	path, _, found = g.Pathfinder().FindPath(org, dst)
+	if !found {
+		t.Fatalf("FindPath(%v, %v) found = false, want true", org, dst)
+	}
+	if pathCrossesTile(world, path, world.Tile(4, 2)) {
+		t.Errorf("path %v goes through the building tile", path)
+	}
+}
+
+func TestPathfinder_FindPathToBuilding(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+
+	path, _, found := g.Pathfinder().FindPath(d2.Vec2{1.5, 2.5}, b.Position())
+	if !found {
+		t.Fatalf("FindPath() to a building found = false, want true")
+	}
+	if !path[0].Approx(b.Position()) {
+		t.Errorf("path last waypoint = %v, want %v", path[0], b.Position())
+	}
+}
+
+func TestZombie_ReroutesAroundBuilding(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+
+	for i := 0; i < 200 && z.curState != attackingState; i++ {
+		tick(g, 50*time.Millisecond)
+		if z.Rectangle().Overlaps(b.Rectangle()) {
+			t.Fatalf("zombie at %v walked into the building", z.Position())
+		}
+	}
+	if z.curState != attackingState {
+		t.Fatalf("zombie at %v didn't reach the player at %v", z.Position(), p.Position())
+	}
+	if z.target != p {
+		t.Errorf("zombie target = %v, want player %v", z.target, p)
+	}
+}
+
+func TestZombie_AvoidsBuildingDroppedOnPath(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	for i := 0; i < 5; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if z.Pos[0] <= 1.5 || z.curState == attackingState {
+		t.Fatalf("zombie at %v should be walking to the player", z.Position())
+	}
+
+	// drop a building right in front of the zombie, that bumps into it
+	// before looking for a new path
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{z.Pos[0] + 0.8, z.Pos[1]})
+	z.curState, z.timeAcc = walkingState, 0
+	hp := b.State().(BuildingState).CurHitPoints
+	var (
+		last            = z.Position()
+		still, maxStill int
+		steered         bool
+	)
+	for i := 0; i < 400 && z.curState != attackingState; i++ {
+		tick(g, 50*time.Millisecond)
+		if z.Rectangle().Overlaps(b.Rectangle()) {
+			t.Fatalf("zombie at %v walked into the building", z.Position())
+		}
+		steered = steered || z.steerLeft > 0
+		if still = still + 1; !z.Position().Approx(last) {
+			still = 0
+		}
+		if still > maxStill {
+			maxStill = still
+		}
+		last = z.Position()
+	}
+	if !steered {
+		t.Errorf("zombie should have nudged around the building")
+	}
+	// the zombie only stands still while looking for a path
+	if maxStill > 4 {
+		t.Errorf("zombie stood still for %d ticks", maxStill)
+	}
+	if z.curState != attackingState || z.target != p {
+		t.Fatalf("zombie at %v should have rerouted to the player, got target %v", z.Position(), z.target)
+	}
+	if b.State().(BuildingState).CurHitPoints != hp {
+		t.Errorf("zombie attacked the building instead of going around")
+	}
+}
+
+func TestZombie_BreaksThroughWall(t *testing.T) {
+	g := newTestGame(t,
+		"#########",
+		"#.......#",
+		"#########",
+	)
+	wall := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 1.5})
+	wall.AddBuildPower(g.state.BuildingData(BarricadeBuilding).BuildingPowerRec)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 1.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 1.5})
+
+	// the player can't be reached, the zombie should attack the wall
+	for i := 0; i < 20; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if z.target != wall {
+		t.Fatalf("zombie target = %v, want the wall %v", z.target, wall)
+	}
+
+	for i := 0; i < 1000 && g.state.Entity(wall.Id()) != nil; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if g.state.Entity(wall.Id()) != nil {
+		t.Fatalf("the wall should have been destroyed")
+	}
+	if tile := g.state.World().Tile(4, 1); !tile.IsWalkable() {
+		t.Fatalf("tile %#v should be walkable once the wall is destroyed", *tile)
+	}
+
+	// now the zombie should go after the player
+	for i := 0; i < 200 && z.curState != attackingState; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if z.target != p || z.curState != attackingState {
+		t.Errorf("zombie should be attacking the player, got target %v, state %v", z.target, z.curState)
+	}
+}
+
+var closetRoom = []string{
+	"#########",
+	"#.....#.#",
+	"#.....#.#",
+	"#.......#",
+	"#########",
+}
+
+func TestGameState_checkPlacement(t *testing.T) {
+	g := newTestGame(t, closetRoom...)
+	builder := addTestPlayer(g, EngineerEntity, d2.Vec2{3.5, 3.5})
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{2.5, 2.5})
+	addTestZombie(g, d2.Vec2{4.5, 1.5})
+
+	tests := []struct {
+		name string
+		pos  d2.Vec2
+		want error
+	}{
+		{"free tile", d2.Vec2{5.5, 2.5}, nil},
+		{"under the builder", d2.Vec2{3.5, 3.5}, nil},
+		{"closet door, closet empty", d2.Vec2{7.5, 3.5}, nil},
+		{"out of bounds", d2.Vec2{-1, 2.5}, errBuildOutOfBounds},
+		{"wall", d2.Vec2{6.5, 1.5}, errBuildNotWalkable},
+		{"other building", d2.Vec2{2.5, 2.5}, errBuildOccupied},
+		{"zombie", d2.Vec2{4.5, 1.5}, errBuildInTheWay},
+	}
+	for _, tt := range tests {
+		if _, err := g.state.checkPlacement(tt.pos, builder); err != tt.want {
+			t.Errorf("%s: checkPlacement(%v) error = %v, want %v", tt.name, tt.pos, err, tt.want)
+		}
+	}
+
+	// a player in the closet would be trapped
+	addTestPlayer(g, TankEntity, d2.Vec2{7.5, 1.5})
+	if _, err := g.state.checkPlacement(d2.Vec2{7.5, 3.5}, builder); err != errBuildTrapsPlayer {
+		t.Errorf("checkPlacement() at the closet door error = %v, want %v", err, errBuildTrapsPlayer)
+	}
+}
+
+func TestGameState_onPlayerBuild_RejectsInvalidPlacement(t *testing.T) {
+	g := newTestGame(t, closetRoom...)
+	builder := addTestPlayer(g, EngineerEntity, d2.Vec2{3.5, 3.5})
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{2.5, 2.5})
+	addTestPlayer(g, TankEntity, d2.Vec2{7.5, 1.5})
+
+	buildings := func() int {
+		var n int
+		for _, ent := range g.state.entities {
+			if _, ok := ent.(Building); ok {
+				n++
+			}
+		}
+		return n
+	}
+	for _, pos := range []d2.Vec2{{6.5, 1.5}, {2.5, 2.5}, {7.5, 3.5}} {
+		g.PostEvent(events.NewEvent(events.PlayerBuildId, events.PlayerBuild{
+			Id: builder.Id(), Type: uint8(BarricadeBuilding), Xpos: pos[0], Ypos: pos[1]}))
+		tick(g, 10*time.Millisecond)
+		tick(g, 10*time.Millisecond)
+		if n := buildings(); n != 1 {
+			t.Errorf("building at %v has been placed", pos)
+		}
+	}
+
+	// a valid placement is accepted
+	g.PostEvent(events.NewEvent(events.PlayerBuildId, events.PlayerBuild{
+		Id: builder.Id(), Type: uint8(BarricadeBuilding), Xpos: 5.5, Ypos: 2.5}))
+	tick(g, 10*time.Millisecond)
+	tick(g, 10*time.Millisecond)
+	if n := buildings(); n != 2 {
+		t.Errorf("%d buildings, want the valid one to be placed", n)
+	}
+}
+
+func TestGameState_onPlayerBuild_Cost(t *testing.T) {
+	g := newTestGame(t, closetRoom...)
+	g.state.BuildingData(BarricadeBuilding).Cost = 15
+	builder := addTestPlayer(g, EngineerEntity, d2.Vec2{3.5, 3.5})
+
+	build := func(pos d2.Vec2) {
+		g.PostEvent(events.NewEvent(events.PlayerBuildId, events.PlayerBuild{
+			Id: builder.Id(), Type: uint8(BarricadeBuilding), Xpos: pos[0], Ypos: pos[1]}))
+		tick(g, 10*time.Millisecond)
+		tick(g, 10*time.Millisecond)
+	}
+	resources := func() uint16 {
+		return builder.State().(PlayerState).Resources
+	}
+
+	// the cost is deducted once the building is placed
+	build(d2.Vec2{5.5, 2.5})
+	building := builder.curBuilding
+	if building == nil {
+		t.Fatalf("affordable building hasn't been placed")
+	}
+	if got, want := resources(), uint16(PlayerStartingResources-15); got != want {
+		t.Errorf("resources = %v, want %v", got, want)
+	}
+
+	// a building that can't be afforded is rejected
+	build(d2.Vec2{4.5, 1.5})
+	if builder.curBuilding != building {
+		t.Errorf("building placed without enough resources")
+	}
+	if tile, _ := g.state.world.TileAtWorldVec(d2.Vec2{4.5, 1.5}); tile.HasBuilding() {
+		t.Errorf("building placed without enough resources")
+	}
+
+	// cancelling the construction refunds it
+	g.PostEvent(events.NewEvent(events.PlayerMoveId,
+		events.PlayerMove{Id: builder.Id(), Xpos: 1.5, Ypos: 1.5}))
+	tick(g, 10*time.Millisecond)
+	tick(g, 10*time.Millisecond)
+	if g.state.Entity(building.Id()) != nil {
+		t.Errorf("unfinished building hasn't been removed on cancellation")
+	}
+	if got := resources(); got != PlayerStartingResources {
+		t.Errorf("resources after cancellation = %v, want %v", got, PlayerStartingResources)
+	}
+}
+
+/*
+ * completeBuilding induces enough build power into b to complete it
+ */
+func completeBuilding(b Building) {
+	for !b.IsBuilt() {
+		b.AddBuildPower(100)
+	}
+}
+
+func TestGameState_createBuildingBehaviors(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.gameData.buildingsData[WallBuilding] = &BuildingData{TotHp: 200, BuildingPowerRec: 20}
+
+	if _, ok := g.state.createBuilding(WallBuilding, d2.Vec2{1.5, 1.5}).(*Wall); !ok {
+		t.Errorf("wall building should have the wall behavior")
+	}
+	if _, ok := g.state.createBuilding(BarricadeBuilding, d2.Vec2{2.5, 1.5}).(*Barricade); !ok {
+		t.Errorf("barricade building should have the barricade behavior")
+	}
+	if _, ok := g.state.createBuilding(MgTurretBuilding, d2.Vec2{3.5, 1.5}).(*MgTurret); !ok {
+		t.Errorf("turret building should have the turret behavior")
+	}
+
+	// the building data decides of the behavior
+	g.gameData.buildingsData[BarricadeBuilding].Behavior = TurretBehavior
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 1.5})
+	if _, ok := b.(*MgTurret); !ok || b.Type() != BarricadeBuilding {
+		t.Errorf("createBuilding() = %T of type %v, want a turret of type %v", b, b.Type(), BarricadeBuilding)
+	}
+}
+
+func TestGameState_onPlayerBuild_Unsupported(t *testing.T) {
+	g := newTestGame(t, closetRoom...)
+	g.state.BuildingData(BarricadeBuilding).Cost = 15
+	g.state.BuildingData(BarricadeBuilding).Behavior = "catapult"
+	builder := addTestPlayer(g, EngineerEntity, d2.Vec2{3.5, 3.5})
+
+	// a building that can't be created is rejected, and not paid for
+	g.PostEvent(events.NewEvent(events.PlayerBuildId, events.PlayerBuild{
+		Id: builder.Id(), Type: uint8(BarricadeBuilding), Xpos: 5.5, Ypos: 2.5}))
+	tick(g, 10*time.Millisecond)
+	tick(g, 10*time.Millisecond)
+	if builder.curBuilding != nil {
+		t.Errorf("unsupported building has been placed")
+	}
+	if tile, _ := g.state.world.TileAtWorldVec(d2.Vec2{5.5, 2.5}); tile.HasBuilding() {
+		t.Errorf("unsupported building has been attached to its tile")
+	}
+	if got := builder.inventory.Count(ResourceItem); got != PlayerStartingResources {
+		t.Errorf("resources = %v, want %v", got, PlayerStartingResources)
+	}
+}
+
+func TestBuildingData_validate(t *testing.T) {
+	tests := []struct {
+		data  BuildingData
+		valid bool
+	}{
+		{BuildingData{}, true},
+		{BuildingData{Behavior: WallBehavior}, true},
+		{BuildingData{Behavior: "moat"}, false},
+		{BuildingData{Range: -1}, false},
+		{BuildingData{Cooldown: -1}, false},
+		{BuildingData{Targeting: TurretTargetsWeakest}, true},
+		{BuildingData{Targeting: "strongest"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.data.validate(MgTurretBuilding); (err == nil) != tt.valid {
+			t.Errorf("validate(%+v) = %v, want valid = %v", tt.data, err, tt.valid)
+		}
+	}
+}
+
+func TestMgTurret_ShootsZombies(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.gameData.buildingsData[MgTurretBuilding].Power = 5
+	g.gameData.buildingsData[MgTurretBuilding].Range = 4
+	mg := g.state.createBuilding(MgTurretBuilding, d2.Vec2{1.5, 2.5}).(*MgTurret)
+	near := addTestZombie(g, d2.Vec2{4.5, 2.5})
+	far := addTestZombie(g, d2.Vec2{6.5, 2.5})
+
+	// turrets under construction don't shoot
+	mg.Update(100 * time.Millisecond)
+	if near.health.Cur != near.health.Total || mg.target != InvalidID {
+		t.Fatalf("turret under construction shot, zombie at %v HP", near.health.Cur)
+	}
+
+	// once built, it shoots the zombie in range, once per cooldown
+	completeBuilding(mg)
+	for i := 0; i < 10; i++ {
+		mg.Update(100 * time.Millisecond)
+	}
+	if id, ok := mg.Target(); !ok || id != near.Id() {
+		t.Errorf("turret Target() = %v, %v, want the zombie in range %v", id, ok, near.Id())
+	}
+	if want := near.health.Total - 2*5; near.health.Cur != want {
+		t.Errorf("zombie in range at %v HP after 2 cooldowns, want %v", near.health.Cur, want)
+	}
+	if far.health.Cur != far.health.Total {
+		t.Errorf("zombie out of range at %v HP, want %v", far.health.Cur, far.health.Total)
+	}
+
+	// nothing to shoot at
+	g.state.RemoveEntity(near.Id())
+	mg.Update(time.Second)
+	if mg.target != InvalidID || far.health.Cur != far.health.Total {
+		t.Errorf("turret target = %v, zombie out of range at %v HP", mg.target, far.health.Cur)
+	}
+}
+
+func TestMgTurret_RangedDamage(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.gameData.buildingsData[MgTurretBuilding].Power = 10
+	mg := g.state.createBuilding(MgTurretBuilding, d2.Vec2{1.5, 2.5}).(*MgTurret)
+	completeBuilding(mg)
+	z := addTestZombie(g, d2.Vec2{3.5, 2.5})
+	z.resists[MeleeDamage] = 1
+	z.resists[RangedDamage] = 0.5
+
+	// turret bullets are ranged damage, whatever the melee resistance
+	mg.Update(100 * time.Millisecond)
+	if want := z.health.Total - 5; z.health.Cur != want {
+		t.Errorf("zombie at %v HP once shot, want %v", z.health.Cur, want)
+	}
+}
+
+func TestZombie_IgnoresWalls(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.gameData.buildingsData[WallBuilding] = &BuildingData{TotHp: 200, BuildingPowerRec: 20}
+	z := addTestZombie(g, d2.Vec2{4.5, 2.5})
+	wall := g.state.createBuilding(WallBuilding, d2.Vec2{3.5, 2.5})
+	completeBuilding(wall)
+	if target := z.findTarget(); target != nil {
+		t.Errorf("zombie targets %v, want walls to be ignored", target)
+	}
+
+	barricade := g.state.createBuilding(BarricadeBuilding, d2.Vec2{5.5, 2.5})
+	completeBuilding(barricade)
+	if target := z.findTarget(); target != barricade {
+		t.Errorf("zombie targets %v, want the barricade %v", target, barricade)
+	}
+}
+
+func TestMgTurret_LineOfSight(t *testing.T) {
+	g := newTestGame(t, pillarRoom...)
+	mg := g.state.createBuilding(MgTurretBuilding, d2.Vec2{2.5, 1.5}).(*MgTurret)
+	completeBuilding(mg)
+	hidden := addTestZombie(g, d2.Vec2{5.5, 3.5})
+	open := addTestZombie(g, d2.Vec2{6.5, 1.5})
+
+	// the closest zombie is behind the pillar
+	for i := 0; i < 10; i++ {
+		mg.Update(100 * time.Millisecond)
+	}
+	if mg.target != open.Id() {
+		t.Errorf("turret target = %v, want the zombie in the open %v", mg.target, open.Id())
+	}
+	if hidden.health.Cur != hidden.health.Total {
+		t.Errorf("zombie behind cover at %v HP, want %v", hidden.health.Cur, hidden.health.Total)
+	}
+	if want := open.health.Total - 2*DefaultTurretPower; open.health.Cur != want {
+		t.Errorf("zombie in the open at %v HP, want %v", open.health.Cur, want)
+	}
+
+	// buildings give cover too
+	g.state.RemoveEntity(open.Id())
+	open = addTestZombie(g, d2.Vec2{6.5, 1.5})
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 1.5})
+	mg.Update(time.Second)
+	if mg.target != InvalidID || open.health.Cur != open.health.Total {
+		t.Errorf("turret shot zombie %v behind a barricade, now at %v HP", mg.target, open.health.Cur)
+	}
+}
+
+func TestMgTurret_TargetingPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   int // index of the zombie targeted
+	}{
+		{"", 0},
+		{TurretTargetsNearest, 0},
+		{TurretTargetsWeakest, 1},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, openRoom...)
+		g.gameData.buildingsData[MgTurretBuilding].Targeting = tt.policy
+		mg := g.state.createBuilding(MgTurretBuilding, d2.Vec2{1.5, 2.5}).(*MgTurret)
+		completeBuilding(mg)
+		zombies := []*Zombie{
+			addTestZombie(g, d2.Vec2{3.5, 2.5}),
+			addTestZombie(g, d2.Vec2{6.5, 1.5}),
+		}
+		zombies[1].DealDamage(20, MeleeDamage)
+
+		mg.Update(100 * time.Millisecond)
+		if want := zombies[tt.want].Id(); mg.target != want {
+			t.Errorf("policy %q: turret target = %v, want %v", tt.policy, mg.target, want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Surviveler package
+ * combat hits
+ */
+package surviveler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+// length of the steps in which a knockback is checked against collisions
+const knockbackStep = 0.1
+
+/*
+ * knockbackResistant is implemented by the entities that can be immune to
+ * the knockbacks
+ */
+type knockbackResistant interface {
+	knockbackImmune() bool
+}
+
+/*
+ * newCombat creates the combat component of an entity that can't hit more
+ * than once per period, with the attack cooldown and the damage variance of
+ * the configuration. Its damage variance is only drawn once seeded, see
+ * seedCombat.
+ */
+func newCombat(cfg Config, power uint16, period time.Duration) *Combat {
+	c := NewCombat(power)
+	c.Variance = cfg.DamageVariance
+	c.Cooldown = time.Duration(cfg.AttackCooldown) * time.Millisecond
+	if c.Cooldown < period {
+		c.Cooldown = period
+	}
+	return c
+}
+
+/*
+ * seedCombat gives the combat component of an entity its own random number
+ * generator, derived from the game one and the entity id, so that the
+ * variance drawn doesn't depend on the order in which entities are updated
+ */
+func seedCombat(g *Game, c *Combat, name string, id uint32) {
+	c.rng = g.rng.Derive(fmt.Sprintf("%s %d combat", name, id))
+}
+
+/*
+ * setHitEffects configures the knockback and the stagger of the hits dealt
+ * by an entity, from its entity data
+ */
+func setHitEffects(e Entity, data *EntityData) {
+	var c *Combat
+	if GetComponent(e, &c) {
+		c.Knockback = data.Knockback
+		c.Stagger = time.Duration(data.StaggerTime * float32(time.Second))
+	}
+}
+
+/*
+ * dealHit deals the damage of an attacker hit to target, and returns true if
+ * the target died.
+ *
+ * The damage varies by up to the attacker variance, it's then modified by the
+ * attacker damage modifiers and dealt as typ damage, and the attacker can't
+ * hit again before the end of its cooldown. A surviving target having a
+ * Movable component is pushed back, away from the attacker, by the attacker
+ * knockback distance, or less if a wall or an obstacle stops it, unless it's
+ * immune to the knockbacks. A target having a Stagger component can't act for
+ * the attacker stagger time.
+ */
+func dealHit(w *World, attacker, target Entity, c *Combat, typ DamageType) (dead bool) {
+	c.Hit()
+	if dead = target.DealDamage(dealtDamage(attacker, c.Damage()), typ); dead {
+		return
+	}
+	if c.Stagger > 0 {
+		var s *Stagger
+		if GetComponent(target, &s) {
+			s.Start(c.Stagger)
+		}
+	}
+	if kr, ok := target.(knockbackResistant); ok && kr.knockbackImmune() {
+		return
+	}
+	var mv *Movable
+	if c.Knockback > 0 && GetComponent(target, &mv) {
+		knockBack(w, target, mv, attacker.Position(), c.Knockback)
+	}
+	return
+}
+
+/*
+ * knockBack pushes target, moved by mv, away from org by dist, step by step,
+ * until a wall or an obstacle stops it
+ */
+func knockBack(w *World, target Entity, mv *Movable, org d2.Vec2, dist float32) {
+	dir := mv.Pos.Sub(org)
+	if dir.Len() < 1e-6 {
+		return
+	}
+	dir.Normalize()
+	start := mv.Pos
+	var pushed bool
+	for moved := float32(0); moved < dist; moved += knockbackStep {
+		step := math32.Min(knockbackStep, dist-moved)
+		next := mv.Pos.Add(dir.Scale(step))
+		if _, free := mv.canMoveTo(w, target, next, isKnockbackObstacle); !free {
+			break
+		}
+		// pushed back, not turned around
+		mv.Pos, pushed = next, true
+	}
+	if pushed {
+		var mg *MoveGuard
+		if GetComponent(target, &mg) {
+			mg.Allow(mv.Pos.Sub(start).Len())
+		}
+		w.UpdateEntity(target)
+	}
+}
+
+/*
+ * isKnockbackObstacle indicates if an entity stops an entity being knocked
+ * back
+ */
+func isKnockbackObstacle(e Entity) bool {
+	switch e.(type) {
+	case *Player, *Zombie, Building:
+		return true
+	}
+	return false
+}
@@ -59,3 +59,17 @@ func (gs *GameState) getBuilding(ID uint32) Building {
 	}
 	return nil
 }
+
+/*
+ * getItem returns the Item associated to given ID.
+ *
+ * It returns nil if ID doesn't exist or the entity is not an Item.
+ */
+func (gs *GameState) getItem(ID uint32) *Item {
+	if e, ok := gs.entities[ID]; ok {
+		if it, ok := e.(*Item); ok {
+			return it
+		}
+	}
+	return nil
+}
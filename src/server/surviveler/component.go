@@ -0,0 +1,402 @@
+/*
+ * Surviveler package
+ * entity components
+ */
+package surviveler
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+/*
+ * Components is a registry of the components attached to an entity.
+ *
+ * Components are attached and fetched by type, so that the systems working
+ * on entities (movement, collision, combat, etc.) only depend on the
+ * components they need, and not on the concrete entity types. It is meant to
+ * be embedded in entities.
+ */
+type Components struct {
+	comps map[reflect.Type]interface{}
+}
+
+/*
+ * ComponentHolder is the interface implemented by entities accepting
+ * components
+ */
+type ComponentHolder interface {
+	AddComponent(comp interface{})
+	GetComponent(target interface{}) bool
+}
+
+/*
+ * AddComponent attaches a component.
+ *
+ * A component already attached with the same type is replaced.
+ */
+func (c *Components) AddComponent(comp interface{}) {
+	if c.comps == nil {
+		c.comps = make(map[reflect.Type]interface{})
+	}
+	c.comps[reflect.TypeOf(comp)] = comp
+}
+
+/*
+ * GetComponent fetches the component having the type pointed to by target.
+ *
+ * If such a component is attached, it is stored in target and GetComponent
+ * returns true. target must be a non-nil pointer, for example:
+ *
+ *   var mv *Movable
+ *   if ent.GetComponent(&mv) {
+ *       // use mv
+ *   }
+ */
+func (c *Components) GetComponent(target interface{}) bool {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		panic("GetComponent target must be a non-nil pointer")
+	}
+	comp, ok := c.comps[v.Type().Elem()]
+	if !ok {
+		return false
+	}
+	v.Elem().Set(reflect.ValueOf(comp))
+	return true
+}
+
+/*
+ * eachComponent calls fn on every attached component, in the order of their
+ * type names
+ */
+func (c *Components) eachComponent(fn func(comp interface{})) {
+	types := make([]reflect.Type, 0, len(c.comps))
+	for t := range c.comps {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].String() < types[j].String() })
+	for _, t := range types {
+		fn(c.comps[t])
+	}
+}
+
+/*
+ * GetComponent fetches a component of an entity (see Components.GetComponent).
+ *
+ * It returns false if the entity doesn't accept components.
+ */
+func GetComponent(e Entity, target interface{}) bool {
+	if ch, ok := e.(ComponentHolder); ok {
+		return ch.GetComponent(target)
+	}
+	return false
+}
+
+/*
+ * Health is the component holding the hit points of an entity
+ */
+type Health struct {
+	Total float32 // total hit points
+	Cur   float32 // current hit points
+}
+
+/*
+ * NewHealth creates a health component with all its hit points
+ */
+func NewHealth(total float32) *Health {
+	return &Health{Total: total, Cur: total}
+}
+
+/*
+ * Damage removes hit points, it returns true if no hit points are left
+ */
+func (h *Health) Damage(damage float32) (dead bool) {
+	if damage >= h.Cur {
+		h.Cur = 0
+		return true
+	}
+	h.Cur -= damage
+	return false
+}
+
+/*
+ * Heal gives hit points back, it returns true if all the hit points have been
+ * recovered
+ */
+func (h *Health) Heal(heal float32) (healthy bool) {
+	if heal+h.Cur >= h.Total {
+		h.Cur = h.Total
+		return true
+	}
+	h.Cur += heal
+	return false
+}
+
+/*
+ * Combat is the component holding the fighting abilities of an entity
+ */
+type Combat struct {
+	Power     uint16        // damage dealt on each attack
+	Variance  float32       // damage varies by up to this fraction of the power
+	Cooldown  time.Duration // minimum time between 2 hits
+	Knockback float32       // distance the targets are pushed back, 0 for none
+	Stagger   time.Duration // time during which the targets can't act
+	rng       *RNG          // draws the damage variance, no variance if nil
+	left      time.Duration // time left before the next hit can land
+}
+
+/*
+ * NewCombat creates a combat component
+ */
+func NewCombat(power uint16) *Combat {
+	return &Combat{Power: power}
+}
+
+/*
+ * Damage returns the damage of a hit, the power varying randomly by up to
+ * the variance
+ */
+func (c *Combat) Damage() float32 {
+	dmg := float32(c.Power)
+	if c.Variance > 0 && c.rng != nil {
+		dmg *= 1 + c.rng.Range(-c.Variance, c.Variance)
+	}
+	return dmg
+}
+
+/*
+ * Ready indicates if the cooldown since the last hit is over
+ */
+func (c *Combat) Ready() bool {
+	return c.left <= 0
+}
+
+/*
+ * Hit starts the cooldown of a hit that just landed
+ */
+func (c *Combat) Hit() {
+	c.left = c.Cooldown
+}
+
+/*
+ * Tick lets dt elapse on the cooldown
+ */
+func (c *Combat) Tick(dt time.Duration) {
+	if c.left > 0 {
+		c.left -= dt
+	}
+}
+
+/*
+ * Stagger is the component of an entity that can be staggered by a hit, so
+ * that it can't act for a while
+ */
+type Stagger struct {
+	left time.Duration // time left staggered
+}
+
+/*
+ * Start staggers the entity for d, unless it's already staggered for longer
+ */
+func (s *Stagger) Start(d time.Duration) {
+	if d > s.left {
+		s.left = d
+	}
+}
+
+/*
+ * Staggered indicates if the entity can't act
+ */
+func (s *Stagger) Staggered() bool {
+	return s.left > 0
+}
+
+/*
+ * Tick lets dt elapse, it returns true if the entity was staggered, and thus
+ * can't act during dt
+ */
+func (s *Stagger) Tick(dt time.Duration) bool {
+	if s.left <= 0 {
+		return false
+	}
+	s.left -= dt
+	return true
+}
+
+/*
+ * Cooldown times an action that can't be repeated before its period has
+ * elapsed. It's ticked with the logic time, so that it doesn't depend on the
+ * wall clock.
+ */
+type Cooldown struct {
+	Period time.Duration // minimum time between 2 actions
+	left   time.Duration // time left before the action can be repeated
+}
+
+/*
+ * Ready indicates if the period since the last action is over
+ */
+func (c *Cooldown) Ready() bool {
+	return c.left <= 0
+}
+
+/*
+ * Start starts the period of an action that was just performed
+ */
+func (c *Cooldown) Start() {
+	c.left = c.Period
+}
+
+/*
+ * Reset makes the action available at once
+ */
+func (c *Cooldown) Reset() {
+	c.left = 0
+}
+
+/*
+ * Tick lets dt elapse on the cooldown
+ */
+func (c *Cooldown) Tick(dt time.Duration) {
+	if c.left > 0 {
+		c.left -= dt
+	}
+}
+
+/*
+ * Regen is the component regenerating the hit points of an entity, once it
+ * hasn't been hurt for a while
+ */
+type Regen struct {
+	Delay  time.Duration // time without being hurt before regenerating
+	Rate   float32       // hit points regenerated per second, 0 to disable
+	calm   time.Duration // time since the entity was last hurt
+	active bool          // hit points have been regenerated during the last tick
+}
+
+/*
+ * NewRegen creates a regeneration component
+ */
+func NewRegen(delay time.Duration, rate float32) *Regen {
+	return &Regen{Delay: delay, Rate: rate}
+}
+
+/*
+ * Hurt restarts the delay before the hit points regenerate
+ */
+func (r *Regen) Hurt() {
+	r.calm = 0
+	r.active = false
+}
+
+/*
+ * Regenerating indicates if the hit points are regenerating
+ */
+func (r *Regen) Regenerating() bool {
+	return r.active
+}
+
+/*
+ * Tick lets dt elapse, and regenerates the hit points of h for the part of
+ * dt that is past the delay. A dead entity doesn't regenerate.
+ */
+func (r *Regen) Tick(h *Health, dt time.Duration) {
+	r.active = false
+	if r.Rate <= 0 || h.Cur <= 0 {
+		return
+	}
+	calm := r.calm + dt
+	if r.calm < r.Delay {
+		dt = calm - r.Delay
+		r.calm = calm
+	}
+	if dt <= 0 || h.Cur >= h.Total {
+		return
+	}
+	h.Heal(r.Rate * float32(dt.Seconds()))
+	r.active = true
+}
+
+/*
+ * Protection is the component shielding an entity for a while, as newly
+ * joined players are: it can't be targeted nor hurt
+ */
+type Protection struct {
+	left time.Duration // time left protected
+}
+
+/*
+ * Start protects the entity for d, unless it's already protected for longer
+ */
+func (p *Protection) Start(d time.Duration) {
+	if d > p.left {
+		p.left = d
+	}
+}
+
+/*
+ * Active indicates if the entity is protected
+ */
+func (p *Protection) Active() bool {
+	return p.left > 0
+}
+
+/*
+ * Tick lets dt elapse
+ */
+func (p *Protection) Tick(dt time.Duration) {
+	if p.left > 0 {
+		p.left -= dt
+	}
+}
+
+/*
+ * isProtected indicates if an entity can't currently be targeted nor hurt
+ */
+func isProtected(e Entity) bool {
+	var p *Protection
+	return GetComponent(e, &p) && p.Active()
+}
+
+/*
+ * Inventory is the component holding the items carried by an entity, counted
+ * by item type
+ */
+type Inventory struct {
+	items map[EntityType]uint16
+}
+
+/*
+ * NewInventory creates an empty inventory
+ */
+func NewInventory() *Inventory {
+	return &Inventory{items: make(map[EntityType]uint16)}
+}
+
+/*
+ * Add adds n items of type t
+ */
+func (inv *Inventory) Add(t EntityType, n uint16) {
+	inv.items[t] += n
+}
+
+/*
+ * Count returns the number of items of type t
+ */
+func (inv *Inventory) Count(t EntityType) uint16 {
+	return inv.items[t]
+}
+
+/*
+ * Take removes n items of type t, it returns false, removing nothing, if
+ * there are less than n of them
+ */
+func (inv *Inventory) Take(t EntityType, n uint16) bool {
+	if inv.items[t] < n {
+		return false
+	}
+	inv.items[t] -= n
+	return true
+}
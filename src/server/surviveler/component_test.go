@@ -0,0 +1,99 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestComponents_AddGetComponent(t *testing.T) {
+	var c Components
+
+	var h *Health
+	if c.GetComponent(&h) {
+		t.Fatalf("GetComponent() = true on an empty registry")
+	}
+
+	health := NewHealth(100)
+	c.AddComponent(health)
+	c.AddComponent(NewCombat(10))
+	if !c.GetComponent(&h) || h != health {
+		t.Errorf("GetComponent() = %v, want %v", h, health)
+	}
+	var cb *Combat
+	if !c.GetComponent(&cb) || cb.Power != 10 {
+		t.Errorf("GetComponent() = %v, want combat power 10", cb)
+	}
+
+	// adding a component of the same type replaces it
+	c.AddComponent(NewHealth(50))
+	if c.GetComponent(&h); h.Total != 50 {
+		t.Errorf("GetComponent() = %v, want replaced health", h)
+	}
+}
+
+func TestGetComponent_Entities(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 3.5})
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+
+	for _, ent := range []Entity{p, z} {
+		var (
+			mv *Movable
+			h  *Health
+			cb *Combat
+		)
+		if !GetComponent(ent, &mv) || !GetComponent(ent, &h) || !GetComponent(ent, &cb) {
+			t.Errorf("%T should have movable, health and combat components", ent)
+		}
+	}
+	var mv *Movable
+	if GetComponent(b, &mv) {
+		t.Errorf("GetComponent() = true for a building")
+	}
+}
+
+func TestMovable_PlayerAndZombieCollideIdentically(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 3.5})
+
+	// each one walks in its own lane towards a building
+	for _, ent := range []Entity{p, z} {
+		lane := ent.Position()[1]
+		g.state.createBuilding(BarricadeBuilding, d2.Vec2{5.5, lane})
+
+		var mv *Movable
+		GetComponent(ent, &mv)
+		mv.Speed = 2
+		mv.SetPath(Path{d2.Vec2{7.5, lane}})
+	}
+
+	var obstacles [2]Entity
+	for i := 0; i < 100; i++ {
+		for j, ent := range []Entity{p, z} {
+			var mv *Movable
+			GetComponent(ent, &mv)
+			if _, obstacle := mv.MoveOrSlide(g.state.World(), ent, 50*time.Millisecond, isPlayerObstacle); obstacle != nil {
+				obstacles[j] = obstacle
+			}
+			g.state.World().UpdateEntity(ent)
+		}
+	}
+
+	for j, ent := range []Entity{p, z} {
+		if b, ok := obstacles[j].(Building); !ok {
+			t.Errorf("%T obstacle = %v, want a building", ent, obstacles[j])
+		} else if ent.Rectangle().Overlaps(b.Rectangle()) {
+			t.Errorf("%T at %v walked into the building", ent, ent.Position())
+		}
+	}
+	if p.Pos[0] != z.Pos[0] {
+		t.Errorf("player stopped at x = %v, zombie at x = %v, want the same", p.Pos[0], z.Pos[0])
+	}
+	if p.Pos[1] != 1.5 || z.Pos[1] != 3.5 {
+		t.Errorf("player and zombie shouldn't have left their lane, got %v and %v", p.Pos, z.Pos)
+	}
+}
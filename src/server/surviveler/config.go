@@ -4,8 +4,84 @@
  */
 package surviveler
 
+import (
+	"fmt"
+	"server/logging"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
 const DefaultLogLevel string = "Debug"
 
+/*
+ * Accepted range for the tick periods, in milliseconds
+ */
+const (
+	MinTickPeriod = 1
+	MaxTickPeriod = 10000
+)
+
+/*
+ * Accepted range for the grid scale, in tiles per world unit.
+ *
+ * A finer grid gives more precise paths, but the number of tiles, hence of
+ * A* nodes, grows with the square of the scale.
+ */
+const (
+	MinGridScale = 0.25
+	MaxGridScale = 8
+)
+
+/*
+ * What becomes of the client events received while the game is paused
+ */
+const (
+	PauseEventsQueue = "queue" // processed once the game is resumed
+	PauseEventsDrop  = "drop"  // ignored
+)
+
+/*
+ * How the zombies choose between the players and the buildings in range
+ */
+const (
+	ZombieTargetsPlayers   = "players"   // players first
+	ZombieTargetsBuildings = "buildings" // buildings first
+	ZombieTargetsNearest   = "nearest"   // whichever is closer
+	ZombieTargetsWeakest   = "weakest"   // whichever has the fewest hit points left
+)
+
+/*
+ * How the spawned zombies are scattered around their spawn point
+ */
+const (
+	SpawnPatternUniform = "uniform" // evenly within the jitter radius
+	SpawnPatternCluster = "cluster" // mostly close to the spawn point
+	SpawnPatternSpread  = "spread"  // on the outer half of the jitter radius
+)
+
+/*
+ * How the zombie stats grow with the waves
+ */
+const (
+	WaveScalingLinear      = "linear"      // by the same amount each wave, +10% per wave makes +40% on wave 5
+	WaveScalingExponential = "exponential" // compounded, +10% per wave makes +46% on wave 5
+)
+
+/*
+ * What becomes of the zombie spawns beyond the entity cap
+ */
+const (
+	SpawnsAtCapQueue  = "queue"  // spawned once deaths make room under the cap
+	SpawnsAtCapRefuse = "refuse" // dropped
+)
+
+/*
+ * Number of minutes in a game day
+ */
+const minutesPerDay = 1440
+
 /*
  * Config contains all the configurable server-specific game settings
  */
@@ -19,7 +95,51 @@ type Config struct {
 	NightEndingTime   int
 	GameStartingTime  int
 	TelnetPort        string
+	TelnetPassword    string // password the telnet clients must give before any command, empty for none
 	AssetsPath        string
+	RecordPath        string
+	ReplayPath        string
+	MetricsPort       string
+	PlayerWaypoints   int     // waypoints sent in player moves, -1 for the whole path
+	ZombieWaypoints   int     // waypoints sent in zombie moves, -1 for the whole path
+	ArrivalTolerance  float32 // distance under which the players and zombies have reached a waypoint
+	SlowdownRadius    float32 // distance to their destination under which the players slow down, 0 to disable
+	ReconnectGrace    int     // seconds left to disconnected players to resume, 0 to disable
+	FriendlyFire      bool    // players can hurt the players of their own faction
+	PlayerFactions    int     // number of player factions the joining players are spread among, 1 for co-op
+	GridScale         float32 // grid tiles per world unit, 0 to use the map scale factor
+	GridCache         string  // directory in which the world grid is cached, empty to disable
+	MapBorder         float32 // width of the impassable border along the map edges, 0 for none
+	PathBudget        int     // max number of tiles explored by a path search, 0 for no limit
+	Seed              int64   // seed of the random number generators, 0 for a random seed
+	PlayerRegenDelay  int     // milliseconds a player must go unhurt before regenerating hit points
+	PlayerRegenRate   float32 // hit points regenerated per second by unhurt players, 0 to disable
+	SpawnProtection   int     // milliseconds newly joined players can't be targeted nor hurt, 0 to disable
+	ZombieChaseTime   int     // seconds a zombie chases a target before giving up, 0 to disable
+	ZombieLeash       float32 // max distance from its spawn point at which a zombie chases, 0 to disable
+	ZombieDyingTime   int     // milliseconds a killed zombie lies dying before being removed, 0 to remove it at once
+	RepathInterval    int     // min milliseconds between 2 path searches of a zombie chasing a target
+	RepathDistance    float32 // distance its target must move for a chasing zombie to search a new path, 0 to search at each interval
+	AttackCooldown    int     // minimum milliseconds between 2 hits of an entity, on top of its attack rate
+	DamageVariance    float32 // the damage of a hit varies by up to this fraction of the combat power
+	ModifierStacking  string  // how the modifiers of a same entity stat stack
+	MeleeArc          float32 // degrees swept by the player melee hits, hurting every enemy within, 0 to only hurt the target
+	MeleeRange        float32 // reach of the player melee hits
+	ZombieTargets     string  // how zombies choose between players and buildings, see targetScores
+	SpawnJitter       float32 // max distance of a spawned zombie from its spawn point, 0 to spawn right on it
+	SpawnPattern      string  // how spawned zombies are scattered within the spawn jitter
+	WaveScaling       string  // how the stats of the zombies grow with the waves, a wave being a night
+	WaveHPGrowth      float32 // fraction of their hit points the zombies gain per wave, 0 for none
+	WaveDamageGrowth  float32 // fraction of their damage the zombies gain per wave, 0 for none
+	Rooms             int     // number of isolated game rooms hosted by the server
+	PauseEvents       string  // client events received while paused are queued or dropped
+	AlignSendTicks    bool    // game states are sent right after the logic ticks, see sendTickRatio
+	SendVelocities    bool    // velocities of the moving entities are sent in the game states
+	ViewRadius        float32 // max radius around their player within which entities are sent to the clients, 0 for no limit
+	SlowClientDrops   int     // consecutive messages a client can miss, not reading fast enough, before being disconnected, 0 to never disconnect
+	MaxEntities       int     // max number of entities in game, beyond which zombie spawns are held, 0 for no limit
+	SpawnsAtCap       string  // zombie spawns beyond the entity cap are queued or refused
+	Logging           logging.Config
 }
 
 /*
@@ -37,5 +157,200 @@ func NewConfig() Config {
 		GameStartingTime:  480,
 		TelnetPort:        "1235",
 		AssetsPath:        "data",
+		PlayerWaypoints:   2,
+		ZombieWaypoints:   2,
+		ArrivalTolerance:  0.05,
+		SlowdownRadius:    0.5,
+		ReconnectGrace:    30,
+		PlayerFactions:    1,
+		SlowClientDrops:   50,
+		PlayerRegenDelay:  5000,
+		SpawnProtection:   3000,
+		ZombieDyingTime:   1500,
+		RepathInterval:    200,
+		RepathDistance:    1,
+		ModifierStacking:  ModifierStackingMultiply,
+		MeleeRange:        1,
+		ZombieTargets:     ZombieTargetsPlayers,
+		SpawnJitter:       2,
+		SpawnPattern:      SpawnPatternUniform,
+		WaveScaling:       WaveScalingLinear,
+		WaveHPGrowth:      0.1,
+		WaveDamageGrowth:  0.05,
+		Rooms:             1,
+		PauseEvents:       PauseEventsQueue,
+		SpawnsAtCap:       SpawnsAtCapQueue,
+		Logging: logging.Config{
+			MaxSize:    10,
+			MaxBackups: 3,
+			Format:     logging.FormatText,
+		},
+	}
+}
+
+/*
+ * Validate checks the configuration settings, it returns an error describing
+ * every invalid setting, or nil if the configuration is valid
+ */
+func (cfg Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	checkPort := func(name, port string, optional bool) {
+		if optional && len(port) == 0 {
+			return
+		}
+		n, err := strconv.Atoi(port)
+		check(err == nil && n > 0 && n <= 65535,
+			"%s must be a number between 1 and 65535, got '%s'", name, port)
+	}
+	checkTickPeriod := func(name string, period int) {
+		check(period >= MinTickPeriod && period <= MaxTickPeriod,
+			"%s must be between %dms and %dms, got %d", name, MinTickPeriod, MaxTickPeriod, period)
+	}
+	checkGameTime := func(name string, t int) {
+		check(t >= 0 && t < minutesPerDay,
+			"%s must be a number of minutes between 0 and %d, got %d", name, minutesPerDay-1, t)
+	}
+
+	checkPort("port", cfg.Port, false)
+	checkPort("telnet port", cfg.TelnetPort, true)
+	checkPort("metrics port", cfg.MetricsPort, true)
+	checkTickPeriod("logic tick period", cfg.LogicTickPeriod)
+	checkTickPeriod("send tick period", cfg.SendTickPeriod)
+	check(cfg.SendTickPeriod >= cfg.LogicTickPeriod,
+		"send tick period can't be shorter than the logic tick period, got %dms < %dms",
+		cfg.SendTickPeriod, cfg.LogicTickPeriod)
+	check(cfg.TimeFactor > 0, "time factor must be positive, got %d", cfg.TimeFactor)
+	checkGameTime("night starting time", cfg.NightStartingTime)
+	checkGameTime("night ending time", cfg.NightEndingTime)
+	checkGameTime("game starting time", cfg.GameStartingTime)
+	check(len(cfg.AssetsPath) > 0, "assets path must be specified")
+	check(cfg.PlayerWaypoints >= -1, "player waypoints must be -1 or more, got %d", cfg.PlayerWaypoints)
+	check(cfg.ZombieWaypoints >= -1, "zombie waypoints must be -1 or more, got %d", cfg.ZombieWaypoints)
+	check(cfg.ReconnectGrace >= 0, "reconnect grace period can't be negative, got %d", cfg.ReconnectGrace)
+	check(cfg.PlayerFactions >= 1 && cfg.PlayerFactions <= MaxPlayerFactions,
+		"player factions must be between 1 and %d, got %d", MaxPlayerFactions, cfg.PlayerFactions)
+	check(cfg.GridScale >= 0, "grid scale can't be negative, got %v", cfg.GridScale)
+	check(cfg.MapBorder >= 0, "map border can't be negative, got %v", cfg.MapBorder)
+	check(cfg.PathBudget >= 0, "path budget can't be negative, got %d", cfg.PathBudget)
+	check(cfg.PlayerRegenDelay >= 0, "player regen delay can't be negative, got %d", cfg.PlayerRegenDelay)
+	check(cfg.PlayerRegenRate >= 0, "player regen rate can't be negative, got %v", cfg.PlayerRegenRate)
+	check(cfg.SpawnProtection >= 0, "spawn protection can't be negative, got %d", cfg.SpawnProtection)
+	check(cfg.ZombieChaseTime >= 0, "zombie chase time can't be negative, got %d", cfg.ZombieChaseTime)
+	check(cfg.ZombieLeash >= 0, "zombie leash can't be negative, got %v", cfg.ZombieLeash)
+	check(cfg.ZombieDyingTime >= 0, "zombie dying time can't be negative, got %d", cfg.ZombieDyingTime)
+	check(cfg.RepathInterval >= 0, "repath interval can't be negative, got %d", cfg.RepathInterval)
+	check(cfg.RepathDistance >= 0, "repath distance can't be negative, got %v", cfg.RepathDistance)
+	check(cfg.AttackCooldown >= 0, "attack cooldown can't be negative, got %d", cfg.AttackCooldown)
+	check(cfg.DamageVariance >= 0 && cfg.DamageVariance <= 1,
+		"damage variance must be in [0, 1], got %v", cfg.DamageVariance)
+	check(cfg.ModifierStacking == ModifierStackingAdd || cfg.ModifierStacking == ModifierStackingMultiply ||
+		cfg.ModifierStacking == ModifierStackingStrongest, "modifier stacking must be '%s', '%s' or '%s', got '%s'",
+		ModifierStackingAdd, ModifierStackingMultiply, ModifierStackingStrongest, cfg.ModifierStacking)
+	check(cfg.MeleeArc >= 0 && cfg.MeleeArc <= 360, "melee arc must be in [0, 360], got %v", cfg.MeleeArc)
+	check(cfg.ArrivalTolerance > 0, "arrival tolerance must be positive, got %v", cfg.ArrivalTolerance)
+	check(cfg.SlowdownRadius >= 0, "slowdown radius can't be negative, got %v", cfg.SlowdownRadius)
+	check(cfg.MeleeRange > 0, "melee range must be positive, got %v", cfg.MeleeRange)
+	_, ok := targetScores[cfg.ZombieTargets]
+	check(ok, "zombie targets must be '%s', '%s', '%s' or '%s', got '%s'", ZombieTargetsPlayers,
+		ZombieTargetsBuildings, ZombieTargetsNearest, ZombieTargetsWeakest, cfg.ZombieTargets)
+	check(cfg.SpawnJitter >= 0, "spawn jitter can't be negative, got %v", cfg.SpawnJitter)
+	check(cfg.SpawnPattern == SpawnPatternUniform || cfg.SpawnPattern == SpawnPatternCluster ||
+		cfg.SpawnPattern == SpawnPatternSpread, "spawn pattern must be '%s', '%s' or '%s', got '%s'",
+		SpawnPatternUniform, SpawnPatternCluster, SpawnPatternSpread, cfg.SpawnPattern)
+	check(cfg.WaveScaling == WaveScalingLinear || cfg.WaveScaling == WaveScalingExponential,
+		"wave scaling must be '%s' or '%s', got '%s'", WaveScalingLinear, WaveScalingExponential, cfg.WaveScaling)
+	check(cfg.WaveHPGrowth >= 0, "wave HP growth can't be negative, got %v", cfg.WaveHPGrowth)
+	check(cfg.WaveDamageGrowth >= 0, "wave damage growth can't be negative, got %v", cfg.WaveDamageGrowth)
+	check(cfg.Logging.MaxSize >= 0, "log file max size can't be negative, got %d", cfg.Logging.MaxSize)
+	check(cfg.Logging.MaxBackups >= 0, "log file max backups can't be negative, got %d", cfg.Logging.MaxBackups)
+	if _, err := logging.ParseLevels(cfg.Logging.Modules); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if _, err := logging.ParseFormat(cfg.Logging.Format); err != nil {
+		errs = append(errs, err.Error())
+	}
+	check(len(cfg.RecordPath) == 0 || len(cfg.ReplayPath) == 0,
+		"a session can't be recorded and replayed at the same time")
+	check(cfg.PauseEvents == PauseEventsQueue || cfg.PauseEvents == PauseEventsDrop,
+		"pause events must be '%s' or '%s', got '%s'", PauseEventsQueue, PauseEventsDrop, cfg.PauseEvents)
+	check(cfg.ViewRadius >= 0, "view radius can't be negative, got %v", cfg.ViewRadius)
+	check(cfg.SlowClientDrops >= 0, "slow client drops can't be negative, got %d", cfg.SlowClientDrops)
+	check(cfg.MaxEntities >= 0, "max number of entities can't be negative, got %d", cfg.MaxEntities)
+	check(cfg.SpawnsAtCap == SpawnsAtCapQueue || cfg.SpawnsAtCap == SpawnsAtCapRefuse,
+		"spawns at cap must be '%s' or '%s', got '%s'", SpawnsAtCapQueue, SpawnsAtCapRefuse, cfg.SpawnsAtCap)
+	check(cfg.Rooms > 0, "number of rooms must be positive, got %d", cfg.Rooms)
+	check(cfg.Rooms == 1 || len(cfg.RecordPath) == 0 && len(cfg.ReplayPath) == 0,
+		"sessions can only be recorded or replayed with a single room")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+/*
+ * sendTickRatio returns the number of logic ticks per send tick, rounded to
+ * the nearest integer.
+ *
+ * Each game state sent to the clients should reflect new logic ticks: a send
+ * period shorter than the logic period sends identical game states, and a
+ * send period that isn't a multiple of the logic period sends game states
+ * reflecting a varying number of logic ticks, which the clients perceive as
+ * jitter. With AlignSendTicks, the game state is sent right after every
+ * sendTickRatio logic ticks, the actual send period being rounded to a
+ * multiple of the logic period.
+ */
+func (cfg Config) sendTickRatio() int {
+	if cfg.LogicTickPeriod <= 0 {
+		return 1
+	}
+	ratio := (cfg.SendTickPeriod + cfg.LogicTickPeriod/2) / cfg.LogicTickPeriod
+	if ratio < 1 {
+		ratio = 1
+	}
+	return ratio
+}
+
+/*
+ * checkTickPeriods warns if the send tick period isn't a multiple of the
+ * logic tick period, it returns false if so
+ */
+func (cfg Config) checkTickPeriods() bool {
+	if cfg.LogicTickPeriod <= 0 || cfg.SendTickPeriod%cfg.LogicTickPeriod == 0 {
+		return true
+	}
+	fields := log.Fields{
+		"send":  cfg.SendTickPeriod,
+		"logic": cfg.LogicTickPeriod,
+	}
+	if cfg.AlignSendTicks {
+		fields["aligned"] = cfg.sendTickRatio() * cfg.LogicTickPeriod
+		log.WithFields(fields).Warn("Send tick period isn't a multiple of the logic tick period, rounded")
+	} else {
+		log.WithFields(fields).Warn("Send tick period isn't a multiple of the logic tick period, game states will jitter")
+	}
+	return false
+}
+
+/*
+ * clampGridScale clamps a grid scale to the accepted range, with a warning
+ */
+func clampGridScale(scale float32) float32 {
+	clamped := scale
+	switch {
+	case scale < MinGridScale:
+		clamped = MinGridScale
+	case scale > MaxGridScale:
+		clamped = MaxGridScale
+	}
+	if clamped != scale {
+		log.WithFields(log.Fields{"scale": scale, "clamped": clamped}).
+			Warn("Grid scale out of the accepted range, clamped")
 	}
+	return clamped
 }
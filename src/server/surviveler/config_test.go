@@ -0,0 +1,163 @@
+package surviveler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	if err := NewConfig().Validate(); err != nil {
+		t.Fatalf("default configuration should be valid, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string // expected error substring
+	}{
+		{"empty port", func(c *Config) { c.Port = "" }, "port must be"},
+		{"non numeric port", func(c *Config) { c.Port = "http" }, "port must be"},
+		{"out of range port", func(c *Config) { c.Port = "70000" }, "port must be"},
+		{"invalid telnet port", func(c *Config) { c.TelnetPort = "-1" }, "telnet port must be"},
+		{"invalid metrics port", func(c *Config) { c.MetricsPort = "0" }, "metrics port must be"},
+		{"zero logic tick", func(c *Config) { c.LogicTickPeriod = 0 }, "logic tick period must be"},
+		{"huge logic tick", func(c *Config) { c.LogicTickPeriod = MaxTickPeriod + 1 }, "logic tick period must be"},
+		{"zero send tick", func(c *Config) { c.SendTickPeriod = 0 }, "send tick period must be"},
+		{"send faster than logic", func(c *Config) { c.SendTickPeriod, c.LogicTickPeriod = 10, 20 }, "shorter than the logic tick period"},
+		{"zero time factor", func(c *Config) { c.TimeFactor = 0 }, "time factor must be positive"},
+		{"negative time factor", func(c *Config) { c.TimeFactor = -2 }, "time factor must be positive"},
+		{"night start", func(c *Config) { c.NightStartingTime = 1440 }, "night starting time must be"},
+		{"night end", func(c *Config) { c.NightEndingTime = -1 }, "night ending time must be"},
+		{"game start", func(c *Config) { c.GameStartingTime = 2000 }, "game starting time must be"},
+		{"no assets", func(c *Config) { c.AssetsPath = "" }, "assets path must be specified"},
+		{"player waypoints", func(c *Config) { c.PlayerWaypoints = -2 }, "player waypoints must be"},
+		{"zombie waypoints", func(c *Config) { c.ZombieWaypoints = -5 }, "zombie waypoints must be"},
+		{"reconnect grace", func(c *Config) { c.ReconnectGrace = -1 }, "reconnect grace period can't be negative"},
+		{"no player faction", func(c *Config) { c.PlayerFactions = 0 }, "player factions must be between 1 and"},
+		{"player factions", func(c *Config) { c.PlayerFactions = MaxPlayerFactions + 1 }, "player factions must be between 1 and"},
+		{"grid scale", func(c *Config) { c.GridScale = -2 }, "grid scale can't be negative"},
+		{"map border", func(c *Config) { c.MapBorder = -1 }, "map border can't be negative"},
+		{"path budget", func(c *Config) { c.PathBudget = -1 }, "path budget can't be negative"},
+		{"player regen delay", func(c *Config) { c.PlayerRegenDelay = -1 }, "player regen delay can't be negative"},
+		{"player regen rate", func(c *Config) { c.PlayerRegenRate = -1 }, "player regen rate can't be negative"},
+		{"zombie leash", func(c *Config) { c.ZombieLeash = -1 }, "zombie leash can't be negative"},
+		{"zombie dying time", func(c *Config) { c.ZombieDyingTime = -1 }, "zombie dying time can't be negative"},
+		{"repath interval", func(c *Config) { c.RepathInterval = -1 }, "repath interval can't be negative"},
+		{"repath distance", func(c *Config) { c.RepathDistance = -1 }, "repath distance can't be negative"},
+		{"attack cooldown", func(c *Config) { c.AttackCooldown = -1 }, "attack cooldown can't be negative"},
+		{"damage variance", func(c *Config) { c.DamageVariance = 1.5 }, "damage variance must be in [0, 1]"},
+		{"modifier stacking", func(c *Config) { c.ModifierStacking = "max" }, "modifier stacking must be"},
+		{"melee arc", func(c *Config) { c.MeleeArc = 400 }, "melee arc must be in [0, 360]"},
+		{"melee range", func(c *Config) { c.MeleeRange = 0 }, "melee range must be positive"},
+		{"arrival tolerance", func(c *Config) { c.ArrivalTolerance = 0 }, "arrival tolerance must be positive"},
+		{"slowdown radius", func(c *Config) { c.SlowdownRadius = -1 }, "slowdown radius can't be negative"},
+		{"view radius", func(c *Config) { c.ViewRadius = -1 }, "view radius can't be negative"},
+		{"slow client drops", func(c *Config) { c.SlowClientDrops = -1 }, "slow client drops can't be negative"},
+		{"zombie targets", func(c *Config) { c.ZombieTargets = "zombies" }, "zombie targets must be"},
+		{"spawn jitter", func(c *Config) { c.SpawnJitter = -1 }, "spawn jitter can't be negative"},
+		{"spawn pattern", func(c *Config) { c.SpawnPattern = "line" }, "spawn pattern must be"},
+		{"wave scaling", func(c *Config) { c.WaveScaling = "quadratic" }, "wave scaling must be"},
+		{"wave HP growth", func(c *Config) { c.WaveHPGrowth = -0.1 }, "wave HP growth can't be negative"},
+		{"wave damage growth", func(c *Config) { c.WaveDamageGrowth = -0.1 }, "wave damage growth can't be negative"},
+		{"log modules", func(c *Config) { c.Logging.Modules = "pathfinder=loud" }, "invalid level for module 'pathfinder'"},
+		{"log format", func(c *Config) { c.Logging.Format = "xml" }, "invalid log format 'xml'"},
+		{"log max size", func(c *Config) { c.Logging.MaxSize = -1 }, "log file max size"},
+		{"record and replay", func(c *Config) { c.RecordPath, c.ReplayPath = "a", "b" }, "recorded and replayed"},
+		{"pause events", func(c *Config) { c.PauseEvents = "keep" }, "pause events must be"},
+		{"max entities", func(c *Config) { c.MaxEntities = -1 }, "max number of entities can't be negative"},
+		{"spawns at cap", func(c *Config) { c.SpawnsAtCap = "drop" }, "spawns at cap must be"},
+		{"no rooms", func(c *Config) { c.Rooms = 0 }, "number of rooms must be positive"},
+		{"record rooms", func(c *Config) { c.Rooms, c.RecordPath = 2, "a" }, "with a single room"},
+	}
+	for _, tt := range tests {
+		cfg := NewConfig()
+		tt.modify(&cfg)
+		err := cfg.Validate()
+		if err == nil {
+			t.Errorf("%s: Validate() = nil, want an error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Validate() = %q, want it to contain %q", tt.name, err, tt.want)
+		}
+	}
+
+	// optional ports can be left empty
+	cfg := NewConfig()
+	cfg.TelnetPort, cfg.MetricsPort = "", ""
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("empty optional ports should be valid, got %v", err)
+	}
+}
+
+func TestConfig_ValidateReportsAllErrors(t *testing.T) {
+	cfg := NewConfig()
+	cfg.LogicTickPeriod = 0
+	cfg.AssetsPath = ""
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("Validate() = nil, want an error")
+	}
+	for _, want := range []string{"logic tick period", "assets path"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestConfig_CheckTickPeriods(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	out, lvl := logger.Out, logger.Level
+	logger.Out, logger.Level = &buf, log.WarnLevel
+	defer func() { logger.Out, logger.Level = out, lvl }()
+
+	tests := []struct {
+		name        string
+		send, logic int
+		align       bool
+		warn        bool
+		ratio       int
+	}{
+		{"defaults", 100, 10, false, false, 10},
+		{"same periods", 20, 20, false, false, 1},
+		{"mismatched", 100, 30, false, true, 3},
+		{"mismatched rounded up", 50, 20, false, true, 3},
+		{"mismatched aligned", 100, 30, true, true, 3},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		cfg := NewConfig()
+		cfg.SendTickPeriod, cfg.LogicTickPeriod, cfg.AlignSendTicks = tt.send, tt.logic, tt.align
+		if ok := cfg.checkTickPeriods(); ok == tt.warn {
+			t.Errorf("%s: checkTickPeriods() = %v, want %v", tt.name, ok, !tt.warn)
+		}
+		if warned := strings.Contains(buf.String(), "isn't a multiple"); warned != tt.warn {
+			t.Errorf("%s: warned = %v, want %v, log:\n%s", tt.name, warned, tt.warn, buf.String())
+		}
+		if ratio := cfg.sendTickRatio(); ratio != tt.ratio {
+			t.Errorf("%s: sendTickRatio() = %d, want %d", tt.name, ratio, tt.ratio)
+		}
+	}
+}
+
+func TestNewGame_InvalidConfig(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.StandardLogger()
+	out, lvl := logger.Out, logger.Level
+	logger.Out = &buf
+	defer func() { logger.Out, logger.Level = out, lvl }()
+
+	cfg := NewConfig()
+	cfg.AssetsPath = testAssets
+	cfg.SendTickPeriod = 0
+	if g := NewGame(cfg); g != nil {
+		t.Fatalf("NewGame() with an invalid configuration should fail")
+	}
+	if !strings.Contains(buf.String(), "send tick period must be") {
+		t.Errorf("NewGame() log doesn't explain the error:\n%s", buf.String())
+	}
+}
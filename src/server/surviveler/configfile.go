@@ -0,0 +1,189 @@
+/*
+ * Surviveler package
+ * configuration files
+ */
+package surviveler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-ini/ini"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+ * LoadConfigFile reads the settings of a configuration file into cfg, the
+ * settings absent from the file are left untouched.
+ *
+ * The file format is chosen after the file extension:
+ * - .yaml or .yml: YAML mapping, keys in snake case (ex: send_tick_period),
+ *   logging settings in a nested 'logging' mapping
+ * - .toml: TOML document with the same keys, logging settings in a
+ *   '[logging]' table
+ * - any other extension: ini file, keys in caps (ex: SEND_TICK_PERIOD)
+ *
+ * Unlike ini files, YAML and TOML files are strictly checked: unknown keys
+ * and values of the wrong type are reported as errors.
+ */
+func LoadConfigFile(cfg *Config, path string) error {
+	var decode func([]byte) (map[string]interface{}, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decode = decodeYAMLConfig
+	case ".toml":
+		decode = decodeTOMLConfig
+	default:
+		return ini.MapToWithMapper(cfg, ini.AllCapsUnderscore, path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values, err := decode(data)
+	if err != nil {
+		return err
+	}
+	return setConfigFields(reflect.ValueOf(cfg).Elem(), values, "")
+}
+
+/*
+ * decodeYAMLConfig decodes a YAML configuration document
+ */
+func decodeYAMLConfig(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+/*
+ * decodeTOMLConfig decodes a TOML configuration document
+ */
+func decodeTOMLConfig(data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if _, err := toml.Decode(string(data), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+/*
+ * setConfigFields sets the fields of the struct v to the decoded values, the
+ * keys being the fields names in snake case. table is the name of the table
+ * holding values, to report errors.
+ */
+func setConfigFields(v reflect.Value, values map[string]interface{}, table string) error {
+	fields := make(map[string]int)
+	for i := 0; i < v.NumField(); i++ {
+		fields[ini.TitleUnderscore(v.Type().Field(i).Name)] = i
+	}
+
+	// sorted keys, so that errors are reported consistently
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := key
+		if len(table) > 0 {
+			name = table + "." + key
+		}
+		i, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("unknown setting '%s'", name)
+		}
+		if err := setConfigField(v.Field(i), values[key], name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * setConfigField sets a configuration field to a decoded value
+ */
+func setConfigField(f reflect.Value, val interface{}, name string) error {
+	invalid := func(expected string) error {
+		return fmt.Errorf("setting '%s' must be %s, got '%v'", name, expected, val)
+	}
+
+	// decoders return integers as int or int64, and floats as float64
+	var (
+		i       int64
+		isInt   bool
+		num     float64
+		isFloat bool
+	)
+	switch n := val.(type) {
+	case int:
+		i, isInt = int64(n), true
+	case int64:
+		i, isInt = n, true
+	case float64:
+		num, isFloat = n, true
+	}
+
+	switch f.Kind() {
+	case reflect.Struct:
+		var values map[string]interface{}
+		switch m := val.(type) {
+		case map[string]interface{}:
+			values = m
+		case map[interface{}]interface{}:
+			values = make(map[string]interface{}, len(m))
+			for k, v := range m {
+				values[fmt.Sprint(k)] = v
+			}
+		default:
+			return invalid("a table")
+		}
+		return setConfigFields(f, values, name)
+	case reflect.String:
+		// ports may be written as numbers
+		s, ok := val.(string)
+		switch {
+		case ok:
+			f.SetString(s)
+		case isInt:
+			f.SetString(strconv.FormatInt(i, 10))
+		default:
+			return invalid("a string")
+		}
+	case reflect.Int, reflect.Int64:
+		if isFloat && num == math.Trunc(num) && math.Abs(num) < math.MaxInt64 {
+			i, isInt = int64(num), true
+		}
+		if !isInt || f.OverflowInt(i) {
+			return invalid("an integer")
+		}
+		f.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		if isInt {
+			num = float64(i)
+		} else if !isFloat {
+			return invalid("a number")
+		}
+		f.SetFloat(num)
+	case reflect.Bool:
+		b, ok := val.(bool)
+		if !ok {
+			return invalid("a boolean")
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("setting '%s' has an unsupported type", name)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package surviveler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testYAMLConfig = `
+# sample configuration
+port: 4321
+send_tick_period: 50
+friendly_fire: true
+grid_scale: 2
+damage_variance: 0.25
+seed: 9007199254740993
+zombie_targets: nearest
+logging:
+  format: json
+  max_size: 20
+`
+
+const testTOMLConfig = `
+# sample configuration
+port = "4321"
+send_tick_period = 50
+friendly_fire = true
+grid_scale = 2.0
+damage_variance = 0.25 # a comment
+seed = 9_007_199_254_740_993
+zombie_targets = """
+nearest"""
+logging = { format = "json", max_size = 20 }
+`
+
+/*
+ * writeTestConfig writes a configuration file named name, in a temporary
+ * directory that should be removed once done
+ */
+func writeTestConfig(t *testing.T, name, content string) (dir, path string) {
+	dir, err := ioutil.TempDir("", "surviveler-config")
+	if err != nil {
+		t.Fatalf("couldn't create temp dir: %v", err)
+	}
+	path = filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("couldn't write config file: %v", err)
+	}
+	return dir, path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name, content string
+	}{
+		{"server.yaml", testYAMLConfig},
+		{"server.yml", testYAMLConfig},
+		{"server.toml", testTOMLConfig},
+	}
+	for _, tt := range tests {
+		dir, path := writeTestConfig(t, tt.name, tt.content)
+		defer os.RemoveAll(dir)
+
+		cfg := NewConfig()
+		if err := LoadConfigFile(&cfg, path); err != nil {
+			t.Errorf("%s: LoadConfigFile() = %v, want nil", tt.name, err)
+			continue
+		}
+		want := NewConfig()
+		want.Port = "4321"
+		want.SendTickPeriod = 50
+		want.FriendlyFire = true
+		want.GridScale = 2
+		want.DamageVariance = 0.25
+		want.Seed = 9007199254740993
+		want.ZombieTargets = ZombieTargetsNearest
+		want.Logging.Format = "json"
+		want.Logging.MaxSize = 20
+		if cfg != want {
+			t.Errorf("%s: LoadConfigFile() loaded\n%+v\nwant\n%+v", tt.name, cfg, want)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("%s: loaded configuration should be valid, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestLoadConfigFile_Ini(t *testing.T) {
+	dir, path := writeTestConfig(t, "server.ini", "PORT = 4321\nSEND_TICK_PERIOD = 50\n[LOGGING]\nFORMAT = json\n")
+	defer os.RemoveAll(dir)
+
+	cfg := NewConfig()
+	if err := LoadConfigFile(&cfg, path); err != nil {
+		t.Fatalf("LoadConfigFile() = %v, want nil", err)
+	}
+	if cfg.Port != "4321" || cfg.SendTickPeriod != 50 || cfg.Logging.Format != "json" {
+		t.Errorf("LoadConfigFile() loaded %+v", cfg)
+	}
+}
+
+func TestLoadConfigFile_Errors(t *testing.T) {
+	tests := []struct {
+		name, content string
+		want          string // expected error substring
+	}{
+		{"unknown.yaml", "ports: 1234", "unknown setting 'ports'"},
+		{"unknown.toml", "[logging]\nlevel = 'info'", "unknown setting 'logging.level'"},
+		{"string.yaml", "assets_path: [a, b]", "setting 'assets_path' must be a string"},
+		{"integer.yaml", "send_tick_period: 2.5", "setting 'send_tick_period' must be an integer"},
+		{"overflow.yaml", "logging: {max_size: 1e30}", "setting 'logging.max_size' must be an integer"},
+		{"number.toml", "grid_scale = 'big'", "setting 'grid_scale' must be a number"},
+		{"boolean.toml", "friendly_fire = 1", "setting 'friendly_fire' must be a boolean"},
+		{"table.yaml", "logging: json", "setting 'logging' must be a table"},
+		{"syntax.yaml", "port: [1234", "yaml"},
+		{"value.toml", "port = 12 34", "toml: line 1"},
+		{"pair.toml", "\nport", "toml: line 2"},
+		{"twice.toml", "port = 1\nport = 2", "'port' has already been defined"},
+		{"header.toml", "[logging", "toml: line 1"},
+		{"nested.toml", "[logging.file]", "setting 'logging.file' must be a string"},
+		{"array.toml", "port = [4321, 4322]", "setting 'port' must be a string"},
+	}
+	for _, tt := range tests {
+		dir, path := writeTestConfig(t, tt.name, tt.content)
+		defer os.RemoveAll(dir)
+
+		cfg := NewConfig()
+		err := LoadConfigFile(&cfg, path)
+		if err == nil {
+			t.Errorf("%s: LoadConfigFile() = nil, want an error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: LoadConfigFile() = %q, want it to contain %q", tt.name, err, tt.want)
+		}
+	}
+
+	cfg := NewConfig()
+	if err := LoadConfigFile(&cfg, filepath.Join("missing", "server.yaml")); err == nil {
+		t.Errorf("LoadConfigFile() of a missing file should fail")
+	}
+}
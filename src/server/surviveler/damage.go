@@ -0,0 +1,99 @@
+/*
+ * Surviveler package
+ * damage types, resistances and vulnerabilities
+ */
+package surviveler
+
+import "fmt"
+
+/*
+ * DamageType is the nature of the damage dealt to an entity
+ */
+type DamageType uint8
+
+const (
+	MeleeDamage     DamageType = iota // hits of the players and the zombies
+	RangedDamage                      // projectiles
+	ExplosiveDamage                   // grenade blasts
+	FireDamage                        // hazard zones
+	numDamageTypes
+)
+
+/*
+ * damageTypeNames are the names of the damage types, in the entity data
+ */
+var damageTypeNames = [numDamageTypes]string{"melee", "ranged", "explosive", "fire"}
+
+func (dt DamageType) String() string {
+	if dt < numDamageTypes {
+		return damageTypeNames[dt]
+	}
+	return fmt.Sprintf("DamageType(%d)", uint8(dt))
+}
+
+/*
+ * parseDamageType returns the damage type having the given name, or false if
+ * there's none
+ */
+func parseDamageType(name string) (DamageType, bool) {
+	for dt, n := range damageTypeNames {
+		if n == name {
+			return DamageType(dt), true
+		}
+	}
+	return 0, false
+}
+
+/*
+ * Resistances is the component holding the resistance of an entity to each
+ * damage type.
+ *
+ * A resistance is the fraction of the damage the entity is spared: 0.25 for
+ * a quarter less damage, 1 for an immunity, a negative resistance being a
+ * vulnerability, -0.5 for half more damage. A nil Resistances resists
+ * nothing.
+ */
+type Resistances [numDamageTypes]float32
+
+/*
+ * Apply returns the damage taken out of damage of type dt
+ */
+func (r *Resistances) Apply(dt DamageType, damage float32) float32 {
+	if r == nil || dt >= numDamageTypes {
+		return damage
+	}
+	return damage * (1 - r[dt])
+}
+
+/*
+ * validateResistances checks the resistances of some entity data, by damage
+ * type name
+ */
+func validateResistances(res map[string]float32) error {
+	for name, r := range res {
+		if _, ok := parseDamageType(name); !ok {
+			return fmt.Errorf("unknown damage type '%s'", name)
+		}
+		if r > 1 {
+			return fmt.Errorf("'%s' resistance can't be above 1, got %v", name, r)
+		}
+	}
+	return nil
+}
+
+/*
+ * setResistances sets the resistances of an entity having a Resistances
+ * component from its entity data
+ */
+func setResistances(e Entity, data *EntityData) {
+	var r *Resistances
+	if !GetComponent(e, &r) {
+		return
+	}
+	*r = Resistances{}
+	for name, v := range data.Resistances {
+		if dt, ok := parseDamageType(name); ok {
+			r[dt] = v
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package surviveler
+
+import (
+	"testing"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+func TestDealDamage_Resistances(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	data := *g.state.EntityData(ZombieEntity)
+	data.Resistances = map[string]float32{
+		"ranged":    0.25,
+		"explosive": 1,
+		"fire":      -0.5,
+	}
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	applyEntityData(z, &data)
+
+	tests := []struct {
+		typ  DamageType
+		want float32
+	}{
+		{MeleeDamage, 20},
+		{RangedDamage, 15},
+		{ExplosiveDamage, 0},
+		{FireDamage, 30},
+	}
+	for _, tt := range tests {
+		z.health.Cur = z.health.Total
+		z.DealDamage(20, tt.typ)
+		if got := z.health.Total - z.health.Cur; math32.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("%v: zombie lost %v HP, want %v", tt.typ, got, tt.want)
+		}
+	}
+
+	// the resistances apply before the defense modifiers
+	z.health.Cur = z.health.Total
+	z.modifiers.Add(Modifier{Source: "armor", Stat: DefenseStat, Factor: 2})
+	z.DealDamage(20, FireDamage)
+	if got := z.health.Total - z.health.Cur; math32.Abs(got-15) > 1e-4 {
+		t.Errorf("armored zombie lost %v HP to fire, want 15", got)
+	}
+
+	// a player without resistances takes the full damage of every type
+	p := addTestPlayer(g, TankEntity, d2.Vec2{4.5, 2.5})
+	for dt := MeleeDamage; dt < numDamageTypes; dt++ {
+		p.health.Cur = p.health.Total
+		p.DealDamage(20, dt)
+		if got := p.health.Total - p.health.Cur; got != 20 {
+			t.Errorf("%v: player lost %v HP, want 20", dt, got)
+		}
+	}
+	if got := (*Resistances)(nil).Apply(FireDamage, 20); got != 20 {
+		t.Errorf("nil resistances damage = %v, want 20", got)
+	}
+}
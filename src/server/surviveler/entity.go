@@ -6,7 +6,7 @@ package surviveler
 
 import (
 	gomath "math"
-	"server/actions"
+	"server/messages"
 	"time"
 
 	"github.com/aurelien-rainone/gogeo/f32/d2"
@@ -35,6 +35,7 @@ const (
 const (
 	BarricadeBuilding EntityType = iota
 	MgTurretBuilding
+	WallBuilding
 )
 
 /*
@@ -44,10 +45,50 @@ const (
 	CoffeeMachineObject EntityType = iota
 )
 
+/*
+ * Projectile type identifiers.
+ */
+const (
+	BulletProjectile EntityType = iota
+	GrenadeProjectile
+)
+
+/*
+ * Item type identifiers.
+ */
+const (
+	AmmoItem EntityType = iota
+	MedkitItem
+	ResourceItem
+)
+
 const (
 	InvalidID uint32 = gomath.MaxUint32
 )
 
+/*
+ * Faction is the side an entity is fighting for
+ */
+type Faction uint8
+
+/*
+ * Faction identifiers.
+ *
+ * In co-op, every player is in PlayerFaction. In PvP, the players can be
+ * spread among several factions, numbered from PlayerFaction onwards.
+ */
+const (
+	NeutralFaction Faction = iota // buildings, objects and projectiles
+	ZombieFaction
+	PlayerFaction
+)
+
+/*
+ * MaxPlayerFactions is the max number of player factions, numbered up to the
+ * last Faction
+ */
+const MaxPlayerFactions = gomath.MaxUint8 - int(PlayerFaction) + 1
+
 /*
  * Entity is the interface that represents stateful game objects
  */
@@ -55,12 +96,13 @@ type Entity interface {
 	Id() uint32 // should return InvalidId if Id has not been assigned yet
 	SetId(uint32)
 	Type() EntityType
+	Faction() Faction
 	State() EntityState
 	Position() d2.Vec2
 	Update(dt time.Duration)
-	DealDamage(float32) bool
+	DealDamage(float32, DamageType) bool
 	HealDamage(float32) bool
-	d2.Rectangler
+	d2.Rectangler // Rectangle returns the bounding box, the entity footprint
 }
 
 /*
@@ -91,6 +133,23 @@ type Building interface {
 	AddBuildPower(bp uint16)
 }
 
+/*
+ * damageable is implemented by the entities having hit points
+ */
+type damageable interface {
+	hitPoints() float32 // current hit points
+}
+
+/*
+ * Targeter is implemented by the entities pursuing or shooting at a target,
+ * like the zombies and the turrets
+ */
+type Targeter interface {
+	// Target returns the id of the current target of the entity, and false if
+	// it has none
+	Target() (uint32, bool)
+}
+
 /*
  * Object is the interface implemented by building objects.
  *
@@ -104,7 +163,21 @@ type Object interface {
 }
 
 /*
- * EntityState represents a snapshot of an entity state
+ * applyEntityData configures the components of a new entity that its
+ * constructor doesn't set, from its entity data
+ */
+func applyEntityData(e Entity, data *EntityData) {
+	setHitEffects(e, data)
+	setCollisionRadius(e, data)
+	setZombieBehavior(e, data)
+	setResistances(e, data)
+}
+
+/*
+ * EntityState represents a snapshot of an entity state.
+ *
+ * Entity states are converted to their wire schema, defined in the messages
+ * package, when the game state is packed.
  */
 type EntityState interface{}
 
@@ -116,8 +189,60 @@ type MobileEntityState struct {
 	Xpos         float32
 	Ypos         float32
 	CurHitPoints uint16
-	ActionType   actions.Type
-	Action       interface{}
+	Heading      float32 // direction faced, angle in radians from the x axis
+	Xvel         float32 // velocity, null unless moving or if not sent
+	Yvel         float32
+	Staggered    bool
+	Action       messages.Action
+}
+
+func (s MobileEntityState) pack() messages.MobileEntityState {
+	return messages.MobileEntityState{
+		Type:         uint8(s.Type),
+		Xpos:         s.Xpos,
+		Ypos:         s.Ypos,
+		CurHitPoints: s.CurHitPoints,
+		Heading:      s.Heading,
+		Xvel:         s.Xvel,
+		Yvel:         s.Yvel,
+		Staggered:    s.Staggered,
+		Action:       s.Action,
+	}
+}
+
+/*
+ * PlayerState represents a snapshot of a player
+ */
+type PlayerState struct {
+	Type         EntityType
+	Xpos         float32
+	Ypos         float32
+	CurHitPoints uint16
+	Heading      float32
+	Xvel         float32
+	Yvel         float32
+	Staggered    bool
+	Regenerating bool // hit points are regenerating, out of combat
+	Protected    bool // newly joined, can't be targeted nor hurt
+	Action       messages.Action
+	Resources    uint16
+}
+
+func (s PlayerState) pack() messages.MobileEntityState {
+	return messages.MobileEntityState{
+		Type:         uint8(s.Type),
+		Xpos:         s.Xpos,
+		Ypos:         s.Ypos,
+		CurHitPoints: s.CurHitPoints,
+		Heading:      s.Heading,
+		Xvel:         s.Xvel,
+		Yvel:         s.Yvel,
+		Staggered:    s.Staggered,
+		Regenerating: s.Regenerating,
+		Protected:    s.Protected,
+		Action:       s.Action,
+		Resources:    s.Resources,
+	}
 }
 
 /*
@@ -131,6 +256,56 @@ type BuildingState struct {
 	Completed    bool
 }
 
+func (s BuildingState) pack() messages.BuildingState {
+	return messages.BuildingState{
+		Type:         uint8(s.Type),
+		Xpos:         s.Xpos,
+		Ypos:         s.Ypos,
+		CurHitPoints: s.CurHitPoints,
+		Completed:    s.Completed,
+	}
+}
+
+/*
+ * ProjectileState represents a snapshot of a projectile
+ */
+type ProjectileState struct {
+	Type EntityType
+	Xpos float32
+	Ypos float32
+	Xdir float32
+	Ydir float32
+}
+
+func (s ProjectileState) pack() messages.ProjectileState {
+	return messages.ProjectileState{
+		Type: uint8(s.Type),
+		Xpos: s.Xpos,
+		Ypos: s.Ypos,
+		Xdir: s.Xdir,
+		Ydir: s.Ydir,
+	}
+}
+
+/*
+ * ItemState represents a snapshot of an item
+ */
+type ItemState struct {
+	Type     EntityType
+	Xpos     float32
+	Ypos     float32
+	Quantity uint16
+}
+
+func (s ItemState) pack() messages.ItemState {
+	return messages.ItemState{
+		Type:     uint8(s.Type),
+		Xpos:     s.Xpos,
+		Ypos:     s.Ypos,
+		Quantity: s.Quantity,
+	}
+}
+
 /*
  * ObjectState represents a snapshot of an usable object
  */
@@ -140,3 +315,12 @@ type ObjectState struct {
 	Ypos       float32
 	OperatedBy uint32
 }
+
+func (s ObjectState) pack() messages.ObjectState {
+	return messages.ObjectState{
+		Type:       uint8(s.Type),
+		Xpos:       s.Xpos,
+		Ypos:       s.Ypos,
+		OperatedBy: s.OperatedBy,
+	}
+}
@@ -5,33 +5,88 @@
 package surviveler
 
 import (
-	"math/rand"
 	"server/events"
+	"server/logging"
+	"server/messages"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/aurelien-rainone/gogeo/f32/d2"
 )
 
 /*
- * runPathFinder runs the macro-pathfinder from org to dst.
+ * tickLog returns a log entry carrying the current logic tick
+ */
+func (gs *GameState) tickLog() *log.Entry {
+	return logging.WithTick(log.NewEntry(log.StandardLogger()), gs.game.tick)
+}
+
+/*
+ * entityLog returns a log entry concerning an entity, at the current logic
+ * tick
+ */
+func (gs *GameState) entityLog(id uint32) *log.Entry {
+	return logging.WithEntity(gs.tickLog(), id)
+}
+
+/*
+ * clientLog returns a log entry concerning a client, and the player entity
+ * that has the client id, at the current logic tick
+ */
+func (gs *GameState) clientLog(id uint32) *log.Entry {
+	return logging.WithClient(gs.entityLog(id), id)
+}
+
+/*
+ * runPathFinder runs the macro-pathfinder from the player position to dst.
  *
- * The callback function fn is called if a path is found.
+ * The search is performed off the game loop, the callback function fn is
+ * called on a later tick, if a path is found. Otherwise fail, if not nil, is
+ * called with the reason of the failure. A new search supersedes the one that
+ * is still pending for the player, so that only the latest order given by a
+ * player gets applied.
  */
-func (gs *GameState) runPathFinder(org, dst d2.Vec2, fn func(path Path)) {
-	ctxLog := log.WithFields(log.Fields{"org": org, "dst": dst})
+func (gs *GameState) runPathFinder(player *Player, dst d2.Vec2, fn func(path Path), fail func(res PathResult)) {
+	org := player.Position()
+	ctxLog := gs.clientLog(player.Id()).WithFields(log.Fields{"org": org, "dst": dst})
+
+	player.cancelPathRequest()
 	// run the macro-pathfinder
-	path, _, found := gs.game.Pathfinder().FindPath(org, dst)
-	if !found {
-		ctxLog.Warn("Pathfinder failed to find path")
-		return
-	}
+	var req *PathRequest
+	req = gs.game.Pathfinder().Request(org, dst, func(path Path, found bool) {
+		player.pathReq = nil
+		if gs.Entity(player.Id()) != player {
+			// the player has left in the meantime
+			return
+		}
+		if !found {
+			ctxLog.WithField("reason", req.Result()).Warn("Pathfinder failed to find path")
+			if fail != nil {
+				fail(req.Result())
+			}
+			return
+		}
 
-	// TODO: why > 1? it should be forbidden, pathfinder-side, to return an
-	// empty path!
-	if len(path) > 1 {
-		ctxLog.WithField("path", path).Debug("Pathfinder found a path")
-		fn(path)
-	}
+		// TODO: why > 1? it should be forbidden, pathfinder-side, to return an
+		// empty path!
+		if len(path) > 1 {
+			ctxLog.WithField("path", path).Debug("Pathfinder found a path")
+			fn(path)
+		}
+	})
+	player.pathReq = req
+}
+
+/*
+ * rejectMove notifies a player that it can't go to dst
+ */
+func (gs *GameState) rejectMove(id uint32, dst d2.Vec2, res PathResult) {
+	msg := messages.New(messages.MoveRejectedId, messages.MoveRejected{
+		Xpos:   dst[0],
+		Ypos:   dst[1],
+		Reason: uint8(res),
+	})
+	gs.game.clients.Multicast([]uint32{id}, msg)
 }
 
 /*
@@ -40,11 +95,14 @@ func (gs *GameState) runPathFinder(org, dst d2.Vec2, fn func(path Path)) {
 func (gs *GameState) onPlayerJoin(event *events.Event) {
 	evt := event.Payload.(events.PlayerJoin)
 	// we have a new player, his id will be its unique connection id
-	log.WithField("clientId", evt.Id).Info("Received a PlayerJoin event")
+	gs.clientLog(evt.Id).Info("Received a PlayerJoin event")
 
-	// pick a random spawn point
-	org := gs.gameData.mapData.AIKeypoints.Spawn.
-		Players[rand.Intn(len(gs.gameData.mapData.AIKeypoints.Spawn.Players))]
+	// pick the next available spawn point
+	org, ok := gs.playerSpawnPoint()
+	if !ok {
+		gs.clientLog(evt.Id).Error("No player spawn point to spawn the player at")
+		return
+	}
 
 	// load entity data
 	entityData := gs.EntityData(EntityType(evt.Type))
@@ -56,6 +114,10 @@ func (gs *GameState) onPlayerJoin(event *events.Event) {
 	p := NewPlayer(gs.game, org, EntityType(evt.Type),
 		float32(entityData.Speed), float32(entityData.TotalHP),
 		uint16(entityData.BuildingPower), uint16(entityData.CombatPower))
+	applyEntityData(p, entityData)
+	p.SetFaction(gs.playerFaction())
+	// shield the player while it finds its bearings
+	p.protection.Start(time.Duration(gs.game.cfg.SpawnProtection) * time.Millisecond)
 	p.SetId(evt.Id)
 	gs.AddEntity(p)
 }
@@ -66,8 +128,10 @@ func (gs *GameState) onPlayerJoin(event *events.Event) {
 func (gs *GameState) onPlayerLeave(event *events.Event) {
 	evt := event.Payload.(events.PlayerLeave)
 	// one player less, remove him from the map
-	log.WithField("clientId", evt.Id).Info("We have one less player")
-	delete(gs.entities, evt.Id)
+	gs.clientLog(evt.Id).Info("We have one less player")
+	if player := gs.getPlayer(evt.Id); player != nil {
+		gs.RemoveEntity(evt.Id)
+	}
 }
 
 /*
@@ -77,23 +141,33 @@ func (gs *GameState) onPlayerMove(event *events.Event) {
 	evt := event.Payload.(events.PlayerMove)
 	dst := d2.Vec2{evt.Xpos, evt.Ypos}
 
-	ctxLog := log.WithFields(log.Fields{"evt": evt, "dst": dst})
+	ctxLog := gs.clientLog(evt.Id).WithFields(log.Fields{"evt": evt, "dst": dst})
 	ctxLog.Info("Received PlayerMove event")
 
+	player := gs.getPlayer(evt.Id)
+	if player == nil {
+		ctxLog.Error("Unknown player id")
+		return
+	}
+
 	if !gs.world.PointInBounds(dst) {
 		// do not forward a request with out-of-bounds destination
 		ctxLog.Error("Can't plan path to out-of-bounds destination")
+		gs.rejectMove(evt.Id, dst, PathOutOfBounds)
 		return
 	}
-
-	player := gs.getPlayer(evt.Id)
-	if player == nil {
-		ctxLog.Error("Unknown player id")
+	if evt.Queued && (player.actions.Len() > 1 || player.pathReq != nil) {
+		// the player is busy, it'll go there afterwards
+		player.QueueMove(dst)
 		return
 	}
+	// a move given right away replaces the queued ones
+	player.clearMoveQueue()
 
-	gs.runPathFinder(player.Position(), dst, func(p Path) {
+	gs.runPathFinder(player, dst, func(p Path) {
 		player.Move(p)
+	}, func(res PathResult) {
+		gs.rejectMove(evt.Id, dst, res)
 	})
 }
 
@@ -104,7 +178,7 @@ func (gs *GameState) onPlayerBuild(event *events.Event) {
 	evt := event.Payload.(events.PlayerBuild)
 	dst := d2.Vec2{evt.Xpos, evt.Ypos}
 
-	ctxLog := log.WithFields(log.Fields{"evt": evt, "dst": dst})
+	ctxLog := gs.clientLog(evt.Id).WithFields(log.Fields{"evt": evt, "dst": dst})
 	ctxLog.Info("Received PlayerBuild event")
 
 	player := gs.getPlayer(evt.Id)
@@ -119,35 +193,66 @@ func (gs *GameState) onPlayerBuild(event *events.Event) {
 			"illegal action: only engineers can build!")
 		return
 	}
-	// get the tile at building point coordinates
-	tile := gs.world.TileFromWorldVec(dst)
-	ctxLog.WithField("tile", tile)
-
-	// tile must be walkable
-	if !tile.IsWalkable() {
-		ctxLog.Error("Tile is not walkable: can't build")
+	data, ok := gs.gameData.buildingsData[EntityType(evt.Type)]
+	if !ok {
+		ctxLog.Error("Unknown building type")
+		return
+	}
+	// check if the player can afford it
+	if player.inventory.Count(ResourceItem) < data.Cost {
+		ctxLog.Warn("Not enough resources to build")
+		gs.rejectBuild(evt, errBuildTooExpensive)
 		return
 	}
-
 	// check if we can build here
-	tile.Entities.Each(func(ent Entity) bool {
-		if _, ok := ent.(Building); ok {
-			ctxLog.Error("There's already a building on this tile")
-			return false
-		}
-		return true
-	})
+	tile, err := gs.checkPlacement(dst, player)
+	if err != nil {
+		ctxLog.WithError(err).Warn("Can't build here")
+		gs.rejectBuild(evt, err)
+		return
+	}
 
 	// clip building center with tile center
 	pos := d2.Vec2{float32(tile.X), float32(tile.Y)}.
 		Scale(1 / gs.world.GridScale).
 		Add(txCenter)
 
-	gs.runPathFinder(player.Position(), pos, func(p Path) {
+	gs.runPathFinder(player, pos, func(p Path) {
+		// things may have changed while the path was searched
+		if _, err := gs.checkPlacement(pos, player); err != nil {
+			ctxLog.WithError(err).Warn("Can't build here anymore")
+			gs.rejectBuild(evt, err)
+			return
+		}
+		// pay for the building, the resources may have been spent meanwhile
+		if !player.inventory.Take(ResourceItem, data.Cost) {
+			ctxLog.Warn("Not enough resources to build anymore")
+			gs.rejectBuild(evt, errBuildTooExpensive)
+			return
+		}
 		// create the building, attach it to the tile
 		building := gs.createBuilding(EntityType(evt.Type), pos)
-		player.Build(building, p)
+		if building == nil {
+			ctxLog.Error("Couldn't create the building")
+			player.inventory.Add(ResourceItem, data.Cost)
+			gs.rejectBuild(evt, errBuildUnsupported)
+			return
+		}
+		player.Build(building, data.Cost, p)
+	}, nil)
+}
+
+/*
+ * rejectBuild notifies the player that its building can't be placed
+ */
+func (gs *GameState) rejectBuild(evt events.PlayerBuild, reason error) {
+	msg := messages.New(messages.BuildRejectedId, messages.BuildRejected{
+		Type:   evt.Type,
+		Xpos:   evt.Xpos,
+		Ypos:   evt.Ypos,
+		Reason: reason.Error(),
 	})
+	gs.game.clients.Multicast([]uint32{evt.Id}, msg)
 }
 
 /*
@@ -156,7 +261,7 @@ func (gs *GameState) onPlayerBuild(event *events.Event) {
 func (gs *GameState) onPlayerRepair(event *events.Event) {
 	evt := event.Payload.(events.PlayerRepair)
 
-	ctxLog := log.WithField("evt", evt)
+	ctxLog := gs.clientLog(evt.Id).WithField("evt", evt)
 	ctxLog.Info("Received PlayerRepair event")
 
 	player := gs.getPlayer(evt.Id)
@@ -171,10 +276,10 @@ func (gs *GameState) onPlayerRepair(event *events.Event) {
 		return
 	}
 
-	gs.runPathFinder(player.Position(), building.Position(), func(p Path) {
+	gs.runPathFinder(player, building.Position(), func(p Path) {
 		// set player action
 		player.Repair(building, p)
-	})
+	}, nil)
 }
 
 /*
@@ -182,24 +287,63 @@ func (gs *GameState) onPlayerRepair(event *events.Event) {
  */
 func (gs *GameState) onPlayerAttack(event *events.Event) {
 	evt := event.Payload.(events.PlayerAttack)
-	log.WithField("evt", evt).Info("Received PlayerAttack event")
+	gs.clientLog(evt.Id).WithField("evt", evt).Info("Received PlayerAttack event")
 
 	if player := gs.getPlayer(evt.Id); player != nil {
 
-		if enemy := gs.getZombie(evt.EntityId); enemy != nil {
-			// set player action
-			player.Attack(enemy)
+		enemy := gs.Entity(evt.EntityId)
+		if enemy != nil && evt.EntityId != evt.Id && gs.Hostile(player.Faction(), enemy.Faction()) {
+			// set player action, superseding any pending order
+			player.cancelPathRequest()
+			player.Attack(enemy, time.Duration(evt.Latency)*time.Millisecond)
 		}
 	}
 }
 
+/*
+ * event handler for PlayerShoot events
+ */
+func (gs *GameState) onPlayerShoot(event *events.Event) {
+	evt := event.Payload.(events.PlayerShoot)
+	gs.clientLog(evt.Id).WithField("evt", evt).Info("Received PlayerShoot event")
+
+	if player := gs.getPlayer(evt.Id); player != nil {
+		player.Shoot(d2.Vec2{evt.Xpos, evt.Ypos})
+	}
+}
+
+/*
+ * event handler for PlayerThrow events
+ */
+func (gs *GameState) onPlayerThrow(event *events.Event) {
+	evt := event.Payload.(events.PlayerThrow)
+	gs.clientLog(evt.Id).WithField("evt", evt).Info("Received PlayerThrow event")
+
+	if player := gs.getPlayer(evt.Id); player != nil {
+		player.Throw(d2.Vec2{evt.Xpos, evt.Ypos})
+	}
+}
+
+/*
+ * event handler for PlayerChat events, sent on the area channel
+ */
+func (gs *GameState) onPlayerChat(event *events.Event) {
+	evt := event.Payload.(events.PlayerChat)
+	gs.clientLog(evt.Id).WithField("evt", evt).Debug("Received PlayerChat event")
+
+	if player := gs.getPlayer(evt.Id); player != nil {
+		chat := messages.Chat{Id: evt.Id, Text: evt.Text, Channel: messages.ChatArea}
+		gs.game.clients.Multicast(gs.chatAudience(player), messages.New(messages.ChatId, chat))
+	}
+}
+
 /*
  * event handler for PlayerOperate events
  */
 func (gs *GameState) onPlayerOperate(event *events.Event) {
 	evt := event.Payload.(events.PlayerOperate)
 
-	ctxLog := log.WithField("evt", evt)
+	ctxLog := gs.clientLog(evt.Id).WithField("evt", evt)
 	ctxLog.Info("Received PlayerOperate event")
 
 	player := gs.getPlayer(evt.Id)
@@ -217,10 +361,14 @@ func (gs *GameState) onPlayerOperate(event *events.Event) {
 	var (
 		tile, draft *Tile
 		position    *d2.Vec2
+		ok          bool
 	)
 
 	// get the tile at object coordinates
-	tile = gs.world.TileFromWorldVec(object.Position())
+	if tile, ok = gs.world.TileAtWorldVec(object.Position()); !ok {
+		ctxLog.Error("Object out of the world bounds")
+		return
+	}
 
 	// This is awful, isn't it?
 	// FIXME: please, at some point...
@@ -241,8 +389,7 @@ func (gs *GameState) onPlayerOperate(event *events.Event) {
 				break
 			}
 			if x != tile.X && y != tile.Y {
-				draft = gs.world.Tile(x, y)
-				if draft.IsWalkable() {
+				if draft, ok = gs.world.TileAt(x, y); ok && draft.IsWalkable() {
 					position = &d2.Vec2{
 						float32(x) / gs.world.GridScale,
 						float32(y) / gs.world.GridScale,
@@ -253,9 +400,9 @@ func (gs *GameState) onPlayerOperate(event *events.Event) {
 	}
 
 	if position != nil {
-		gs.runPathFinder(player.Position(), *position, func(p Path) {
+		gs.runPathFinder(player, *position, func(p Path) {
 			player.Operate(object, p)
-		})
+		}, nil)
 	}
 }
 
@@ -264,7 +411,7 @@ func (gs *GameState) onPlayerOperate(event *events.Event) {
  */
 func (gs *GameState) onPlayerDeath(event *events.Event) {
 	evt := event.Payload.(events.PlayerDeath)
-	log.WithField("evt", evt).Info("Received PlayerDeath event")
+	gs.clientLog(evt.Id).WithField("evt", evt).Info("Received PlayerDeath event")
 
 	// TODO: we should keep track of what is happening and maybe mark the
 	// entity as dead and then remove it later.
@@ -279,11 +426,24 @@ func (gs *GameState) onPlayerDeath(event *events.Event) {
  */
 func (gs *GameState) onZombieDeath(event *events.Event) {
 	evt := event.Payload.(events.ZombieDeath)
-	log.WithField("evt", evt).Info("Received ZombieDeath event")
+	gs.entityLog(evt.Id).WithField("evt", evt).Info("Received ZombieDeath event")
 
-	// TODO: we should keep track of what is happening and maybe mark the
-	// entity as dead and then remove it later.
-	if zombie := gs.getZombie(evt.Id); zombie != nil {
+	if zombie := gs.getZombie(evt.Id); zombie != nil && zombie.curState != dyingState {
+		zombie.die()
+	}
+}
+
+/*
+ * event handler for ItemPickup events
+ */
+func (gs *GameState) onItemPickup(event *events.Event) {
+	evt := event.Payload.(events.ItemPickup)
+	logging.WithClient(gs.entityLog(evt.Id), evt.PlayerId).WithField("evt", evt).Info("Received ItemPickup event")
+
+	// the item may have been picked up by another player in the meantime
+	item, player := gs.getItem(evt.Id), gs.getPlayer(evt.PlayerId)
+	if item != nil && player != nil {
+		item.pickUp(player)
 		gs.RemoveEntity(evt.Id)
 	}
 }
@@ -293,7 +453,7 @@ func (gs *GameState) onZombieDeath(event *events.Event) {
  */
 func (gs *GameState) onBuildingDestroy(event *events.Event) {
 	evt := event.Payload.(events.BuildingDestroy)
-	log.WithField("evt", evt).Info("Received BuildingDestroy event")
+	gs.entityLog(evt.Id).WithField("evt", evt).Info("Received BuildingDestroy event")
 
 	// TODO: we should keep track of what is happening and maybe mark the
 	// entity as dead and then remove it later.
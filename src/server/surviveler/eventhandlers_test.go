@@ -0,0 +1,273 @@
+package surviveler
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"server/events"
+	"server/logging"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestGameState_onPlayerJoin_SpawnPoints(t *testing.T) {
+	g := newTestGameFromAssets(t, testAssets)
+	spawns := g.state.MapData().AIKeypoints.Spawn.Players
+
+	// each player should take a different spawn point, then we loop back to
+	// the first one when they are all occupied
+	for i := 0; i < len(spawns)+1; i++ {
+		id := g.state.allocEntityId()
+		g.PostEvent(events.NewEvent(events.PlayerJoinId,
+			events.PlayerJoin{Id: id, Type: uint8(TankEntity)}))
+		g.eventManager.Process()
+
+		p := g.state.getPlayer(id)
+		if p == nil {
+			t.Fatalf("player %v not found in game state", id)
+		}
+		want := spawns[i%len(spawns)]
+		if !p.Position().Approx(want) {
+			t.Errorf("player %d spawned at %v, want %v", i, p.Position(), want)
+		}
+	}
+}
+
+func TestGameState_onPlayerJoin_SpawnProtection(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.cfg.SpawnProtection = 1000
+	z := addTestZombie(g, d2.Vec2{2.5, 1.5})
+	id := g.state.allocEntityId()
+	g.PostEvent(events.NewEvent(events.PlayerJoinId,
+		events.PlayerJoin{Id: id, Type: uint8(TankEntity)}))
+	g.eventManager.Process()
+	p := g.state.getPlayer(id)
+	if p == nil {
+		t.Fatalf("player %v not found in game state", id)
+	}
+
+	// the zombie right next to the spawn point ignores the player, whose
+	// hits are harmless anyway
+	for i := 0; i < 18; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if z.target == Entity(p) {
+		t.Errorf("zombie targets the protected player")
+	}
+	if p.DealDamage(10, MeleeDamage); p.health.Cur != p.health.Total {
+		t.Errorf("protected player has %v HP, want %v", p.health.Cur, p.health.Total)
+	}
+	if !p.State().(PlayerState).Protected {
+		t.Errorf("player state not protected during the spawn protection")
+	}
+
+	// once the protection expires, the zombie attacks
+	for i := 0; i < 40 && p.health.Cur == p.health.Total; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if z.target != Entity(p) || p.health.Cur == p.health.Total {
+		t.Errorf("zombie target = %v, player at %v HP, want the player to be attacked", z.target, p.health.Cur)
+	}
+	if p.State().(PlayerState).Protected {
+		t.Errorf("player state still protected after the spawn protection")
+	}
+}
+
+func TestGameState_onPlayerJoin_Factions(t *testing.T) {
+	for _, friendlyFire := range []bool{false, true} {
+		g := newTestGame(t, openRoom...)
+		g.cfg.PlayerFactions = 2
+		g.cfg.FriendlyFire = friendlyFire
+		g.cfg.SpawnProtection = 0
+		g.state.gameData.mapData.AIKeypoints.Spawn.Players = VecList{{2.5, 2.5}, {2.5, 3.3}, {3.3, 2.5}}
+
+		// the joining players are spread among the player factions
+		var players []*Player
+		for i := 0; i < 3; i++ {
+			id := g.state.allocEntityId()
+			g.PostEvent(events.NewEvent(events.PlayerJoinId,
+				events.PlayerJoin{Id: id, Type: uint8(TankEntity)}))
+			g.eventManager.Process()
+			players = append(players, g.state.getPlayer(id))
+		}
+		attacker, enemy, ally := players[0], players[1], players[2]
+		if attacker.Faction() != PlayerFaction || enemy.Faction() != PlayerFaction+1 || ally.Faction() != PlayerFaction {
+			t.Fatalf("player factions = %v, %v, %v, want %v, %v, %v", attacker.Faction(), enemy.Faction(),
+				ally.Faction(), PlayerFaction, PlayerFaction+1, PlayerFaction)
+		}
+
+		// an ally is only hurt with friendly fire
+		hp := ally.health.Cur
+		g.PostEvent(events.NewEvent(events.PlayerAttackId,
+			events.PlayerAttack{Id: attacker.Id(), EntityId: ally.Id()}))
+		for i := 0; i < 10; i++ {
+			tick(g, 50*time.Millisecond)
+		}
+		if hurt := ally.health.Cur < hp; hurt != friendlyFire {
+			t.Errorf("friendly fire %v: ally hurt = %v", friendlyFire, hurt)
+		}
+
+		// an enemy is always hurt
+		hp = enemy.health.Cur
+		g.PostEvent(events.NewEvent(events.PlayerAttackId,
+			events.PlayerAttack{Id: attacker.Id(), EntityId: enemy.Id()}))
+		for i := 0; i < 100 && enemy.health.Cur == hp; i++ {
+			tick(g, 50*time.Millisecond)
+		}
+		if enemy.health.Cur == hp {
+			t.Errorf("friendly fire %v: enemy not hurt", friendlyFire)
+		}
+	}
+}
+
+func TestGameState_playerSpawnPoint_SkipsOccupied(t *testing.T) {
+	g := newTestGameFromAssets(t, testAssets)
+	spawns := g.state.MapData().AIKeypoints.Spawn.Players
+
+	// occupy the first spawn point with a zombie
+	addTestZombie(g, spawns[0])
+	if got, ok := g.state.playerSpawnPoint(); !ok || !got.Approx(spawns[1]) {
+		t.Errorf("playerSpawnPoint() = %v, %v, want %v", got, ok, spawns[1])
+	}
+}
+
+func TestGameState_onPlayerJoin_NoSpawnPoint(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.state.gameData.mapData.AIKeypoints.Spawn.Players = nil
+
+	// the player can't be spawned, but the game goes on
+	id := g.state.allocEntityId()
+	g.PostEvent(events.NewEvent(events.PlayerJoinId,
+		events.PlayerJoin{Id: id, Type: uint8(TankEntity)}))
+	g.eventManager.Process()
+	if p := g.state.getPlayer(id); p != nil {
+		t.Errorf("player spawned at %v, without any spawn point", p.Position())
+	}
+}
+
+/*
+ * destination returns the last waypoint of the player path, if any
+ */
+func destination(p *Player) (d2.Vec2, bool) {
+	wps := p.NextWaypoints(-1)
+	if len(wps) == 0 {
+		return nil, false
+	}
+	return wps[len(wps)-1], true
+}
+
+func TestGameState_onPlayerMove_Coalescing(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	move := func(dst d2.Vec2) {
+		g.PostEvent(events.NewEvent(events.PlayerMoveId,
+			events.PlayerMove{Id: p.Id(), Xpos: dst[0], Ypos: dst[1]}))
+	}
+
+	// 3 moves in quick succession, only the last one is applied
+	move(d2.Vec2{7.5, 1.5})
+	move(d2.Vec2{7.5, 3.5})
+	move(d2.Vec2{4.5, 3.5})
+	tick(g, 10*time.Millisecond)
+	if dst, ok := destination(p); ok {
+		t.Fatalf("player destination = %v before the path search completed", dst)
+	}
+	tick(g, 10*time.Millisecond)
+	if dst, ok := destination(p); !ok || !dst.Approx(d2.Vec2{4.5, 3.5}) {
+		t.Errorf("player destination = %v, want %v", dst, d2.Vec2{4.5, 3.5})
+	}
+	if p.pathReq != nil {
+		t.Errorf("player still has a pending path request")
+	}
+}
+
+func TestGameState_runPathFinder_FailureReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		dst    d2.Vec2
+		budget int
+		want   PathResult
+	}{
+		{"same room", d2.Vec2{3.5, 3.5}, 0, PathFound},
+		{"sealed room", d2.Vec2{9.5, 2.5}, 0, PathUnreachable},
+		{"out of bounds", d2.Vec2{-4, 2.5}, 0, PathOutOfBounds},
+		{"wall", d2.Vec2{4.5, 2.5}, 0, PathDestinationOccupied},
+		{"budget exceeded", d2.Vec2{3.5, 3.5}, 2, PathBudgetExceeded},
+		{"within budget", d2.Vec2{3.5, 3.5}, 20, PathFound},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, sealedRooms...)
+		g.cfg.PathBudget = tt.budget
+		p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+
+		got := PathResult(255)
+		g.state.runPathFinder(p, tt.dst, func(Path) {
+			got = PathFound
+		}, func(res PathResult) {
+			got = res
+		})
+		g.Pathfinder().Deliver()
+		if got != tt.want {
+			t.Errorf("%s: path search result = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// a building surrounded by walls can't be reached
+	g := newTestGame(t, sealedRooms...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{3.5, 1.5})
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{3.5, 2.5})
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{2.5, 1.5})
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{2.5, 2.5})
+	var got PathResult
+	g.state.runPathFinder(p, d2.Vec2{3.5, 1.5}, func(Path) {
+		got = PathFound
+	}, func(res PathResult) {
+		got = res
+	})
+	g.Pathfinder().Deliver()
+	if got != PathDestinationOccupied {
+		t.Errorf("path search result = %v, want %v", got, PathDestinationOccupied)
+	}
+}
+
+func TestGameState_EventLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	logging.SetOutput(&buf)
+	defer logging.SetOutput(os.Stderr)
+	logging.SetFormatter(&log.JSONFormatter{})
+	defer logging.SetFormatter(&log.TextFormatter{})
+
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	g.tick = 12
+	g.PostEvent(events.NewEvent(events.PlayerMoveId,
+		events.PlayerMove{Id: p.Id(), Xpos: 7.5, Ypos: 1.5}))
+	g.eventManager.Process()
+
+	var entry map[string]interface{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "Received PlayerMove event") {
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("log line %q isn't JSON: %v", line, err)
+			}
+		}
+	}
+	if entry == nil {
+		t.Fatalf("PlayerMove event hasn't been logged:\n%s", buf.String())
+	}
+	want := map[string]float64{
+		logging.TickKey:   12,
+		logging.EntityKey: float64(p.Id()),
+		logging.ClientKey: float64(p.Id()),
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("field %q = %v, want %v", k, entry[k], v)
+		}
+	}
+}
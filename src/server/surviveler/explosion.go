@@ -0,0 +1,176 @@
+/*
+ * Surviveler package
+ * area of effect damage, grenades
+ */
+package surviveler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+// TODO: those values should be taken from the resources
+const (
+	GrenadeFuse    = 2 * time.Second // time before a thrown grenade explodes
+	GrenadeRange   = 8               // max distance at which a grenade is thrown
+	GrenadeRadius  = 3               // radius of the blast
+	GrenadeDamage  = 60              // damage dealt at the center of the blast
+	GrenadeFalloff = 0.75            // fraction of the damage lost at the edge of the blast
+	ThrowPeriod    = 2 * time.Second // min time between 2 throws
+)
+
+/*
+ * blast is an explosion dealing damage to the entities around its center,
+ * less and less with the distance
+ */
+type blast struct {
+	center  d2.Vec2
+	radius  float32
+	damage  float32 // damage dealt at the center
+	falloff float32 // fraction of the damage lost at the edge, linearly with the distance
+	los     bool    // walls and buildings shield the entities behind them
+}
+
+/*
+ * damageAt returns the damage dealt by the blast at a distance from its center
+ */
+func (b blast) damageAt(dist float32) float32 {
+	if dist > b.radius {
+		return 0
+	}
+	return b.damage * (1 - b.falloff*dist/b.radius)
+}
+
+/*
+ * dealAreaDamage deals the damage of a blast to the entities satisfying the
+ * filter that lie within its radius, and returns them.
+ *
+ * The entities are damaged by increasing id, so that the outcome doesn't
+ * depend on the spatial index.
+ */
+func dealAreaDamage(gs *GameState, b blast, f EntityFilter) []Entity {
+	hits := gs.entitiesInRadius(b.center, b.radius, func(e Entity) bool {
+		return f(e) && (!b.los || gs.world.LineOfSight(b.center, e.Position()))
+	})
+	sort.Slice(hits, func(i, j int) bool { return hits[i].e.Id() < hits[j].e.Id() })
+	ents := make([]Entity, len(hits))
+	for i, hit := range hits {
+		hit.e.DealDamage(b.damageAt(hit.d), ExplosiveDamage)
+		ents[i] = hit.e
+	}
+	return ents
+}
+
+/*
+ * Grenade is an entity lying where it has been thrown, that explodes once its
+ * fuse has burnt. The blast hurts the players and the zombies the thrower
+ * faction is hostile to, as long as no wall shields them.
+ */
+type Grenade struct {
+	id             uint32
+	pos            d2.Vec2
+	dir            d2.Vec2       // direction in which it has been thrown
+	fuse           time.Duration // time left before the explosion
+	throwerFaction Faction
+	g              *Game
+}
+
+/*
+ * NewGrenade creates a grenade thrown from org towards dst, by an entity of
+ * the given faction. It lands at dst, or closer if dst is out of range or a
+ * wall is in between.
+ */
+func NewGrenade(g *Game, org, dst d2.Vec2, faction Faction) *Grenade {
+	delta := dst.Sub(org)
+	if l := delta.Len(); l > GrenadeRange {
+		delta = delta.Scale(GrenadeRange / l)
+	}
+	w := g.State().World()
+	if tile, blocked := w.Raycast(org, org.Add(delta), isOpaque); blocked {
+		// falls right before the wall
+		var t float32
+		if tile != nil {
+			t, _ = segmentEntry(org, delta, tile.Rectangle())
+		}
+		delta = delta.Scale(math32.Max(t-projectileSize/delta.Len(), 0))
+	}
+	dir := delta.Scale(1 / math32.Max(delta.Len(), 1e-6))
+	return &Grenade{
+		id:             InvalidID,
+		pos:            org.Add(delta),
+		dir:            dir,
+		fuse:           GrenadeFuse,
+		throwerFaction: faction,
+		g:              g,
+	}
+}
+
+func (gr *Grenade) Id() uint32 {
+	return gr.id
+}
+
+func (gr *Grenade) SetId(id uint32) {
+	gr.id = id
+}
+
+func (gr *Grenade) Type() EntityType {
+	return GrenadeProjectile
+}
+
+func (gr *Grenade) Faction() Faction {
+	return NeutralFaction
+}
+
+func (gr *Grenade) Position() d2.Vec2 {
+	return gr.pos
+}
+
+func (gr *Grenade) Rectangle() d2.Rectangle {
+	return d2.RectFromCircle(gr.pos, projectileSize)
+}
+
+func (gr *Grenade) State() EntityState {
+	return ProjectileState{
+		Type: GrenadeProjectile,
+		Xpos: gr.pos[0],
+		Ypos: gr.pos[1],
+		Xdir: gr.dir[0],
+		Ydir: gr.dir[1],
+	}
+}
+
+/*
+ * Update burns the fuse, then the grenade explodes and vanishes
+ */
+func (gr *Grenade) Update(dt time.Duration) {
+	if gr.fuse -= dt; gr.fuse > 0 {
+		return
+	}
+	gs := gr.g.State()
+	dealAreaDamage(gs, blast{
+		center:  gr.pos,
+		radius:  GrenadeRadius,
+		damage:  GrenadeDamage,
+		falloff: GrenadeFalloff,
+		los:     true,
+	}, func(e Entity) bool {
+		switch e.(type) {
+		case *Zombie, *Player:
+			return gs.Hostile(gr.throwerFaction, e.Faction())
+		}
+		return false
+	})
+	gs.RemoveEntity(gr.id)
+}
+
+func (gr *Grenade) DealDamage(damage float32, typ DamageType) bool {
+	// grenades can't be damaged
+	return false
+}
+
+func (gr *Grenade) HealDamage(damage float32) bool {
+	return true
+}
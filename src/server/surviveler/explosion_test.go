@@ -0,0 +1,136 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+func TestDealAreaDamage_Falloff(t *testing.T) {
+	g := newTestGame(t, newTestRoom(21)...)
+	center := d2.Vec2{10.5, 10.5}
+	b := blast{center: center, radius: 3, damage: 40, falloff: 0.5}
+
+	dists := []float32{0, 1, 2, 2.9, 3.1, 5}
+	zombies := make([]*Zombie, len(dists))
+	for i, d := range dists {
+		zombies[i] = addTestZombie(g, center.Add(d2.Vec2{0, d}))
+	}
+	hits := dealAreaDamage(g.state, b, isZombie)
+	if len(hits) != 4 {
+		t.Errorf("%d entities hit, want the 4 within the radius", len(hits))
+	}
+
+	last := float32(math32.MaxFloat32)
+	for i, z := range zombies {
+		dmg := z.health.Total - z.health.Cur
+		if dists[i] > b.radius {
+			if dmg != 0 {
+				t.Errorf("zombie %v away took %v damage, out of the blast", dists[i], dmg)
+			}
+			continue
+		}
+		if want := 40 * (1 - 0.5*dists[i]/3); math32.Abs(dmg-want) > 1e-3 {
+			t.Errorf("zombie %v away took %v damage, want %v", dists[i], dmg, want)
+		}
+		if dmg >= last {
+			t.Errorf("zombie %v away took %v damage, not less than a closer one", dists[i], dmg)
+		}
+		last = dmg
+	}
+}
+
+func TestDealAreaDamage_LineOfSight(t *testing.T) {
+	rows := []string{
+		"###########",
+		"#....#....#",
+		"#....#....#",
+		"#.........#",
+		"###########",
+	}
+	for _, los := range []bool{false, true} {
+		g := newTestGame(t, rows...)
+		center := d2.Vec2{3.5, 1.5}
+		visible := addTestZombie(g, d2.Vec2{3.5, 3.5})
+		shielded := addTestZombie(g, d2.Vec2{6.5, 1.5})
+		dealAreaDamage(g.state, blast{center: center, radius: 4, damage: 20, los: los}, isZombie)
+
+		if visible.health.Cur != visible.health.Total-20 {
+			t.Errorf("los %v: visible zombie has %v HP, want it hurt", los, visible.health.Cur)
+		}
+		if hurt := shielded.health.Cur != shielded.health.Total; hurt == los {
+			t.Errorf("los %v: zombie behind the wall hurt = %v, want %v", los, hurt, !los)
+		}
+	}
+}
+
+func TestPlayer_ThrowGrenade(t *testing.T) {
+	g := newTestGame(t, newTestRoom(21)...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{2.5, 2.5})
+	ally := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 4.5})
+	z := addTestZombie(g, d2.Vec2{9.5, 2.5})
+	z.walkSpeed = 0
+	z.health.Total, z.health.Cur = 500, 500
+
+	gr := p.Throw(d2.Vec2{8.5, 2.5})
+	if gr == nil {
+		t.Fatalf("Throw() = nil, want a grenade")
+	}
+	if !gr.Position().Approx(d2.Vec2{8.5, 2.5}) {
+		t.Errorf("grenade landed at %v, want %v", gr.Position(), d2.Vec2{8.5, 2.5})
+	}
+	if p.Throw(d2.Vec2{8.5, 2.5}) != nil {
+		t.Errorf("Throw() succeeded again right away")
+	}
+
+	// nothing happens until the fuse has burnt
+	const dt = 100 * time.Millisecond
+	for elapsed := dt; elapsed < GrenadeFuse; elapsed += dt {
+		tick(g, dt)
+	}
+	if z.health.Cur != z.health.Total || g.state.Entity(gr.Id()) != gr {
+		t.Fatalf("grenade exploded before its fuse burnt")
+	}
+	tick(g, dt)
+	if g.state.Entity(gr.Id()) == gr {
+		t.Errorf("grenade still in game after the explosion")
+	}
+	b := blast{radius: GrenadeRadius, damage: GrenadeDamage, falloff: GrenadeFalloff}
+	if want := z.health.Total - b.damageAt(1); math32.Abs(z.health.Cur-want) > 1e-3 {
+		t.Errorf("zombie has %v HP after the explosion, want %v", z.health.Cur, want)
+	}
+	if ally.health.Cur != ally.health.Total {
+		t.Errorf("allied player hurt by the explosion")
+	}
+
+	// the throw period has elapsed with the logic time meanwhile
+	if p.Throw(d2.Vec2{8.5, 2.5}) == nil {
+		t.Errorf("Throw() should succeed once the throw period has elapsed")
+	}
+}
+
+func TestNewGrenade_Landing(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	org := d2.Vec2{1.5, 2.5}
+
+	// out of range, it falls at the max range, or before the wall
+	tests := []struct {
+		dst  d2.Vec2
+		maxX float32
+	}{
+		{d2.Vec2{5.5, 2.5}, 5.5},
+		{d2.Vec2{40, 2.5}, 8},
+	}
+	for _, tt := range tests {
+		gr := NewGrenade(g, org, tt.dst, PlayerFaction)
+		pos := gr.Position()
+		if pos[0] > tt.maxX || math32.Abs(pos[1]-2.5) > 1e-3 || pos.Sub(org).Len() > GrenadeRange {
+			t.Errorf("grenade thrown to %v landed at %v", tt.dst, pos)
+		}
+		if tile, ok := g.state.World().TileAtWorldVec(pos); !ok || !tile.IsWalkable() {
+			t.Errorf("grenade thrown to %v landed at %v, not on a walkable tile", tt.dst, pos)
+		}
+	}
+}
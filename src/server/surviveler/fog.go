@@ -0,0 +1,111 @@
+/*
+ * Surviveler package
+ * fog of war, tiles explored by the players
+ */
+package surviveler
+
+import (
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+// PlayerVisionRadius is the distance up to which a player reveals the map
+const PlayerVisionRadius = 8
+
+/*
+ * ExploredMap is the component holding the tiles of the world grid that an
+ * entity has seen.
+ *
+ * Tiles are identified by their index in the world grid, x + y * grid width.
+ * The tiles revealed since the last call to TakeRevealed are kept apart, so
+ * that only those are sent to the client.
+ */
+type ExploredMap struct {
+	width, height int
+	bits          []uint64
+	revealed      []uint32 // tiles revealed since the last TakeRevealed
+	last          *Tile    // tile from which tiles were last revealed
+}
+
+/*
+ * NewExploredMap creates an explored map of the world grid, with no explored
+ * tiles
+ */
+func NewExploredMap(w *World) *ExploredMap {
+	return &ExploredMap{
+		width:  w.GridWidth,
+		height: w.GridHeight,
+		bits:   make([]uint64, (w.GridWidth*w.GridHeight+63)/64),
+	}
+}
+
+/*
+ * Explored indicates if the tile at grid coordinates (x, y) has been explored
+ */
+func (em *ExploredMap) Explored(x, y int) bool {
+	if x < 0 || x >= em.width || y < 0 || y >= em.height {
+		return false
+	}
+	i := x + y*em.width
+	return em.bits[i/64]&(1<<uint(i%64)) != 0
+}
+
+/*
+ * Count returns the number of explored tiles
+ */
+func (em *ExploredMap) Count() int {
+	var n int
+	for _, b := range em.bits {
+		for ; b != 0; b &= b - 1 {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+ * Reveal explores the tiles whose centers lie within radius of pos, in world
+ * coordinates.
+ *
+ * Nothing is done if pos lies on the tile from which tiles were last
+ * revealed, as the same tiles would be revealed.
+ */
+func (em *ExploredMap) Reveal(w *World, pos d2.Vec2, radius float32) {
+	tile, ok := w.TileAtWorldVec(pos)
+	if !ok || tile == em.last {
+		return
+	}
+	em.last = tile
+
+	// work in grid coordinates
+	center := pos.Scale(w.GridScale)
+	r := radius * w.GridScale
+	minx, maxx := int(math32.Floor(center[0]-r)), int(math32.Ceil(center[0]+r))
+	miny, maxy := int(math32.Floor(center[1]-r)), int(math32.Ceil(center[1]+r))
+	for y := miny; y <= maxy; y++ {
+		for x := minx; x <= maxx; x++ {
+			if x < 0 || x >= em.width || y < 0 || y >= em.height {
+				continue
+			}
+			tc := d2.Vec2{float32(x) + 0.5, float32(y) + 0.5}
+			if tc.Sub(center).LenSqr() > r*r {
+				continue
+			}
+			i := x + y*em.width
+			if em.bits[i/64]&(1<<uint(i%64)) == 0 {
+				em.bits[i/64] |= 1 << uint(i%64)
+				em.revealed = append(em.revealed, uint32(i))
+			}
+		}
+	}
+}
+
+/*
+ * TakeRevealed returns the tiles revealed since the last call, and forgets
+ * them
+ */
+func (em *ExploredMap) TakeRevealed() []uint32 {
+	revealed := em.revealed
+	em.revealed = nil
+	return revealed
+}
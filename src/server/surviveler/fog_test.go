@@ -0,0 +1,74 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestExploredMap_Reveal(t *testing.T) {
+	w := newTestWorld(t, 1, longRoom...)
+	em := NewExploredMap(w)
+	em.Reveal(w, d2.Vec2{1.5, 2.5}, 1)
+
+	// the tile and its 4 neighbours
+	want := map[[2]int]bool{{1, 2}: true, {0, 2}: true, {2, 2}: true, {1, 1}: true, {1, 3}: true}
+	for y := 0; y < w.GridHeight; y++ {
+		for x := 0; x < w.GridWidth; x++ {
+			if em.Explored(x, y) != want[[2]int{x, y}] {
+				t.Errorf("Explored(%d, %d) = %v, want %v", x, y, em.Explored(x, y), want[[2]int{x, y}])
+			}
+		}
+	}
+	if revealed := em.TakeRevealed(); len(revealed) != len(want) {
+		t.Errorf("TakeRevealed() = %v, want %d tiles", revealed, len(want))
+	}
+
+	// only the new tiles are revealed
+	em.Reveal(w, d2.Vec2{2.5, 2.5}, 1)
+	revealed := em.TakeRevealed()
+	if len(revealed) != 3 {
+		t.Errorf("TakeRevealed() = %v, want the 3 new tiles", revealed)
+	}
+	for _, i := range revealed {
+		if x, y := int(i)%w.GridWidth, int(i)/w.GridWidth; x != 3 && x != 2 {
+			t.Errorf("tile (%d, %d) revealed again", x, y)
+		}
+	}
+	if revealed := em.TakeRevealed(); len(revealed) != 0 {
+		t.Errorf("TakeRevealed() = %v, want no tiles", revealed)
+	}
+}
+
+func TestPlayer_RevealsTilesAlongPath(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	tick(g, 0)
+	explored := p.explored.Count()
+	if explored == 0 || !p.explored.Explored(1, 2) {
+		t.Fatalf("player didn't explore its surroundings")
+	}
+	if p.explored.Explored(16, 2) {
+		t.Fatalf("tile (16, 2) explored before being in vision range")
+	}
+
+	p.Move(Path{{16.5, 2.5}})
+	var revealed int
+	for i := 0; i < 200 && !isIdle(p); i++ {
+		tick(g, 50*time.Millisecond)
+		n := p.explored.Count()
+		if n < explored {
+			t.Fatalf("explored tiles went down from %d to %d", explored, n)
+		}
+		explored = n
+		revealed += len(p.explored.TakeRevealed())
+	}
+	if !p.explored.Explored(16, 2) {
+		t.Errorf("tile (16, 2) not explored at the end of the path")
+	}
+	// every explored tile has been revealed exactly once
+	if want := p.explored.Count(); revealed != want {
+		t.Errorf("%d tiles revealed, want the %d explored tiles", revealed, want)
+	}
+}
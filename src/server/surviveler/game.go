@@ -6,10 +6,13 @@ package surviveler
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"server/events"
+	"server/logging"
+	"server/messages"
 	"server/protocol"
 	"server/resource"
 	"sync"
@@ -19,6 +22,12 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
+/*
+ * Time let to the clients to disconnect after having been notified of the
+ * server shutdown
+ */
+const ShutdownGracePeriod = 500 * time.Millisecond
+
 /*
  * Game is the main game structure, entry and exit points
  */
@@ -37,56 +46,123 @@ type Game struct {
 	state        *GameState               // the game state
 	pathfinder   *Pathfinder              // pathfinder
 	ai           *AIDirector              // AI director
+	lod          *lodScheduler            // entity updates scheduling, nil to update all every tick
+	rng          *RNG                     // root of the subsystems random number generators
 	gameData     *gameData
+	tick         uint64         // number of logic ticks performed
+	clockAcc     time.Duration  // logic time not yet accounted in the game time
+	recorder     *Recorder      // if recording, the client events recorder
+	replayer     *Replayer      // if replaying, the client events replayer
+	metrics      *Metrics       // runtime metrics
+	logicWatch   overrunWatch   // detects the logic ticks overrunning their period
+	sendWatch    overrunWatch   // detects the send ticks overrunning their period
+	paused       int32          // accessed atomically, 1 while the game is paused
+	skipSend     bool           // skip the next send tick, to catch up
+	sendSkipped  bool           // the last send tick has been skipped
+	sendPhase    int            // logic ticks since the last aligned send tick
+	updateIDs    []uint32       // ids of the entities updated during the logic tick
+	snapshot     snapshotHolder // read-only game state, published after each logic tick
+	metricsSrv   *http.Server   // if enabled, the metrics http server
 }
 
 /*
  * Setup initializes the different game subsystems
  */
 func NewGame(cfg Config) *Game {
-	g := new(Game)
+	if !setupProcess(&cfg) {
+		return nil
+	}
+	g := &Game{cfg: cfg}
 
-	// copy configuration
-	g.cfg = cfg
+	// load assets
+	gd, err := g.loadAssets(g.cfg.AssetsPath)
+	if err != nil {
+		log.WithError(err).Error("Couldn't load assets")
+		return nil
+	}
+	if err = g.init(gd); err != nil {
+		log.WithError(err).Error("Couldn't setup the game")
+		return nil
+	}
+	return g
+}
 
+/*
+ * setupProcess performs the process-wide setup: logging, configuration
+ * validation and go runtime. It returns false if the game can't be set up.
+ */
+func setupProcess(cfg *Config) bool {
 	var (
 		err error
 		lvl log.Level
 	)
 	// setup logger
-	if lvl, err = log.ParseLevel(g.cfg.LogLevel); err != nil {
+	if lvl, err = log.ParseLevel(cfg.LogLevel); err != nil {
 		log.WithFields(log.Fields{
-			"level":   g.cfg.LogLevel,
+			"level":   cfg.LogLevel,
 			"default": DefaultLogLevel,
 		}).Warn("unknown log level, using default")
-		g.cfg.LogLevel = DefaultLogLevel
+		cfg.LogLevel = DefaultLogLevel
 		lvl, _ = log.ParseLevel(DefaultLogLevel)
 	}
-	log.StandardLogger().Level = lvl
+	logging.SetLevel(lvl)
 
-	// dump config
-	log.WithField("cfg", g.cfg).Info("Game configuration")
+	// dump and validate config
+	log.WithField("cfg", *cfg).Info("Game configuration")
+	if err = cfg.Validate(); err != nil {
+		log.WithError(err).Error("Couldn't setup the game")
+		return false
+	}
+	cfg.checkTickPeriods()
+	if err = logging.Setup(cfg.Logging); err != nil {
+		log.WithError(err).Error("Couldn't setup logging")
+		return false
+	}
 
 	// setup go runtime
 	runtime.GOMAXPROCS(runtime.NumCPU())
+	return true
+}
 
-	// load assets
-	g.gameData, err = g.loadAssets(g.cfg.AssetsPath)
-	if err != nil {
-		log.WithError(err).Error("Couldn't load assets")
-		return nil
-	}
+/*
+ * init initializes the game subsystems, around the loaded game data
+ */
+func (g *Game) init(gd *gameData) error {
+	var err error
+	cfg := g.cfg
+	g.gameData = gd
 
 	// initialize the gamestate
 	g.state = newGameState(g, int16(cfg.GameStartingTime))
-	if err := g.state.init(g.gameData); err != nil {
-		log.WithError(err).Error("Couldn't initialize gamestate")
-		return nil
+	if err = g.state.init(g.gameData); err != nil {
+		return fmt.Errorf("couldn't initialize gamestate: %v", err)
+	}
+
+	// setup session recording or replay, a replay uses the recorded seed
+	seed := g.cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if len(g.cfg.ReplayPath) > 0 {
+		if g.replayer, err = NewReplayer(g.cfg.ReplayPath); err != nil {
+			return fmt.Errorf("couldn't load replay: %v", err)
+		}
+		seed = g.replayer.seed
+	} else if len(g.cfg.RecordPath) > 0 {
+		if g.recorder, err = NewRecorder(g.cfg.RecordPath, seed); err != nil {
+			return fmt.Errorf("couldn't create record file: %v", err)
+		}
+		log.WithField("path", g.cfg.RecordPath).Info("Recording session")
 	}
 
+	g.rng = NewRNG(seed)
+	log.WithField("seed", seed).Info("Random number generators seeded")
+
 	// init channels
 	g.quitChan = make(chan struct{})
 
+	g.metrics = NewMetrics()
+
 	g.eventManager = events.NewManager()
 
 	// creates the client registry
@@ -94,40 +170,77 @@ func NewGame(cfg Config) *Game {
 		return g.state.allocEntityId()
 	}
 	g.clients = protocol.NewClientRegistry(allocId)
+	g.clients.SetReconnectGracePeriod(time.Duration(cfg.ReconnectGrace) * time.Second)
+	g.clients.SetMaxViewRadius(cfg.ViewRadius)
+	g.clients.SetMaxDroppedSends(cfg.SlowClientDrops)
 
 	// setup the telnet server
 	if len(g.cfg.TelnetPort) > 0 {
 		g.telnetReq = make(chan TelnetRequest)
 		g.telnetDone = make(chan error)
 		g.telnet = protocol.NewTelnetServer(g.cfg.TelnetPort, g.clients)
+		g.telnet.SetPassword(g.cfg.TelnetPassword)
+		if len(g.cfg.TelnetPassword) == 0 {
+			log.Warn("The telnet server isn't password protected, anyone reaching it can administer the game")
+		}
 		g.registerTelnetHandlers()
 	}
 
 	// initialize the pathfinder module
 	g.pathfinder = NewPathfinder(g)
+	g.lod = newLODScheduler(g.state)
 
 	// init the AI director
 	g.ai = NewAIDirector(g, int16(cfg.NightStartingTime), int16(cfg.NightEndingTime))
 	g.server = protocol.NewServer(g.cfg.Port, g.clients, g.telnet, &g.wg, g.clients)
+	g.registerServerCallbacks()
+	g.registerMsgHandlers()
+	g.registerEntityHooks()
+	return nil
+}
 
+/*
+ * registerServerCallbacks turns the players joining and leaving the server
+ * into game events
+ */
+func (g *Game) registerServerCallbacks() {
 	// this will be called after a new player has successfully joined the game
 	g.server.OnPlayerJoined(func(ID uint32, playerType uint8) {
-		g.PostEvent(
+		g.postClientEvent(
 			events.NewEvent(
 				events.PlayerJoinId,
 				events.PlayerJoin{Id: ID, Type: playerType}))
 	})
 
-	// this will be called after a player has effectively left the game
+	// this will be called after a player has effectively left the game, a
+	// disconnected player leaves once it can't resume anymore
 	g.server.OnPlayerLeft(func(ID uint32) {
-		g.PostEvent(
+		g.postClientEvent(
 			events.NewEvent(
 				events.PlayerLeaveId,
 				events.PlayerLeave{Id: ID}))
 	})
 
-	g.registerMsgHandlers()
-	return g
+	// area chat messages are delivered to the players around the sender
+	g.server.OnAreaChat(func(chat messages.Chat) {
+		g.postClientEvent(
+			events.NewEvent(
+				events.PlayerChatId,
+				events.PlayerChat{Id: chat.Id, Text: chat.Text}))
+	})
+}
+
+/*
+ * registerEntityHooks registers the hooks keeping the game in sync with the
+ * entities entering and leaving the game
+ */
+func (g *Game) registerEntityHooks() {
+	for _, et := range []EntityType{TankEntity, ProgrammerEntity, EngineerEntity} {
+		// a disconnected player removed from the game can't be resumed
+		g.state.OnRemove(messages.MobileEntityKind, et, func(ent Entity) {
+			g.clients.EndSession(ent.Id())
+		})
+	}
 }
 
 /*
@@ -137,43 +250,137 @@ func (g *Game) loadAssets(path string) (*gameData, error) {
 	if len(path) == 0 {
 		return nil, fmt.Errorf("can't start without a specified assets path")
 	}
-	pkg, err := resource.OpenFSPackage(g.cfg.AssetsPath)
+	pkg, err := resource.OpenFSPackage(path)
 	if err != nil {
-		return nil, fmt.Errorf("can't open assets %v", g.cfg.AssetsPath)
+		return nil, fmt.Errorf("can't open assets %v", path)
 	}
-	g.assets = pkg
 
 	// load game assets
-	gameData, err := newGameData(g.assets)
+	gameData, err := newGameData(pkg, g.cfg.GridScale, g.cfg.GridCache)
 	if err != nil {
 		return nil, err
 	}
+	// make the map edges impassable, the spawn points must still be usable
+	if g.cfg.MapBorder > 0 {
+		gameData.world.SetBorder(g.cfg.MapBorder)
+		if err = gameData.validateWorld(gameData.world); err != nil {
+			return nil, err
+		}
+	}
+	g.assets = pkg
 
 	log.WithField("path", path).Info("Assets loaded successfully")
 	return gameData, nil
 }
 
+/*
+ * reloadAssets reloads the assets package from disk and swaps the new game
+ * data into the running game.
+ *
+ * The world is kept as is, as entities are attached to it, so the new map
+ * data is validated against the current world. Existing entities keep their
+ * current state, only their speed is updated, new entities use the new data.
+ * On error, the game data is left untouched.
+ */
+func (g *Game) reloadAssets() error {
+	gd, err := g.loadAssets(g.cfg.AssetsPath)
+	if err != nil {
+		return err
+	}
+	if err = gd.validateWorld(g.state.world); err != nil {
+		return fmt.Errorf("new map data doesn't match the current world: %v", err)
+	}
+	gd.world = g.state.world
+
+	// swap the game data
+	g.gameData = gd
+	g.state.gameData = gd
+	g.state.nextSpawn = 0
+	g.ai.keypoints = gd.mapData.AIKeypoints
+	g.ai.entitiesData = gd.entitiesData
+
+	// re-apply speeds to moving entities
+	g.state.forEachEntity(func(ent Entity) bool {
+		switch e := ent.(type) {
+		case *Player:
+			e.Speed = g.state.EntityData(e.Type()).Speed
+		case *Zombie:
+			e.walkSpeed = g.state.EntityData(ZombieEntity).variant(e.behavior).Speed
+			e.Speed = e.walkSpeed
+		}
+		return true
+	})
+	log.WithField("path", g.cfg.AssetsPath).Info("Assets reloaded")
+	return nil
+}
+
 /*
  * Start starts the server and game loops
  */
 func (g *Game) Start() {
 	// start everything
 	g.server.Start()
-
-	// start the game loop (will return immedialtely as the game loop runs
-	// in a goroutine)
-	if err := g.loop(); err != nil {
+	if err := g.run(); err != nil {
 		log.WithError(err).Error("Game state initialization failed...")
 	} else {
 		// game loop started, make this goroutine wait for
 		// for an operating system signal
-		chSig := make(chan os.Signal)
-		defer close(chSig)
-		signal.Notify(chSig, syscall.SIGINT, syscall.SIGTERM)
-		log.WithField("signal", <-chSig).Warn("Received termination signal")
+		waitTermination()
 	}
 
 	g.stop()
+	logging.Close()
+}
+
+/*
+ * run starts the metrics server, if enabled, and the game loop, which will
+ * return immediately as the game loop runs in a goroutine
+ */
+func (g *Game) run() error {
+	if len(g.cfg.MetricsPort) > 0 {
+		g.startMetricsServer()
+	}
+	return g.loop()
+}
+
+/*
+ * waitTermination blocks until an operating system termination signal is
+ * received
+ */
+func waitTermination() {
+	chSig := make(chan os.Signal, 1)
+	defer close(chSig)
+	signal.Notify(chSig, syscall.SIGINT, syscall.SIGTERM)
+	log.WithField("signal", <-chSig).Warn("Received termination signal")
+	signal.Stop(chSig)
+}
+
+/*
+ * startMetricsServer starts the http server exposing the runtime metrics,
+ * in the Prometheus format on /metrics, and human readable on /stats
+ */
+func (g *Game) startMetricsServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := g.metrics.Snapshot().WritePrometheus(w); err != nil {
+			log.WithError(err).Warn("Couldn't write metrics")
+		}
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		g.writeStats(w)
+	})
+	g.metricsSrv = &http.Server{Addr: ":" + g.cfg.MetricsPort, Handler: mux}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		log.WithField("addr", g.metricsSrv.Addr).Info("Metrics server ready")
+		if err := g.metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("Metrics server failed")
+		}
+	}()
 }
 
 func (g *Game) State() *GameState {
@@ -199,16 +406,26 @@ func (g *Game) WaitGroup() *sync.WaitGroup {
 /*
  * stop cleanups the servers and exits the various loops
  *
- * Main tcp server and telnet server are successively closed, each in a
- * blocking call to Stop that let them cleanups the various goroutines and
- * connections still opened.
+ * Clients are notified of the shutdown, then the main tcp server and telnet
+ * server are successively closed, each in a blocking call that let them
+ * cleanups the various goroutines and connections still opened.
  */
 func (g *Game) stop() {
-	g.server.Stop()
+	g.server.Shutdown("server shutdown", ShutdownGracePeriod)
 	if g.telnet != nil {
 		g.telnet.Stop()
 	}
 
+	if g.metricsSrv != nil {
+		g.metricsSrv.Close()
+	}
+
 	close(g.quitChan)
 	g.wg.Wait()
+
+	if g.recorder != nil {
+		if err := g.recorder.Close(); err != nil {
+			log.WithError(err).Error("Couldn't close record file")
+		}
+	}
 }
@@ -0,0 +1,358 @@
+package surviveler
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"server/actions"
+	"server/events"
+	"server/messages"
+	"server/protocol"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestGame_reloadAssets(t *testing.T) {
+	dir, cleanup := copyTestAssets(t)
+	defer cleanup()
+	g := newTestGameFromAssets(t, dir)
+	g.cfg.AssetsPath = dir
+	old := addTestZombie(g, d2.Vec2{4.5, 4.5})
+
+	zombieData := filepath.Join(dir, "entities", "zombie", "data.json")
+	err := ioutil.WriteFile(zombieData,
+		[]byte(`{"building_power": 0, "combat_power": 9, "tot_hp": 120, "speed": 2}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.reloadAssets(); err != nil {
+		t.Fatalf("reloadAssets() error = %v", err)
+	}
+
+	// newly spawned zombies should use the new stats
+	g.ai.SummonZombie()
+	var z *Zombie
+	for _, ent := range g.state.entities {
+		if e, ok := ent.(*Zombie); ok && e != old {
+			z = e
+		}
+	}
+	if z == nil {
+		t.Fatalf("no zombie has been summoned")
+	}
+	if z.health.Total != 120 || z.combat.Power != 9 || z.Speed != 2 {
+		t.Errorf("summoned zombie has HP %v, CP %v, speed %v, want 120, 9, 2",
+			z.health.Total, z.combat.Power, z.Speed)
+	}
+
+	// existing zombies should only have their speed updated
+	if old.health.Total != 50 || old.health.Cur != 50 || old.combat.Power != 5 {
+		t.Errorf("existing zombie state changed: HP %v/%v, CP %v", old.health.Cur, old.health.Total, old.combat.Power)
+	}
+	if old.Speed != 2 {
+		t.Errorf("existing zombie speed = %v, want 2", old.Speed)
+	}
+}
+
+func TestGame_reloadInvalidAssets(t *testing.T) {
+	dir, cleanup := copyTestAssets(t)
+	defer cleanup()
+	g := newTestGameFromAssets(t, dir)
+	g.cfg.AssetsPath = dir
+	gd := g.gameData
+
+	zombieData := filepath.Join(dir, "entities", "zombie", "data.json")
+	if err := ioutil.WriteFile(zombieData, []byte(`{"tot_hp": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.reloadAssets(); err == nil {
+		t.Fatalf("reloadAssets() with an invalid package should fail")
+	}
+	if g.gameData != gd || g.state.gameData != gd {
+		t.Errorf("game data should be left untouched after a failed reload")
+	}
+}
+
+/*
+ * testJoin connects a client to the game server and joins the game as a tank,
+ * with the given session token. It returns the connection and the received
+ * STAY message.
+ */
+func testJoin(t *testing.T, g *Game, token string) (net.Conn, messages.Stay) {
+	return testJoinWith(t, g, messages.Join{
+		Name:    "John Doe",
+		Type:    uint8(TankEntity),
+		Version: protocol.MaxProtocolVersion,
+		Token:   token,
+	})
+}
+
+/*
+ * testJoinWith connects to the game server and sends join, then returns the
+ * connection and the STAY message answering it
+ */
+func testJoinWith(t *testing.T, g *Game, join messages.Join) (net.Conn, messages.Stay) {
+	conn, err := net.Dial("tcp", g.server.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if _, err := conn.Write(messages.New(messages.JoinId, join).Serialize()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	var stay messages.Stay
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			t.Fatalf("no STAY received, error = %v", err)
+		}
+		if msg.Type == messages.StayId {
+			messages.Decode(msg, &stay)
+			return conn, stay
+		}
+	}
+}
+
+func TestGame_ResumePlayer(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	g := newTestGame(t, openRoom...)
+	g.cfg.SpawnProtection = 0 // the joined player gets hurt right away
+	g.clients.SetReconnectGracePeriod(grace)
+	g.server = protocol.NewServer("0", g.clients, nil, &g.wg, g.clients)
+	g.registerServerCallbacks()
+	g.server.Start()
+
+	// processEvents processes the game events until the player having the
+	// given id is in game, or not
+	processEvents := func(id uint32, present bool) *Player {
+		for i := 0; i < 100; i++ {
+			g.eventManager.Process()
+			if p := g.state.getPlayer(id); (p != nil) == present {
+				return p
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("player %d in game = %v, want %v", id, !present, present)
+		return nil
+	}
+	waitDisconnection := func(conn net.Conn) {
+		conn.Close()
+		for i := 0; i < 100 && g.clients.Len() != 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		g.eventManager.Process()
+	}
+
+	conn, stay := testJoin(t, g, "")
+	p := processEvents(stay.Id, true)
+	// the player gets hurt, while walking somewhere
+	teleport(g, p, d2.Vec2{3.5, 2.5})
+	p.DealDamage(30, MeleeDamage)
+	p.Move(Path{d2.Vec2{7.5, 2.5}})
+
+	// reconnect within the grace period
+	waitDisconnection(conn)
+	conn, resumed := testJoin(t, g, stay.Token)
+	if resumed.Id != stay.Id {
+		t.Fatalf("resumed player id = %d, want %d", resumed.Id, stay.Id)
+	}
+	if got := processEvents(stay.Id, true); got != p {
+		t.Fatalf("resumed player = %p, want the same player %p", got, p)
+	}
+	if !p.Pos.Approx(d2.Vec2{3.5, 2.5}) || p.health.Cur != p.health.Total-30 {
+		t.Errorf("resumed player at %v with %v HP, want its previous state", p.Pos, p.health.Cur)
+	}
+	if action, _ := p.actions.Peek(); action.Type != actions.MoveId {
+		t.Errorf("resumed player action = %v, want it to keep moving", action.Type)
+	}
+
+	// reconnect after the grace period
+	waitDisconnection(conn)
+	time.Sleep(grace)
+	processEvents(stay.Id, false)
+	conn, fresh := testJoin(t, g, stay.Token)
+	if fresh.Id == stay.Id {
+		t.Errorf("player resumed after the grace period")
+	}
+	np := processEvents(fresh.Id, true)
+	if np.health.Cur != np.health.Total {
+		t.Errorf("new player has %v HP, want %v", np.health.Cur, np.health.Total)
+	}
+
+	waitDisconnection(conn)
+	g.server.Stop()
+	g.wg.Wait()
+}
+
+func TestGame_PauseResume(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	var moves int
+	g.eventManager.Subscribe(events.PlayerMoveId, func(*events.Event) { moves++ })
+	move := func(x, y float32) {
+		g.postClientEvent(events.NewEvent(events.PlayerMoveId,
+			events.PlayerMove{Id: p.Id(), Xpos: x, Ypos: y}))
+	}
+	timestep := NewTimestep(10 * time.Millisecond)
+
+	move(7.5, 2.5)
+	g.advance(timestep, 50*time.Millisecond)
+	if p.Pos[0] <= 1.5 {
+		t.Fatalf("player at %v didn't start moving", p.Pos)
+	}
+
+	// nothing happens while paused, however long it lasts
+	if err := g.pause(); err != nil {
+		t.Fatalf("pause() error = %v", err)
+	}
+	if err := g.pause(); err == nil {
+		t.Errorf("pausing a paused game should fail")
+	}
+	pos, tick := d2.Vec2{p.Pos[0], p.Pos[1]}, g.tick
+	move(1.5, 2.5)
+	g.advance(timestep, 5*time.Second)
+	if !p.Pos.Approx(pos) || g.tick != tick {
+		t.Errorf("player moved from %v to %v during %d ticks while paused", pos, p.Pos, g.tick-tick)
+	}
+	if moves != 1 {
+		t.Errorf("%d move events processed while paused, want the last one queued", moves-1)
+	}
+
+	// the queued move is processed on resume
+	if err := g.resume(); err != nil {
+		t.Fatalf("resume() error = %v", err)
+	}
+	if err := g.resume(); err == nil {
+		t.Errorf("resuming a running game should fail")
+	}
+	g.advance(timestep, 50*time.Millisecond)
+	if moves != 2 {
+		t.Errorf("%d move events processed after resuming, want 2", moves)
+	}
+	if g.tick-tick != 5 {
+		t.Errorf("%d ticks performed after resuming, want 5, the pause being forgotten", g.tick-tick)
+	}
+	if p.Pos.Approx(pos) {
+		t.Errorf("player didn't move after resuming")
+	}
+
+	// events received while paused can be dropped instead
+	g.cfg.PauseEvents = PauseEventsDrop
+	g.pause()
+	move(7.5, 2.5)
+	g.resume()
+	g.advance(timestep, 10*time.Millisecond)
+	if moves != 2 {
+		t.Errorf("move event received while paused has been processed, want it dropped")
+	}
+}
+
+func TestGame_advanceClock(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.cfg.TimeFactor = 60
+	g.state.gameTime = minutesPerDay - 1
+
+	// the game time follows the logic time, a minute lasting a second here,
+	// and wraps around at midnight
+	for i := 0; i < 250; i++ {
+		g.logicTick(10 * time.Millisecond)
+	}
+	if g.state.gameTime != 1 {
+		t.Errorf("game time = %d after 2.5s, want 1", g.state.gameTime)
+	}
+}
+
+func TestGame_AlignedSendTicks(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.cfg.SendTickPeriod, g.cfg.LogicTickPeriod = 90, 30
+	if g.alignedSendDue() {
+		t.Errorf("send tick due while not aligned")
+	}
+
+	g.cfg.AlignSendTicks = true
+	var due []bool
+	for i := 0; i < 7; i++ {
+		due = append(due, g.alignedSendDue())
+	}
+	want := []bool{false, false, true, false, false, true, false}
+	for i := range want {
+		if due[i] != want[i] {
+			t.Fatalf("send ticks due after logic ticks %v, want %v", due, want)
+		}
+	}
+}
+
+func TestGame_ViewRadius(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.cfg.ViewRadius = 5
+	g.clients.SetMaxViewRadius(g.cfg.ViewRadius)
+	g.server = protocol.NewServer("0", g.clients, nil, &g.wg, g.clients)
+	g.registerServerCallbacks()
+	g.server.Start()
+	defer func() {
+		g.server.Stop()
+		g.wg.Wait()
+	}()
+
+	join := func(name string, radius float32, pos d2.Vec2) (net.Conn, *Player, messages.Stay) {
+		conn, stay := testJoinWith(t, g, messages.Join{
+			Name:       name,
+			Type:       uint8(TankEntity),
+			Version:    protocol.MaxProtocolVersion,
+			ViewRadius: radius,
+		})
+		for i := 0; i < 100 && g.state.getPlayer(stay.Id) == nil; i++ {
+			g.eventManager.Process()
+			time.Sleep(10 * time.Millisecond)
+		}
+		p := g.state.getPlayer(stay.Id)
+		if p == nil {
+			t.Fatalf("player %s hasn't joined", name)
+		}
+		teleport(g, p, pos)
+		return conn, p, stay
+	}
+	// the far-sighted player asks more than the server allows
+	nearConn, near, nearStay := join("near", 2, d2.Vec2{1.5, 1.5})
+	defer nearConn.Close()
+	farConn, far, farStay := join("far", 10, d2.Vec2{7.5, 3.5})
+	defer farConn.Close()
+	if nearStay.ViewRadius != 2 || farStay.ViewRadius != 5 {
+		t.Errorf("negotiated view radii = %v and %v, want 2 and 5", nearStay.ViewRadius, farStay.ViewRadius)
+	}
+
+	z1 := addTestZombie(g, d2.Vec2{2.5, 1.5})
+	z2 := addTestZombie(g, d2.Vec2{5.5, 2.5})
+	z3 := addTestZombie(g, d2.Vec2{4.5, 3.5})
+	g.sendGameState()
+
+	tests := []struct {
+		name string
+		conn net.Conn
+		want []uint32
+	}{
+		{"near", nearConn, []uint32{near.Id(), z1.Id()}},
+		{"far", farConn, []uint32{far.Id(), z2.Id(), z3.Id()}},
+	}
+	for _, tt := range tests {
+		msg := readUntil(tt.conn, time.Second, func(msg *messages.Message) bool {
+			return msg.Type == messages.GameStateId
+		})
+		if msg == nil {
+			t.Fatalf("%s: no game state received", tt.name)
+		}
+		var gs messages.GameState
+		messages.Decode(msg, &gs)
+		if len(gs.Entities) != len(tt.want) {
+			t.Errorf("%s: received %d entities, want %d", tt.name, len(gs.Entities), len(tt.want))
+		}
+		for _, id := range tt.want {
+			if _, ok := gs.Entities[id]; !ok {
+				t.Errorf("%s: entity %d within the view radius hasn't been received", tt.name, id)
+			}
+		}
+	}
+}
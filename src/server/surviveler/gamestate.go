@@ -12,29 +12,59 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
 )
 
 // translation from topleft of tile to its center
 var txCenter d2.Vec2
 
+// ChatAreaRadius is the distance at which area chat messages are heard
+const ChatAreaRadius = 15
+
 type EntityFilter func(e Entity) bool
 
+/*
+ * Hostile indicates if an entity of faction a can attack an entity of
+ * faction b.
+ *
+ * Neutral entities are never targeted through their faction, zombies attack
+ * buildings on their own. Players of the same faction can only hurt each
+ * other if friendly fire is enabled.
+ */
+func (gs *GameState) Hostile(a, b Faction) bool {
+	switch {
+	case a == NeutralFaction || b == NeutralFaction:
+		return false
+	case a == b:
+		return a != ZombieFaction && gs.game.cfg.FriendlyFire
+	}
+	return true
+}
+
 /*
  * gamestate is the structure that contains all the complete game state
  */
 type GameState struct {
-	gameData    *gameData         // game constants/resources coming from assets
-	gameTime    int16             // current time in-game
-	entities    map[uint32]Entity // entities currently in game
-	numEntities uint32            // number of entities currently present in the game
-	game        *Game
-	world       *World
+	gameData  *gameData         // game constants/resources coming from assets
+	gameTime  int16             // current time in-game
+	clock     time.Duration     // simulated time elapsed since the game start
+	entities  map[uint32]Entity // entities currently in game
+	order     []uint32          // ids of the entities in game, sorted, see forEachEntity
+	ids       *IDAllocator      // entity ids allocator
+	nextSpawn int               // index of the next player spawn point to use
+	game      *Game
+	world     *World
+	lifecycle []*messages.Message // spawns and despawns, sent before the next game state
+
+	spawnHooks  entityHooks // called when entities are added, see OnSpawn
+	removeHooks entityHooks // called when entities are removed, see OnRemove
 }
 
 func newGameState(g *Game, gameStart int16) *GameState {
 	gs := new(GameState)
 	gs.game = g
 	gs.entities = make(map[uint32]Entity)
+	gs.ids = NewIDAllocator()
 	gs.gameTime = gameStart
 	return gs
 }
@@ -55,6 +85,9 @@ func (gs *GameState) init(gameData *gameData) error {
 			gs.AddEntity(obj)
 		}
 	}
+	for _, itemdata := range gs.gameData.mapData.Items {
+		gs.AddEntity(NewItem(itemdata.Pos, EntityType(itemdata.Type), itemdata.Quantity))
+	}
 
 	// precompute constant, translation from corner to center of tile
 	txCenter = d2.Vec2{1, 1}.Scale(1 / (2 * gs.world.GridScale))
@@ -69,31 +102,136 @@ func (gs *GameState) pack() *messages.GameState {
 	gsMsg := new(messages.GameState)
 	gsMsg.Tstamp = time.Now().UnixNano() / int64(time.Millisecond)
 	gsMsg.Time = gs.gameTime
+	gsMsg.Version = messages.StateSchemaVersion
 
 	// to ease client reception, we separate mobile entities and buildings
-	gsMsg.Entities = make(map[uint32]interface{})
-	gsMsg.Buildings = make(map[uint32]interface{})
-	gsMsg.Objects = make(map[uint32]interface{})
+	gsMsg.Entities = make(map[uint32]messages.MobileEntityState)
+	gsMsg.Buildings = make(map[uint32]messages.BuildingState)
+	gsMsg.Objects = make(map[uint32]messages.ObjectState)
+	gsMsg.Projectiles = make(map[uint32]messages.ProjectileState)
+	gsMsg.Items = make(map[uint32]messages.ItemState)
 
+	// the state type decides of the wire schema
 	for id, ent := range gs.entities {
-		switch ent.(type) {
-		case Object:
-			gsMsg.Objects[id] = ent.State()
-		case Building:
-			gsMsg.Buildings[id] = ent.State()
+		switch state := ent.State().(type) {
+		case ObjectState:
+			gsMsg.Objects[id] = state.pack()
+		case BuildingState:
+			gsMsg.Buildings[id] = state.pack()
+		case ProjectileState:
+			gsMsg.Projectiles[id] = state.pack()
+		case ItemState:
+			gsMsg.Items[id] = state.pack()
+		case MobileEntityState:
+			gsMsg.Entities[id] = state.pack()
+		case PlayerState:
+			gsMsg.Entities[id] = state.pack()
 		default:
-			gsMsg.Entities[id] = ent.State()
+			log.WithField("id", id).Errorf("Can't pack entity state of type %T", state)
 		}
 	}
 	return gsMsg
 }
 
 /*
- * allocates a new entity identifier.
+ * filterState returns the part of the packed game state full made of the
+ * entities lying within radius of center
+ */
+func (gs *GameState) filterState(full *messages.GameState, center d2.Vec2, radius float32) *messages.GameState {
+	in := func(id uint32) bool {
+		ent, ok := gs.entities[id]
+		return ok && ent.Position().Sub(center).Len() <= radius
+	}
+	view := *full
+	view.Entities = make(map[uint32]messages.MobileEntityState)
+	for id, state := range full.Entities {
+		if in(id) {
+			view.Entities[id] = state
+		}
+	}
+	view.Buildings = make(map[uint32]messages.BuildingState)
+	for id, state := range full.Buildings {
+		if in(id) {
+			view.Buildings[id] = state
+		}
+	}
+	view.Objects = make(map[uint32]messages.ObjectState)
+	for id, state := range full.Objects {
+		if in(id) {
+			view.Objects[id] = state
+		}
+	}
+	view.Projectiles = make(map[uint32]messages.ProjectileState)
+	for id, state := range full.Projectiles {
+		if in(id) {
+			view.Projectiles[id] = state
+		}
+	}
+	view.Items = make(map[uint32]messages.ItemState)
+	for id, state := range full.Items {
+		if in(id) {
+			view.Items[id] = state
+		}
+	}
+	return &view
+}
+
+/*
+ * allocates a new entity identifier, that has never been used.
+ *
+ * This is safe to call from other goroutines than the game loop, i.e for the
+ * connecting clients. Entities added without an identifier get one that may
+ * have been recycled.
  */
 func (gs *GameState) allocEntityId() uint32 {
-	gs.numEntities++
-	return gs.numEntities
+	return gs.ids.AllocFresh()
+}
+
+/*
+ * playerSpawnPoint returns the position at which a new player should spawn.
+ *
+ * Player spawn points are used in turn, skipping the ones currently occupied
+ * by another entity. If they are all occupied, the next one is used anyway.
+ * It returns false if the map has no player spawn point.
+ */
+func (gs *GameState) playerSpawnPoint() (d2.Vec2, bool) {
+	spawns := gs.gameData.mapData.AIKeypoints.Spawn.Players
+	if len(spawns) == 0 {
+		return nil, false
+	}
+	idx := gs.nextSpawn
+	for i := range spawns {
+		org := spawns[(gs.nextSpawn+i)%len(spawns)]
+		if gs.world.AABBSpatialQuery(d2.RectFromCircle(org, DefaultEntityRadius)).Len() == 0 {
+			idx = (gs.nextSpawn + i) % len(spawns)
+			break
+		}
+	}
+	gs.nextSpawn = (idx + 1) % len(spawns)
+	return spawns[idx], true
+}
+
+/*
+ * playerFaction returns the faction of a new player: the player faction
+ * having the fewest players, the first one on a tie
+ */
+func (gs *GameState) playerFaction() Faction {
+	counts := make([]int, gs.game.cfg.PlayerFactions)
+	gs.forEachEntity(func(ent Entity) bool {
+		if p, ok := ent.(*Player); ok {
+			if i := int(p.faction - PlayerFaction); i >= 0 && i < len(counts) {
+				counts[i]++
+			}
+		}
+		return true
+	})
+	var best int
+	for i := range counts {
+		if counts[i] < counts[best] {
+			best = i
+		}
+	}
+	return PlayerFaction + Faction(best)
 }
 
 func (gs *GameState) World() *World {
@@ -111,39 +249,156 @@ func (gs *GameState) Entity(id uint32) Entity {
  * AddEntity adds an entity to the game state.
  *
  * It entity Id is InvalidID, an unique id is generated and assigned
- * to the entity
+ * to the entity. The OnSpawn hooks are called if the entity wasn't already
+ * in game.
  */
 func (gs *GameState) AddEntity(ent Entity) {
 	id := ent.Id()
 	if id == InvalidID {
-		id = gs.allocEntityId()
+		id = gs.ids.Alloc()
 		ent.SetId(id)
 	}
+	_, exists := gs.entities[id]
+	if !exists {
+		i := gs.orderIndex(id)
+		gs.order = append(gs.order, 0)
+		copy(gs.order[i+1:], gs.order[i:])
+		gs.order[i] = id
+	}
 	gs.entities[id] = ent
 
 	// add the entity onto the world representation
 	gs.world.AttachEntity(ent)
+
+	if exists {
+		// already spawned
+		return
+	}
+	if kind, ok := entityKind(ent); ok {
+		pos := ent.Position()
+		gs.lifecycle = append(gs.lifecycle, messages.New(messages.EntitySpawnedId, messages.EntitySpawned{
+			Id:   id,
+			Kind: kind,
+			Type: uint8(ent.Type()),
+			Xpos: pos[0],
+			Ypos: pos[1],
+		}))
+	}
+	gs.spawnHooks.fire(ent)
+}
+
+/*
+ * forEachEntity calls f on every entity in game, by increasing id, until f
+ * returns false.
+ *
+ * Map iteration order being random, iterating over the entities in a known
+ * order keeps the simulation reproducible, for replays and tests. f mustn't
+ * add or remove entities, see entityIDs.
+ */
+func (gs *GameState) forEachEntity(f func(Entity) bool) {
+	for _, id := range gs.order {
+		if !f(gs.entities[id]) {
+			return
+		}
+	}
 }
 
 /*
- * RemoveEntity removes an entity from the game state
+ * orderIndex returns the index at which id is, or should be inserted, in the
+ * sorted entity ids
+ */
+func (gs *GameState) orderIndex(id uint32) int {
+	return sort.Search(len(gs.order), func(i int) bool { return gs.order[i] >= id })
+}
+
+/*
+ * entityIDs appends to ids the ids of the entities in game, by increasing id,
+ * and returns the extended slice
+ */
+func (gs *GameState) entityIDs(ids []uint32) []uint32 {
+	return append(ids, gs.order...)
+}
+
+/*
+ * atEntityCap indicates if the game holds the max number of entities allowed
+ * by the configuration
+ */
+func (gs *GameState) atEntityCap() bool {
+	return gs.game.cfg.MaxEntities > 0 && len(gs.entities) >= gs.game.cfg.MaxEntities
+}
+
+/*
+ * RemoveEntity removes an entity from the game state, and calls the OnRemove
+ * hooks. It's a no-op if the entity isn't in game.
  */
 func (gs *GameState) RemoveEntity(id uint32) {
-	gs.world.DetachEntity(gs.entities[id])
+	ent, ok := gs.entities[id]
+	if !ok {
+		return
+	}
+	gs.world.DetachEntity(ent)
 	delete(gs.entities, id)
+	if i := gs.orderIndex(id); i < len(gs.order) && gs.order[i] == id {
+		gs.order = append(gs.order[:i], gs.order[i+1:]...)
+	}
+	gs.ids.Free(id)
+
+	if kind, known := entityKind(ent); known {
+		gs.lifecycle = append(gs.lifecycle, messages.New(messages.EntityDespawnedId, messages.EntityDespawned{
+			Id:   id,
+			Kind: kind,
+		}))
+	}
+	gs.removeHooks.fire(ent)
+}
+
+/*
+ * entityKind returns the kind of an entity, that is in which map of the game
+ * state message it's sent
+ */
+func entityKind(ent Entity) (uint8, bool) {
+	switch ent.(type) {
+	case *Player, *Zombie:
+		return messages.MobileEntityKind, true
+	case Building:
+		return messages.BuildingKind, true
+	case Object:
+		return messages.ObjectKind, true
+	case *Projectile, *Grenade:
+		return messages.ProjectileKind, true
+	case *Item:
+		return messages.ItemKind, true
+	}
+	return 0, false
+}
+
+/*
+ * takeLifecycle returns the spawn and despawn messages of the entities that
+ * entered or left the world since the last call, in order
+ */
+func (gs *GameState) takeLifecycle() []*messages.Message {
+	msgs := gs.lifecycle
+	gs.lifecycle = nil
+	return msgs
 }
 
 func (gs *GameState) createBuilding(t EntityType, pos d2.Vec2) Building {
 	var building Building
-	switch t {
-	case BarricadeBuilding:
-		data := gs.BuildingData(t)
-		building = NewBarricade(gs.game, pos, data.TotHp, data.BuildingPowerRec)
-	case MgTurretBuilding:
-		data := gs.BuildingData(t)
-		building = NewMgTurret(gs.game, pos, data.TotHp, data.BuildingPowerRec)
+	data := gs.BuildingData(t)
+	if data == nil {
+		return nil
+	}
+	// the building data decides of the behavior
+	switch data.behavior(t) {
+	case WallBehavior:
+		building = NewWall(gs.game, t, pos, data)
+	case BarricadeBehavior:
+		building = NewBarricade(gs.game, t, pos, data)
+	case TurretBehavior:
+		building = NewMgTurret(gs.game, t, pos, data)
 	default:
-		log.WithField("type", t).Error("Can't create building, unsupported type")
+		log.WithField("type", t).Error("Can't create building, unsupported behavior")
+		return nil
 	}
 	gs.AddEntity(building)
 	return building
@@ -192,20 +447,87 @@ func (c entityDistCollection) Swap(i, j int) {
 	c[i], c[j] = c[j], c[i]
 }
 
-func (gs *GameState) NearestEntity(pos d2.Vec2,
+/*
+ * NearestEntity returns the entity satisfying the filter that is the closest
+ * to pos, and its distance, or nil if none has been found.
+ *
+ * Only entities lying within radius are considered, a radius of 0 meaning no
+ * distance limit.
+ */
+func (gs *GameState) NearestEntity(pos d2.Vec2, radius float32,
 	f EntityFilter) (Entity, float32) {
+	var (
+		nearest Entity
+		minDist float32
+	)
+	for _, ent := range gs.entitiesInRadius(pos, radius, f) {
+		if nearest == nil || ent.d < minDist {
+			nearest, minDist = ent.e, ent.d
+		}
+	}
+	return nearest, minDist
+}
+
+/*
+ * KNearestEntities returns, sorted by distance, the k entities satisfying the
+ * filter that are the closest to pos.
+ *
+ * Only entities lying within radius are considered, a radius of 0 meaning no
+ * distance limit.
+ */
+func (gs *GameState) KNearestEntities(pos d2.Vec2, k int, radius float32,
+	f EntityFilter) []Entity {
+	result := gs.entitiesInRadius(pos, radius, f)
+	sort.Sort(result)
+	if len(result) > k {
+		result = result[:k]
+	}
+	ents := make([]Entity, len(result))
+	for i := range result {
+		ents[i] = result[i].e
+	}
+	return ents
+}
+
+/*
+ * entitiesInRadius returns the entities satisfying the filter that lie within
+ * radius of pos, along with their distances.
+ *
+ * With a radius, only the entities found by a spatial query on the circle
+ * are considered, otherwise all of them are.
+ */
+func (gs *GameState) entitiesInRadius(pos d2.Vec2, radius float32,
+	f EntityFilter) entityDistCollection {
 	result := make(entityDistCollection, 0)
-	for _, ent := range gs.entities {
+	// the far entities are pruned before calling the filter
+	visit := func(ent Entity, sqDist float32) bool {
 		if f(ent) {
-			result = append(result, entityDist{
-				d: float32(ent.Position().Sub(pos).Len()),
-				e: ent,
-			})
+			result = append(result, entityDist{d: math32.Sqrt(sqDist), e: ent})
 		}
+		return true
+	}
+	if radius > 0 {
+		gs.world.circleQuery(pos, radius, visit)
+	} else {
+		gs.forEachEntity(func(ent Entity) bool {
+			return visit(ent, ent.Position().Sub(pos).LenSqr())
+		})
+	}
+	return result
+}
+
+/*
+ * chatAudience returns the ids of the players hearing the area chat messages
+ * of a player, the sender included
+ */
+func (gs *GameState) chatAudience(sender *Player) []uint32 {
+	isPlayer := func(e Entity) bool {
+		_, ok := e.(*Player)
+		return ok
 	}
-	if len(result) > 0 {
-		sort.Sort(result)
-		return result[0].e, result[0].d
+	var ids []uint32
+	for _, ent := range gs.entitiesInRadius(sender.Position(), ChatAreaRadius, isPlayer) {
+		ids = append(ids, ent.e.Id())
 	}
-	return nil, 0
+	return ids
 }
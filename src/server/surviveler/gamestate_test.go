@@ -0,0 +1,313 @@
+package surviveler
+
+import (
+	"reflect"
+	"server/messages"
+	"server/protocol"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func isZombie(e Entity) bool {
+	_, ok := e.(*Zombie)
+	return ok
+}
+
+func TestGameState_NearestEntity(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	pos := d2.Vec2{1.5, 1.5}
+	addTestPlayer(g, TankEntity, d2.Vec2{2.5, 1.5})
+	far := addTestZombie(g, d2.Vec2{7.5, 3.5})
+	near := addTestZombie(g, d2.Vec2{4.5, 1.5})
+
+	tests := []struct {
+		name     string
+		radius   float32
+		want     Entity
+		wantDist float32
+	}{
+		{"unbounded", 0, near, 3},
+		{"in radius", 3, near, 3},
+		{"out of radius", 2.5, nil, 0},
+	}
+	for _, tt := range tests {
+		ent, dist := g.state.NearestEntity(pos, tt.radius, isZombie)
+		if ent != tt.want || dist != tt.wantDist {
+			t.Errorf("%s: NearestEntity() = %v, %v, want %v, %v", tt.name, ent, dist, tt.want, tt.wantDist)
+		}
+	}
+
+	g.state.RemoveEntity(near.Id())
+	if ent, _ := g.state.NearestEntity(pos, 0, isZombie); ent != far {
+		t.Errorf("NearestEntity() = %v, want %v", ent, far)
+	}
+}
+
+func TestGameState_KNearestEntities(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	pos := d2.Vec2{1.5, 1.5}
+	z3 := addTestZombie(g, d2.Vec2{7.5, 1.5})
+	z1 := addTestZombie(g, d2.Vec2{2.5, 1.5})
+	addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	z2 := addTestZombie(g, d2.Vec2{4.5, 2.5})
+
+	tests := []struct {
+		name   string
+		k      int
+		radius float32
+		want   []Entity
+	}{
+		{"all", 5, 0, []Entity{z1, z2, z3}},
+		{"k nearest", 2, 0, []Entity{z1, z2}},
+		{"in radius", 5, 4, []Entity{z1, z2}},
+		{"k nearest in radius", 1, 4, []Entity{z1}},
+		{"none in radius", 5, 0.5, []Entity{}},
+	}
+	for _, tt := range tests {
+		got := g.state.KNearestEntities(pos, tt.k, tt.radius, isZombie)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: KNearestEntities() = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: KNearestEntities() = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestGameState_ChatAudience(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	sender := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	near := addTestPlayer(g, TankEntity, d2.Vec2{10.5, 2.5})
+	addTestPlayer(g, TankEntity, d2.Vec2{17.5, 2.5})
+	addTestZombie(g, d2.Vec2{2.5, 2.5})
+
+	got := make(map[uint32]bool)
+	for _, id := range g.state.chatAudience(sender) {
+		got[id] = true
+	}
+	if len(got) != 2 || !got[sender.Id()] || !got[near.Id()] {
+		t.Errorf("chatAudience() = %v, want the sender %d and the near player %d", got, sender.Id(), near.Id())
+	}
+}
+
+func TestGameState_Hostile(t *testing.T) {
+	const otherFaction = PlayerFaction + 1
+	tests := []struct {
+		a, b         Faction
+		friendlyFire bool
+		want         bool
+	}{
+		{ZombieFaction, PlayerFaction, false, true},
+		{ZombieFaction, otherFaction, false, true},
+		{PlayerFaction, ZombieFaction, false, true},
+		{ZombieFaction, ZombieFaction, true, false},
+		{PlayerFaction, otherFaction, false, true},
+		{PlayerFaction, PlayerFaction, false, false},
+		{PlayerFaction, PlayerFaction, true, true},
+		{ZombieFaction, NeutralFaction, true, false},
+		{PlayerFaction, NeutralFaction, true, false},
+	}
+	g := newTestGame(t, openRoom...)
+	for _, tt := range tests {
+		g.cfg.FriendlyFire = tt.friendlyFire
+		if got := g.state.Hostile(tt.a, tt.b); got != tt.want {
+			t.Errorf("Hostile(%v, %v) with friendly fire %v = %v, want %v", tt.a, tt.b, tt.friendlyFire, got, tt.want)
+		}
+	}
+}
+
+func TestZombie_TargetsEveryPlayerFaction(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	z := addTestZombie(g, d2.Vec2{1.5, 1.5})
+	addTestZombie(g, d2.Vec2{2.5, 1.5})
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{1.5, 3.5})
+	p1 := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 1.5})
+	p2 := addTestPlayer(g, TankEntity, d2.Vec2{4.5, 1.5})
+	p2.SetFaction(PlayerFaction + 1)
+
+	if got := z.findTarget(); got != p2 {
+		t.Errorf("findTarget() = %v, want the nearest player %v", got, p2)
+	}
+	g.state.RemoveEntity(p2.Id())
+	if got := z.findTarget(); got != p1 {
+		t.Errorf("findTarget() = %v, want the other faction player %v", got, p1)
+	}
+}
+
+func TestGameState_PackSchemas(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{2.5, 1.5})
+	z := addTestZombie(g, d2.Vec2{7.5, 3.5})
+	medkit := NewItem(d2.Vec2{4.5, 2.5}, MedkitItem, 1)
+	g.state.AddEntity(medkit)
+
+	msg := g.state.pack()
+	if msg.Version != messages.StateSchemaVersion {
+		t.Errorf("Version = %v, want %v", msg.Version, messages.StateSchemaVersion)
+	}
+	ps, ok := msg.Entities[p.Id()]
+	if !ok || ps.Type != uint8(TankEntity) || ps.Resources != PlayerStartingResources || ps.Xpos != 2.5 {
+		t.Errorf("packed player = %+v, %v, want a tank with %v resources", ps, ok, PlayerStartingResources)
+	}
+	if zs, ok := msg.Entities[z.Id()]; !ok || zs.Type != uint8(ZombieEntity) || zs.Resources != 0 {
+		t.Errorf("packed zombie = %+v, %v, want a zombie without resources", zs, ok)
+	}
+	if is, ok := msg.Items[medkit.Id()]; !ok || is.Type != uint8(MedkitItem) || is.Quantity != 1 {
+		t.Errorf("packed item = %+v, %v, want a medkit", is, ok)
+	}
+}
+
+func TestGame_EntityLifecycleMessages(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.server = protocol.NewServer("0", g.clients, nil, &g.wg, g.clients)
+	g.registerServerCallbacks()
+	g.server.Start()
+	defer func() {
+		g.server.Stop()
+		g.wg.Wait()
+	}()
+
+	conn, stay := testJoin(t, g, "")
+	defer conn.Close()
+	for i := 0; i < 100 && g.state.getPlayer(stay.Id) == nil; i++ {
+		g.eventManager.Process()
+		time.Sleep(10 * time.Millisecond)
+	}
+	z := addTestZombie(g, d2.Vec2{6.5, 2.5})
+	zid := z.Id()
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 1.5})
+	g.sendGameState()
+	g.state.RemoveEntity(zid)
+	g.sendGameState()
+	g.sendGameState()
+
+	// each spawn and despawn is sent once, before the game state reflecting it
+	spawned, despawned := make(map[uint32]int), make(map[uint32]int)
+	var states int
+	readUntil(conn, 200*time.Millisecond, func(msg *messages.Message) bool {
+		switch msg.Type {
+		case messages.EntitySpawnedId:
+			var m messages.EntitySpawned
+			messages.Decode(msg, &m)
+			if states != 0 {
+				t.Errorf("entity %d spawn sent after %d game states", m.Id, states)
+			}
+			if m.Id == zid && (m.Kind != messages.MobileEntityKind || m.Type != uint8(ZombieEntity) || m.Xpos != 6.5) {
+				t.Errorf("zombie spawn = %+v", m)
+			}
+			if m.Id == b.Id() && m.Kind != messages.BuildingKind {
+				t.Errorf("building spawn = %+v, want kind %d", m, messages.BuildingKind)
+			}
+			spawned[m.Id]++
+		case messages.EntityDespawnedId:
+			var m messages.EntityDespawned
+			messages.Decode(msg, &m)
+			if states != 1 {
+				t.Errorf("entity %d despawn sent after %d game states, want 1", m.Id, states)
+			}
+			despawned[m.Id]++
+		case messages.GameStateId:
+			states++
+		}
+		return false
+	})
+	if states != 3 {
+		t.Fatalf("%d game states received, want 3", states)
+	}
+	for _, id := range []uint32{stay.Id, zid, b.Id()} {
+		if spawned[id] != 1 {
+			t.Errorf("entity %d spawn sent %d times, want once", id, spawned[id])
+		}
+	}
+	if len(despawned) != 1 || despawned[zid] != 1 {
+		t.Errorf("despawns sent = %v, want the zombie once", despawned)
+	}
+}
+
+func TestGameState_AddEntityTwice(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	var hooked int
+	g.state.OnSpawn(messages.MobileEntityKind, ZombieEntity, func(Entity) { hooked++ })
+	z := addTestZombie(g, d2.Vec2{2.5, 2.5})
+
+	// re-adding a live entity doesn't spawn it again
+	g.state.AddEntity(z)
+	var spawns int
+	for _, msg := range g.state.takeLifecycle() {
+		if msg.Type == messages.EntitySpawnedId {
+			spawns++
+		}
+	}
+	if spawns != 1 || hooked != 1 {
+		t.Errorf("%d spawn messages, %d spawn hook calls, want 1 of each", spawns, hooked)
+	}
+	if n := len(g.state.order); n != 1 {
+		t.Errorf("%d entities in the update order, want 1", n)
+	}
+}
+
+func TestGameState_EntityOrder(t *testing.T) {
+	// ids in the order the entities are visited, after adding and removing
+	// entities in an arbitrary order
+	visit := func() []uint32 {
+		g := newTestGame(t, openRoom...)
+		for _, id := range []uint32{1000, 500, 750, 250, 1500} {
+			z := addTestZombie(g, d2.Vec2{2.5, 2.5})
+			g.state.RemoveEntity(z.Id())
+			z.SetId(id)
+			g.state.AddEntity(z)
+		}
+		g.state.RemoveEntity(750)
+		g.state.RemoveEntity(1500)
+		g.state.AddEntity(g.state.Entity(500)) // adding twice doesn't duplicate
+		addTestZombie(g, d2.Vec2{3.5, 2.5})
+
+		var ids []uint32
+		g.state.forEachEntity(func(ent Entity) bool {
+			ids = append(ids, ent.Id())
+			return true
+		})
+		if len(ids) != len(g.state.entities) {
+			t.Fatalf("%d entities visited, want %d", len(ids), len(g.state.entities))
+		}
+		for i, id := range ids {
+			if _, ok := g.state.entities[id]; !ok {
+				t.Errorf("entity %d visited but not in game", id)
+			}
+			if i > 0 && ids[i-1] >= id {
+				t.Errorf("entities visited out of order: %v", ids)
+				break
+			}
+		}
+		if got := g.state.entityIDs(nil); !reflect.DeepEqual(got, ids) {
+			t.Errorf("entityIDs() = %v, want %v", got, ids)
+		}
+		return ids
+	}
+
+	first := visit()
+	for i := 0; i < 5; i++ {
+		if ids := visit(); !reflect.DeepEqual(ids, first) {
+			t.Fatalf("entities visited in order %v, then %v", first, ids)
+		}
+	}
+
+	// visiting stops as soon as asked
+	g := newTestGame(t, openRoom...)
+	addTestZombie(g, d2.Vec2{2.5, 2.5})
+	addTestZombie(g, d2.Vec2{3.5, 2.5})
+	var n int
+	g.state.forEachEntity(func(Entity) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("%d entities visited, want 1", n)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+ * Surviveler package
+ * binary cache of the world grid
+ */
+package surviveler
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// identifies the grid cache files, and the version of their format
+var gridCacheMagic = [4]byte{'S', 'V', 'G', 'C'}
+
+const gridCacheVersion = 1
+
+// size of a cached tile: its kind on a byte, then its cost
+const gridCacheTileSize = 5
+
+var errStaleGridCache = errors.New("grid cache is stale")
+
+/*
+ * gridCacheKey is the hash of the sources a world grid is built from
+ */
+type gridCacheKey [sha256.Size]byte
+
+/*
+ * gridCacheHeader is written at the beginning of a grid cache file, before
+ * the tiles, row after row
+ */
+type gridCacheHeader struct {
+	Magic      [4]byte
+	Version    uint32
+	Key        gridCacheKey
+	GridWidth  uint32
+	GridHeight uint32
+	GridScale  float32
+}
+
+/*
+ * newGridCacheKey hashes the sources of a world grid: the map bitmaps, the
+ * map scale factor and the grid scale. A cached grid is only valid for the
+ * key it has been written with.
+ */
+func newGridCacheKey(mapScale, gridScale float32, sources ...[]byte) gridCacheKey {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, [2]float32{mapScale, gridScale})
+	for _, src := range sources {
+		// prefix each source with its length, so they can't be mixed up
+		binary.Write(h, binary.LittleEndian, uint64(len(src)))
+		h.Write(src)
+	}
+	var key gridCacheKey
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+/*
+ * gridCachePath returns the path of the cache file, in dir, of the grid built
+ * from the map bitmap at uri
+ */
+func gridCachePath(dir, uri string) string {
+	name := path.Base(uri)
+	return filepath.Join(dir, strings.TrimSuffix(name, path.Ext(name))+".grid")
+}
+
+/*
+ * writeGridCache writes the kinds and costs of the tiles of w
+ */
+func writeGridCache(wr io.Writer, w *World, key gridCacheKey) error {
+	bw := bufio.NewWriter(wr)
+	hdr := gridCacheHeader{
+		Magic:      gridCacheMagic,
+		Version:    gridCacheVersion,
+		Key:        key,
+		GridWidth:  uint32(w.GridWidth),
+		GridHeight: uint32(w.GridHeight),
+		GridScale:  w.GridScale,
+	}
+	if err := binary.Write(bw, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	buf := make([]byte, gridCacheTileSize*len(w.Grid))
+	for i := range w.Grid {
+		b := buf[i*gridCacheTileSize:]
+		b[0] = byte(w.Grid[i].Kind)
+		binary.LittleEndian.PutUint32(b[1:], math.Float32bits(w.Grid[i].Cost))
+	}
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+/*
+ * readGridCache reads a cached grid, and returns the world made of it. It
+ * returns errStaleGridCache if the grid has been cached with another key, or
+ * in another format.
+ */
+func readGridCache(r io.Reader, key gridCacheKey) (*World, error) {
+	br := bufio.NewReader(r)
+	var hdr gridCacheHeader
+	if err := binary.Read(br, binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr.Magic != gridCacheMagic || hdr.Version != gridCacheVersion || hdr.Key != key {
+		return nil, errStaleGridCache
+	}
+	if hdr.GridWidth == 0 || hdr.GridHeight == 0 || hdr.GridScale <= 0 {
+		return nil, errors.New("grid cache has an empty grid")
+	}
+
+	w := newWorld(int(hdr.GridWidth), int(hdr.GridHeight), hdr.GridScale)
+	buf := make([]byte, gridCacheTileSize*len(w.Grid))
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, err
+	}
+	for i := range w.Grid {
+		b := buf[i*gridCacheTileSize:]
+		w.Grid[i] = NewTile(TileKind(b[0]), w, i%w.GridWidth, i/w.GridWidth)
+		w.Grid[i].Cost = math.Float32frombits(binary.LittleEndian.Uint32(b[1:]))
+	}
+	w.regions = newRegions(w)
+	return w, nil
+}
+
+/*
+ * loadGridCache returns the world cached at fname for key, or nil if there's
+ * no valid cached grid
+ */
+func loadGridCache(fname string, key gridCacheKey) *World {
+	f, err := os.Open(fname)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithError(err).Warn("Couldn't open the grid cache")
+		}
+		return nil
+	}
+	defer f.Close()
+
+	w, err := readGridCache(f, key)
+	if err != nil {
+		log.WithError(err).WithField("cache", fname).Info("Rebuilding the grid cache")
+		return nil
+	}
+	log.WithField("cache", fname).Info("World grid loaded from cache")
+	return w
+}
+
+/*
+ * saveGridCache caches the grid of w at fname for key. The file is written
+ * aside then renamed, so that a concurrent load never reads half a grid.
+ */
+func saveGridCache(fname string, w *World, key gridCacheKey) error {
+	dir := filepath.Dir(fname)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, filepath.Base(fname))
+	if err != nil {
+		return err
+	}
+	if err = writeGridCache(f, w, key); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fname)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
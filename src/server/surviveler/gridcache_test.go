@@ -0,0 +1,145 @@
+package surviveler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"server/resource"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+/*
+ * sameGrid fails on the first tile that differs between 2 worlds
+ */
+func sameGrid(t *testing.T, got, want *World) {
+	if got.GridWidth != want.GridWidth || got.GridHeight != want.GridHeight || got.GridScale != want.GridScale {
+		t.Fatalf("grid is %dx%d at scale %v, want %dx%d at scale %v", got.GridWidth, got.GridHeight,
+			got.GridScale, want.GridWidth, want.GridHeight, want.GridScale)
+	}
+	for i := range want.Grid {
+		g, w := &got.Grid[i], &want.Grid[i]
+		if g.X != w.X || g.Y != w.Y || g.Kind != w.Kind || g.Cost != w.Cost || g.W != got {
+			t.Fatalf("tile %d = (%d, %d) kind %v cost %v, want (%d, %d) kind %v cost %v",
+				i, g.X, g.Y, g.Kind, g.Cost, w.X, w.Y, w.Kind, w.Cost)
+		}
+	}
+	if got.Regions().Len() != want.Regions().Len() {
+		t.Errorf("%d regions, want %d", got.Regions().Len(), want.Regions().Len())
+	}
+}
+
+func TestGridCache_RoundTrip(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	w := g.state.World()
+	w.Tile(3, 2).Cost = 2.5
+	key := newGridCacheKey(1, 1, []byte("map"))
+
+	var buf bytes.Buffer
+	if err := writeGridCache(&buf, w, key); err != nil {
+		t.Fatalf("writeGridCache() error = %v", err)
+	}
+	cached := buf.Bytes()
+	got, err := readGridCache(bytes.NewReader(cached), key)
+	if err != nil {
+		t.Fatalf("readGridCache() error = %v", err)
+	}
+	sameGrid(t, got, w)
+
+	other := newGridCacheKey(1, 2, []byte("map"))
+	if _, err := readGridCache(bytes.NewReader(cached), other); err != errStaleGridCache {
+		t.Errorf("readGridCache() with another key error = %v, want %v", err, errStaleGridCache)
+	}
+	if _, err := readGridCache(bytes.NewReader(cached[:len(cached)-1]), key); err == nil {
+		t.Errorf("readGridCache() of a truncated cache should fail")
+	}
+}
+
+func TestNewGameData_GridCache(t *testing.T) {
+	assets, cleanup := copyTestAssets(t)
+	defer cleanup()
+	cacheDir, err := ioutil.TempDir("", "surviveler-grid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	pkg, err := resource.OpenFSPackage(assets)
+	if err != nil {
+		t.Fatalf("OpenFSPackage(%v) error = %v", assets, err)
+	}
+	load := func(cacheDir string) *World {
+		gd, err := newGameData(pkg, 2, cacheDir)
+		if err != nil {
+			t.Fatalf("newGameData() error = %v", err)
+		}
+		return gd.world
+	}
+
+	// the grid built on the first load is cached, and reloaded as is
+	fresh := load("")
+	sameGrid(t, load(cacheDir), fresh)
+	cacheFile := gridCachePath(cacheDir, "map/matrix.bmp")
+	if _, err := os.Stat(cacheFile); err != nil {
+		t.Fatalf("grid hasn't been cached: %v", err)
+	}
+	sameGrid(t, load(cacheDir), fresh)
+
+	// the grid is indeed read from the cache
+	f, err := os.Open(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr gridCacheHeader
+	err = binary.Read(f, binary.LittleEndian, &hdr)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := fresh.emptyCopy()
+	tampered.Tile(0, 0).Cost = 7
+	if err := saveGridCache(cacheFile, tampered, hdr.Key); err != nil {
+		t.Fatalf("saveGridCache() error = %v", err)
+	}
+	if c := load(cacheDir).Tile(0, 0).Cost; c != 7 {
+		t.Errorf("tile cost = %v, want the cached cost 7", c)
+	}
+
+	// changing the map invalidates the cache
+	matrix := filepath.Join(assets, "map", "matrix.bmp")
+	buf, err := ioutil.ReadFile(matrix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := bmp.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := image.NewRGBA(img.Bounds())
+	for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+		for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+			changed.Set(x, y, img.At(x, y))
+		}
+	}
+	changed.Set(0, 0, color.White)
+	var out bytes.Buffer
+	if err := bmp.Encode(&out, changed); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(matrix, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := load(cacheDir)
+	if c := w.Tile(0, 0).Cost; c != DefaultTileCost {
+		t.Errorf("tile cost = %v, want %v once rebuilt", c, DefaultTileCost)
+	}
+	if !w.Tile(0, 0).IsWalkable() || fresh.Tile(0, 0).IsWalkable() {
+		t.Errorf("tile (0, 0) should have turned walkable with the map")
+	}
+	sameGrid(t, load(cacheDir), w)
+}
@@ -0,0 +1,187 @@
+package surviveler
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"server/events"
+	"server/protocol"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+// path of the test assets package
+var testAssets = path.Join("..", "testdata", "assets")
+
+/*
+ * newTestWorld builds a world from an ascii representation of the grid.
+ *
+ * Each string represents a row of the grid, '#' stands for a non-walkable tile
+ * and any other character for a walkable one.
+ */
+func newTestWorld(t testing.TB, scale float32, rows ...string) *World {
+	img := image.NewGray(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				img.SetGray(x, y, color.Gray{0})
+			} else {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	w, err := NewWorld(img, scale)
+	if err != nil {
+		t.Fatalf("NewWorld() error = %v", err)
+	}
+	return w
+}
+
+/*
+ * newTestGame creates a game with a world built from rows (see newTestWorld),
+ * with a grid scale of 1.
+ */
+func newTestGame(t testing.TB, rows ...string) *Game {
+	world := newTestWorld(t, 1, rows...)
+	gd := &gameData{
+		world: world,
+		mapData: &MapData{
+			ScaleFactor: 1,
+			AIKeypoints: AIKeypoints{
+				Spawn: Spawn{
+					Players: VecList{d2.Vec2{1.5, 1.5}},
+					Enemies: VecList{d2.Vec2{1.5, 1.5}},
+				},
+			},
+		},
+		entitiesData: EntityDataDict{
+			TankEntity:       {CombatPower: 10, TotalHP: 100, Speed: 2},
+			ProgrammerEntity: {CombatPower: 5, TotalHP: 80, Speed: 2},
+			EngineerEntity:   {BuildingPower: 20, CombatPower: 5, TotalHP: 80, Speed: 2},
+			ZombieEntity:     {CombatPower: 5, TotalHP: 50, Speed: 1.5},
+		},
+		buildingsData: BuildingDataDict{
+			BarricadeBuilding: {TotHp: 100, BuildingPowerRec: 20},
+			MgTurretBuilding:  {TotHp: 100, BuildingPowerRec: 20},
+		},
+	}
+	return newTestGameFromData(t, gd)
+}
+
+/*
+ * newTestGameFromAssets creates a game with data loaded from the assets
+ * package at dir
+ */
+func newTestGameFromAssets(t testing.TB, dir string) *Game {
+	g := &Game{cfg: NewConfig()}
+	gd, err := g.loadAssets(dir)
+	if err != nil {
+		t.Fatalf("loadAssets(%v) error = %v", dir, err)
+	}
+	return newTestGameFromData(t, gd)
+}
+
+/*
+ * newTestGameFromData creates a game from game data, with all the subsystems
+ * needed to update the game state, but no networking.
+ */
+func newTestGameFromData(t testing.TB, gd *gameData) *Game {
+	g := &Game{cfg: NewConfig(), gameData: gd, rng: NewRNG(1)}
+	g.state = newGameState(g, int16(g.cfg.GameStartingTime))
+	if err := g.state.init(gd); err != nil {
+		t.Fatalf("GameState.init() error = %v", err)
+	}
+	g.eventManager = events.NewManager()
+	g.clients = protocol.NewClientRegistry(g.state.allocEntityId)
+	g.pathfinder = NewPathfinder(g)
+	g.lod = newLODScheduler(g.state)
+	g.ai = NewAIDirector(g, int16(g.cfg.NightStartingTime), int16(g.cfg.NightEndingTime))
+	g.metrics = NewMetrics()
+	g.registerEventHandlers()
+	g.registerEntityHooks()
+	return g
+}
+
+/*
+ * addTestPlayer adds a player of given type at pos
+ */
+func addTestPlayer(g *Game, et EntityType, pos d2.Vec2) *Player {
+	data := g.state.EntityData(et)
+	p := NewPlayer(g, pos, et, data.Speed, float32(data.TotalHP),
+		uint16(data.BuildingPower), uint16(data.CombatPower))
+	applyEntityData(p, data)
+	g.state.AddEntity(p)
+	return p
+}
+
+/*
+ * addTestZombie adds a zombie at pos
+ */
+func addTestZombie(g *Game, pos d2.Vec2) *Zombie {
+	data := g.state.EntityData(ZombieEntity)
+	z := NewZombie(g, pos, data.Speed, data.CombatPower, float32(data.TotalHP))
+	applyEntityData(z, data)
+	g.state.AddEntity(z)
+	return z
+}
+
+/*
+ * teleport instantly moves a player to pos, as the server would
+ */
+func teleport(g *Game, p *Player, pos d2.Vec2) {
+	p.Pos = pos
+	p.guard.Reset(pos)
+	g.state.World().UpdateEntity(p)
+}
+
+/*
+ * tick delivers the searched paths, processes pending events then updates
+ * every entity once
+ */
+func tick(g *Game, dt time.Duration) {
+	g.pathfinder.Deliver()
+	g.eventManager.Process()
+	for _, ent := range g.state.entities {
+		ent.Update(dt)
+	}
+	g.state.recordPositions(dt)
+}
+
+/*
+ * copyTestAssets copies the test assets package into a temporary directory,
+ * that is returned along with a function removing it
+ */
+func copyTestAssets(t testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "surviveler-assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = filepath.Walk(testAssets, func(src string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(testAssets, src)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		buf, err := ioutil.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dst, buf, 0644)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("couldn't copy test assets: %v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
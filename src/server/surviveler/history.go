@@ -0,0 +1,130 @@
+/*
+ * Surviveler package
+ * entity position history, for lag compensation
+ */
+package surviveler
+
+import (
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+const (
+	// MaxLagCompensation is the maximum duration an entity can be rewound by
+	MaxLagCompensation = 500 * time.Millisecond
+	// number of positions kept by a position history, it must cover
+	// MaxLagCompensation at the default logic tick period
+	historyCapacity = 64
+)
+
+/*
+ * positionSample is the position of an entity at a given game clock time
+ */
+type positionSample struct {
+	t   time.Duration
+	pos d2.Vec2
+}
+
+/*
+ * PositionHistory is the component holding the last positions of an entity.
+ *
+ * It is a ring buffer of samples, recorded after each logic tick, that allows
+ * to know where an entity was a short time ago, as seen by a lagging client.
+ */
+type PositionHistory struct {
+	samples []positionSample
+	first   int // index of the oldest sample
+	n       int // number of samples
+}
+
+/*
+ * NewPositionHistory creates an empty position history
+ */
+func NewPositionHistory() *PositionHistory {
+	return &PositionHistory{samples: make([]positionSample, historyCapacity)}
+}
+
+/*
+ * Record adds the position at time t, that must be greater than the time of
+ * the last recorded position. The oldest position is dropped when the
+ * history is full.
+ */
+func (h *PositionHistory) Record(t time.Duration, pos d2.Vec2) {
+	s := positionSample{t: t, pos: d2.Vec2{pos[0], pos[1]}}
+	if h.n < len(h.samples) {
+		h.samples[(h.first+h.n)%len(h.samples)] = s
+		h.n++
+		return
+	}
+	h.samples[h.first] = s
+	h.first = (h.first + 1) % len(h.samples)
+}
+
+/*
+ * sample returns the i-th oldest sample
+ */
+func (h *PositionHistory) sample(i int) positionSample {
+	return h.samples[(h.first+i)%len(h.samples)]
+}
+
+/*
+ * At returns the position at time t, interpolated between the two closest
+ * recorded positions.
+ *
+ * A time outside of the recorded range is clamped to the oldest or to the
+ * latest position. At returns false if no position has been recorded.
+ */
+func (h *PositionHistory) At(t time.Duration) (d2.Vec2, bool) {
+	if h.n == 0 {
+		return nil, false
+	}
+	if oldest := h.sample(0); t <= oldest.t {
+		return oldest.pos, true
+	}
+	for i := 1; i < h.n; i++ {
+		next := h.sample(i)
+		if t > next.t {
+			continue
+		}
+		prev := h.sample(i - 1)
+		ratio := float32(t-prev.t) / float32(next.t-prev.t)
+		return prev.pos.Add(next.pos.Sub(prev.pos).Scale(ratio)), true
+	}
+	return h.sample(h.n - 1).pos, true
+}
+
+/*
+ * recordPositions advances the game clock by dt and records the current
+ * position of the entities having a position history
+ */
+func (gs *GameState) recordPositions(dt time.Duration) {
+	gs.clock += dt
+	gs.forEachEntity(func(ent Entity) bool {
+		var h *PositionHistory
+		if GetComponent(ent, &h) {
+			h.Record(gs.clock, ent.Position())
+		}
+		return true
+	})
+}
+
+/*
+ * rewind returns the position an entity had latency ago, that is the
+ * position a client having this latency saw it at.
+ *
+ * The latency is capped to MaxLagCompensation. The current position is
+ * returned for the entities without position history.
+ */
+func (gs *GameState) rewind(ent Entity, latency time.Duration) d2.Vec2 {
+	if latency > MaxLagCompensation {
+		latency = MaxLagCompensation
+	}
+	var h *PositionHistory
+	if latency > 0 && GetComponent(ent, &h) {
+		if pos, ok := h.At(gs.clock - latency); ok {
+			return pos
+		}
+	}
+	return ent.Position()
+}
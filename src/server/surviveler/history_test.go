@@ -0,0 +1,91 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestPositionHistory_At(t *testing.T) {
+	h := NewPositionHistory()
+	if _, ok := h.At(0); ok {
+		t.Fatalf("At() = true on an empty history")
+	}
+	h.Record(10*time.Millisecond, d2.Vec2{1, 1})
+	h.Record(20*time.Millisecond, d2.Vec2{2, 1})
+	h.Record(30*time.Millisecond, d2.Vec2{2, 3})
+
+	tests := []struct {
+		t    time.Duration
+		want d2.Vec2
+	}{
+		{0, d2.Vec2{1, 1}},
+		{10 * time.Millisecond, d2.Vec2{1, 1}},
+		{15 * time.Millisecond, d2.Vec2{1.5, 1}},
+		{20 * time.Millisecond, d2.Vec2{2, 1}},
+		{25 * time.Millisecond, d2.Vec2{2, 2}},
+		{time.Second, d2.Vec2{2, 3}},
+	}
+	for _, tt := range tests {
+		if got, _ := h.At(tt.t); !got.Approx(tt.want) {
+			t.Errorf("At(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestPositionHistory_DropsOldestPositions(t *testing.T) {
+	h := NewPositionHistory()
+	for i := 0; i < historyCapacity+10; i++ {
+		h.Record(time.Duration(i)*time.Millisecond, d2.Vec2{float32(i), 0})
+	}
+	if got, _ := h.At(0); !got.Approx(d2.Vec2{10, 0}) {
+		t.Errorf("At(0) = %v, want the oldest kept position %v", got, d2.Vec2{10, 0})
+	}
+	last := float32(historyCapacity + 9)
+	if got, _ := h.At(time.Hour); !got.Approx(d2.Vec2{last, 0}) {
+		t.Errorf("At(1h) = %v, want the latest position %v", got, d2.Vec2{last, 0})
+	}
+}
+
+func TestPlayer_AttackRewindsTarget(t *testing.T) {
+	const dt = 10 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		latency time.Duration
+		hit     bool
+	}{
+		{"no latency", 0, false},
+		{"target seen in range", 100 * time.Millisecond, true},
+		{"latency capped", time.Hour, false},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, longRoom...)
+		p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+		z := addTestZombie(g, d2.Vec2{2.3, 2.5})
+		hp := z.health.Cur
+
+		// the zombie walks out of the player attack range
+		g.state.recordPositions(dt)
+		for i := 0; i < 10; i++ {
+			z.Pos = d2.Vec2{z.Pos[0] + 0.1, z.Pos[1]}
+			g.state.World().UpdateEntity(z)
+			g.state.recordPositions(dt)
+		}
+		if tt.latency > MaxLagCompensation {
+			// the zombie was in range a long time ago, then far away
+			h := NewPositionHistory()
+			h.Record(g.state.clock-time.Second, d2.Vec2{2.3, 2.5})
+			h.Record(g.state.clock-MaxLagCompensation, d2.Vec2{8.5, 2.5})
+			h.Record(g.state.clock, z.Pos)
+			z.AddComponent(h)
+		}
+
+		p.Attack(z, tt.latency)
+		p.Update(dt)
+		if hit := z.health.Cur < hp; hit != tt.hit {
+			t.Errorf("%s: zombie hit = %v, want %v", tt.name, hit, tt.hit)
+		}
+	}
+}
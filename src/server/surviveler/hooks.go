@@ -0,0 +1,69 @@
+/*
+ * Surviveler package
+ * entity lifecycle hooks
+ */
+package surviveler
+
+/*
+ * EntityHook is a function called when an entity enters or leaves the game
+ */
+type EntityHook func(ent Entity)
+
+/*
+ * entityClass identifies a type of entities. The entity types of the
+ * different kinds overlap, so the kind is part of it.
+ */
+type entityClass struct {
+	kind uint8      // entity kind, see entityKind
+	typ  EntityType // entity type, among the types of its kind
+}
+
+/*
+ * entityHooks holds the hooks registered for each class of entities
+ */
+type entityHooks map[entityClass][]EntityHook
+
+/*
+ * OnSpawn registers a hook called each time an entity of the given kind and
+ * type is added to the game state, once it has been.
+ *
+ * kind is one of the entity kinds of the messages package, players and
+ * zombies being of messages.MobileEntityKind. Subsystems keeping track of
+ * entities register hooks rather than being called from wherever the
+ * entities are added.
+ */
+func (gs *GameState) OnSpawn(kind uint8, et EntityType, hook EntityHook) {
+	gs.spawnHooks = gs.spawnHooks.add(kind, et, hook)
+}
+
+/*
+ * OnRemove registers a hook called each time an entity of the given kind and
+ * type is removed from the game state, once it has been, whatever the reason
+ * of the removal. See OnSpawn.
+ */
+func (gs *GameState) OnRemove(kind uint8, et EntityType, hook EntityHook) {
+	gs.removeHooks = gs.removeHooks.add(kind, et, hook)
+}
+
+func (hooks entityHooks) add(kind uint8, et EntityType, hook EntityHook) entityHooks {
+	if hooks == nil {
+		hooks = make(entityHooks)
+	}
+	class := entityClass{kind, et}
+	hooks[class] = append(hooks[class], hook)
+	return hooks
+}
+
+/*
+ * fire calls the hooks registered for the class of ent, in the order in
+ * which they have been registered
+ */
+func (hooks entityHooks) fire(ent Entity) {
+	kind, ok := entityKind(ent)
+	if !ok {
+		return
+	}
+	for _, hook := range hooks[entityClass{kind, ent.Type()}] {
+		hook(ent)
+	}
+}
@@ -0,0 +1,59 @@
+package surviveler
+
+import (
+	"server/messages"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestGameState_EntityHooks(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	g.cfg.ZombieDyingTime = 500
+	gs := g.state
+	spawned := make(map[Entity]int)
+	removed := make(map[Entity]int)
+	count := func(calls map[Entity]int, inGame bool) EntityHook {
+		return func(ent Entity) {
+			if (gs.Entity(ent.Id()) == ent) != inGame {
+				t.Errorf("hook called on entity %d, in game = %v, want %v", ent.Id(), !inGame, inGame)
+			}
+			calls[ent]++
+		}
+	}
+	gs.OnSpawn(messages.MobileEntityKind, ZombieEntity, count(spawned, true))
+	gs.OnRemove(messages.MobileEntityKind, ZombieEntity, count(removed, false))
+	gs.OnSpawn(messages.MobileEntityKind, TankEntity, count(spawned, true))
+	gs.OnRemove(messages.MobileEntityKind, TankEntity, count(removed, false))
+
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	p := addTestPlayer(g, TankEntity, d2.Vec2{10.5, 2.5})
+	// a barricade has the type of a tank, but not its kind
+	b := gs.createBuilding(BarricadeBuilding, d2.Vec2{15.5, 2.5})
+	gs.AddEntity(z)
+	if spawned[z] != 1 || spawned[p] != 1 || spawned[b] != 0 {
+		t.Errorf("spawn hooks called %d, %d and %d times on the zombie, player and barricade, want 1, 1 and 0",
+			spawned[z], spawned[p], spawned[b])
+	}
+
+	// a killed zombie is only removed once it's done dying
+	z.DealDamage(z.health.Cur, MeleeDamage)
+	tick(g, 10*time.Millisecond)
+	if removed[z] != 0 {
+		t.Errorf("remove hook called on a dying zombie")
+	}
+	for i := 0; i < 6; i++ {
+		tick(g, 100*time.Millisecond)
+	}
+	gs.RemoveEntity(p.Id())
+	gs.RemoveEntity(p.Id())
+	gs.RemoveEntity(b.Id())
+	if removed[z] != 1 || removed[p] != 1 || removed[b] != 0 {
+		t.Errorf("remove hooks called %d, %d and %d times on the zombie, player and barricade, want 1, 1 and 0",
+			removed[z], removed[p], removed[b])
+	}
+	if spawned[z] != 1 || spawned[p] != 1 {
+		t.Errorf("spawn hooks called again on removal")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Surviveler package
+ * entity identifiers allocation
+ */
+package surviveler
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/*
+ * An entity id is made of an index, recycled once the entity is removed, and
+ * of the generation of that index, incremented each time it gets recycled.
+ * A stale reference to a removed entity thus never designates the entity
+ * that reuses its index.
+ */
+const (
+	idIndexBits     = 20
+	idIndexMask     = 1<<idIndexBits - 1
+	idMaxGeneration = 1<<(32-idIndexBits) - 1 // last generation, never reached
+)
+
+/*
+ * IDAllocator hands out unique entity ids, and reuses the ids of the removed
+ * entities, so that long sessions don't exhaust them.
+ *
+ * Freed ids are reused in the order they have been freed, with their
+ * generation incremented. An index whose generation is exhausted is retired.
+ * Index 0 is never used, so that the ids of the first generation start at 1.
+ */
+type IDAllocator struct {
+	mutex sync.Mutex
+	count uint32   // number of indices allocated so far
+	slots []uint32 // live id of each index, InvalidID if free
+	free  []uint32 // freed ids, oldest first
+}
+
+/*
+ * NewIDAllocator creates an id allocator with no allocated ids
+ */
+func NewIDAllocator() *IDAllocator {
+	return &IDAllocator{slots: []uint32{InvalidID}}
+}
+
+/*
+ * Alloc returns an id that no live entity has, reusing a freed id if any
+ */
+func (a *IDAllocator) Alloc() uint32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.free) == 0 {
+		return a.allocFresh()
+	}
+	id := a.free[0]
+	a.free = a.free[1:]
+	id += 1 << idIndexBits
+	a.slots[id&idIndexMask] = id
+	return id
+}
+
+/*
+ * AllocFresh returns the id of an index that has never been allocated.
+ *
+ * It's meant for the ids allocated off the game loop, that can't be
+ * recorded in order to be replayed, as the allocated index only depends on
+ * the number of ids allocated so far (see Count and Reserve).
+ */
+func (a *IDAllocator) AllocFresh() uint32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.allocFresh()
+}
+
+func (a *IDAllocator) allocFresh() uint32 {
+	if a.count == idIndexMask {
+		log.Error("No entity ids left")
+		return InvalidID
+	}
+	a.count++
+	a.slots = append(a.slots, a.count)
+	return a.count
+}
+
+/*
+ * Free makes the id available for reuse. Freeing an id that isn't live does
+ * nothing.
+ */
+func (a *IDAllocator) Free(id uint32) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	idx := id & idIndexMask
+	if id == InvalidID || idx == 0 || idx > a.count || a.slots[idx] != id {
+		return
+	}
+	a.slots[idx] = InvalidID
+	if id>>idIndexBits+1 < idMaxGeneration {
+		a.free = append(a.free, id)
+	}
+}
+
+/*
+ * Count returns the number of indices allocated so far
+ */
+func (a *IDAllocator) Count() uint32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.count
+}
+
+/*
+ * Reserve considers the first n indices as allocated, this is used while
+ * replaying to account for the ids that were allocated off the game loop.
+ */
+func (a *IDAllocator) Reserve(n uint32) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for a.count < n && a.count < idIndexMask {
+		a.count++
+		a.slots = append(a.slots, a.count)
+	}
+}
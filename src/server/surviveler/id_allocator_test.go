@@ -0,0 +1,110 @@
+package surviveler
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestIDAllocator_Recycling(t *testing.T) {
+	a := NewIDAllocator()
+	first, second := a.Alloc(), a.Alloc()
+	if first != 1 || second != 2 {
+		t.Fatalf("first ids = %v, %v, want 1, 2", first, second)
+	}
+
+	a.Free(first)
+	a.Free(first) // double free is ignored
+	reused := a.Alloc()
+	if reused == first || reused&idIndexMask != first&idIndexMask {
+		t.Errorf("Alloc() = %#x, want index of %#x with a new generation", reused, first)
+	}
+	if id := a.Alloc(); id != 3 {
+		t.Errorf("Alloc() = %v, want a fresh id 3", id)
+	}
+
+	// a stale id doesn't free the entity reusing its index
+	a.Free(first)
+	if id := a.Alloc(); id == reused {
+		t.Errorf("freeing a stale id released the live id %#x", reused)
+	}
+	if a.Count() != 4 {
+		t.Errorf("Count() = %v, want 4", a.Count())
+	}
+}
+
+func TestIDAllocator_NoLiveCollisions(t *testing.T) {
+	a := NewIDAllocator()
+	live := make(map[uint32]bool)
+	seen := make(map[uint32]bool)
+	var maxLive int
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		if len(live) > 0 && rnd.Intn(2) == 0 {
+			for id := range live {
+				a.Free(id)
+				delete(live, id)
+				break
+			}
+			continue
+		}
+		id := a.Alloc()
+		if id == InvalidID || live[id] {
+			t.Fatalf("Alloc() = %#x, already live", id)
+		}
+		if seen[id] {
+			t.Fatalf("Alloc() = %#x, already handed out before", id)
+		}
+		live[id], seen[id] = true, true
+		if len(live) > maxLive {
+			maxLive = len(live)
+		}
+	}
+	// ids are recycled, no more indices than live ids are needed
+	if a.Count() != uint32(maxLive) {
+		t.Errorf("Count() = %v, want at most %d live ids", a.Count(), maxLive)
+	}
+}
+
+func TestIDAllocator_RetiresExhaustedIndex(t *testing.T) {
+	a := NewIDAllocator()
+	id := a.Alloc()
+	for gen := 1; gen < idMaxGeneration; gen++ {
+		a.Free(id)
+		if id = a.Alloc(); id>>idIndexBits != uint32(gen) {
+			t.Fatalf("Alloc() generation = %v, want %v", id>>idIndexBits, gen)
+		}
+	}
+	a.Free(id)
+	if id = a.Alloc(); id != 2 {
+		t.Errorf("Alloc() = %#x, want the exhausted index to be retired", id)
+	}
+}
+
+func TestIDAllocator_Reserve(t *testing.T) {
+	a := NewIDAllocator()
+	a.Reserve(5)
+	if id := a.AllocFresh(); id != 6 {
+		t.Errorf("AllocFresh() = %v, want 6", id)
+	}
+	a.Reserve(3)
+	if a.Count() != 6 {
+		t.Errorf("Count() = %v, want 6", a.Count())
+	}
+}
+
+func TestGameState_RemoveEntityRecyclesId(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	old := z.Id()
+	g.state.RemoveEntity(old)
+
+	z = addTestZombie(g, d2.Vec2{1.5, 2.5})
+	if z.Id() == old || z.Id()&idIndexMask != old&idIndexMask {
+		t.Errorf("new zombie id = %#x, want the index of %#x with a new generation", z.Id(), old)
+	}
+	if g.state.Entity(old) != nil {
+		t.Errorf("stale id %#x designates an entity", old)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Surviveler package
+ * entity debug dumps
+ */
+package surviveler
+
+import (
+	"fmt"
+	"io"
+)
+
+// max length of a component value in an entity dump
+const inspectMaxValueLen = 160
+
+// names of the zombie AI states
+var zombieStateNames = [...]string{
+	lookingState:   "looking",
+	walkingState:   "walking",
+	attackingState: "attacking",
+	returningState: "returning",
+	wanderingState: "wandering",
+	dyingState:     "dying",
+}
+
+/*
+ * inspectEntity writes the full debug state of the entity id: its type,
+ * position and hit points, the state and target of its AI, its path and the
+ * values of its components
+ */
+func (gs *GameState) inspectEntity(w io.Writer, id uint32) error {
+	ent := gs.Entity(id)
+	if ent == nil {
+		return fmt.Errorf("id %+v doesn't exist", id)
+	}
+	fmt.Fprintf(w, "entity %d: %T, type %d, faction %d\n", id, ent, ent.Type(), ent.Faction())
+	fmt.Fprintf(w, "  position: %v\n", ent.Position())
+	if d, ok := ent.(damageable); ok {
+		fmt.Fprintf(w, "  hit points: %v\n", d.hitPoints())
+	}
+
+	target, hasTarget := InvalidID, false
+	switch e := ent.(type) {
+	case *Zombie:
+		fmt.Fprintf(w, "  state: %s\n", zombieStateNames[e.curState])
+		fmt.Fprintf(w, "  searching path: %v\n", e.searching)
+	case *Player:
+		if action, ok := e.actions.Peek(); ok {
+			fmt.Fprintf(w, "  action: %d %+v\n", action.Type, action.Item)
+		}
+		if e.target != nil {
+			target, hasTarget = e.target.Id(), true
+		}
+	}
+	if t, ok := ent.(Targeter); ok {
+		target, hasTarget = t.Target()
+	}
+	if hasTarget {
+		fmt.Fprintf(w, "  target: %d\n", target)
+	} else {
+		fmt.Fprintln(w, "  target: none")
+	}
+	var mv *Movable
+	if GetComponent(ent, &mv) {
+		fmt.Fprintf(w, "  path: %v\n", mv.NextWaypoints(-1))
+	}
+
+	if c, ok := ent.(interface {
+		eachComponent(func(interface{}))
+	}); ok {
+		fmt.Fprintln(w, "  components:")
+		c.eachComponent(func(comp interface{}) {
+			value := fmt.Sprintf("%+v", comp)
+			if len(value) > inspectMaxValueLen {
+				value = value[:inspectMaxValueLen] + "..."
+			}
+			fmt.Fprintf(w, "    %T: %s\n", comp, value)
+		})
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Surviveler package
+ * item entities
+ */
+package surviveler
+
+import (
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+const itemSize = 0.25 // half size of the item bounding box
+
+/*
+ * Item is an entity lying on the ground, that is picked up by the first
+ * player moving over it.
+ *
+ * The quantity of an item depends on its type: it's a number of rounds for
+ * ammo, of hit points for a medkit, etc.
+ */
+type Item struct {
+	id       uint32
+	itemType EntityType
+	pos      d2.Vec2
+	quantity uint16
+}
+
+/*
+ * NewItem creates a new item, lying at pos
+ */
+func NewItem(pos d2.Vec2, itemType EntityType, quantity uint16) *Item {
+	return &Item{
+		id:       InvalidID,
+		itemType: itemType,
+		pos:      pos,
+		quantity: quantity,
+	}
+}
+
+func (it *Item) Id() uint32 {
+	return it.id
+}
+
+func (it *Item) SetId(id uint32) {
+	it.id = id
+}
+
+func (it *Item) Type() EntityType {
+	return it.itemType
+}
+
+func (it *Item) Faction() Faction {
+	return NeutralFaction
+}
+
+func (it *Item) Position() d2.Vec2 {
+	return it.pos
+}
+
+func (it *Item) Rectangle() d2.Rectangle {
+	return d2.RectFromCircle(it.pos, itemSize)
+}
+
+func (it *Item) State() EntityState {
+	return ItemState{
+		Type:     it.itemType,
+		Xpos:     it.pos[0],
+		Ypos:     it.pos[1],
+		Quantity: it.quantity,
+	}
+}
+
+func (it *Item) Update(dt time.Duration) {
+}
+
+func (it *Item) DealDamage(damage float32, typ DamageType) bool {
+	// NOTE: items can't be damaged
+	return false
+}
+
+func (it *Item) HealDamage(damage float32) bool {
+	return true
+}
+
+/*
+ * pickUp gives the item to a player: medkits heal the player on the spot,
+ * the other items go into its inventory
+ */
+func (it *Item) pickUp(p *Player) {
+	switch it.itemType {
+	case MedkitItem:
+		p.HealDamage(float32(it.quantity))
+	default:
+		p.inventory.Add(it.itemType, it.quantity)
+	}
+}
@@ -0,0 +1,105 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestPlayer_PicksUpMedkit(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	p.DealDamage(30, MeleeDamage)
+	medkit := NewItem(d2.Vec2{4.5, 2.5}, MedkitItem, 20)
+	g.state.AddEntity(medkit)
+	if _, ok := g.state.pack().Items[medkit.Id()]; !ok {
+		t.Fatalf("item %v not found in packed game state", medkit.Id())
+	}
+	hp := p.health.Cur
+
+	// walk over the medkit
+	p.Move(Path{{7.5, 2.5}, {1.5, 2.5}})
+	for i := 0; i < 100 && !isIdle(p); i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	tick(g, 0)
+
+	if want := hp + 20; p.health.Cur != want {
+		t.Errorf("player HP = %v, want %v", p.health.Cur, want)
+	}
+	if g.state.Entity(medkit.Id()) != nil {
+		t.Errorf("medkit should have been removed from the game state")
+	}
+	if _, ok := g.state.pack().Items[medkit.Id()]; ok {
+		t.Errorf("medkit still in packed game state")
+	}
+}
+
+func TestPlayer_PicksUpAmmo(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	ammo := NewItem(d2.Vec2{4.5, 2.5}, AmmoItem, 10)
+	g.state.AddEntity(ammo)
+
+	// use all the starting ammo
+	p.inventory.Take(AmmoItem, PlayerStartingAmmo)
+	if p.Shoot(d2.Vec2{7.5, 2.5}) != nil {
+		t.Fatalf("Shoot() without ammo should fail")
+	}
+
+	p.Move(Path{{7.5, 2.5}})
+	for i := 0; i < 100 && !isIdle(p); i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	tick(g, 0)
+
+	if got := p.inventory.Count(AmmoItem); got != 10 {
+		t.Errorf("ammo = %d, want 10", got)
+	}
+	if g.state.Entity(ammo.Id()) != nil {
+		t.Errorf("ammo should have been removed from the game state")
+	}
+	if p.Shoot(d2.Vec2{1.5, 2.5}) == nil {
+		t.Errorf("Shoot() with ammo should succeed")
+	}
+	if got := p.inventory.Count(AmmoItem); got != 9 {
+		t.Errorf("ammo after a shot = %d, want 9", got)
+	}
+}
+
+func TestPlayer_PicksUpItemAtItsFeet(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{4.5, 2.5})
+	tick(g, 50*time.Millisecond)
+
+	// an item dropped under a standing player is picked up all the same
+	ammo := NewItem(d2.Vec2{4.5, 2.5}, AmmoItem, 10)
+	g.state.AddEntity(ammo)
+	tick(g, 50*time.Millisecond)
+	tick(g, 50*time.Millisecond)
+
+	if !isIdle(p) {
+		t.Fatalf("player should be standing still")
+	}
+	if got := p.inventory.Count(AmmoItem); got != PlayerStartingAmmo+10 {
+		t.Errorf("ammo = %d, want %d", got, PlayerStartingAmmo+10)
+	}
+	if g.state.Entity(ammo.Id()) != nil {
+		t.Errorf("ammo should have been removed from the game state")
+	}
+}
+
+func TestZombie_WalksOverItems(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.state.AddEntity(NewItem(d2.Vec2{4.5, 2.5}, AmmoItem, 10))
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+
+	for i := 0; i < 200 && z.curState != attackingState; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if z.curState != attackingState || z.target != p {
+		t.Errorf("zombie at %v should be attacking the player, got target %v", z.Position(), z.target)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Surviveler package
+ * game rooms
+ */
+package surviveler
+
+import (
+	"fmt"
+	"server/logging"
+	"server/protocol"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/*
+ * Lobby hosts several isolated game rooms in a single process.
+ *
+ * Each room is a Game, with its own state, loop and clients. The assets
+ * package is loaded once and shared by the rooms, each room having its own
+ * copy of the world. The clients all connect to the lobby, that sends them
+ * to a room when they join. Only the first room runs the telnet and metrics
+ * servers, if enabled.
+ */
+type Lobby struct {
+	cfg   Config
+	rooms []*Game
+	lobby *protocol.Lobby // routes the clients to the rooms
+	wg    sync.WaitGroup  // wait for the lobby goroutines to finish
+}
+
+/*
+ * NewLobby sets up a lobby hosting cfg.Rooms game rooms
+ */
+func NewLobby(cfg Config) *Lobby {
+	if !setupProcess(&cfg) {
+		return nil
+	}
+	l, err := newLobby(cfg)
+	if err != nil {
+		log.WithError(err).Error("Couldn't setup the rooms")
+		return nil
+	}
+	return l
+}
+
+/*
+ * newLobby creates the rooms of a lobby, the assets being loaded by the
+ * first room only
+ */
+func newLobby(cfg Config) (*Lobby, error) {
+	l := &Lobby{cfg: cfg}
+	for i := 0; i < cfg.Rooms; i++ {
+		room := &Game{cfg: cfg}
+		var (
+			gd  *gameData
+			err error
+		)
+		if i == 0 {
+			if gd, err = room.loadAssets(cfg.AssetsPath); err != nil {
+				return nil, fmt.Errorf("couldn't load assets: %v", err)
+			}
+		} else {
+			room.cfg.TelnetPort, room.cfg.MetricsPort = "", ""
+			room.assets = l.rooms[0].assets
+			gd = l.rooms[0].gameData.forRoom()
+		}
+		if err = room.init(gd); err != nil {
+			return nil, fmt.Errorf("room %d: %v", i, err)
+		}
+		l.rooms = append(l.rooms, room)
+	}
+	return l, nil
+}
+
+/*
+ * Start starts the rooms and the lobby, then waits for a termination signal
+ */
+func (l *Lobby) Start() {
+	if err := l.start(); err != nil {
+		log.WithError(err).Error("Game state initialization failed...")
+	} else {
+		waitTermination()
+	}
+	l.stop()
+	logging.Close()
+}
+
+/*
+ * start starts the room servers and game loops, then the lobby
+ */
+func (l *Lobby) start() error {
+	servers := make([]*protocol.Server, len(l.rooms))
+	for i, room := range l.rooms {
+		room.server.Run()
+		servers[i] = room.server
+	}
+	for i, room := range l.rooms {
+		if err := room.run(); err != nil {
+			return fmt.Errorf("room %d: %v", i, err)
+		}
+	}
+	l.lobby = protocol.NewLobby(l.cfg.Port, &l.wg, servers...)
+	l.lobby.Start()
+	return nil
+}
+
+/*
+ * stop stops the rooms, all at once so that their clients are given the
+ * same grace period, then the lobby
+ */
+func (l *Lobby) stop() {
+	var wg sync.WaitGroup
+	for _, room := range l.rooms {
+		wg.Add(1)
+		go func(room *Game) {
+			defer wg.Done()
+			room.stop()
+		}(room)
+	}
+	wg.Wait()
+
+	if l.lobby != nil {
+		l.lobby.Stop()
+	}
+	l.wg.Wait()
+}
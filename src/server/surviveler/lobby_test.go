@@ -0,0 +1,111 @@
+package surviveler
+
+import (
+	"net"
+	"server/messages"
+	"server/protocol"
+	"testing"
+	"time"
+)
+
+/*
+ * readUntil reads the messages received on conn until one satisfies match,
+ * which is returned, or nil if none did before the timeout
+ */
+func readUntil(conn net.Conn, timeout time.Duration, match func(*messages.Message) bool) *messages.Message {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		msg, err := messages.ReadMessage(conn)
+		if err != nil {
+			return nil
+		}
+		if match(msg) {
+			return msg
+		}
+	}
+}
+
+func TestLobby_IsolatedRooms(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Port, cfg.TelnetPort, cfg.MetricsPort = "0", "", ""
+	cfg.AssetsPath = testAssets
+	cfg.Rooms = 2
+	l, err := newLobby(cfg)
+	if err != nil {
+		t.Fatalf("newLobby() error = %v", err)
+	}
+	if l.rooms[0].state.world == l.rooms[1].state.world {
+		t.Fatalf("rooms share the same world")
+	}
+	if err := l.start(); err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	defer l.stop()
+
+	// each client goes to the emptiest room
+	players := []EntityType{TankEntity, EngineerEntity}
+	var conns []net.Conn
+	for i, et := range players {
+		conn, err := net.Dial("tcp", l.lobby.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		join := messages.New(messages.JoinId, messages.Join{
+			Name:    []string{"Alice", "Bob"}[i],
+			Type:    uint8(et),
+			Version: protocol.MaxProtocolVersion,
+		})
+		if _, err := conn.Write(join.Serialize()); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		isStay := func(msg *messages.Message) bool { return msg.Type == messages.StayId }
+		if readUntil(conn, time.Second, isStay) == nil {
+			t.Fatalf("client %d didn't receive STAY", i)
+		}
+	}
+	for i, room := range l.rooms {
+		if n := room.clients.Len(); n != 1 {
+			t.Errorf("room %d has %d clients, want 1", i, n)
+		}
+	}
+
+	// each client only sees its own player in the game state
+	for i, conn := range conns {
+		msg := readUntil(conn, time.Second, func(msg *messages.Message) bool {
+			if msg.Type != messages.GameStateId {
+				return false
+			}
+			var gs messages.GameState
+			messages.Decode(msg, &gs)
+			return len(gs.Entities) > 0
+		})
+		if msg == nil {
+			t.Fatalf("client %d didn't receive its player in a game state", i)
+		}
+		var gs messages.GameState
+		messages.Decode(msg, &gs)
+		if len(gs.Entities) != 1 {
+			t.Errorf("client %d sees %d entities, want only its player", i, len(gs.Entities))
+		}
+		for _, ent := range gs.Entities {
+			if EntityType(ent.Type) != players[i] {
+				t.Errorf("client %d sees an entity of type %v, want its own %v", i, ent.Type, players[i])
+			}
+		}
+	}
+
+	// chat messages stay in their room
+	chat := messages.New(messages.ChatId, messages.Chat{Text: "anyone there?"})
+	if _, err := conns[0].Write(chat.Serialize()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	isChat := func(msg *messages.Message) bool { return msg.Type == messages.ChatId }
+	if readUntil(conns[0], time.Second, isChat) == nil {
+		t.Errorf("chat message hasn't been broadcast in its room")
+	}
+	if readUntil(conns[1], 200*time.Millisecond, isChat) != nil {
+		t.Errorf("chat message leaked to the other room")
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Surviveler package
+ * level of detail update scheduling
+ */
+package surviveler
+
+import (
+	"server/messages"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+/*
+ * Level of detail distances: the zombies farther than those from every player
+ * update less often
+ */
+const (
+	LODNearDistance = 20 // closer zombies update every tick
+	LODFarDistance  = 40 // farther zombies update every lodFarPeriod ticks
+)
+
+// update periods of the level of detail tiers, in logic ticks
+const (
+	lodMidPeriod = 4
+	lodFarPeriod = 16
+)
+
+/*
+ * lodScheduler decides, tick after tick, which entities to update.
+ *
+ * Only the zombies are scheduled by level of detail, every other entity
+ * updates every tick. A zombie skipping updates accumulates the elapsed time,
+ * which is passed at once to its next update, so that its timers and
+ * intervals keep running at the same pace. The updates of the zombies of a
+ * tier are spread over the ticks, according to their ids.
+ */
+type lodScheduler struct {
+	pending map[uint32]time.Duration // time elapsed since the last update of the skipped zombies
+	players []d2.Vec2                // player positions, for the current tick
+}
+
+func newLODScheduler(gs *GameState) *lodScheduler {
+	s := &lodScheduler{pending: make(map[uint32]time.Duration)}
+	// forget the removed zombies, their ids may be reused
+	gs.OnRemove(messages.MobileEntityKind, ZombieEntity, func(ent Entity) {
+		delete(s.pending, ent.Id())
+	})
+	return s
+}
+
+/*
+ * begin prepares the scheduling of a tick, before any call to due
+ */
+func (s *lodScheduler) begin(gs *GameState) {
+	s.players = s.players[:0]
+	gs.forEachEntity(func(ent Entity) bool {
+		if p, ok := ent.(*Player); ok {
+			s.players = append(s.players, p.Pos)
+		}
+		return true
+	})
+}
+
+/*
+ * due indicates if ent has to be updated during the tick, and returns the
+ * time elapsed since its last update, dt included
+ */
+func (s *lodScheduler) due(ent Entity, tick uint64, dt time.Duration) (time.Duration, bool) {
+	z, ok := ent.(*Zombie)
+	if !ok {
+		return dt, true
+	}
+	period := s.period(z.Pos)
+	acc := s.pending[z.Id()] + dt
+	if period > 1 && (tick+uint64(z.Id()))%period != 0 {
+		s.pending[z.Id()] = acc
+		return 0, false
+	}
+	delete(s.pending, z.Id())
+	return acc, true
+}
+
+/*
+ * period returns the update period, in ticks, of a zombie at pos
+ */
+func (s *lodScheduler) period(pos d2.Vec2) uint64 {
+	nearest := float32(-1)
+	for _, p := range s.players {
+		if d := p.Sub(pos).LenSqr(); nearest < 0 || d < nearest {
+			nearest = d
+		}
+	}
+	switch {
+	case nearest < 0:
+		// no player to watch, no need to hurry
+		return lodFarPeriod
+	case nearest <= LODNearDistance*LODNearDistance:
+		return 1
+	case nearest <= LODFarDistance*LODFarDistance:
+		return lodMidPeriod
+	}
+	return lodFarPeriod
+}
@@ -0,0 +1,108 @@
+package surviveler
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestLODScheduler_Tiers(t *testing.T) {
+	const dt = 10 * time.Millisecond
+	g := newTestGame(t, newTestRoom(80)...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{5.5, 5.5})
+	tests := []struct {
+		name    string
+		z       *Zombie
+		updates int
+	}{
+		{"near", addTestZombie(g, d2.Vec2{5.5 + LODNearDistance - 1, 5.5}), 64},
+		{"mid", addTestZombie(g, d2.Vec2{5.5 + LODFarDistance - 1, 5.5}), 64 / lodMidPeriod},
+		{"far", addTestZombie(g, d2.Vec2{5.5 + LODFarDistance + 1, 5.5}), 64 / lodFarPeriod},
+	}
+
+	updates := make([]int, len(tests))
+	elapsed := make([]time.Duration, len(tests))
+	for tick := uint64(0); tick < 64; tick++ {
+		g.lod.begin(g.state)
+		if d, due := g.lod.due(p, tick, dt); !due || d != dt {
+			t.Fatalf("tick %d: player due = %v with %v, want it updated every tick", tick, due, d)
+		}
+		for i, tt := range tests {
+			if d, due := g.lod.due(tt.z, tick, dt); due {
+				updates[i]++
+				elapsed[i] += d
+			}
+		}
+	}
+	for i, tt := range tests {
+		if updates[i] != tt.updates {
+			t.Errorf("%s zombie updated %d times in 64 ticks, want %d", tt.name, updates[i], tt.updates)
+		}
+		// no time is lost, it's only delayed until the next update
+		if total := elapsed[i] + g.lod.pending[tt.z.Id()]; total != 64*dt {
+			t.Errorf("%s zombie updated for %v in total, want %v", tt.name, total, 64*dt)
+		}
+	}
+	if elapsed[0] != 64*dt {
+		t.Errorf("near zombie updated for %v, want every tick for %v", elapsed[0], 64*dt)
+	}
+
+	// the skipped updates of removed zombies are forgotten
+	far := tests[2].z.Id()
+	g.state.RemoveEntity(far)
+	if _, ok := g.lod.pending[far]; ok {
+		t.Errorf("removed zombie still has pending updates")
+	}
+}
+
+func TestLODScheduler_NearbyZombieChases(t *testing.T) {
+	// a nearby zombie behaves exactly as without scheduling
+	var zombies []*Zombie
+	for _, lod := range []bool{false, true} {
+		g := newTestGame(t, newTestRoom(80)...)
+		addTestPlayer(g, TankEntity, d2.Vec2{5.5, 5.5})
+		z := addTestZombie(g, d2.Vec2{12.5, 5.5})
+		if !lod {
+			g.lod = nil
+		}
+		for i := 0; i < 50; i++ {
+			g.logicTick(10 * time.Millisecond)
+		}
+		zombies = append(zombies, z)
+	}
+	if zombies[1].Pos[0] >= 12.5 {
+		t.Errorf("zombie at %v didn't chase the player", zombies[1].Pos)
+	}
+	if !zombies[1].Pos.Approx(zombies[0].Pos) || zombies[1].curState != zombies[0].curState {
+		t.Errorf("zombie at %v in state %v, want %v in state %v as without scheduling",
+			zombies[1].Pos, zombies[1].curState, zombies[0].Pos, zombies[0].curState)
+	}
+}
+
+func BenchmarkLogicTick_IdleZombies(b *testing.B) {
+	const size = 256
+	for _, bb := range []struct {
+		name string
+		lod  bool
+	}{
+		{"every tick", false},
+		{"lod", true},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			rnd := rand.New(rand.NewSource(1))
+			g, _ := newCrowdedGame(b, rnd, size, 2000)
+			// leashed zombies don't chase the lone player, they idle around
+			g.cfg.ZombieLeash = 10
+			addTestPlayer(g, TankEntity, d2.Vec2{2.5, 2.5})
+			if !bb.lod {
+				g.lod = nil
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				g.logicTick(10 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -6,8 +6,11 @@
 package surviveler
 
 import (
+	"errors"
 	"server/events"
 	"server/messages"
+	"server/protocol"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -24,30 +27,20 @@ import (
  * - telnet request -> perform a game state related telnet request
  */
 func (g *Game) loop() error {
-	// will tick when it's time to send the gamestate to the clients
-	sendTickChan := time.NewTicker(
-		time.Millisecond * time.Duration(g.cfg.SendTickPeriod)).C
+	// will tick when it's time to send the gamestate to the clients, unless
+	// sending is aligned on the logic ticks
+	var sendTickChan <-chan time.Time
+	if !g.cfg.AlignSendTicks {
+		sendTickChan = time.NewTicker(
+			time.Millisecond * time.Duration(g.cfg.SendTickPeriod)).C
+	}
 
 	// will tick when it's time to update the game
-	tickChan := time.NewTicker(
-		time.Millisecond * time.Duration(g.cfg.LogicTickPeriod)).C
+	logicStep := time.Millisecond * time.Duration(g.cfg.LogicTickPeriod)
+	tickChan := time.NewTicker(logicStep).C
+	timestep := NewTimestep(logicStep)
 
-	// will tick when a minute in game time elapses
-	timeChan := time.NewTicker(
-		time.Minute * 1 / time.Duration(g.cfg.TimeFactor)).C
-
-	// event listeners
-	g.eventManager.Subscribe(events.PlayerJoinId, g.state.onPlayerJoin)
-	g.eventManager.Subscribe(events.PlayerLeaveId, g.state.onPlayerLeave)
-	g.eventManager.Subscribe(events.PlayerMoveId, g.state.onPlayerMove)
-	g.eventManager.Subscribe(events.PlayerBuildId, g.state.onPlayerBuild)
-	g.eventManager.Subscribe(events.PlayerRepairId, g.state.onPlayerRepair)
-	g.eventManager.Subscribe(events.PlayerAttackId, g.state.onPlayerAttack)
-	g.eventManager.Subscribe(events.PlayerOperateId, g.state.onPlayerOperate)
-	g.eventManager.Subscribe(events.PlayerDeathId, g.state.onPlayerDeath)
-	g.eventManager.Subscribe(events.ZombieDeathId, g.state.onZombieDeath)
-	g.eventManager.Subscribe(events.ZombieDeathId, g.ai.OnZombieDeath)
-	g.eventManager.Subscribe(events.BuildingDestroyId, g.state.onBuildingDestroy)
+	g.registerEventHandlers()
 
 	var lastTime, curTime time.Time
 	lastTime = time.Now()
@@ -67,41 +60,13 @@ func (g *Game) loop() error {
 				return
 
 			case <-sendTickChan:
-				// pack the gamestate into a message
-				if gsMsg := g.state.pack(); gsMsg != nil {
-					// wrap the gameStateMsg into a generic Message
-					if msg := messages.New(messages.GameStateId, *gsMsg); msg != nil {
-						g.server.Broadcast(msg)
-					}
-				}
+				g.sendTick()
 
 			case <-tickChan:
-				// poll and process accumulated events
-				g.eventManager.Process()
-
-				// compute delta time
 				curTime = time.Now()
-				dt := curTime.Sub(lastTime)
-
-				// update AI
-				g.ai.Update(curTime)
-
-				// update entities
-				for _, ent := range g.state.entities {
-					ent.Update(dt)
-				}
-
+				g.advance(timestep, curTime.Sub(lastTime))
 				lastTime = curTime
 
-			case <-timeChan:
-				// increment game time by 1 minute
-				g.state.gameTime++
-
-				// clamp the game time to 24h
-				if g.state.gameTime >= 1440 {
-					g.state.gameTime -= 1440
-				}
-
 			case tnr := <-g.telnetReq:
 				// received a telnet request
 				g.telnetDone <- g.telnetHandler(tnr)
@@ -114,3 +79,290 @@ func (g *Game) loop() error {
 	}()
 	return nil
 }
+
+/*
+ * advance runs as many fixed logic steps as the elapsed time allows, none
+ * while the game is paused.
+ *
+ * With aligned send ticks, the game state is sent right after every
+ * sendTickRatio logic steps, or while paused, logic ticker wake-ups.
+ */
+func (g *Game) advance(timestep *Timestep, elapsed time.Duration) {
+	if g.Paused() {
+		// the time elapsed during the pause is forgotten
+		if g.alignedSendDue() {
+			g.sendTick()
+		}
+		return
+	}
+	for n := timestep.Advance(elapsed); n > 0; n-- {
+		g.logicTick(timestep.Step)
+		if g.alignedSendDue() {
+			g.sendTick()
+		}
+	}
+}
+
+/*
+ * alignedSendDue indicates if a send tick is due after a logic tick, it's
+ * always false if send ticks aren't aligned on the logic ticks
+ */
+func (g *Game) alignedSendDue() bool {
+	if !g.cfg.AlignSendTicks {
+		return false
+	}
+	if g.sendPhase++; g.sendPhase < g.cfg.sendTickRatio() {
+		return false
+	}
+	g.sendPhase = 0
+	return true
+}
+
+/*
+ * Paused indicates if the game is paused
+ */
+func (g *Game) Paused() bool {
+	return atomic.LoadInt32(&g.paused) != 0
+}
+
+/*
+ * pause suspends the logic ticks and the game time, the game state still
+ * being sent to the clients. It returns an error if the game is already
+ * paused.
+ */
+func (g *Game) pause() error {
+	if !atomic.CompareAndSwapInt32(&g.paused, 0, 1) {
+		return errors.New("game is already paused")
+	}
+	g.state.tickLog().Info("Game paused")
+	return nil
+}
+
+/*
+ * resume resumes a paused game. It returns an error if the game isn't paused.
+ */
+func (g *Game) resume() error {
+	if !atomic.CompareAndSwapInt32(&g.paused, 1, 0) {
+		return errors.New("game isn't paused")
+	}
+	g.state.tickLog().Info("Game resumed")
+	return nil
+}
+
+/*
+ * logicTick performs a single logic update of the game, advancing it by dt
+ */
+func (g *Game) logicTick(dt time.Duration) {
+	start := time.Now()
+	if g.replayer != nil {
+		// inject the client events that were processed during this tick
+		g.replayer.inject(g.tick, g)
+	}
+
+	// apply the paths searched since the previous tick
+	g.pathfinder.Deliver()
+
+	// poll and process accumulated events
+	g.eventManager.Process()
+
+	// advance the game time
+	g.advanceClock(dt)
+
+	// update AI
+	g.ai.Update(dt)
+
+	// apply the effects of the special tiles
+	g.state.applyTileEffects(dt)
+
+	// update entities, always in the same order. Updates may add or remove
+	// entities, so iterate over a copy of the ids
+	g.updateIDs = g.state.entityIDs(g.updateIDs[:0])
+	if g.lod != nil {
+		g.lod.begin(g.state)
+	}
+	for _, id := range g.updateIDs {
+		ent, ok := g.state.entities[id]
+		if !ok {
+			continue
+		}
+		if g.lod == nil {
+			ent.Update(dt)
+		} else if elapsed, due := g.lod.due(ent, g.tick, dt); due {
+			ent.Update(elapsed)
+		}
+	}
+	g.state.recordPositions(dt)
+	g.tick++
+	g.publishSnapshot()
+
+	d := time.Since(start)
+	period := time.Duration(g.cfg.LogicTickPeriod) * time.Millisecond
+	overrun := g.logicWatch.check("logic", d, period, time.Now())
+	if overrun {
+		g.skipSend = true
+	}
+	g.metrics.addLogicTick(d, overrun, len(g.state.entities), g.pathfinder.calls)
+}
+
+/*
+ * advanceClock advances the game time by dt of logic time, a minute in game
+ * time lasting 1/TimeFactor of a real minute
+ */
+func (g *Game) advanceClock(dt time.Duration) {
+	minute := time.Minute / time.Duration(g.cfg.TimeFactor)
+	for g.clockAcc += dt; g.clockAcc >= minute; g.clockAcc -= minute {
+		// increment game time by 1 minute, clamped to 24h
+		if g.state.gameTime++; g.state.gameTime >= minutesPerDay {
+			g.state.gameTime -= minutesPerDay
+		}
+	}
+}
+
+/*
+ * shedSendTick reports whether the current send tick should be skipped, to
+ * give its time to a lagging logic.
+ *
+ * Only the send tick following a logic tick overrun is skipped, and never 2
+ * send ticks in a row, so that clients keep receiving the gamestate.
+ */
+func (g *Game) shedSendTick() bool {
+	skip := g.skipSend && !g.sendSkipped
+	g.skipSend, g.sendSkipped = false, skip
+	if skip {
+		g.metrics.addSkippedSendTick()
+	}
+	return skip
+}
+
+/*
+ * sendTick sends the game state to the clients, unless the send tick is shed
+ */
+func (g *Game) sendTick() {
+	if !g.shedSendTick() {
+		g.sendGameState()
+	}
+}
+
+/*
+ * sendGameState packs the gamestate and broadcasts it to the clients, after
+ * the spawns and despawns of entities since the previous game state
+ */
+func (g *Game) sendGameState() {
+	start := time.Now()
+	for _, msg := range g.state.takeLifecycle() {
+		g.server.Broadcast(msg)
+	}
+	// pack the gamestate into a message
+	if gsMsg := g.state.pack(); gsMsg != nil {
+		if g.cfg.ViewRadius > 0 {
+			g.multicastGameState(gsMsg)
+		} else if msg := messages.New(messages.GameStateId, *gsMsg); msg != nil {
+			// wrap the gameStateMsg into a generic Message
+			g.server.Broadcast(msg)
+		}
+	}
+	g.sendExploredTiles()
+
+	clients := g.clients.Len()
+	d := time.Since(start)
+	period := time.Duration(g.cfg.SendTickPeriod) * time.Millisecond
+	overrun := g.sendWatch.check("send", d, period, time.Now())
+	g.metrics.addSendTick(d, overrun, clients, g.clients.TotalDroppedSends())
+}
+
+/*
+ * multicastGameState sends to each client the part of the game state made
+ * of the entities lying within its view radius of its player. The clients
+ * without a player, or a view radius, receive the whole game state.
+ */
+func (g *Game) multicastGameState(gsMsg *messages.GameState) {
+	var full []uint32
+	g.clients.Range(func(cd protocol.ClientData) bool {
+		if p := g.state.getPlayer(cd.Id); p != nil && cd.ViewRadius > 0 {
+			view := g.state.filterState(gsMsg, p.Pos, cd.ViewRadius)
+			g.clients.Multicast([]uint32{cd.Id}, messages.New(messages.GameStateId, *view))
+		} else {
+			full = append(full, cd.Id)
+		}
+		return true
+	})
+	if len(full) > 0 {
+		g.clients.Multicast(full, messages.New(messages.GameStateId, *gsMsg))
+	}
+}
+
+/*
+ * sendExploredTiles sends to each player the tiles it revealed since the last
+ * game state
+ */
+func (g *Game) sendExploredTiles() {
+	g.state.forEachEntity(func(ent Entity) bool {
+		p, ok := ent.(*Player)
+		if !ok {
+			return true
+		}
+		if tiles := p.explored.TakeRevealed(); len(tiles) > 0 {
+			msg := messages.New(messages.ExploredId, messages.Explored{Tiles: tiles})
+			g.clients.Multicast([]uint32{p.Id()}, msg)
+		}
+		return true
+	})
+}
+
+/*
+ * postClientEvent posts an event originating from a client.
+ *
+ * While replaying, events coming from connected clients are ignored, as the
+ * client events are read from the replay file. While the game is paused, the
+ * events are queued until it's resumed, or dropped if so configured, except
+ * the players joining and leaving.
+ */
+func (g *Game) postClientEvent(evt *events.Event) {
+	if g.replayer != nil {
+		log.WithField("event", evt).Debug("Ignoring client event during replay")
+		return
+	}
+	if g.cfg.PauseEvents == PauseEventsDrop && g.Paused() &&
+		evt.Type != events.PlayerJoinId && evt.Type != events.PlayerLeaveId {
+		log.WithField("event", evt).Debug("Dropping client event during pause")
+		return
+	}
+	g.eventManager.PostEvent(evt)
+}
+
+/*
+ * registerEventHandlers subscribes the game event handlers to the event manager
+ */
+func (g *Game) registerEventHandlers() {
+	g.eventManager.Subscribe(events.PlayerJoinId, g.state.onPlayerJoin)
+	g.eventManager.Subscribe(events.PlayerLeaveId, g.state.onPlayerLeave)
+	g.eventManager.Subscribe(events.PlayerMoveId, g.state.onPlayerMove)
+	g.eventManager.Subscribe(events.PlayerBuildId, g.state.onPlayerBuild)
+	g.eventManager.Subscribe(events.PlayerRepairId, g.state.onPlayerRepair)
+	g.eventManager.Subscribe(events.PlayerAttackId, g.state.onPlayerAttack)
+	g.eventManager.Subscribe(events.PlayerShootId, g.state.onPlayerShoot)
+	g.eventManager.Subscribe(events.PlayerThrowId, g.state.onPlayerThrow)
+	g.eventManager.Subscribe(events.PlayerChatId, g.state.onPlayerChat)
+	g.eventManager.Subscribe(events.PlayerOperateId, g.state.onPlayerOperate)
+	g.eventManager.Subscribe(events.PlayerDeathId, g.state.onPlayerDeath)
+	g.eventManager.Subscribe(events.ZombieDeathId, g.state.onZombieDeath)
+	g.eventManager.Subscribe(events.ZombieDeathId, g.ai.OnZombieDeath)
+	g.eventManager.Subscribe(events.BuildingDestroyId, g.state.onBuildingDestroy)
+	g.eventManager.Subscribe(events.ItemPickupId, g.state.onItemPickup)
+
+	if g.recorder != nil {
+		g.registerRecorder()
+	}
+}
+
+/*
+ * registerRecorder subscribes the recorder to every client event, so that
+ * they get recorded at the tick they are processed
+ */
+func (g *Game) registerRecorder() {
+	for t := range replayEventTypes {
+		g.eventManager.Subscribe(t, func(evt *events.Event) {
+			g.recorder.record(g.tick, g.state.ids.Count(), evt)
+		})
+	}
+}
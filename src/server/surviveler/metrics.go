@@ -0,0 +1,278 @@
+/*
+ * Surviveler package
+ * runtime metrics
+ */
+package surviveler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/*
+ * Number of tick durations kept to compute the tick duration statistics
+ */
+const metricsWindowSize = 1000
+
+/*
+ * Minimum period between 2 warnings about the ticks of a kind overrunning
+ * their period
+ */
+const overrunWarnPeriod = time.Second
+
+/*
+ * durationWindow keeps the last durations in a ring buffer
+ */
+type durationWindow struct {
+	samples []time.Duration
+	next    int // index of the next sample to overwrite
+}
+
+func (dw *durationWindow) add(d time.Duration) {
+	if len(dw.samples) < metricsWindowSize {
+		dw.samples = append(dw.samples, d)
+		return
+	}
+	dw.samples[dw.next] = d
+	dw.next = (dw.next + 1) % metricsWindowSize
+}
+
+/*
+ * stats computes the duration statistics over the window
+ */
+func (dw *durationWindow) stats() DurationStats {
+	var ds DurationStats
+	if len(dw.samples) == 0 {
+		return ds
+	}
+	sorted := make([]time.Duration, len(dw.samples))
+	copy(sorted, dw.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	ds.Avg = sum / time.Duration(len(sorted))
+	ds.P50 = percentile(50)
+	ds.P95 = percentile(95)
+	ds.P99 = percentile(99)
+	ds.Max = sorted[len(sorted)-1]
+	return ds
+}
+
+/*
+ * DurationStats regroups statistics about a set of durations
+ */
+type DurationStats struct {
+	Avg, P50, P95, P99, Max time.Duration
+}
+
+/*
+ * MetricsSnapshot is a copy of the server metrics at a given instant
+ */
+type MetricsSnapshot struct {
+	LogicTicks      uint64        // number of logic ticks performed
+	LogicTick       DurationStats // logic tick duration statistics
+	LogicOverruns   uint64        // number of logic ticks that took longer than their period
+	SendTicks       uint64        // number of gamestate broadcasts performed
+	SendTick        DurationStats // send tick duration statistics
+	SendOverruns    uint64        // number of send ticks that took longer than their period
+	SkippedSends    uint64        // number of send ticks skipped to catch up
+	Entities        int           // number of entities in game
+	Clients         int           // number of connected clients
+	PathfindCalls   uint64        // number of path searches performed
+	PathfindRate    float64       // path searches per second, during the last second
+	DroppedMessages uint64        // number of messages missed by the clients, once per client
+}
+
+/*
+ * Metrics collects the runtime metrics of the server.
+ *
+ * Metrics are updated from the game loop, and can be safely read from any
+ * goroutine with Snapshot.
+ */
+type Metrics struct {
+	mutex         sync.Mutex
+	snap          MetricsSnapshot
+	logicTickDurs durationWindow
+	sendTickDurs  durationWindow
+	rateTime      time.Time // start of the current pathfinding rate period
+	rateCalls     uint64    // pathfinding calls at the start of the period
+}
+
+/*
+ * NewMetrics creates an empty metrics collector
+ */
+func NewMetrics() *Metrics {
+	return &Metrics{rateTime: time.Now()}
+}
+
+/*
+ * addLogicTick records a logic tick
+ */
+func (m *Metrics) addLogicTick(d time.Duration, overrun bool, entities int, pathfindCalls uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.snap.LogicTicks++
+	m.logicTickDurs.add(d)
+	if overrun {
+		m.snap.LogicOverruns++
+	}
+	m.snap.Entities = entities
+	m.snap.PathfindCalls = pathfindCalls
+
+	// refresh pathfinding rate every second
+	if elapsed := time.Since(m.rateTime); elapsed >= time.Second {
+		m.snap.PathfindRate = float64(pathfindCalls-m.rateCalls) / elapsed.Seconds()
+		m.rateCalls = pathfindCalls
+		m.rateTime = time.Now()
+	}
+}
+
+/*
+ * addSendTick records a gamestate broadcast
+ */
+func (m *Metrics) addSendTick(d time.Duration, overrun bool, clients int, dropped uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.snap.SendTicks++
+	m.sendTickDurs.add(d)
+	if overrun {
+		m.snap.SendOverruns++
+	}
+	m.snap.Clients = clients
+	m.snap.DroppedMessages = dropped
+}
+
+/*
+ * addSkippedSendTick records a send tick skipped to shed load
+ */
+func (m *Metrics) addSkippedSendTick() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.snap.SkippedSends++
+}
+
+/*
+ * Snapshot returns a copy of the current metrics
+ */
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	snap := m.snap
+	snap.LogicTick = m.logicTickDurs.stats()
+	snap.SendTick = m.sendTickDurs.stats()
+	return snap
+}
+
+/*
+ * WritePrometheus writes the metrics snapshot in the Prometheus text format
+ */
+func (s MetricsSnapshot) WritePrometheus(w io.Writer) error {
+	var err error
+	write := func(name, typ, help string, value interface{}) {
+		if err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n",
+			name, help, name, typ, name, value)
+	}
+	summary := func(name, help string, count uint64, ds DurationStats) {
+		if err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", name, help, name)
+		for _, q := range []struct {
+			quantile string
+			d        time.Duration
+		}{{"0.5", ds.P50}, {"0.95", ds.P95}, {"0.99", ds.P99}} {
+			if err == nil {
+				_, err = fmt.Fprintf(w, "%s{quantile=\"%s\"} %v\n", name, q.quantile, q.d.Seconds())
+			}
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s_count %v\n", name, count)
+		}
+	}
+
+	summary("surviveler_logic_tick_seconds", "Duration of the logic ticks.",
+		s.LogicTicks, s.LogicTick)
+	summary("surviveler_send_tick_seconds", "Duration of the gamestate broadcasts.",
+		s.SendTicks, s.SendTick)
+	write("surviveler_logic_tick_overruns_total", "counter", "Number of logic ticks that overran their period.", s.LogicOverruns)
+	write("surviveler_send_tick_overruns_total", "counter", "Number of gamestate broadcasts that overran their period.", s.SendOverruns)
+	write("surviveler_send_ticks_skipped_total", "counter", "Number of gamestate broadcasts skipped to catch up.", s.SkippedSends)
+	write("surviveler_entities", "gauge", "Number of entities in game.", s.Entities)
+	write("surviveler_clients", "gauge", "Number of connected clients.", s.Clients)
+	write("surviveler_pathfind_calls_total", "counter", "Number of path searches.", s.PathfindCalls)
+	write("surviveler_pathfind_rate", "gauge", "Path searches per second, during the last second.", s.PathfindRate)
+	write("surviveler_dropped_messages_total", "counter", "Number of messages missed by the clients, once per client.", s.DroppedMessages)
+	return err
+}
+
+/*
+ * String returns a human readable representation of the metrics snapshot
+ */
+func (s MetricsSnapshot) String() string {
+	durs := func(ds DurationStats) string {
+		return fmt.Sprintf("avg %v, p50 %v, p95 %v, p99 %v, max %v",
+			ds.Avg, ds.P50, ds.P95, ds.P99, ds.Max)
+	}
+	return fmt.Sprintf(
+		"logic ticks: %d (%s), %d overruns\n"+
+			"send ticks: %d (%s), %d overruns, %d skipped\n"+
+			"entities: %d\n"+
+			"clients: %d\n"+
+			"pathfinding: %d calls (%.1f/s)\n"+
+			"dropped messages: %d\n",
+		s.LogicTicks, durs(s.LogicTick), s.LogicOverruns,
+		s.SendTicks, durs(s.SendTick), s.SendOverruns, s.SkippedSends,
+		s.Entities, s.Clients,
+		s.PathfindCalls, s.PathfindRate,
+		s.DroppedMessages)
+}
+
+/*
+ * overrunWatch detects the ticks of a kind taking longer than their period,
+ * that is the game loop falling behind.
+ *
+ * It warns at most once per overrunWarnPeriod, with the number of overruns
+ * since the last warning, so that a struggling server doesn't flood the logs.
+ */
+type overrunWatch struct {
+	missed   int       // overruns since the last warning
+	lastWarn time.Time // time of the last warning
+}
+
+/*
+ * check reports whether a tick of given kind, lasting d, overran its period,
+ * and warns about it if the last warning is old enough
+ */
+func (ow *overrunWatch) check(kind string, d, period time.Duration, now time.Time) bool {
+	if d <= period {
+		return false
+	}
+	ow.missed++
+	if now.Sub(ow.lastWarn) >= overrunWarnPeriod {
+		log.WithFields(log.Fields{
+			"tick":     kind,
+			"duration": d,
+			"period":   period,
+			"overruns": ow.missed,
+		}).Warn("Tick overran its period, the game loop is falling behind")
+		ow.missed, ow.lastWarn = 0, now
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package surviveler
+
+import (
+	"bytes"
+	"server/events"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestMetrics_LogicTicks(t *testing.T) {
+	g := newTestGameFromAssets(t, testAssets)
+	g.state.gameTime = 720
+
+	id := g.state.allocEntityId()
+	g.PostEvent(events.NewEvent(events.PlayerJoinId,
+		events.PlayerJoin{Id: id, Type: uint8(TankEntity)}))
+	g.PostEvent(events.NewEvent(events.PlayerMoveId,
+		events.PlayerMove{Id: id, Xpos: 8.5, Ypos: 6.5}))
+	for i := 0; i < 10; i++ {
+		g.logicTick(10 * time.Millisecond)
+	}
+
+	snap := g.metrics.Snapshot()
+	if snap.LogicTicks != 10 {
+		t.Errorf("LogicTicks = %v, want 10", snap.LogicTicks)
+	}
+	if snap.Entities != 1 {
+		t.Errorf("Entities = %v, want 1", snap.Entities)
+	}
+	if snap.PathfindCalls != 1 {
+		t.Errorf("PathfindCalls = %v, want 1", snap.PathfindCalls)
+	}
+	if snap.LogicTick.Max <= 0 || snap.LogicTick.Avg > snap.LogicTick.Max {
+		t.Errorf("inconsistent logic tick durations: %+v", snap.LogicTick)
+	}
+
+	g.logicTick(10 * time.Millisecond)
+	if snap := g.metrics.Snapshot(); snap.LogicTicks != 11 {
+		t.Errorf("LogicTicks = %v, want 11", snap.LogicTicks)
+	}
+}
+
+func TestMetrics_DurationStats(t *testing.T) {
+	var dw durationWindow
+	for i := 1; i <= metricsWindowSize+100; i++ {
+		dw.add(time.Duration(i))
+	}
+	// the first 100 samples have been overwritten
+	ds := dw.stats()
+	want := DurationStats{Avg: 600, P50: 600, P95: 1050, P99: 1090, Max: 1100}
+	if ds != want {
+		t.Errorf("stats() = %+v, want %+v", ds, want)
+	}
+}
+
+func TestMetricsSnapshot_WritePrometheus(t *testing.T) {
+	m := NewMetrics()
+	m.addLogicTick(2*time.Millisecond, true, 12, 3)
+	m.addSendTick(time.Millisecond, false, 2, 5)
+	m.addSkippedSendTick()
+	snap := m.Snapshot()
+	snap.PathfindRate = 2.5
+
+	var buf bytes.Buffer
+	if err := snap.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus() error = %v", err)
+	}
+	for _, line := range []string{
+		"surviveler_logic_tick_seconds_count 1",
+		`surviveler_logic_tick_seconds{quantile="0.99"} 0.002`,
+		"surviveler_send_tick_seconds_count 1",
+		"surviveler_entities 12",
+		"surviveler_clients 2",
+		"surviveler_pathfind_calls_total 3",
+		"surviveler_pathfind_rate 2.5",
+		"surviveler_dropped_messages_total 5",
+		"surviveler_logic_tick_overruns_total 1",
+		"surviveler_send_tick_overruns_total 0",
+		"surviveler_send_ticks_skipped_total 1",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("metrics output doesn't contain %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+/*
+ * slowEntity is an entity whose update takes a while
+ */
+type slowEntity struct {
+	Entity
+	sleep time.Duration
+}
+
+func (se *slowEntity) Update(dt time.Duration) {
+	time.Sleep(se.sleep)
+	se.Entity.Update(dt)
+}
+
+/*
+ * warnHook captures the warnings logged
+ */
+type warnHook struct {
+	entries []*log.Entry
+}
+
+func (h *warnHook) Levels() []log.Level { return []log.Level{log.WarnLevel} }
+
+func (h *warnHook) Fire(e *log.Entry) error {
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func TestGame_LogicTickOverrun(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.cfg.LogicTickPeriod = 1
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	g.state.entities[z.Id()] = &slowEntity{Entity: z, sleep: 5 * time.Millisecond}
+
+	hook := &warnHook{}
+	hooks := log.StandardLogger().Hooks
+	log.StandardLogger().Hooks = make(log.LevelHooks)
+	log.AddHook(hook)
+	defer func() { log.StandardLogger().Hooks = hooks }()
+
+	g.logicTick(time.Millisecond)
+	g.logicTick(time.Millisecond)
+
+	// a single warning, the next one is held back
+	if len(hook.entries) != 1 || hook.entries[0].Data["tick"] != "logic" {
+		t.Fatalf("warnings = %+v, want a single logic tick overrun warning", hook.entries)
+	}
+	snap := g.metrics.Snapshot()
+	if snap.LogicOverruns != 2 {
+		t.Errorf("LogicOverruns = %v, want 2", snap.LogicOverruns)
+	}
+	// the next send tick is skipped after an overrun, but never 2 in a row
+	if !g.shedSendTick() {
+		t.Errorf("shedSendTick() = false after an overrun, want true")
+	}
+	g.logicTick(time.Millisecond)
+	if g.shedSendTick() {
+		t.Errorf("shedSendTick() = true, want send ticks not to be skipped in a row")
+	}
+	if g.shedSendTick() {
+		t.Errorf("shedSendTick() = true without overrun, want false")
+	}
+	if snap := g.metrics.Snapshot(); snap.SkippedSends != 1 {
+		t.Errorf("SkippedSends = %v, want 1", snap.SkippedSends)
+	}
+
+	// the watch warns again once the warning period has elapsed
+	ow := g.logicWatch
+	if !ow.check("logic", 2*time.Millisecond, time.Millisecond, ow.lastWarn.Add(overrunWarnPeriod)) {
+		t.Errorf("check() = false, want an overrun")
+	}
+	if len(hook.entries) != 2 || hook.entries[1].Data["overruns"] != 3 {
+		t.Errorf("warnings = %+v, want a 2nd warning reporting 3 overruns", hook.entries)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Surviveler package
+ * entity stats modifiers, buffs and debuffs
+ */
+package surviveler
+
+import (
+	"time"
+
+	"github.com/aurelien-rainone/math32"
+)
+
+/*
+ * Stat is an entity stat that modifiers can alter
+ */
+type Stat uint8
+
+const (
+	SpeedStat   Stat = iota // movement speed
+	DamageStat              // damage dealt by the hits
+	DefenseStat             // damage taken is divided by it
+)
+
+// stats are never brought below this fraction of their raw value
+const minModifierFactor = 0.05
+
+/*
+ * How the modifiers of a same stat stack
+ */
+const (
+	ModifierStackingAdd       = "add"       // the bonuses and maluses add up, a +20% and a +30% make a +50%
+	ModifierStackingMultiply  = "multiply"  // the factors multiply, a +20% and a +30% make a +56%
+	ModifierStackingStrongest = "strongest" // only the strongest bonus and the strongest malus apply
+)
+
+/*
+ * Modifier multiplies a stat of an entity, permanently or for a while
+ */
+type Modifier struct {
+	Source   string        // what applies the modifier, an item, a zone, etc.
+	Stat     Stat          // modified stat
+	Factor   float32       // multiplies the stat, above 1 for a buff, below for a debuff
+	Duration time.Duration // time during which the modifier applies, 0 for ever
+}
+
+/*
+ * activeModifier is a modifier applying to an entity
+ */
+type activeModifier struct {
+	Modifier
+	left time.Duration // time left, for timed modifiers
+}
+
+/*
+ * Modifiers is the component holding the modifiers applying to the stats of
+ * an entity.
+ *
+ * A modifier replaces the one already applied by the same source on the same
+ * stat, the modifiers of different sources stack according to the stacking
+ * rule. The systems reading a stat must go through Apply. A nil Modifiers
+ * leaves the stats unmodified.
+ */
+type Modifiers struct {
+	Stacking string // ModifierStackingAdd, ModifierStackingMultiply or ModifierStackingStrongest
+	mods     []activeModifier
+}
+
+/*
+ * NewModifiers creates a component without modifiers, stacking them
+ * according to stacking
+ */
+func NewModifiers(stacking string) *Modifiers {
+	return &Modifiers{Stacking: stacking}
+}
+
+/*
+ * Add applies a modifier, in place of the one of the same source on the same
+ * stat if any
+ */
+func (ms *Modifiers) Add(mod Modifier) {
+	am := activeModifier{Modifier: mod, left: mod.Duration}
+	for i := range ms.mods {
+		if ms.mods[i].Source == mod.Source && ms.mods[i].Stat == mod.Stat {
+			ms.mods[i] = am
+			return
+		}
+	}
+	ms.mods = append(ms.mods, am)
+}
+
+/*
+ * Remove removes the modifiers applied by source, on any stat
+ */
+func (ms *Modifiers) Remove(source string) {
+	kept := ms.mods[:0]
+	for _, m := range ms.mods {
+		if m.Source != source {
+			kept = append(kept, m)
+		}
+	}
+	ms.mods = kept
+}
+
+/*
+ * Factor returns the factor by which the modifiers multiply stat, 1 if none
+ * applies. It's never below minModifierFactor.
+ */
+func (ms *Modifiers) Factor(stat Stat) float32 {
+	if ms == nil {
+		return 1
+	}
+	var (
+		sum          float32
+		prod         = float32(1)
+		bonus, malus = float32(1), float32(1)
+	)
+	for _, m := range ms.mods {
+		if m.Stat != stat {
+			continue
+		}
+		sum += m.Factor - 1
+		prod *= m.Factor
+		bonus = math32.Max(bonus, m.Factor)
+		malus = math32.Min(malus, m.Factor)
+	}
+	var f float32
+	switch ms.Stacking {
+	case ModifierStackingAdd:
+		f = 1 + sum
+	case ModifierStackingStrongest:
+		f = bonus * malus
+	default:
+		f = prod
+	}
+	return math32.Max(f, minModifierFactor)
+}
+
+/*
+ * Apply returns the effective value of a stat, out of its raw value
+ */
+func (ms *Modifiers) Apply(stat Stat, raw float32) float32 {
+	return raw * ms.Factor(stat)
+}
+
+/*
+ * Tick lets dt elapse, removing the expired modifiers
+ */
+func (ms *Modifiers) Tick(dt time.Duration) {
+	kept := ms.mods[:0]
+	for _, m := range ms.mods {
+		if m.Duration > 0 {
+			if m.left -= dt; m.left <= 0 {
+				continue
+			}
+		}
+		kept = append(kept, m)
+	}
+	ms.mods = kept
+}
+
+/*
+ * takenDamage returns the damage an entity having the given modifiers takes
+ * out of a hit of damage, reduced by its defense
+ */
+func (ms *Modifiers) takenDamage(damage float32) float32 {
+	return damage / ms.Factor(DefenseStat)
+}
+
+/*
+ * dealtDamage returns the damage of a hit dealt by entity e, modified by its
+ * damage modifiers if it has any
+ */
+func dealtDamage(e Entity, damage float32) float32 {
+	var ms *Modifiers
+	if GetComponent(e, &ms) {
+		return ms.Apply(DamageStat, damage)
+	}
+	return damage
+}
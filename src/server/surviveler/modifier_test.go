@@ -0,0 +1,103 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+func TestModifiers_Stacking(t *testing.T) {
+	mods := []Modifier{
+		{Source: "coffee", Stat: SpeedStat, Factor: 1.2},
+		{Source: "boots", Stat: SpeedStat, Factor: 1.3},
+		{Source: "mud", Stat: SpeedStat, Factor: 0.5},
+		{Source: "rage", Stat: DamageStat, Factor: 2},
+	}
+	tests := []struct {
+		stacking string
+		want     float32
+	}{
+		{ModifierStackingAdd, 1},
+		{ModifierStackingMultiply, 0.78},
+		{ModifierStackingStrongest, 0.65},
+	}
+	for _, tt := range tests {
+		ms := NewModifiers(tt.stacking)
+		for _, mod := range mods {
+			ms.Add(mod)
+		}
+		if got := ms.Factor(SpeedStat); math32.Abs(got-tt.want) > 1e-5 {
+			t.Errorf("%s: speed factor = %v, want %v", tt.stacking, got, tt.want)
+		}
+		if got := ms.Factor(DefenseStat); got != 1 {
+			t.Errorf("%s: unmodified defense factor = %v, want 1", tt.stacking, got)
+		}
+	}
+
+	// a source doesn't stack with itself
+	ms := NewModifiers(ModifierStackingMultiply)
+	ms.Add(Modifier{Source: "coffee", Stat: SpeedStat, Factor: 1.2})
+	ms.Add(Modifier{Source: "coffee", Stat: SpeedStat, Factor: 1.5})
+	if got := ms.Factor(SpeedStat); got != 1.5 {
+		t.Errorf("speed factor after a refreshed buff = %v, want 1.5", got)
+	}
+	ms.Remove("coffee")
+	if got := ms.Factor(SpeedStat); got != 1 {
+		t.Errorf("speed factor after removal = %v, want 1", got)
+	}
+
+	// stats never drop to nothing
+	ms = NewModifiers(ModifierStackingAdd)
+	ms.Add(Modifier{Source: "glue", Stat: SpeedStat, Factor: 0.1})
+	ms.Add(Modifier{Source: "mud", Stat: SpeedStat, Factor: 0.2})
+	if got := ms.Factor(SpeedStat); got != minModifierFactor {
+		t.Errorf("speed factor = %v, want %v", got, minModifierFactor)
+	}
+	if got := (*Modifiers)(nil).Apply(SpeedStat, 2); got != 2 {
+		t.Errorf("nil modifiers speed = %v, want 2", got)
+	}
+}
+
+func TestPlayer_SpeedBuff(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	org := d2.Vec2{1.5, 2.5}
+	p := addTestPlayer(g, TankEntity, org)
+	p.Move(Path{d2.Vec2{7.5, 2.5}})
+	p.modifiers.Add(Modifier{Source: "coffee", Stat: SpeedStat, Factor: 1.5, Duration: 250 * time.Millisecond})
+
+	// 2 ticks at 3 units per second, then back to 2 units per second
+	for i, want := range []float32{0.3, 0.6, 0.8} {
+		tick(g, 100*time.Millisecond)
+		if dist := p.Pos.Sub(org).Len(); math32.Abs(dist-want) > 1e-4 {
+			t.Errorf("tick %d: player covered %v, want %v", i, dist, want)
+		}
+	}
+	if v := p.Velocity().Len(); math32.Abs(v-2) > 1e-4 {
+		t.Errorf("player velocity after the buff = %v, want 2", v)
+	}
+	if n := g.clients.Cheats(p.Id()); n != 0 {
+		t.Errorf("buffed player flagged %d times", n)
+	}
+}
+
+func TestDealHit_Modifiers(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	p := addTestPlayer(g, TankEntity, d2.Vec2{2.5, 2.5})
+	power := float32(z.combat.Power)
+
+	z.modifiers.Add(Modifier{Source: "rage", Stat: DamageStat, Factor: 1.5})
+	dealHit(g.state.world, z, p, z.combat, MeleeDamage)
+	if got, want := p.health.Total-p.health.Cur, 1.5*power; math32.Abs(got-want) > 1e-4 {
+		t.Errorf("player lost %v HP to an enraged zombie, want %v", got, want)
+	}
+
+	p.health.Cur = p.health.Total
+	p.modifiers.Add(Modifier{Source: "armor", Stat: DefenseStat, Factor: 3})
+	dealHit(g.state.world, z, p, z.combat, MeleeDamage)
+	if got, want := p.health.Total-p.health.Cur, 0.5*power; math32.Abs(got-want) > 1e-4 {
+		t.Errorf("armored player lost %v HP, want %v", got, want)
+	}
+}
@@ -5,16 +5,22 @@
 package surviveler
 
 import (
+	"server/actions"
 	"time"
 
 	"github.com/aurelien-rainone/gogeo/f32/d2"
 	"github.com/aurelien-rainone/math32"
 )
 
-/*
- * Number of next waypoints to return in NextWaypoints
- */
-const maxNextWaypoints = 2
+const (
+	// default distance under which a waypoint is considered reached
+	DefaultArrivalTolerance = 1e-3
+	// minimum speed factor when slowing down, so that the destination is
+	// eventually reached
+	minSlowdownFactor = 0.2
+	// collision radius of the entities whose data don't specify one
+	DefaultEntityRadius = 0.5
+)
 
 /*
  * Movable is the *moving part* of an entity.
@@ -24,9 +30,16 @@ const maxNextWaypoints = 2
  * alongside it
  */
 type Movable struct {
-	Pos       d2.Vec2 // current position
-	Speed     float32 // speed
-	waypoints *VecStack
+	Pos            d2.Vec2    // current position
+	Speed          float32    // speed
+	Tolerance      float32    // distance under which a waypoint is considered reached
+	SlowdownRadius float32    // distance to the destination under which to slow down, 0 to disable
+	Heading        float32    // direction faced, angle in radians from the x axis
+	Radius         float32    // collision radius, half the side of the bounding box
+	tileSpeed      float32    // speed factor of the tile the movable is on, see applyTileEffects
+	modifiers      *Modifiers // speed modifiers of the entity, nil for none
+	waypoints      *VecStack
+	queryBuf       []Entity // reused by the spatial queries of canMoveTo
 }
 
 /*
@@ -36,6 +49,9 @@ func NewMovable(pos d2.Vec2, speed float32) *Movable {
 	return &Movable{
 		Pos:       pos,
 		Speed:     speed,
+		Tolerance: DefaultArrivalTolerance,
+		Radius:    DefaultEntityRadius,
+		tileSpeed: 1,
 		waypoints: newVecStack(),
 	}
 }
@@ -46,28 +62,81 @@ func (me *Movable) findMicroPath(wp d2.Vec2) (path Path, found bool) {
 	return Path{wp}, true
 }
 
+/*
+ * step computes the position reached by moving from org towards the next
+ * waypoint during dt, and indicates if the waypoint is reached.
+ *
+ * The waypoint is reached if org is within the arrival tolerance, in which
+ * case org is returned, or if it would be passed, in which case the waypoint
+ * itself is returned so that there's never any overshoot.
+ */
+func (me *Movable) step(org, dst d2.Vec2, dt time.Duration) (d2.Vec2, bool) {
+	dir := dst.Sub(org)
+	remaining := dir.Len()
+	if remaining <= me.Tolerance || math32.IsNaN(remaining) {
+		return org, true
+	}
+
+	// compute distance to be covered as time * speed
+	distance := float32(dt.Seconds()) * me.speedAt(remaining)
+	if distance >= remaining-me.Tolerance {
+		return dst, true
+	}
+	return org.Add(dir.Scale(distance / remaining)), false
+}
+
+/*
+ * speedAt returns the speed of the movable at a distance remaining from the
+ * next waypoint, slowed down when it's the destination and it's close enough
+ */
+func (me *Movable) speedAt(remaining float32) float32 {
+	speed := me.effectiveSpeed()
+	if me.SlowdownRadius > 0 && me.waypoints.Len() == 1 && remaining < me.SlowdownRadius {
+		// ease into the destination
+		speed *= math32.Max(remaining/me.SlowdownRadius, minSlowdownFactor)
+	}
+	return speed
+}
+
+/*
+ * effectiveSpeed returns the speed of the movable on the tile it's on, slow
+ * tiles slowing it down, after its speed modifiers
+ */
+func (me *Movable) effectiveSpeed() float32 {
+	return me.modifiers.Apply(SpeedStat, me.Speed) * me.tileSpeed
+}
+
+/*
+ * Velocity returns the current velocity of the movable: its speed, in the
+ * direction it's heading. It's null once the destination is reached.
+ */
+func (me *Movable) Velocity() d2.Vec2 {
+	dst, exists := me.waypoints.Peek()
+	if !exists {
+		return d2.Vec2{0, 0}
+	}
+	speed := me.speedAt(dst.Sub(me.Pos).Len())
+	return d2.Vec2{math32.Cos(me.Heading), math32.Sin(me.Heading)}.Scale(speed)
+}
+
+/*
+ * velocityHint returns the velocity sent in the state of the movable, so that
+ * the clients can extrapolate its position between game states. It's null if
+ * sending velocities isn't enabled or the entity isn't moving.
+ */
+func (me *Movable) velocityHint(enabled, moving bool) (xvel, yvel float32) {
+	if !enabled || !moving {
+		return 0, 0
+	}
+	v := me.Velocity()
+	return v[0], v[1]
+}
+
 func (me Movable) ComputeMove(org d2.Vec2, dt time.Duration) d2.Vec2 {
 	// update position on the player path
 	if dst, exists := me.waypoints.Peek(); exists {
-		// compute distance to be covered as time * speed
-		distance := float32(dt.Seconds()) * me.Speed
-		// compute translation and direction vectors
-		dir := dst.Sub(org)
-		b := dir.Len()
-		dir.Normalize()
-
-		// compute next position
-		pos := org.Add(dir.Scale(distance))
-		a := pos.Sub(org).Len()
-
-		// check against edge-cases
-		isNan := math32.IsNaN(a) || math32.IsNaN(b) || math32.IsNaN(dir.Len()) || math32.Abs(a-b) < 1e-3
-
-		if a > b || isNan {
-			return dst
-		} else {
-			return pos
-		}
+		pos, _ := me.step(org, dst, dt)
+		return pos
 	}
 	return org
 }
@@ -80,41 +149,11 @@ func (me Movable) ComputeMove(org d2.Vec2, dt time.Duration) d2.Vec2 {
 func (me *Movable) Move(dt time.Duration) (hasMoved bool) {
 	// update position on the player path
 	if dst, exists := me.waypoints.Peek(); exists {
-		// compute distance to be covered as time * speed
-		distance := float32(dt.Seconds()) * me.Speed
-
-		for {
-			// compute translation and direction vectors
-			dir := dst.Sub(me.Pos)
-			b := dir.Len()
-			dir.Normalize()
-
-			// compute new position
-			pos := me.Pos.Add(dir.Scale(distance))
-			a := pos.Sub(me.Pos).Len()
-
-			// check against edge-cases
-			isNan := math32.IsNaN(a) || math32.IsNaN(b) || math32.IsNaN(dir.Len()) || math32.Abs(a-b) < 1e-3
-
-			hasMoved = true
-			if a > b || isNan {
-				me.Pos = dst
-				if _, exists = me.waypoints.Peek(); exists {
-					me.waypoints.Pop()
-					break
-				} else {
-					break
-				}
-
-				if isNan {
-					break
-				}
-
-				distance = a - b
-			} else {
-				me.Pos = pos
-				break
-			}
+		pos, reached := me.step(me.Pos, dst, dt)
+		hasMoved = true
+		me.moveTo(pos)
+		if reached {
+			me.waypoints.Pop()
 		}
 	}
 	return
@@ -137,18 +176,142 @@ func (me *Movable) SetPath(path Path) {
 	}
 }
 
-func (me *Movable) NextWaypoints() Path {
-	path := make(Path, maxNextWaypoints)
-	for i, wp := range me.waypoints.PeekN(maxNextWaypoints) {
-		path[i] = wp
+/*
+ * NextWaypoints returns at max the n next waypoints, from the closest to the
+ * farthest, or the whole remaining path if n is negative
+ */
+func (me *Movable) NextWaypoints(n int) Path {
+	if n < 0 {
+		n = me.waypoints.Len()
+	}
+	return me.waypoints.PeekN(n)
+}
+
+/*
+ * moveAction returns the payload of the move action of the movable, including
+ * at max its n next waypoints (see NextWaypoints)
+ */
+func (me *Movable) moveAction(n int) actions.Move {
+	wps := me.NextWaypoints(n)
+	move := actions.Move{
+		Speed: me.Speed,
+		Path:  make([]actions.Waypoint, len(wps)),
+	}
+	for i, wp := range wps {
+		move.Path[i] = actions.Waypoint{Xpos: wp[0], Ypos: wp[1]}
+	}
+	return move
+}
+
+/*
+ * moveTo sets the movable position, facing the direction of the move
+ */
+func (me *Movable) moveTo(pos d2.Vec2) {
+	me.FaceTowards(pos)
+	me.Pos = pos
+}
+
+/*
+ * FaceTowards turns the movable so that it faces pt. The heading is left
+ * unchanged if pt is the current position.
+ */
+func (me *Movable) FaceTowards(pt d2.Vec2) {
+	dir := pt.Sub(me.Pos)
+	if dir.LenSqr() > 1e-12 {
+		me.Heading = math32.Atan2(dir[1], dir[0])
 	}
-	return path
 }
 
 func (me *Movable) HasReachedDestination() bool {
 	return me.waypoints.Len() == 0
 }
 
+/*
+ * Rectangle returns the bounding box of the movable
+ */
 func (me *Movable) Rectangle() d2.Rectangle {
-	return d2.RectFromCircle(me.Pos, 0.5)
+	return me.boundsAt(me.Pos)
+}
+
+/*
+ * boundsAt returns the bounding box the movable would have at pos
+ */
+func (me *Movable) boundsAt(pos d2.Vec2) d2.Rectangle {
+	return d2.RectFromCircle(pos, me.Radius)
+}
+
+/*
+ * setCollisionRadius sets the collision radius of an entity having a Movable
+ * component, from its entity data
+ */
+func setCollisionRadius(e Entity, data *EntityData) {
+	var mv *Movable
+	if data.Radius > 0 && GetComponent(e, &mv) {
+		mv.Radius = data.Radius
+	}
+}
+
+/*
+ * MoveOrSlide moves the movable along its path, resolving collisions with the
+ * walls and the obstacles of the world.
+ *
+ * self is the entity the movable is a component of, isObstacle tells which
+ * entities block its way. If moving would create a collision, the movable
+ * tries to slide along the obstacle by only moving on one axis, starting with
+ * the one on which it moves the most.
+ *
+ * MoveOrSlide returns false if the movable is blocked and couldn't move at
+ * all, and the entity that obstructed the direct move, if any.
+ */
+func (me *Movable) MoveOrSlide(w *World, self Entity, dt time.Duration,
+	isObstacle EntityFilter) (moved bool, obstacle Entity) {
+	nextPos := me.ComputeMove(me.Pos, dt)
+	obstacle, free := me.canMoveTo(w, self, nextPos, isObstacle)
+	if free {
+		me.Move(dt)
+		return true, nil
+	}
+
+	// try to slide along the obstacle
+	delta := nextPos.Sub(me.Pos)
+	slides := []d2.Vec2{{delta[0], 0}, {0, delta[1]}}
+	if math32.Abs(delta[1]) > math32.Abs(delta[0]) {
+		slides[0], slides[1] = slides[1], slides[0]
+	}
+	for _, slide := range slides {
+		if slide.Len() < 1e-3 {
+			continue
+		}
+		pos := me.Pos.Add(slide)
+		if _, free = me.canMoveTo(w, self, pos, isObstacle); free {
+			me.moveTo(pos)
+			return true, obstacle
+		}
+	}
+	return false, obstacle
+}
+
+/*
+ * canMoveTo indicates if the movable can move to pos without colliding with
+ * a wall or an obstacle, nor sticking out of the world, and returns the
+ * colliding obstacle, if any.
+ *
+ * Obstacles already overlapping the movable are ignored, so that overlapping
+ * entities can move apart.
+ */
+func (me *Movable) canMoveTo(w *World, self Entity, pos d2.Vec2,
+	isObstacle EntityFilter) (obstacle Entity, free bool) {
+	if t, ok := w.TileAtWorldVec(pos); !ok || t.Kind != KindWalkable || !w.Contains(me.boundsAt(pos)) {
+		return nil, false
+	}
+	curBB := me.Rectangle()
+	me.queryBuf = w.AABBSpatialQueryInto(me.boundsAt(pos), me.queryBuf)
+	for _, e := range me.queryBuf {
+		if e == self || !isObstacle(e) || e.Rectangle().Overlaps(curBB) {
+			continue
+		}
+		obstacle = e
+		break
+	}
+	return obstacle, obstacle == nil
 }
@@ -0,0 +1,291 @@
+package surviveler
+
+import (
+	"server/actions"
+	"server/messages"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+func TestMovable_StopsAtWaypointWithLargeTimestep(t *testing.T) {
+	mv := NewMovable(d2.Vec2{0, 0}, 2)
+	// path is reversed, the last waypoint is the first to be reached
+	mv.SetPath(Path{d2.Vec2{3, 4}, d2.Vec2{3, 0}})
+
+	if next := mv.ComputeMove(mv.Pos, 10*time.Second); !next.Approx(d2.Vec2{3, 0}) {
+		t.Errorf("ComputeMove() = %v, want %v", next, d2.Vec2{3, 0})
+	}
+	mv.Move(10 * time.Second)
+	if !mv.Pos.Approx(d2.Vec2{3, 0}) {
+		t.Fatalf("position = %v, want the first waypoint %v", mv.Pos, d2.Vec2{3, 0})
+	}
+	mv.Move(10 * time.Second)
+	if !mv.Pos.Approx(d2.Vec2{3, 4}) || !mv.HasReachedDestination() {
+		t.Fatalf("position = %v, want the destination %v", mv.Pos, d2.Vec2{3, 4})
+	}
+
+	// once arrived, it stays there
+	if mv.Move(10 * time.Second); !mv.Pos.Approx(d2.Vec2{3, 4}) {
+		t.Errorf("position = %v after arrival, want %v", mv.Pos, d2.Vec2{3, 4})
+	}
+}
+
+func TestMovable_ArrivalTolerance(t *testing.T) {
+	mv := NewMovable(d2.Vec2{0, 0}, 1)
+	mv.Tolerance = 0.5
+	mv.SetPath(Path{d2.Vec2{0.4, 0}})
+
+	mv.Move(10 * time.Millisecond)
+	if !mv.HasReachedDestination() {
+		t.Errorf("destination within tolerance should be reached")
+	}
+	if !mv.Pos.Approx(d2.Vec2{0, 0}) {
+		t.Errorf("position = %v, the movable shouldn't have moved", mv.Pos)
+	}
+}
+
+func TestMovable_SlowdownWithoutOvershoot(t *testing.T) {
+	dst := d2.Vec2{5, 0}
+	mv := NewMovable(d2.Vec2{0, 0}, 4)
+	mv.SlowdownRadius = 2
+	mv.SetPath(Path{dst})
+
+	var lastStep float32
+	for i := 0; i < 100 && !mv.HasReachedDestination(); i++ {
+		org := mv.Pos
+		mv.Move(200 * time.Millisecond)
+		if mv.Pos[0] > dst[0] {
+			t.Fatalf("position %v overshot the destination %v", mv.Pos, dst)
+		}
+		step := mv.Pos.Sub(org).Len()
+		if dst.Sub(org).Len() < mv.SlowdownRadius && step > lastStep+1e-3 {
+			t.Fatalf("movable accelerated while slowing down: step %v after %v", step, lastStep)
+		}
+		lastStep = step
+	}
+	if !mv.HasReachedDestination() || !mv.Pos.Approx(dst) {
+		t.Fatalf("movable at %v didn't stop at the destination %v", mv.Pos, dst)
+	}
+	if lastStep >= 4*0.2 {
+		t.Errorf("last step = %v, should have slowed down", lastStep)
+	}
+}
+
+func TestMovable_Heading(t *testing.T) {
+	mv := NewMovable(d2.Vec2{0, 0}, 1)
+	mv.SetPath(Path{d2.Vec2{-1, 1}, d2.Vec2{0, 1}, d2.Vec2{1, 1}, d2.Vec2{1, 0}})
+
+	// east, north, west, then stays facing west once arrived
+	for _, want := range []float32{0, math32.Pi / 2, math32.Pi, math32.Pi, math32.Pi} {
+		mv.Move(time.Second)
+		if math32.Abs(mv.Heading-want) > 1e-4 {
+			t.Errorf("heading at %v = %v, want %v", mv.Pos, mv.Heading, want)
+		}
+	}
+
+	mv.FaceTowards(d2.Vec2{-2, 0})
+	if want := -3 * math32.Pi / 4; math32.Abs(mv.Heading-want) > 1e-4 {
+		t.Errorf("heading = %v, want %v", mv.Heading, want)
+	}
+}
+
+func TestMovable_NextWaypoints(t *testing.T) {
+	mv := NewMovable(d2.Vec2{0, 0}, 1)
+	mv.SetPath(Path{d2.Vec2{3, 3}, d2.Vec2{2, 2}, d2.Vec2{1, 1}})
+
+	tests := []struct {
+		n    int
+		want Path
+	}{
+		{0, Path{}},
+		{2, Path{{1, 1}, {2, 2}}},
+		{5, Path{{1, 1}, {2, 2}, {3, 3}}},
+		{-1, Path{{1, 1}, {2, 2}, {3, 3}}},
+	}
+	for _, tt := range tests {
+		got := mv.NextWaypoints(tt.n)
+		if len(got) != len(tt.want) {
+			t.Errorf("NextWaypoints(%d) = %v, want %v", tt.n, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !got[i].Approx(tt.want[i]) {
+				t.Errorf("NextWaypoints(%d) = %v, want %v", tt.n, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestMovable_MoveStateWaypoints(t *testing.T) {
+	path := Path{{7.5, 3.5}, {6.5, 3.5}, {5.5, 3.5}, {4.5, 3.5}, {3.5, 2.5}}
+
+	tests := []struct {
+		waypoints int
+		want      int
+	}{
+		{0, 0},
+		{2, 2},
+		{3, 3},
+		{-1, len(path)},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, openRoom...)
+		g.cfg.PlayerWaypoints = tt.waypoints
+		g.cfg.ZombieWaypoints = tt.waypoints
+		p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+		p.Move(path)
+		z := addTestZombie(g, d2.Vec2{1.5, 3.5})
+		z.curState = walkingState
+		z.SetPath(path)
+
+		sent := []messages.Action{p.State().(PlayerState).Action, z.State().(MobileEntityState).Action}
+		for i, ent := range []Entity{p, z} {
+			move := sent[i].Move
+			if sent[i].Type != actions.MoveId || move == nil {
+				t.Fatalf("%T action = %#v, want a move", ent, sent[i])
+			}
+			if len(move.Path) != tt.want {
+				t.Errorf("%T with %d waypoints configured sent %d waypoints, want %d",
+					ent, tt.waypoints, len(move.Path), tt.want)
+				continue
+			}
+			if tt.want > 0 && (move.Path[0] != actions.Waypoint{Xpos: 3.5, Ypos: 2.5}) {
+				t.Errorf("%T first waypoint = %v, want the closest one", ent, move.Path[0])
+			}
+		}
+	}
+}
+
+func TestZombie_FacesAttackTarget(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{4.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+
+	// walking east, toward the player
+	for i := 0; i < 10 && z.curState != walkingState; i++ {
+		tick(g, 20*time.Millisecond)
+	}
+	tick(g, 20*time.Millisecond)
+	if heading := z.State().(MobileEntityState).Heading; math32.Abs(heading) > 1e-4 {
+		t.Errorf("walking zombie heading = %v, want 0", heading)
+	}
+
+	// the player comes from the south
+	teleport(g, p, d2.Vec2{z.Pos[0], z.Pos[1] + 1})
+	z.target, z.curState = p, attackingState
+	tick(g, 20*time.Millisecond)
+	if z.curState != attackingState {
+		t.Fatalf("zombie state = %v, want attacking", z.curState)
+	}
+	if heading := z.State().(MobileEntityState).Heading; math32.Abs(heading-math32.Pi/2) > 1e-4 {
+		t.Errorf("attacking zombie heading = %v, want %v", heading, math32.Pi/2)
+	}
+}
+
+func TestEntity_BoundingBoxes(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.state.EntityData(ZombieEntity).Radius = 0.4
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+	z := addTestZombie(g, d2.Vec2{3.5, 1.5})
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{5.5, 1.5})
+
+	tests := []struct {
+		name   string
+		ent    Entity
+		radius float32
+	}{
+		{"player, default radius", p, DefaultEntityRadius},
+		{"zombie, radius from its data", z, 0.4},
+		{"building", b, buildingRadius},
+	}
+	for _, tt := range tests {
+		want := d2.RectFromCircle(tt.ent.Position(), tt.radius)
+		if bb := tt.ent.Rectangle(); !bb.Eq(want) {
+			t.Errorf("%s: Rectangle() = %v, want %v", tt.name, bb, want)
+		}
+	}
+
+	// the spatial index knows the zombie by its actual footprint
+	near := d2.RectFromCircle(d2.Vec2{3.5, 1.5 + 0.45}, 0.01)
+	if n := g.state.World().AABBSpatialQuery(near).Len(); n != 0 {
+		t.Errorf("%d entities found just out of the zombie footprint, want 0", n)
+	}
+}
+
+func TestMovable_VelocityHint(t *testing.T) {
+	g := newTestGame(t, newTestRoom(21)...)
+	g.cfg.SendVelocities = true
+	p := addTestPlayer(g, TankEntity, d2.Vec2{2.5, 2.5})
+	velocity := func() d2.Vec2 {
+		s := p.State().(PlayerState)
+		return d2.Vec2{s.Xvel, s.Yvel}
+	}
+	if v := velocity(); v.Len() != 0 {
+		t.Errorf("idle player velocity = %v, want null", v)
+	}
+
+	// moving diagonally, the velocity matches the actual displacement
+	const dt = 50 * time.Millisecond
+	p.Move(Path{d2.Vec2{14.5, 11.5}})
+	tick(g, dt)
+	v, org := velocity(), d2.Vec2{p.Pos[0], p.Pos[1]}
+	tick(g, dt)
+	moved := p.Pos.Sub(org).Scale(float32(time.Second / dt))
+	if !v.Approx(moved) {
+		t.Errorf("player velocity = %v, want %v", v, moved)
+	}
+	if dir, want := v.Scale(1/v.Len()), (d2.Vec2{0.8, 0.6}); !dir.Approx(want) {
+		t.Errorf("velocity direction = %v, want %v", dir, want)
+	}
+	if math32.Abs(v.Len()-p.Speed) > 1e-3 {
+		t.Errorf("velocity magnitude = %v, want the player speed %v", v.Len(), p.Speed)
+	}
+
+	// not sent unless enabled
+	g.cfg.SendVelocities = false
+	if v := velocity(); v.Len() != 0 {
+		t.Errorf("velocity = %v with velocities disabled, want null", v)
+	}
+}
+
+func TestMovable_PlayerAndZombieArrival(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	g.cfg.ArrivalTolerance, g.cfg.SlowdownRadius = 0.1, 1.5
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{16.5, 1.5})
+
+	// the players slow down when approaching their destination
+	dst := d2.Vec2{8.5, 2.5}
+	p.Move(Path{dst})
+	const dt = 50 * time.Millisecond
+	var first, slowest float32
+	for i := 0; i < 100 && !p.HasReachedDestination(); i++ {
+		org := d2.Vec2{p.Pos[0], p.Pos[1]}
+		p.Update(dt)
+		if step := p.Pos.Sub(org).Len(); first == 0 {
+			first = step
+		} else if !p.HasReachedDestination() && (step < slowest || slowest == 0) {
+			slowest = step
+		}
+	}
+	if !p.HasReachedDestination() || p.Pos.Sub(dst).Len() > g.cfg.ArrivalTolerance {
+		t.Fatalf("player at %v didn't reach %v", p.Pos, dst)
+	}
+	if slowest >= first/2 {
+		t.Errorf("player slowest step = %v, first one = %v, want it to slow down", slowest, first)
+	}
+
+	// the zombies reach their waypoints within the tolerance, at full speed
+	if z.Tolerance != g.cfg.ArrivalTolerance || z.SlowdownRadius != 0 {
+		t.Errorf("zombie tolerance = %v, slowdown radius = %v, want %v, 0",
+			z.Tolerance, z.SlowdownRadius, g.cfg.ArrivalTolerance)
+	}
+	z.SetPath(Path{d2.Vec2{16.5, 1.55}})
+	if z.Move(dt); !z.HasReachedDestination() || !z.Pos.Approx(d2.Vec2{16.5, 1.5}) {
+		t.Errorf("zombie at %v should have reached a waypoint within the tolerance without moving", z.Pos)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"server/messages"
 	"server/network"
 	"server/protocol"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
 )
 
 func (g *Game) registerMsgHandlers() {
@@ -16,22 +19,60 @@ func (g *Game) registerMsgHandlers() {
 	g.server.RegisterMsgHandler(messages.BuildId, g.handleBuild)
 	g.server.RegisterMsgHandler(messages.RepairId, g.handleRepair)
 	g.server.RegisterMsgHandler(messages.AttackId, g.handleAttack)
+	g.server.RegisterMsgHandler(messages.ShootId, g.handleShoot)
+	g.server.RegisterMsgHandler(messages.ThrowId, g.handleThrow)
 	g.server.RegisterMsgHandler(messages.OperateId, g.handleOperate)
 }
 
+/*
+ * isFinite reports whether f is neither NaN nor infinite
+ */
+func isFinite(f float32) bool {
+	return !math32.IsNaN(f) && !math32.IsInf(f, 0)
+}
+
+/*
+ * validPoint reports whether the world coordinates sent by a client are
+ * finite and lie within the world bounds.
+ *
+ * Messages carrying invalid coordinates are logged and ignored, so that
+ * they never reach the pathfinder nor the spatial index.
+ */
+func (g *Game) validPoint(x, y float32) bool {
+	return isFinite(x) && isFinite(y) && g.state.World().PointInBounds(d2.Vec2{x, y})
+}
+
+/*
+ * validAim reports whether the aimed point sent by a client is finite and
+ * reasonably close to the world.
+ *
+ * Only the direction of an aimed point matters, it can thus lie out of the
+ * world, but not so far that computing the direction overflows.
+ */
+func (g *Game) validAim(x, y float32) bool {
+	w := g.state.World()
+	return isFinite(x) && isFinite(y) &&
+		x >= -w.Width && x <= 2*w.Width && y >= -w.Height && y <= 2*w.Height
+}
+
 /*
  * handleMove processes a Move message and fires a PlayerMove event
  */
 func (g *Game) handleMove(c *network.Conn, msg interface{}) error {
 	move := msg.(messages.Move)
 	log.WithField("msg", move).Info("Move message")
+	if !g.validPoint(move.Xpos, move.Ypos) {
+		log.WithField("msg", move).Warn("Ignoring Move message with invalid coordinates")
+		return nil
+	}
 
-	g.eventManager.PostEvent(
+	g.postClientEvent(
 		events.NewEvent(
 			events.PlayerMoveId,
 			events.PlayerMove{
 				Id:   (c.GetUserData().(protocol.ClientData)).Id,
 				Xpos: move.Xpos, Ypos: move.Ypos,
+				Queued: move.Queued,
 			}))
 	return nil
 }
@@ -42,8 +83,12 @@ func (g *Game) handleMove(c *network.Conn, msg interface{}) error {
 func (g *Game) handleBuild(c *network.Conn, msg interface{}) error {
 	build := msg.(messages.Build)
 	log.WithField("msg", build).Info("Build message")
+	if !g.validPoint(build.Xpos, build.Ypos) {
+		log.WithField("msg", build).Warn("Ignoring Build message with invalid coordinates")
+		return nil
+	}
 
-	g.eventManager.PostEvent(
+	g.postClientEvent(
 		events.NewEvent(events.PlayerBuildId,
 			events.PlayerBuild{
 				Id:   c.GetUserData().(protocol.ClientData).Id,
@@ -60,7 +105,7 @@ func (g *Game) handleRepair(c *network.Conn, msg interface{}) error {
 	repair := msg.(messages.Repair)
 	log.WithField("msg", repair).Info("Repair message")
 
-	g.eventManager.PostEvent(
+	g.postClientEvent(
 		events.NewEvent(events.PlayerRepairId,
 			events.PlayerRepair{
 				Id:         c.GetUserData().(protocol.ClientData).Id,
@@ -76,11 +121,57 @@ func (g *Game) handleAttack(c *network.Conn, msg interface{}) error {
 	attack := msg.(messages.Attack)
 	log.WithField("msg", attack).Info("Attack message")
 
-	g.eventManager.PostEvent(
+	// the client saw the target where it was one round-trip ago
+	id := c.GetUserData().(protocol.ClientData).Id
+	rtt, _ := g.clients.RTT(id)
+	g.postClientEvent(
 		events.NewEvent(events.PlayerAttackId,
 			events.PlayerAttack{
-				Id:       c.GetUserData().(protocol.ClientData).Id,
+				Id:       id,
 				EntityId: attack.Id,
+				Latency:  uint32(rtt / time.Millisecond),
+			}))
+	return nil
+}
+
+/*
+ * handleShoot processes a Shoot message and fires a PlayerShoot event
+ */
+func (g *Game) handleShoot(c *network.Conn, msg interface{}) error {
+	shoot := msg.(messages.Shoot)
+	log.WithField("msg", shoot).Info("Shoot message")
+	if !g.validAim(shoot.Xpos, shoot.Ypos) {
+		log.WithField("msg", shoot).Warn("Ignoring Shoot message with invalid coordinates")
+		return nil
+	}
+
+	g.postClientEvent(
+		events.NewEvent(events.PlayerShootId,
+			events.PlayerShoot{
+				Id:   c.GetUserData().(protocol.ClientData).Id,
+				Xpos: shoot.Xpos,
+				Ypos: shoot.Ypos,
+			}))
+	return nil
+}
+
+/*
+ * handleThrow processes a Throw message and fires a PlayerThrow event
+ */
+func (g *Game) handleThrow(c *network.Conn, msg interface{}) error {
+	throw := msg.(messages.Throw)
+	log.WithField("msg", throw).Info("Throw message")
+	if !g.validAim(throw.Xpos, throw.Ypos) {
+		log.WithField("msg", throw).Warn("Ignoring Throw message with invalid coordinates")
+		return nil
+	}
+
+	g.postClientEvent(
+		events.NewEvent(events.PlayerThrowId,
+			events.PlayerThrow{
+				Id:   c.GetUserData().(protocol.ClientData).Id,
+				Xpos: throw.Xpos,
+				Ypos: throw.Ypos,
 			}))
 	return nil
 }
@@ -92,7 +183,7 @@ func (g *Game) handleOperate(c *network.Conn, msg interface{}) error {
 	operate := msg.(messages.Operate)
 	log.WithField("msg", operate).Info("Operate message")
 
-	g.eventManager.PostEvent(
+	g.postClientEvent(
 		events.NewEvent(events.PlayerOperateId,
 			events.PlayerOperate{
 				Id:       c.GetUserData().(protocol.ClientData).Id,
@@ -0,0 +1,64 @@
+package surviveler
+
+import (
+	"server/events"
+	"server/messages"
+	"server/network"
+	"server/protocol"
+	"testing"
+
+	"github.com/aurelien-rainone/math32"
+)
+
+func TestGame_InvalidCoordinates(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	c := &network.Conn{}
+	c.SetUserData(protocol.ClientData{Id: 1, Joined: true})
+
+	var posted int
+	count := func(*events.Event) { posted++ }
+	g.eventManager.Subscribe(events.PlayerMoveId, count)
+	g.eventManager.Subscribe(events.PlayerBuildId, count)
+	g.eventManager.Subscribe(events.PlayerShootId, count)
+
+	nan, inf := math32.NaN(), math32.Inf(1)
+	tests := []struct {
+		name  string
+		x, y  float32
+		valid bool
+	}{
+		{"inside", 1.5, 2.5, true},
+		{"NaN", nan, 2.5, false},
+		{"+Inf", 1.5, inf, false},
+		{"-Inf", -inf, 2.5, false},
+		{"huge", 1e30, -1e30, false},
+		{"out of the map", 9.5, 2.5, false},
+	}
+	for _, tt := range tests {
+		posted = 0
+		g.handleMove(c, messages.Move{Xpos: tt.x, Ypos: tt.y})
+		g.handleBuild(c, messages.Build{Type: uint8(BarricadeBuilding), Xpos: tt.x, Ypos: tt.y})
+		g.eventManager.Process()
+		if valid := posted == 2; valid != tt.valid || posted%2 != 0 {
+			t.Errorf("%s: %d Move/Build events posted, want valid = %v", tt.name, posted, tt.valid)
+		}
+	}
+
+	// shooting out of the map is fine, as long as the direction can be computed
+	for _, tt := range []struct {
+		name  string
+		x, y  float32
+		valid bool
+	}{
+		{"out of the map", 12.5, -3, true},
+		{"NaN", 1.5, nan, false},
+		{"huge", -1e30, 2.5, false},
+	} {
+		posted = 0
+		g.handleShoot(c, messages.Shoot{Xpos: tt.x, Ypos: tt.y})
+		g.eventManager.Process()
+		if valid := posted == 1; valid != tt.valid {
+			t.Errorf("%s: Shoot event posted = %v, want %v", tt.name, valid, tt.valid)
+		}
+	}
+}
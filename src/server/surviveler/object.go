@@ -22,7 +22,7 @@ type CoffeeMachine struct {
 	pos        d2.Vec2
 	objectType EntityType
 	operatedBy Entity
-	lastHeal   time.Time
+	healing    Cooldown // period between 2 heals
 	g          *Game
 	gamestate  *GameState
 }
@@ -35,6 +35,7 @@ func NewCoffeeMachine(g *Game, pos d2.Vec2, objectType EntityType) *CoffeeMachin
 	cm.pos = pos
 	cm.objectType = objectType
 	cm.id = InvalidID
+	cm.healing.Period = HealingFrequency
 	cm.operatedBy = nil
 	cm.g = g
 	cm.gamestate = g.State()
@@ -54,6 +55,10 @@ func (cm *CoffeeMachine) Type() EntityType {
 	return cm.objectType
 }
 
+func (cm *CoffeeMachine) Faction() Faction {
+	return NeutralFaction
+}
+
 func (cm *CoffeeMachine) State() EntityState {
 	var operatedById uint32
 	if cm.operatedBy == nil {
@@ -74,20 +79,21 @@ func (cm *CoffeeMachine) Position() d2.Vec2 {
 }
 
 func (cm *CoffeeMachine) Update(dt time.Duration) {
+	cm.healing.Tick(dt)
 	if cm.operatedBy != nil {
 		dist := cm.operatedBy.Position().Sub(cm.pos).Len()
 		if dist > HealingDistance {
 			cm.operatedBy = nil
 		} else {
-			if time.Since(cm.lastHeal) > HealingFrequency {
+			if cm.healing.Ready() {
 				cm.operatedBy.HealDamage(HealingPower)
-				cm.lastHeal = time.Now()
+				cm.healing.Start()
 			}
 		}
 	}
 }
 
-func (cm *CoffeeMachine) DealDamage(dmg float32) bool {
+func (cm *CoffeeMachine) DealDamage(dmg float32, typ DamageType) bool {
 	// NOTE: no damage to clickable objects
 	return false
 }
@@ -110,7 +116,7 @@ func (cm *CoffeeMachine) Operate(ent Entity) (res bool) {
 	res = true
 	if cm.operatedBy == nil {
 		cm.operatedBy = ent
-		cm.lastHeal = time.Time{}
+		cm.healing.Reset()
 	} else {
 		res = false
 	}
@@ -0,0 +1,93 @@
+/*
+ * Surviveler package
+ * dynamic tile occupancy
+ */
+package surviveler
+
+// dynamic occupancy constants
+const (
+	CrowdedTileCount = 3 // number of zombies from which a tile is crowded
+	CrowdedTileCost  = 4 // multiplies the cost of moving onto a crowded tile
+)
+
+/*
+ * Occupancy is the dynamic layer of the world grid, laid over the static
+ * layer made of the tile kinds and costs.
+ *
+ * It counts, for each tile, the buildings and the zombies attached to it: a
+ * tile holding a building is blocked, a tile holding CrowdedTileCount zombies
+ * or more is crowded. The static layer never changes once the map is loaded,
+ * only the occupancy does, as the entities come, move and go.
+ */
+type Occupancy struct {
+	width        int
+	buildings    []uint16 // number of buildings on each tile
+	zombies      []uint16 // number of zombies on each tile
+	version      uint64   // incremented when a tile gets blocked or freed
+	crowdVersion uint64   // incremented when a tile gets crowded or not
+}
+
+/*
+ * NewOccupancy creates the occupancy layer of a grid, with no occupied tiles
+ */
+func NewOccupancy(width, height int) *Occupancy {
+	return &Occupancy{
+		width:     width,
+		buildings: make([]uint16, width*height),
+		zombies:   make([]uint16, width*height),
+	}
+}
+
+/*
+ * Blocked indicates if the tile at grid coordinates (x, y) is occupied by a
+ * building
+ */
+func (o *Occupancy) Blocked(x, y int) bool {
+	return o.buildings[x+y*o.width] > 0
+}
+
+/*
+ * Crowded indicates if the tile at grid coordinates (x, y) is occupied by
+ * CrowdedTileCount zombies or more
+ */
+func (o *Occupancy) Crowded(x, y int) bool {
+	return o.zombies[x+y*o.width] >= CrowdedTileCount
+}
+
+/*
+ * add adds delta to the counts of the tiles an entity is attached to, only
+ * buildings and zombies are counted
+ */
+func (o *Occupancy) add(ent Entity, tiles TileList, delta int) {
+	var (
+		counts    []uint16
+		threshold uint16
+		version   *uint64
+	)
+	switch ent.(type) {
+	case Building:
+		counts, threshold, version = o.buildings, 1, &o.version
+	case *Zombie:
+		counts, threshold, version = o.zombies, CrowdedTileCount, &o.crowdVersion
+	default:
+		return
+	}
+	for _, t := range tiles {
+		i := t.X + t.Y*o.width
+		was := counts[i] >= threshold
+		counts[i] = uint16(int(counts[i]) + delta)
+		if counts[i] >= threshold != was {
+			*version++
+		}
+	}
+}
+
+/*
+ * clone returns a copy of the occupancy layer
+ */
+func (o *Occupancy) clone() *Occupancy {
+	c := *o
+	c.buildings = append([]uint16(nil), o.buildings...)
+	c.zombies = append([]uint16(nil), o.zombies...)
+	return &c
+}
@@ -0,0 +1,80 @@
+package surviveler
+
+import (
+	"testing"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestOccupancy_Building(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	world := g.state.World()
+	occ := world.Occupancy()
+	tile := world.Tile(4, 2)
+
+	v := world.navVersion(false)
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+	if !occ.Blocked(4, 2) || tile.IsWalkable() {
+		t.Errorf("building tile blocked = %v, walkable = %v, want blocked", occ.Blocked(4, 2), tile.IsWalkable())
+	}
+	if tile.Kind != KindWalkable {
+		t.Errorf("static tile kind = %v, want it unchanged", tile.Kind)
+	}
+	if occ.Blocked(3, 2) || occ.Blocked(5, 2) {
+		t.Errorf("the building blocks its neighbour tiles")
+	}
+	if world.navVersion(false) == v {
+		t.Errorf("navigation version unchanged by the building")
+	}
+
+	g.state.RemoveEntity(b.Id())
+	if occ.Blocked(4, 2) || !tile.IsWalkable() {
+		t.Errorf("tile still blocked once the building is removed")
+	}
+}
+
+func TestOccupancy_Crowds(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	world := g.state.World()
+	occ := world.Occupancy()
+	org, dst := d2.Vec2{1.5, 2.5}, d2.Vec2{7.5, 2.5}
+
+	var zombies []*Zombie
+	for i := 0; i < CrowdedTileCount; i++ {
+		zombies = append(zombies, addTestZombie(g, d2.Vec2{4.5, 2.5}))
+	}
+	if !occ.Crowded(4, 2) {
+		t.Fatalf("tile holding %d zombies isn't crowded", CrowdedTileCount)
+	}
+	if occ.Blocked(4, 2) || !world.Tile(4, 2).IsWalkable() {
+		t.Errorf("crowded tile is blocked")
+	}
+
+	var path Path
+	request := func() {
+		path = nil
+		g.Pathfinder().Request(org, dst, func(p Path, found bool) { path = p })
+		g.Pathfinder().Deliver()
+	}
+	request()
+	if !pathCrossesTile(world, path, world.Tile(4, 2)) {
+		t.Errorf("path %v should ignore the crowd by default", path)
+	}
+	g.Pathfinder().AvoidCrowds = true
+	request()
+	if path == nil || pathCrossesTile(world, path, world.Tile(4, 2)) {
+		t.Errorf("path %v goes through the crowded tile", path)
+	}
+
+	// the crowd disperses
+	z := zombies[0]
+	z.Pos = d2.Vec2{1.5, 3.5}
+	world.UpdateEntity(z)
+	if occ.Crowded(4, 2) {
+		t.Errorf("tile still crowded once a zombie left")
+	}
+	request()
+	if !pathCrossesTile(world, path, world.Tile(4, 2)) {
+		t.Errorf("path %v should go through the room once the crowd dispersed", path)
+	}
+}
@@ -4,7 +4,39 @@
  */
 package surviveler
 
-import "github.com/aurelien-rainone/gogeo/f32/d2"
+import (
+	"math"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
 
 // Path is a sequence of points
 type Path []d2.Vec2
+
+/*
+ * Length returns the geometric length of the path, that is the sum of the
+ * distances between its consecutive points
+ */
+func (p Path) Length() float32 {
+	var length float32
+	for i := 1; i < len(p); i++ {
+		length += p[i].Sub(p[i-1]).Len()
+	}
+	return length
+}
+
+/*
+ * ETA returns the time an entity moving at speed, in world units per second,
+ * takes to follow the whole path.
+ *
+ * This is an estimate, the slowdown when approaching the destination isn't
+ * taken into account. The ETA of an entity that can't move is the max
+ * duration.
+ */
+func (p Path) ETA(speed float32) time.Duration {
+	if speed <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(float64(p.Length()/speed) * float64(time.Second))
+}
@@ -5,18 +5,118 @@
 package surviveler
 
 import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/aurelien-rainone/gogeo/f32/d2"
 	astar "github.com/beefsack/go-astar"
+	"server/logging"
+)
+
+// logger of the pathfinder module
+var pathfinderLog = logging.Module("pathfinder")
+
+/*
+ * PathResult is the outcome of a path search, telling why it failed if so
+ */
+type PathResult uint8
+
+// path search outcomes
+const (
+	PathFound               PathResult = iota // a path has been found
+	PathUnreachable                           // no path leads to the destination
+	PathOutOfBounds                           // the origin or the destination is out of the map
+	PathDestinationOccupied                   // the destination is a wall, or a building with no free side
+	PathBudgetExceeded                        // the search explored more tiles than allowed
 )
 
+func (res PathResult) String() string {
+	switch res {
+	case PathFound:
+		return "found"
+	case PathUnreachable:
+		return "unreachable"
+	case PathOutOfBounds:
+		return "out of bounds"
+	case PathDestinationOccupied:
+		return "destination occupied"
+	case PathBudgetExceeded:
+		return "budget exceeded"
+	}
+	return fmt.Sprintf("PathResult(%d)", uint8(res))
+}
+
+/*
+ * originSnapRadius is the distance, in tiles, up to which a walkable tile is
+ * looked for when the origin of a path search is blocked
+ */
+const originSnapRadius = 2
+
 type Pathfinder struct {
-	game *Game
+	game            *Game
+	calls           uint64         // number of path searches performed
+	workers         chan struct{}  // limits the number of concurrent searches
+	pending         []*PathRequest // searches requested since the last delivery
+	wg              sync.WaitGroup // wait for the pending searches to complete
+	snapshot        *World         // world walkability the searches run on
+	snapshotVersion uint64         // world navigation version of the snapshot
+	snapshotCrowds  bool           // whether the snapshot accounts for crowds
+
+	// AvoidCrowds makes the searches go round the tiles crowded by zombies
+	AvoidCrowds bool
+}
+
+/*
+ * PathRequest is a path search performed by the pathfinding workers, it's
+ * the token used to cancel the search
+ */
+type PathRequest struct {
+	fn        func(path Path, found bool) // called with the search result
+	path      Path
+	found     bool
+	result    PathResult
+	cancelled int32 // accessed atomically, as the workers read it
+}
+
+/*
+ * Cancel cancels the request, its callback won't be called. It's a no-op on
+ * a nil or already delivered request.
+ */
+func (req *PathRequest) Cancel() {
+	if req != nil {
+		atomic.StoreInt32(&req.cancelled, 1)
+	}
+}
+
+/*
+ * Result returns the outcome of the search, it's only meaningful once the
+ * request has been delivered, i.e from its callback
+ */
+func (req *PathRequest) Result() PathResult {
+	return req.result
+}
+
+func (req *PathRequest) isCancelled() bool {
+	return atomic.LoadInt32(&req.cancelled) != 0
+}
+
+/*
+ * PathDebug collects what a path search did, in order to visualize it: the
+ * tiles explored by A* and the final path
+ */
+type PathDebug struct {
+	Result   PathResult
+	Explored []d2.Vec2 // centers of the tiles explored by A*, in exploration order
+	Path     Path      // smoothed path, from the destination to the origin
 }
 
 func NewPathfinder(game *Game) *Pathfinder {
 	return &Pathfinder{
-		game: game,
+		game:    game,
+		workers: make(chan struct{}, runtime.NumCPU()),
 	}
 }
 
@@ -25,35 +125,312 @@ func NewPathfinder(game *Game) *Pathfinder {
  *
  * The search is performed with the A* algorithm, running on a matrix-shaped
  * graph representing the world. The grid is scaled to achieve a better
- * resolution.
+ * resolution. A* isn't run at all if the destination is in another region
+ * than the origin, as it would explore the whole region in vain.
+ *
+ * dist is the geometric length of the smoothed path, in world units, and
+ * not its cost.
+ *
+ * If the origin tile is blocked, the path starts from the closest walkable
+ * tile instead, see snapOrigin.
  */
-func (pf Pathfinder) FindPath(org, dst d2.Vec2) (path Path, dist float32, found bool) {
-	world := pf.game.State().World()
-	// scale org and dst coordinates
-	scaledOrg, scaledDst := org.Scale(world.GridScale), dst.Scale(world.GridScale)
+func (pf *Pathfinder) FindPath(org, dst d2.Vec2) (path Path, dist float32, found bool) {
+	return pf.findPath(org, dst, nil)
+}
 
-	// retrieve origin and destination tiles by rounding the scaled org/dst points down
-	porg := world.Tile(int(scaledOrg[0]), int(scaledOrg[1]))
-	pdst := world.Tile(int(scaledDst[0]), int(scaledDst[1]))
-	switch {
-	case porg == nil, pdst == nil:
-		log.WithFields(log.Fields{"org": org, "dst": dst}).Error("Couldn't find origin or destination Tile")
-		found = false
+/*
+ * DebugPath performs the same search as FindPath, and returns what it did
+ */
+func (pf *Pathfinder) DebugPath(org, dst d2.Vec2) *PathDebug {
+	dbg := &PathDebug{}
+	pf.findPath(org, dst, dbg)
+	return dbg
+}
+
+/*
+ * findPath implements FindPath, collecting what the search did in dbg if
+ * it's not nil
+ */
+func (pf *Pathfinder) findPath(org, dst d2.Vec2, dbg *PathDebug) (path Path, dist float32, found bool) {
+	pf.calls++
+	world := pf.game.State().World()
+	org = snapOrigin(world, org)
+	porg, pdst, res := pf.endpoints(world, org, dst)
+	if dbg != nil {
+		dbg.Result = res
+	}
+	if res != PathFound {
 		return
 	}
 
 	// perform A*
-	rawPath, _, found := astar.Path(porg, pdst)
-	if !found {
+	rawPath, res := pf.search(porg, pdst, dbg)
+	if dbg != nil {
+		dbg.Result = res
+	}
+	if res != PathFound {
 		return
 	}
+	path = smoothPath(world, rawPath, org, dst)
+	if dbg != nil {
+		dbg.Path = path
+	}
+	dist = path.Length()
+	return path, dist, true
+}
+
+/*
+ * Request queues a path search from org to dst, performed off the game loop
+ * by the pathfinding workers.
+ *
+ * The search runs on a snapshot of the world walkability, taken when the
+ * buildings last changed, or the crowds if AvoidCrowds is set. fn is called
+ * with the result on the game loop goroutine, by the next call to Deliver,
+ * that is on the next logic tick, unless the returned request gets cancelled
+ * in the meantime.
+ */
+func (pf *Pathfinder) Request(org, dst d2.Vec2, fn func(path Path, found bool)) *PathRequest {
+	return pf.request(org, dst, false, fn)
+}
+
+/*
+ * RequestNearest is like Request, but if dst can't be reached, the path
+ * leads to the reachable tile that is the closest to dst instead.
+ *
+ * found is false only if no path to the origin tile itself exists.
+ */
+func (pf *Pathfinder) RequestNearest(org, dst d2.Vec2, fn func(path Path, found bool)) *PathRequest {
+	return pf.request(org, dst, true, fn)
+}
+
+func (pf *Pathfinder) request(org, dst d2.Vec2, nearest bool, fn func(path Path, found bool)) *PathRequest {
+	pf.calls++
+	req := &PathRequest{fn: fn}
+	pf.pending = append(pf.pending, req)
+
+	world := pf.game.State().World()
+	org = snapOrigin(world, org)
+	porg, pdst, res := pf.endpoints(world, org, dst)
+	if req.result = res; res != PathFound && (!nearest || res == PathOutOfBounds) {
+		return req
+	}
+	crowds := pf.AvoidCrowds
+	if version := world.navVersion(crowds); pf.snapshot == nil ||
+		pf.snapshotVersion != version || pf.snapshotCrowds != crowds {
+		pf.snapshot, pf.snapshotVersion, pf.snapshotCrowds = world.navSnapshot(crowds), version, crowds
+	}
+	snap := pf.snapshot
+	porg, pdst = snap.Tile(porg.X, porg.Y), snap.Tile(pdst.X, pdst.Y)
+
+	pf.wg.Add(1)
+	go func() {
+		defer pf.wg.Done()
+		pf.workers <- struct{}{}
+		defer func() { <-pf.workers }()
+		if req.isCancelled() {
+			return
+		}
+
+		var rawPath []astar.Pather
+		if res == PathFound {
+			rawPath, res = pf.search(porg, pdst, nil)
+		}
+		if res != PathFound && nearest {
+			// head for the closest point we can reach instead
+			closest := closestReachable(porg, pdst)
+			dst = closest.Rectangle().Center()
+			rawPath, res = pf.search(porg, closest, nil)
+		}
+		if req.result = res; res == PathFound {
+			req.path, req.found = smoothPath(snap, rawPath, org, dst), true
+		}
+	}()
+	return req
+}
+
+/*
+ * Deliver waits for the searches requested since the last call to complete,
+ * then calls the callbacks of those that haven't been cancelled, in the
+ * order of the requests.
+ *
+ * It's called once per logic tick, a whole tick after the searches were
+ * requested, so that it normally doesn't have to wait at all.
+ */
+func (pf *Pathfinder) Deliver() {
+	reqs := pf.pending
+	pf.pending = nil
+	pf.wg.Wait()
+	for _, req := range reqs {
+		if !req.isCancelled() {
+			req.fn(req.path, req.found)
+		}
+	}
+}
+
+/*
+ * endpoints returns the tiles of world between which a path from org to dst
+ * has to be searched.
+ *
+ * If the search is bound to fail, the reason is returned, along with the
+ * tiles if they have been found. No search is needed for a destination in
+ * another region than the origin, A* would explore the whole region in vain.
+ */
+func (pf *Pathfinder) endpoints(world *World, org, dst d2.Vec2) (porg, pdst *Tile, res PathResult) {
+	// retrieve origin and destination tiles
+	porg, okOrg := world.TileAtWorldVec(org)
+	pdst, okDst := world.TileAtWorldVec(dst)
+	switch {
+	case !okOrg, !okDst:
+		pathfinderLog.WithFields(log.Fields{"org": org, "dst": dst}).Error("Couldn't find origin or destination Tile")
+		return nil, nil, PathOutOfBounds
+	}
+
+	if pdst.Kind == KindWalkable && pdst.HasBuilding() {
+		// the destination is occupied by a building, this is the case when
+		// the destination is the building itself (i.e for building, repairing
+		// or attacking it). As A* can't reach a blocked tile, we aim for its
+		// closest walkable neighbour instead, the final waypoint remains the
+		// requested destination.
+		if free := pf.closestWalkableNeighbour(pdst, porg); free != nil {
+			pdst = free
+		}
+	}
+	switch {
+	case world.Reachable(porg, pdst):
+		return porg, pdst, PathFound
+	case !pdst.IsWalkable():
+		return porg, pdst, PathDestinationOccupied
+	}
+	return porg, pdst, PathUnreachable
+}
 
-	// generate a cleaner path, in one pass:
-	// - basic path smoothing (remove consecutive equal segments)
-	// - clip path segment ends to cell center
+/*
+ * snapOrigin returns the point from which a path starting at org has to be
+ * searched.
+ *
+ * That's org itself, unless its tile isn't walkable, as when a building has
+ * been placed under the entity standing there. A* can't start from a blocked
+ * tile, so the path starts from the center of the walkable tile the closest
+ * to org instead, within originSnapRadius tiles, and the entity escapes by
+ * walking to it first.
+ */
+func snapOrigin(world *World, org d2.Vec2) d2.Vec2 {
+	t, ok := world.TileAtWorldVec(org)
+	if !ok || t.IsWalkable() {
+		return org
+	}
+	var (
+		closest *Tile
+		minDist float32
+	)
+	// look on rings of increasing radius around the origin tile
+	for r := 1; r <= originSnapRadius && closest == nil; r++ {
+		for x := t.X - r; x <= t.X+r; x++ {
+			for y := t.Y - r; y <= t.Y+r; y++ {
+				if absInt(x-t.X) != r && absInt(y-t.Y) != r {
+					continue
+				}
+				n, ok := world.TileAt(x, y)
+				if !ok || !n.IsWalkable() {
+					continue
+				}
+				dist := n.Rectangle().Center().Sub(org).LenSqr()
+				if closest == nil || dist < minDist {
+					closest, minDist = n, dist
+				}
+			}
+		}
+	}
+	if closest == nil {
+		return org
+	}
+	return closest.Rectangle().Center()
+}
+
+/*
+ * search runs A* from org to dst, within the search budget of the game
+ * configuration, if any. The explored tiles are collected in dbg if it's not
+ * nil.
+ */
+func (pf *Pathfinder) search(org, dst *Tile, dbg *PathDebug) ([]astar.Pather, PathResult) {
+	budget := pf.game.cfg.PathBudget
+	if budget <= 0 && dbg == nil {
+		if rawPath, _, found := astar.Path(org, dst); found {
+			return rawPath, PathFound
+		}
+		return nil, PathUnreachable
+	}
+
+	s := &budgetSearch{limited: budget > 0, left: budget, dbg: dbg}
+	rawPath, _, found := astar.Path(budgetNode{org, s}, budgetNode{dst, s})
+	switch {
+	case found:
+		for i := range rawPath {
+			rawPath[i] = rawPath[i].(budgetNode).Tile
+		}
+		return rawPath, PathFound
+	case s.exceeded:
+		return nil, PathBudgetExceeded
+	}
+	return nil, PathUnreachable
+}
+
+/*
+ * budgetSearch is the state of a search limited in the number of tiles it
+ * explores, or whose explored tiles are collected
+ */
+type budgetSearch struct {
+	limited  bool       // the number of explored tiles is limited
+	left     int        // number of tiles that can still be explored
+	exceeded bool       // a tile couldn't be explored
+	dbg      *PathDebug // if not nil, collects the explored tiles
+}
+
+/*
+ * budgetNode is a tile explored by a budgeted search, once the budget is
+ * spent, the tiles have no neighbours anymore so that A* gives up.
+ */
+type budgetNode struct {
+	*Tile
+	s *budgetSearch
+}
+
+func (n budgetNode) PathNeighbors() []astar.Pather {
+	if n.s.limited {
+		if n.s.left <= 0 {
+			n.s.exceeded = true
+			return nil
+		}
+		n.s.left--
+	}
+	if n.s.dbg != nil {
+		n.s.dbg.Explored = append(n.s.dbg.Explored, n.Rectangle().Center())
+	}
+	neighbors := n.Tile.PathNeighbors()
+	for i := range neighbors {
+		neighbors[i] = budgetNode{neighbors[i].(*Tile), n.s}
+	}
+	return neighbors
+}
+
+func (n budgetNode) PathNeighborCost(to astar.Pather) float64 {
+	return n.Tile.PathNeighborCost(to.(budgetNode).Tile)
+}
+
+func (n budgetNode) PathEstimatedCost(to astar.Pather) float64 {
+	return n.Tile.PathEstimatedCost(to.(budgetNode).Tile)
+}
+
+/*
+ * smoothPath generates a cleaner path from the tiles returned by A*, in one
+ * pass:
+ * - basic path smoothing (remove consecutive equal segments)
+ * - clip path segment ends to cell center
+ */
+func smoothPath(world *World, rawPath []astar.Pather, org, dst d2.Vec2) Path {
 	invScale := 1.0 / world.GridScale
 	txCenter := d2.Vec2{0.5, 0.5} // tx vector to the cell center
-	path = make(Path, 0, len(rawPath))
+	path := make(Path, 0, len(rawPath))
 	var last d2.Vec2
 	for pidx := range rawPath {
 		tile := rawPath[pidx].(*Tile)
@@ -80,5 +457,63 @@ func (pf Pathfinder) FindPath(org, dst d2.Vec2) (path Path, dist float32, found
 		}
 		last = pt
 	}
-	return
+	if len(rawPath) == 1 {
+		// origin and destination are on the same tile
+		path = append(path, org)
+	}
+	return path
+}
+
+/*
+ * closestWalkableNeighbour returns the walkable neighbour of t that is the
+ * closest from the tile from, or nil if t has no walkable neighbours.
+ */
+func (pf *Pathfinder) closestWalkableNeighbour(t, from *Tile) *Tile {
+	var (
+		closest *Tile
+		minDist float32
+	)
+	world := pf.game.State().World()
+	for x := t.X - 1; x <= t.X+1; x++ {
+		for y := t.Y - 1; y <= t.Y+1; y++ {
+			n, ok := world.TileAt(x, y)
+			if !ok || n == t || !n.IsWalkable() {
+				continue
+			}
+			dist := d2.Vec2{float32(n.X - from.X), float32(n.Y - from.Y)}.Len()
+			if closest == nil || dist < minDist {
+				closest, minDist = n, dist
+			}
+		}
+	}
+	return closest
+}
+
+/*
+ * closestReachable returns the tile that can be reached from org and is the
+ * closest from dst. Among the equally close tiles, the closest from org is
+ * returned.
+ */
+func closestReachable(org, dst *Tile) *Tile {
+	dist := func(t *Tile) int {
+		dx, dy := t.X-dst.X, t.Y-dst.Y
+		return dx*dx + dy*dy
+	}
+	closest, minDist := org, dist(org)
+	visited := map[*Tile]bool{org: true}
+	// breadth first, so that tiles are visited by distance from org
+	for queue := []*Tile{org}; len(queue) > 0; queue = queue[1:] {
+		for _, n := range queue[0].PathNeighbors() {
+			t := n.(*Tile)
+			if visited[t] {
+				continue
+			}
+			visited[t] = true
+			queue = append(queue, t)
+			if d := dist(t); d < minDist {
+				closest, minDist = t, d
+			}
+		}
+	}
+	return closest
 }
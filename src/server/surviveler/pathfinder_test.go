@@ -0,0 +1,371 @@
+package surviveler
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+var muddyRoom = []string{
+	"#########",
+	"#.......#",
+	"#.......#",
+	"#.......#",
+	"#.......#",
+	"#.......#",
+	"#########",
+}
+
+func TestPathfinder_TerrainCosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		cost    float32 // cost of the mud strip
+		crosses bool
+	}{
+		{"detour cheaper", 10, false},
+		{"detour longer", 1.5, true},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, muddyRoom...)
+		world := g.state.World()
+		// mud strip, leaving the bottom row free
+		var strip []*Tile
+		for y := 1; y <= 4; y++ {
+			tile := world.Tile(4, y)
+			tile.Cost = tt.cost
+			strip = append(strip, tile)
+		}
+
+		path, _, found := g.Pathfinder().FindPath(d2.Vec2{1.5, 1.5}, d2.Vec2{7.5, 1.5})
+		if !found {
+			t.Fatalf("%s: FindPath() found = false, want true", tt.name)
+		}
+		var crosses bool
+		for _, tile := range strip {
+			crosses = crosses || pathCrossesTile(world, path, tile)
+		}
+		if crosses != tt.crosses {
+			t.Errorf("%s: path %v crosses the mud = %v, want %v", tt.name, path, crosses, tt.crosses)
+		}
+	}
+}
+
+func TestWorld_LoadCosts(t *testing.T) {
+	w := newTestWorld(t, 1, muddyRoom...)
+	img := image.NewGray(image.Rect(0, 0, w.GridWidth, w.GridHeight))
+	for x := 0; x < w.GridWidth; x++ {
+		for y := 0; y < w.GridHeight; y++ {
+			img.SetGray(x, y, color.Gray{tileCostUnit})
+		}
+	}
+	img.SetGray(2, 1, color.Gray{32})
+	img.SetGray(3, 1, color.Gray{192})
+	img.SetGray(4, 1, color.Gray{0})
+
+	if err := w.LoadCosts(img); err != nil {
+		t.Fatalf("LoadCosts() error = %v", err)
+	}
+	for _, tt := range []struct {
+		x, y int
+		want float32
+	}{
+		{1, 1, DefaultTileCost},
+		{2, 1, 0.5},
+		{3, 1, 3},
+		{4, 1, MinTileCost},
+	} {
+		if got := w.Tile(tt.x, tt.y).Cost; got != tt.want {
+			t.Errorf("tile (%d, %d) cost = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	if err := w.LoadCosts(image.NewGray(image.Rect(0, 0, 3, 3))); err == nil {
+		t.Errorf("LoadCosts() with a cost layer of the wrong size should fail")
+	}
+}
+
+func TestPathfinder_Request(t *testing.T) {
+	g := newTestGame(t, muddyRoom...)
+	pf := g.Pathfinder()
+
+	type request struct{ org, dst d2.Vec2 }
+	var reqs []request
+	for x := 1; x <= 7; x++ {
+		for y := 1; y <= 5; y++ {
+			org := d2.Vec2{float32(x) + 0.5, float32(y) + 0.5}
+			for _, dst := range []d2.Vec2{{1.5, 1.5}, {7.5, 5.5}, {4.5, 3.5}} {
+				reqs = append(reqs, request{org, dst})
+			}
+		}
+	}
+	// a wall and a point outside of the world can't be reached
+	reqs = append(reqs, request{d2.Vec2{1.5, 1.5}, d2.Vec2{0.5, 0.5}})
+	reqs = append(reqs, request{d2.Vec2{1.5, 1.5}, d2.Vec2{-5, -5}})
+
+	var delivered []int
+	paths := make([]Path, len(reqs))
+	founds := make([]bool, len(reqs))
+	for i, req := range reqs {
+		i := i
+		pf.Request(req.org, req.dst, func(path Path, found bool) {
+			delivered = append(delivered, i)
+			paths[i], founds[i] = path, found
+		})
+	}
+	if len(delivered) != 0 {
+		t.Fatalf("%d paths delivered before Deliver, want 0", len(delivered))
+	}
+
+	pf.Deliver()
+	if len(delivered) != len(reqs) {
+		t.Fatalf("%d paths delivered, want %d", len(delivered), len(reqs))
+	}
+	for i, req := range reqs {
+		if delivered[i] != i {
+			t.Fatalf("path %d delivered in position %d", delivered[i], i)
+		}
+		want, _, wantFound := pf.FindPath(req.org, req.dst)
+		if founds[i] != wantFound || len(paths[i]) != len(want) {
+			t.Errorf("Request(%v, %v) = %v, %v, want %v, %v", req.org, req.dst, paths[i], founds[i], want, wantFound)
+			continue
+		}
+		for j := range want {
+			if !paths[i][j].Approx(want[j]) {
+				t.Errorf("Request(%v, %v) = %v, want %v", req.org, req.dst, paths[i], want)
+				break
+			}
+		}
+	}
+
+	// nothing left to deliver
+	delivered = nil
+	pf.Deliver()
+	if len(delivered) != 0 {
+		t.Errorf("%d paths delivered twice", len(delivered))
+	}
+}
+
+func TestPathfinder_RequestCancel(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	pf := g.Pathfinder()
+
+	var delivered []int
+	var reqs []*PathRequest
+	for i := 0; i < 3; i++ {
+		i := i
+		reqs = append(reqs, pf.Request(d2.Vec2{1.5, 2.5}, d2.Vec2{7.5, 2.5}, func(Path, bool) {
+			delivered = append(delivered, i)
+		}))
+	}
+	reqs[0].Cancel()
+	reqs[1].Cancel()
+	pf.Deliver()
+	if len(delivered) != 1 || delivered[0] != 2 {
+		t.Errorf("delivered requests = %v, want [2]", delivered)
+	}
+
+	// cancelling a delivered or nil request does nothing
+	reqs[2].Cancel()
+	var req *PathRequest
+	req.Cancel()
+}
+
+func TestPathfinder_RequestAroundBuilding(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	world := g.state.World()
+	org, dst := d2.Vec2{1.5, 2.5}, d2.Vec2{7.5, 2.5}
+
+	var path Path
+	request := func() {
+		path = nil
+		g.Pathfinder().Request(org, dst, func(p Path, found bool) { path = p })
+		g.Pathfinder().Deliver()
+	}
+	request()
+	if !pathCrossesTile(world, path, world.Tile(4, 2)) {
+		t.Fatalf("path %v should go straight through the room", path)
+	}
+
+	// the searches run on a snapshot of the world, updated with the buildings
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+	request()
+	if path == nil || pathCrossesTile(world, path, world.Tile(4, 2)) {
+		t.Errorf("path %v goes through the building tile", path)
+	}
+
+	g.state.RemoveEntity(b.Id())
+	request()
+	if !pathCrossesTile(world, path, world.Tile(4, 2)) {
+		t.Errorf("path %v should go through the room once the building is removed", path)
+	}
+}
+
+func TestZombie_LooksWithoutBlocking(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+
+	// the path is searched during the tick, and applied on the next one
+	tick(g, 20*time.Millisecond)
+	if z.curState != lookingState || !z.searching {
+		t.Fatalf("zombie state = %v, searching = %v, want looking for a path", z.curState, z.searching)
+	}
+	tick(g, 20*time.Millisecond)
+	if z.curState != walkingState || z.searching {
+		t.Fatalf("zombie state = %v, searching = %v, want walking", z.curState, z.searching)
+	}
+	for i := 0; i < 5; i++ {
+		tick(g, 20*time.Millisecond)
+	}
+	if z.Pos[0] <= 1.5 {
+		t.Errorf("zombie at %v didn't walk toward the player", z.Pos)
+	}
+}
+
+// the player's cell, at the right, is walled in
+var lockedRoom = []string{
+	"##########",
+	"#......#.#",
+	"#......#.#",
+	"#......###",
+	"##########",
+}
+
+func TestPathfinder_RequestNearest(t *testing.T) {
+	g := newTestGame(t, lockedRoom...)
+	org, dst := d2.Vec2{1.5, 3.5}, d2.Vec2{8.5, 1.5}
+
+	var (
+		path  Path
+		found bool
+	)
+	g.Pathfinder().Request(org, dst, func(p Path, ok bool) { path, found = p, ok })
+	g.Pathfinder().Deliver()
+	if found {
+		t.Fatalf("Request() found path %v to the locked cell", path)
+	}
+
+	g.Pathfinder().RequestNearest(org, dst, func(p Path, ok bool) { path, found = p, ok })
+	g.Pathfinder().Deliver()
+	if !found {
+		t.Fatalf("RequestNearest() didn't find a path")
+	}
+	if want := (d2.Vec2{6.5, 1.5}); !path[0].Approx(want) {
+		t.Errorf("RequestNearest() path ends at %v, want the closest reachable point %v", path[0], want)
+	}
+
+	// a reachable destination is reached
+	dst = d2.Vec2{5.5, 1.5}
+	g.Pathfinder().RequestNearest(org, dst, func(p Path, ok bool) { path, found = p, ok })
+	g.Pathfinder().Deliver()
+	if !found || !path[0].Approx(dst) {
+		t.Errorf("RequestNearest() path = %v, want a path to %v", path, dst)
+	}
+}
+
+func TestZombie_GathersAtBarrier(t *testing.T) {
+	g := newTestGame(t, lockedRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{8.5, 1.5})
+	zombies := []*Zombie{
+		addTestZombie(g, d2.Vec2{1.5, 1.5}),
+		addTestZombie(g, d2.Vec2{1.5, 3.5}),
+	}
+
+	for i := 0; i < 200; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	for i, z := range zombies {
+		if z.target != p {
+			t.Errorf("zombie %d target = %v, want the locked player", i, z.target)
+		}
+		if dist := z.Pos.Sub(d2.Vec2{6.5, 1.5}).Len(); dist > 1.5 {
+			t.Errorf("zombie %d at %v, want it by the wall closest to the player", i, z.Pos)
+		}
+	}
+}
+
+func TestPathfinder_PathLength(t *testing.T) {
+	g := newTestGame(t, pillarRoom...)
+	// the path goes around the pillar:
+	// (1.5,2.5) → (2.5,2.5) → (3.5,3.5) → (7.5,3.5) → (8.5,2.5)
+	path, dist, found := g.Pathfinder().FindPath(d2.Vec2{1.5, 2.5}, d2.Vec2{8.5, 2.5})
+	if !found {
+		t.Fatalf("FindPath() found = false, want true")
+	}
+	want := 1 + 4 + 2*math32.Sqrt2
+	if math32.Abs(dist-want) > 1e-4 || math32.Abs(path.Length()-want) > 1e-4 {
+		t.Errorf("FindPath() dist = %v, path %v length = %v, want %v", dist, path, path.Length(), want)
+	}
+
+	// 1.5 units per second
+	eta := path.ETA(1.5)
+	if secs := float32(eta.Seconds()); math32.Abs(secs-want/1.5) > 1e-3 {
+		t.Errorf("ETA() = %v, want %vs", eta, want/1.5)
+	}
+	if eta := path.ETA(0); eta < time.Hour {
+		t.Errorf("ETA() at speed 0 = %v, want the max duration", eta)
+	}
+	if l := (Path{d2.Vec2{1, 1}}).Length(); l != 0 {
+		t.Errorf("single point path Length() = %v, want 0", l)
+	}
+}
+
+func TestPathfinder_BlockedOrigin(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	pf := g.Pathfinder()
+	world := g.state.World()
+	z := addTestZombie(g, d2.Vec2{4.5, 2.5})
+	dst := d2.Vec2{7.5, 2.5}
+
+	// a building is placed under the zombie
+	g.state.createBuilding(BarricadeBuilding, z.Position())
+	if tile := world.Tile(4, 2); tile.IsWalkable() {
+		t.Fatalf("tile %#v should be blocked by the building", *tile)
+	}
+
+	path, _, found := pf.FindPath(z.Position(), dst)
+	if !found {
+		t.Fatalf("FindPath() from a blocked tile found = false, want true")
+	}
+	// the path starts from a free adjacent tile
+	start := path[len(path)-1]
+	tile, _ := world.TileAtWorldVec(start)
+	if !tile.IsWalkable() || absInt(tile.X-4) > 1 || absInt(tile.Y-2) > 1 {
+		t.Errorf("path %v starts on %#v, want a walkable tile next to the origin", path, *tile)
+	}
+	if !path[0].Approx(dst) {
+		t.Errorf("path last waypoint = %v, want %v", path[0], dst)
+	}
+
+	// same for the path requests of the zombies
+	var reqPath Path
+	pf.Request(z.Position(), dst, func(p Path, found bool) { reqPath = p })
+	pf.Deliver()
+	if len(reqPath) != len(path) || !reqPath[len(reqPath)-1].Approx(start) {
+		t.Errorf("Request() from a blocked tile = %v, want %v", reqPath, path)
+	}
+
+	// paths from a free tile start from the origin itself
+	org := d2.Vec2{1.2, 2.7}
+	if path, _, _ := pf.FindPath(org, dst); !path[len(path)-1].Approx(org) {
+		t.Errorf("path %v should start from its origin %v", path, org)
+	}
+}
+
+func TestPathfinder_EnclosedOrigin(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	org := d2.Vec2{2.5, 2.5}
+	for x := 1; x <= 4; x++ {
+		for y := 1; y <= 3; y++ {
+			g.state.createBuilding(BarricadeBuilding, d2.Vec2{float32(x) + 0.5, float32(y) + 0.5})
+		}
+	}
+	// no free tile close enough to escape
+	if path, _, found := g.Pathfinder().FindPath(org, d2.Vec2{7.5, 2.5}); found {
+		t.Errorf("FindPath() from an enclosed tile = %v, want no path", path)
+	}
+}
@@ -5,20 +5,26 @@
 package surviveler
 
 import (
+	"fmt"
 	"server/actions"
 	"server/events"
+	"server/messages"
+	"sort"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
 )
 
 // player private action types
 const (
 	BuildPowerInductionPeriod = time.Second
-	PlayerAttackDistance      = 1
 	AttackPeriod              = 500 * time.Millisecond
+	ShootPeriod               = 500 * time.Millisecond
 	PathFindPeriod            = time.Second
+	PlayerStartingAmmo        = 50 // rounds of ammo a player has when spawning
+	PlayerStartingResources   = 20 // resources a player has when spawning
 )
 
 /*
@@ -28,23 +34,38 @@ const (
 type Player struct {
 	id              uint32
 	entityType      EntityType    // player type
+	faction         Faction       // side the player is fighting for
 	actions         actions.Stack // action stack
-	lastBPinduced   time.Time     // time of last initiated BP induction
-	lastAttack      time.Time     // time of last attack
-	lastPathFind    time.Time     // time of last path find
+	induction       Cooldown      // period between 2 build power inductions
+	pathFinding     Cooldown      // period between 2 path finds towards the attack target
+	shooting        Cooldown      // period between 2 shots
+	throwing        Cooldown      // period between 2 grenade throws
 	lastCoffeeDrink time.Time     // time of last coffee drink
 	curBuilding     Building      // building in construction
+	buildCost       uint16        // resources paid for curBuilding, until it's built
 	target          Entity
+	targetLatency   time.Duration // latency to compensate when attacking the target
 	curObject       Object
+	pathReq         *PathRequest    // pending path search for the last order
+	leg             *actions.Action // queued move whose path is being searched
+	legReq          *PathRequest    // path search of leg
 	g               *Game
 	gamestate       *GameState
 	world           *World
 	buildPower      uint16
-	combatPower     uint16
-	totalHP         float32
-	curHP           float32
+	health          *Health
+	combat          *Combat
+	stagger         *Stagger
+	regen           *Regen
+	protection      *Protection
+	modifiers       *Modifiers
+	resists         *Resistances
+	inventory       *Inventory
+	explored        *ExploredMap
+	guard           *MoveGuard
 	posDirty        bool
 	*Movable
+	Components
 }
 
 /*
@@ -53,18 +74,47 @@ type Player struct {
 func NewPlayer(g *Game, spawn d2.Vec2, entityType EntityType,
 	speed, totalHP float32, buildPower, combatPower uint16) *Player {
 	p := &Player{
-		entityType:  entityType,
-		buildPower:  buildPower,
-		combatPower: combatPower,
-		totalHP:     totalHP,
-		curHP:       totalHP,
-		g:           g,
-		gamestate:   g.State(),
-		world:       g.State().World(),
-		id:          InvalidID,
-		actions:     *actions.NewStack(),
-		Movable:     NewMovable(spawn, speed),
+		entityType: entityType,
+		faction:    PlayerFaction,
+		buildPower: buildPower,
+		health:     NewHealth(totalHP),
+		combat:     newCombat(g.cfg, combatPower, AttackPeriod),
+		stagger:    &Stagger{},
+		regen:      NewRegen(time.Duration(g.cfg.PlayerRegenDelay)*time.Millisecond, g.cfg.PlayerRegenRate),
+		protection: &Protection{},
+		modifiers:  NewModifiers(g.cfg.ModifierStacking),
+		resists:    &Resistances{},
+		inventory:  NewInventory(),
+		explored:   NewExploredMap(g.State().World()),
+		guard:      NewMoveGuard(spawn),
+		g:          g,
+		gamestate:  g.State(),
+		world:      g.State().World(),
+		id:         InvalidID,
+		actions:    *actions.NewStack(),
+		Movable:    NewMovable(spawn, speed),
 	}
+	p.Movable.modifiers = p.modifiers
+	p.Movable.Tolerance = g.cfg.ArrivalTolerance
+	p.Movable.SlowdownRadius = g.cfg.SlowdownRadius
+	p.induction.Period = BuildPowerInductionPeriod
+	p.pathFinding.Period = PathFindPeriod
+	p.shooting.Period = ShootPeriod
+	p.throwing.Period = ThrowPeriod
+	p.AddComponent(p.Movable)
+	p.AddComponent(p.health)
+	p.AddComponent(p.combat)
+	p.AddComponent(p.stagger)
+	p.AddComponent(p.regen)
+	p.AddComponent(p.protection)
+	p.AddComponent(p.modifiers)
+	p.AddComponent(p.resists)
+	p.AddComponent(p.inventory)
+	p.AddComponent(p.explored)
+	p.AddComponent(p.guard)
+	p.AddComponent(NewPositionHistory())
+	p.inventory.Add(AmmoItem, PlayerStartingAmmo)
+	p.inventory.Add(ResourceItem, PlayerStartingResources)
 	// place an idle action as the bottommost item of the action stack item.
 	// This should never be removed as the player should remain idle if he
 	// has nothing better to do
@@ -77,13 +127,27 @@ func NewPlayer(g *Game, spawn d2.Vec2, entityType EntityType,
  */
 func (p *Player) Update(dt time.Duration) {
 	p.posDirty = false
+	p.regen.Tick(p.health, dt)
+	p.protection.Tick(dt)
+	p.modifiers.Tick(dt)
+	p.combat.Tick(dt)
+	p.induction.Tick(dt)
+	p.pathFinding.Tick(dt)
+	p.shooting.Tick(dt)
+	p.throwing.Tick(dt)
+	// a staggered player can't act
+	staggered := p.stagger.Tick(dt)
 	// peek the topmost stack action
-	if action, exist := p.actions.Peek(); exist {
+	if action, exist := p.actions.Peek(); exist && !staggered {
 		switch action.Type {
 
 		case actions.MoveId:
 
-			p.onMoveAction(dt)
+			if isQueuedMove(action) {
+				p.startLeg(action)
+			} else {
+				p.onMoveAction(dt)
+			}
 
 		case actions.BuildId, actions.RepairId:
 
@@ -96,37 +160,71 @@ func (p *Player) Update(dt time.Duration) {
 
 		case actions.AttackId:
 
-			dist := p.target.Position().Sub(p.Pos).Len()
-			if dist < PlayerAttackDistance {
-				if time.Since(p.lastAttack) >= AttackPeriod {
-					if !p.target.DealDamage(float32(p.combatPower)) {
-						p.lastAttack = time.Now()
-					} else {
-						// pop current action to get ready for next update
-						next := p.actions.Pop()
-						log.WithField("action", next).Debug("next player action")
-					}
+			// check the attack against the target position, as seen by
+			// the player at the time of the attack order
+			targetPos := p.gamestate.rewind(p.target, p.targetLatency)
+			dist := targetPos.Sub(p.Pos).Len()
+			if dist < p.g.cfg.MeleeRange && p.world.LineOfSight(p.Pos, targetPos) {
+				p.FaceTowards(targetPos)
+				if p.combat.Ready() && p.meleeHit(targetPos) {
+					// pop current action to get ready for next update
+					next := p.actions.Pop()
+					log.WithField("action", next).Debug("next player action")
 				}
 			} else {
-				p.posDirty = p.Movable.Move(dt)
-				if time.Since(p.lastPathFind) > PathFindPeriod {
+				p.moveOrSlide(dt)
+				if p.pathFinding.Ready() {
 					p.findPath(p.target.Position())
 				}
 			}
 		}
 	}
 
+	p.guardMove(dt)
 	if p.posDirty {
 		// update entity position only if needed
 		p.gamestate.World().UpdateEntity(p)
 		p.posDirty = true
 	}
+	// items may be dropped at the feet of a standing player
+	p.pickUpItems()
+	p.explored.Reveal(p.world, p.Pos, PlayerVisionRadius)
+}
+
+/*
+ * guardMove checks the distance covered by the player since the last update.
+ *
+ * A player moving faster than its speed allows is put back at its last
+ * valid position, and the client is flagged for a suspected cheat.
+ */
+func (p *Player) guardMove(dt time.Duration) {
+	maxDist := p.modifiers.Apply(SpeedStat, p.Speed) * float32(dt.Seconds())
+	if dist, ok := p.guard.Check(p.Pos, maxDist); !ok {
+		p.Pos = p.guard.Last()
+		p.posDirty = true
+		p.g.clients.FlagCheat(p.id,
+			fmt.Sprintf("moved by %.2f in %v, max %.2f", dist, dt, maxDist))
+	}
+}
+
+/*
+ * pickUpItems picks up the items the player is over
+ */
+func (p *Player) pickUpItems() {
+	p.world.AABBSpatialQuery(p.Rectangle()).Each(func(e Entity) bool {
+		if _, ok := e.(*Item); ok {
+			p.g.PostEvent(events.NewEvent(
+				events.ItemPickupId,
+				events.ItemPickup{Id: e.Id(), PlayerId: p.id}))
+		}
+		return true
+	})
 }
 
 func (p *Player) onMoveAction(dt time.Duration) {
 	// check if moving would create a collision
 	nextPos := p.Movable.ComputeMove(p.Pos, dt)
-	nextBB := d2.RectFromCircle(nextPos, 0.5)
+	nextBB := p.boundsAt(nextPos)
 	colliding := p.world.AABBSpatialQuery(nextBB)
 
 	var curActionEnded bool
@@ -159,7 +257,12 @@ func (p *Player) onMoveAction(dt time.Duration) {
 	if !curActionEnded {
 
 		// perform the actual move
-		p.posDirty = p.Movable.Move(dt)
+		if !p.moveOrSlide(dt) {
+			// the way is blocked, stop there
+			log.WithField("pos", p.Pos).Debug("player blocked by an obstacle")
+			p.emptyActions()
+			return
+		}
 		if p.Movable.HasReachedDestination() {
 			// pop current action to get ready for next update
 			next := p.actions.Pop()
@@ -169,28 +272,51 @@ func (p *Player) onMoveAction(dt time.Duration) {
 	return
 }
 
+/*
+ * moveOrSlide moves the player along its path, sliding along the obstacles
+ * (see Movable.MoveOrSlide).
+ *
+ * It returns false if the player is blocked and couldn't move at all.
+ */
+func (p *Player) moveOrSlide(dt time.Duration) bool {
+	moved, _ := p.Movable.MoveOrSlide(p.world, p, dt, isPlayerObstacle)
+	p.posDirty = p.posDirty || moved
+	return moved
+}
+
+/*
+ * isPlayerObstacle indicates if an entity blocks the way of the players
+ */
+func isPlayerObstacle(e Entity) bool {
+	switch e.(type) {
+	case *Player, Building:
+		return true
+	}
+	return false
+}
+
 func (p *Player) induceBuildPower() {
 	bid := p.curBuilding.Id()
 	if ent := p.gamestate.Entity(bid); ent == nil {
 		// building doesn't exist anymore, cancel action
-		p.curBuilding = nil
+		p.curBuilding, p.buildCost = nil, 0
 		p.actions.Pop()
 		return
 	}
 
 	// induce build power by chunks of `player BP` per second
-	if time.Since(p.lastBPinduced) > BuildPowerInductionPeriod {
+	if p.induction.Ready() {
 		// period elapsed -> induce BP
 		p.curBuilding.AddBuildPower(p.buildPower)
-		p.lastBPinduced = time.Now()
+		p.induction.Start()
 	}
 
 	if p.curBuilding.IsBuilt() {
 		// building is built: pop current action
-		p.curBuilding = nil
+		p.curBuilding, p.buildCost = nil, 0
 		p.actions.Pop()
-		// zero time of last BP induced
-		p.lastBPinduced = time.Time{}
+		// next building starts with an induction
+		p.induction.Reset()
 	}
 }
 
@@ -214,6 +340,106 @@ func (p *Player) Move(path Path) {
 	p.SetPath(path)
 }
 
+/*
+ * QueueMove queues a move to dst, that the player makes once done with its
+ * current actions, after the moves queued before. The path to dst is
+ * searched from where the player is when it sets off.
+ */
+func (p *Player) QueueMove(dst d2.Vec2) {
+	// slip the move under the actions above the bottommost idle one
+	above := p.actions.PeekN(p.actions.Len() - 1)
+	for range above {
+		p.actions.Pop()
+	}
+	p.actions.Push(actions.New(actions.MoveId, actions.QueuedMove{Xpos: dst[0], Ypos: dst[1]}))
+	for i := len(above) - 1; i >= 0; i-- {
+		p.actions.Push(above[i])
+	}
+}
+
+/*
+ * clearMoveQueue drops the queued moves, the other actions are kept
+ */
+func (p *Player) clearMoveQueue() {
+	p.leg, p.legReq = nil, nil
+	p.filterActions(func(a *actions.Action) bool {
+		return !isQueuedMove(a)
+	})
+}
+
+/*
+ * isQueuedMove indicates if a is a queued move whose path hasn't been
+ * searched yet
+ */
+func isQueuedMove(a *actions.Action) bool {
+	_, ok := a.Item.(actions.QueuedMove)
+	return ok
+}
+
+/*
+ * filterActions removes the actions above the bottommost idle one for which
+ * keep returns false
+ */
+func (p *Player) filterActions(keep func(a *actions.Action) bool) {
+	above := p.actions.PeekN(p.actions.Len() - 1)
+	for range above {
+		p.actions.Pop()
+	}
+	for i := len(above) - 1; i >= 0; i-- {
+		if keep(above[i]) {
+			p.actions.Push(above[i])
+		}
+	}
+}
+
+/*
+ * startLeg sets off for the queued move a, on top of the action stack. Its
+ * path is searched first, the queued move being replaced by a move along the
+ * path once it's found, or dropped if there's none.
+ *
+ * The search waits for the one of an order given in the meantime, and starts
+ * over if it's been superseded by it.
+ */
+func (p *Player) startLeg(a *actions.Action) {
+	switch {
+	case p.pathReq != nil:
+		// the search of the leg, or of an order coming first, is pending
+		return
+	case p.leg == a && !p.legReq.isCancelled():
+		// delivered without a path to follow, the player is already there
+		p.leg, p.legReq = nil, nil
+		p.actions.Pop()
+		return
+	}
+
+	qm := a.Item.(actions.QueuedMove)
+	dst := d2.Vec2{qm.Xpos, qm.Ypos}
+	p.leg = a
+	p.gamestate.runPathFinder(p, dst, func(path Path) {
+		p.leg, p.legReq = nil, nil
+		if top, _ := p.actions.Peek(); top == a {
+			p.actions.Pop()
+			p.actions.Push(actions.New(actions.MoveId, struct{}{}))
+			p.SetPath(path)
+		}
+	}, func(res PathResult) {
+		p.leg, p.legReq = nil, nil
+		p.gamestate.rejectMove(p.id, dst, res)
+		if top, _ := p.actions.Peek(); top == a {
+			p.actions.Pop()
+		}
+	})
+	p.legReq = p.pathReq
+}
+
+/*
+ * cancelPathRequest cancels the pending path search, if any
+ */
+func (p *Player) cancelPathRequest() {
+	p.pathReq.Cancel()
+	p.pathReq = nil
+}
+
 func (p *Player) Position() d2.Vec2 {
 	return p.Movable.Pos
 }
@@ -222,8 +448,24 @@ func (p *Player) Type() EntityType {
 	return p.entityType
 }
 
+func (p *Player) Faction() Faction {
+	return p.faction
+}
+
+func (p *Player) hitPoints() float32 {
+	return p.health.Cur
+}
+
+/*
+ * SetFaction sets the faction the player is fighting for
+ */
+func (p *Player) SetFaction(f Faction) {
+	p.faction = f
+}
+
 func (p *Player) SetId(id uint32) {
 	p.id = id
+	seedCombat(p.g, p.combat, "player", id)
 }
 
 func (p *Player) Id() uint32 {
@@ -231,45 +473,46 @@ func (p *Player) Id() uint32 {
 }
 
 func (p *Player) State() EntityState {
-	var (
-		actionData interface{}     // action data to be sent
-		actionType actions.Type    //
-		curAction  *actions.Action // action action from the stack
-	)
-
-	curAction, _ = p.actions.Peek()
-	actionType = curAction.Type
+	var action messages.Action // action to be sent
+
+	curAction, _ := p.actions.Peek()
 	switch curAction.Type {
 	case actions.MoveId:
-		actionData = actions.Move{Speed: p.Speed}
-	case actions.BuildId:
-		actionData = actions.Build{}
-	case actions.RepairId:
-		actionData = actions.Repair{}
-	case actions.IdleId:
-		actionType = actions.IdleId
-		actionData = actions.Idle{}
+		if isQueuedMove(curAction) {
+			// the path of the queued move is being searched
+			action = messages.NewAction(actions.IdleId)
+		} else {
+			action = messages.NewMoveAction(p.moveAction(p.g.cfg.PlayerWaypoints))
+		}
+	case actions.BuildId, actions.RepairId:
+		action = messages.NewAction(curAction.Type)
 	case actions.AttackId:
 		dist := p.target.Position().Sub(p.Pos).Len()
-		if dist > PlayerAttackDistance {
-			actionType = actions.MoveId
-			actionData = actions.Move{Speed: p.Speed}
+		if dist >= p.g.cfg.MeleeRange {
+			action = messages.NewMoveAction(p.moveAction(p.g.cfg.PlayerWaypoints))
 		} else {
-			actionData = actions.Attack{TargetID: p.target.Id()}
-			actionType = actions.AttackId
+			action = messages.NewAttackAction(actions.Attack{TargetID: p.target.Id()})
 		}
-	case actions.DrinkCoffeeId:
-		actionType = actions.IdleId
-		actionData = actions.Idle{}
+	case actions.IdleId, actions.DrinkCoffeeId:
+		// drinking coffee is shown as idling
+		action = messages.NewAction(actions.IdleId)
 	}
 
-	return MobileEntityState{
+	staggered := p.stagger.Staggered()
+	xvel, yvel := p.velocityHint(p.g.cfg.SendVelocities, action.Type == actions.MoveId && !staggered)
+	return PlayerState{
 		Type:         p.entityType,
 		Xpos:         float32(p.Pos[0]),
 		Ypos:         float32(p.Pos[1]),
-		CurHitPoints: uint16(p.curHP),
-		ActionType:   actionType,
-		Action:       actionData,
+		CurHitPoints: uint16(p.health.Cur),
+		Heading:      p.Heading,
+		Xvel:         xvel,
+		Yvel:         yvel,
+		Staggered:    staggered,
+		Regenerating: p.regen.Regenerating(),
+		Protected:    p.protection.Active(),
+		Action:       action,
+		Resources:    p.inventory.Count(ResourceItem),
 	}
 }
 
@@ -280,16 +523,19 @@ func (p *Player) State() EntityState {
  * The player action stack is emptied, effectively cancelling any previous
  * player action, and replaced with a 'move' action on top of a 'build'
  * action, that will immediately start once the player will be in contact
- * with the target building
+ * with the target building.
+ *
+ * cost is the amount of resources the player paid for the building, they
+ * are refunded if the construction is cancelled before completion.
  */
-func (p *Player) Build(b Building, path Path) {
+func (p *Player) Build(b Building, cost uint16, path Path) {
 	// empty action stack, this cancel any current action(s)
 	p.emptyActions()
 	// fill the player action stack
 	p.actions.Push(actions.New(actions.BuildId, actions.Build{}))
 	p.actions.Push(actions.New(actions.MoveId, struct{}{}))
-	p.curBuilding = b
-	p.lastBPinduced = time.Time{}
+	p.curBuilding, p.buildCost = b, cost
+	p.induction.Reset()
 	p.SetPath(path)
 }
 
@@ -309,7 +555,7 @@ func (p *Player) Repair(b Building, path Path) {
 	p.actions.Push(actions.New(actions.BuildId, actions.Build{}))
 	p.actions.Push(actions.New(actions.MoveId, struct{}{}))
 	p.curBuilding = b
-	p.lastBPinduced = time.Time{}
+	p.induction.Reset()
 	p.SetPath(path)
 }
 
@@ -322,21 +568,109 @@ func (p *Player) findPath(dst d2.Vec2) {
 	}
 	// set the path if found
 	p.Movable.SetPath(path)
-	p.lastPathFind = time.Now()
+	p.pathFinding.Start()
 }
 
-func (p *Player) Attack(e Entity) {
+/*
+ * Attack sets the player as attacking e.
+ *
+ * latency is the player round-trip time, e is considered to be where the
+ * player saw it, that is where it was latency ago.
+ */
+func (p *Player) Attack(e Entity, latency time.Duration) {
 	log.Debug("Player.Attack")
 
 	// directly search for path
 	p.findPath(e.Position())
-	p.lastAttack = time.Time{}
 
 	// setup the actions in the stack
 	p.emptyActions()
 	p.actions.Push(actions.New(actions.AttackId, actions.Attack{}))
 	p.target = e
+	p.targetLatency = latency
+}
 
+/*
+ * meleeHit deals a melee hit to the target, seen at targetPos, and returns
+ * true if it died.
+ *
+ * With a melee arc, the hit sweeps the arc in front of the player, toward the
+ * target, and also hurts every enemy in sight within the melee range.
+ */
+func (p *Player) meleeHit(targetPos d2.Vec2) (dead bool) {
+	arc := p.g.cfg.MeleeArc
+	if arc <= 0 {
+		return dealHit(p.world, p, p.target, p.combat, MeleeDamage)
+	}
+	var others []Entity
+	p.world.coneQuery(p.Pos, targetPos.Sub(p.Pos), p.g.cfg.MeleeRange, arc*math32.Pi/180,
+		func(e Entity, sqDist float32) bool {
+			if e != p.target && p.isMeleeVictim(e) && p.world.LineOfSight(p.Pos, e.Position()) {
+				others = append(others, e)
+			}
+			return true
+		})
+	// by increasing id, so that the outcome doesn't depend on the spatial index
+	sort.Slice(others, func(i, j int) bool { return others[i].Id() < others[j].Id() })
+	dead = dealHit(p.world, p, p.target, p.combat, MeleeDamage)
+	for _, e := range others {
+		dealHit(p.world, p, e, p.combat, MeleeDamage)
+	}
+	return
+}
+
+/*
+ * isMeleeVictim indicates if e is hurt by the melee hits of the player
+ * sweeping over it
+ */
+func (p *Player) isMeleeVictim(e Entity) bool {
+	switch e.(type) {
+	case *Zombie, *Player:
+		return e != Entity(p) && p.gamestate.Hostile(p.Faction(), e.Faction())
+	}
+	return false
+}
+
+/*
+ * Shoot fires a projectile in direction of target.
+ *
+ * The player keeps doing its current action. Shoot returns the projectile, or
+ * nil if the player can't shoot yet, or is out of ammo.
+ */
+func (p *Player) Shoot(target d2.Vec2) *Projectile {
+	if !p.shooting.Ready() || target.Sub(p.Pos).Len() < 1e-3 {
+		return nil
+	}
+	if !p.inventory.Take(AmmoItem, 1) {
+		return nil
+	}
+	p.shooting.Start()
+	p.FaceTowards(target)
+
+	proj := NewProjectile(p.g, p.Pos, target,
+		ProjectileSpeed, dealtDamage(p, p.combat.Damage()), ProjectileRange)
+	proj.setShooter(p)
+	p.gamestate.AddEntity(proj)
+	return proj
+}
+
+/*
+ * Throw throws a grenade in direction of target, that explodes after its
+ * fuse has burnt.
+ *
+ * The player keeps doing its current action. Throw returns the grenade, or
+ * nil if the player can't throw yet.
+ */
+func (p *Player) Throw(target d2.Vec2) *Grenade {
+	if !p.throwing.Ready() || target.Sub(p.Pos).Len() < 1e-3 {
+		return nil
+	}
+	p.throwing.Start()
+	p.FaceTowards(target)
+
+	grenade := NewGrenade(p.g, p.Pos, target, p.Faction())
+	p.gamestate.AddEntity(grenade)
+	return grenade
 }
 
 /*
@@ -373,33 +707,47 @@ func (p *Player) Operate(o Object, path Path) {
 /*
  * emptyActions removes all the actions from the actions stack.
  *
- * It removes all actions but the last one: `IdleAction`.
+ * It removes all actions but the queued moves, that the player makes once
+ * done with the new ones, and the last one: `IdleAction`. A building under
+ * construction is cancelled.
  */
 func (p *Player) emptyActions() {
-	// empty the action stack, just let the bottommost (idle)
-	for ; p.actions.Len() > 1; p.actions.Pop() {
+	p.cancelBuild()
+	p.filterActions(isQueuedMove)
+}
+
+/*
+ * cancelBuild cancels the construction of the building the player has paid
+ * for: the unfinished building is removed and its cost is refunded. Nothing
+ * is refunded if the building has been destroyed in the meantime.
+ */
+func (p *Player) cancelBuild() {
+	b, cost := p.curBuilding, p.buildCost
+	p.curBuilding, p.buildCost = nil, 0
+	if b == nil || cost == 0 || b.IsBuilt() {
+		return
+	}
+	if p.gamestate.Entity(b.Id()) != b {
+		return
 	}
+	p.gamestate.RemoveEntity(b.Id())
+	p.inventory.Add(ResourceItem, cost)
+	log.WithFields(log.Fields{"building": b, "refund": cost}).Debug("Build cancelled")
 }
 
-func (p *Player) DealDamage(damage float32) (dead bool) {
-	if damage >= p.curHP {
-		p.curHP = 0
+func (p *Player) DealDamage(damage float32, typ DamageType) (dead bool) {
+	if p.protection.Active() {
+		return false
+	}
+	p.regen.Hurt()
+	if dead = p.health.Damage(p.modifiers.takenDamage(p.resists.Apply(typ, damage))); dead {
 		p.g.PostEvent(events.NewEvent(
 			events.PlayerDeathId,
 			events.PlayerDeath{Id: p.id}))
-		dead = true
-	} else {
-		p.curHP -= damage
 	}
 	return
 }
 
 func (p *Player) HealDamage(damage float32) (healthy bool) {
-	if damage+p.curHP >= p.totalHP {
-		p.curHP = p.totalHP
-		healthy = true
-	} else {
-		p.curHP += damage
-	}
-	return
+	return p.health.Heal(damage)
 }
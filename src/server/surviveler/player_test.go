@@ -0,0 +1,242 @@
+package surviveler
+
+import (
+	"server/actions"
+	"server/events"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+/*
+ * isIdle indicates if the player has no more actions to perform
+ */
+func isIdle(p *Player) bool {
+	action, _ := p.actions.Peek()
+	return action.Type == actions.IdleId
+}
+
+func TestPlayer_StopsAtBuilding(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+
+	// walk straight through the building
+	p.Move(Path{{7.5, 2.5}, {1.5, 2.5}})
+	for i := 0; i < 100 && !isIdle(p); i++ {
+		tick(g, 50*time.Millisecond)
+		if p.Rectangle().Overlaps(b.Rectangle()) {
+			t.Fatalf("player at %v walked into the building", p.Position())
+		}
+	}
+	if !isIdle(p) {
+		t.Fatalf("player at %v should have stopped at the building", p.Position())
+	}
+	if p.Position()[0] < 3 {
+		t.Errorf("player stopped at %v, too far from the building", p.Position())
+	}
+}
+
+func TestPlayer_StopsAtPlayer(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	other := addTestPlayer(g, ProgrammerEntity, d2.Vec2{5.5, 2.5})
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+
+	p.Move(Path{{7.5, 2.5}, {1.5, 2.5}})
+	for i := 0; i < 100 && !isIdle(p); i++ {
+		tick(g, 50*time.Millisecond)
+		if p.Rectangle().Overlaps(other.Rectangle()) {
+			t.Fatalf("player at %v overlaps the other player at %v", p.Position(), other.Position())
+		}
+	}
+	if !isIdle(p) {
+		t.Fatalf("player at %v should have stopped at the other player", p.Position())
+	}
+	if !other.Position().Approx(d2.Vec2{5.5, 2.5}) {
+		t.Errorf("other player moved to %v", other.Position())
+	}
+}
+
+func TestPlayer_SlidesAlongWall(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+
+	// head diagonally into the top wall, the player should slide along it
+	p.Move(Path{{4.5, 0.5}, {1.5, 1.5}})
+	for i := 0; i < 20; i++ {
+		tick(g, 50*time.Millisecond)
+		if tile := g.state.World().TileFromWorldVec(p.Position()); !tile.IsWalkable() {
+			t.Fatalf("player at %v walked into a wall", p.Position())
+		}
+	}
+	if p.Position()[0] < 3 {
+		t.Errorf("player at %v should have slid along the wall", p.Position())
+	}
+}
+
+func TestPlayer_HealthRegen(t *testing.T) {
+	const dt = 100 * time.Millisecond
+	g := newTestGame(t, openRoom...)
+	g.cfg.PlayerRegenDelay = 1000
+	g.cfg.PlayerRegenRate = 10
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+	p.DealDamage(50, MeleeDamage)
+
+	// nothing happens during the delay
+	for i := 0; i < 10; i++ {
+		tick(g, dt)
+	}
+	if p.health.Cur != 50 || p.State().(PlayerState).Regenerating {
+		t.Fatalf("player at %v HP, regenerating = %v, before the end of the delay",
+			p.health.Cur, p.State().(PlayerState).Regenerating)
+	}
+
+	// then the hit points climb back
+	for i := 0; i < 10; i++ {
+		tick(g, dt)
+	}
+	if math32.Abs(p.health.Cur-60) > 1e-3 || !p.State().(PlayerState).Regenerating {
+		t.Fatalf("player at %v HP, regenerating = %v, want 60 HP after 1s of regen",
+			p.health.Cur, p.State().(PlayerState).Regenerating)
+	}
+
+	// a new hit resets the delay
+	p.DealDamage(20, MeleeDamage)
+	for i := 0; i < 10; i++ {
+		tick(g, dt)
+	}
+	if math32.Abs(p.health.Cur-40) > 1e-3 || p.State().(PlayerState).Regenerating {
+		t.Errorf("player at %v HP, want 40 until the delay is over again", p.health.Cur)
+	}
+
+	// up to the max
+	for i := 0; i < 100; i++ {
+		tick(g, dt)
+	}
+	if p.health.Cur != p.health.Total || p.State().(PlayerState).Regenerating {
+		t.Errorf("player at %v HP, regenerating = %v, want fully healed at %v",
+			p.health.Cur, p.State().(PlayerState).Regenerating, p.health.Total)
+	}
+}
+
+func TestPlayer_MeleeArc(t *testing.T) {
+	g := newTestGame(t,
+		"#########",
+		"#.#.....#",
+		"#.......#",
+		"#.#.....#",
+		"#########")
+	g.cfg.MeleeArc = 90
+	g.cfg.MeleeRange = 2.5
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	ally := addTestPlayer(g, TankEntity, d2.Vec2{3.2, 2.2})
+	target := addTestZombie(g, d2.Vec2{2.5, 2.5})
+	zombies := []struct {
+		name string
+		z    *Zombie
+		hit  bool
+	}{
+		{"target", target, true},
+		{"within the arc", addTestZombie(g, d2.Vec2{3.2, 3}), true},
+		{"behind a wall", addTestZombie(g, d2.Vec2{3.3, 1.2}), false},
+		{"beside the player", addTestZombie(g, d2.Vec2{1.5, 1.5}), false},
+		{"out of range", addTestZombie(g, d2.Vec2{4.5, 2.5}), false},
+	}
+
+	p.Attack(target, 0)
+	p.Update(100 * time.Millisecond)
+	for _, tt := range zombies {
+		if hit := tt.z.health.Cur < tt.z.health.Total; hit != tt.hit {
+			t.Errorf("%s: zombie hit = %v, want %v", tt.name, hit, tt.hit)
+		}
+	}
+	if ally.health.Cur != ally.health.Total {
+		t.Errorf("ally within the arc lost %v HP", ally.health.Total-ally.health.Cur)
+	}
+
+	// without an arc, only the target is hurt
+	g.cfg.MeleeArc = 0
+	for _, tt := range zombies {
+		tt.z.health.Cur = tt.z.health.Total
+	}
+	p.combat.Tick(time.Hour)
+	p.Attack(target, 0)
+	p.Update(100 * time.Millisecond)
+	for _, tt := range zombies {
+		if hit := tt.z.health.Cur < tt.z.health.Total; hit != (tt.z == target) {
+			t.Errorf("no arc, %s: zombie hit = %v", tt.name, hit)
+		}
+	}
+}
+
+func TestPlayer_AttackState(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.cfg.MeleeRange = 2.5
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{3.5, 2.5})
+
+	// the target is within the melee range but farther than a unit
+	p.Attack(z, 0)
+	if got := p.State().(PlayerState).Action.Type; got != actions.AttackId {
+		t.Errorf("within the melee range, state action = %v, want %v", got, actions.AttackId)
+	}
+
+	g.cfg.MeleeRange = 1.5
+	if got := p.State().(PlayerState).Action.Type; got != actions.MoveId {
+		t.Errorf("out of the melee range, state action = %v, want %v", got, actions.MoveId)
+	}
+}
+
+func TestPlayer_QueuedMoves(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+	move := func(x, y float32, queued bool) {
+		g.PostEvent(events.NewEvent(events.PlayerMoveId,
+			events.PlayerMove{Id: p.Id(), Xpos: x, Ypos: y, Queued: queued}))
+	}
+
+	// the queued moves are given before the path of the first one is found
+	dsts := []d2.Vec2{{7.5, 1.5}, {7.5, 3.5}, {1.5, 3.5}}
+	move(dsts[0][0], dsts[0][1], false)
+	move(dsts[1][0], dsts[1][1], true)
+	move(dsts[2][0], dsts[2][1], true)
+	visited := 0
+	for i := 0; i < 200 && visited < len(dsts); i++ {
+		tick(g, 50*time.Millisecond)
+		for j := visited + 1; j < len(dsts); j++ {
+			if p.Pos.Sub(dsts[j]).Len() < 0.1 {
+				t.Fatalf("player got to %v before %v", dsts[j], dsts[visited])
+			}
+		}
+		if p.Pos.Sub(dsts[visited]).Len() < 0.1 {
+			visited++
+		}
+	}
+	if visited < len(dsts) {
+		t.Fatalf("player at %v visited %d destinations out of %d", p.Pos, visited, len(dsts))
+	}
+	for i := 0; i < 5; i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if !isIdle(p) {
+		t.Errorf("player isn't idle once done with the queued moves")
+	}
+
+	// a move given right away clears the queue
+	move(7.5, 3.5, false)
+	move(7.5, 1.5, true)
+	tick(g, 50*time.Millisecond)
+	tick(g, 50*time.Millisecond)
+	if n := p.actions.Len(); n != 3 {
+		t.Fatalf("player has %d actions, want a move, a queued move and idle", n)
+	}
+	move(4.5, 3.5, false)
+	for i := 0; i < 100 && !isIdle(p); i++ {
+		tick(g, 50*time.Millisecond)
+	}
+	if p.Pos.Sub(d2.Vec2{4.5, 3.5}).Len() > 0.1 {
+		t.Errorf("player stopped at %v, want %v, the queued move should have been dropped", p.Pos, d2.Vec2{4.5, 3.5})
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Surviveler package
+ * projectile entities
+ */
+package surviveler
+
+import (
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+// TODO: those values should be taken from the resources
+const (
+	ProjectileSpeed = 15  // distance covered per second
+	ProjectileRange = 10  // max distance covered before vanishing
+	projectileSize  = 0.1 // half size of the projectile bounding box
+)
+
+/*
+ * Projectile is an entity travelling in straight line, that deals damage to
+ * the first enemy of its shooter it hits.
+ *
+ * It vanishes on hit, at the first tile blocking the line of sight (wall or
+ * building), or after having covered its maximum range.
+ */
+type Projectile struct {
+	id             uint32
+	projectileType EntityType
+	pos            d2.Vec2 // current position
+	dir            d2.Vec2 // normalized direction
+	speed          float32
+	damage         float32
+	maxRange       float32
+	covered        float32 // distance already covered
+	shooterId      uint32  // entity that fired the projectile
+	shooterFaction Faction // faction of the shooter, deciding who is hit
+	queryBuf       []Entity
+	g              *Game
+	world          *World
+}
+
+/*
+ * NewProjectile creates a projectile fired from org, in direction of dst
+ */
+func NewProjectile(g *Game, org, dst d2.Vec2, speed, damage, maxRange float32) *Projectile {
+	dir := dst.Sub(org)
+	dir.Normalize()
+	return &Projectile{
+		id:             InvalidID,
+		projectileType: BulletProjectile,
+		pos:            org,
+		dir:            dir,
+		speed:          speed,
+		damage:         damage,
+		maxRange:       maxRange,
+		shooterId:      InvalidID,
+		shooterFaction: PlayerFaction,
+		g:              g,
+		world:          g.State().World(),
+	}
+}
+
+/*
+ * setShooter sets the entity that fired the projectile, the projectile goes
+ * through it and the entities it can't attack
+ */
+func (p *Projectile) setShooter(e Entity) {
+	p.shooterId = e.Id()
+	p.shooterFaction = e.Faction()
+}
+
+func (p *Projectile) Id() uint32 {
+	return p.id
+}
+
+func (p *Projectile) SetId(id uint32) {
+	p.id = id
+}
+
+func (p *Projectile) Type() EntityType {
+	return p.projectileType
+}
+
+func (p *Projectile) Faction() Faction {
+	return NeutralFaction
+}
+
+func (p *Projectile) Position() d2.Vec2 {
+	return p.pos
+}
+
+func (p *Projectile) Rectangle() d2.Rectangle {
+	return d2.RectFromCircle(p.pos, projectileSize)
+}
+
+func (p *Projectile) State() EntityState {
+	return ProjectileState{
+		Type: p.projectileType,
+		Xpos: p.pos[0],
+		Ypos: p.pos[1],
+		Xdir: p.dir[0],
+		Ydir: p.dir[1],
+	}
+}
+
+/*
+ * Update moves the projectile and resolves its collisions.
+ *
+ * Collisions are checked against the whole segment covered during dt, so
+ * that fast projectiles can't go through small entities: the entities whose
+ * bounding box intersect the bounding box of the segment are fetched from
+ * the spatial index, and the first one crossed by the segment, if any, is
+ * hit.
+ */
+func (p *Projectile) Update(dt time.Duration) {
+	distance := math32.Min(float32(dt.Seconds())*p.speed, p.maxRange-p.covered)
+	delta := p.dir.Scale(distance)
+	end := p.pos.Add(delta)
+
+	// the segment is cut short by the first wall or building tile
+	reach, over := float32(1), false
+	if tile, blocked := p.world.Raycast(p.pos, end, isOpaque); blocked {
+		reach, over = 0, true
+		if tile != nil {
+			reach, _ = segmentEntry(p.pos, delta, tile.Rectangle())
+		}
+	}
+
+	// then by the first entity hit
+	hit, t := p.sweep(delta, reach)
+	if hit != nil {
+		reach, over = t, true
+		if _, ok := hit.(Building); !ok {
+			hit.DealDamage(p.damage, RangedDamage)
+		}
+	}
+
+	p.pos = p.pos.Add(delta.Scale(reach))
+	p.covered += distance * reach
+	if over || p.covered >= p.maxRange {
+		p.g.State().RemoveEntity(p.id)
+		return
+	}
+	p.world.UpdateEntity(p)
+}
+
+/*
+ * sweep returns the first entity the projectile hits, and the parameter at
+ * which it's hit, while moving by delta, up to the parameter reach.
+ *
+ * Buildings stop the projectile. The shooter, and the entities its faction
+ * isn't hostile to, are passed through.
+ */
+func (p *Projectile) sweep(delta d2.Vec2, reach float32) (hit Entity, t float32) {
+	end := p.pos.Add(delta.Scale(reach))
+	bb := d2.RectFromCircle(p.pos, projectileSize).Union(d2.RectFromCircle(end, projectileSize))
+	p.queryBuf = p.world.AABBSpatialQueryInto(bb, p.queryBuf)
+	t = reach
+	for _, e := range p.queryBuf {
+		switch e.(type) {
+		case *Zombie, *Player:
+			if e.Id() == p.shooterId || !p.g.State().Hostile(p.shooterFaction, e.Faction()) {
+				continue
+			}
+		case Building:
+		default:
+			continue
+		}
+		// the projectile box hits the entity box when its center enters
+		// the entity box grown by the projectile size
+		if et, ok := segmentEntry(p.pos, delta, e.Rectangle().Inset(-projectileSize)); ok && et <= t {
+			if hit == nil || et < t || e.Id() < hit.Id() {
+				hit, t = e, et
+			}
+		}
+	}
+	return
+}
+
+func (p *Projectile) DealDamage(damage float32, typ DamageType) bool {
+	// projectiles can't be damaged
+	return false
+}
+
+func (p *Projectile) HealDamage(damage float32) bool {
+	return true
+}
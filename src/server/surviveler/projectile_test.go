@@ -0,0 +1,205 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+var longRoom = []string{
+	"##################",
+	"#................#",
+	"#................#",
+	"#................#",
+	"##################",
+}
+
+func TestPlayer_ShootZombie(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{6.5, 2.5})
+	hp := z.health.Cur
+
+	proj := p.Shoot(z.Position())
+	if proj == nil {
+		t.Fatalf("Shoot() = nil, want a projectile")
+	}
+	if g.state.Entity(proj.Id()) != proj {
+		t.Fatalf("projectile should have been added to the game state")
+	}
+	if _, ok := g.state.pack().Projectiles[proj.Id()]; !ok {
+		t.Errorf("projectile %v not found in packed game state", proj.Id())
+	}
+	if p.Shoot(z.Position()) != nil {
+		t.Errorf("Shoot() should fail before the shoot period has elapsed")
+	}
+
+	for i := 0; i < 100 && g.state.Entity(proj.Id()) != nil; i++ {
+		proj.Update(50 * time.Millisecond)
+	}
+	if g.state.Entity(proj.Id()) != nil {
+		t.Fatalf("projectile at %v should have despawned", proj.Position())
+	}
+	if want := hp - float32(p.combat.Power); z.health.Cur != want {
+		t.Errorf("zombie HP = %v, want %v", z.health.Cur, want)
+	}
+	if proj.Position()[0] > z.Rectangle().Max[0] {
+		t.Errorf("projectile at %v went through the zombie", proj.Position())
+	}
+
+	// the shoot period elapses with the logic time
+	p.Update(ShootPeriod / 2)
+	if p.Shoot(z.Position()) != nil {
+		t.Errorf("Shoot() should fail before the shoot period has elapsed")
+	}
+	p.Update(ShootPeriod / 2)
+	if p.Shoot(z.Position()) == nil {
+		t.Errorf("Shoot() should succeed once the shoot period has elapsed")
+	}
+}
+
+func TestProjectile_MaxRange(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	proj := NewProjectile(g, d2.Vec2{1.5, 2.5}, d2.Vec2{16.5, 2.5}, ProjectileSpeed, 10, 5)
+	g.state.AddEntity(proj)
+
+	for i := 0; i < 100 && g.state.Entity(proj.Id()) != nil; i++ {
+		proj.Update(50 * time.Millisecond)
+	}
+	if g.state.Entity(proj.Id()) != nil {
+		t.Fatalf("projectile should have despawned")
+	}
+	if !proj.Position().Approx(d2.Vec2{6.5, 2.5}) {
+		t.Errorf("projectile despawned at %v, want %v", proj.Position(), d2.Vec2{6.5, 2.5})
+	}
+}
+
+func TestProjectile_FastProjectileSweep(t *testing.T) {
+	tests := []struct {
+		name string
+		y    float32 // ordinate of the projectile course
+		hit  bool
+	}{
+		{"through the center", 2.5, true},
+		{"grazing the box", 3.05, true},
+		{"passing by", 3.2, false},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, longRoom...)
+		z := addTestZombie(g, d2.Vec2{8.5, 2.5})
+		hp := z.health.Cur
+
+		// the whole room is crossed in a single update
+		proj := NewProjectile(g, d2.Vec2{1.5, tt.y}, d2.Vec2{16.5, tt.y}, 1000, 10, 15)
+		proj.setShooter(addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5}))
+		g.state.AddEntity(proj)
+		proj.Update(50 * time.Millisecond)
+
+		if g.state.Entity(proj.Id()) != nil {
+			t.Fatalf("%s: projectile should have despawned", tt.name)
+		}
+		if hit := z.health.Cur < hp; hit != tt.hit {
+			t.Errorf("%s: zombie hit = %v, want %v", tt.name, hit, tt.hit)
+		}
+		if tt.hit && proj.Position()[0] > z.Position()[0] {
+			t.Errorf("%s: projectile at %v went through the zombie", tt.name, proj.Position())
+		}
+	}
+}
+
+func TestProjectile_StoppedByWallsAndBuildings(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  d2.Vec2
+	}{
+		{"wall", d2.Vec2{1.5, -5}},
+		{"building", d2.Vec2{16.5, 2.5}},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, longRoom...)
+		b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+		z := addTestZombie(g, d2.Vec2{8.5, 2.5})
+		hp := z.health.Cur
+		proj := NewProjectile(g, d2.Vec2{1.5, 2.5}, tt.dst, ProjectileSpeed, 10, ProjectileRange)
+		g.state.AddEntity(proj)
+
+		for i := 0; i < 100 && g.state.Entity(proj.Id()) != nil; i++ {
+			proj.Update(50 * time.Millisecond)
+		}
+		if g.state.Entity(proj.Id()) != nil {
+			t.Fatalf("%s: projectile should have despawned", tt.name)
+		}
+		if proj.covered >= ProjectileRange {
+			t.Errorf("%s: projectile covered its whole range", tt.name)
+		}
+		if z.health.Cur != hp || g.state.Entity(b.Id()) == nil {
+			t.Errorf("%s: projectile shouldn't have damaged anything", tt.name)
+		}
+	}
+}
+
+func TestPlayer_ShootThroughWall(t *testing.T) {
+	tests := []struct {
+		name   string
+		player d2.Vec2
+		hit    bool
+	}{
+		{"wall in between", d2.Vec2{1.5, 2.5}, false},
+		{"clear line", d2.Vec2{1.5, 3.5}, true},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, pillarRoom...)
+		p := addTestPlayer(g, TankEntity, tt.player)
+		z := addTestZombie(g, d2.Vec2{7.5, tt.player[1]})
+		hp := z.health.Cur
+
+		proj := p.Shoot(z.Position())
+		for i := 0; i < 100 && g.state.Entity(proj.Id()) != nil; i++ {
+			proj.Update(50 * time.Millisecond)
+		}
+		if g.state.Entity(proj.Id()) != nil {
+			t.Fatalf("%s: projectile should have despawned", tt.name)
+		}
+		if hit := z.health.Cur < hp; hit != tt.hit {
+			t.Errorf("%s: zombie hit = %v, want %v", tt.name, hit, tt.hit)
+		}
+		if !tt.hit && proj.Position()[0] > 4 {
+			t.Errorf("%s: projectile at %v went through the wall", tt.name, proj.Position())
+		}
+	}
+}
+
+func TestPlayer_ShootFriendlyFire(t *testing.T) {
+	tests := []struct {
+		name         string
+		friendlyFire bool
+		hitAlly      bool
+	}{
+		{"friendly fire off", false, false},
+		{"friendly fire on", true, true},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, longRoom...)
+		g.cfg.FriendlyFire = tt.friendlyFire
+		p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+		ally := addTestPlayer(g, TankEntity, d2.Vec2{4.5, 2.5})
+		enemy := addTestPlayer(g, TankEntity, d2.Vec2{8.5, 2.5})
+		enemy.SetFaction(PlayerFaction + 1)
+		allyHP, enemyHP := ally.health.Cur, enemy.health.Cur
+
+		proj := p.Shoot(enemy.Position())
+		for i := 0; i < 100 && g.state.Entity(proj.Id()) != nil; i++ {
+			proj.Update(50 * time.Millisecond)
+		}
+		if hit := ally.health.Cur != allyHP; hit != tt.hitAlly {
+			t.Errorf("%s: ally hit = %v, want %v", tt.name, hit, tt.hitAlly)
+		}
+		if hit := enemy.health.Cur != enemyHP; hit == tt.hitAlly {
+			t.Errorf("%s: enemy hit = %v, want %v", tt.name, hit, !tt.hitAlly)
+		}
+		if p.health.Cur != p.health.Total {
+			t.Errorf("%s: the shooter hit itself", tt.name)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * Surviveler package
+ * quadtree spatial index
+ */
+package surviveler
+
+import "github.com/aurelien-rainone/gogeo/f32/d2"
+
+const (
+	quadtreeMaxEntities = 8 // number of entities a node holds before being split
+	quadtreeMaxDepth    = 8 // maximum depth of the tree
+)
+
+/*
+ * quadtree is a region quadtree indexing entities by their bounding box.
+ *
+ * An entity is stored in the deepest node whose bounds entirely contain its
+ * bounding box. As a consequence, entities straddling the boundaries between
+ * quadrants are kept by the parent node, and the root keeps the entities lying
+ * outside of its bounds.
+ */
+type quadtree struct {
+	root  *quadNode
+	nodes map[uint32]*quadNode // node in which each entity is stored
+}
+
+type quadNode struct {
+	bounds   d2.Rectangle
+	depth    int
+	parent   *quadNode
+	children []*quadNode // nil for a leaf, else the 4 quadrants
+	entities []Entity    // entities stored at this level
+}
+
+/*
+ * newQuadtree creates an empty quadtree covering bounds
+ */
+func newQuadtree(bounds d2.Rectangle) *quadtree {
+	return &quadtree{
+		root:  &quadNode{bounds: bounds},
+		nodes: make(map[uint32]*quadNode),
+	}
+}
+
+/*
+ * insert adds an entity to the quadtree
+ */
+func (qt *quadtree) insert(ent Entity) {
+	qt.root.insert(qt, ent, ent.Rectangle())
+}
+
+/*
+ * remove removes an entity from the quadtree
+ */
+func (qt *quadtree) remove(ent Entity) {
+	n, ok := qt.nodes[ent.Id()]
+	if !ok {
+		return
+	}
+	delete(qt.nodes, ent.Id())
+	for i, e := range n.entities {
+		if e.Id() == ent.Id() {
+			last := len(n.entities) - 1
+			n.entities[i] = n.entities[last]
+			n.entities[last] = nil
+			n.entities = n.entities[:last]
+			break
+		}
+	}
+
+	// merge the nodes that have become too sparse
+	for ; n != nil; n = n.parent {
+		if n.children == nil {
+			continue
+		}
+		if n.count(quadtreeMaxEntities) > quadtreeMaxEntities {
+			break
+		}
+		n.merge(qt)
+	}
+}
+
+/*
+ * update moves an entity to the node corresponding to its current bounding
+ * box, if it has changed
+ */
+func (qt *quadtree) update(ent Entity) {
+	bb := ent.Rectangle()
+	if n, ok := qt.nodes[ent.Id()]; ok {
+		inNode := n == qt.root || bb.In(n.bounds)
+		if inNode && (n.children == nil || n.childContaining(bb) == nil) {
+			// still in the right node
+			return
+		}
+	}
+	qt.remove(ent)
+	qt.root.insert(qt, ent, bb)
+}
+
+/*
+ * query calls f for each entity whose bounding box overlaps with bb.
+ *
+ * If f returns false, query stops the iteration immediately.
+ */
+func (qt *quadtree) query(bb d2.Rectangle, f func(Entity) bool) {
+	qt.root.query(bb, f)
+}
+
+func (n *quadNode) insert(qt *quadtree, ent Entity, bb d2.Rectangle) {
+	if n.children != nil {
+		if child := n.childContaining(bb); child != nil {
+			child.insert(qt, ent, bb)
+			return
+		}
+	}
+	n.entities = append(n.entities, ent)
+	qt.nodes[ent.Id()] = n
+	if n.children == nil && len(n.entities) > quadtreeMaxEntities && n.depth < quadtreeMaxDepth {
+		n.split(qt)
+	}
+}
+
+/*
+ * split divides a leaf into 4 quadrants and redistributes its entities
+ */
+func (n *quadNode) split(qt *quadtree) {
+	min, c, max := n.bounds.Min, n.bounds.Center(), n.bounds.Max
+	n.children = []*quadNode{
+		{bounds: d2.Rect(min[0], min[1], c[0], c[1])},
+		{bounds: d2.Rect(c[0], min[1], max[0], c[1])},
+		{bounds: d2.Rect(min[0], c[1], c[0], max[1])},
+		{bounds: d2.Rect(c[0], c[1], max[0], max[1])},
+	}
+	for _, child := range n.children {
+		child.depth = n.depth + 1
+		child.parent = n
+	}
+	entities := n.entities
+	n.entities = nil
+	for _, ent := range entities {
+		n.insert(qt, ent, ent.Rectangle())
+	}
+}
+
+/*
+ * merge gathers all the entities of the subtree into the node, that becomes
+ * a leaf
+ */
+func (n *quadNode) merge(qt *quadtree) {
+	var collect func(*quadNode)
+	collect = func(child *quadNode) {
+		for _, ent := range child.entities {
+			n.entities = append(n.entities, ent)
+			qt.nodes[ent.Id()] = n
+		}
+		for _, c := range child.children {
+			collect(c)
+		}
+	}
+	for _, child := range n.children {
+		collect(child)
+	}
+	n.children = nil
+}
+
+/*
+ * count returns the number of entities in the subtree, stopping as soon as
+ * limit is exceeded
+ */
+func (n *quadNode) count(limit int) int {
+	total := len(n.entities)
+	for _, child := range n.children {
+		if total > limit {
+			break
+		}
+		total += child.count(limit - total)
+	}
+	return total
+}
+
+/*
+ * childContaining returns the child node entirely containing bb, or nil
+ */
+func (n *quadNode) childContaining(bb d2.Rectangle) *quadNode {
+	for _, child := range n.children {
+		if bb.In(child.bounds) {
+			return child
+		}
+	}
+	return nil
+}
+
+func (n *quadNode) query(bb d2.Rectangle, f func(Entity) bool) bool {
+	for _, ent := range n.entities {
+		if ent.Rectangle().Overlaps(bb) && !f(ent) {
+			return false
+		}
+	}
+	for _, child := range n.children {
+		if child.bounds.Overlaps(bb) && !child.query(bb, f) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,268 @@
+package surviveler
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+/*
+ * newTestRoom returns the rows of an open square room of given size, walls
+ * included
+ */
+func newTestRoom(size int) []string {
+	wall := strings.Repeat("#", size)
+	inner := "#" + strings.Repeat(".", size-2) + "#"
+	rows := []string{wall}
+	for i := 0; i < size-2; i++ {
+		rows = append(rows, inner)
+	}
+	return append(rows, wall)
+}
+
+func randomPos(rnd *rand.Rand, size float32) d2.Vec2 {
+	return d2.Vec2{1 + rnd.Float32()*(size-2), 1 + rnd.Float32()*(size-2)}
+}
+
+/*
+ * newCrowdedGame creates a game with n zombies randomly placed in a square
+ * room of given size
+ */
+func newCrowdedGame(t testing.TB, rnd *rand.Rand, size, n int) (*Game, []*Zombie) {
+	g := newTestGame(t, newTestRoom(size)...)
+	zombies := make([]*Zombie, n)
+	for i := range zombies {
+		zombies[i] = addTestZombie(g, randomPos(rnd, float32(size)))
+	}
+	return g, zombies
+}
+
+/*
+ * naiveSpatialQuery performs a spatial query by scanning every entity
+ */
+func naiveSpatialQuery(gs *GameState, bb d2.Rectangle) *EntitySet {
+	set := NewEntitySet()
+	for _, ent := range gs.entities {
+		if ent.Rectangle().Overlaps(bb) {
+			set.Add(ent)
+		}
+	}
+	return set
+}
+
+/*
+ * naiveNearestEntity performs a nearest entity query by scanning every entity
+ */
+func naiveNearestEntity(gs *GameState, pos d2.Vec2, radius float32, f EntityFilter) (Entity, float32) {
+	var (
+		nearest Entity
+		minDist float32
+	)
+	for _, ent := range gs.entities {
+		if d := ent.Position().Sub(pos).Len(); d <= radius && f(ent) && (nearest == nil || d < minDist) {
+			nearest, minDist = ent, d
+		}
+	}
+	return nearest, minDist
+}
+
+func sameEntities(a, b *EntitySet) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	same := true
+	a.Each(func(e Entity) bool {
+		same = b.Contains(e)
+		return same
+	})
+	return same
+}
+
+func checkSpatialQueries(t *testing.T, rnd *rand.Rand, g *Game, size float32) {
+	var buf []Entity
+	for i := 0; i < 100; i++ {
+		// some queries partially lie outside of the world
+		bb := d2.RectFromCircle(randomPos(rnd, size), rnd.Float32()*size/4)
+		got := g.state.World().AABBSpatialQuery(bb)
+		want := naiveSpatialQuery(g.state, bb)
+		if !sameEntities(got, want) {
+			t.Fatalf("AABBSpatialQuery(%v) returned %d entities, want %d", bb, got.Len(), want.Len())
+		}
+
+		// same query, reusing the buffer of the previous one
+		buf = g.state.World().AABBSpatialQueryInto(bb, buf)
+		into := NewEntitySet()
+		for _, ent := range buf {
+			into.Add(ent)
+		}
+		if len(buf) != into.Len() || !sameEntities(into, want) {
+			t.Fatalf("AABBSpatialQueryInto(%v) returned %d entities, want %d", bb, len(buf), want.Len())
+		}
+	}
+}
+
+func TestWorld_AABBSpatialQueryMatchesNaiveScan(t *testing.T) {
+	const size = 64
+	rnd := rand.New(rand.NewSource(1))
+	g, zombies := newCrowdedGame(t, rnd, size, 500)
+	checkSpatialQueries(t, rnd, g, size)
+
+	// move entities around, some of them out of the world bounds
+	for _, z := range zombies {
+		z.Pos = z.Pos.Add(d2.Vec2{rnd.Float32()*8 - 4, rnd.Float32()*8 - 4})
+		g.state.World().UpdateEntity(z)
+	}
+	checkSpatialQueries(t, rnd, g, size)
+
+	// remove most of them so that quadrants get merged
+	for _, z := range zombies[50:] {
+		g.state.RemoveEntity(z.Id())
+	}
+	checkSpatialQueries(t, rnd, g, size)
+	if n := g.state.World().index.root.count(len(zombies)); n != 50 {
+		t.Errorf("quadtree contains %d entities, want 50", n)
+	}
+}
+
+func TestGameState_NearestEntityMatchesNaiveScan(t *testing.T) {
+	const size = 64
+	rnd := rand.New(rand.NewSource(2))
+	g, _ := newCrowdedGame(t, rnd, size, 500)
+
+	for i := 0; i < 100; i++ {
+		pos, radius := randomPos(rnd, size), rnd.Float32()*size/4
+		want := make(entityDistCollection, 0)
+		for _, ent := range g.state.entities {
+			if d := ent.Position().Sub(pos).Len(); d <= radius {
+				want = append(want, entityDist{e: ent, d: d})
+			}
+		}
+		got := g.state.KNearestEntities(pos, len(g.state.entities), radius, isZombie)
+		if len(got) != len(want) {
+			t.Fatalf("KNearestEntities(%v, %v) returned %d entities, want %d", pos, radius, len(got), len(want))
+		}
+		for j := 1; j < len(got); j++ {
+			if got[j].Position().Sub(pos).Len() < got[j-1].Position().Sub(pos).Len() {
+				t.Fatalf("KNearestEntities(%v, %v) isn't sorted by distance", pos, radius)
+			}
+		}
+		var nearest Entity
+		if len(got) > 0 {
+			nearest = got[0]
+		}
+		ent, d := g.state.NearestEntity(pos, radius, isZombie)
+		if ent != nearest {
+			t.Fatalf("NearestEntity(%v, %v) = %v, want %v", pos, radius, ent, nearest)
+		}
+		if want, wantDist := naiveNearestEntity(g.state, pos, radius, isZombie); (want == nil) != (ent == nil) || d != wantDist {
+			t.Fatalf("NearestEntity(%v, %v) = %v at %v, want %v at %v", pos, radius, ent, d, want, wantDist)
+		}
+	}
+}
+
+func TestWorld_IntersectingTiles(t *testing.T) {
+	// tiles are half a unit large
+	w := newTestWorld(t, 2, newTestRoom(8)...)
+
+	tests := []struct {
+		bb   d2.Rectangle
+		want int
+	}{
+		{d2.Rect(1.1, 1.1, 1.4, 1.4), 1},
+		{d2.Rect(1.1, 1.1, 1.6, 1.4), 2},
+		{d2.Rect(1.1, 1.1, 1.6, 1.6), 4},
+		{d2.RectFromCircle(d2.Vec2{2, 2}, 0.6), 16},
+		{d2.Rect(-1, -1, 0.25, 0.25), 1},
+		{d2.Rect(-2, -2, -1, -1), 0},
+	}
+	for _, tt := range tests {
+		tiles := w.IntersectingTiles(tt.bb)
+		if len(tiles) != tt.want {
+			t.Errorf("IntersectingTiles(%v) returned %d tiles, want %d", tt.bb, len(tiles), tt.want)
+		}
+		for _, tile := range tiles {
+			if !tile.Rectangle().Overlaps(tt.bb) {
+				t.Errorf("IntersectingTiles(%v) returned %#v that doesn't intersect", tt.bb, *tile)
+			}
+		}
+	}
+}
+
+func BenchmarkAABBSpatialQuery(b *testing.B) {
+	const size = 128
+	rnd := rand.New(rand.NewSource(1))
+	g, _ := newCrowdedGame(b, rnd, size, 1000)
+	queries := make([]d2.Rectangle, 1000)
+	for i := range queries {
+		queries[i] = d2.RectFromCircle(randomPos(rnd, size), 2)
+	}
+
+	b.Run("naive", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			naiveSpatialQuery(g.state, queries[i%len(queries)])
+		}
+	})
+	b.Run("quadtree", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			g.state.World().AABBSpatialQuery(queries[i%len(queries)])
+		}
+	})
+	b.Run("quadtree into buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []Entity
+		for i := 0; i < b.N; i++ {
+			buf = g.state.World().AABBSpatialQueryInto(queries[i%len(queries)], buf)
+		}
+	})
+}
+
+/*
+ * BenchmarkZombie_MoveOrSlide measures the zombie movement hot path, each
+ * zombie walking toward the center of a crowded room
+ */
+func BenchmarkZombie_MoveOrSlide(b *testing.B) {
+	const size = 128
+	rnd := rand.New(rand.NewSource(1))
+	g, zombies := newCrowdedGame(b, rnd, size, 1000)
+	center := d2.Vec2{size / 2, size / 2}
+	for _, z := range zombies {
+		z.SetPath(Path{center})
+	}
+	world := g.state.World()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z := zombies[i%len(zombies)]
+		if moved, _ := z.MoveOrSlide(world, z, 10*time.Millisecond, isZombieObstacle); moved {
+			world.UpdateEntity(z)
+		}
+	}
+}
+
+func BenchmarkNearestEntity(b *testing.B) {
+	const size = 128
+	rnd := rand.New(rand.NewSource(1))
+	g, _ := newCrowdedGame(b, rnd, size, 1000)
+	positions := make([]d2.Vec2, 1000)
+	for i := range positions {
+		positions[i] = randomPos(rnd, size)
+	}
+
+	// both run the same query, within the same radius
+	const radius = 10
+	b.Run("naive", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			naiveNearestEntity(g.state, positions[i%len(positions)], radius, isZombie)
+		}
+	})
+	b.Run("quadtree", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g.state.NearestEntity(positions[i%len(positions)], radius, isZombie)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+/*
+ * Surviveler package
+ * connected regions of the walkable tiles
+ */
+package surviveler
+
+// region of the tiles that can't be walked on
+const noRegion = -1
+
+/*
+ * Regions labels each walkable tile of the grid with the id of its connected
+ * region: 2 walkable tiles are in the same region if, and only if, a path
+ * exists between them.
+ *
+ * As moving diagonally requires both orthogonal neighbours to be walkable,
+ * regions are 4-connected. The labels are computed on the static layer and
+ * the buildings of the occupancy layer, and rebuilt when a building blocks or
+ * frees tiles.
+ */
+type Regions struct {
+	width   int
+	labels  []int32 // region id of each tile, noRegion if not walkable
+	count   int     // number of regions
+	version uint64  // occupancy version the labels were computed at
+}
+
+/*
+ * newRegions labels the connected regions of the walkable tiles of w
+ */
+func newRegions(w *World) *Regions {
+	r := &Regions{
+		width:   w.GridWidth,
+		labels:  make([]int32, len(w.Grid)),
+		version: w.occupancy.version,
+	}
+	for i := range r.labels {
+		r.labels[i] = noRegion
+	}
+
+	var stack []int
+	for i := range w.Grid {
+		if r.labels[i] != noRegion || !w.Grid[i].IsWalkable() {
+			continue
+		}
+		// flood fill a new region
+		id := int32(r.count)
+		r.count++
+		r.labels[i] = id
+		for stack = append(stack[:0], i); len(stack) > 0; {
+			t := &w.Grid[stack[len(stack)-1]]
+			stack = stack[:len(stack)-1]
+			for _, d := range [4][2]int{{0, -1}, {-1, 0}, {0, 1}, {1, 0}} {
+				n, ok := w.TileAt(t.X+d[0], t.Y+d[1])
+				if !ok {
+					continue
+				}
+				j := n.X + n.Y*r.width
+				if r.labels[j] == noRegion && n.IsWalkable() {
+					r.labels[j] = id
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+	return r
+}
+
+/*
+ * Region returns the id of the region of the tile at grid coordinates
+ * (x, y), or -1 if the tile isn't walkable
+ */
+func (r *Regions) Region(x, y int) int {
+	return int(r.labels[x+y*r.width])
+}
+
+/*
+ * Len returns the number of regions
+ */
+func (r *Regions) Len() int {
+	return r.count
+}
@@ -0,0 +1,109 @@
+package surviveler
+
+import (
+	"testing"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+var sealedRooms = []string{
+	"###########",
+	"#...#.....#",
+	"#...#.....#",
+	"#...#.....#",
+	"###########",
+}
+
+func TestRegions_SealedRooms(t *testing.T) {
+	g := newTestGame(t, sealedRooms...)
+	world := g.state.World()
+	regions := world.Regions()
+	if regions.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2 sealed rooms", regions.Len())
+	}
+	if regions.Region(1, 1) == regions.Region(5, 1) {
+		t.Errorf("both rooms are in region %d", regions.Region(1, 1))
+	}
+	if regions.Region(1, 1) != regions.Region(3, 3) {
+		t.Errorf("tiles of the same room are in regions %d and %d", regions.Region(1, 1), regions.Region(3, 3))
+	}
+	if r := regions.Region(4, 2); r != noRegion {
+		t.Errorf("wall tile is in region %d", r)
+	}
+
+	tests := []struct {
+		name     string
+		org, dst d2.Vec2
+		found    bool
+	}{
+		{"left room", d2.Vec2{1.5, 1.5}, d2.Vec2{3.5, 3.5}, true},
+		{"right room", d2.Vec2{5.5, 3.5}, d2.Vec2{9.5, 1.5}, true},
+		{"across the wall", d2.Vec2{1.5, 1.5}, d2.Vec2{9.5, 1.5}, false},
+	}
+	for _, tt := range tests {
+		path, _, found := g.Pathfinder().FindPath(tt.org, tt.dst)
+		if found != tt.found {
+			t.Errorf("%s: FindPath() found = %v, want %v", tt.name, found, tt.found)
+		}
+		if found && (!path[0].Approx(tt.dst) || !path[len(path)-1].Approx(tt.org)) {
+			t.Errorf("%s: path %v doesn't lead from %v to %v", tt.name, path, tt.org, tt.dst)
+		}
+	}
+
+	// the search across the wall doesn't even reach the workers
+	pf := NewPathfinder(g)
+	delivered, found := false, true
+	pf.Request(d2.Vec2{1.5, 1.5}, d2.Vec2{9.5, 1.5}, func(path Path, ok bool) {
+		delivered, found = true, ok
+	})
+	if pf.snapshot != nil {
+		t.Errorf("a search has been started across the wall")
+	}
+	pf.Deliver()
+	if !delivered || found {
+		t.Errorf("request delivered = %v with found = %v, want not found", delivered, found)
+	}
+
+	// the nearest reachable tile is still searched for
+	var path Path
+	pf.RequestNearest(d2.Vec2{1.5, 1.5}, d2.Vec2{9.5, 1.5}, func(p Path, ok bool) { path = p })
+	pf.Deliver()
+	if len(path) == 0 || !path[0].Approx(d2.Vec2{3.5, 1.5}) {
+		t.Errorf("path %v should lead to the closest tile of the left room", path)
+	}
+}
+
+func TestRegions_Building(t *testing.T) {
+	g := newTestGame(t,
+		"###########",
+		"#...#.....#",
+		"#.........#",
+		"#...#.....#",
+		"###########",
+	)
+	world := g.state.World()
+	org, dst := d2.Vec2{1.5, 1.5}, d2.Vec2{9.5, 1.5}
+	if n := world.Regions().Len(); n != 1 {
+		t.Fatalf("Len() = %d, want a single region", n)
+	}
+
+	// a barricade seals the doorway
+	b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{4.5, 2.5})
+	if n := world.Regions().Len(); n != 2 {
+		t.Errorf("Len() = %d with the doorway blocked, want 2", n)
+	}
+	if world.Reachable(world.TileFromWorldVec(org), world.TileFromWorldVec(dst)) {
+		t.Errorf("Reachable() = true through the barricade")
+	}
+	if _, _, found := g.Pathfinder().FindPath(org, dst); found {
+		t.Errorf("FindPath() found a path through the barricade")
+	}
+
+	g.state.RemoveEntity(b.Id())
+	if n := world.Regions().Len(); n != 1 {
+		t.Errorf("Len() = %d once the barricade is removed, want 1", n)
+	}
+	if _, _, found := g.Pathfinder().FindPath(org, dst); !found {
+		t.Errorf("FindPath() found no path once the barricade is removed")
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * Surviveler package
+ * recording and replay of client events
+ */
+package surviveler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"server/events"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ugorji/go/codec"
+)
+
+/*
+ * replayEventTypes associates the types of the events originating from the
+ * clients with their payload type. Only those events are recorded, as the
+ * other ones are the result of the game simulation.
+ */
+var replayEventTypes = map[events.Type]reflect.Type{
+	events.PlayerJoinId:    reflect.TypeOf(events.PlayerJoin{}),
+	events.PlayerLeaveId:   reflect.TypeOf(events.PlayerLeave{}),
+	events.PlayerMoveId:    reflect.TypeOf(events.PlayerMove{}),
+	events.PlayerBuildId:   reflect.TypeOf(events.PlayerBuild{}),
+	events.PlayerRepairId:  reflect.TypeOf(events.PlayerRepair{}),
+	events.PlayerAttackId:  reflect.TypeOf(events.PlayerAttack{}),
+	events.PlayerShootId:   reflect.TypeOf(events.PlayerShoot{}),
+	events.PlayerThrowId:   reflect.TypeOf(events.PlayerThrow{}),
+	events.PlayerOperateId: reflect.TypeOf(events.PlayerOperate{}),
+}
+
+/*
+ * replayRecord is a client event, as written in a replay file
+ */
+type replayRecord struct {
+	Tick    uint64      // logic tick during which the event has been processed
+	NextId  uint32      // value of the entity id counter at that time
+	Type    events.Type // event type
+	Payload []byte      // msgpack encoded event payload
+}
+
+/*
+ * replayHeader is written at the beginning of a replay file
+ */
+type replayHeader struct {
+	Seed int64 // seed of the game random number generators
+}
+
+/*
+ * Recorder writes the client events processed by the game into a replay file
+ */
+type Recorder struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *codec.Encoder
+}
+
+/*
+ * NewRecorder creates a recorder writing into the file at path, for a game
+ * whose random number generators are seeded with seed
+ */
+func NewRecorder(path string, seed int64) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	var mh codec.MsgpackHandle
+	w := bufio.NewWriter(f)
+	r := &Recorder{f: f, w: w, enc: codec.NewEncoder(w, &mh)}
+	if err := r.enc.Encode(replayHeader{Seed: seed}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+/*
+ * record appends an event processed during given tick to the replay file
+ */
+func (r *Recorder) record(tick uint64, nextId uint32, evt *events.Event) {
+	rec := replayRecord{Tick: tick, NextId: nextId, Type: evt.Type}
+
+	var mh codec.MsgpackHandle
+	if err := codec.NewEncoderBytes(&rec.Payload, &mh).Encode(evt.Payload); err != nil {
+		log.WithError(err).WithField("event", evt).Error("Couldn't encode event payload")
+		return
+	}
+	if err := r.enc.Encode(rec); err != nil {
+		log.WithError(err).WithField("event", evt).Error("Couldn't record event")
+	}
+}
+
+/*
+ * Close flushes the recorded events and closes the replay file
+ */
+func (r *Recorder) Close() error {
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+/*
+ * Replayer re-injects the client events read from a replay file into the
+ * game, at the logic tick they had been processed when recorded.
+ */
+type Replayer struct {
+	seed    int64 // seed of the recorded game random number generators
+	records []replayRecord
+	next    int // index of the next record to replay
+}
+
+/*
+ * NewReplayer creates a replayer from the replay file at path
+ */
+func NewReplayer(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mh codec.MsgpackHandle
+	dec := codec.NewDecoder(bufio.NewReader(f), &mh)
+	var hdr replayHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return nil, fmt.Errorf("can't read replay file header %v: %v", path, err)
+	}
+	r := &Replayer{seed: hdr.Seed}
+	for {
+		var rec replayRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("can't read replay file %v: %v", path, err)
+		}
+		if _, ok := replayEventTypes[rec.Type]; !ok {
+			return nil, fmt.Errorf("unexpected event type in replay file: %v", rec.Type)
+		}
+		r.records = append(r.records, rec)
+	}
+	log.WithFields(log.Fields{"path": path, "events": len(r.records)}).Info("Replay loaded")
+	return r, nil
+}
+
+/*
+ * inject posts the events recorded during given tick
+ */
+func (r *Replayer) inject(tick uint64, g *Game) {
+	var mh codec.MsgpackHandle
+	for ; r.next < len(r.records) && r.records[r.next].Tick <= tick; r.next++ {
+		rec := r.records[r.next]
+		payload := reflect.New(replayEventTypes[rec.Type])
+		if err := codec.NewDecoderBytes(rec.Payload, &mh).Decode(payload.Interface()); err != nil {
+			log.WithError(err).WithField("type", rec.Type).Error("Couldn't decode replayed event")
+			continue
+		}
+		// some entity ids may have been allocated outside of the game loop
+		// while recording, i.e for connecting clients
+		g.state.ids.Reserve(rec.NextId)
+		g.PostEvent(events.NewEvent(rec.Type, payload.Elem().Interface()))
+		if r.Done() {
+			log.Info("Replay finished")
+		}
+	}
+}
+
+/*
+ * Done indicates if all the recorded events have been replayed
+ */
+func (r *Replayer) Done() bool {
+	return r.next >= len(r.records)
+}
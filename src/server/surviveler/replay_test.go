@@ -0,0 +1,155 @@
+package surviveler
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"server/events"
+	"testing"
+	"time"
+)
+
+/*
+ * playSession runs n logic ticks of dt, calling post before each tick
+ */
+func playSession(g *Game, n int, dt time.Duration, post func(tick int)) {
+	for i := 0; i < n; i++ {
+		if post != nil {
+			post(i)
+		}
+		g.logicTick(dt)
+	}
+}
+
+/*
+ * replaySession records a session of n logic ticks of dt starting at
+ * gameTime, the client events being posted by post, then replays it in a
+ * fresh game. It returns the recorded game and the replayed one.
+ */
+func replaySession(t *testing.T, gameTime int16, n int, dt time.Duration, post func(g *Game, tick int)) (g, replay *Game) {
+	f, err := ioutil.TempFile("", "surviveler-replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	g = newTestGameFromAssets(t, testAssets)
+	g.state.gameTime = gameTime
+	if g.recorder, err = NewRecorder(f.Name(), g.rng.Seed()); err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+	g.registerRecorder()
+	playSession(g, n, dt, func(tick int) { post(g, tick) })
+	if err := g.recorder.Close(); err != nil {
+		t.Fatalf("Recorder.Close() error = %v", err)
+	}
+
+	replay = newTestGameFromAssets(t, testAssets)
+	replay.state.gameTime = gameTime
+	if replay.replayer, err = NewReplayer(f.Name()); err != nil {
+		t.Fatalf("NewReplayer() error = %v", err)
+	}
+	playSession(replay, n, dt, nil)
+	if !replay.replayer.Done() {
+		t.Errorf("some recorded events have not been replayed")
+	}
+	return g, replay
+}
+
+func TestReplay_ReproducesSession(t *testing.T) {
+	// in day time so that no zombies are spawned
+	var p1, p2 uint32
+	g, replay := replaySession(t, 720, 300, 10*time.Millisecond, func(g *Game, tick int) {
+		switch tick {
+		case 0:
+			// simulate a connecting client that will never join
+			g.state.allocEntityId()
+			p1 = g.state.allocEntityId()
+			g.postClientEvent(events.NewEvent(events.PlayerJoinId,
+				events.PlayerJoin{Id: p1, Type: uint8(TankEntity)}))
+		case 13:
+			p2 = g.state.allocEntityId()
+			g.postClientEvent(events.NewEvent(events.PlayerJoinId,
+				events.PlayerJoin{Id: p2, Type: uint8(EngineerEntity)}))
+		case 20:
+			g.postClientEvent(events.NewEvent(events.PlayerMoveId,
+				events.PlayerMove{Id: p1, Xpos: 8.5, Ypos: 1.5}))
+		case 21:
+			g.postClientEvent(events.NewEvent(events.PlayerMoveId,
+				events.PlayerMove{Id: p2, Xpos: 1.5, Ypos: 1.5}))
+		case 150:
+			g.postClientEvent(events.NewEvent(events.PlayerMoveId,
+				events.PlayerMove{Id: p1, Xpos: 4.5, Ypos: 6.5}))
+		}
+	})
+
+	want, got := g.state.pack(), replay.state.pack()
+	if len(got.Entities) != 2 {
+		t.Errorf("got %d entities after replay, want 2", len(got.Entities))
+	}
+	// only the timestamp should differ
+	got.Tstamp = want.Tstamp
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed game state = %+v, want %+v", got, want)
+	}
+	if replay.state.ids.Count() != g.state.ids.Count() {
+		t.Errorf("entity id counter = %v, want %v", replay.state.ids.Count(), g.state.ids.Count())
+	}
+}
+
+func TestReplay_ReproducesNight(t *testing.T) {
+	// at night, the AI director spawns zombies chasing the player
+	var p uint32
+	g, replay := replaySession(t, 1100, 800, 100*time.Millisecond, func(g *Game, tick int) {
+		switch tick {
+		case 0:
+			p = g.state.allocEntityId()
+			g.postClientEvent(events.NewEvent(events.PlayerJoinId,
+				events.PlayerJoin{Id: p, Type: uint8(TankEntity)}))
+		case 10:
+			g.postClientEvent(events.NewEvent(events.PlayerMoveId,
+				events.PlayerMove{Id: p, Xpos: 8.5, Ypos: 1.5}))
+		case 400:
+			g.postClientEvent(events.NewEvent(events.PlayerMoveId,
+				events.PlayerMove{Id: p, Xpos: 4.5, Ypos: 6.5}))
+		}
+	})
+
+	var zombies int
+	for id, ent := range g.state.entities {
+		z, ok := ent.(*Zombie)
+		if !ok {
+			continue
+		}
+		zombies++
+		rz, ok := replay.state.Entity(id).(*Zombie)
+		if !ok {
+			t.Errorf("zombie %d missing from the replay", id)
+			continue
+		}
+		if !reflect.DeepEqual(rz.Pos, z.Pos) || rz.health.Cur != z.health.Cur || rz.curState != z.curState {
+			t.Errorf("replayed zombie %d at %v with %v HP, state %d, want %v with %v HP, state %d",
+				id, rz.Pos, rz.health.Cur, rz.curState, z.Pos, z.health.Cur, z.curState)
+		}
+	}
+	if zombies == 0 {
+		t.Fatalf("no zombie spawned during the night session")
+	}
+	want, got := g.state.pack(), replay.state.pack()
+	got.Tstamp = want.Tstamp
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed game state = %+v, want %+v", got, want)
+	}
+}
+
+func TestReplay_IgnoresClientEvents(t *testing.T) {
+	g := newTestGameFromAssets(t, testAssets)
+	g.replayer = &Replayer{}
+	g.postClientEvent(events.NewEvent(events.PlayerJoinId,
+		events.PlayerJoin{Id: g.state.allocEntityId(), Type: uint8(TankEntity)}))
+	g.logicTick(10 * time.Millisecond)
+	if len(g.state.entities) != 0 {
+		t.Errorf("client events should be ignored during a replay")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Surviveler package
+ * seeded random number generation
+ */
+package surviveler
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+/*
+ * RNG is a seeded random number generator.
+ *
+ * The game subsystems draw their random numbers from their own generator,
+ * derived from the game one (see Derive) rather than from the global
+ * math/rand source, so that a game can be reproduced from its seed, i.e for
+ * tests and replays. Each subsystem having its own sequence, the numbers
+ * drawn by one subsystem don't depend on what the others draw.
+ */
+type RNG struct {
+	seed  int64
+	mutex sync.Mutex
+	rnd   *rand.Rand
+}
+
+/*
+ * NewRNG creates a random number generator seeded with seed
+ */
+func NewRNG(seed int64) *RNG {
+	return &RNG{seed: seed, rnd: rand.New(rand.NewSource(seed))}
+}
+
+/*
+ * Seed returns the seed of the generator
+ */
+func (r *RNG) Seed() int64 {
+	return r.seed
+}
+
+/*
+ * Derive returns a new generator for the subsystem called name, whose seed
+ * only depends on the seed of r and on name
+ */
+func (r *RNG) Derive(name string) *RNG {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return NewRNG(r.seed ^ int64(h.Sum64()))
+}
+
+/*
+ * Intn returns an int in [0, n), n must be positive
+ */
+func (r *RNG) Intn(n int) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rnd.Intn(n)
+}
+
+/*
+ * Float32 returns a float32 in [0, 1)
+ */
+func (r *RNG) Float32() float32 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rnd.Float32()
+}
+
+/*
+ * Range returns a float32 uniformly distributed in [min, max)
+ */
+func (r *RNG) Range(min, max float32) float32 {
+	return min + r.Float32()*(max-min)
+}
+
+/*
+ * Chance returns true with the probability p
+ */
+func (r *RNG) Chance(p float32) bool {
+	return r.Float32() < p
+}
+
+/*
+ * Normal returns a float32 normally distributed, of given mean and standard
+ * deviation
+ */
+func (r *RNG) Normal(mean, stddev float32) float32 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return mean + float32(r.rnd.NormFloat64())*stddev
+}
+
+/*
+ * InCircle returns a point uniformly distributed in the circle of given
+ * center and radius
+ */
+func (r *RNG) InCircle(center d2.Vec2, radius float32) d2.Vec2 {
+	dist := radius * math32.Sqrt(r.Float32())
+	angle := r.Range(0, 2*math32.Pi)
+	return center.Add(d2.Vec2{dist * math32.Cos(angle), dist * math32.Sin(angle)})
+}
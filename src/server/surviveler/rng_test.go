@@ -0,0 +1,133 @@
+package surviveler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+/*
+ * draws draws a few numbers of every distribution
+ */
+func draws(r *RNG) []float32 {
+	var s []float32
+	for i := 0; i < 10; i++ {
+		pt := r.InCircle(d2.Vec2{1, 1}, 2)
+		s = append(s, float32(r.Intn(100)), r.Float32(), r.Range(-5, 5), r.Normal(10, 2), pt[0], pt[1])
+		if r.Chance(0.5) {
+			s = append(s, 1)
+		}
+	}
+	return s
+}
+
+func equalDraws(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRNG_Reproducible(t *testing.T) {
+	if !equalDraws(draws(NewRNG(42)), draws(NewRNG(42))) {
+		t.Errorf("generators with the same seed drew different numbers")
+	}
+	if equalDraws(draws(NewRNG(42)), draws(NewRNG(43))) {
+		t.Errorf("generators with different seeds drew the same numbers")
+	}
+
+	// derived generators only depend on the seed and the subsystem name
+	a, b := NewRNG(42), NewRNG(42)
+	a.Intn(10)
+	if !equalDraws(draws(a.Derive("ai")), draws(b.Derive("ai"))) {
+		t.Errorf("generators derived for the same subsystem drew different numbers")
+	}
+	if equalDraws(draws(a.Derive("ai")), draws(a.Derive("loot"))) {
+		t.Errorf("generators derived for different subsystems drew the same numbers")
+	}
+
+	for i := 0; i < 100; i++ {
+		r := NewRNG(int64(i))
+		if x := r.Range(2, 3); x < 2 || x >= 3 {
+			t.Fatalf("Range(2, 3) = %v", x)
+		}
+		if pt := r.InCircle(d2.Vec2{1, 1}, 2); pt.Sub(d2.Vec2{1, 1}).Len() > 2 {
+			t.Fatalf("InCircle() = %v, out of the circle", pt)
+		}
+	}
+}
+
+func TestGame_SameSeedSameSpawns(t *testing.T) {
+	spawns := func(seed int64) []d2.Vec2 {
+		g := newTestGame(t, openRoom...)
+		g.rng = NewRNG(seed)
+		g.ai = NewAIDirector(g, 0, 0)
+		g.ai.keypoints.Spawn.Enemies = VecList{{1.5, 1.5}, {7.5, 1.5}, {1.5, 3.5}, {7.5, 3.5}}
+
+		var pos []d2.Vec2
+		for i := 0; i < 20; i++ {
+			g.ai.SummonZombie()
+		}
+		for id := uint32(1); id <= g.state.ids.Count(); id++ {
+			pos = append(pos, g.state.Entity(id).Position())
+		}
+		return pos
+	}
+
+	a, b := spawns(7), spawns(7)
+	if len(a) != 20 || len(b) != 20 {
+		t.Fatalf("%d and %d zombies summoned, want 20", len(a), len(b))
+	}
+	var differ bool
+	for i := range a {
+		if !a[i].Approx(b[i]) {
+			t.Fatalf("zombie %d spawned at %v and %v with the same seed", i, a[i], b[i])
+		}
+		differ = differ || !a[i].Approx(a[0])
+	}
+	if !differ {
+		t.Errorf("all zombies spawned at %v", a[0])
+	}
+}
+
+func TestGame_SameSeedSameWaves(t *testing.T) {
+	// plays the first wave from just before nightfall, the AI director
+	// spawning the zombies on its own, and returns their final states
+	play := func(seed int64) []EntityState {
+		g := newTestGame(t, openRoom...)
+		g.rng = NewRNG(seed)
+		g.ai = NewAIDirector(g, int16(g.cfg.NightStartingTime), int16(g.cfg.NightEndingTime))
+		g.ai.keypoints.Spawn.Enemies = VecList{{1.5, 1.5}, {7.5, 3.5}}
+		g.state.gameTime = int16(g.cfg.NightStartingTime) - 5
+
+		for i := 0; i < 800; i++ {
+			g.logicTick(100 * time.Millisecond)
+		}
+		if g.ai.Wave() != 1 {
+			t.Fatalf("wave %d after nightfall, want 1", g.ai.Wave())
+		}
+		var states []EntityState
+		for _, id := range g.state.entityIDs(nil) {
+			states = append(states, g.state.Entity(id).State())
+		}
+		return states
+	}
+
+	a, b := play(7), play(7)
+	if len(a) < 2 {
+		t.Fatalf("%d zombies spawned during the wave, want at least 2", len(a))
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("waves played with the same seed differ:\n%+v\n%+v", a, b)
+	}
+	if reflect.DeepEqual(a, play(8)) {
+		t.Errorf("waves played with different seeds are the same")
+	}
+}
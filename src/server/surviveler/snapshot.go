@@ -0,0 +1,124 @@
+/*
+ * Surviveler package
+ * read-only game state snapshots
+ */
+package surviveler
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+/*
+ * EntitySummary is the summarized state of an entity, at the time of a
+ * snapshot
+ */
+type EntitySummary struct {
+	Id        uint32
+	Type      EntityType
+	Pos       d2.Vec2
+	HitPoints float32 // 0 for the entities without hit points
+}
+
+/*
+ * StateSnapshot is a read-only copy of the game state, published at the end
+ * of each logic tick.
+ *
+ * A published snapshot is never modified, so it can be read from any
+ * goroutine without locking nor going through the game loop, like the
+ * telnet or the http handlers do.
+ */
+type StateSnapshot struct {
+	Tick     uint64          // number of logic ticks performed
+	GameTime int16           // minutes since midnight, in game time
+	Entities []EntitySummary // by increasing id
+}
+
+/*
+ * Entity returns the summary of the entity with the given id, if it was in
+ * game at the time of the snapshot
+ */
+func (s *StateSnapshot) Entity(id uint32) (EntitySummary, bool) {
+	i := sort.Search(len(s.Entities), func(i int) bool {
+		return s.Entities[i].Id >= id
+	})
+	if i < len(s.Entities) && s.Entities[i].Id == id {
+		return s.Entities[i], true
+	}
+	return EntitySummary{}, false
+}
+
+/*
+ * Count returns the number of entities of type t
+ */
+func (s *StateSnapshot) Count(t EntityType) int {
+	var n int
+	for _, ent := range s.Entities {
+		if ent.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+ * String returns a human readable summary of the snapshot
+ */
+func (s *StateSnapshot) String() string {
+	return fmt.Sprintf("tick: %d, game time: %02d:%02d, entities: %d\n",
+		s.Tick, s.GameTime/60, s.GameTime%60, len(s.Entities))
+}
+
+/*
+ * snapshotHolder holds the last published snapshot
+ */
+type snapshotHolder struct {
+	v atomic.Value // *StateSnapshot
+}
+
+/*
+ * load returns the last published snapshot, or an empty one if none has
+ * been published yet
+ */
+func (h *snapshotHolder) load() *StateSnapshot {
+	if s, ok := h.v.Load().(*StateSnapshot); ok {
+		return s
+	}
+	return &StateSnapshot{}
+}
+
+/*
+ * Snapshot returns the game state, as of the end of the last logic tick.
+ *
+ * It's safe to call from any goroutine, the returned snapshot must not be
+ * modified.
+ */
+func (g *Game) Snapshot() *StateSnapshot {
+	return g.snapshot.load()
+}
+
+/*
+ * publishSnapshot publishes a copy of the current game state, it's called
+ * from the game loop
+ */
+func (g *Game) publishSnapshot() {
+	s := &StateSnapshot{
+		Tick:     g.tick,
+		GameTime: g.state.gameTime,
+		Entities: make([]EntitySummary, 0, len(g.state.entities)),
+	}
+	g.state.forEachEntity(func(ent Entity) bool {
+		// positions are slices, owned by the entities
+		pos := ent.Position()
+		sum := EntitySummary{Id: ent.Id(), Type: ent.Type(), Pos: d2.Vec2{pos[0], pos[1]}}
+		if d, ok := ent.(damageable); ok {
+			sum.HitPoints = d.hitPoints()
+		}
+		s.Entities = append(s.Entities, sum)
+		return true
+	})
+	g.snapshot.v.Store(s)
+}
@@ -0,0 +1,97 @@
+package surviveler
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestGame_Snapshot(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	if s := g.Snapshot(); s.Tick != 0 || len(s.Entities) != 0 {
+		t.Fatalf("snapshot before the first tick = %+v, want an empty one", s)
+	}
+
+	p := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 1.5})
+	z := addTestZombie(g, d2.Vec2{6.5, 3.5})
+	z.DealDamage(10, MeleeDamage)
+	g.logicTick(10 * time.Millisecond)
+
+	s := g.Snapshot()
+	if s.Tick != 1 || s.GameTime != g.state.gameTime || len(s.Entities) != 2 {
+		t.Fatalf("snapshot = %+v, want tick 1 and 2 entities", s)
+	}
+	if sum, ok := s.Entity(z.Id()); !ok || sum.Type != ZombieEntity || sum.HitPoints != 40 || !sum.Pos.Approx(z.Pos) {
+		t.Errorf("zombie summary = %+v, %v", sum, ok)
+	}
+	if s.Count(TankEntity) != 1 || s.Count(ZombieEntity) != 1 {
+		t.Errorf("snapshot has %d tanks and %d zombies, want 1 of each", s.Count(TankEntity), s.Count(ZombieEntity))
+	}
+
+	// published snapshots aren't modified by the next ticks
+	g.state.RemoveEntity(p.Id())
+	z.Pos[0] = 5.5
+	g.logicTick(10 * time.Millisecond)
+	if sum, _ := s.Entity(z.Id()); sum.Pos[0] != 6.5 {
+		t.Errorf("published snapshot zombie moved to %v", sum.Pos)
+	}
+	if _, ok := s.Entity(p.Id()); !ok || len(s.Entities) != 2 {
+		t.Errorf("published snapshot has been modified: %+v", s)
+	}
+	if _, ok := g.Snapshot().Entity(p.Id()); ok {
+		t.Errorf("removed player %v found in the new snapshot", p.Id())
+	}
+}
+
+func TestGame_SnapshotConcurrentReads(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.cfg.LogicTickPeriod = 1
+	g.cfg.SendTickPeriod = int(time.Hour / time.Millisecond) // no network in tests
+	g.quitChan = make(chan struct{})
+	for i := 0; i < 10; i++ {
+		addTestZombie(g, d2.Vec2{float32(i%7) + 1.5, float32(i%3) + 1.5})
+	}
+	addTestPlayer(g, TankEntity, d2.Vec2{4.5, 2.5})
+	g.loop()
+
+	// read while the loop ticks, run with -race to check for data races
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last uint64
+			for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); {
+				s := g.Snapshot()
+				if s.Tick < last {
+					t.Errorf("snapshot of tick %d read after tick %d", s.Tick, last)
+					return
+				}
+				last = s.Tick
+				for j := 1; j < len(s.Entities); j++ {
+					if s.Entities[j-1].Id >= s.Entities[j].Id {
+						t.Errorf("snapshot entities not sorted by id: %+v", s.Entities)
+						return
+					}
+				}
+				var out bytes.Buffer
+				g.writeStats(&out)
+				if !strings.Contains(out.String(), "entities: ") {
+					t.Errorf("stats = %q, want the entity count", out.String())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(g.quitChan)
+	g.wg.Wait()
+
+	if s := g.Snapshot(); s.Tick == 0 || s.Tick != g.tick {
+		t.Errorf("last snapshot of tick %d, want %d", s.Tick, g.tick)
+	}
+}
@@ -30,6 +30,13 @@ const (
 	KindTurret TileKind = 0x10 | KindNotWalkable
 )
 
+// terrain costs, multiplying the cost of moving onto a tile
+const (
+	DefaultTileCost = 1    // normal ground
+	MinTileCost     = 0.25 // cheapest terrain, so that A* estimates remain optimistic
+	tileCostUnit    = 64   // cost layer value of a tile having the default cost
+)
+
 /*
  * A Tile is a tile in a grid which implements Pather.
  *
@@ -38,24 +45,27 @@ const (
  */
 type Tile struct {
 	Kind     TileKind     // kind of tile, each kind has its own cost
+	Cost     float32      // terrain cost, roads are cheap and mud is expensive
 	X, Y     int          // tile position in 'grid' coordinates
 	W        *World       // reference to the map this tile is part of
 	Entities EntitySet    // Entities intersecting with this Tile
+	Effects  *TileEffects // special effects of the map zones covering the tile, or nil
 	aabb     d2.Rectangle // pre-computed bounding box, as it won't ever change
 }
 
 func NewTile(kind TileKind, w *World, x, y int) Tile {
 	return Tile{
 		Kind:     kind,
+		Cost:     DefaultTileCost,
 		W:        w,
 		X:        x,
 		Y:        y,
 		Entities: *NewEntitySet(),
 		aabb: d2.Rect(
-			(float32(x)-0.5)/w.GridScale,
-			(float32(y)-0.5)/w.GridScale,
-			(float32(x)+0.5)/w.GridScale,
-			(float32(y)+0.5)/w.GridScale,
+			float32(x)/w.GridScale,
+			float32(y)/w.GridScale,
+			float32(x+1)/w.GridScale,
+			float32(y+1)/w.GridScale,
 		),
 	}
 }
@@ -68,8 +78,22 @@ func (t Tile) Rectangle() d2.Rectangle {
 	return t.aabb
 }
 
-func (t Tile) IsWalkable() bool {
-	return t.Kind == KindWalkable
+/*
+ * IsWalkable indicates if the tile can be walked on.
+ *
+ * A tile is walkable if its kind is walkable and it is not currently occupied
+ * by a building.
+ */
+func (t *Tile) IsWalkable() bool {
+	return t.Kind == KindWalkable && !t.HasBuilding()
+}
+
+/*
+ * HasBuilding indicates if a building is attached to this tile, as recorded
+ * by the occupancy layer of the world
+ */
+func (t *Tile) HasBuilding() bool {
+	return t.W.occupancy.Blocked(t.X, t.Y)
 }
 
 /*
@@ -81,58 +105,58 @@ func (t *Tile) PathNeighbors() []astar.Pather {
 
 	// up
 	upw, leftw, rightw, downw := false, false, false, false
-	if up := w.Tile(t.X, t.Y-1); up != nil {
-		if up.Kind == KindWalkable {
+	if up, ok := w.TileAt(t.X, t.Y-1); ok {
+		if up.IsWalkable() {
 			upw = true
 			neighbors = append(neighbors, up)
 		}
 	}
 	// left
-	if left := w.Tile(t.X-1, t.Y); left != nil {
-		if left.Kind == KindWalkable {
+	if left, ok := w.TileAt(t.X-1, t.Y); ok {
+		if left.IsWalkable() {
 			leftw = true
 			neighbors = append(neighbors, left)
 		}
 	}
 	// down
-	if down := w.Tile(t.X, t.Y+1); down != nil {
-		if down.Kind == KindWalkable {
+	if down, ok := w.TileAt(t.X, t.Y+1); ok {
+		if down.IsWalkable() {
 			downw = true
 			neighbors = append(neighbors, down)
 		}
 	}
 	// right
-	if right := w.Tile(t.X+1, t.Y); right != nil {
-		if right.Kind == KindWalkable {
+	if right, ok := w.TileAt(t.X+1, t.Y); ok {
+		if right.IsWalkable() {
 			rightw = true
 			neighbors = append(neighbors, right)
 		}
 	}
 
 	// up left
-	if upleft := w.Tile(t.X-1, t.Y-1); upleft != nil {
-		if upleft.Kind == KindWalkable && upw && leftw {
+	if upleft, ok := w.TileAt(t.X-1, t.Y-1); ok {
+		if upleft.IsWalkable() && upw && leftw {
 			neighbors = append(neighbors, upleft)
 		}
 	}
 
 	// down left
-	if downleft := w.Tile(t.X-1, t.Y+1); downleft != nil {
-		if downleft.Kind == KindWalkable && downw && leftw {
+	if downleft, ok := w.TileAt(t.X-1, t.Y+1); ok {
+		if downleft.IsWalkable() && downw && leftw {
 			neighbors = append(neighbors, downleft)
 		}
 	}
 
 	// up right
-	if upright := w.Tile(t.X+1, t.Y-1); upright != nil {
-		if upright.Kind == KindWalkable && upw && rightw {
+	if upright, ok := w.TileAt(t.X+1, t.Y-1); ok {
+		if upright.IsWalkable() && upw && rightw {
 			neighbors = append(neighbors, upright)
 		}
 	}
 
 	// down right
-	if downright := w.Tile(t.X+1, t.Y+1); downright != nil {
-		if downright.Kind == KindWalkable && downw && rightw {
+	if downright, ok := w.TileAt(t.X+1, t.Y+1); ok {
+		if downright.IsWalkable() && downw && rightw {
 			neighbors = append(neighbors, downright)
 		}
 	}
@@ -158,7 +182,10 @@ func costFromKind(kind TileKind) float64 {
  */
 func (t *Tile) PathNeighborCost(to astar.Pather) float64 {
 	tt := to.(*Tile)
-	cf := costFromKind(tt.Kind)
+	cf := costFromKind(tt.Kind) * float64(tt.Cost)
+	if tt.W.avoidCrowds && tt.W.occupancy.Crowded(tt.X, tt.Y) {
+		cf *= CrowdedTileCost
+	}
 
 	if t.X == tt.X || t.Y == tt.Y {
 		// same axis, return the movement cost
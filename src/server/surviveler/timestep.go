@@ -0,0 +1,49 @@
+/*
+ * Surviveler package
+ * fixed timestep accumulator
+ */
+package surviveler
+
+import "time"
+
+/*
+ * Maximum number of logic steps that can be run in a single frame, the time
+ * exceeding this limit is dropped, rather than making the game fall further
+ * behind.
+ */
+const maxStepsPerFrame = 10
+
+/*
+ * Timestep turns irregular frame durations into a number of fixed-size logic
+ * steps.
+ *
+ * It accumulates the real elapsed time and carries the remainder that is not
+ * large enough to make a full step over to the next frame.
+ */
+type Timestep struct {
+	Step time.Duration // duration of a logic step
+	acc  time.Duration // accumulated time not consumed yet
+}
+
+/*
+ * NewTimestep creates a timestep accumulator with given step duration
+ */
+func NewTimestep(step time.Duration) *Timestep {
+	return &Timestep{Step: step}
+}
+
+/*
+ * Advance accumulates elapsed time and returns the number of logic steps to
+ * run
+ */
+func (ts *Timestep) Advance(elapsed time.Duration) int {
+	ts.acc += elapsed
+	steps := int(ts.acc / ts.Step)
+	if steps > maxStepsPerFrame {
+		steps = maxStepsPerFrame
+		ts.acc = 0
+	} else {
+		ts.acc -= time.Duration(steps) * ts.Step
+	}
+	return steps
+}
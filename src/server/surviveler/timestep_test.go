@@ -0,0 +1,55 @@
+package surviveler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestep_Advance(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []time.Duration
+	}{
+		{"regular", []time.Duration{10, 10, 10, 10, 10, 10, 10, 10, 10, 10}},
+		{"jitter", []time.Duration{3, 17, 9, 11, 1, 19, 25, 5, 8, 2}},
+		{"bursts", []time.Duration{0, 0, 45, 0, 4, 51}},
+		{"tiny", []time.Duration{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+			1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+			1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+			1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		ts := NewTimestep(10 * time.Millisecond)
+		var steps int
+		for _, f := range tt.frames {
+			steps += ts.Advance(f * time.Millisecond)
+		}
+		// each test case sums up to 100ms
+		if steps != 10 {
+			t.Errorf("%s: got %d steps, want 10", tt.name, steps)
+		}
+		if ts.acc != 0 {
+			t.Errorf("%s: remaining accumulated time = %v, want 0", tt.name, ts.acc)
+		}
+	}
+}
+
+func TestTimestep_AdvanceCarriesRemainder(t *testing.T) {
+	ts := NewTimestep(10 * time.Millisecond)
+	if n := ts.Advance(15 * time.Millisecond); n != 1 {
+		t.Errorf("Advance(15ms) = %d, want 1", n)
+	}
+	if n := ts.Advance(5 * time.Millisecond); n != 1 {
+		t.Errorf("Advance(5ms) after a 5ms remainder = %d, want 1", n)
+	}
+}
+
+func TestTimestep_AdvanceClampsSteps(t *testing.T) {
+	ts := NewTimestep(10 * time.Millisecond)
+	if n := ts.Advance(time.Second); n != maxStepsPerFrame {
+		t.Errorf("Advance(1s) = %d, want %d", n, maxStepsPerFrame)
+	}
+	if n := ts.Advance(5 * time.Millisecond); n != 0 {
+		t.Errorf("Advance(5ms) after clamping = %d, want 0", n)
+	}
+}
@@ -5,14 +5,17 @@
 package surviveler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"server/events"
 	"server/math"
 	"server/messages"
+	"strconv"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/aurelien-rainone/gogeo/f32/d2"
 	"github.com/urfave/cli"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -35,6 +38,12 @@ const (
 	TnRepairId
 	TnDestroyId
 	TnSummonZombieId
+	TnReloadAssetsId
+	TnPauseId
+	TnResumeId
+	TnSpawnZombiesId
+	TnInspectId
+	TnPathId
 )
 
 /*
@@ -74,6 +83,28 @@ type TnDestroy struct {
 type TnSummonZombie struct {
 }
 
+type TnReloadAssets struct {
+}
+
+type TnPause struct {
+}
+
+type TnResume struct {
+}
+
+type TnSpawnZombies struct {
+	Count int // number of zombies to spawn
+}
+
+type TnInspect struct {
+	Id uint32 // entity id
+}
+
+type TnPath struct {
+	Org math.Vec2 // path origin
+	Dst math.Vec2 // path destination
+}
+
 func (req *TnGameState) FromContext(c *cli.Context) error {
 	req.Short = c.Bool("short")
 	return nil
@@ -165,6 +196,46 @@ func (req *TnSummonZombie) FromContext(c *cli.Context) error {
 	return nil
 }
 
+func (req *TnReloadAssets) FromContext(c *cli.Context) error {
+	return nil
+}
+
+func (req *TnPause) FromContext(c *cli.Context) error {
+	return nil
+}
+
+func (req *TnResume) FromContext(c *cli.Context) error {
+	return nil
+}
+
+func (req *TnSpawnZombies) FromContext(c *cli.Context) error {
+	count, err := strconv.Atoi(c.Args().First())
+	if err != nil || count <= 0 || count > MaxSpawnCount {
+		return fmt.Errorf("invalid count '%s', must be between 1 and %d", c.Args().First(), MaxSpawnCount)
+	}
+	req.Count = count
+	return nil
+}
+
+func (req *TnInspect) FromContext(c *cli.Context) error {
+	id, err := strconv.ParseUint(c.Args().First(), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid id '%s'", c.Args().First())
+	}
+	req.Id = uint32(id)
+	return nil
+}
+
+func (req *TnPath) FromContext(c *cli.Context) error {
+	if err := req.Org.Set(c.String("from")); err != nil {
+		return fmt.Errorf("invalid origin vector: %s", c.String("from"))
+	}
+	if err := req.Dst.Set(c.String("to")); err != nil {
+		return fmt.Errorf("invalid destination vector: %s", c.String("to"))
+	}
+	return nil
+}
+
 /*
  * registerTelnetHandlers declares and registers the game-related telnet
  * handlers.
@@ -301,6 +372,140 @@ func (g *Game) registerTelnetHandlers() {
 		}
 		g.telnet.RegisterCommand(&cmd)
 	}()
+
+	func() {
+		// register 'spawn' command
+		cmd := cli.Command{
+			Name:  "spawn",
+			Usage: "spawn entities in bulk, for load testing",
+			Subcommands: []cli.Command{
+				{
+					Name:      "zombies",
+					Usage:     "spawn zombies, distributed across the zombie spawn points",
+					ArgsUsage: "<count>",
+					Action: createHandler(
+						TelnetRequest{Type: TnSpawnZombiesId, Content: &TnSpawnZombies{}}),
+				},
+			},
+		}
+		g.telnet.RegisterCommand(&cmd)
+	}()
+
+	func() {
+		// register 'inspect' command
+		cmd := cli.Command{
+			Name:      "inspect",
+			Usage:     "dump the full debug state of an entity: AI state, target, path and components",
+			ArgsUsage: "<id>",
+			Action: createHandler(
+				TelnetRequest{Type: TnInspectId, Content: &TnInspect{}}),
+		}
+		g.telnet.RegisterCommand(&cmd)
+	}()
+
+	func() {
+		// register 'path' command
+		cmd := cli.Command{
+			Name:  "path",
+			Usage: "search a path, and show the tiles explored by A* and the final path as JSON",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "from", Usage: "2D vector, ex: 3,4.5"},
+				cli.StringFlag{Name: "to", Usage: "2D vector, ex: 3,4.5"},
+			},
+			Action: createHandler(
+				TelnetRequest{Type: TnPathId, Content: &TnPath{}}),
+		}
+		g.telnet.RegisterCommand(&cmd)
+	}()
+
+	func() {
+		// register 'stats' command, it only reads the metrics and the state
+		// snapshot so it doesn't go through the game loop
+		cmd := cli.Command{
+			Name:  "stats",
+			Usage: "shows server runtime metrics",
+			Flags: []cli.Flag{},
+			Action: func(c *cli.Context) error {
+				g.writeStats(c.App.Writer)
+				return nil
+			},
+		}
+		g.telnet.RegisterCommand(&cmd)
+	}()
+
+	func() {
+		// register 'reload' command
+		cmd := cli.Command{
+			Name:  "reload",
+			Usage: "reload game resources without restarting",
+			Subcommands: []cli.Command{
+				{
+					Name:  "assets",
+					Usage: "reload the assets package from disk (the world map is kept)",
+					Action: createHandler(
+						TelnetRequest{Type: TnReloadAssetsId, Content: &TnReloadAssets{}}),
+				},
+			},
+		}
+		g.telnet.RegisterCommand(&cmd)
+	}()
+
+	func() {
+		// register 'pause' command
+		cmd := cli.Command{
+			Name:  "pause",
+			Usage: "freeze the game, the clients stay connected and keep receiving the game state",
+			Flags: []cli.Flag{},
+			Action: createHandler(
+				TelnetRequest{Type: TnPauseId, Content: &TnPause{}}),
+		}
+		g.telnet.RegisterCommand(&cmd)
+	}()
+
+	func() {
+		// register 'resume' command
+		cmd := cli.Command{
+			Name:  "resume",
+			Usage: "resume a paused game",
+			Flags: []cli.Flag{},
+			Action: createHandler(
+				TelnetRequest{Type: TnResumeId, Content: &TnResume{}}),
+		}
+		g.telnet.RegisterCommand(&cmd)
+	}()
+}
+
+/*
+ * writeStats writes the server runtime metrics and a summary of the game
+ * state. It's safe to call from any goroutine.
+ */
+func (g *Game) writeStats(w io.Writer) {
+	io.WriteString(w, g.metrics.Snapshot().String())
+	io.WriteString(w, g.Snapshot().String())
+}
+
+/*
+ * writePathDebug writes what a path search did as JSON, the explored tiles
+ * and the path, from the origin to the destination, being lists of [x, y]
+ * coordinates
+ */
+func writePathDebug(w io.Writer, dbg *PathDebug) error {
+	out := struct {
+		Result   string    `json:"result"`
+		Explored []d2.Vec2 `json:"explored"`
+		Path     []d2.Vec2 `json:"path"`
+	}{
+		Result:   dbg.Result.String(),
+		Explored: dbg.Explored,
+		Path:     make([]d2.Vec2, 0, len(dbg.Path)),
+	}
+	if out.Explored == nil {
+		out.Explored = []d2.Vec2{}
+	}
+	for i := len(dbg.Path) - 1; i >= 0; i-- {
+		out.Path = append(out.Path, dbg.Path[i])
+	}
+	return json.NewEncoder(w).Encode(&out)
 }
 
 /*
@@ -415,6 +620,46 @@ func (g *Game) telnetHandler(msg TelnetRequest) error {
 
 		g.ai.SummonZombie()
 
+	case TnSpawnZombiesId:
+
+		spawn := msg.Content.(*TnSpawnZombies)
+		n, queued := g.ai.SpawnZombies(spawn.Count)
+		io.WriteString(msg.Context.App.Writer, fmt.Sprintf("%d zombies spawned, %d queued\n", n, queued))
+
+	case TnInspectId:
+
+		inspect := msg.Content.(*TnInspect)
+		return g.state.inspectEntity(msg.Context.App.Writer, inspect.Id)
+
+	case TnPathId:
+
+		path := msg.Content.(*TnPath)
+		dbg := g.pathfinder.DebugPath(
+			d2.Vec2{float32(path.Org[0]), float32(path.Org[1])},
+			d2.Vec2{float32(path.Dst[0]), float32(path.Dst[1])})
+		return writePathDebug(msg.Context.App.Writer, dbg)
+
+	case TnReloadAssetsId:
+
+		if err := g.reloadAssets(); err != nil {
+			return err
+		}
+		io.WriteString(msg.Context.App.Writer, "assets reloaded\n")
+
+	case TnPauseId:
+
+		if err := g.pause(); err != nil {
+			return err
+		}
+		io.WriteString(msg.Context.App.Writer, "game paused\n")
+
+	case TnResumeId:
+
+		if err := g.resume(); err != nil {
+			return err
+		}
+		io.WriteString(msg.Context.App.Writer, "game resumed\n")
+
 	default:
 
 		return errors.New("unknow telnet message id")
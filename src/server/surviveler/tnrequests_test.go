@@ -0,0 +1,125 @@
+package surviveler
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"server/math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/urfave/cli"
+)
+
+/*
+ * telnetContext returns a cli context parsing args, writing to out
+ */
+func telnetContext(out *bytes.Buffer, args ...string) *cli.Context {
+	app := cli.NewApp()
+	app.Writer = out
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.Parse(args)
+	return cli.NewContext(app, set, nil)
+}
+
+func TestGame_TelnetInspect(t *testing.T) {
+	g := newTestGame(t, newTestRoom(10)...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 5.5})
+	z := addTestZombie(g, d2.Vec2{2.5, 2.5})
+	for i := 0; i < 100 && z.curState != walkingState; i++ {
+		tick(g, 10*time.Millisecond)
+	}
+
+	var out bytes.Buffer
+	req := TelnetRequest{Type: TnInspectId, Context: telnetContext(&out, fmt.Sprint(z.Id())), Content: &TnInspect{}}
+	if err := req.Content.FromContext(req.Context); err != nil {
+		t.Fatalf("FromContext() error = %v", err)
+	}
+	if err := g.telnetHandler(req); err != nil {
+		t.Fatalf("telnetHandler() error = %v", err)
+	}
+	dump := out.String()
+	for _, want := range []string{
+		fmt.Sprintf("entity %d: *surviveler.Zombie", z.Id()),
+		"state: walking",
+		fmt.Sprintf("target: %d", p.Id()),
+		"path: [",
+		"*surviveler.Health: &{Total:50 Cur:50}",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("inspect output doesn't contain %q:\n%s", want, dump)
+		}
+	}
+
+	// unknown entities and invalid ids are reported
+	req.Content = &TnInspect{Id: 1000}
+	if err := g.telnetHandler(req); err == nil {
+		t.Errorf("telnetHandler() should fail on an unknown entity")
+	}
+	if err := new(TnInspect).FromContext(telnetContext(&out, "zombie")); err == nil {
+		t.Errorf("FromContext() should fail on an invalid id")
+	}
+}
+
+func TestGame_TelnetPath(t *testing.T) {
+	g := newTestGame(t,
+		"#########",
+		"#...#...#",
+		"#...#...#",
+		"#.......#",
+		"#########")
+	org, dst := d2.Vec2{1.5, 1.5}, d2.Vec2{7.5, 1.5}
+
+	var out bytes.Buffer
+	req := TelnetRequest{
+		Type:    TnPathId,
+		Context: telnetContext(&out),
+		Content: &TnPath{Org: math.FromFloat32(org[0], org[1]), Dst: math.FromFloat32(dst[0], dst[1])},
+	}
+	if err := g.telnetHandler(req); err != nil {
+		t.Fatalf("telnetHandler() error = %v", err)
+	}
+	var got struct {
+		Result   string
+		Explored []d2.Vec2
+		Path     []d2.Vec2
+	}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("path output isn't JSON: %v\n%s", err, out.String())
+	}
+	if got.Result != "found" {
+		t.Errorf("path result = %q, want found", got.Result)
+	}
+
+	// the path is the one FindPath returns, from the origin
+	path, _, _ := g.Pathfinder().FindPath(org, dst)
+	if len(got.Path) != len(path) {
+		t.Fatalf("path = %v, want %v reversed", got.Path, path)
+	}
+	for i := range path {
+		if !got.Path[i].Approx(path[len(path)-1-i]) {
+			t.Fatalf("path = %v, want %v reversed", got.Path, path)
+		}
+	}
+
+	// A* explored walkable tiles only, starting from the origin one, going
+	// round the wall
+	world := g.state.World()
+	if len(got.Explored) == 0 || !got.Explored[0].Approx(org) {
+		t.Fatalf("explored tiles = %v, want them to start at %v", got.Explored, org)
+	}
+	var underWall bool
+	for _, pt := range got.Explored {
+		tile, ok := world.TileAtWorldVec(pt)
+		if !ok || !tile.IsWalkable() || !tile.Rectangle().Center().Approx(pt) {
+			t.Errorf("explored %v, which isn't the center of a walkable tile", pt)
+		}
+		underWall = underWall || tile.X == 4
+	}
+	if !underWall {
+		t.Errorf("explored tiles %v don't go under the wall", got.Explored)
+	}
+}
@@ -7,6 +7,9 @@ package surviveler
 import (
 	"fmt"
 	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
 )
 
 /*
@@ -33,3 +36,40 @@ func (i *uint32Value) Set(s string) error {
 	}
 	return nil
 }
+
+/*
+ * absInt returns the absolute value of an integer
+ */
+func absInt(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+/*
+ * segmentEntry returns the parameter t, in [0, 1], at which the segment going
+ * from org to org+delta enters the rectangle r, and true, or false if the
+ * segment doesn't intersect r. t is 0 if org lies in r.
+ */
+func segmentEntry(org, delta d2.Vec2, r d2.Rectangle) (float32, bool) {
+	tmin, tmax := float32(0), float32(1)
+	for i := 0; i < 2; i++ {
+		if math32.Abs(delta[i]) < 1e-9 {
+			// parallel to the slab
+			if org[i] < r.Min[i] || org[i] > r.Max[i] {
+				return 0, false
+			}
+			continue
+		}
+		t1, t2 := (r.Min[i]-org[i])/delta[i], (r.Max[i]-org[i])/delta[i]
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		tmin, tmax = math32.Max(tmin, t1), math32.Min(tmax, t2)
+		if tmin > tmax {
+			return 0, false
+		}
+	}
+	return tmin, true
+}
@@ -11,6 +11,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
 )
 
 /*
@@ -22,6 +23,10 @@ type World struct {
 	Width, Height         float32             // world dimensions
 	GridScale             float32             // the grid scale
 	Entities              map[uint32]TileList // map entities to the tiles to which it is attached
+	index                 *quadtree           // spatial index of the entities
+	occupancy             *Occupancy          // dynamic layer, tiles occupied by the entities
+	regions               *Regions            // connected regions, rebuilt lazily when the occupancy changes
+	avoidCrowds           bool                // crowded tiles are costly, on navigation snapshots only
 }
 
 /*
@@ -32,19 +37,14 @@ type World struct {
  */
 func NewWorld(img image.Image, gridScale float32) (*World, error) {
 	bounds := img.Bounds()
-	w := World{
-		GridWidth:  bounds.Max.X,
-		GridHeight: bounds.Max.Y,
-		Width:      float32(bounds.Max.X) / gridScale,
-		Height:     float32(bounds.Max.Y) / gridScale,
-		GridScale:  gridScale,
-		Entities:   make(map[uint32]TileList),
-	}
-	log.WithField("world", w).Info("Building world")
+	w := newWorld(bounds.Max.X, bounds.Max.Y, gridScale)
+	log.WithFields(log.Fields{
+		"grid":  fmt.Sprintf("%dx%d", w.GridWidth, w.GridHeight),
+		"scale": gridScale,
+	}).Info("Building world")
 
 	// allocate tiles
 	var kind TileKind
-	w.Grid = make([]Tile, bounds.Max.X*bounds.Max.Y)
 	for x := bounds.Min.X; x < bounds.Max.X; x++ {
 		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 			r, _, _, _ := img.At(x, y).RGBA()
@@ -53,46 +53,167 @@ func NewWorld(img image.Image, gridScale float32) (*World, error) {
 			} else {
 				kind = KindWalkable
 			}
-			w.Grid[x+y*w.GridWidth] = NewTile(kind, &w, x, y)
+			w.Grid[x+y*w.GridWidth] = NewTile(kind, w, x, y)
+		}
+	}
+	w.regions = newRegions(w)
+	return w, nil
+}
+
+/*
+ * newWorld returns a world of the given grid dimensions, with no entities.
+ * Its tiles are allocated, but left for the caller to initialize.
+ */
+func newWorld(gridWidth, gridHeight int, gridScale float32) *World {
+	w := &World{
+		GridWidth:  gridWidth,
+		GridHeight: gridHeight,
+		Width:      float32(gridWidth) / gridScale,
+		Height:     float32(gridHeight) / gridScale,
+		GridScale:  gridScale,
+		Entities:   make(map[uint32]TileList),
+		occupancy:  NewOccupancy(gridWidth, gridHeight),
+		Grid:       make([]Tile, gridWidth*gridHeight),
+	}
+	w.index = newQuadtree(d2.Rect(0, 0, w.Width, w.Height))
+	return w
+}
+
+/*
+ * emptyCopy returns a new world having the same tiles as w, with the same
+ * kinds, costs and effects, but no entities
+ */
+func (w *World) emptyCopy() *World {
+	c := newWorld(w.GridWidth, w.GridHeight, w.GridScale)
+	for i := range w.Grid {
+		t := &w.Grid[i]
+		c.Grid[i] = NewTile(t.Kind, c, t.X, t.Y)
+		c.Grid[i].Cost = t.Cost
+		c.Grid[i].Effects = t.Effects
+	}
+	c.regions = newRegions(c)
+	return c
+}
+
+/*
+ * LoadCosts sets the terrain cost of the tiles from a cost layer, an image
+ * of the size of the grid.
+ *
+ * The cost of a tile is the value of the red channel of the corresponding
+ * pixel, divided by 64: 64 is normal ground, less is a road and more is mud
+ * or rubble. Costs are clamped to MinTileCost.
+ */
+func (w *World) LoadCosts(img image.Image) error {
+	bounds := img.Bounds()
+	if bounds.Dx() != w.GridWidth || bounds.Dy() != w.GridHeight {
+		return fmt.Errorf("cost layer is %dx%d, want the grid size %dx%d",
+			bounds.Dx(), bounds.Dy(), w.GridWidth, w.GridHeight)
+	}
+	for x := 0; x < w.GridWidth; x++ {
+		for y := 0; y < w.GridHeight; y++ {
+			r, _, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			cost := float32(r>>8) / tileCostUnit
+			if cost < MinTileCost {
+				cost = MinTileCost
+			}
+			w.Tile(x, y).Cost = cost
 		}
 	}
-	return &w, nil
+	return nil
 }
 
 /*
- * Tile gets the tile at the given coordinates in the grid.
+ * SetBorder makes the tiles lying within width world units of the grid edges
+ * impassable, whatever the map says, so that nothing walks nor is built along
+ * the edges
+ */
+func (w *World) SetBorder(width float32) {
+	n := int(math32.Ceil(width * w.GridScale))
+	for i := range w.Grid {
+		t := &w.Grid[i]
+		if t.X < n || t.Y < n || t.X >= w.GridWidth-n || t.Y >= w.GridHeight-n {
+			t.Kind = KindNotWalkable
+		}
+	}
+	w.regions = newRegions(w)
+}
+
+/*
+ * Contains indicates if the rectangle r, in world coordinates, lies entirely
+ * within the world bounds
+ */
+func (w *World) Contains(r d2.Rectangle) bool {
+	return r.In(d2.Rect(0, 0, w.Width, w.Height))
+}
+
+/*
+ * InBounds indicates if (x, y) are the coordinates of a tile of the grid.
+ *
+ * (x, y) represent *grid* coordinates, that are valid from (0, 0) included to
+ * (GridWidth, GridHeight) excluded.
+ */
+func (w World) InBounds(x, y int) bool {
+	return x >= 0 && x < w.GridWidth && y >= 0 && y < w.GridHeight
+}
+
+/*
+ * TileAt gets the tile at the given coordinates in the grid, ok reports
+ * whether the coordinates lie in the grid, if not the returned tile is nil.
  *
  * (x, y) represent *grid* coordinates, i.e the map scale factor must be taken
  * in consideration to convert from *world* coordinates into *grid* coordinates.
  */
-func (w World) Tile(x, y int) *Tile {
-	switch {
-	case x < 0, x >= w.GridWidth, y < 0, y >= w.GridHeight:
-		return nil
-	default:
-		return &w.Grid[x+y*w.GridWidth]
+func (w World) TileAt(x, y int) (t *Tile, ok bool) {
+	if !w.InBounds(x, y) {
+		return nil, false
 	}
+	return &w.Grid[x+y*w.GridWidth], true
 }
 
 /*
- * TileFromVec gets the tile at given point in the grid
+ * TileAtWorldVec gets the tile at given point, ok reports whether the point
+ * lies in the grid.
+ *
+ * pt represents *world* coordinates, i.e TileAtWorldVec performs the
+ * conversion from world coordinates into grid coordinates.
+ */
+func (w World) TileAtWorldVec(pt d2.Vec2) (t *Tile, ok bool) {
+	pt = pt.Scale(w.GridScale)
+	return w.TileAt(int(math32.Floor(pt[0])), int(math32.Floor(pt[1])))
+}
+
+/*
+ * Tile gets the tile at the given coordinates in the grid, or nil.
+ *
+ * (x, y) represent *grid* coordinates. Prefer TileAt when the coordinates
+ * may lie outside of the grid.
+ */
+func (w World) Tile(x, y int) *Tile {
+	t, _ := w.TileAt(x, y)
+	return t
+}
+
+/*
+ * TileFromVec gets the tile at given point in the grid, or nil
  *
  * pt represents *grid* coordinates, i.e the map scale factor must be taken in
  * consideration to convert from *world* coordinates into *grid* coordinates.
  */
 func (w World) TileFromVec(pt d2.Vec2) *Tile {
-	return w.Tile(int(pt[0]), int(pt[1]))
+	t, _ := w.TileAt(int(math32.Floor(pt[0])), int(math32.Floor(pt[1])))
+	return t
 }
 
 /*
- * TileFromWorldVec gets the tile at given point in the grid
+ * TileFromWorldVec gets the tile at given point in the grid, or nil
  *
  * pt represents *world* coordinates, i.e TileFromWorldVec performs the
- * conversion from world coordinates into grid coordinates.
+ * conversion from world coordinates into grid coordinates. Prefer
+ * TileAtWorldVec when the point may lie outside of the grid.
  */
 func (w World) TileFromWorldVec(pt d2.Vec2) *Tile {
-	pt = pt.Scale(w.GridScale)
-	return w.TileFromVec(pt)
+	t, _ := w.TileAtWorldVec(pt)
+	return t
 }
 
 /*
@@ -123,56 +244,185 @@ func (w World) DumpGrid() {
  * IntersectingTiles returns the list of Tile intersecting with an AABB
  */
 func (w World) IntersectingTiles(bb d2.Rectangle) []*Tile {
-	// first thing: we need the tile that contains the center of the aabb
-	center := w.TileFromWorldVec(bb.Center())
-	tiles := []*Tile{center}
-	if bb.In(center.Rectangle()) {
-		// exit now if the aabb is contained in the center tile
-		return tiles
+	// range of grid coordinates covered by the aabb, clamped to the grid
+	x0, y0 := int(bb.Min[0]*w.GridScale), int(bb.Min[1]*w.GridScale)
+	x1, y1 := int(bb.Max[0]*w.GridScale), int(bb.Max[1]*w.GridScale)
+	if x0 < 0 {
+		x0 = 0
+	}
+	if y0 < 0 {
+		y0 = 0
+	}
+	if x1 >= w.GridWidth {
+		x1 = w.GridWidth - 1
+	}
+	if y1 >= w.GridHeight {
+		y1 = w.GridHeight - 1
 	}
 
-	// get the 4 'direct' neighbours
-	left := w.Tile(center.X-1, center.Y)
-	right := w.Tile(center.X+1, center.Y)
-	up := w.Tile(center.X, center.Y-1)
-	down := w.Tile(center.X, center.Y+1)
-
-	// intersection with horizontal and vertical neighbour tiles
-	if left != nil && left.Rectangle().Overlaps(bb) {
-		tiles = append(tiles, left)
-	} else {
-		left = nil
+	var tiles []*Tile
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			if t := w.Tile(x, y); t.Rectangle().Overlaps(bb) {
+				tiles = append(tiles, t)
+			}
+		}
 	}
-	if right != nil && right.Rectangle().Overlaps(bb) {
-		tiles = append(tiles, right)
-	} else {
-		right = nil
+	return tiles
+}
+
+/*
+ * Raycast walks through the tiles crossed by the segment going from org to
+ * dst, in order, and stops at the first tile for which blocks returns true.
+ *
+ * It returns that tile and true, or nil and false if the segment isn't
+ * blocked. Leaving the grid blocks the segment, in which case the returned
+ * tile is nil.
+ */
+func (w World) Raycast(org, dst d2.Vec2, blocks func(*Tile) bool) (*Tile, bool) {
+	// work in grid coordinates
+	a, b := org.Scale(w.GridScale), dst.Scale(w.GridScale)
+	x, y := int(math32.Floor(a[0])), int(math32.Floor(a[1]))
+	xEnd, yEnd := int(math32.Floor(b[0])), int(math32.Floor(b[1]))
+
+	// for each axis: step direction, value of the segment parameter at
+	// which the next tile boundary is crossed, and parameter increment
+	// between 2 boundaries
+	axis := func(a, b float32, cur int) (step int, tMax, tDelta float32) {
+		switch d := b - a; {
+		case d > 0:
+			return 1, (float32(cur+1) - a) / d, 1 / d
+		case d < 0:
+			return -1, (a - float32(cur)) / -d, 1 / -d
+		}
+		return 0, math32.Inf(1), math32.Inf(1)
 	}
-	if up != nil && up.Rectangle().Overlaps(bb) {
-		tiles = append(tiles, up)
-	} else {
-		up = nil
+	stepX, tMaxX, tDeltaX := axis(a[0], b[0], x)
+	stepY, tMaxY, tDeltaY := axis(a[1], b[1], y)
+
+	n := absInt(xEnd-x) + absInt(yEnd-y)
+	for i := 0; ; i++ {
+		t := w.Tile(x, y)
+		if t == nil || blocks(t) {
+			return t, true
+		}
+		if i == n {
+			return nil, false
+		}
+		if tMaxX < tMaxY && x != xEnd || y == yEnd {
+			x += stepX
+			tMaxX += tDeltaX
+		} else {
+			y += stepY
+			tMaxY += tDeltaY
+		}
 	}
-	if down != nil && down.Rectangle().Overlaps(bb) {
-		tiles = append(tiles, down)
-	} else {
-		down = nil
+}
+
+/*
+ * LineOfSight indicates if dst can be seen from org, that is if no wall nor
+ * building lies in between
+ */
+func (w World) LineOfSight(org, dst d2.Vec2) bool {
+	_, blocked := w.Raycast(org, dst, isOpaque)
+	return !blocked
+}
+
+/*
+ * lineOfSightFrom is LineOfSight for an observer standing on an opaque tile,
+ * like a turret on its building: the tile of org doesn't block the view
+ */
+func (w World) lineOfSightFrom(org, dst d2.Vec2) bool {
+	from, _ := w.TileAtWorldVec(org)
+	_, blocked := w.Raycast(org, dst, func(t *Tile) bool {
+		return t != from && isOpaque(t)
+	})
+	return !blocked
+}
+
+/*
+ * isOpaque indicates if a tile blocks the line of sight
+ */
+func isOpaque(t *Tile) bool {
+	return !t.IsWalkable()
+}
+
+/*
+ * Occupancy returns the dynamic layer of the world grid
+ */
+func (w *World) Occupancy() *Occupancy {
+	return w.occupancy
+}
+
+/*
+ * Regions returns the connected regions of the walkable tiles, relabeled
+ * first if buildings have blocked or freed tiles since the last call
+ */
+func (w *World) Regions() *Regions {
+	if w.regions == nil || w.regions.version != w.occupancy.version {
+		w.regions = newRegions(w)
 	}
+	return w.regions
+}
 
-	// intersection with diagonal neighbour tiles
-	if left != nil && up != nil {
-		tiles = append(tiles, w.Tile(center.X-1, center.Y-1))
+/*
+ * Reachable indicates if a path may exist from the tile org to the tile dst.
+ *
+ * It's false if dst can't be walked on, or if both tiles are in different
+ * regions. An origin that can't be walked on, like the tile of an entity
+ * standing against a building, may border several regions, so a path from
+ * it may exist to any walkable tile.
+ */
+func (w *World) Reachable(org, dst *Tile) bool {
+	switch {
+	case org == dst:
+		return true
+	case !dst.IsWalkable():
+		return false
+	case !org.IsWalkable():
+		return true
 	}
-	if left != nil && down != nil {
-		tiles = append(tiles, w.Tile(center.X-1, center.Y+1))
+	regions := w.Regions()
+	return regions.Region(org.X, org.Y) == regions.Region(dst.X, dst.Y)
+}
+
+/*
+ * navVersion returns a version number of the dynamic layer, that changes when
+ * tiles get blocked or freed, and also when they get crowded or not if crowds
+ * is true
+ */
+func (w *World) navVersion(crowds bool) uint64 {
+	v := w.occupancy.version
+	if crowds {
+		v += w.occupancy.crowdVersion
 	}
-	if right != nil && up != nil {
-		tiles = append(tiles, w.Tile(center.X+1, center.Y-1))
+	return v
+}
+
+/*
+ * navSnapshot returns a copy of the world grid and of its occupancy, holding
+ * no entities. If crowds is true, moving onto a crowded tile of the snapshot
+ * is CrowdedTileCost times more costly.
+ *
+ * The snapshot is never modified, so paths can be searched on it from other
+ * goroutines than the game loop.
+ */
+func (w *World) navSnapshot(crowds bool) *World {
+	snap := &World{
+		GridWidth:   w.GridWidth,
+		GridHeight:  w.GridHeight,
+		Width:       w.Width,
+		Height:      w.Height,
+		GridScale:   w.GridScale,
+		occupancy:   w.occupancy.clone(),
+		avoidCrowds: crowds,
 	}
-	if right != nil && down != nil {
-		tiles = append(tiles, w.Tile(center.X+1, center.Y+1))
+	snap.Grid = make([]Tile, len(w.Grid))
+	for i := range w.Grid {
+		t := &w.Grid[i]
+		snap.Grid[i] = Tile{Kind: t.Kind, Cost: t.Cost, X: t.X, Y: t.Y, W: snap, aabb: t.aabb}
 	}
-	return tiles
+	return snap
 }
 
 /*
@@ -187,6 +437,11 @@ func (w *World) AttachEntity(ent Entity) {
 
 	// add those links to the world (for fast query by entity id)
 	w.Entities[ent.Id()] = tileList
+
+	// and index it
+	w.index.insert(ent)
+
+	w.occupancy.add(ent, tileList, 1)
 }
 
 /*
@@ -197,9 +452,12 @@ func (w *World) DetachEntity(ent Entity) {
 	tileList := w.Entities[ent.Id()]
 	// detach the entity from each of those tiles
 	w.detachFrom(ent, tileList...)
+	w.occupancy.add(ent, tileList, -1)
 
 	// clear the tile list for this entity
-	w.Entities[ent.Id()] = make(TileList, 0)
+	delete(w.Entities, ent.Id())
+
+	w.index.remove(ent)
 }
 
 func (w *World) attachTo(ent Entity, tiles ...*Tile) {
@@ -224,42 +482,100 @@ func (w *World) detachFrom(ent Entity, tiles ...*Tile) {
  * in order to avoid useless computation of intersections
  */
 func (w *World) UpdateEntity(ent Entity) {
-	// simply detach and re-attach it
-	w.DetachEntity(ent)
-	w.AttachEntity(ent)
+	// simply detach and re-attach it to the tiles
+	old := w.Entities[ent.Id()]
+	w.detachFrom(ent, old...)
+	w.occupancy.add(ent, old, -1)
+	tileList := w.IntersectingTiles(ent.Rectangle())
+	w.attachTo(ent, tileList...)
+	w.occupancy.add(ent, tileList, 1)
+	w.Entities[ent.Id()] = tileList
+
+	// the spatial index knows better
+	w.index.update(ent)
 }
 
 /*
  * AABBSpatialQuery returns the set of entities intersecting with given aabb
  *
- * The query is performed on the underlying grid representation from the world, by
- * first retrieving the tiles that intersect with the provided bounding box.
- * As each tile has an always-updated list of entities that intersect with itself,
- * the result of the spatial query is the set of those entities.
+ * The query is performed on the quadtree indexing the world entities, only the
+ * quadrants overlapping with the provided bounding box are visited.
  *
  * Important Note: if the query is performed by passing the bounding box of an entity,
  * the returned set will contain this entity.
  */
 func (w *World) AABBSpatialQuery(bb d2.Rectangle) *EntitySet {
-	// set to contain all the entities around, though not necessarily colliding
-	allEntities := NewEntitySet()
+	colliding := NewEntitySet()
+	w.index.query(bb, func(ent Entity) bool {
+		colliding.Add(ent)
+		return true
+	})
+	return colliding
+}
 
-	// loop on the intersecting tiles
-	for _, it := range w.IntersectingTiles(bb) {
-		// add all the entities attached to this tile
-		allEntities.Union(&it.Entities)
-	}
+/*
+ * AABBSpatialQueryInto appends the entities intersecting with given aabb to
+ * buf[:0], and returns the resulting slice.
+ *
+ * It performs the same query as AABBSpatialQuery, but allows the caller to
+ * reuse the same buffer from one query to another, as queries are performed
+ * by every moving entity, at every tick.
+ */
+func (w *World) AABBSpatialQueryInto(bb d2.Rectangle, buf []Entity) []Entity {
+	buf = buf[:0]
+	w.index.query(bb, func(ent Entity) bool {
+		buf = append(buf, ent)
+		return true
+	})
+	return buf
+}
 
-	colliding := NewEntitySet()
-	// filter out the non-colliding entities
-	allEntities.Each(func(ent Entity) bool {
-		if ent.Rectangle().Overlaps(bb) {
-			colliding.Add(ent)
+/*
+ * CircleSpatialQuery returns the set of entities whose position lies within
+ * radius of center.
+ *
+ * The entities intersecting with the bounding box of the circle are first
+ * queried on the quadtree, then filtered by their actual distance to center.
+ */
+func (w *World) CircleSpatialQuery(center d2.Vec2, radius float32) *EntitySet {
+	set := NewEntitySet()
+	w.circleQuery(center, radius, func(ent Entity, sqDist float32) bool {
+		set.Add(ent)
+		return true
+	})
+	return set
+}
+
+/*
+ * circleQuery calls f with each entity whose position lies within radius of
+ * center, and its squared distance to center, until f returns false
+ */
+func (w *World) circleQuery(center d2.Vec2, radius float32, f func(ent Entity, sqDist float32) bool) {
+	w.index.query(d2.RectFromCircle(center, radius), func(ent Entity) bool {
+		if sqDist := ent.Position().Sub(center).LenSqr(); sqDist <= radius*radius {
+			return f(ent, sqDist)
 		}
 		return true
 	})
+}
 
-	return colliding
+/*
+ * coneQuery calls f with each entity whose position lies within the cone of
+ * given radius and angle, in radians, having its apex at apex and opening in
+ * direction dir, and its squared distance to apex, until f returns false
+ */
+func (w *World) coneQuery(apex, dir d2.Vec2, radius, angle float32, f func(ent Entity, sqDist float32) bool) {
+	if dir.Len() < 1e-6 {
+		return
+	}
+	dir = dir.Scale(1 / dir.Len())
+	minCos := math32.Cos(angle / 2)
+	w.circleQuery(apex, radius, func(ent Entity, sqDist float32) bool {
+		if sqDist > 1e-12 && ent.Position().Sub(apex).Dot(dir) < minCos*math32.Sqrt(sqDist) {
+			return true
+		}
+		return f(ent, sqDist)
+	})
 }
 
 /*
@@ -0,0 +1,143 @@
+package surviveler
+
+import (
+	"testing"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+var pillarRoom = []string{
+	"##########",
+	"#........#",
+	"#...#....#",
+	"#........#",
+	"##########",
+}
+
+func TestWorld_Raycast(t *testing.T) {
+	w := newTestWorld(t, 1, pillarRoom...)
+
+	tests := []struct {
+		name     string
+		org, dst d2.Vec2
+		want     *Tile
+		blocked  bool
+	}{
+		{"same tile", d2.Vec2{1.2, 1.2}, d2.Vec2{1.8, 1.7}, nil, false},
+		{"clear line", d2.Vec2{1.5, 1.5}, d2.Vec2{8.5, 1.5}, nil, false},
+		{"clear diagonal", d2.Vec2{1.5, 1.5}, d2.Vec2{3.5, 3.5}, nil, false},
+		{"pillar", d2.Vec2{1.5, 2.5}, d2.Vec2{8.5, 2.5}, w.Tile(4, 2), true},
+		{"pillar backwards", d2.Vec2{8.5, 2.5}, d2.Vec2{1.5, 2.5}, w.Tile(4, 2), true},
+		{"pillar diagonal", d2.Vec2{2.5, 1.5}, d2.Vec2{6.5, 3.5}, w.Tile(4, 2), true},
+		{"outer wall", d2.Vec2{1.5, 1.5}, d2.Vec2{1.5, -3}, w.Tile(1, 0), true},
+		{"out of grid", d2.Vec2{-1, -1}, d2.Vec2{1.5, 1.5}, nil, true},
+	}
+	for _, tt := range tests {
+		tile, blocked := w.Raycast(tt.org, tt.dst, isOpaque)
+		if tile != tt.want || blocked != tt.blocked {
+			t.Errorf("%s: Raycast(%v, %v) = %v, %v, want %v, %v",
+				tt.name, tt.org, tt.dst, tile, blocked, tt.want, tt.blocked)
+		}
+	}
+}
+
+func TestWorld_LineOfSightBlockedByBuilding(t *testing.T) {
+	g := newTestGame(t, pillarRoom...)
+	w := g.state.World()
+	org, dst := d2.Vec2{1.5, 1.5}, d2.Vec2{8.5, 1.5}
+
+	if !w.LineOfSight(org, dst) {
+		t.Fatalf("LineOfSight(%v, %v) = false, want true", org, dst)
+	}
+	g.state.createBuilding(BarricadeBuilding, d2.Vec2{5.5, 1.5})
+	if w.LineOfSight(org, dst) {
+		t.Errorf("LineOfSight(%v, %v) = true through a building", org, dst)
+	}
+}
+
+func TestWorld_TileAt(t *testing.T) {
+	w := newTestWorld(t, 2, openRoom...) // 9x5 grid, 4.5x2.5 world
+	tests := []struct {
+		x, y int
+		want bool
+	}{
+		{0, 0, true},
+		{8, 4, true},
+		{-1, 0, false},
+		{0, -1, false},
+		{9, 0, false},
+		{0, 5, false},
+		{9, 5, false},
+	}
+	for _, tt := range tests {
+		if got := w.InBounds(tt.x, tt.y); got != tt.want {
+			t.Errorf("InBounds(%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+		tile, ok := w.TileAt(tt.x, tt.y)
+		if ok != tt.want || (tile != nil) != tt.want {
+			t.Errorf("TileAt(%d, %d) = %v, %v, want ok = %v", tt.x, tt.y, tile, ok, tt.want)
+			continue
+		}
+		if ok && (tile.X != tt.x || tile.Y != tt.y) {
+			t.Errorf("TileAt(%d, %d) returned tile (%d, %d)", tt.x, tt.y, tile.X, tile.Y)
+		}
+	}
+
+	// world coordinates are rounded down, even when negative
+	for _, tt := range []struct {
+		pt   d2.Vec2
+		want bool
+	}{
+		{d2.Vec2{0, 0}, true},
+		{d2.Vec2{4.4, 2.4}, true},
+		{d2.Vec2{-0.1, 1}, false},
+		{d2.Vec2{1, -0.1}, false},
+		{d2.Vec2{4.5, 1}, false},
+		{d2.Vec2{1, 2.5}, false},
+	} {
+		if _, ok := w.TileAtWorldVec(tt.pt); ok != tt.want {
+			t.Errorf("TileAtWorldVec(%v) ok = %v, want %v", tt.pt, ok, tt.want)
+		}
+	}
+}
+
+func TestWorld_CircleSpatialQuery(t *testing.T) {
+	g := newTestGame(t, newTestRoom(21)...)
+	w := g.state.World()
+	center := d2.Vec2{10.5, 10.5}
+	const (
+		radius = 4
+		diag   = 0.70710678 // cos and sin of 45°
+	)
+	tests := []struct {
+		name   string
+		offset d2.Vec2
+		inside bool
+	}{
+		{"center", d2.Vec2{0, 0}, true},
+		{"on the x axis, inside", d2.Vec2{radius - 0.05, 0}, true},
+		{"on the y axis, outside", d2.Vec2{0, -radius - 0.05}, false},
+		{"diagonal, just inside", d2.Vec2{diag, diag}.Scale(radius - 0.05), true},
+		{"diagonal, just outside", d2.Vec2{-diag, diag}.Scale(radius + 0.05), false},
+		{"bounding box corner", d2.Vec2{-radius, -radius}, false},
+	}
+	zombies := make([]*Zombie, len(tests))
+	for i, tt := range tests {
+		zombies[i] = addTestZombie(g, center.Add(tt.offset))
+	}
+
+	set := w.CircleSpatialQuery(center, radius)
+	aabb := w.AABBSpatialQuery(d2.RectFromCircle(center, radius))
+	for i, tt := range tests {
+		if set.Contains(zombies[i]) != tt.inside {
+			t.Errorf("%s: in circle = %v, want %v", tt.name, !tt.inside, tt.inside)
+		}
+		// the bounding box broadphase finds them all
+		if !aabb.Contains(zombies[i]) {
+			t.Errorf("%s: not found by the bounding box query", tt.name)
+		}
+	}
+	if set.Len() != 3 {
+		t.Errorf("%d entities in circle, want 3", set.Len())
+	}
+}
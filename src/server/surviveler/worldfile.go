@@ -0,0 +1,262 @@
+/*
+ * Surviveler package
+ * world files, the editable format of the world grid
+ */
+package surviveler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+ * worldFileVersion is the version of the world file format
+ */
+const worldFileVersion = 1
+
+/*
+ * Characters of the tile kinds, in the rows of a world file
+ */
+const (
+	worldFileWall     = '#'
+	worldFileWalkable = '.'
+	worldFileTurret   = 'T'
+)
+
+/*
+ * WorldFile is the JSON representation of a world grid, with its special
+ * tiles and its spawn points, as read and written by the map editor.
+ *
+ * A world file looks like:
+ *
+ *   {
+ *     "version": 1,
+ *     "grid_scale": 1,
+ *     "rows": [
+ *       "#####",
+ *       "#...#",
+ *       "#####"
+ *     ],
+ *     "special_tiles": [
+ *       {"x": 1, "y": 1, "cost": 2},
+ *       {"x": 2, "y": 1, "effects": ["hazard"], "damage": 10},
+ *       {"x": 3, "y": 1, "effects": ["slow", "spawn_only"], "speed": 0.5}
+ *     ],
+ *     "spawn_points": [
+ *       {"name": "door", "type": "player", "pos": [1.5, 1.5]}
+ *     ]
+ *   }
+ *
+ * Rows are listed from the top of the map, a character per tile: '#' for a
+ * wall, '.' for a walkable tile and 'T' for a turret. The grid scale is the
+ * number of tiles per world unit. Special tiles are the tiles having a
+ * terrain cost other than DefaultTileCost, or special effects (the effects
+ * of the map zones), damage and speed being only read for the hazard and
+ * slow tiles. Spawn points are in world units.
+ */
+type WorldFile struct {
+	Version      int           `json:"version"`
+	GridScale    float32       `json:"grid_scale"`
+	Rows         []string      `json:"rows"`
+	SpecialTiles []SpecialTile `json:"special_tiles,omitempty"`
+	SpawnPoints  []SpawnPoint  `json:"spawn_points"`
+}
+
+/*
+ * SpecialTile is a tile of a world file having a terrain cost or special
+ * effects
+ */
+type SpecialTile struct {
+	X       int      `json:"x"`
+	Y       int      `json:"y"`
+	Cost    float32  `json:"cost,omitempty"`    // terrain cost, DefaultTileCost if omitted
+	Effects []string `json:"effects,omitempty"` // ZoneHazard, ZoneSlow and/or ZoneSpawnOnly
+	Damage  float32  `json:"damage,omitempty"`  // hazard: hit points lost per second
+	Speed   float32  `json:"speed,omitempty"`   // slow: speed factor, in ]0, 1[
+}
+
+/*
+ * tileEffectNames maps the tile flags to the effect names of the world files
+ */
+var tileEffectNames = []struct {
+	flag TileFlags
+	name string
+}{
+	{TileHazard, ZoneHazard},
+	{TileSlow, ZoneSlow},
+	{TileSpawnOnly, ZoneSpawnOnly},
+}
+
+/*
+ * WriteWorld writes the grid of w, with its special tiles and the given spawn
+ * points, as a world file
+ */
+func WriteWorld(wr io.Writer, w *World, spawns []SpawnPoint) error {
+	wf := WorldFile{
+		Version:     worldFileVersion,
+		GridScale:   w.GridScale,
+		Rows:        make([]string, w.GridHeight),
+		SpawnPoints: spawns,
+	}
+	row := make([]byte, w.GridWidth)
+	for y := 0; y < w.GridHeight; y++ {
+		for x := 0; x < w.GridWidth; x++ {
+			t := w.Tile(x, y)
+			switch t.Kind {
+			case KindNotWalkable:
+				row[x] = worldFileWall
+			case KindWalkable:
+				row[x] = worldFileWalkable
+			case KindTurret:
+				row[x] = worldFileTurret
+			default:
+				return fmt.Errorf("tile (%d, %d) has an unknown kind: %d", x, y, t.Kind)
+			}
+			if st, ok := newSpecialTile(t); ok {
+				wf.SpecialTiles = append(wf.SpecialTiles, st)
+			}
+		}
+		wf.Rows[y] = string(row)
+	}
+	if wf.SpawnPoints == nil {
+		wf.SpawnPoints = []SpawnPoint{}
+	}
+	enc := json.NewEncoder(wr)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&wf)
+}
+
+/*
+ * newSpecialTile returns the special tile of t, or false if t isn't special
+ */
+func newSpecialTile(t *Tile) (SpecialTile, bool) {
+	st := SpecialTile{X: t.X, Y: t.Y}
+	special := t.Cost != DefaultTileCost
+	if special {
+		st.Cost = t.Cost
+	}
+	if t.Effects != nil && t.Effects.Flags != 0 {
+		special = true
+		for _, e := range tileEffectNames {
+			if t.Effects.Has(e.flag) {
+				st.Effects = append(st.Effects, e.name)
+			}
+		}
+		if t.Effects.Has(TileHazard) {
+			st.Damage = t.Effects.Damage
+		}
+		if t.Effects.Has(TileSlow) {
+			st.Speed = t.Effects.Speed
+		}
+	}
+	return st, special
+}
+
+/*
+ * ReadWorld reads a world file, and returns the world made of it, with its
+ * spawn points
+ */
+func ReadWorld(r io.Reader) (*World, []SpawnPoint, error) {
+	var wf WorldFile
+	if err := json.NewDecoder(r).Decode(&wf); err != nil {
+		return nil, nil, fmt.Errorf("couldn't decode the world file: %v", err)
+	}
+	w, err := wf.world()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w, wf.SpawnPoints, nil
+}
+
+/*
+ * world builds the world described by the world file, or returns an error
+ * if it's badly defined
+ */
+func (wf *WorldFile) world() (*World, error) {
+	if wf.Version != worldFileVersion {
+		return nil, fmt.Errorf("unsupported world file version: %d", wf.Version)
+	}
+	if wf.GridScale <= 0 {
+		return nil, fmt.Errorf("world file grid scale must be positive, got %v", wf.GridScale)
+	}
+	if len(wf.Rows) == 0 || len(wf.Rows[0]) == 0 {
+		return nil, errors.New("world file has an empty grid")
+	}
+
+	w := newWorld(len(wf.Rows[0]), len(wf.Rows), wf.GridScale)
+	for y, row := range wf.Rows {
+		if len(row) != w.GridWidth {
+			return nil, fmt.Errorf("world file row %d has %d tiles, want %d", y, len(row), w.GridWidth)
+		}
+		for x := 0; x < len(row); x++ {
+			var kind TileKind
+			switch row[x] {
+			case worldFileWall:
+				kind = KindNotWalkable
+			case worldFileWalkable:
+				kind = KindWalkable
+			case worldFileTurret:
+				kind = KindTurret
+			default:
+				return nil, fmt.Errorf("world file tile (%d, %d) has an unknown kind: '%c'", x, y, row[x])
+			}
+			w.Grid[x+y*w.GridWidth] = NewTile(kind, w, x, y)
+		}
+	}
+	for _, st := range wf.SpecialTiles {
+		if err := st.apply(w); err != nil {
+			return nil, err
+		}
+	}
+	w.regions = newRegions(w)
+	return w, nil
+}
+
+/*
+ * apply sets the cost and the effects of the special tile on the tile of w
+ */
+func (st *SpecialTile) apply(w *World) error {
+	t, ok := w.TileAt(st.X, st.Y)
+	if !ok {
+		return fmt.Errorf("special tile (%d, %d) is out of the grid", st.X, st.Y)
+	}
+	if st.Cost != 0 {
+		if st.Cost < MinTileCost {
+			return fmt.Errorf("special tile (%d, %d) cost must be %v or more, got %v", st.X, st.Y, MinTileCost, st.Cost)
+		}
+		t.Cost = st.Cost
+	}
+	if len(st.Effects) == 0 {
+		return nil
+	}
+
+	eff := &TileEffects{Speed: 1}
+	for _, name := range st.Effects {
+		var flag TileFlags
+		for _, e := range tileEffectNames {
+			if e.name == name {
+				flag = e.flag
+			}
+		}
+		if flag == 0 {
+			return fmt.Errorf("special tile (%d, %d) has an unknown effect: '%s'", st.X, st.Y, name)
+		}
+		eff.Flags |= flag
+	}
+	if eff.Has(TileHazard) {
+		if st.Damage <= 0 {
+			return fmt.Errorf("hazard tile (%d, %d) must have a positive damage, got %v", st.X, st.Y, st.Damage)
+		}
+		eff.Damage = st.Damage
+	}
+	if eff.Has(TileSlow) {
+		if st.Speed <= 0 || st.Speed >= 1 {
+			return fmt.Errorf("slow tile (%d, %d) must have a speed in ]0, 1[, got %v", st.X, st.Y, st.Speed)
+		}
+		eff.Speed = st.Speed
+	}
+	t.Effects = eff
+	return nil
+}
@@ -0,0 +1,156 @@
+package surviveler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"server/resource"
+	"strings"
+	"testing"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+func TestWorldFile_RoundTrip(t *testing.T) {
+	w := newTestWorld(t, 2,
+		"##########",
+		"#........#",
+		"#..##....#",
+		"#........#",
+		"##########")
+	w.Tile(6, 1).Kind = KindTurret
+	w.Tile(2, 3).Cost = 2.5
+	w.Tile(3, 3).Cost = MinTileCost
+	err := w.LoadZones([]MapZone{
+		{Name: "acid", Effect: ZoneHazard, Rect: Rect2D{{0.5, 0.5}, {1.5, 1}}, Damage: 10},
+		{Name: "mud", Effect: ZoneSlow, Rect: Rect2D{{3, 1}, {4.5, 2}}, Speed: 0.5},
+		{Name: "spawn", Effect: ZoneSpawnOnly, Rect: Rect2D{{4, 1}, {4.5, 2}}},
+	})
+	if err != nil {
+		t.Fatalf("LoadZones() error = %v", err)
+	}
+	spawns := []SpawnPoint{
+		{Name: "door", Type: PlayerSpawnPoint, Pos: d2.Vec2{0.75, 0.75}},
+		{Name: "vent", Type: ZombieSpawnPoint, Pos: d2.Vec2{4.25, 1.75}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteWorld(&buf, w, spawns); err != nil {
+		t.Fatalf("WriteWorld() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `"#.....T..#"`) {
+		t.Errorf("world file rows aren't readable:\n%s", buf.String())
+	}
+	got, gotSpawns, err := ReadWorld(&buf)
+	if err != nil {
+		t.Fatalf("ReadWorld() error = %v", err)
+	}
+
+	sameGrid(t, got, w)
+	for i := range w.Grid {
+		g, want := &got.Grid[i], &w.Grid[i]
+		if !reflect.DeepEqual(g.Effects, want.Effects) {
+			t.Errorf("tile (%d, %d) effects = %+v, want %+v", g.X, g.Y, g.Effects, want.Effects)
+		}
+	}
+	if eff := got.Tile(8, 3).Effects; eff == nil || eff.Flags != TileSlow|TileSpawnOnly || eff.Speed != 0.5 {
+		t.Errorf("tile (8, 3) effects = %+v, want slow and spawn only", eff)
+	}
+	if !reflect.DeepEqual(gotSpawns, spawns) {
+		t.Errorf("spawn points = %+v, want %+v", gotSpawns, spawns)
+	}
+}
+
+func TestReadWorld_Errors(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{`{"version": 2, "grid_scale": 1, "rows": ["."]}`, "unsupported world file version"},
+		{`{"version": 1, "grid_scale": 0, "rows": ["."]}`, "grid scale must be positive"},
+		{`{"version": 1, "grid_scale": 1, "rows": []}`, "empty grid"},
+		{`{"version": 1, "grid_scale": 1, "rows": ["...", ".."]}`, "row 1 has 2 tiles, want 3"},
+		{`{"version": 1, "grid_scale": 1, "rows": [".x."]}`, "tile (1, 0) has an unknown kind: 'x'"},
+		{`{"version": 1, "grid_scale": 1, "rows": ["."], "special_tiles": [{"x": 1, "y": 0, "cost": 2}]}`,
+			"special tile (1, 0) is out of the grid"},
+		{`{"version": 1, "grid_scale": 1, "rows": ["."], "special_tiles": [{"x": 0, "y": 0, "cost": 0.1}]}`,
+			"cost must be 0.25 or more"},
+		{`{"version": 1, "grid_scale": 1, "rows": ["."], "special_tiles": [{"x": 0, "y": 0, "effects": ["lava"]}]}`,
+			"unknown effect: 'lava'"},
+		{`{"version": 1, "grid_scale": 1, "rows": ["."], "special_tiles": [{"x": 0, "y": 0, "effects": ["hazard"]}]}`,
+			"must have a positive damage"},
+		{`{"version": 1, "grid_scale": 1, "rows": ["."], "special_tiles": [{"x": 0, "y": 0, "effects": ["slow"], "speed": 1}]}`,
+			"must have a speed in ]0, 1["},
+		{`{"version": 1, "grid_scale": 1, "rows": "."}`, "couldn't decode the world file"},
+	}
+	for _, tt := range tests {
+		_, _, err := ReadWorld(strings.NewReader(tt.file))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ReadWorld(%s) error = %v, want it to contain %q", tt.file, err, tt.want)
+		}
+	}
+}
+
+func TestNewGameData_WorldFile(t *testing.T) {
+	assets, cleanup := copyTestAssets(t)
+	defer cleanup()
+
+	// the world file replaces the map bitmaps and spawn points
+	world := `{
+  "version": 1,
+  "grid_scale": 1,
+  "rows": [
+    "######",
+    "#....#",
+    "#.##.#",
+    "#....#",
+    "######"
+  ],
+  "special_tiles": [
+    {"x": 4, "y": 3, "effects": ["hazard"], "damage": 5}
+  ],
+  "spawn_points": [
+    {"name": "corner", "type": "player", "pos": [1.5, 1.5]},
+    {"name": "hole", "type": "zombie", "pos": [4.5, 3.5]}
+  ]
+}`
+	mapData := filepath.Join(assets, "map", "data.json")
+	buf, err := ioutil.ReadFile(mapData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf = bytes.Replace(buf, []byte(`"matrix": "map/matrix.bmp"`),
+		[]byte(`"matrix": "map/matrix.bmp", "world": "map/world.json"`), 1)
+	if err := ioutil.WriteFile(mapData, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(assets, "map", "world.json"), []byte(world), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, err := resource.OpenFSPackage(assets)
+	if err != nil {
+		t.Fatalf("OpenFSPackage(%v) error = %v", assets, err)
+	}
+	gd, err := newGameData(pkg, 2, "")
+	if err != nil {
+		t.Fatalf("newGameData() error = %v", err)
+	}
+	w := gd.world
+	if w.GridWidth != 6 || w.GridHeight != 5 || w.GridScale != 1 {
+		t.Fatalf("world grid is %dx%d at scale %v, want the 6x5 grid of the world file at scale 1",
+			w.GridWidth, w.GridHeight, w.GridScale)
+	}
+	if w.Tile(2, 2).Kind != KindNotWalkable || w.Tile(1, 2).Kind != KindWalkable {
+		t.Errorf("world file walls haven't been loaded")
+	}
+	if eff := w.Tile(4, 3).Effects; !eff.Has(TileHazard) || eff.Damage != 5 {
+		t.Errorf("tile (4, 3) effects = %+v, want a hazard", eff)
+	}
+	spawn := gd.mapData.AIKeypoints.Spawn
+	if len(spawn.Players) != 1 || !spawn.Players[0].Approx(d2.Vec2{1.5, 1.5}) ||
+		len(spawn.Enemies) != 1 || !spawn.Enemies[0].Approx(d2.Vec2{4.5, 3.5}) {
+		t.Errorf("spawn points = %+v, want the ones of the world file", spawn)
+	}
+}
@@ -5,8 +5,10 @@
 package surviveler
 
 import (
+	"fmt"
 	"server/actions"
 	"server/events"
+	"server/messages"
 	"time"
 
 	"github.com/aurelien-rainone/gogeo/f32/d2"
@@ -19,41 +21,153 @@ const (
 	lookingState = iota
 	walkingState
 	attackingState
+	returningState // going back to the anchor after giving up a chase
+	wanderingState // strolling around, with nothing to chase
+	dyingState     // killed, lying there until removed
 )
 
 // TODO: all of those values should be taken from the zombie resource
 const (
 	zombieLookingInterval = 200 * time.Millisecond
-	zombieDamageInterval  = 500 * time.Millisecond
+	zombieWindUpDuration  = 300 * time.Millisecond // time before a blow lands
+	zombieRecoveryTime    = 200 * time.Millisecond // time after a blow before the next wind-up
+	steerDuration         = 300 * time.Millisecond // time spent nudging around an obstacle
 	attackDistance        = 1.2
+	attackReach           = 0.1 // reach beyond the zombie bounding box
+	buildingSearchRadius  = 10  // max distance of a building to target
+	zombieWanderRadius    = 3   // max distance of a wander destination
+	zombieWanderSpeed     = 0.5 // wander speed, relatively to the walk speed
+	zombieWanderAttempts  = 5   // random points tried to find a walkable wander destination
+	zombieMinWanderPause  = time.Second
+	zombieMaxWanderPause  = 3 * time.Second
 )
 
+/*
+ * Behaviors of the zombie variants, the common zombies have none
+ */
+const (
+	ZombieScreamer = "screamer" // alerts the idle zombies around when it spots a player
+	ZombieTank     = "tank"     // isn't pushed back by the hits
+)
+
+// kinds of zombie targets
+const (
+	playerTarget = iota
+	buildingTarget
+)
+
+/*
+ * targetScore rates an entity of a given kind, at dist from a zombie, as its
+ * target. Lower priorities are preferred, then lower scores.
+ */
+type targetScore func(e Entity, kind int, dist float32) (priority int, score float32)
+
+/*
+ * targetScores holds the scoring functions of the zombie targets, by
+ * ZombieTargets policy
+ */
+var targetScores = map[string]targetScore{
+	ZombieTargetsPlayers: func(e Entity, kind int, dist float32) (int, float32) {
+		return kind, dist
+	},
+	ZombieTargetsBuildings: func(e Entity, kind int, dist float32) (int, float32) {
+		return buildingTarget - kind, dist
+	},
+	ZombieTargetsNearest: func(e Entity, kind int, dist float32) (int, float32) {
+		return 0, dist
+	},
+	ZombieTargetsWeakest: func(e Entity, kind int, dist float32) (int, float32) {
+		if d, ok := e.(damageable); ok {
+			return 0, d.hitPoints()
+		}
+		return 0, dist
+	},
+}
+
+/*
+ * targetCandidate is an entity a zombie could target, with its rating
+ */
+type targetCandidate struct {
+	e        Entity
+	dist     float32
+	priority int
+	score    float32
+}
+
+/*
+ * better indicates if c is a better target than o. Ties are broken by
+ * distance, then by id, so that the choice doesn't depend on the iteration
+ * order of the entities.
+ */
+func (c *targetCandidate) better(o *targetCandidate) bool {
+	switch {
+	case c.priority != o.priority:
+		return c.priority < o.priority
+	case c.score != o.score:
+		return c.score < o.score
+	case c.dist != o.dist:
+		return c.dist < o.dist
+	}
+	return c.e.Id() < o.e.Id()
+}
+
 type Zombie struct {
-	id          uint32
-	g           *Game
-	curState    int // current state
-	combatPower uint8
-	walkSpeed   float32
-	totalHP     float32
-	curHP       float32
-	timeAcc     time.Duration
-	target      Entity
-	world       *World
+	id        uint32
+	g         *Game
+	curState  int // current state
+	walkSpeed float32
+	health    *Health
+	combat    *Combat
+	stagger   *Stagger
+	modifiers *Modifiers
+	resists   *Resistances
+	timeAcc   time.Duration
+	target    Entity
+	searching bool                // waiting for a path search to complete
+	phase     actions.AttackPhase // phase of the current attack
+	world     *World
+	steer     d2.Vec2       // direction in which the zombie nudges around an obstacle
+	steerLeft time.Duration // time left nudging around the obstacle
+	anchor    d2.Vec2       // point the zombie is leashed to, where it spawned
+	chaseTime time.Duration // time spent chasing the current target
+	idleTime  time.Duration // time left idling before wandering
+	pathAge   time.Duration // time since the path toward the target was found
+	pathGoal  d2.Vec2       // target position when the path was found, nil if none was searched
+	pathNav   uint64        // world navigation version when the path was found
+	rng       *RNG
+	behavior  string  // ZombieScreamer, ZombieTank, or empty for a common zombie
+	alertDist float32 // screamer: distance up to which it alerts the zombies
+	alert     Entity  // target shared by a screamer, chased at the next look
 	*Movable
+	Components
 }
 
 func NewZombie(g *Game, pos d2.Vec2, walkSpeed float32, combatPower uint8, totalHP float32) *Zombie {
-	return &Zombie{
-		id:          InvalidID,
-		g:           g,
-		curState:    lookingState,
-		walkSpeed:   walkSpeed,
-		totalHP:     totalHP,
-		curHP:       totalHP,
-		combatPower: combatPower,
-		world:       g.State().World(),
-		Movable:     NewMovable(pos, walkSpeed),
+	z := &Zombie{
+		id:        InvalidID,
+		g:         g,
+		curState:  lookingState,
+		walkSpeed: walkSpeed,
+		health:    NewHealth(totalHP),
+		combat:    newCombat(g.cfg, uint16(combatPower), 0),
+		stagger:   &Stagger{},
+		modifiers: NewModifiers(g.cfg.ModifierStacking),
+		resists:   &Resistances{},
+		world:     g.State().World(),
+		anchor:    pos,
+		Movable:   NewMovable(pos, walkSpeed),
 	}
+	z.Movable.modifiers = z.modifiers
+	// zombies don't slow down, they run into their prey
+	z.Movable.Tolerance = g.cfg.ArrivalTolerance
+	z.AddComponent(z.Movable)
+	z.AddComponent(z.health)
+	z.AddComponent(z.combat)
+	z.AddComponent(z.stagger)
+	z.AddComponent(z.modifiers)
+	z.AddComponent(z.resists)
+	z.AddComponent(NewPositionHistory())
+	return z
 }
 
 func (z *Zombie) Id() uint32 {
@@ -62,50 +176,312 @@ func (z *Zombie) Id() uint32 {
 
 func (z *Zombie) SetId(id uint32) {
 	z.id = id
-}
-
-func (z *Zombie) findPathToTarget() (Path, bool) {
-	path, _, found := z.g.Pathfinder().FindPath(z.Pos, z.target.Position())
-	return path, found
+	seedCombat(z.g, z.combat, "zombie", id)
 }
 
 func (z *Zombie) look(dt time.Duration) (state int) {
 	state = z.curState
+	if z.searching {
+		// wait for the path search to complete
+		return
+	}
+	if ent := z.alert; ent != nil {
+		// a screamer spotted it, chase it as well
+		z.alert = nil
+		if z.g.State().Entity(ent.Id()) == ent {
+			z.searchPath([]Entity{ent}, ent)
+			return
+		}
+	}
+
+	// target the preferred player or building, or if it can't be reached,
+	// the other one
+	targets := z.findTargets()
+	switch {
+	case len(targets) > 0:
+		z.searchPath(targets, targets[0])
+	case z.g.cfg.ZombieLeash > 0 && z.Pos.Sub(z.anchor).Len() > zombieWanderRadius:
+		// nothing to chase around here, go home
+		z.giveUp()
+		state = returningState
+	default:
+		if z.idleTime -= dt; z.idleTime <= 0 {
+			z.startWandering()
+		}
+	}
+	return
+}
+
+/*
+ * startWandering requests a path to a random walkable point around the
+ * zombie, or around its anchor if it's leashed. Once found, on a later tick,
+ * the zombie starts wandering along it.
+ */
+func (z *Zombie) startWandering() {
+	if z.rng == nil {
+		z.rng = z.g.rng.Derive(fmt.Sprintf("zombie %d", z.id))
+	}
+	center := z.Pos
+	if z.g.cfg.ZombieLeash > 0 {
+		center = z.anchor
+	}
 
-	ent, dist := z.findTarget()
-	if ent != nil {
-		// update the target
-		z.target = ent
+	var (
+		dst   d2.Vec2
+		found bool
+	)
+	for i := 0; i < zombieWanderAttempts && !found; i++ {
+		dst = z.rng.InCircle(center, zombieWanderRadius)
+		tile, ok := z.world.TileAtWorldVec(dst)
+		found = ok && tile.IsWalkable()
+	}
+	z.idleTime = z.wanderPause()
+	if !found {
+		return
+	}
 
-		path, found := z.findPathToTarget()
-		if found == false {
+	z.searching = true
+	z.g.Pathfinder().Request(z.Pos, dst, func(path Path, found bool) {
+		z.searching = false
+		if z.g.State().Entity(z.id) != z || z.curState != lookingState || !found {
 			return
 		}
 		z.SetPath(path)
+		z.curState = wanderingState
+		z.timeAcc = 0
+	})
+}
+
+/*
+ * wanderPause returns a random time to idle between 2 wanders
+ */
+func (z *Zombie) wanderPause() time.Duration {
+	return time.Duration(z.rng.Range(float32(zombieMinWanderPause), float32(zombieMaxWanderPause)))
+}
 
-		// update the state
-		if dist < attackDistance {
-			state = attackingState
-		} else {
-			state = walkingState
+/*
+ * wander moves the zombie toward its wander destination, and keeps looking
+ * for targets on the way, to chase them as soon as they show up
+ */
+func (z *Zombie) wander(dt time.Duration) (state int) {
+	state = z.curState
+	if z.alert != nil {
+		z.SetPath(nil)
+		return lookingState
+	}
+	if z.timeAcc >= zombieLookingInterval {
+		z.timeAcc -= zombieLookingInterval
+		if z.findTarget() != nil {
+			z.SetPath(nil)
+			return lookingState
 		}
 	}
+
+	z.Speed = z.walkSpeed * zombieWanderSpeed
+	moved := false
+	if !z.HasReachedDestination() {
+		moved, _ = z.Movable.MoveOrSlide(z.world, z, dt, isZombieObstacle)
+	}
+	if !moved {
+		// arrived or blocked, take a break
+		z.SetPath(nil)
+		return lookingState
+	}
+	z.world.UpdateEntity(z)
+	return
+}
+
+/*
+ * searchPath requests a path to the first reachable entity of targets. Once
+ * found, on a later tick, the zombie targets that entity and starts walking
+ * toward it.
+ *
+ * If none of the targets can be reached, the zombie approaches fallback as
+ * close as it can, so that zombies mass at the barriers rather than waiting
+ * for a way in.
+ */
+func (z *Zombie) searchPath(targets []Entity, fallback Entity) {
+	if len(targets) == 0 {
+		z.approach(fallback)
+		return
+	}
+	ent := targets[0]
+	z.searching = true
+	z.g.Pathfinder().Request(z.Pos, ent.Position(), func(path Path, found bool) {
+		z.searching = false
+		if !z.canFollow(ent) {
+			// the zombie or its target are gone, look again
+			return
+		}
+		if !found {
+			z.searchPath(targets[1:], fallback)
+			return
+		}
+		z.follow(ent, path)
+	})
+}
+
+/*
+ * approach requests a path to the reachable point that is the closest to
+ * ent. Once found, on a later tick, the zombie targets ent and walks there.
+ */
+func (z *Zombie) approach(ent Entity) {
+	z.searching = true
+	z.g.Pathfinder().RequestNearest(z.Pos, ent.Position(), func(path Path, found bool) {
+		z.searching = false
+		if z.canFollow(ent) && found {
+			z.follow(ent, path)
+		}
+	})
+}
+
+/*
+ * canFollow indicates if the zombie, still looking for a target, can follow
+ * ent, that is if both still exist
+ */
+func (z *Zombie) canFollow(ent Entity) bool {
+	gs := z.g.State()
+	return gs.Entity(z.id) == z && z.curState == lookingState && gs.Entity(ent.Id()) == ent
+}
+
+/*
+ * follow targets ent and sets the zombie on path toward it
+ */
+func (z *Zombie) follow(ent Entity, path Path) {
+	if ent != z.target {
+		z.chaseTime = 0
+		if _, ok := ent.(*Player); ok && z.behavior == ZombieScreamer {
+			z.scream(ent)
+		}
+	}
+	z.target = ent
+	z.alert = nil
+	z.SetPath(path)
+	pos := ent.Position()
+	z.pathAge = 0
+	z.pathGoal = d2.Vec2{pos[0], pos[1]}
+	z.pathNav = z.world.navVersion(false)
+
+	// update the state
+	z.timeAcc = 0
+	z.phase = actions.AttackWindUp
+	if z.inAttackRange(ent) {
+		z.curState = attackingState
+	} else {
+		z.curState = walkingState
+	}
+}
+
+/*
+ * scream alerts the idle zombies within the alert radius of the screamer,
+ * which chase target from their next look
+ */
+func (z *Zombie) scream(target Entity) {
+	for _, ent := range z.g.State().entitiesInRadius(z.Pos, z.alertDist, func(e Entity) bool {
+		o, ok := e.(*Zombie)
+		return ok && o != z && o.idle()
+	}) {
+		ent.e.(*Zombie).alert = target
+	}
+}
+
+/*
+ * Target returns the id of the entity the zombie is chasing, and false if it
+ * isn't chasing anyone
+ *
+ * It implements the Targeter interface
+ */
+func (z *Zombie) Target() (uint32, bool) {
+	if z.target == nil || (z.curState != walkingState && z.curState != attackingState) {
+		return InvalidID, false
+	}
+	return z.target.Id(), true
+}
+
+/*
+ * idle indicates if the zombie is neither chasing, going back to its anchor
+ * nor dying
+ */
+func (z *Zombie) idle() bool {
+	return z.curState == lookingState || z.curState == wanderingState
+}
+
+/*
+ * withinLeash indicates if pt lies within the leash distance of the zombie
+ * anchor, which is always the case if the leash is disabled
+ */
+func (z *Zombie) withinLeash(pt d2.Vec2) bool {
+	leash := z.g.cfg.ZombieLeash
+	return leash <= 0 || pt.Sub(z.anchor).Len() <= leash
+}
+
+/*
+ * leashed indicates if the zombie has chased its target for too long, or too
+ * far from its anchor, and should give up
+ */
+func (z *Zombie) leashed() bool {
+	maxChase := time.Duration(z.g.cfg.ZombieChaseTime) * time.Second
+	return !z.withinLeash(z.Pos) || (maxChase > 0 && z.chaseTime > maxChase)
+}
+
+/*
+ * giveUp stops the chase and requests a path back to the anchor
+ */
+func (z *Zombie) giveUp() {
+	z.target = nil
+	z.chaseTime = 0
+	z.SetPath(nil)
+	z.searching = true
+	z.g.Pathfinder().Request(z.Pos, z.anchor, func(path Path, found bool) {
+		z.searching = false
+		if z.g.State().Entity(z.id) != z || z.curState != returningState {
+			return
+		}
+		if found {
+			z.SetPath(path)
+		}
+	})
+}
+
+/*
+ * goBack walks the zombie back to its anchor. It ignores the players until
+ * there, then starts looking for targets within the leash distance.
+ */
+func (z *Zombie) goBack(dt time.Duration) (state int) {
+	state = z.curState
+	if z.searching {
+		// wait for the path search to complete
+		return
+	}
+	if z.HasReachedDestination() {
+		return lookingState
+	}
+
+	z.Speed = z.walkSpeed
+	moved, _ := z.Movable.MoveOrSlide(z.world, z, dt, isZombieObstacle)
+	if !moved {
+		// blocked on the way back, wait here
+		return lookingState
+	}
+	z.world.UpdateEntity(z)
 	return
 }
 
 func (z *Zombie) walk(dt time.Duration) (state int) {
 	state = z.curState
 
-	dist := z.target.Position().Sub(z.Pos).Len()
-	if dist < attackDistance {
+	if z.inAttackRange(z.target) {
 		state = attackingState
 		return
 	}
 
+	z.pathAge += dt
 	if z.timeAcc >= zombieLookingInterval {
 		z.timeAcc -= zombieLookingInterval
-		state = lookingState
-		return
+		if z.shouldRepath() {
+			state = lookingState
+			return
+		}
 	}
 
 	z.Speed = z.walkSpeed
@@ -116,21 +492,57 @@ func (z *Zombie) walk(dt time.Duration) (state int) {
 	return
 }
 
+/*
+ * shouldRepath indicates if the walking zombie should look for its target
+ * again, and search a new path.
+ *
+ * That's only the case if no path was searched toward its target, if the
+ * target moved further than the repath distance since the path was found, if
+ * the buildings changed, possibly blocking the path, or if a better target
+ * showed up. In any case, there must be at least
+ * the repath interval between 2 searches.
+ */
+func (z *Zombie) shouldRepath() bool {
+	cfg := z.g.cfg
+	if z.pathAge < time.Duration(cfg.RepathInterval)*time.Millisecond {
+		return false
+	}
+	if z.pathGoal == nil || z.target.Position().Sub(z.pathGoal).Len() > cfg.RepathDistance ||
+		z.world.navVersion(false) != z.pathNav {
+		return true
+	}
+	return z.findTarget() != z.target
+}
+
 func (z *Zombie) attack(dt time.Duration) (state int) {
 	state = z.curState
 
-	if z.target.Position().Sub(z.Pos).Len() > attackDistance {
+	if !z.inAttackRange(z.target) {
+		// the blow being prepared, if any, misses
 		state = walkingState
 		return
 	}
-
-	if z.timeAcc >= zombieDamageInterval {
-		z.timeAcc -= zombieDamageInterval
-		if z.target.DealDamage(float32(z.combatPower)) {
-			state = lookingState
+	z.FaceTowards(z.target.Position())
+
+	switch z.phase {
+	case actions.AttackWindUp:
+		if z.timeAcc >= zombieWindUpDuration && !z.combat.Ready() {
+			// the blow is held until the end of the attack cooldown
+			z.timeAcc = zombieWindUpDuration
+		} else if z.timeAcc >= zombieWindUpDuration {
+			// hit frame, the blow lands
+			z.timeAcc -= zombieWindUpDuration
+			z.phase = actions.AttackRecovery
+			if dealHit(z.world, z, z.target, z.combat, MeleeDamage) {
+				state = lookingState
+			}
+		}
+	case actions.AttackRecovery:
+		if z.timeAcc >= zombieRecoveryTime {
+			z.timeAcc -= zombieRecoveryTime
+			z.phase = actions.AttackWindUp
 		}
 	}
-
 	return
 }
 
@@ -139,61 +551,157 @@ func (z *Zombie) attack(dt time.Duration) (state int) {
  *
  * It returns the next zombie state, in order to resolve the possible collision
  * If -1 is returned, there was no collision and the zombie should go
- * ahead with its current action
+ * ahead with its current action.
+ *
+ * A zombie blocked by an obstacle it isn't after (a building dropped on its
+ * path, another zombie, etc.) nudges around it for a few ticks rather than
+ * stalling, then looks for a new path.
  */
 func (z *Zombie) moveOrCollide(dt time.Duration) (state int) {
-	//func (z *Zombie) moveOrCollide(dt time.Duration) (hasCollided bool) {
-	// check if moving would create a collision
-	nextPos := z.Movable.ComputeMove(z.Pos, dt)
-	nextBB := d2.RectFromCircle(nextPos, 0.5)
-	colliding := z.world.AABBSpatialQuery(nextBB)
-
-	var wouldCollide bool
-	colliding.Each(func(e Entity) bool {
+	if z.steerLeft > 0 {
+		z.steerLeft -= dt
+		if !z.nudge(dt) || z.steerLeft <= 0 {
+			// done nudging, find a path from here
+			z.steerLeft = 0
+			return lookingState
+		}
+		return -1
+	}
 
-		if e == z {
-			// it's just me... pass
-			return true
+	moved, obstacle := z.Movable.MoveOrSlide(z.world, z, dt, isZombieObstacle)
+	if moved {
+		z.world.UpdateEntity(z)
+	}
+	switch {
+	case obstacle != nil && (obstacle == z.target || isAttackable(obstacle)):
+		// what? it's a player or the building we're after! let's destroy it
+		// change target, in case we were following somebody else
+		if obstacle != z.target {
+			z.target, z.pathGoal = obstacle, nil
 		}
-		if _, ok := e.(*Player); ok {
-			// what? it's a player! let's kill him
-			// change target, in case we were following somebody else
-			state = attackingState
-			z.target = e
-			// we are colliding and the current framework allows us to 'resolve'
-			// one collision only so no need to check further
-			return false
+		return attackingState
+	case !moved:
+		if z.startSteering(obstacle, dt) {
+			return -1
 		}
-		state = lookingState
-		wouldCollide = true
-		return true
-	})
+		// completely blocked, look for another path
+		return lookingState
+	}
+	return -1
+}
 
-	if !wouldCollide {
-		if z.Movable.Move(dt) {
-			z.world.UpdateEntity(z)
+/*
+ * startSteering starts nudging the zombie sideways, away from the obstacle
+ * blocking its way. It returns false if the zombie can't move sideways.
+ */
+func (z *Zombie) startSteering(obstacle Entity, dt time.Duration) bool {
+	dir := z.ComputeMove(z.Pos, dt).Sub(z.Pos)
+	if dir.Len() < 1e-6 {
+		return false
+	}
+	dir.Normalize()
+	left, right := d2.Vec2{-dir[1], dir[0]}, d2.Vec2{dir[1], -dir[0]}
+	sides := []d2.Vec2{left, right}
+	if obstacle != nil && obstacle.Position().Sub(z.Pos).Dot(left) > 0 {
+		// the obstacle lies on the left, try the right first
+		sides[0], sides[1] = right, left
+	}
+	for _, side := range sides {
+		if z.steer = side; z.nudge(dt) {
+			z.steerLeft = steerDuration
+			return true
 		}
-		state = -1
 	}
-	return
+	return false
+}
+
+/*
+ * nudge moves the zombie in its steering direction, it returns false if the
+ * way is blocked
+ */
+func (z *Zombie) nudge(dt time.Duration) bool {
+	pos := z.Pos.Add(z.steer.Scale(z.effectiveSpeed() * float32(dt.Seconds())))
+	if _, free := z.canMoveTo(z.world, z, pos, isZombieObstacle); !free {
+		return false
+	}
+	z.moveTo(pos)
+	z.world.UpdateEntity(z)
+	return true
+}
+
+/*
+ * die makes the zombie lie dying, which leaves the clients the time to
+ * animate its death. A dying zombie stays in the game state, but is detached
+ * from the world, so that it doesn't collide and can't be hit anymore. It's
+ * removed once the dying time has elapsed, or at once if it's 0.
+ */
+func (z *Zombie) die() {
+	if z.g.cfg.ZombieDyingTime == 0 {
+		z.g.State().RemoveEntity(z.id)
+		return
+	}
+	z.curState = dyingState
+	z.timeAcc = 0
+	z.phase = actions.AttackWindUp
+	z.target = nil
+	z.SetPath(nil)
+	z.world.DetachEntity(z)
+}
+
+/*
+ * decay removes the dying zombie once the dying time has elapsed
+ */
+func (z *Zombie) decay(dt time.Duration) {
+	if z.timeAcc += dt; z.timeAcc < time.Duration(z.g.cfg.ZombieDyingTime)*time.Millisecond {
+		return
+	}
+	if gs := z.g.State(); gs.Entity(z.id) == z {
+		gs.RemoveEntity(z.id)
+	}
 }
 
 func (z *Zombie) Update(dt time.Duration) {
+	if z.curState == dyingState {
+		z.decay(dt)
+		return
+	}
+	z.modifiers.Tick(dt)
+	z.combat.Tick(dt)
+	if z.stagger.Tick(dt) {
+		// staggered, the blow being prepared is lost
+		z.phase = actions.AttackWindUp
+		z.timeAcc = 0
+		return
+	}
 	z.timeAcc += dt
 
-	// TODO: check target entity existance; fallback to lookingState in case it
-	// doesn't
+	chasing := z.curState == walkingState || z.curState == attackingState
+	if chasing && z.g.State().Entity(z.target.Id()) != z.target {
+		// the target doesn't exist anymore (killed, destroyed, etc.)
+		z.curState = lookingState
+		z.timeAcc = 0
+	} else if chasing {
+		if z.chaseTime += dt; z.leashed() {
+			z.curState = returningState
+			z.timeAcc = 0
+			z.phase = actions.AttackWindUp
+			z.giveUp()
+		}
+	}
 
 	stateMap := map[int]func(time.Duration) int{
 		lookingState:   z.look,
 		walkingState:   z.walk,
 		attackingState: z.attack,
+		returningState: z.goBack,
+		wanderingState: z.wander,
 	}
 
 	nextState := stateMap[z.curState](dt)
 	if nextState != z.curState {
 		z.timeAcc = 0
 		z.curState = nextState
+		z.phase = actions.AttackWindUp
 	}
 }
 
@@ -205,60 +713,165 @@ func (z *Zombie) Type() EntityType {
 	return ZombieEntity
 }
 
+func (z *Zombie) Faction() Faction {
+	return ZombieFaction
+}
+
+func (z *Zombie) hitPoints() float32 {
+	return z.health.Cur
+}
+
+func (z *Zombie) knockbackImmune() bool {
+	return z.behavior == ZombieTank
+}
+
 func (z *Zombie) State() EntityState {
-	// first, compile the data depending on current action
-	var actionData interface{} = actions.Idle{}
-	var actionType actions.Type = actions.IdleId
+	// first, compile the action data depending on current state
+	action := messages.NewAction(actions.IdleId)
 
 	switch z.curState {
 	case attackingState:
-		actionData = actions.Attack{
+		action = messages.NewAttackAction(actions.Attack{
 			TargetID: z.target.Id(),
-		}
-		actionType = actions.AttackId
-
-	case lookingState:
-		fallthrough
+			Phase:    z.phase,
+		})
 
-	case walkingState:
+	case lookingState, walkingState, returningState, wanderingState:
 		if !z.Movable.HasReachedDestination() {
-			moveActionData := actions.Move{
-				Speed: z.Speed,
-			}
-			actionType = actions.MoveId
-			actionData = moveActionData
+			action = messages.NewMoveAction(z.moveAction(z.g.cfg.ZombieWaypoints))
 		}
+
+	case dyingState:
+		action = messages.NewAction(actions.DieId)
 	}
 
+	staggered := z.stagger.Staggered()
+	xvel, yvel := z.velocityHint(z.g.cfg.SendVelocities, action.Type == actions.MoveId && !staggered)
 	return MobileEntityState{
 		Type:         ZombieEntity,
 		Xpos:         z.Pos[0],
 		Ypos:         z.Pos[1],
-		CurHitPoints: uint16(z.curHP),
-		ActionType:   actionType,
-		Action:       actionData,
+		CurHitPoints: uint16(z.health.Cur),
+		Heading:      z.Heading,
+		Xvel:         xvel,
+		Yvel:         yvel,
+		Staggered:    staggered,
+		Action:       action,
 	}
 }
 
-func (z *Zombie) findTarget() (Entity, float32) {
-	ent, dist := z.g.State().NearestEntity(
-		z.Pos,
-		func(e Entity) bool {
-			return e.Type() != ZombieEntity
-		},
-	)
-	return ent, dist
+/*
+ * findTarget returns the preferred target of the zombie, or nil
+ */
+func (z *Zombie) findTarget() Entity {
+	if targets := z.findTargets(); len(targets) > 0 {
+		return targets[0]
+	}
+	return nil
 }
 
-func (z *Zombie) DealDamage(damage float32) (dead bool) {
-	if damage >= z.curHP {
-		z.curHP = 0
+/*
+ * findTargets returns the best target of each kind, player and building, the
+ * preferred one first. The targets are rated with the scoring function of the
+ * ZombieTargets configuration.
+ *
+ * Players are targeted wherever they are, buildings only in the zombie
+ * surroundings, and neither beyond its leash. Walls and protected players
+ * are never targeted.
+ */
+func (z *Zombie) findTargets() []Entity {
+	gs := z.g.State()
+	score := targetScores[z.g.cfg.ZombieTargets]
+	isTarget := func(e Entity) bool {
+		if _, ok := e.(Building); ok {
+			_, wall := e.(*Wall)
+			return !wall && e.Position().Sub(z.Pos).Len() <= buildingSearchRadius &&
+				z.withinLeash(e.Position())
+		}
+		// entity types overlap between players, buildings and objects, so
+		// we rely on factions to only target players
+		return gs.Hostile(z.Faction(), e.Faction()) && !isProtected(e) &&
+			z.withinLeash(e.Position())
+	}
+	var best [2]*targetCandidate
+	for _, ent := range gs.entitiesInRadius(z.Pos, 0, isTarget) {
+		kind := playerTarget
+		if _, ok := ent.e.(Building); ok {
+			kind = buildingTarget
+		}
+		c := &targetCandidate{e: ent.e, dist: ent.d}
+		c.priority, c.score = score(ent.e, kind, ent.d)
+		if best[kind] == nil || c.better(best[kind]) {
+			best[kind] = c
+		}
+	}
+
+	first, second := best[playerTarget], best[buildingTarget]
+	if first == nil || second != nil && second.better(first) {
+		first, second = second, first
+	}
+	var targets []Entity
+	for _, c := range []*targetCandidate{first, second} {
+		if c != nil {
+			targets = append(targets, c.e)
+		}
+	}
+	return targets
+}
+
+/*
+ * inAttackRange indicates if the zombie is close enough to attack an entity
+ */
+func (z *Zombie) inAttackRange(e Entity) bool {
+	if e.Position().Sub(z.Pos).Len() < attackDistance {
+		return true
+	}
+	// big entities can be out of attack distance, but still at reach
+	reach := d2.RectFromCircle(z.Pos, z.Radius+attackReach)
+	return reach.Overlaps(e.Rectangle())
+}
+
+/*
+ * setZombieBehavior sets the behavior of a zombie variant, from its entity
+ * data
+ */
+func setZombieBehavior(e Entity, data *EntityData) {
+	if z, ok := e.(*Zombie); ok {
+		z.behavior = data.Behavior
+		z.alertDist = data.AlertRadius
+	}
+}
+
+/*
+ * isZombieObstacle indicates if an entity blocks the way of the zombies
+ */
+func isZombieObstacle(e Entity) bool {
+	switch e.(type) {
+	case *Projectile, *Item:
+		return false
+	}
+	return true
+}
+
+/*
+ * isAttackable indicates if an entity is attacked by the zombies bumping into
+ * it. Buildings are only attacked when targeted, otherwise zombies go around,
+ * as they do around protected players.
+ */
+func isAttackable(e Entity) bool {
+	_, ok := e.(*Player)
+	return ok && !isProtected(e)
+}
+
+func (z *Zombie) DealDamage(damage float32, typ DamageType) (dead bool) {
+	if z.curState == dyingState {
+		// already dead
+		return true
+	}
+	if dead = z.health.Damage(z.modifiers.takenDamage(z.resists.Apply(typ, damage))); dead {
 		z.g.PostEvent(events.NewEvent(
 			events.ZombieDeathId,
 			events.ZombieDeath{Id: z.id}))
-		dead = true
-	} else {
-		z.curHP -= damage
 	}
 	return
 }
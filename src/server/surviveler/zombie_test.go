@@ -0,0 +1,554 @@
+package surviveler
+
+import (
+	"server/actions"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+/*
+ * newAttackingZombie returns a zombie attacking a player standing next to it
+ */
+func newAttackingZombie(t *testing.T) (*Game, *Zombie, *Player) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{2.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	z.target, z.curState = p, attackingState
+	return g, z, p
+}
+
+func attackPhase(z *Zombie) actions.AttackPhase {
+	return z.State().(MobileEntityState).Action.Attack.Phase
+}
+
+func TestZombie_AttackHitFrame(t *testing.T) {
+	g, z, p := newAttackingZombie(t)
+	hp := p.health.Cur
+
+	// no damage during the wind-up
+	for elapsed := time.Duration(0); elapsed+50*time.Millisecond < zombieWindUpDuration; elapsed += 50 * time.Millisecond {
+		tick(g, 50*time.Millisecond)
+		if p.health.Cur != hp {
+			t.Fatalf("damage dealt %v into the wind-up", elapsed)
+		}
+		if phase := attackPhase(z); phase != actions.AttackWindUp {
+			t.Fatalf("phase = %v during the wind-up", phase)
+		}
+	}
+
+	// the blow lands at the hit frame
+	tick(g, 50*time.Millisecond)
+	if want := hp - float32(z.combat.Power); p.health.Cur != want {
+		t.Fatalf("player hp = %v after the wind-up, want %v", p.health.Cur, want)
+	}
+	if phase := attackPhase(z); phase != actions.AttackRecovery {
+		t.Errorf("phase = %v after the hit frame, want recovery", phase)
+	}
+
+	// then a new wind-up starts, the next blow lands a whole cycle later
+	hp = p.health.Cur
+	tick(g, zombieRecoveryTime)
+	if phase := attackPhase(z); phase != actions.AttackWindUp || p.health.Cur != hp {
+		t.Errorf("phase = %v, hp = %v after the recovery, want a new wind-up", phase, p.health.Cur)
+	}
+	tick(g, zombieWindUpDuration)
+	if p.health.Cur != hp-float32(z.combat.Power) {
+		t.Errorf("player hp = %v, want a second blow to land", p.health.Cur)
+	}
+}
+
+func TestZombie_AttackCooldownAndVariance(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	g.cfg.AttackCooldown = 1000
+	g.cfg.DamageVariance = 0.5
+	p := addTestPlayer(g, TankEntity, d2.Vec2{2.5, 2.5})
+	p.health.Total, p.health.Cur = 1e6, 1e6
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	z.combat.Knockback, z.combat.Stagger = 0, 0
+	z.target, z.curState = p, attackingState
+
+	const dt = 50 * time.Millisecond
+	power := float32(z.combat.Power)
+	var (
+		hits    []time.Duration
+		damages = make(map[float32]bool)
+	)
+	for elapsed := dt; elapsed <= 10*time.Second; elapsed += dt {
+		hp := p.health.Cur
+		tick(g, dt)
+		if dmg := hp - p.health.Cur; dmg > 0 {
+			if dmg < power*0.5-1e-3 || dmg > power*1.5+1e-3 {
+				t.Errorf("hit dealt %v damage, want within [%v, %v]", dmg, power*0.5, power*1.5)
+			}
+			hits = append(hits, elapsed)
+			damages[dmg] = true
+		}
+	}
+
+	// the blows land once per cooldown, rather than once per attack cycle
+	if len(hits) < 9 || len(hits) > 10 {
+		t.Fatalf("%d hits in 10s, want 9 or 10 with a 1s cooldown", len(hits))
+	}
+	for i := 1; i < len(hits); i++ {
+		if d := hits[i] - hits[i-1]; d < time.Second {
+			t.Errorf("hits %d and %d landed %v apart, within the cooldown", i-1, i, d)
+		}
+	}
+	if len(damages) < 2 {
+		t.Errorf("every hit dealt the same damage, want them to vary")
+	}
+}
+
+func TestZombie_AttackInterrupted(t *testing.T) {
+	g, z, p := newAttackingZombie(t)
+	hp := p.health.Cur
+
+	// the player steps out of reach during the wind-up
+	tick(g, zombieWindUpDuration/2)
+	teleport(g, p, d2.Vec2{6.5, 2.5})
+	tick(g, zombieWindUpDuration)
+	if p.health.Cur != hp {
+		t.Errorf("player hp = %v, the blow should have been cancelled", p.health.Cur)
+	}
+	if z.curState == attackingState {
+		t.Errorf("zombie still attacking a player out of reach")
+	}
+
+	// killing the zombie before the hit frame spares the player
+	g, z, p = newAttackingZombie(t)
+	tick(g, zombieWindUpDuration/2)
+	z.DealDamage(float32(z.health.Cur), MeleeDamage)
+	tick(g, zombieWindUpDuration)
+	if p.health.Cur != hp {
+		t.Errorf("player hp = %v, dead zombie's blow landed", p.health.Cur)
+	}
+}
+
+func TestZombie_Dying(t *testing.T) {
+	g, z, p := newAttackingZombie(t)
+	g.cfg.ZombieDyingTime = 500
+	hp := p.health.Cur
+	z.DealDamage(float32(z.health.Cur), MeleeDamage)
+	tick(g, 10*time.Millisecond)
+
+	// the killed zombie is still in the game state, reporting its death
+	if g.state.Entity(z.Id()) != z || z.curState != dyingState {
+		t.Fatalf("killed zombie should be dying, state = %v", z.curState)
+	}
+	if at := z.State().(MobileEntityState).Action.Type; at != actions.DieId {
+		t.Errorf("dying zombie action = %v, want %v", at, actions.DieId)
+	}
+	if n := g.state.World().AABBSpatialQuery(z.Rectangle()).Len(); n != 0 {
+		t.Errorf("dying zombie can still be collided with, %d entities found at its position", n)
+	}
+	if dead := z.DealDamage(1, MeleeDamage); !dead {
+		t.Errorf("dying zombie can still be damaged")
+	}
+
+	// it doesn't act anymore
+	pos := d2.Vec2{z.Pos[0], z.Pos[1]}
+	for i := 0; i < 4; i++ {
+		tick(g, 100*time.Millisecond)
+	}
+	if p.health.Cur != hp || !z.Pos.Approx(pos) {
+		t.Errorf("dying zombie moved to %v or hurt the player, hp = %v", z.Pos, p.health.Cur)
+	}
+	if g.state.Entity(z.Id()) != z {
+		t.Fatalf("zombie removed before the end of the dying time")
+	}
+
+	// then it's removed
+	tick(g, 100*time.Millisecond)
+	if g.state.Entity(z.Id()) != nil {
+		t.Errorf("zombie still there after the dying time")
+	}
+
+	// or at once, without dying time
+	g, z, _ = newAttackingZombie(t)
+	g.cfg.ZombieDyingTime = 0
+	z.DealDamage(float32(z.health.Cur), MeleeDamage)
+	tick(g, 10*time.Millisecond)
+	if g.state.Entity(z.Id()) != nil {
+		t.Errorf("zombie still there without dying time")
+	}
+}
+
+func TestZombie_HitKnockbackAndStagger(t *testing.T) {
+	g, z, p := newAttackingZombie(t)
+	z.combat.Knockback, z.combat.Stagger = 1, 500*time.Millisecond
+	org, hp := p.Pos, p.health.Cur
+
+	// the player is pushed away from the zombie, and staggered
+	for i := 0; i < 100 && p.health.Cur == hp; i++ {
+		tick(g, 10*time.Millisecond)
+	}
+	if moved := p.Pos.Sub(org); !moved.Approx(d2.Vec2{1, 0}) {
+		t.Errorf("player knocked back by %v, want %v", moved, d2.Vec2{1, 0})
+	}
+	if !p.State().(PlayerState).Staggered {
+		t.Errorf("player state should report the stagger")
+	}
+
+	// a staggered player can't act
+	org = p.Pos
+	p.Move(Path{d2.Vec2{6.5, 2.5}})
+	for i := 0; i < 4; i++ {
+		tick(g, 100*time.Millisecond)
+	}
+	if !p.Pos.Approx(org) {
+		t.Errorf("staggered player moved from %v to %v", org, p.Pos)
+	}
+	for i := 0; i < 2; i++ {
+		tick(g, 100*time.Millisecond)
+	}
+	if p.Pos.Approx(org) || p.State().(PlayerState).Staggered {
+		t.Errorf("player still staggered after the stagger time")
+	}
+}
+
+func TestZombie_KnockbackStoppedByWall(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{6.5, 2.5})
+	z.target, z.curState = p, attackingState
+	z.combat.Knockback = 3
+
+	tick(g, zombieWindUpDuration)
+	if p.Pos[0] < 7.5 || p.Pos[0] >= 8 {
+		t.Errorf("player knocked back at %v, want it stopped by the wall", p.Pos)
+	}
+}
+
+func TestZombie_KnockbackStoppedByMapEdge(t *testing.T) {
+	field := []string{
+		".........",
+		".........",
+		".........",
+		".........",
+		".........",
+	}
+	for _, border := range []float32{0, 1} {
+		g := newTestGame(t, field...)
+		world := g.state.World()
+		world.SetBorder(border)
+		p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+		z := addTestZombie(g, d2.Vec2{6.5, 2.5})
+		z.target, z.curState = p, attackingState
+		z.combat.Knockback = 3
+
+		tick(g, zombieWindUpDuration)
+		if tile, ok := world.TileAtWorldVec(p.Pos); !ok || !tile.IsWalkable() {
+			t.Errorf("border %v: player knocked back at %v, not on a walkable tile", border, p.Pos)
+		}
+		if !world.Contains(p.Rectangle()) {
+			t.Errorf("border %v: player knocked back at %v, sticking out of the map", border, p.Pos)
+		}
+		if p.Pos[0] <= 7.5 {
+			t.Errorf("border %v: player at %v, not knocked back at all", border, p.Pos)
+		}
+	}
+
+	// nothing is built on the border either
+	g := newTestGame(t, field...)
+	g.state.World().SetBorder(1)
+	if _, err := g.state.checkPlacement(d2.Vec2{8.5, 2.5}, nil); err != errBuildNotWalkable {
+		t.Errorf("checkPlacement() on the border error = %v, want %v", err, errBuildNotWalkable)
+	}
+}
+
+func TestZombie_Leash(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	g.cfg.ZombieLeash = 5
+	anchor := d2.Vec2{2.5, 2.5}
+	z := addTestZombie(g, anchor)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+
+	// the player, within the leash, gets chased
+	far := float32(zombieWanderRadius + 0.2)
+	for i := 0; i < 1000 && z.Pos.Sub(anchor).Len() < far; i++ {
+		tick(g, 10*time.Millisecond)
+	}
+	if z.target != p || z.Pos.Sub(anchor).Len() < far {
+		t.Fatalf("zombie at %v, target = %v, want it to chase the player", z.Pos, z.target)
+	}
+
+	// the player runs out of the leash, the zombie gives up
+	teleport(g, p, d2.Vec2{15.5, 2.5})
+	var gaveUp bool
+	for i := 0; i < 1000 && !gaveUp; i++ {
+		tick(g, 10*time.Millisecond)
+		gaveUp = z.curState == returningState
+		if dist := z.Pos.Sub(anchor).Len(); dist > g.cfg.ZombieLeash+0.1 {
+			t.Fatalf("zombie %v away from its anchor, beyond the leash", dist)
+		}
+	}
+	if !gaveUp {
+		t.Fatalf("zombie at %v never gave up the chase", z.Pos)
+	}
+
+	// it walks back to its anchor, then ignores the player out of the leash
+	for i := 0; i < 1000 && z.curState == returningState; i++ {
+		tick(g, 10*time.Millisecond)
+	}
+	if !z.Pos.Approx(anchor) {
+		t.Errorf("zombie back at %v, want its anchor %v", z.Pos, anchor)
+	}
+	for i := 0; i < 500; i++ {
+		tick(g, 10*time.Millisecond)
+		if z.curState == walkingState || z.curState == attackingState {
+			t.Fatalf("zombie chases %v out of the leash", z.target)
+		}
+		if dist := z.Pos.Sub(anchor).Len(); dist > zombieWanderRadius+0.1 {
+			t.Fatalf("zombie wandered %v away from its anchor", dist)
+		}
+	}
+}
+
+func TestZombie_ChaseTime(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	g.cfg.ZombieChaseTime = 1
+	z := addTestZombie(g, d2.Vec2{2.5, 2.5})
+	p := addTestPlayer(g, TankEntity, d2.Vec2{15.5, 2.5})
+	p.Speed = 0
+
+	var chased time.Duration
+	for chased < 2*time.Second && z.curState != returningState {
+		tick(g, 10*time.Millisecond)
+		if z.curState == walkingState {
+			chased += 10 * time.Millisecond
+		}
+	}
+	if z.curState != returningState {
+		t.Fatalf("zombie still chasing after %v", chased)
+	}
+	// the ticks spent looking for a path on the way aren't counted here
+	if chased < 900*time.Millisecond || chased > time.Second {
+		t.Errorf("zombie gave up after %v of chase, want 1s", chased)
+	}
+}
+
+func TestZombie_TargetPriority(t *testing.T) {
+	tests := []struct {
+		policy   string
+		wounded  bool // the player has fewer hit points left than the barricade
+		building bool // the barricade is engaged, not the player
+	}{
+		{ZombieTargetsPlayers, false, false},
+		{ZombieTargetsBuildings, false, true},
+		{ZombieTargetsNearest, false, true},
+		{ZombieTargetsWeakest, false, true},
+		{ZombieTargetsWeakest, true, false},
+	}
+	for _, tt := range tests {
+		g := newTestGame(t, openRoom...)
+		g.cfg.ZombieTargets = tt.policy
+		z := addTestZombie(g, d2.Vec2{1.5, 1.5})
+		b := g.state.createBuilding(BarricadeBuilding, d2.Vec2{1.5, 3.5})
+		p := addTestPlayer(g, TankEntity, d2.Vec2{5.5, 1.5})
+		p.Speed = 0
+		if tt.wounded {
+			p.health.Cur = b.(damageable).hitPoints() / 2
+		}
+
+		var want Entity = p
+		if tt.building {
+			want = b
+		}
+		for i := 0; i < 100 && z.target == nil; i++ {
+			tick(g, 10*time.Millisecond)
+		}
+		if z.target != want {
+			t.Errorf("%s (wounded player: %v): zombie engaged %v, want %v", tt.policy, tt.wounded, z.target, want)
+		}
+	}
+}
+
+func TestZombie_Wander(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	org := d2.Vec2{8.5, 2.5}
+	z := addTestZombie(g, org)
+
+	// with nothing to chase, the zombie strolls around
+	var wandered bool
+	for i := 0; i < 500 && !wandered; i++ {
+		tick(g, 10*time.Millisecond)
+		wandered = z.curState == wanderingState && !z.Pos.Approx(org)
+	}
+	if !wandered {
+		t.Fatalf("targetless zombie still at %v, want it to wander", z.Pos)
+	}
+
+	// until a player shows up
+	p := addTestPlayer(g, TankEntity, d2.Vec2{15.5, 2.5})
+	for i := 0; i < 50 && z.target != p; i++ {
+		tick(g, 10*time.Millisecond)
+	}
+	if z.target != p || z.curState != walkingState {
+		t.Errorf("zombie state = %v, target = %v, want it to chase the player", z.curState, z.target)
+	}
+}
+
+func TestZombie_Target(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	var _ Targeter = z
+
+	// a looking zombie has no target
+	if id, ok := z.Target(); ok || id != InvalidID {
+		t.Errorf("looking zombie Target() = %v, %v, want none", id, ok)
+	}
+
+	// a chasing one reports the entity it's chasing
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+	for i := 0; i < 50 && z.curState != walkingState; i++ {
+		tick(g, 10*time.Millisecond)
+	}
+	if id, ok := z.Target(); !ok || id != p.Id() {
+		t.Errorf("chasing zombie Target() = %v, %v, want %v", id, ok, p.Id())
+	}
+
+	// nor has it once it stopped chasing
+	z.curState = lookingState
+	if id, ok := z.Target(); ok {
+		t.Errorf("looking zombie Target() = %v, %v, want none", id, ok)
+	}
+}
+
+func TestZombie_WanderReproducible(t *testing.T) {
+	positions := func() []d2.Vec2 {
+		g := newTestGame(t, longRoom...)
+		z := addTestZombie(g, d2.Vec2{8.5, 2.5})
+		var pos []d2.Vec2
+		for i := 0; i < 600; i++ {
+			tick(g, 10*time.Millisecond)
+			pos = append(pos, z.Pos)
+		}
+		return pos
+	}
+	first, second := positions(), positions()
+	for i := range first {
+		if first[i][0] != second[i][0] || first[i][1] != second[i][1] {
+			t.Fatalf("tick %d: zombie at %v then %v with the same seed", i, first[i], second[i])
+		}
+	}
+}
+
+func TestZombie_ScreamerAlert(t *testing.T) {
+	g := newTestGame(t, longRoom...)
+	g.cfg.ZombieLeash = 3
+	p := addTestPlayer(g, TankEntity, d2.Vec2{12.5, 2.5})
+	screamer := addTestZombie(g, d2.Vec2{10.5, 2.5})
+	setZombieBehavior(screamer, &EntityData{Behavior: ZombieScreamer, AlertRadius: 5})
+	// both leashed too far to chase the player on their own
+	near := addTestZombie(g, d2.Vec2{6.5, 2.5})
+	far := addTestZombie(g, d2.Vec2{1.5, 2.5})
+
+	for i := 0; i < 100 && near.target == nil; i++ {
+		tick(g, 10*time.Millisecond)
+	}
+	if screamer.target != p {
+		t.Fatalf("screamer target = %v, want the player", screamer.target)
+	}
+	if near.target != p {
+		t.Errorf("zombie within the alert radius target = %v, want the screamer target", near.target)
+	}
+	if far.target != nil || far.alert != nil {
+		t.Errorf("zombie out of the alert radius has been alerted")
+	}
+}
+
+func TestZombie_TankKnockbackImmune(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{2.5, 2.5})
+	p.combat.Knockback = 1
+	common := addTestZombie(g, d2.Vec2{3.5, 1.5})
+	tank := addTestZombie(g, d2.Vec2{3.5, 3.5})
+	setZombieBehavior(tank, &EntityData{Behavior: ZombieTank})
+
+	dealHit(g.state.world, p, common, p.combat, MeleeDamage)
+	if common.Pos.Approx(d2.Vec2{3.5, 1.5}) {
+		t.Errorf("common zombie hasn't been knocked back")
+	}
+	hp := tank.health.Cur
+	p.combat.Tick(time.Minute)
+	dealHit(g.state.world, p, tank, p.combat, MeleeDamage)
+	if !tank.Pos.Approx(d2.Vec2{3.5, 3.5}) {
+		t.Errorf("tank knocked back to %v", tank.Pos)
+	}
+	if tank.health.Cur >= hp {
+		t.Errorf("tank hasn't been hurt")
+	}
+}
+
+func TestZombie_Repath(t *testing.T) {
+	const dt = 50 * time.Millisecond
+	g := newTestGame(t, longRoom...)
+	g.cfg.RepathInterval = 500
+	p := addTestPlayer(g, TankEntity, d2.Vec2{16.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+	for i := 0; i < 10 && z.curState != walkingState; i++ {
+		tick(g, dt)
+	}
+	if z.curState != walkingState {
+		t.Fatalf("zombie state = %d, want it walking toward the player", z.curState)
+	}
+	pf := g.Pathfinder()
+
+	// the player barely moves, the zombie keeps its path
+	calls := pf.calls
+	for i := 1; i <= 20; i++ {
+		teleport(g, p, d2.Vec2{16.5, 2.5 + 0.04*float32(i)})
+		tick(g, dt)
+	}
+	if pf.calls != calls || z.curState != walkingState {
+		t.Errorf("zombie searched %d paths, state = %d, after the player barely moved",
+			pf.calls-calls, z.curState)
+	}
+
+	// the player runs away, the zombie searches a new path
+	teleport(g, p, d2.Vec2{14.5, 2.5})
+	for i := 0; i < 6; i++ {
+		tick(g, dt)
+	}
+	if pf.calls != calls+1 || z.curState != walkingState {
+		t.Fatalf("zombie searched %d paths, state = %d, after the player ran away, want 1",
+			pf.calls-calls, z.curState)
+	}
+
+	// but not sooner than the repath interval after the previous search
+	teleport(g, p, d2.Vec2{16.5, 2.5})
+	for i := 0; i < 8; i++ {
+		tick(g, dt)
+	}
+	if pf.calls != calls+1 {
+		t.Errorf("zombie searched a path before the repath interval")
+	}
+	for i := 0; i < 6; i++ {
+		tick(g, dt)
+	}
+	if pf.calls != calls+2 {
+		t.Errorf("zombie searched %d paths after the repath interval, want 1", pf.calls-calls-1)
+	}
+}
+
+func TestZombie_GoesAroundProtectedPlayer(t *testing.T) {
+	g := newTestGame(t, openRoom...)
+	p := addTestPlayer(g, TankEntity, d2.Vec2{7.5, 2.5})
+	protected := addTestPlayer(g, TankEntity, d2.Vec2{4.5, 2.5})
+	protected.protection.Start(time.Hour)
+	z := addTestZombie(g, d2.Vec2{1.5, 2.5})
+
+	// the protected player stands right in the way, the zombie bumps into it
+	// without attacking it
+	for i := 0; i < 400 && !(z.curState == attackingState && z.target == p); i++ {
+		tick(g, 50*time.Millisecond)
+		if z.target == protected {
+			t.Fatalf("zombie at %v targets the protected player", z.Position())
+		}
+	}
+	if z.curState != attackingState || z.target != p {
+		t.Errorf("zombie at %v should be attacking the player, got target %v", z.Position(), z.target)
+	}
+}
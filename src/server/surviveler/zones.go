@@ -0,0 +1,139 @@
+/*
+ * Surviveler package
+ * map zones and special tiles
+ */
+package surviveler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+)
+
+/*
+ * Effects of the map zones on the entities standing on them
+ */
+const (
+	ZoneHazard    = "hazard"     // hurts them
+	ZoneSlow      = "slow"       // slows them down
+	ZoneSpawnOnly = "spawn_only" // reserved to spawning, nothing can be built on it
+)
+
+/*
+ * TileFlags is a bit mask of the special effects of a tile
+ */
+type TileFlags uint8
+
+const (
+	TileHazard TileFlags = 1 << iota
+	TileSlow
+	TileSpawnOnly
+)
+
+/*
+ * TileEffects are the special effects of a tile, combining the effects of
+ * the map zones covering it
+ */
+type TileEffects struct {
+	Flags  TileFlags
+	Damage float32 // hit points lost per second by the entities standing on the tile
+	Speed  float32 // speed factor of the entities walking on the tile
+}
+
+/*
+ * Has indicates if the tile has the given effect. A tile without special
+ * effects has a nil TileEffects, that has none.
+ */
+func (e *TileEffects) Has(flag TileFlags) bool {
+	return e != nil && e.Flags&flag != 0
+}
+
+/*
+ * speed returns the speed factor of the entities walking on the tile
+ */
+func (e *TileEffects) speed() float32 {
+	if !e.Has(TileSlow) {
+		return 1
+	}
+	return e.Speed
+}
+
+/*
+ * newZoneEffects returns the effects a map zone has on its tiles, or an error
+ * if it's badly defined
+ */
+func newZoneEffects(z MapZone) (TileEffects, error) {
+	eff := TileEffects{Speed: 1}
+	switch z.Effect {
+	case ZoneHazard:
+		if z.Damage <= 0 {
+			return eff, fmt.Errorf("hazard zone '%s' must have a positive damage, got %v", z.Name, z.Damage)
+		}
+		eff.Flags, eff.Damage = TileHazard, z.Damage
+	case ZoneSlow:
+		if z.Speed <= 0 || z.Speed >= 1 {
+			return eff, fmt.Errorf("slow zone '%s' must have a speed in ]0, 1[, got %v", z.Name, z.Speed)
+		}
+		eff.Flags, eff.Speed = TileSlow, z.Speed
+	case ZoneSpawnOnly:
+		eff.Flags = TileSpawnOnly
+	default:
+		return eff, fmt.Errorf("zone '%s' has an unknown effect: '%s'", z.Name, z.Effect)
+	}
+	return eff, nil
+}
+
+/*
+ * LoadZones sets the effects of the map zones on the tiles whose center lies
+ * in them.
+ *
+ * The effects of overlapping zones add up: hazards hurt for the sum of their
+ * damages, and slow zones multiply their speed factors.
+ */
+func (w *World) LoadZones(zones []MapZone) error {
+	for _, z := range zones {
+		eff, err := newZoneEffects(z)
+		if err != nil {
+			return err
+		}
+		rect := d2.Rect(z.Rect[0][0], z.Rect[0][1], z.Rect[1][0], z.Rect[1][1])
+		for _, t := range w.IntersectingTiles(rect) {
+			if !rect.Contains(t.Rectangle().Center()) {
+				continue
+			}
+			if t.Effects == nil {
+				t.Effects = &TileEffects{Speed: 1}
+			}
+			t.Effects.Flags |= eff.Flags
+			t.Effects.Damage += eff.Damage
+			t.Effects.Speed *= eff.Speed
+		}
+	}
+	return nil
+}
+
+/*
+ * applyTileEffects applies the effects of the tiles on which the entities
+ * stand, during dt: the moving entities walk at the speed of their tile, and
+ * the ones on hazard tiles get hurt.
+ */
+func (gs *GameState) applyTileEffects(dt time.Duration) {
+	gs.forEachEntity(func(ent Entity) bool {
+		t, _ := gs.world.TileAtWorldVec(ent.Position())
+		var effects *TileEffects
+		if t != nil {
+			effects = t.Effects
+		}
+
+		var mv *Movable
+		if GetComponent(ent, &mv) {
+			mv.tileSpeed = effects.speed()
+		}
+		var h *Health
+		if effects.Has(TileHazard) && GetComponent(ent, &h) && h.Cur > 0 {
+			ent.DealDamage(effects.Damage*float32(dt.Seconds()), FireDamage)
+		}
+		return true
+	})
+}
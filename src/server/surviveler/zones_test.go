@@ -0,0 +1,147 @@
+package surviveler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aurelien-rainone/gogeo/f32/d2"
+	"github.com/aurelien-rainone/math32"
+)
+
+/*
+ * newZonesTestGame creates a game in the open room, with an acid pool on the
+ * west side, mud on the east side and a spawn area in the middle
+ */
+func newZonesTestGame(t *testing.T) *Game {
+	g := newTestGame(t, openRoom...)
+	err := g.state.world.LoadZones([]MapZone{
+		{Name: "acid", Effect: ZoneHazard, Rect: Rect2D{{1, 1}, {3, 4}}, Damage: 10},
+		{Name: "mud", Effect: ZoneSlow, Rect: Rect2D{{5, 1}, {8, 4}}, Speed: 0.5},
+		{Name: "deep mud", Effect: ZoneSlow, Rect: Rect2D{{7, 1}, {8, 4}}, Speed: 0.5},
+		{Name: "spawn", Effect: ZoneSpawnOnly, Rect: Rect2D{{4, 1}, {5, 4}}},
+	})
+	if err != nil {
+		t.Fatalf("LoadZones() error = %v", err)
+	}
+	return g
+}
+
+func TestWorld_LoadZones(t *testing.T) {
+	g := newZonesTestGame(t)
+	w := g.state.world
+
+	tests := []struct {
+		x, y   int
+		flags  TileFlags
+		damage float32
+		speed  float32
+	}{
+		{1, 1, TileHazard, 10, 1},
+		{2, 3, TileHazard, 10, 1},
+		{3, 2, 0, 0, 1},
+		{4, 2, TileSpawnOnly, 0, 1},
+		{5, 2, TileSlow, 0, 0.5},
+		{7, 2, TileSlow, 0, 0.25},
+	}
+	for _, tt := range tests {
+		eff := w.Tile(tt.x, tt.y).Effects
+		if tt.flags == 0 {
+			if eff != nil {
+				t.Errorf("tile (%d, %d) effects = %+v, want none", tt.x, tt.y, *eff)
+			}
+			continue
+		}
+		if eff == nil || eff.Flags != tt.flags || eff.Damage != tt.damage || eff.Speed != tt.speed {
+			t.Errorf("tile (%d, %d) effects = %+v, want flags %d, damage %v, speed %v",
+				tt.x, tt.y, eff, tt.flags, tt.damage, tt.speed)
+		}
+	}
+
+	// rooms share the effects of the map
+	if eff := w.emptyCopy().Tile(1, 1).Effects; !eff.Has(TileHazard) {
+		t.Errorf("copied world tile effects = %+v, want a hazard", eff)
+	}
+
+	errs := []struct {
+		zone MapZone
+		want string
+	}{
+		{MapZone{Name: "z", Effect: "lava"}, "unknown effect: 'lava'"},
+		{MapZone{Name: "z", Effect: ZoneHazard}, "must have a positive damage"},
+		{MapZone{Name: "z", Effect: ZoneSlow, Speed: 1.5}, "must have a speed in ]0, 1["},
+	}
+	for _, tt := range errs {
+		err := newTestWorld(t, 1, openRoom...).LoadZones([]MapZone{tt.zone})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("LoadZones(%+v) error = %v, want it to contain %q", tt.zone, err, tt.want)
+		}
+	}
+}
+
+func TestGameState_HazardTiles(t *testing.T) {
+	g := newZonesTestGame(t)
+	burnt := addTestPlayer(g, TankEntity, d2.Vec2{1.5, 2.5})
+	safe := addTestPlayer(g, TankEntity, d2.Vec2{3.5, 2.5})
+	z := addTestZombie(g, d2.Vec2{2.5, 1.5})
+
+	// 10 hit points per second, for half a second
+	for i := 0; i < 50; i++ {
+		g.state.applyTileEffects(10 * time.Millisecond)
+	}
+	if math32.Abs(burnt.health.Cur-95) > 1e-3 {
+		t.Errorf("player in the acid has %v HP, want 95", burnt.health.Cur)
+	}
+	if safe.health.Cur != safe.health.Total {
+		t.Errorf("player out of the acid has %v HP, want %v", safe.health.Cur, safe.health.Total)
+	}
+	if math32.Abs(z.health.Cur-45) > 1e-3 {
+		t.Errorf("zombie in the acid has %v HP, want 45", z.health.Cur)
+	}
+
+	// hurt over time until death
+	for i := 0; i < 5; i++ {
+		g.state.applyTileEffects(time.Second)
+	}
+	if z.health.Cur != 0 {
+		t.Errorf("zombie in the acid has %v HP, want 0", z.health.Cur)
+	}
+}
+
+func TestGameState_SlowTiles(t *testing.T) {
+	g := newZonesTestGame(t)
+	tests := []struct {
+		name  string
+		org   d2.Vec2
+		speed float32
+	}{
+		{"normal ground", d2.Vec2{1.5, 1.5}, 2},
+		{"mud", d2.Vec2{5.5, 2.5}, 1},
+		{"deep mud", d2.Vec2{7.5, 3.5}, 0.5},
+	}
+	for _, tt := range tests {
+		p := addTestPlayer(g, TankEntity, tt.org)
+		p.Move(Path{tt.org.Add(d2.Vec2{0, -0.9})})
+		g.logicTick(100 * time.Millisecond)
+
+		want := tt.speed * 0.1
+		if dist := p.Pos.Sub(tt.org).Len(); math32.Abs(dist-want) > 1e-4 {
+			t.Errorf("%s: player covered %v in 100ms, want %v", tt.name, dist, want)
+		}
+		if v := p.Velocity().Len(); math32.Abs(v-tt.speed) > 1e-4 {
+			t.Errorf("%s: player velocity = %v, want %v", tt.name, v, tt.speed)
+		}
+		g.state.RemoveEntity(p.Id())
+	}
+}
+
+func TestGameState_checkPlacementSpawnOnly(t *testing.T) {
+	g := newZonesTestGame(t)
+	builder := addTestPlayer(g, EngineerEntity, d2.Vec2{3.5, 3.5})
+	if _, err := g.state.checkPlacement(d2.Vec2{4.5, 1.5}, builder); err != errBuildSpawnOnly {
+		t.Errorf("checkPlacement() in the spawn area error = %v, want %v", err, errBuildSpawnOnly)
+	}
+	if _, err := g.state.checkPlacement(d2.Vec2{2.5, 1.5}, builder); err != nil {
+		t.Errorf("checkPlacement() in the acid error = %v, want nil", err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 var (
 	scale   = flag.Int("scale", 1, "map mesh scale factor")
 	pngfile = flag.String("png", "out.png", "output png file")
+	wldfile = flag.String("world", "", "also write the walls as a world file, for the map editor")
 	dbg     = flag.Bool("v", false, "verbose output")
 )
 
@@ -22,7 +23,7 @@ func showUsage() {
 	fmt.Println("wallmap - Rasterize the triangular faces of an OBJ file onto a PNG image")
 	fmt.Println()
 	fmt.Println("usage:")
-	fmt.Println("  wallmap -div INT -png FILE OBJFILE")
+	fmt.Println("  wallmap -div INT -png FILE [-world FILE] OBJFILE")
 	flag.PrintDefaults()
 }
 
@@ -92,6 +93,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *wldfile != "" {
+		fmt.Println("Creating", *wldfile)
+		wf, err := os.Create(*wldfile)
+		if err != nil {
+			fmt.Println("Can't create ", *wldfile, ": ", err)
+			os.Exit(1)
+		}
+		err = NewWorldFile(gr, float64(*scale)).Write(wf)
+		wf.Close()
+		if err != nil {
+			fmt.Println("Can't write ", *wldfile, ": ", err)
+			os.Exit(1)
+		}
+	}
+
 	fmt.Println("Resulting image details")
 
 	if *dbg {
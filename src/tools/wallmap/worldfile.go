@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"io"
+)
+
+// WorldFile is a world grid in the world file format of the server, that the
+// map editor reads and writes, and the server loads through the 'world'
+// resource of the map data (see surviveler.WorldFile for the format).
+//
+// wallmap only writes the walls, the special tiles and the spawn points are
+// left to the map editor.
+type WorldFile struct {
+	Version     int           `json:"version"`
+	GridScale   float64       `json:"grid_scale"`
+	Rows        []string      `json:"rows"`
+	SpawnPoints []interface{} `json:"spawn_points"`
+}
+
+// NewWorldFile returns the world file of a black and white wall map, black
+// pixels being walls, having scale tiles per world unit
+func NewWorldFile(img image.Image, scale float64) *WorldFile {
+	wf := &WorldFile{
+		Version:     1,
+		GridScale:   scale,
+		SpawnPoints: []interface{}{},
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := make([]byte, 0, b.Dx())
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// same test as the server loading the bitmap
+			if r, _, _, _ := img.At(x, y).RGBA(); r == 0 {
+				row = append(row, '#')
+			} else {
+				row = append(row, '.')
+			}
+		}
+		wf.Rows = append(wf.Rows, string(row))
+	}
+	return wf
+}
+
+// Write writes the world file as indented JSON
+func (wf *WorldFile) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(wf)
+}